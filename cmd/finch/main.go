@@ -28,6 +28,11 @@ func main() {
 	stdOut := os.Stdout
 	if err := xmain(logger, stdLib, fs, stdLib, mem, stdOut); err != nil {
 		errutil.HandleExitCoder(err)
+		// Fatal exits with 1, which is also the code of the errors that don't map to a more specific one.
+		if code := exitCodeOf(err); code != 1 {
+			logger.Error(err)
+			os.Exit(code)
+		}
 		logger.Fatal(err)
 	}
 }
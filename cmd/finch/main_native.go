@@ -25,6 +25,12 @@ import (
 	"github.com/runfinch/finch/pkg/version"
 )
 
+// exitCodeOf returns the code finch exits with when it fails with err, the VM commands that define more
+// specific codes don't exist on Linux.
+func exitCodeOf(error) int {
+	return 1
+}
+
 func xmain(logger flog.Logger,
 	_ path.FinchFinderDeps,
 	fs afero.Fs,
@@ -99,7 +105,7 @@ var newApp = func(
 	supportBundleBuilder := support.NewBundleBuilder(
 		logger,
 		fs,
-		support.NewBundleConfig(fp, fp.FinchDir()),
+		support.NewBundleConfig(fp, fp.FinchDir(), ""),
 		fp,
 		ecc,
 		ncc,
@@ -6,8 +6,11 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"os"
+	"time"
 
 	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
@@ -16,10 +19,12 @@ import (
 	"github.com/runfinch/finch/pkg/config"
 	"github.com/runfinch/finch/pkg/flog"
 	"github.com/runfinch/finch/pkg/fmemory"
+	"github.com/runfinch/finch/pkg/lima"
 	"github.com/runfinch/finch/pkg/lima/wrapper"
 	"github.com/runfinch/finch/pkg/path"
 	"github.com/runfinch/finch/pkg/support"
 	"github.com/runfinch/finch/pkg/system"
+	"github.com/runfinch/finch/pkg/telemetry"
 	"github.com/runfinch/finch/pkg/version"
 )
 
@@ -55,7 +60,33 @@ func xmain(logger flog.Logger,
 	if err != nil {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
+	instance, selects := instanceFlag(newApp(logger, fp, fs, fc, stdOut, home, finchRootPath, ecc), os.Args[1:])
+	if selects {
+		if err := selectInstance(fs, logger, fp.ContextsFilePath(finchRootPath), fc, instance); err != nil {
+			return err
+		}
+	}
+	if name := fc.InstanceName(); name != limaInstanceName {
+		limaInstanceName = name
+	}
+	if fc.Logging.Syslog != "" {
+		if err := flog.ForwardToSyslog(fc.Logging.Syslog, finchRootCmd); err != nil {
+			logger.Warnf("Could not forward the logs to syslog: %v", err)
+		}
+	}
+	if fc.Telemetry.Enabled {
+		lima.RegisterLifecycleListener(telemetry.NewRecorder(fs, fp.TelemetryBatchPath(finchRootPath), fc.Telemetry.Endpoint,
+			telemetryDriver(fc), logger))
+	}
 
+	ctx, cancel, err := contextWithDeadline(context.Background(), ffd.Env(envDeadline))
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	// The commands capture the instance they operate on when they're built, so the ones executed are built again
+	// once it's selected.
 	return newApp(
 		logger,
 		fp,
@@ -65,7 +96,24 @@ func xmain(logger flog.Logger,
 		home,
 		finchRootPath,
 		ecc,
-	).Execute()
+	).ExecuteContext(ctx)
+}
+
+// envDeadline is set by supervisors enforcing a global timeout to the time, in RFC 3339 format,
+// by which the command must be done.
+const envDeadline = "FINCH_DEADLINE"
+
+// contextWithDeadline returns a copy of ctx that expires at deadline, or ctx itself if deadline is empty.
+func contextWithDeadline(ctx context.Context, deadline string) (context.Context, context.CancelFunc, error) {
+	if deadline == "" {
+		return ctx, func() {}, nil
+	}
+	t, err := time.Parse(time.RFC3339, deadline)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse %s, it must be in RFC 3339 format: %w", envDeadline, err)
+	}
+	ctx, cancel := context.WithDeadline(ctx, t)
+	return ctx, cancel, nil
 }
 
 var newApp = func(
@@ -109,7 +157,7 @@ var newApp = func(
 	supportBundleBuilder := support.NewBundleBuilder(
 		logger,
 		fs,
-		support.NewBundleConfig(fp, finchRootPath),
+		support.NewBundleConfig(fp, finchRootPath, limaInstanceName),
 		fp,
 		ecc,
 		ncc,
@@ -124,6 +172,7 @@ var newApp = func(
 		newVersionCommand(ncc, logger, stdOut),
 		virtualMachineCommands(logger, fp, ncc, ecc, fs, fc, home, finchRootPath),
 		newSupportBundleCommand(logger, supportBundleBuilder, ncc),
+		newTelemetryCommand(logger, fs, fc, fp.TelemetryBatchPath(finchRootPath), stdOut),
 		newGenDocsCommand(rootCmd, logger, fs, system.NewStdLib()),
 	)
 
@@ -6,11 +6,13 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
 	"runtime"
 	"testing"
+	"time"
 
 	"gopkg.in/yaml.v3"
 
@@ -80,6 +82,7 @@ func TestXmain(t *testing.T) {
 				loadCfgDeps.EXPECT().NumCPU().Return(16)
 				// 12_884_901_888 == 12GiB
 				mem.EXPECT().TotalMemory().Return(uint64(12_884_901_888))
+				ffd.EXPECT().Env("FINCH_DEADLINE").Return("")
 			},
 		},
 		{
@@ -125,6 +128,7 @@ func TestXmain(t *testing.T) {
 				ffd.EXPECT().Executable().Return("/bin/path", nil)
 				ffd.EXPECT().EvalSymlinks("/bin/path").Return("/real/bin/path", nil)
 				ffd.EXPECT().FilePathJoin("/real/bin/path", "..", "..").Return("/real")
+				ffd.EXPECT().Env("FINCH_DEADLINE").Return("")
 			},
 		},
 		{
@@ -176,6 +180,49 @@ func TestXmain(t *testing.T) {
 	}
 }
 
+func TestContextWithDeadline(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name         string
+		deadline     string
+		wantDeadline time.Time
+		wantErr      string
+	}{
+		{
+			name: "should not set a deadline if there is none",
+		},
+		{
+			name:         "should set the deadline",
+			deadline:     "2030-01-02T15:04:05Z",
+			wantDeadline: time.Date(2030, 1, 2, 15, 4, 5, 0, time.UTC),
+		},
+		{
+			name:     "should fail if the deadline isn't in RFC 3339 format",
+			deadline: "in 5 minutes",
+			wantErr:  "failed to parse FINCH_DEADLINE, it must be in RFC 3339 format: ",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel, err := contextWithDeadline(context.Background(), tc.deadline)
+			if tc.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			defer cancel()
+			deadline, ok := ctx.Deadline()
+			assert.Equal(t, !tc.wantDeadline.IsZero(), ok)
+			assert.True(t, tc.wantDeadline.Equal(deadline))
+		})
+	}
+}
+
 func TestNewApp(t *testing.T) {
 	t.Parallel()
 
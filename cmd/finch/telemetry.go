@@ -0,0 +1,87 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/flog"
+	"github.com/runfinch/finch/pkg/telemetry"
+)
+
+func newTelemetryCommand(logger flog.Logger, fs afero.Fs, fc *config.Finch, batchPath string, stdout io.Writer) *cobra.Command {
+	telemetryCommand := &cobra.Command{
+		Use:   "telemetry",
+		Short: "Anonymized telemetry management",
+	}
+	telemetryCommand.AddCommand(
+		newTelemetryPreviewCommand(logger, fs, fc, batchPath, stdout),
+	)
+	return telemetryCommand
+}
+
+func newTelemetryPreviewCommand(logger flog.Logger, fs afero.Fs, fc *config.Finch, batchPath string, stdout io.Writer) *cobra.Command {
+	return &cobra.Command{
+		Use:   "preview",
+		Args:  cobra.NoArgs,
+		Short: "Print the telemetry events that will be sent with the next batch",
+		Long: "Prints the anonymized telemetry events recorded since the last batch was sent, exactly as they will be sent. " +
+			"Telemetry is only collected after opting in with telemetry.enabled in finch.yaml.",
+		RunE: newTelemetryPreviewAction(logger, fs, fc, batchPath, stdout).runAdapter,
+	}
+}
+
+type telemetryPreviewAction struct {
+	logger    flog.Logger
+	fs        afero.Fs
+	fc        *config.Finch
+	batchPath string
+	stdout    io.Writer
+}
+
+func newTelemetryPreviewAction(
+	logger flog.Logger,
+	fs afero.Fs,
+	fc *config.Finch,
+	batchPath string,
+	stdout io.Writer,
+) *telemetryPreviewAction {
+	return &telemetryPreviewAction{logger: logger, fs: fs, fc: fc, batchPath: batchPath, stdout: stdout}
+}
+
+func (tpa *telemetryPreviewAction) runAdapter(_ *cobra.Command, _ []string) error {
+	return tpa.run()
+}
+
+func (tpa *telemetryPreviewAction) run() error {
+	if !tpa.fc.Telemetry.Enabled {
+		tpa.logger.Info("Telemetry is disabled, set telemetry.enabled to true in finch.yaml to opt in")
+	}
+	events, err := telemetry.Pending(tpa.fs, tpa.batchPath)
+	if err != nil {
+		return err
+	}
+	payload := telemetry.Payload{Events: events}
+	if payload.Events == nil {
+		payload.Events = []telemetry.Event{}
+	}
+	enc := json.NewEncoder(tpa.stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(payload)
+}
+
+// telemetryDriver returns the type of the VM reported with the telemetry events.
+func telemetryDriver(fc *config.Finch) string {
+	if fc.VMType == nil {
+		return ""
+	}
+	return string(*fc.VMType)
+}
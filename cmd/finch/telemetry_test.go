@@ -0,0 +1,96 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"bytes"
+	"runtime"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/lima"
+	"github.com/runfinch/finch/pkg/mocks"
+	"github.com/runfinch/finch/pkg/telemetry"
+)
+
+func TestNewTelemetryCommand(t *testing.T) {
+	t.Parallel()
+
+	cmd := newTelemetryCommand(nil, nil, nil, "", nil)
+	assert.Equal(t, cmd.Name(), "telemetry")
+}
+
+func TestTelemetryPreviewAction_run(t *testing.T) {
+	t.Parallel()
+
+	batchPath := mockFinchPath.TelemetryBatchPath(mockFinchRootPath)
+
+	testCases := []struct {
+		name       string
+		enabled    bool
+		stops      int
+		wantStdout string
+		mockSvc    func(*mocks.Logger)
+	}{
+		{
+			name:    "should print the pending events",
+			enabled: true,
+			stops:   1,
+			wantStdout: `{
+  "events": [
+    {
+      "type": "stop",
+      "result": "success",
+      "durationBucket": "<10s",
+      "forced": false,
+      "os": "` + runtime.GOOS + `",
+      "driver": "vz"
+    }
+  ]
+}
+`,
+			mockSvc: func(_ *mocks.Logger) {},
+		},
+		{
+			name:    "should tell that telemetry is disabled",
+			enabled: false,
+			stops:   0,
+			wantStdout: `{
+  "events": []
+}
+`,
+			mockSvc: func(logger *mocks.Logger) {
+				logger.EXPECT().Info("Telemetry is disabled, set telemetry.enabled to true in finch.yaml to opt in")
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			logger := mocks.NewLogger(ctrl)
+			tc.mockSvc(logger)
+			fs := afero.NewMemMapFs()
+			r := telemetry.NewRecorder(fs, batchPath, "", "vz", logger)
+			for range tc.stops {
+				r.OnStopComplete(lima.StopResult{Instance: limaInstanceName, Stopped: true})
+			}
+
+			fc := &config.Finch{}
+			fc.Telemetry.Enabled = tc.enabled
+			stdout := &bytes.Buffer{}
+			require.NoError(t, newTelemetryPreviewAction(logger, fs, fc, batchPath, stdout).run())
+			assert.Equal(t, tc.wantStdout, stdout.String())
+		})
+	}
+}
@@ -8,6 +8,7 @@ package main
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/runfinch/finch/pkg/disk"
 	"github.com/runfinch/finch/pkg/fssh"
@@ -16,16 +17,18 @@ import (
 	"github.com/runfinch/finch/pkg/command"
 	"github.com/runfinch/finch/pkg/config"
 	"github.com/runfinch/finch/pkg/flog"
+	"github.com/runfinch/finch/pkg/lima"
 	"github.com/runfinch/finch/pkg/path"
 
+	"github.com/shirou/gopsutil/v3/host"
 	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
 )
 
-const (
-	limaInstanceName      = "finch"
-	virtualMachineRootCmd = "vm"
-)
+const virtualMachineRootCmd = "vm"
+
+// limaInstanceName is the name of the Finch instance in Lima, it's prefixed by the instancePrefix of the config at startup.
+var limaInstanceName = "finch"
 
 // Used by the actions that call VM start to ensure that the in-VM config file options are applied after boot.
 type postVMStartInitAction struct {
@@ -33,7 +36,9 @@ type postVMStartInitAction struct {
 	logger         flog.Logger
 	fs             afero.Fs
 	privateKeyPath string
+	instanceDir    string
 	nca            config.NerdctlConfigApplier
+	fc             *config.Finch
 }
 
 func newPostVMStartInitAction(
@@ -41,9 +46,19 @@ func newPostVMStartInitAction(
 	creator command.NerdctlCmdCreator,
 	fs afero.Fs,
 	privateKeyPath string,
+	instanceDir string,
 	nca config.NerdctlConfigApplier,
+	fc *config.Finch,
 ) *postVMStartInitAction {
-	return &postVMStartInitAction{creator: creator, logger: logger, fs: fs, privateKeyPath: privateKeyPath, nca: nca}
+	return &postVMStartInitAction{
+		creator:        creator,
+		logger:         logger,
+		fs:             fs,
+		privateKeyPath: privateKeyPath,
+		instanceDir:    instanceDir,
+		nca:            nca,
+		fc:             fc,
+	}
 }
 
 func (p *postVMStartInitAction) runAdapter(_ *cobra.Command, _ []string) error {
@@ -51,6 +66,15 @@ func (p *postVMStartInitAction) runAdapter(_ *cobra.Command, _ []string) error {
 }
 
 func (p *postVMStartInitAction) run() error {
+	// Recorded for finch vm stop --since-boot-only, which only stops the instances started since the host booted.
+	if err := p.recordHostBootTime(); err != nil {
+		p.logger.Warnf("Could not record the boot time of the host in the instance metadata: %v", err)
+	}
+	// Recorded for finch vm info --last-run-config, as finch.yaml can change while the VM runs.
+	if err := p.recordRunConfig(); err != nil {
+		p.logger.Warnf("Could not record the configuration the instance was started with: %v", err)
+	}
+
 	p.logger.Debugln("Applying guest configuration options")
 
 	sshPortArgs := []string{"ls", "-f", "{{.SSHLocalPort}}", limaInstanceName}
@@ -68,6 +92,98 @@ func (p *postVMStartInitAction) run() error {
 	return p.nca.Apply(fmt.Sprintf("127.0.0.1:%v", portString))
 }
 
+func (p *postVMStartInitAction) recordHostBootTime() error {
+	bootTime, err := hostBootTime()
+	if err != nil {
+		return err
+	}
+	return lima.UpdateInstanceMetadata(p.fs, p.instanceDir, func(md *lima.InstanceMetadata) {
+		md.HostBootTime = bootTime
+	})
+}
+
+// recordRunConfig records the configuration the VM was started with, with the defaults and the clamps applied,
+// which the stop turns into the last run configuration.
+func (p *postVMStartInitAction) recordRunConfig() error {
+	cfg, err := finchConfigMap(p.fc)
+	if err != nil {
+		return err
+	}
+	return lima.UpdateInstanceMetadata(p.fs, p.instanceDir, func(md *lima.InstanceMetadata) {
+		md.RunConfig = cfg
+	})
+}
+
+// hostBootTimeTolerance is how far apart two readings of the host boot time can be while being of the same boot,
+// as some platforms derive it from the uptime, which makes it drift by a second or so.
+const hostBootTimeTolerance = 5 * time.Second
+
+// hostBootTime returns when the host booted, which identifies its current boot session.
+func hostBootTime() (time.Time, error) {
+	secs, err := host.BootTime()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get the boot time of the host: %w", err)
+	}
+	return time.Unix(int64(secs), 0).UTC(), nil //nolint:gosec // G115: seconds since the epoch fit in an int64
+}
+
+// targetLimaHome returns a creator whose commands use the Lima home at limaHome instead of the one of Finch,
+// for setups where the state of Lima is kept somewhere else.
+func targetLimaHome(
+	creator command.NerdctlCmdCreator,
+	fs afero.Fs,
+	logger flog.Logger,
+	limaHome string,
+) (command.NerdctlCmdCreator, error) {
+	fi, err := fs.Stat(limaHome)
+	if err != nil {
+		return nil, fmt.Errorf("failed to access the Lima home: %w", err)
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("the Lima home %q is not a directory", limaHome)
+	}
+	targeter, ok := creator.(command.LimaHomeTargeter)
+	if !ok {
+		return nil, fmt.Errorf("the Lima home can't be changed to %q", limaHome)
+	}
+	logger.Debugf("Using the Lima home %q", limaHome)
+	return targeter.WithLimaHome(limaHome), nil
+}
+
+// addGuestSSHFlags adds the flags overriding how the guest is reached over SSH to cmd, see targetGuestSSH.
+func addGuestSSHFlags(cmd *cobra.Command) {
+	cmd.Flags().Int("ssh-port", 0, "port to reach the guest over SSH on, instead of the one forwarded by Lima")
+	cmd.Flags().String("ssh-identity", "", "path to the private key to reach the guest over SSH with, instead of the one generated by Lima")
+}
+
+// targetGuestSSH returns a creator whose commands run in the guest of the Finch instance go over SSH with the port
+// and the identity file set with the flags added by addGuestSSHFlags, instead of through `limactl shell`.
+// The creator is returned as is if neither is set.
+func targetGuestSSH(
+	cmd *cobra.Command,
+	creator command.NerdctlCmdCreator,
+	ecc command.Creator,
+	fs afero.Fs,
+	logger flog.Logger,
+) (command.NerdctlCmdCreator, error) {
+	port, err := cmd.Flags().GetInt("ssh-port")
+	if err != nil {
+		return nil, err
+	}
+	if port < 0 || port > 65535 {
+		return nil, fmt.Errorf("--ssh-port must be between 1 and 65535, got %d", port)
+	}
+	identityFile, err := cmd.Flags().GetString("ssh-identity")
+	if err != nil {
+		return nil, err
+	}
+	if port == 0 && identityFile == "" {
+		return creator, nil
+	}
+	overrides := lima.SSHConfig{Port: port, IdentityFile: identityFile}
+	return lima.NewSSHCmdCreator(creator, ecc, fs, logger, limaInstanceName, overrides), nil
+}
+
 func virtualMachineCommands(
 	logger flog.Logger,
 	fp path.Finch,
@@ -80,6 +196,7 @@ func virtualMachineCommands(
 ) *cobra.Command {
 	return newVirtualMachineCommand(
 		ncc,
+		ecc,
 		logger,
 		dependencies(ecc, fc, fp, fs, ncc, logger, fp.FinchDir(finchRootPath)),
 		config.NewLimaApplier(
@@ -97,11 +214,21 @@ func virtualMachineCommands(
 			fp.LimaSSHPrivateKeyPath(),
 			fp.FinchDir(finchRootPath),
 			home,
-			fp.LimaInstancePath(),
+			fp.LimaInstancePathOf(limaInstanceName),
 			fc,
 		),
 		fp,
 		fs,
-		disk.NewUserDataDiskManager(ncc, ecc, &afero.OsFs{}, fp, finchRootPath, fc, logger),
+		disk.NewManager(disk.ManagerDeps{
+			NCC:     ncc,
+			ECC:     ecc,
+			FS:      &afero.OsFs{},
+			Finch:   fp,
+			RootDir: finchRootPath,
+			Config:  fc,
+			Logger:  logger,
+		}),
+		fc,
+		finchRootPath,
 	)
 }
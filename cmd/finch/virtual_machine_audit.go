@@ -0,0 +1,140 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/runfinch/finch/pkg/audit"
+	"github.com/runfinch/finch/pkg/flog"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+)
+
+// recordAudit appends the record of the command run on the instance to the audit log at path.
+// Auditing is best-effort: a failure to write the record is logged and never fails the command.
+func recordAudit(fs afero.Fs, logger flog.Logger, path, command, instance string, err error) {
+	appendAudit(fs, logger, path, audit.NewRecord(command, instance, err))
+}
+
+// appendAudit appends the record to the audit log at path, see recordAudit.
+func appendAudit(fs afero.Fs, logger flog.Logger, path string, r audit.Record) {
+	if err := audit.Append(fs, path, r); err != nil {
+		logger.Warnf("Could not write the audit record: %v", err)
+	}
+}
+
+// withAudit makes the command append a record of each of its runs on the Finch instance to the audit log at path.
+// The record covers the whole run, from its PreRunE to its PostRunE, e.g. the initialization that follows a start.
+func withAudit(cmd *cobra.Command, fs afero.Fs, logger flog.Logger, path string) *cobra.Command {
+	record := func(cmd *cobra.Command, err error) {
+		recordAudit(fs, logger, path, auditCommandName(cmd), limaInstanceName, err)
+	}
+	// Cobra stops at the first of them that fails, so the run is recorded there, or once the last one is done.
+	if preRunE := cmd.PreRunE; preRunE != nil {
+		cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+			err := preRunE(cmd, args)
+			if err != nil {
+				record(cmd, err)
+			}
+			return err
+		}
+	}
+	runE, postRunE := cmd.RunE, cmd.PostRunE
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		err := runE(cmd, args)
+		if err != nil || postRunE == nil {
+			record(cmd, err)
+		}
+		return err
+	}
+	if postRunE != nil {
+		cmd.PostRunE = func(cmd *cobra.Command, args []string) error {
+			err := postRunE(cmd, args)
+			record(cmd, err)
+			return err
+		}
+	}
+	return cmd
+}
+
+// auditCommandName returns the name the command is recorded with, i.e., its path under finch vm, e.g. "disk attach".
+func auditCommandName(cmd *cobra.Command) string {
+	name := cmd.Name()
+	for parent := cmd.Parent(); parent != nil && parent.Name() != virtualMachineRootCmd; parent = parent.Parent() {
+		name = parent.Name() + " " + name
+	}
+	return name
+}
+
+func newAuditVMCommand(logger flog.Logger, fs afero.Fs, path string, stdout io.Writer) *cobra.Command {
+	auditVMCommand := &cobra.Command{
+		Use:   "audit",
+		Short: "Print the audit log of the changes of state of the virtual machine",
+		Args:  cobra.NoArgs,
+		RunE:  newAuditVMAction(logger, fs, path, stdout).runAdapter,
+	}
+
+	auditVMCommand.Flags().Bool("verify", false, "verify that no record of the audit log was altered or removed")
+
+	return auditVMCommand
+}
+
+type auditVMAction struct {
+	logger flog.Logger
+	fs     afero.Fs
+	path   string
+	stdout io.Writer
+}
+
+func newAuditVMAction(logger flog.Logger, fs afero.Fs, path string, stdout io.Writer) *auditVMAction {
+	return &auditVMAction{logger: logger, fs: fs, path: path, stdout: stdout}
+}
+
+func (ava *auditVMAction) runAdapter(cmd *cobra.Command, _ []string) error {
+	verify, err := cmd.Flags().GetBool("verify")
+	if err != nil {
+		return err
+	}
+	return ava.run(verify)
+}
+
+func (ava *auditVMAction) run(verify bool) error {
+	records, err := audit.Read(ava.fs, ava.path)
+	if err != nil {
+		return err
+	}
+	if verify {
+		var key []byte
+		// The key is generated along with the first record.
+		if len(records) > 0 {
+			if key, err = audit.ReadKey(ava.fs, ava.path); err != nil {
+				return err
+			}
+		}
+		if err := audit.Verify(records, key); err != nil {
+			return fmt.Errorf("the audit log %q was tampered with: %w", ava.path, err)
+		}
+		ava.logger.Infof("The audit log is intact, %d records verified", len(records))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(ava.stdout, 0, 0, 3, ' ', 0)
+	if _, err := fmt.Fprintln(w, "TIME\tUSER\tCOMMAND\tINSTANCE\tRESULT"); err != nil {
+		return err
+	}
+	for _, r := range records {
+		if _, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			r.Time.Local().Format(time.RFC3339), r.User, r.Command, r.Instance, r.Result); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
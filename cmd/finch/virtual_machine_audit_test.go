@@ -0,0 +1,336 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/runfinch/finch/pkg/audit"
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/lima"
+	"github.com/runfinch/finch/pkg/mocks"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+const mockAuditLogPath = "/home/.finch/audit.log"
+
+func TestWithAudit(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name       string
+		preRunErr  error
+		runErr     error
+		postRun    bool
+		postRunErr error
+		wantResult string
+	}{
+		{
+			name:       "should record a successful run",
+			wantResult: audit.ResultSuccess,
+		},
+		{
+			name:       "should record a failed run",
+			runErr:     errors.New("exit status 1"),
+			wantResult: audit.ResultFailure,
+		},
+		{
+			name:       "should record a run whose PreRunE failed",
+			preRunErr:  errors.New("invalid flag"),
+			wantResult: audit.ResultFailure,
+		},
+		{
+			name:       "should record a successful run once its PostRunE is done",
+			postRun:    true,
+			wantResult: audit.ResultSuccess,
+		},
+		{
+			name:       "should record a run whose PostRunE failed",
+			postRun:    true,
+			postRunErr: errors.New("failed to initialize the VM"),
+			wantResult: audit.ResultFailure,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			fs := afero.NewMemMapFs()
+			cmd := &cobra.Command{
+				Use:     "start",
+				PreRunE: func(*cobra.Command, []string) error { return tc.preRunErr },
+				RunE:    func(*cobra.Command, []string) error { return tc.runErr },
+			}
+			if tc.postRun {
+				cmd.PostRunE = func(*cobra.Command, []string) error { return tc.postRunErr }
+			}
+			cmd = withAudit(cmd, fs, nil, mockAuditLogPath)
+			cmd.SetArgs([]string{})
+			cmd.SilenceErrors = true
+			cmd.SilenceUsage = true
+			_ = cmd.Execute()
+
+			records, err := audit.Read(fs, mockAuditLogPath)
+			require.NoError(t, err)
+			require.Len(t, records, 1)
+			assert.Equal(t, "start", records[0].Command)
+			assert.Equal(t, limaInstanceName, records[0].Instance)
+			assert.Equal(t, tc.wantResult, records[0].Result)
+		})
+	}
+}
+
+func TestWithAudit_subcommand(t *testing.T) {
+	t.Parallel()
+
+	fs := afero.NewMemMapFs()
+	vmCmd := &cobra.Command{Use: virtualMachineRootCmd}
+	diskCmd := &cobra.Command{Use: "disk"}
+	vmCmd.AddCommand(diskCmd)
+	diskCmd.AddCommand(withAudit(&cobra.Command{
+		Use:  "attach",
+		RunE: func(*cobra.Command, []string) error { return nil },
+	}, fs, nil, mockAuditLogPath))
+	vmCmd.SetArgs([]string{"disk", "attach"})
+	require.NoError(t, vmCmd.Execute())
+
+	records, err := audit.Read(fs, mockAuditLogPath)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, "disk attach", records[0].Command)
+}
+
+func TestAuditVMAction_run(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name    string
+		verify  bool
+		tamper  bool
+		empty   bool
+		mockSvc func(*mocks.Logger)
+		wantOut string
+		wantErr string
+	}{
+		{
+			name:    "should print the records of the audit log",
+			mockSvc: func(*mocks.Logger) {},
+			wantOut: "TIME",
+		},
+		{
+			name:   "should verify an intact audit log",
+			verify: true,
+			mockSvc: func(logger *mocks.Logger) {
+				logger.EXPECT().Infof("The audit log is intact, %d records verified", 2)
+			},
+		},
+		{
+			name:   "should verify an empty audit log",
+			verify: true,
+			empty:  true,
+			mockSvc: func(logger *mocks.Logger) {
+				logger.EXPECT().Infof("The audit log is intact, %d records verified", 0)
+			},
+		},
+		{
+			name:    "should report a tampered audit log",
+			verify:  true,
+			tamper:  true,
+			mockSvc: func(*mocks.Logger) {},
+			wantErr: `the audit log "/home/.finch/audit.log" was tampered with: the audit record 1 was altered`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			logger := mocks.NewLogger(ctrl)
+			fs := afero.NewMemMapFs()
+			if !tc.empty {
+				require.NoError(t, audit.Append(fs, mockAuditLogPath, audit.NewRecord("start", "finch", nil)))
+				require.NoError(t, audit.Append(fs, mockAuditLogPath, audit.NewRecord("stop", "finch", nil)))
+			}
+			if tc.tamper {
+				b, err := afero.ReadFile(fs, mockAuditLogPath)
+				require.NoError(t, err)
+				b = bytes.Replace(b, []byte(`"command":"start"`), []byte(`"command":"stats"`), 1)
+				require.NoError(t, afero.WriteFile(fs, mockAuditLogPath, b, 0o600))
+			}
+			tc.mockSvc(logger)
+
+			stdout := &bytes.Buffer{}
+			err := newAuditVMAction(logger, fs, mockAuditLogPath, stdout).run(tc.verify)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			if tc.wantOut != "" {
+				lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+				require.Len(t, lines, 3)
+				assert.Equal(t, []string{"TIME", "USER", "COMMAND", "INSTANCE", "RESULT"}, strings.Fields(lines[0]))
+				assert.Contains(t, lines[1], "start")
+				assert.Contains(t, lines[2], "stop")
+			}
+		})
+	}
+}
+
+func TestStopVMAction_runRecordsAudit(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	dm := mocks.NewUserDataDiskManager(ctrl)
+	logger := mocks.NewLogger(ctrl)
+	ncc := mocks.NewNerdctlCmdCreator(ctrl)
+
+	getVMStatusC := mocks.NewCommand(ctrl)
+	ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+	getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+	expectUnmountGuestDataVolume(ncc, ctrl)
+	dm.EXPECT().DetachUserDataDisk().Return(nil)
+	stopC := mocks.NewCommand(ctrl)
+	ncc.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(stopC)
+	stopC.EXPECT().CombinedOutput()
+	logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+	logger.EXPECT().Info("Finch virtual machine stopped successfully")
+
+	fs := afero.NewMemMapFs()
+	action := newStopVMAction(ncc, nil, dm, logger, fs, mockFinchPath, &config.Finch{}, mockFinchRootPath, nil, nil, nil)
+	require.NoError(t, action.run(stopVMOptions{}))
+
+	records, err := audit.Read(fs, mockFinchPath.AuditLogPath(mockFinchRootPath))
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, "stop", records[0].Command)
+	assert.Equal(t, limaInstanceName, records[0].Instance)
+	assert.Equal(t, audit.ResultSuccess, records[0].Result)
+}
+
+func TestStopVMAction_runRecordsAnnotations(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	dm := mocks.NewUserDataDiskManager(ctrl)
+	logger := mocks.NewLogger(ctrl)
+	ncc := mocks.NewNerdctlCmdCreator(ctrl)
+
+	getVMStatusC := mocks.NewCommand(ctrl)
+	ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+	getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+	expectUnmountGuestDataVolume(ncc, ctrl)
+	dm.EXPECT().DetachUserDataDisk().Return(nil)
+	stopC := mocks.NewCommand(ctrl)
+	ncc.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(stopC)
+	stopC.EXPECT().CombinedOutput()
+	logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+	logger.EXPECT().Info("Finch virtual machine stopped successfully")
+
+	fs := afero.NewMemMapFs()
+	annotations := map[string]string{"ticket": "JIRA-123", "owner": "alice"}
+	action := newStopVMAction(ncc, nil, dm, logger, fs, mockFinchPath, &config.Finch{}, mockFinchRootPath, nil, nil, nil)
+	require.NoError(t, action.run(stopVMOptions{annotations: annotations}))
+
+	records, err := audit.Read(fs, mockFinchPath.AuditLogPath(mockFinchRootPath))
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, annotations, records[0].Annotations)
+	key, err := audit.ReadKey(fs, mockFinchPath.AuditLogPath(mockFinchRootPath))
+	require.NoError(t, err)
+	assert.NoError(t, audit.Verify(records, key))
+	md, err := lima.LoadInstanceMetadata(fs, mockFinchPath.LimaInstancePathOf(limaInstanceName))
+	require.NoError(t, err)
+	assert.Equal(t, annotations, md.StopAnnotations)
+}
+
+func TestStopVMAction_runRecordsLastRunConfig(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	dm := mocks.NewUserDataDiskManager(ctrl)
+	logger := mocks.NewLogger(ctrl)
+	ncc := mocks.NewNerdctlCmdCreator(ctrl)
+
+	getVMStatusC := mocks.NewCommand(ctrl)
+	ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+	getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+	expectUnmountGuestDataVolume(ncc, ctrl)
+	dm.EXPECT().DetachUserDataDisk().Return(nil)
+	stopC := mocks.NewCommand(ctrl)
+	ncc.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(stopC)
+	stopC.EXPECT().CombinedOutput()
+	logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+	logger.EXPECT().Info("Finch virtual machine stopped successfully")
+
+	fs := afero.NewMemMapFs()
+	instanceDir := mockFinchPath.LimaInstancePathOf(limaInstanceName)
+	require.NoError(t, lima.SaveInstanceMetadata(fs, instanceDir, &lima.InstanceMetadata{RunConfig: map[string]any{"dockercompat": true}}))
+	// finch.yaml changed since the VM was started, the configuration it was started with is the one recorded.
+	action := newStopVMAction(ncc, nil, dm, logger, fs, mockFinchPath, &config.Finch{}, mockFinchRootPath, nil, nil, nil)
+	require.NoError(t, action.run(stopVMOptions{}))
+
+	md, err := lima.LoadInstanceMetadata(fs, instanceDir)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"dockercompat": true}, md.LastRunConfig)
+	assert.Nil(t, md.RunConfig)
+}
+
+func TestParseAnnotations(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		annotate []string
+		want     map[string]string
+		wantErr  string
+	}{
+		{
+			name:     "should accumulate the annotations",
+			annotate: []string{"ticket=JIRA-123", "owner=alice", "note=a=b"},
+			want:     map[string]string{"ticket": "JIRA-123", "owner": "alice", "note": "a=b"},
+		},
+		{
+			name:     "should let a later annotation override an earlier one",
+			annotate: []string{"owner=alice", "owner=bob"},
+			want:     map[string]string{"owner": "bob"},
+		},
+		{
+			name:     "should reject an annotation without a value",
+			annotate: []string{"owner"},
+			wantErr:  `invalid annotation "owner", it must be in the key=value format`,
+		},
+		{
+			name:     "should reject an annotation without a key",
+			annotate: []string{"=alice"},
+			wantErr:  `invalid annotation "=alice", it must be in the key=value format`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := parseAnnotations(tc.annotate)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/runfinch/finch/pkg/command"
+	"github.com/runfinch/finch/pkg/disk"
+	"github.com/runfinch/finch/pkg/flog"
+	"github.com/runfinch/finch/pkg/lima"
+)
+
+func newVMDiskDetachCommand(creator command.NerdctlCmdCreator, diskManager disk.UserDataDiskManager, logger flog.Logger) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "detach",
+		Short: "Detach the user data disk from the virtual machine",
+		RunE:  newDiskDetachAction(creator, diskManager, logger).runAdapter,
+	}
+	cmd.Flags().BoolP("force", "f", false, "detach the disk even if the virtual machine is running")
+	return cmd
+}
+
+func newVMDiskAttachCommand(diskManager disk.UserDataDiskManager, logger flog.Logger) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "attach",
+		Short: "Attach the user data disk to the virtual machine, creating it if needed",
+		RunE:  newDiskAttachAction(diskManager, logger).runAdapter,
+	}
+	return cmd
+}
+
+type diskDetachAction struct {
+	creator     command.NerdctlCmdCreator
+	diskManager disk.UserDataDiskManager
+	logger      flog.Logger
+}
+
+func newDiskDetachAction(creator command.NerdctlCmdCreator, diskManager disk.UserDataDiskManager, logger flog.Logger) *diskDetachAction {
+	return &diskDetachAction{creator: creator, diskManager: diskManager, logger: logger}
+}
+
+func (dda *diskDetachAction) runAdapter(cmd *cobra.Command, _ []string) error {
+	force, err := cmd.Flags().GetBool("force")
+	if err != nil {
+		return err
+	}
+	return dda.run(force)
+}
+
+func (dda *diskDetachAction) run(force bool) error {
+	if !force {
+		status, err := lima.Status(dda.creator, limaInstanceName)
+		if err != nil {
+			return err
+		}
+		// The guest may be writing to the disk, pulling it from under it can corrupt the file system.
+		if status == lima.Running || status == lima.Starting {
+			return fmt.Errorf("the instance %q is running, detaching its user data disk could corrupt it, "+
+				"run `finch %s stop` first or use --force", limaInstanceName, virtualMachineRootCmd)
+		}
+	}
+
+	if err := dda.diskManager.DetachUserDataDisk(); err != nil {
+		return err
+	}
+	dda.logger.Info("User data disk detached successfully")
+	return nil
+}
+
+type diskAttachAction struct {
+	diskManager disk.UserDataDiskManager
+	logger      flog.Logger
+}
+
+func newDiskAttachAction(diskManager disk.UserDataDiskManager, logger flog.Logger) *diskAttachAction {
+	return &diskAttachAction{diskManager: diskManager, logger: logger}
+}
+
+func (daa *diskAttachAction) runAdapter(_ *cobra.Command, _ []string) error {
+	return daa.run()
+}
+
+func (daa *diskAttachAction) run() error {
+	if err := daa.diskManager.EnsureUserDataDisk(); err != nil {
+		return err
+	}
+	daa.logger.Info("User data disk attached successfully")
+	return nil
+}
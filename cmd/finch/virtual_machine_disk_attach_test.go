@@ -0,0 +1,138 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/runfinch/finch/pkg/mocks"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestNewVMDiskDetachCommand(t *testing.T) {
+	t.Parallel()
+
+	cmd := newVMDiskDetachCommand(nil, nil, nil)
+	assert.Equal(t, cmd.Name(), "detach")
+}
+
+func TestDiskDetachAction_run(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name    string
+		force   bool
+		wantErr error
+		mockSvc func(*mocks.NerdctlCmdCreator, *mocks.UserDataDiskManager, *mocks.Logger, *gomock.Controller)
+	}{
+		{
+			name:    "should detach the disk of a stopped VM",
+			force:   false,
+			wantErr: nil,
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, dm *mocks.UserDataDiskManager, logger *mocks.Logger, ctrl *gomock.Controller) {
+				statusC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(statusC)
+				statusC.EXPECT().Output().Return([]byte("Stopped"), nil)
+				dm.EXPECT().DetachUserDataDisk().Return(nil)
+				logger.EXPECT().Info("User data disk detached successfully")
+			},
+		},
+		{
+			name:  "should refuse to detach the disk of a running VM",
+			force: false,
+			wantErr: fmt.Errorf("the instance %q is running, detaching its user data disk could corrupt it, "+
+				"run `finch %s stop` first or use --force", limaInstanceName, virtualMachineRootCmd),
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, _ *mocks.UserDataDiskManager, _ *mocks.Logger, ctrl *gomock.Controller) {
+				statusC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(statusC)
+				statusC.EXPECT().Output().Return([]byte("Running"), nil)
+			},
+		},
+		{
+			name:    "should detach the disk of a running VM with --force",
+			force:   true,
+			wantErr: nil,
+			mockSvc: func(_ *mocks.NerdctlCmdCreator, dm *mocks.UserDataDiskManager, logger *mocks.Logger, _ *gomock.Controller) {
+				dm.EXPECT().DetachUserDataDisk().Return(nil)
+				logger.EXPECT().Info("User data disk detached successfully")
+			},
+		},
+		{
+			name:    "should return the error of the disk manager",
+			force:   true,
+			wantErr: errors.New("failed to detach disk"),
+			mockSvc: func(_ *mocks.NerdctlCmdCreator, dm *mocks.UserDataDiskManager, _ *mocks.Logger, _ *gomock.Controller) {
+				dm.EXPECT().DetachUserDataDisk().Return(errors.New("failed to detach disk"))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			tc.mockSvc(ncc, dm, logger, ctrl)
+
+			err := newDiskDetachAction(ncc, dm, logger).run(tc.force)
+			assert.Equal(t, tc.wantErr, err)
+		})
+	}
+}
+
+func TestNewVMDiskAttachCommand(t *testing.T) {
+	t.Parallel()
+
+	cmd := newVMDiskAttachCommand(nil, nil)
+	assert.Equal(t, cmd.Name(), "attach")
+}
+
+func TestDiskAttachAction_run(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name    string
+		wantErr error
+		mockSvc func(*mocks.UserDataDiskManager, *mocks.Logger)
+	}{
+		{
+			name:    "should attach the disk",
+			wantErr: nil,
+			mockSvc: func(dm *mocks.UserDataDiskManager, logger *mocks.Logger) {
+				dm.EXPECT().EnsureUserDataDisk().Return(nil)
+				logger.EXPECT().Info("User data disk attached successfully")
+			},
+		},
+		{
+			name:    "should return the error of the disk manager",
+			wantErr: errors.New("could not attach persistent disk"),
+			mockSvc: func(dm *mocks.UserDataDiskManager, _ *mocks.Logger) {
+				dm.EXPECT().EnsureUserDataDisk().Return(errors.New("could not attach persistent disk"))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			tc.mockSvc(dm, logger)
+
+			err := newDiskAttachAction(dm, logger).run()
+			assert.Equal(t, tc.wantErr, err)
+		})
+	}
+}
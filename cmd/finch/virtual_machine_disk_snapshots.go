@@ -0,0 +1,69 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/spf13/cobra"
+
+	"github.com/runfinch/finch/pkg/disk"
+	"github.com/runfinch/finch/pkg/flog"
+)
+
+func newVMDiskSnapshotsCommand(diskManager disk.UserDataDiskManager, logger flog.Logger, stdout io.Writer) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "snapshots",
+		Short: "List the snapshots of the user data disk, taken with finch vm stop --pre-snapshot",
+		Args:  cobra.NoArgs,
+		RunE:  newDiskSnapshotsAction(diskManager, logger, stdout).runAdapter,
+	}
+	return cmd
+}
+
+type diskSnapshotsAction struct {
+	diskManager disk.UserDataDiskManager
+	logger      flog.Logger
+	stdout      io.Writer
+}
+
+func newDiskSnapshotsAction(diskManager disk.UserDataDiskManager, logger flog.Logger, stdout io.Writer) *diskSnapshotsAction {
+	return &diskSnapshotsAction{diskManager: diskManager, logger: logger, stdout: stdout}
+}
+
+func (dsa *diskSnapshotsAction) runAdapter(_ *cobra.Command, _ []string) error {
+	return dsa.run()
+}
+
+func (dsa *diskSnapshotsAction) run() error {
+	snapshots, err := dsa.diskManager.UserDataDiskSnapshots()
+	if err != nil {
+		return err
+	}
+	if len(snapshots) == 0 {
+		dsa.logger.Info("No snapshots of the user data disk were taken")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(dsa.stdout, 0, 0, 3, ' ', 0)
+	if _, err := fmt.Fprintln(tw, "NAME\tSIZE\tCREATED"); err != nil {
+		return err
+	}
+	for _, s := range snapshots {
+		name := strings.TrimSuffix(s.Name(), filepath.Ext(s.Name()))
+		if _, err := fmt.Fprintf(tw, "%s\t%s\t%s\n", name, units.HumanSize(float64(s.Size())),
+			s.ModTime().Format(time.RFC3339)); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
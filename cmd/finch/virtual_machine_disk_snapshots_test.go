@@ -0,0 +1,76 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"bytes"
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/runfinch/finch/pkg/mocks"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestNewVMDiskSnapshotsCommand(t *testing.T) {
+	t.Parallel()
+
+	cmd := newVMDiskSnapshotsCommand(nil, nil, nil)
+	assert.Equal(t, cmd.Name(), "snapshots")
+}
+
+func TestDiskSnapshotsAction_run(t *testing.T) {
+	t.Parallel()
+
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	mem := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(mem, "/snapshots/before-upgrade.img", make([]byte, 2048), 0o600))
+	require.NoError(t, mem.Chtimes("/snapshots/before-upgrade.img", created, created))
+	snapshot, err := mem.Stat("/snapshots/before-upgrade.img")
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name       string
+		wantOutput string
+		mockSvc    func(*mocks.UserDataDiskManager, *mocks.Logger)
+	}{
+		{
+			name: "should list the snapshots",
+			wantOutput: "NAME             SIZE      CREATED\n" +
+				"before-upgrade   2.048kB   " + created.Format(time.RFC3339) + "\n",
+			mockSvc: func(dm *mocks.UserDataDiskManager, _ *mocks.Logger) {
+				dm.EXPECT().UserDataDiskSnapshots().Return([]fs.FileInfo{snapshot}, nil)
+			},
+		},
+		{
+			name:       "should tell that there are no snapshots",
+			wantOutput: "",
+			mockSvc: func(dm *mocks.UserDataDiskManager, logger *mocks.Logger) {
+				dm.EXPECT().UserDataDiskSnapshots().Return(nil, nil)
+				logger.EXPECT().Info("No snapshots of the user data disk were taken")
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			tc.mockSvc(dm, logger)
+
+			stdout := &bytes.Buffer{}
+			require.NoError(t, newDiskSnapshotsAction(dm, logger, stdout).run())
+			assert.Equal(t, tc.wantOutput, stdout.String())
+		})
+	}
+}
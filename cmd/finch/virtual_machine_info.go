@@ -0,0 +1,182 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/runfinch/finch/pkg/command"
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/flog"
+	"github.com/runfinch/finch/pkg/lima"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+)
+
+func newInfoVMCommand(
+	limaCmdCreator command.NerdctlCmdCreator,
+	logger flog.Logger,
+	fc *config.Finch,
+	fs afero.Fs,
+	instanceDir string,
+	stdout io.Writer,
+) *cobra.Command {
+	infoVMCommand := &cobra.Command{
+		Use:   "info",
+		Short: "Display the configuration and the runtime information of the virtual machine",
+		RunE:  newInfoVMAction(limaCmdCreator, logger, fc, fs, instanceDir, stdout).runAdapter,
+	}
+
+	infoVMCommand.Flags().Bool("json", false, "print the information as JSON")
+	infoVMCommand.Flags().Bool("last-run-config", false,
+		"print the effective configuration the virtual machine ran with until it was last stopped, instead of the information")
+
+	return infoVMCommand
+}
+
+// vmInfo is the document printed by `finch vm info`.
+// The configuration persisted in finch.yaml and the live state reported by Lima are kept apart,
+// so that consumers can tell what was asked for from what is actually running.
+type vmInfo struct {
+	Config map[string]any `json:"config" yaml:"config"`
+	// Runtime is nil if the VM doesn't exist.
+	Runtime *vmRuntimeInfo `json:"runtime" yaml:"runtime"`
+	// StopAnnotations are the annotations of the last stop made with finch vm stop --annotate.
+	StopAnnotations map[string]string `json:"stopAnnotations,omitempty" yaml:"stopAnnotations,omitempty"`
+}
+
+// vmRuntimeInfo holds the fields of `limactl ls --json` that describe the live state of the VM.
+// The fields that only make sense for a running VM are omitted when it's stopped.
+type vmRuntimeInfo struct {
+	Status       string `json:"status" yaml:"status"`
+	VMType       string `json:"vmType,omitempty" yaml:"vmType,omitempty"`
+	Arch         string `json:"arch,omitempty" yaml:"arch,omitempty"`
+	CPUs         int    `json:"cpus,omitempty" yaml:"cpus,omitempty"`
+	Memory       int64  `json:"memory,omitempty" yaml:"memory,omitempty"`
+	Disk         int64  `json:"disk,omitempty" yaml:"disk,omitempty"`
+	SSHLocalPort int    `json:"sshLocalPort,omitempty" yaml:"sshLocalPort,omitempty"`
+	HostAgentPID int    `json:"hostAgentPID,omitempty" yaml:"hostAgentPID,omitempty"`
+	DriverPID    int    `json:"driverPID,omitempty" yaml:"driverPID,omitempty"`
+	Message      string `json:"message,omitempty" yaml:"message,omitempty"`
+	Errors       []any  `json:"errors,omitempty" yaml:"errors,omitempty"`
+}
+
+type infoVMAction struct {
+	creator     command.NerdctlCmdCreator
+	logger      flog.Logger
+	fc          *config.Finch
+	fs          afero.Fs
+	instanceDir string
+	stdout      io.Writer
+}
+
+func newInfoVMAction(
+	creator command.NerdctlCmdCreator,
+	logger flog.Logger,
+	fc *config.Finch,
+	fs afero.Fs,
+	instanceDir string,
+	stdout io.Writer,
+) *infoVMAction {
+	return &infoVMAction{creator: creator, logger: logger, fc: fc, fs: fs, instanceDir: instanceDir, stdout: stdout}
+}
+
+func (iva *infoVMAction) runAdapter(cmd *cobra.Command, _ []string) error {
+	asJSON, err := cmd.Flags().GetBool("json")
+	if err != nil {
+		return err
+	}
+	lastRunConfig, err := cmd.Flags().GetBool("last-run-config")
+	if err != nil {
+		return err
+	}
+	if lastRunConfig {
+		return iva.runLastRunConfig(asJSON)
+	}
+	return iva.run(asJSON)
+}
+
+func (iva *infoVMAction) run(asJSON bool) error {
+	cfg, err := finchConfigMap(iva.fc)
+	if err != nil {
+		return err
+	}
+	info := vmInfo{Config: cfg}
+
+	out, err := iva.creator.CreateWithoutStdio("ls", "--json", limaInstanceName).Output()
+	if err != nil {
+		return fmt.Errorf("failed to get the virtual machine runtime information: %w", err)
+	}
+	if out = bytes.TrimSpace(out); len(out) > 0 {
+		if info.Runtime, err = parseRuntimeInfo(out); err != nil {
+			return err
+		}
+	} else {
+		iva.logger.Debugln("The virtual machine doesn't exist, no runtime information to report")
+	}
+	if md, err := lima.LoadInstanceMetadata(iva.fs, iva.instanceDir); err == nil {
+		info.StopAnnotations = md.StopAnnotations
+	} else {
+		iva.logger.Debugf("Could not load the instance metadata: %v", err)
+	}
+
+	return iva.print(info, asJSON)
+}
+
+// runLastRunConfig prints the configuration the VM ran with until it was last stopped, as recorded by finch vm stop.
+func (iva *infoVMAction) runLastRunConfig(asJSON bool) error {
+	md, err := lima.LoadInstanceMetadata(iva.fs, iva.instanceDir)
+	if err != nil {
+		return err
+	}
+	if md.LastRunConfig == nil {
+		return fmt.Errorf("no configuration was recorded for the virtual machine, it's recorded when it's stopped with `finch %s stop`",
+			virtualMachineRootCmd)
+	}
+	return iva.print(md.LastRunConfig, asJSON)
+}
+
+func (iva *infoVMAction) print(v any, asJSON bool) error {
+	if asJSON {
+		enc := json.NewEncoder(iva.stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	b, err := yaml.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal the virtual machine information: %w", err)
+	}
+	_, err = iva.stdout.Write(b)
+	return err
+}
+
+// finchConfigMap returns the Finch configuration with the same keys as finch.yaml, whatever the OS specific settings are.
+func finchConfigMap(fc *config.Finch) (map[string]any, error) {
+	b, err := yaml.Marshal(fc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal the Finch configuration: %w", err)
+	}
+	cfg := map[string]any{}
+	if err := yaml.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal the Finch configuration: %w", err)
+	}
+	return cfg, nil
+}
+
+// parseRuntimeInfo parses the live state of the VM out of the output of `limactl ls --json`.
+func parseRuntimeInfo(out []byte) (*vmRuntimeInfo, error) {
+	var runtime vmRuntimeInfo
+	if err := json.Unmarshal(out, &runtime); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal the virtual machine runtime information: %w", err)
+	}
+	return &runtime, nil
+}
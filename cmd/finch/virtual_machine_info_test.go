@@ -0,0 +1,219 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/lima"
+	"github.com/runfinch/finch/pkg/mocks"
+)
+
+func TestNewInfoVMCommand(t *testing.T) {
+	t.Parallel()
+
+	cmd := newInfoVMCommand(nil, nil, nil, nil, "", nil)
+	assert.Equal(t, cmd.Name(), "info")
+}
+
+func TestInfoVMAction_run(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name       string
+		asJSON     bool
+		lsOutput   string
+		lsErr      error
+		wantErr    error
+		wantStdout string
+		mockSvc    func(logger *mocks.Logger)
+		// annotations are the annotations of the last stop recorded in the instance metadata.
+		annotations map[string]string
+	}{
+		{
+			name:     "should merge the configuration and the status of a running VM",
+			asJSON:   true,
+			lsOutput: `{"name":"finch","status":"Running","vmType":"vz","cpus":2,"sshLocalPort":60022,"hostAgentPID":42}`,
+			wantErr:  nil,
+			wantStdout: `{
+  "config": {
+    "dockercompat": true
+  },
+  "runtime": {
+    "status": "Running",
+    "vmType": "vz",
+    "cpus": 2,
+    "sshLocalPort": 60022,
+    "hostAgentPID": 42
+  }
+}
+`,
+			mockSvc: func(_ *mocks.Logger) {},
+		},
+		{
+			name:     "should omit the live fields of a stopped VM",
+			asJSON:   true,
+			lsOutput: `{"name":"finch","status":"Stopped","vmType":"vz","cpus":2,"sshLocalPort":0,"hostAgentPID":0}`,
+			wantErr:  nil,
+			wantStdout: `{
+  "config": {
+    "dockercompat": true
+  },
+  "runtime": {
+    "status": "Stopped",
+    "vmType": "vz",
+    "cpus": 2
+  }
+}
+`,
+			mockSvc: func(_ *mocks.Logger) {},
+		},
+		{
+			name:     "should report no runtime information if the VM doesn't exist",
+			asJSON:   true,
+			lsOutput: "",
+			wantErr:  nil,
+			wantStdout: `{
+  "config": {
+    "dockercompat": true
+  },
+  "runtime": null
+}
+`,
+			mockSvc: func(logger *mocks.Logger) {
+				logger.EXPECT().Debugln("The virtual machine doesn't exist, no runtime information to report")
+			},
+		},
+		{
+			name:     "should print YAML without --json",
+			asJSON:   false,
+			lsOutput: `{"name":"finch","status":"Stopped"}`,
+			wantErr:  nil,
+			wantStdout: `config:
+    dockercompat: true
+runtime:
+    status: Stopped
+`,
+			mockSvc: func(_ *mocks.Logger) {},
+		},
+		{
+			name:     "should report the annotations of the last stop",
+			asJSON:   false,
+			lsOutput: `{"name":"finch","status":"Stopped"}`,
+			wantErr:  nil,
+			wantStdout: `config:
+    dockercompat: true
+runtime:
+    status: Stopped
+stopAnnotations:
+    owner: alice
+    ticket: JIRA-123
+`,
+			mockSvc:     func(_ *mocks.Logger) {},
+			annotations: map[string]string{"ticket": "JIRA-123", "owner": "alice"},
+		},
+		{
+			name:       "should return an error if the runtime information can't be read",
+			asJSON:     true,
+			lsErr:      errors.New("error"),
+			wantErr:    fmt.Errorf("failed to get the virtual machine runtime information: %w", errors.New("error")),
+			wantStdout: "",
+			mockSvc:    func(_ *mocks.Logger) {},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+			stdout := &bytes.Buffer{}
+
+			lsC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("ls", "--json", limaInstanceName).Return(lsC)
+			lsC.EXPECT().Output().Return([]byte(tc.lsOutput), tc.lsErr)
+			tc.mockSvc(logger)
+
+			fs := afero.NewMemMapFs()
+			instanceDir := mockFinchPath.LimaInstancePath()
+			if tc.annotations != nil {
+				require.NoError(t, lima.SaveInstanceMetadata(fs, instanceDir, &lima.InstanceMetadata{StopAnnotations: tc.annotations}))
+			}
+
+			fc := &config.Finch{}
+			fc.DockerCompat = true
+			err := newInfoVMAction(ncc, logger, fc, fs, instanceDir, stdout).run(tc.asJSON)
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.wantStdout, stdout.String())
+		})
+	}
+}
+
+func TestInfoVMAction_runLastRunConfig(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name          string
+		asJSON        bool
+		lastRunConfig map[string]any
+		wantErr       error
+		wantStdout    string
+	}{
+		{
+			name:          "should print the configuration recorded on the last stop",
+			asJSON:        false,
+			lastRunConfig: map[string]any{"cpus": 4, "memory": "4GiB"},
+			wantErr:       nil,
+			wantStdout: `cpus: 4
+memory: 4GiB
+`,
+		},
+		{
+			name:          "should print the configuration recorded on the last stop as JSON",
+			asJSON:        true,
+			lastRunConfig: map[string]any{"cpus": 4},
+			wantErr:       nil,
+			wantStdout: `{
+  "cpus": 4
+}
+`,
+		},
+		{
+			name:          "should return an error if no configuration was recorded",
+			asJSON:        false,
+			lastRunConfig: nil,
+			wantErr: fmt.Errorf("no configuration was recorded for the virtual machine, it's recorded when it's stopped with `finch %s stop`",
+				virtualMachineRootCmd),
+			wantStdout: "",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			stdout := &bytes.Buffer{}
+			fs := afero.NewMemMapFs()
+			instanceDir := mockFinchPath.LimaInstancePath()
+			require.NoError(t, lima.SaveInstanceMetadata(fs, instanceDir, &lima.InstanceMetadata{LastRunConfig: tc.lastRunConfig}))
+
+			err := newInfoVMAction(nil, nil, &config.Finch{}, fs, instanceDir, stdout).runLastRunConfig(tc.asJSON)
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.wantStdout, stdout.String())
+		})
+	}
+}
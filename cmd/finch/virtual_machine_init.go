@@ -27,16 +27,18 @@ func newInitVMCommand(
 	optionalDepGroups []*dependency.Group,
 	lca config.LimaConfigApplier,
 	nca config.NerdctlConfigApplier,
+	fc *config.Finch,
 	baseYamlFilePath string,
 	fs afero.Fs,
 	privateKeyPath string,
 	diskManager disk.UserDataDiskManager,
+	instanceDir string,
 ) *cobra.Command {
 	initVMCommand := &cobra.Command{
 		Use:      "init",
 		Short:    "Initialize the virtual machine",
 		RunE:     newInitVMAction(ncc, logger, optionalDepGroups, lca, baseYamlFilePath, diskManager).runAdapter,
-		PostRunE: newPostVMStartInitAction(logger, ncc, fs, privateKeyPath, nca).runAdapter,
+		PostRunE: newPostVMStartInitAction(logger, ncc, fs, privateKeyPath, instanceDir, nca, fc).runAdapter,
 	}
 
 	return initVMCommand
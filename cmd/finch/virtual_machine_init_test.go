@@ -25,7 +25,7 @@ const mockBaseYamlFilePath = "/os/os.yaml"
 func TestNewInitVMCommand(t *testing.T) {
 	t.Parallel()
 
-	cmd := newInitVMCommand(nil, nil, nil, nil, nil, "", nil, "", nil)
+	cmd := newInitVMCommand(nil, nil, nil, nil, nil, nil, "", nil, "", nil, "")
 	assert.Equal(t, cmd.Name(), "init")
 }
 
@@ -0,0 +1,105 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/runfinch/finch/pkg/command"
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/flog"
+	"github.com/runfinch/finch/pkg/lima"
+
+	"github.com/docker/go-units"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+)
+
+func newListVMCommand(
+	limaCmdCreator command.NerdctlCmdCreator,
+	logger flog.Logger,
+	fc *config.Finch,
+	fs afero.Fs,
+	stdout io.Writer,
+) *cobra.Command {
+	listVMCommand := &cobra.Command{
+		Use:     "ls",
+		Aliases: []string{"list"},
+		Short:   "List the virtual machines created by Finch",
+		Args:    cobra.NoArgs,
+		RunE:    newListVMAction(limaCmdCreator, logger, fc, fs, stdout).runAdapter,
+	}
+
+	listVMCommand.Flags().String("format", "table", `format of the list, either "table" or "json"`)
+
+	return listVMCommand
+}
+
+// vmListEntry is what `finch vm ls` reports about an instance.
+type vmListEntry struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	// Disk is the size of the disk of the instance in bytes.
+	Disk   int64  `json:"disk"`
+	Driver string `json:"driver"`
+}
+
+type listVMAction struct {
+	creator command.NerdctlCmdCreator
+	logger  flog.Logger
+	fc      *config.Finch
+	fs      afero.Fs
+	stdout  io.Writer
+}
+
+func newListVMAction(
+	creator command.NerdctlCmdCreator,
+	logger flog.Logger,
+	fc *config.Finch,
+	fs afero.Fs,
+	stdout io.Writer,
+) *listVMAction {
+	return &listVMAction{creator: creator, logger: logger, fc: fc, fs: fs, stdout: stdout}
+}
+
+func (lva *listVMAction) runAdapter(cmd *cobra.Command, _ []string) error {
+	format, err := cmd.Flags().GetString("format")
+	if err != nil {
+		return err
+	}
+	return lva.run(format)
+}
+
+func (lva *listVMAction) run(format string) error {
+	if format != "table" && format != "json" {
+		return fmt.Errorf("unsupported format %q, it must be either %q or %q", format, "table", "json")
+	}
+
+	instances, err := lima.ListFinchInstances(lva.creator, lva.fs, lva.fc.InstancePrefix)
+	if err != nil {
+		return err
+	}
+	lva.logger.Debugf("Found %d instances created by Finch", len(instances))
+	entries := make([]vmListEntry, 0, len(instances))
+	for _, inst := range instances {
+		entries = append(entries, vmListEntry{Name: inst.Name, Status: inst.Status, Disk: inst.Disk, Driver: inst.VMType})
+	}
+
+	if format == "json" {
+		enc := json.NewEncoder(lva.stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+	tw := tabwriter.NewWriter(lva.stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSTATUS\tDISK\tDRIVER")
+	for _, e := range entries {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", e.Name, e.Status, units.BytesSize(float64(e.Disk)), e.Driver)
+	}
+	return tw.Flush()
+}
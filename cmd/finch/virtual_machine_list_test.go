@@ -0,0 +1,113 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/mocks"
+)
+
+func TestNewListVMCommand(t *testing.T) {
+	t.Parallel()
+
+	cmd := newListVMCommand(nil, nil, nil, nil, nil)
+	assert.Equal(t, cmd.Name(), "ls")
+}
+
+func TestListVMAction_run(t *testing.T) {
+	t.Parallel()
+
+	const lsOutput = `{"name":"finch","status":"Running","dir":"/lima/finch","vmType":"vz","disk":53687091200}
+{"name":"finch-test","status":"Stopped","dir":"/lima/finch-test","vmType":"qemu","disk":10737418240}
+{"name":"docker","status":"Running","dir":"/lima/docker","vmType":"qemu","disk":107374182400}
+`
+
+	testCases := []struct {
+		name       string
+		format     string
+		lsOutput   string
+		lsErr      error
+		wantErr    error
+		wantStdout string
+	}{
+		{
+			name:     "should list the instances created by Finch as a table",
+			format:   "table",
+			lsOutput: lsOutput,
+			wantStdout: `NAME         STATUS    DISK    DRIVER
+finch        Running   50GiB   vz
+finch-test   Stopped   10GiB   qemu
+`,
+		},
+		{
+			name:     "should list the instances created by Finch as JSON",
+			format:   "json",
+			lsOutput: lsOutput,
+			wantStdout: `[
+  {
+    "name": "finch",
+    "status": "Running",
+    "disk": 53687091200,
+    "driver": "vz"
+  },
+  {
+    "name": "finch-test",
+    "status": "Stopped",
+    "disk": 10737418240,
+    "driver": "qemu"
+  }
+]
+`,
+		},
+		{
+			name:       "should print an empty JSON list if there is no instance",
+			format:     "json",
+			lsOutput:   "",
+			wantStdout: "[]\n",
+		},
+		{
+			name:    "should fail if the instances can't be listed",
+			format:  "table",
+			lsErr:   errors.New("exit status 1"),
+			wantErr: fmt.Errorf("failed to list the instances: %w", errors.New("exit status 1")),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			lsC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("ls", "--json").Return(lsC)
+			lsC.EXPECT().Output().Return([]byte(tc.lsOutput), tc.lsErr)
+			logger.EXPECT().Debugf("Found %d instances created by Finch", gomock.Any()).AnyTimes()
+
+			var stdout bytes.Buffer
+			err := newListVMAction(ncc, logger, &config.Finch{}, afero.NewMemMapFs(), &stdout).run(tc.format)
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.wantStdout, stdout.String())
+		})
+	}
+}
+
+func TestListVMAction_runWithUnsupportedFormat(t *testing.T) {
+	t.Parallel()
+
+	err := newListVMAction(nil, nil, nil, nil, nil).run("yaml")
+	assert.EqualError(t, err, `unsupported format "yaml", it must be either "table" or "json"`)
+}
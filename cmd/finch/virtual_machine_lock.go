@@ -0,0 +1,57 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/flog"
+	"github.com/runfinch/finch/pkg/lima"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+)
+
+// diskLock returns the lock shared by the hosts that use the same user data disk, or nil if none is configured.
+func diskLock(fs afero.Fs, fc *config.Finch) lima.DistributedLock {
+	if fc == nil || fc.Disk.LockFile == "" {
+		return nil
+	}
+	return lima.NewFileLock(fs, fc.Disk.LockFile)
+}
+
+// lockOwner identifies this Finch process to the other hosts sharing the lock.
+func lockOwner() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown host"
+	}
+	return fmt.Sprintf("%s (pid %d)", host, os.Getpid())
+}
+
+// withDiskLock makes the command hold the lock for the whole of each of its runs, so that no other host changes
+// the state of the VM using the same user data disk meanwhile. The command is left unchanged if lock is nil.
+func withDiskLock(cmd *cobra.Command, lock lima.DistributedLock, logger flog.Logger) *cobra.Command {
+	if lock == nil {
+		return cmd
+	}
+	runE := cmd.RunE
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		release, err := lock.Acquire(lockOwner())
+		if err != nil {
+			return fmt.Errorf("failed to lock the user data disk: %w", err)
+		}
+		defer func() {
+			if err := release(); err != nil {
+				logger.Warnf("Could not release the lock of the user data disk: %v", err)
+			}
+		}()
+		return runE(cmd, args)
+	}
+	return cmd
+}
@@ -0,0 +1,103 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/lima"
+	"github.com/runfinch/finch/pkg/mocks"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+const mockLockFile = "/shared/finch.lock"
+
+func TestDiskLock(t *testing.T) {
+	t.Parallel()
+
+	fs := afero.NewMemMapFs()
+	assert.Nil(t, diskLock(fs, nil))
+	assert.Nil(t, diskLock(fs, &config.Finch{}))
+	assert.NotNil(t, diskLock(fs, &config.Finch{SystemSettings: config.SystemSettings{
+		SharedSystemSettings: config.SharedSystemSettings{
+			Disk: config.DiskSettings{LockFile: mockLockFile},
+		},
+	}}))
+}
+
+func TestWithDiskLock(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		heldBy   string
+		runErr   error
+		wantErr  error
+		wantRuns int
+	}{
+		{
+			name:     "should run the command while holding the lock",
+			wantRuns: 1,
+		},
+		{
+			name:     "should release the lock when the command fails",
+			runErr:   errors.New("exit status 1"),
+			wantErr:  errors.New("exit status 1"),
+			wantRuns: 1,
+		},
+		{
+			name:     "should not run the command when another host holds the lock",
+			heldBy:   "host-b",
+			wantErr:  lima.ErrLockHeld,
+			wantRuns: 0,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			logger := mocks.NewLogger(ctrl)
+			fs := afero.NewMemMapFs()
+			if tc.heldBy != "" {
+				_, err := lima.NewFileLock(fs, mockLockFile).Acquire(tc.heldBy)
+				require.NoError(t, err)
+			}
+
+			runs := 0
+			cmd := withDiskLock(&cobra.Command{
+				Use: "stop",
+				RunE: func(*cobra.Command, []string) error {
+					runs++
+					exists, err := afero.Exists(fs, mockLockFile)
+					require.NoError(t, err)
+					assert.True(t, exists)
+					return tc.runErr
+				},
+			}, lima.NewFileLock(fs, mockLockFile), logger)
+
+			err := cmd.RunE(cmd, nil)
+			assert.Equal(t, tc.wantRuns, runs)
+			if tc.heldBy != "" {
+				require.ErrorIs(t, err, tc.wantErr)
+				assert.ErrorContains(t, err, "failed to lock the user data disk: ")
+				return
+			}
+			assert.Equal(t, tc.wantErr, err)
+			exists, err := afero.Exists(fs, mockLockFile)
+			require.NoError(t, err)
+			assert.False(t, exists)
+		})
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/runfinch/finch/pkg/command"
+	"github.com/runfinch/finch/pkg/flog"
+
+	"github.com/spf13/cobra"
+)
+
+// probeExitCodeNotReady is the exit code of finch vm probe when the container runtime isn't ready.
+const probeExitCodeNotReady = 1
+
+func newProbeVMCommand(limaCmdCreator command.NerdctlCmdCreator, logger flog.Logger, stdout io.Writer) *cobra.Command {
+	probeVMCommand := &cobra.Command{
+		Use:   "probe",
+		Short: "Check whether the container runtime in the virtual machine is ready, exiting with 0 if it is and 1 if it isn't",
+		RunE:  newProbeVMAction(limaCmdCreator, logger, stdout).runAdapter,
+	}
+
+	probeVMCommand.Flags().Duration("timeout", 0, "how long to wait for the container runtime to be ready, 0 to check it once")
+	probeVMCommand.Flags().Duration("interval", defaultVerifyPollInterval, "how often to check the container runtime while waiting for it")
+
+	return probeVMCommand
+}
+
+type probeVMAction struct {
+	creator command.NerdctlCmdCreator
+	logger  flog.Logger
+	stdout  io.Writer
+}
+
+func newProbeVMAction(creator command.NerdctlCmdCreator, logger flog.Logger, stdout io.Writer) *probeVMAction {
+	return &probeVMAction{creator: creator, logger: logger, stdout: stdout}
+}
+
+func (pva *probeVMAction) runAdapter(cmd *cobra.Command, _ []string) error {
+	timeout, err := cmd.Flags().GetDuration("timeout")
+	if err != nil {
+		return err
+	}
+	if timeout < 0 {
+		return fmt.Errorf("invalid timeout %s, it must not be negative", timeout)
+	}
+	interval, err := cmd.Flags().GetDuration("interval")
+	if err != nil {
+		return err
+	}
+	if interval <= 0 {
+		return fmt.Errorf("invalid interval %s, it must be positive", interval)
+	}
+	return pva.run(timeout, interval)
+}
+
+// run prints whether the container runtime is ready and encodes it in the exit code, for scripts to wait for it
+// after the VM is started, e.g. with `finch vm probe --timeout 1m && finch build .`.
+func (pva *probeVMAction) run(timeout, interval time.Duration) error {
+	if err := probeRuntime(pva.creator, timeout, interval); err != nil {
+		pva.logger.Debugf("The container runtime isn't ready: %v", err)
+		if _, err := fmt.Fprintln(pva.stdout, "not ready"); err != nil {
+			return err
+		}
+		return &probeExitError{code: probeExitCodeNotReady}
+	}
+	_, err := fmt.Fprintln(pva.stdout, "ready")
+	return err
+}
+
+// probeRuntime checks the container runtime every interval until it answers, and returns why it didn't
+// once timeout has passed. A timeout of 0 checks it once.
+func probeRuntime(creator command.NerdctlCmdCreator, timeout, interval time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		_, err := creator.CreateWithoutStdio("shell", limaInstanceName, "sudo", "-E", "nerdctl", "info").Output()
+		if err == nil {
+			return nil
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return err
+		}
+		time.Sleep(min(interval, remaining))
+	}
+}
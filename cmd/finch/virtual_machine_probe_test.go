@@ -0,0 +1,95 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/runfinch/finch/pkg/mocks"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestNewProbeVMCommand(t *testing.T) {
+	t.Parallel()
+
+	cmd := newProbeVMCommand(nil, nil, nil)
+	assert.Equal(t, cmd.Name(), "probe")
+}
+
+func TestProbeVMAction_run(t *testing.T) {
+	t.Parallel()
+
+	infoArgs := []any{"shell", limaInstanceName, "sudo", "-E", "nerdctl", "info"}
+
+	testCases := []struct {
+		name       string
+		timeout    time.Duration
+		wantErr    error
+		wantStdout string
+		mockSvc    func(*mocks.Logger, *mocks.NerdctlCmdCreator, *gomock.Controller)
+	}{
+		{
+			name:       "should print ready when the runtime answers",
+			timeout:    0,
+			wantErr:    nil,
+			wantStdout: "ready\n",
+			mockSvc: func(_ *mocks.Logger, creator *mocks.NerdctlCmdCreator, ctrl *gomock.Controller) {
+				infoC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio(infoArgs...).Return(infoC)
+				infoC.EXPECT().Output()
+			},
+		},
+		{
+			name:       "should wait for the runtime to answer within the timeout",
+			timeout:    time.Minute,
+			wantErr:    nil,
+			wantStdout: "ready\n",
+			mockSvc: func(_ *mocks.Logger, creator *mocks.NerdctlCmdCreator, ctrl *gomock.Controller) {
+				notReadyC := mocks.NewCommand(ctrl)
+				readyC := mocks.NewCommand(ctrl)
+				gomock.InOrder(
+					creator.EXPECT().CreateWithoutStdio(infoArgs...).Return(notReadyC),
+					creator.EXPECT().CreateWithoutStdio(infoArgs...).Return(readyC),
+				)
+				notReadyC.EXPECT().Output().Return(nil, errors.New("exit status 1"))
+				readyC.EXPECT().Output()
+			},
+		},
+		{
+			name:       "should print not ready and exit with 1 when the runtime doesn't answer",
+			timeout:    0,
+			wantErr:    &probeExitError{code: probeExitCodeNotReady},
+			wantStdout: "not ready\n",
+			mockSvc: func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, ctrl *gomock.Controller) {
+				infoC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio(infoArgs...).Return(infoC)
+				infoC.EXPECT().Output().Return(nil, errors.New("exit status 1"))
+				logger.EXPECT().Debugf("The container runtime isn't ready: %v", errors.New("exit status 1"))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+			stdout := &bytes.Buffer{}
+
+			tc.mockSvc(logger, ncc, ctrl)
+			err := newProbeVMAction(ncc, logger, stdout).run(tc.timeout, time.Millisecond)
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.wantStdout, stdout.String())
+		})
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/runfinch/finch/pkg/command"
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/dependency"
+	"github.com/runfinch/finch/pkg/disk"
+	"github.com/runfinch/finch/pkg/flog"
+	"github.com/runfinch/finch/pkg/lima"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+)
+
+func newResetVMCommand(
+	ncc command.NerdctlCmdCreator,
+	logger flog.Logger,
+	optionalDepGroups []*dependency.Group,
+	lca config.LimaConfigApplier,
+	nca config.NerdctlConfigApplier,
+	fc *config.Finch,
+	baseYamlFilePath string,
+	fs afero.Fs,
+	privateKeyPath string,
+	diskManager disk.UserDataDiskManager,
+	instanceDir string,
+) *cobra.Command {
+	resetVMCommand := &cobra.Command{
+		Use:   "reset",
+		Short: "Remove the virtual machine and its user data disk, then initialize it again from scratch",
+		RunE: newResetVMAction(
+			ncc,
+			logger,
+			diskManager,
+			newRemoveVMAction(ncc, diskManager, logger),
+			newInitVMAction(ncc, logger, optionalDepGroups, lca, baseYamlFilePath, diskManager),
+		).runAdapter,
+		PostRunE: newPostVMStartInitAction(logger, ncc, fs, privateKeyPath, instanceDir, nca, fc).runAdapter,
+	}
+
+	resetVMCommand.Flags().BoolP("yes", "y", false, "confirm that the virtual machine and all of its data can be deleted")
+
+	return resetVMCommand
+}
+
+type resetVMAction struct {
+	creator      command.NerdctlCmdCreator
+	logger       flog.Logger
+	diskManager  disk.UserDataDiskManager
+	removeAction *removeVMAction
+	initAction   *initVMAction
+}
+
+func newResetVMAction(
+	creator command.NerdctlCmdCreator,
+	logger flog.Logger,
+	diskManager disk.UserDataDiskManager,
+	removeAction *removeVMAction,
+	initAction *initVMAction,
+) *resetVMAction {
+	return &resetVMAction{
+		creator:      creator,
+		logger:       logger,
+		diskManager:  diskManager,
+		removeAction: removeAction,
+		initAction:   initAction,
+	}
+}
+
+func (rva *resetVMAction) runAdapter(cmd *cobra.Command, _ []string) error {
+	yes, err := cmd.Flags().GetBool("yes")
+	if err != nil {
+		return err
+	}
+	return rva.run(yes)
+}
+
+// run chains stop, remove and init, so that a broken environment can be recreated with a single command.
+// The user data disk is removed too, as it's where the images and containers the reset is meant to get rid of live.
+func (rva *resetVMAction) run(yes bool) error {
+	if !yes {
+		return fmt.Errorf("resetting deletes the virtual machine along with all of its images, containers and volumes, "+
+			"run `finch %s reset --yes` to confirm", virtualMachineRootCmd)
+	}
+
+	status, err := lima.Status(rva.creator, limaInstanceName)
+	var unknownStatusErr *lima.UnknownStatusError
+	if err != nil && !errors.As(err, &unknownStatusErr) {
+		return err
+	}
+
+	rva.logger.Info("Resetting Finch virtual machine...")
+	if status != lima.Nonexistent {
+		if err := rva.removeAction.removeVM(rva.needsForcedRemoval(status)); err != nil {
+			return err
+		}
+	}
+
+	rva.logger.Info("Removing the user data disk...")
+	if err := rva.diskManager.RemoveUserDataDisk(); err != nil {
+		return err
+	}
+
+	if err := rva.initAction.run(); err != nil {
+		return err
+	}
+	rva.logger.Info("Finch virtual machine reset successfully")
+	return nil
+}
+
+// needsForcedRemoval stops the instance if it's running, and reports whether it has to be forcibly removed,
+// which is the case if it couldn't be stopped, or if it's in a state that a regular remove refuses.
+func (rva *resetVMAction) needsForcedRemoval(status lima.VMStatus) bool {
+	switch status {
+	case lima.Stopped:
+		return false
+	case lima.Running:
+		rva.logger.Info("Stopping existing Finch virtual machine...")
+		if logs, err := rva.creator.CreateWithoutStdio("stop", limaInstanceName).CombinedOutput(); err != nil {
+			rva.logger.Warnf("Finch virtual machine failed to stop, it will be forcibly removed, debug logs:\n%s", logs)
+			return true
+		}
+		return false
+	default:
+		return true
+	}
+}
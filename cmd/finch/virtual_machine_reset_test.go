@@ -0,0 +1,219 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"testing"
+
+	"github.com/runfinch/finch/pkg/mocks"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestNewResetVMCommand(t *testing.T) {
+	t.Parallel()
+
+	cmd := newResetVMCommand(nil, nil, nil, nil, nil, nil, "", nil, "", nil, "")
+	assert.Equal(t, cmd.Name(), "reset")
+}
+
+func TestResetVMAction_run(t *testing.T) {
+	t.Parallel()
+
+	expectInit := func(ncc *mocks.NerdctlCmdCreator, logger *mocks.Logger, lca *mocks.LimaConfigApplier,
+		dm *mocks.UserDataDiskManager, ctrl *gomock.Controller,
+	) {
+		getVMStatusC := mocks.NewCommand(ctrl)
+		ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+		getVMStatusC.EXPECT().Output().Return([]byte(""), nil)
+		logger.EXPECT().Debugf("Status of virtual machine: %s", "")
+
+		lca.EXPECT().ConfigureDefaultLimaYaml().Return(nil)
+		lca.EXPECT().ConfigureOverrideLimaYaml().Return(nil)
+		dm.EXPECT().DetachUserDataDisk().Return(nil)
+		dm.EXPECT().EnsureUserDataDisk().Return(nil)
+
+		startC := mocks.NewCommand(ctrl)
+		ncc.EXPECT().CreateWithoutStdio("start", fmt.Sprintf("--name=%s", limaInstanceName),
+			mockBaseYamlFilePath, "--tty=false").Return(startC)
+		startC.EXPECT().CombinedOutput()
+
+		logger.EXPECT().Info("Initializing and starting Finch virtual machine...")
+		if runtime.GOOS == "windows" {
+			logger.EXPECT().Warnln("Finch on Windows uses WSL, which mounts the C Drive in read-write mode by default. " +
+				"To run finch with more restricted access, follow " +
+				"https://runfinch.com/docs/managing-finch/windows/wsl-configuration/")
+		}
+		logger.EXPECT().Info("Finch virtual machine started successfully")
+	}
+
+	testCases := []struct {
+		name    string
+		yes     bool
+		wantErr error
+		mockSvc func(
+			*mocks.NerdctlCmdCreator,
+			*mocks.Logger,
+			*mocks.LimaConfigApplier,
+			*mocks.UserDataDiskManager,
+			*gomock.Controller,
+		)
+	}{
+		{
+			name: "should refuse to reset without --yes",
+			yes:  false,
+			wantErr: fmt.Errorf("resetting deletes the virtual machine along with all of its images, containers and volumes, "+
+				"run `finch %s reset --yes` to confirm", virtualMachineRootCmd),
+			mockSvc: func(
+				_ *mocks.NerdctlCmdCreator,
+				_ *mocks.Logger,
+				_ *mocks.LimaConfigApplier,
+				_ *mocks.UserDataDiskManager,
+				_ *gomock.Controller,
+			) {
+			},
+		},
+		{
+			name:    "should stop, remove and initialize a running VM",
+			yes:     true,
+			wantErr: nil,
+			mockSvc: func(
+				ncc *mocks.NerdctlCmdCreator,
+				logger *mocks.Logger,
+				lca *mocks.LimaConfigApplier,
+				dm *mocks.UserDataDiskManager,
+				ctrl *gomock.Controller,
+			) {
+				getVMStatusC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+				getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+				logger.EXPECT().Info("Resetting Finch virtual machine...")
+
+				stopC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(stopC)
+				stopC.EXPECT().CombinedOutput()
+				logger.EXPECT().Info("Stopping existing Finch virtual machine...")
+
+				dm.EXPECT().DetachUserDataDisk().Return(nil)
+				removeC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("remove", limaInstanceName).Return(removeC)
+				removeC.EXPECT().CombinedOutput()
+				logger.EXPECT().Info("Removing existing Finch virtual machine...")
+				logger.EXPECT().Info("Finch virtual machine removed successfully")
+
+				logger.EXPECT().Info("Removing the user data disk...")
+				dm.EXPECT().RemoveUserDataDisk().Return(nil)
+
+				expectInit(ncc, logger, lca, dm, ctrl)
+				logger.EXPECT().Info("Finch virtual machine reset successfully")
+			},
+		},
+		{
+			name:    "should forcibly remove a VM that fails to stop",
+			yes:     true,
+			wantErr: errors.New("remove failed"),
+			mockSvc: func(
+				ncc *mocks.NerdctlCmdCreator,
+				logger *mocks.Logger,
+				_ *mocks.LimaConfigApplier,
+				dm *mocks.UserDataDiskManager,
+				ctrl *gomock.Controller,
+			) {
+				getVMStatusC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+				getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+				logger.EXPECT().Info("Resetting Finch virtual machine...")
+
+				stopC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(stopC)
+				stopC.EXPECT().CombinedOutput().Return([]byte("stop logs"), errors.New("stop failed"))
+				logger.EXPECT().Info("Stopping existing Finch virtual machine...")
+				logger.EXPECT().Warnf("Finch virtual machine failed to stop, it will be forcibly removed, debug logs:\n%s",
+					[]byte("stop logs"))
+
+				dm.EXPECT().DetachUserDataDisk().Return(nil)
+				removeC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("remove", "--force", limaInstanceName).Return(removeC)
+				removeC.EXPECT().CombinedOutput().Return([]byte("remove logs"), errors.New("remove failed"))
+				logger.EXPECT().Info("Forcibly removing Finch virtual machine...")
+				logger.EXPECT().Errorf("Finch virtual machine failed to remove, debug logs:\n%s", []byte("remove logs"))
+			},
+		},
+		{
+			name:    "should forcibly remove a broken VM",
+			yes:     true,
+			wantErr: nil,
+			mockSvc: func(
+				ncc *mocks.NerdctlCmdCreator,
+				logger *mocks.Logger,
+				lca *mocks.LimaConfigApplier,
+				dm *mocks.UserDataDiskManager,
+				ctrl *gomock.Controller,
+			) {
+				getVMStatusC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+				getVMStatusC.EXPECT().Output().Return([]byte("Broken"), nil)
+				logger.EXPECT().Info("Resetting Finch virtual machine...")
+
+				dm.EXPECT().DetachUserDataDisk().Return(nil)
+				removeC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("remove", "--force", limaInstanceName).Return(removeC)
+				removeC.EXPECT().CombinedOutput()
+				logger.EXPECT().Info("Forcibly removing Finch virtual machine...")
+				logger.EXPECT().Info("Finch virtual machine removed successfully")
+
+				logger.EXPECT().Info("Removing the user data disk...")
+				dm.EXPECT().RemoveUserDataDisk().Return(nil)
+
+				expectInit(ncc, logger, lca, dm, ctrl)
+				logger.EXPECT().Info("Finch virtual machine reset successfully")
+			},
+		},
+		{
+			name:    "should fail if the user data disk can't be removed",
+			yes:     true,
+			wantErr: errors.New("remove disk failed"),
+			mockSvc: func(
+				ncc *mocks.NerdctlCmdCreator,
+				logger *mocks.Logger,
+				_ *mocks.LimaConfigApplier,
+				dm *mocks.UserDataDiskManager,
+				ctrl *gomock.Controller,
+			) {
+				getVMStatusC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+				getVMStatusC.EXPECT().Output().Return([]byte(""), nil)
+				logger.EXPECT().Info("Resetting Finch virtual machine...")
+
+				logger.EXPECT().Info("Removing the user data disk...")
+				dm.EXPECT().RemoveUserDataDisk().Return(errors.New("remove disk failed"))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+			lca := mocks.NewLimaConfigApplier(ctrl)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+
+			tc.mockSvc(ncc, logger, lca, dm, ctrl)
+
+			action := newResetVMAction(ncc, logger, dm, newRemoveVMAction(ncc, dm, logger),
+				newInitVMAction(ncc, logger, nil, lca, mockBaseYamlFilePath, dm))
+			err := action.run(tc.yes)
+			assert.Equal(t, tc.wantErr, err)
+		})
+	}
+}
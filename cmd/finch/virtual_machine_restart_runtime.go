@@ -0,0 +1,91 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/runfinch/finch/pkg/command"
+	"github.com/runfinch/finch/pkg/flog"
+	"github.com/runfinch/finch/pkg/lima"
+
+	"github.com/spf13/cobra"
+)
+
+// runtimeServices are the services of the container runtime in the guest, in the order they are restarted.
+var runtimeServices = []string{"containerd.service", "buildkit.service"}
+
+func newRestartRuntimeVMCommand(limaCmdCreator command.NerdctlCmdCreator, logger flog.Logger) *cobra.Command {
+	restartRuntimeVMCommand := &cobra.Command{
+		Use:   "restart-runtime",
+		Short: "Restart the container runtime in the virtual machine, without restarting the virtual machine",
+		RunE:  newRestartRuntimeVMAction(limaCmdCreator, logger).runAdapter,
+	}
+
+	restartRuntimeVMCommand.Flags().Duration("timeout", defaultVerifyTimeout,
+		"how long to wait for the container runtime to be ready after it's restarted")
+
+	return restartRuntimeVMCommand
+}
+
+type restartRuntimeVMAction struct {
+	creator command.NerdctlCmdCreator
+	logger  flog.Logger
+}
+
+func newRestartRuntimeVMAction(creator command.NerdctlCmdCreator, logger flog.Logger) *restartRuntimeVMAction {
+	return &restartRuntimeVMAction{creator: creator, logger: logger}
+}
+
+func (rva *restartRuntimeVMAction) runAdapter(cmd *cobra.Command, _ []string) error {
+	timeout, err := cmd.Flags().GetDuration("timeout")
+	if err != nil {
+		return err
+	}
+	if timeout <= 0 {
+		return fmt.Errorf("invalid timeout %s, it must be positive", timeout)
+	}
+	return rva.run(timeout)
+}
+
+func (rva *restartRuntimeVMAction) run(timeout time.Duration) error {
+	status, err := lima.GetVMStatus(rva.creator, rva.logger, limaInstanceName)
+	if err != nil {
+		return err
+	}
+	switch status {
+	case lima.Running:
+	case lima.Nonexistent:
+		return fmt.Errorf("the instance %q does not exist, run `finch %s init` to create a new instance",
+			limaInstanceName, virtualMachineRootCmd)
+	default:
+		return fmt.Errorf("the instance %q is not running, run `finch %s start` to start the instance",
+			limaInstanceName, virtualMachineRootCmd)
+	}
+
+	rva.logger.Info("Restarting the container runtime...")
+	args := append([]string{"shell", limaInstanceName, "sudo", "systemctl", "restart"}, runtimeServices...)
+	logs, err := rva.creator.CreateWithoutStdio(args...).CombinedOutput()
+	if err != nil {
+		rva.logger.Errorf("Finch container runtime failed to restart, debug logs:\n%s", logs)
+		return err
+	}
+	if err := rva.waitForRuntime(timeout); err != nil {
+		return err
+	}
+	rva.logger.Info("Finch container runtime restarted successfully")
+	return nil
+}
+
+// waitForRuntime waits for the container runtime to be ready, as systemd considers containerd started
+// before it serves requests, and gives up after timeout.
+func (rva *restartRuntimeVMAction) waitForRuntime(timeout time.Duration) error {
+	if err := probeRuntime(rva.creator, timeout, defaultVerifyPollInterval); err != nil {
+		return fmt.Errorf("the container runtime isn't ready %s after it was restarted: %w", timeout, err)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/runfinch/finch/pkg/mocks"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestNewRestartRuntimeVMCommand(t *testing.T) {
+	t.Parallel()
+
+	cmd := newRestartRuntimeVMCommand(nil, nil)
+	assert.Equal(t, cmd.Name(), "restart-runtime")
+}
+
+func TestRestartRuntimeVMAction_run(t *testing.T) {
+	t.Parallel()
+
+	restartArgs := []any{"shell", limaInstanceName, "sudo", "systemctl", "restart", "containerd.service", "buildkit.service"}
+	infoArgs := []any{"shell", limaInstanceName, "sudo", "-E", "nerdctl", "info"}
+
+	testCases := []struct {
+		name    string
+		timeout time.Duration
+		wantErr error
+		mockSvc func(*mocks.Logger, *mocks.NerdctlCmdCreator, *gomock.Controller)
+	}{
+		{
+			name:    "should restart the runtime and wait for it to be ready",
+			timeout: time.Minute,
+			mockSvc: func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, ctrl *gomock.Controller) {
+				getVMStatusC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+				getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+				logger.EXPECT().Debugf("Status of virtual machine: %s", "Running")
+				logger.EXPECT().Info("Restarting the container runtime...")
+				restartC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio(restartArgs...).Return(restartC)
+				restartC.EXPECT().CombinedOutput()
+				notReadyC := mocks.NewCommand(ctrl)
+				readyC := mocks.NewCommand(ctrl)
+				gomock.InOrder(
+					creator.EXPECT().CreateWithoutStdio(infoArgs...).Return(notReadyC),
+					creator.EXPECT().CreateWithoutStdio(infoArgs...).Return(readyC),
+				)
+				notReadyC.EXPECT().Output().Return(nil, errors.New("exit status 1"))
+				readyC.EXPECT().Output()
+				logger.EXPECT().Info("Finch container runtime restarted successfully")
+			},
+		},
+		{
+			name:    "should fail when the runtime isn't ready in time",
+			timeout: time.Nanosecond,
+			wantErr: fmt.Errorf("the container runtime isn't ready %s after it was restarted: %w", time.Nanosecond,
+				errors.New("exit status 1")),
+			mockSvc: func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, ctrl *gomock.Controller) {
+				getVMStatusC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+				getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+				logger.EXPECT().Debugf("Status of virtual machine: %s", "Running")
+				logger.EXPECT().Info("Restarting the container runtime...")
+				restartC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio(restartArgs...).Return(restartC)
+				restartC.EXPECT().CombinedOutput()
+				infoC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio(infoArgs...).Return(infoC)
+				infoC.EXPECT().Output().Return(nil, errors.New("exit status 1"))
+			},
+		},
+		{
+			name:    "should print the logs if the runtime failed to restart",
+			timeout: time.Minute,
+			wantErr: errors.New("exit status 1"),
+			mockSvc: func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, ctrl *gomock.Controller) {
+				getVMStatusC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+				getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+				logger.EXPECT().Debugf("Status of virtual machine: %s", "Running")
+				logger.EXPECT().Info("Restarting the container runtime...")
+				logs := []byte("Job for containerd.service failed")
+				restartC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio(restartArgs...).Return(restartC)
+				restartC.EXPECT().CombinedOutput().Return(logs, errors.New("exit status 1"))
+				logger.EXPECT().Errorf("Finch container runtime failed to restart, debug logs:\n%s", logs)
+			},
+		},
+		{
+			name:    "stopped VM",
+			timeout: time.Minute,
+			wantErr: fmt.Errorf("the instance %q is not running, run `finch %s start` to start the instance",
+				limaInstanceName, virtualMachineRootCmd),
+			mockSvc: func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, ctrl *gomock.Controller) {
+				getVMStatusC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+				getVMStatusC.EXPECT().Output().Return([]byte("Stopped"), nil)
+				logger.EXPECT().Debugf("Status of virtual machine: %s", "Stopped")
+			},
+		},
+		{
+			name:    "nonexistent VM",
+			timeout: time.Minute,
+			wantErr: fmt.Errorf("the instance %q does not exist, run `finch %s init` to create a new instance",
+				limaInstanceName, virtualMachineRootCmd),
+			mockSvc: func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, ctrl *gomock.Controller) {
+				getVMStatusC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+				getVMStatusC.EXPECT().Output().Return([]byte(""), nil)
+				logger.EXPECT().Debugf("Status of virtual machine: %s", "")
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+
+			tc.mockSvc(logger, ncc, ctrl)
+			err := newRestartRuntimeVMAction(ncc, logger).run(tc.timeout)
+			assert.Equal(t, tc.wantErr, err)
+		})
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/runfinch/finch/pkg/flog"
+	"github.com/runfinch/finch/pkg/socketproxy"
+
+	"github.com/spf13/cobra"
+)
+
+func newServeSocketVMCommand(logger flog.Logger) *cobra.Command {
+	return &cobra.Command{
+		Use:    serveSocketCmd + " <path>",
+		Args:   cobra.ExactArgs(1),
+		Hidden: true,
+		Short:  "Hold the connections to the host socket of a stopped instance until it's started again",
+		RunE:   newServeSocketVMAction(logger).runAdapter,
+	}
+}
+
+type serveSocketVMAction struct {
+	logger flog.Logger
+}
+
+func newServeSocketVMAction(logger flog.Logger) *serveSocketVMAction {
+	return &serveSocketVMAction{logger: logger}
+}
+
+func (ssa *serveSocketVMAction) runAdapter(cmd *cobra.Command, args []string) error {
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return ssa.run(ctx, args[0])
+}
+
+// run serves the host socket until the next start of the instance replaces it, see socketproxy.Proxy.Serve.
+// It's started by finch vm stop --keep-alive-socket, and stops holding the connections when it's terminated.
+func (ssa *serveSocketVMAction) run(ctx context.Context, path string) error {
+	err := socketproxy.New(path, ssa.logger).Serve(ctx)
+	if errors.Is(err, context.Canceled) {
+		ssa.logger.Infof("Stopped holding the connections to %q", path)
+		return nil
+	}
+	return err
+}
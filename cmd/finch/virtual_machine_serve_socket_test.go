@@ -0,0 +1,61 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin
+
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/runfinch/finch/pkg/mocks"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestServeSocketVMAction_run(t *testing.T) {
+	t.Parallel()
+
+	t.Run("should stop holding the connections once terminated", func(t *testing.T) {
+		t.Parallel()
+
+		dir, err := os.MkdirTemp("", "ss")
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = os.RemoveAll(dir) })
+		path := filepath.Join(dir, "finch.sock")
+
+		ctrl := gomock.NewController(t)
+		logger := mocks.NewLogger(ctrl)
+		logger.EXPECT().Infof("Holding the connections to %q until the instance is started again", path)
+		logger.EXPECT().Infof("Stopped holding the connections to %q", path)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		require.NoError(t, newServeSocketVMAction(logger).run(ctx, path))
+		_, err = os.Stat(path)
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+
+	t.Run("should fail if the socket is already served", func(t *testing.T) {
+		t.Parallel()
+
+		dir, err := os.MkdirTemp("", "ss")
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = os.RemoveAll(dir) })
+		path := filepath.Join(dir, "finch.sock")
+		l, err := net.Listen("unix", path)
+		require.NoError(t, err)
+		defer l.Close() //nolint:errcheck // closing the listener in a test
+
+		ctrl := gomock.NewController(t)
+		logger := mocks.NewLogger(ctrl)
+		err = newServeSocketVMAction(logger).run(context.Background(), path)
+		assert.ErrorContains(t, err, "is already served")
+	})
+}
@@ -7,6 +7,10 @@ package main
 
 import (
 	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/runfinch/finch/pkg/disk"
 
@@ -16,6 +20,7 @@ import (
 	"github.com/runfinch/finch/pkg/flog"
 	"github.com/runfinch/finch/pkg/lima"
 
+	"github.com/docker/go-units"
 	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
 )
@@ -26,16 +31,40 @@ func newStartVMCommand(
 	optionalDepGroups []*dependency.Group,
 	lca config.LimaConfigApplier,
 	nca config.NerdctlConfigApplier,
+	fc *config.Finch,
 	fs afero.Fs,
 	privateKeyPath string,
 	dm disk.UserDataDiskManager,
+	instanceDir string,
+	ecc command.Creator,
 ) *cobra.Command {
-	return &cobra.Command{
+	startVMCommand := &cobra.Command{
 		Use:      "start",
 		Short:    "Start the virtual machine",
-		RunE:     newStartVMAction(ncc, logger, optionalDepGroups, lca, dm).runAdapter,
-		PostRunE: newPostVMStartInitAction(logger, ncc, fs, privateKeyPath, nca).runAdapter,
+		RunE:     newStartVMAction(ncc, logger, optionalDepGroups, lca, dm, fs, instanceDir, ecc).runAdapter,
+		PostRunE: newPostVMStartInitAction(logger, ncc, fs, privateKeyPath, instanceDir, nca, fc).runAdapter,
 	}
+
+	startVMCommand.Flags().Bool("skip-preflight", false, "skip checking that the host has enough free disk space")
+	startVMCommand.Flags().String("from-snapshot", "",
+		"restore the snapshot with the given tag, taken with finch vm stop --tag, before starting")
+	startVMCommand.Flags().Bool("no-time-sync", false, "do not synchronize the guest clock with the host once started")
+	startVMCommand.Flags().Bool("no-safe-mode", false,
+		"do not clean up what the last stops left behind before starting, even though they failed repeatedly")
+	startVMCommand.Flags().Bool("restore-session", false,
+		"restart the containers that were running when the VM was stopped with finch vm stop --record-session")
+	addGuestSSHFlags(startVMCommand)
+
+	return startVMCommand
+}
+
+// startVMOptions holds the options of a single `finch vm start` invocation.
+type startVMOptions struct {
+	skipPreflight  bool
+	fromSnapshot   string
+	noTimeSync     bool
+	noSafeMode     bool
+	restoreSession bool
 }
 
 type startVMAction struct {
@@ -44,6 +73,9 @@ type startVMAction struct {
 	optionalDepGroups   []*dependency.Group
 	limaConfigApplier   config.LimaConfigApplier
 	userDataDiskManager disk.UserDataDiskManager
+	fs                  afero.Fs
+	instanceDir         string
+	ecc                 command.Creator
 }
 
 func newStartVMAction(
@@ -52,6 +84,9 @@ func newStartVMAction(
 	optionalDepGroups []*dependency.Group,
 	lca config.LimaConfigApplier,
 	dm disk.UserDataDiskManager,
+	fs afero.Fs,
+	instanceDir string,
+	ecc command.Creator,
 ) *startVMAction {
 	return &startVMAction{
 		creator:             creator,
@@ -59,18 +94,56 @@ func newStartVMAction(
 		optionalDepGroups:   optionalDepGroups,
 		limaConfigApplier:   lca,
 		userDataDiskManager: dm,
+		fs:                  fs,
+		instanceDir:         instanceDir,
+		ecc:                 ecc,
 	}
 }
 
-func (sva *startVMAction) runAdapter(_ *cobra.Command, _ []string) error {
-	return sva.run()
+func (sva *startVMAction) runAdapter(cmd *cobra.Command, _ []string) error {
+	skipPreflight, err := cmd.Flags().GetBool("skip-preflight")
+	if err != nil {
+		return err
+	}
+	fromSnapshot, err := cmd.Flags().GetString("from-snapshot")
+	if err != nil {
+		return err
+	}
+	noTimeSync, err := cmd.Flags().GetBool("no-time-sync")
+	if err != nil {
+		return err
+	}
+	noSafeMode, err := cmd.Flags().GetBool("no-safe-mode")
+	if err != nil {
+		return err
+	}
+	restoreSession, err := cmd.Flags().GetBool("restore-session")
+	if err != nil {
+		return err
+	}
+	sva.creator, err = targetGuestSSH(cmd, sva.creator, sva.ecc, sva.fs, sva.logger)
+	if err != nil {
+		return err
+	}
+	return sva.run(startVMOptions{
+		skipPreflight:  skipPreflight,
+		fromSnapshot:   fromSnapshot,
+		noTimeSync:     noTimeSync,
+		noSafeMode:     noSafeMode,
+		restoreSession: restoreSession,
+	})
 }
 
-func (sva *startVMAction) run() error {
+func (sva *startVMAction) run(opts startVMOptions) error {
 	err := sva.assertVMIsStopped(sva.creator, sva.logger)
 	if err != nil {
 		return err
 	}
+	if !opts.skipPreflight {
+		if err := sva.checkHostDiskSpace(); err != nil {
+			return err
+		}
+	}
 	err = dependency.InstallOptionalDeps(sva.optionalDepGroups, sva.logger)
 	if err != nil {
 		sva.logger.Errorf("Dependency error: %v", err)
@@ -81,14 +154,39 @@ func (sva *startVMAction) run() error {
 		return err
 	}
 
+	md, err := lima.LoadInstanceMetadata(sva.fs, sva.instanceDir)
+	if err != nil {
+		sva.logger.Warnf("Could not load the instance metadata: %v", err)
+		md = &lima.InstanceMetadata{}
+	}
+
+	if md.StopFailures >= safeModeStopFailures {
+		if opts.noSafeMode {
+			sva.logger.Warnf("The last %d stops of the instance failed, starting without recovering from them", md.StopFailures)
+		} else {
+			sva.recoverFromStopFailures(md.StopFailures)
+		}
+	}
+
 	// TODO: don't run this on Windows
 	err = sva.userDataDiskManager.EnsureUserDataDisk()
 	if err != nil {
 		return err
 	}
 
+	if opts.fromSnapshot != "" {
+		if err := sva.restoreSnapshot(md, opts.fromSnapshot); err != nil {
+			return err
+		}
+	}
+
 	limaCmd := sva.creator.CreateWithoutStdio("start", limaInstanceName)
-	sva.logger.Info("Starting existing Finch virtual machine...")
+	if md.Hibernated {
+		// Lima restores the saved VM state instead of cold-booting when there is one.
+		sva.logger.Info("Resuming hibernated Finch virtual machine...")
+	} else {
+		sva.logger.Info("Starting existing Finch virtual machine...")
+	}
 	logs, err := limaCmd.CombinedOutput()
 	if err != nil {
 		sva.logger.SetFormatter(flog.TextWithoutTruncation)
@@ -96,6 +194,34 @@ func (sva *startVMAction) run() error {
 		sva.logger.SetFormatter(flog.Text)
 		return err
 	}
+
+	if !opts.noTimeSync {
+		sva.syncGuestClock()
+	}
+	if len(md.CheckpointedContainers) > 0 {
+		sva.restoreContainers(md.CheckpointedContainers)
+	}
+	if opts.restoreSession {
+		switch {
+		case md.Hibernated:
+			// The containers keep running in the resumed VM, recreating them would restart them.
+			sva.logger.Info("The containers of the recorded session were resumed with the VM, not recreating them")
+		case len(md.Session) == 0:
+			sva.logger.Info("No session was recorded by finch vm stop --record-session, there are no containers to restore")
+		default:
+			sva.restoreSession(md.Session)
+		}
+	}
+
+	if md.Hibernated {
+		if err := lima.UpdateInstanceMetadata(sva.fs, sva.instanceDir, func(md *lima.InstanceMetadata) {
+			md.Hibernated = false
+		}); err != nil {
+			sva.logger.Warnf("Could not clear the hibernation marker of the instance: %v", err)
+		}
+		sva.logger.Info("Finch virtual machine resumed successfully")
+		return nil
+	}
 	sva.logger.Info("Finch virtual machine started successfully")
 	return nil
 }
@@ -115,3 +241,119 @@ func (sva *startVMAction) assertVMIsStopped(creator command.NerdctlCmdCreator, l
 		return nil
 	}
 }
+
+// restoreContainers restores the containers checkpointed by finch vm stop --preserve-containers-state.
+// A container that can't be restored is left stopped, and its checkpoint is kept for it to be restored by hand.
+// The containers are only restored once, so that a broken checkpoint doesn't fail every start.
+func (sva *startVMAction) restoreContainers(containers []string) {
+	sva.logger.Infof("Restoring %d checkpointed containers...", len(containers))
+	for _, container := range containers {
+		out, err := sva.creator.CreateWithoutStdio("shell", limaInstanceName, "sudo", "-E", "nerdctl", "start",
+			"--checkpoint", containersCheckpointName, container).CombinedOutput()
+		if err != nil {
+			sva.logger.Warnf("Could not restore the container %s from its checkpoint %q: %v, command output: %s",
+				container, containersCheckpointName, err, out)
+		}
+	}
+	if err := lima.UpdateInstanceMetadata(sva.fs, sva.instanceDir, func(md *lima.InstanceMetadata) {
+		md.CheckpointedContainers = nil
+	}); err != nil {
+		sva.logger.Warnf("Could not clear the checkpointed containers of the instance: %v", err)
+	}
+}
+
+// safeModeStopFailures is the number of stops failed in a row after which finch vm start runs in safe mode.
+const safeModeStopFailures = 3
+
+// recoverFromStopFailures is the safe mode of the start, for an instance whose last stops failed: whatever they left
+// behind, e.g. the processes of the VM or the user data disk still attached, is forcibly cleaned up before starting.
+// The clean up is best-effort, the start goes on and reports the problems that remain.
+func (sva *startVMAction) recoverFromStopFailures(failures int) {
+	sva.logger.Warnf("The last %d stops of the instance failed, starting in safe mode to recover from them...", failures)
+	// On a stopped instance, a forced stop kills what's left of its processes and removes its stale sockets and PID files.
+	if logs, err := sva.creator.CreateWithoutStdio("stop", "--force", limaInstanceName).CombinedOutput(); err != nil {
+		sva.logger.Warnf("Could not clean up the processes of the instance: %v, debug logs:\n%s", err, logs)
+	}
+	if err := sva.userDataDiskManager.DetachUserDataDisk(); err != nil {
+		sva.logger.Warnf("Could not detach the user data disk: %v", err)
+	}
+	if err := lima.UpdateInstanceMetadata(sva.fs, sva.instanceDir, func(md *lima.InstanceMetadata) {
+		md.StopFailures = 0
+	}); err != nil {
+		sva.logger.Warnf("Could not clear the stop failures of the instance: %v", err)
+	}
+}
+
+// syncGuestClock steps the guest clock to the time of the host.
+// The guest loses track of time while it's hibernated or the host is suspended,
+// which makes e.g. TLS certificates look invalid. This is best-effort and never fails the start.
+func (sva *startVMAction) syncGuestClock() {
+	drift, err := sva.guestClockDrift()
+	if err != nil {
+		sva.logger.Warnf("Could not read the guest clock: %v", err)
+		return
+	}
+
+	out, err := sva.creator.CreateWithoutStdio("shell", limaInstanceName, "sudo", "chronyc", "makestep").CombinedOutput()
+	if err != nil {
+		sva.logger.Debugf("Could not step the guest clock with chrony, falling back to hwclock: %v, command output: %s", err, out)
+		out, err = sva.creator.CreateWithoutStdio("shell", limaInstanceName, "sudo", "hwclock", "--hctosys").CombinedOutput()
+		if err != nil {
+			sva.logger.Warnf("Could not synchronize the guest clock: %v, command output: %s", err, out)
+			return
+		}
+	}
+
+	if drift.Abs() < time.Second {
+		sva.logger.Debugf("Synchronized the guest clock, which was off by %s", drift)
+		return
+	}
+	sva.logger.Infof("Synchronized the guest clock, which was off by %s", drift)
+}
+
+// guestClockDrift returns how far ahead of the host the guest clock is, negative if it's behind.
+func (sva *startVMAction) guestClockDrift() (time.Duration, error) {
+	before := time.Now()
+	out, err := sva.creator.CreateWithoutStdio("shell", limaInstanceName, "date", "+%s.%N").Output()
+	if err != nil {
+		return 0, err
+	}
+	// The guest read its clock somewhere in between, halfway is the best guess.
+	host := before.Add(time.Since(before) / 2)
+
+	secs, err := strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse the guest time %q: %w", out, err)
+	}
+	guest := time.Unix(0, int64(secs*float64(time.Second)))
+	return guest.Sub(host).Round(time.Millisecond), nil
+}
+
+// checkHostDiskSpace makes sure that the user data disk can grow to its full size,
+// as the VM fails in obscure ways once the host runs out of disk space.
+func (sva *startVMAction) checkHostDiskSpace() error {
+	required, available, err := sva.userDataDiskManager.UserDataDiskSpace()
+	if err != nil {
+		return err
+	}
+	if available < required {
+		return fmt.Errorf("insufficient host disk space: need %s, have %s",
+			units.BytesSize(float64(required)), units.BytesSize(float64(available)))
+	}
+	return nil
+}
+
+// restoreSnapshot reverts the instance to a snapshot taken with `finch vm stop --tag`.
+func (sva *startVMAction) restoreSnapshot(md *lima.InstanceMetadata, tag string) error {
+	if !slices.Contains(md.Snapshots, tag) {
+		return fmt.Errorf("the snapshot %q does not exist", tag)
+	}
+
+	sva.logger.Infof("Restoring snapshot %q of the Finch virtual machine...", tag)
+	logs, err := sva.creator.CreateWithoutStdio("snapshot", "apply", limaInstanceName, "--tag", tag).CombinedOutput()
+	if err != nil {
+		sva.logger.Errorf("Finch virtual machine failed to restore the snapshot, debug logs:\n%s", logs)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"slices"
+
+	"github.com/runfinch/finch/pkg/lima"
+)
+
+// restoreSession restores the containers recorded by finch vm stop --record-session: a container that is still there
+// is started again, one that was removed since is recreated. A container that can't be restored is skipped.
+// The session is only restored once, so that a container that can't be restored doesn't fail every start.
+func (sva *startVMAction) restoreSession(session []lima.SessionContainer) {
+	sva.logger.Infof("Restoring the %d containers of the recorded session...", len(session))
+	for _, c := range session {
+		inspectArgs := sessionNerdctlArgs(c, "container", "inspect", "--format", "{{.ID}}", c.Name)
+		if _, err := sva.creator.CreateWithoutStdio(inspectArgs...).Output(); err == nil {
+			if out, err := sva.creator.CreateWithoutStdio(sessionNerdctlArgs(c, "start", c.Name)...).CombinedOutput(); err != nil {
+				sva.logger.Warnf("Could not start the container %s: %v, command output: %s", c.Name, err, out)
+			}
+			continue
+		}
+		if out, err := sva.creator.CreateWithoutStdio(sessionNerdctlArgs(c, sessionRunArgs(c)...)...).CombinedOutput(); err != nil {
+			sva.logger.Warnf("Could not recreate the container %s: %v, command output: %s", c.Name, err, out)
+		}
+	}
+	if err := lima.UpdateInstanceMetadata(sva.fs, sva.instanceDir, func(md *lima.InstanceMetadata) {
+		md.Session = nil
+	}); err != nil {
+		sva.logger.Warnf("Could not clear the recorded session of the instance: %v", err)
+	}
+}
+
+// sessionNerdctlArgs returns the arguments of limactl running nerdctl with args in the namespace of the container.
+func sessionNerdctlArgs(c lima.SessionContainer, args ...string) []string {
+	nerdctlArgs := []string{"shell", limaInstanceName, "sudo", "-E", "nerdctl"}
+	if c.Namespace != "" {
+		nerdctlArgs = append(nerdctlArgs, "--namespace", c.Namespace)
+	}
+	return append(nerdctlArgs, args...)
+}
+
+// sessionRunArgs returns the arguments of nerdctl recreating the container. Like with Docker, only the first element
+// of the entrypoint can be passed with --entrypoint, the others are passed before the command.
+func sessionRunArgs(c lima.SessionContainer) []string {
+	args := []string{"run", "--detach", "--name", c.Name}
+	for _, port := range c.Ports {
+		args = append(args, "--publish", port)
+	}
+	cmd := c.Cmd
+	if len(c.Entrypoint) > 0 {
+		args = append(args, "--entrypoint", c.Entrypoint[0])
+		cmd = append(slices.Clone(c.Entrypoint[1:]), cmd...)
+	}
+	args = append(args, c.Image)
+	return append(args, cmd...)
+}
@@ -0,0 +1,154 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/runfinch/finch/pkg/lima"
+	"github.com/runfinch/finch/pkg/mocks"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestStartVMAction_runRestoresSession(t *testing.T) {
+	t.Parallel()
+
+	session := []lima.SessionContainer{
+		{
+			Name:       "web",
+			Image:      "nginx:latest",
+			Entrypoint: []string{"/docker-entrypoint.sh", "--verbose"},
+			Cmd:        []string{"nginx", "-g", "daemon off;"},
+			Ports:      []string{"127.0.0.1:8080:80/tcp"},
+		},
+		{
+			Namespace: "jobs",
+			Name:      "worker",
+			Image:     "busybox:latest",
+			Cmd:       []string{"sleep", "infinity"},
+		},
+	}
+
+	testCases := []struct {
+		name        string
+		md          *lima.InstanceMetadata
+		mockSvc     func(*mocks.NerdctlCmdCreator, *mocks.Logger, *gomock.Controller)
+		wantSession []lima.SessionContainer
+	}{
+		{
+			name: "should start the recorded containers again and recreate the removed ones",
+			md:   &lima.InstanceMetadata{Session: session},
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, logger *mocks.Logger, ctrl *gomock.Controller) {
+				logger.EXPECT().Info("Starting existing Finch virtual machine...")
+				logger.EXPECT().Infof("Restoring the %d containers of the recorded session...", 2)
+				inspectWebC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "sudo", "-E", "nerdctl", "container", "inspect",
+					"--format", "{{.ID}}", "web").Return(inspectWebC)
+				inspectWebC.EXPECT().Output().Return([]byte("abc\n"), nil)
+				startWebC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "sudo", "-E", "nerdctl", "start", "web").Return(startWebC)
+				startWebC.EXPECT().CombinedOutput().Return([]byte("web\n"), nil)
+				inspectWorkerC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "sudo", "-E", "nerdctl", "--namespace", "jobs",
+					"container", "inspect", "--format", "{{.ID}}", "worker").Return(inspectWorkerC)
+				inspectWorkerC.EXPECT().Output().Return(nil, errors.New("exit status 1"))
+				runWorkerC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "sudo", "-E", "nerdctl", "--namespace", "jobs", "run",
+					"--detach", "--name", "worker", "busybox:latest", "sleep", "infinity").Return(runWorkerC)
+				runWorkerC.EXPECT().CombinedOutput().Return([]byte("image not found"), errors.New("exit status 1"))
+				logger.EXPECT().Warnf("Could not recreate the container %s: %v, command output: %s", "worker",
+					errors.New("exit status 1"), []byte("image not found"))
+				logger.EXPECT().Info("Finch virtual machine started successfully")
+			},
+		},
+		{
+			name: "should recreate a removed container with its entrypoint and ports, and skip the one that can't be started",
+			md:   &lima.InstanceMetadata{Session: session},
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, logger *mocks.Logger, ctrl *gomock.Controller) {
+				logger.EXPECT().Info("Starting existing Finch virtual machine...")
+				logger.EXPECT().Infof("Restoring the %d containers of the recorded session...", 2)
+				inspectWebC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "sudo", "-E", "nerdctl", "container", "inspect",
+					"--format", "{{.ID}}", "web").Return(inspectWebC)
+				inspectWebC.EXPECT().Output().Return(nil, errors.New("exit status 1"))
+				runWebC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "sudo", "-E", "nerdctl", "run", "--detach", "--name", "web",
+					"--publish", "127.0.0.1:8080:80/tcp", "--entrypoint", "/docker-entrypoint.sh", "nginx:latest", "--verbose", "nginx",
+					"-g", "daemon off;").Return(runWebC)
+				runWebC.EXPECT().CombinedOutput().Return([]byte("abc\n"), nil)
+				inspectWorkerC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "sudo", "-E", "nerdctl", "--namespace", "jobs",
+					"container", "inspect", "--format", "{{.ID}}", "worker").Return(inspectWorkerC)
+				inspectWorkerC.EXPECT().Output().Return([]byte("def\n"), nil)
+				startWorkerC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "sudo", "-E", "nerdctl", "--namespace", "jobs", "start",
+					"worker").Return(startWorkerC)
+				startWorkerC.EXPECT().CombinedOutput().Return([]byte("mount failed"), errors.New("exit status 1"))
+				logger.EXPECT().Warnf("Could not start the container %s: %v, command output: %s", "worker",
+					errors.New("exit status 1"), []byte("mount failed"))
+				logger.EXPECT().Info("Finch virtual machine started successfully")
+			},
+		},
+		{
+			name: "should not recreate the containers resumed with a hibernated VM",
+			md:   &lima.InstanceMetadata{Hibernated: true, Session: session},
+			mockSvc: func(_ *mocks.NerdctlCmdCreator, logger *mocks.Logger, _ *gomock.Controller) {
+				logger.EXPECT().Info("Resuming hibernated Finch virtual machine...")
+				logger.EXPECT().Info("The containers of the recorded session were resumed with the VM, not recreating them")
+				logger.EXPECT().Info("Finch virtual machine resumed successfully")
+			},
+			wantSession: session,
+		},
+		{
+			name: "should start without a recorded session",
+			md:   &lima.InstanceMetadata{},
+			mockSvc: func(_ *mocks.NerdctlCmdCreator, logger *mocks.Logger, _ *gomock.Controller) {
+				logger.EXPECT().Info("Starting existing Finch virtual machine...")
+				logger.EXPECT().Info("No session was recorded by finch vm stop --record-session, there are no containers to restore")
+				logger.EXPECT().Info("Finch virtual machine started successfully")
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+			lca := mocks.NewLimaConfigApplier(ctrl)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			fs := afero.NewMemMapFs()
+			instanceDir := mockFinchPath.LimaInstancePath()
+
+			require.NoError(t, lima.SaveInstanceMetadata(fs, instanceDir, tc.md))
+			getVMStatusC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+			getVMStatusC.EXPECT().Output().Return([]byte("Stopped"), nil)
+			logger.EXPECT().Debugf("Status of virtual machine: %s", "Stopped")
+			lca.EXPECT().ConfigureOverrideLimaYaml().Return(nil)
+			dm.EXPECT().EnsureUserDataDisk().Return(nil)
+			startC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("start", limaInstanceName).Return(startC)
+			startC.EXPECT().CombinedOutput()
+			tc.mockSvc(ncc, logger, ctrl)
+
+			err := newStartVMAction(ncc, logger, nil, lca, dm, fs, instanceDir, nil).run(
+				startVMOptions{skipPreflight: true, noTimeSync: true, restoreSession: true})
+			require.NoError(t, err)
+
+			md, err := lima.LoadInstanceMetadata(fs, instanceDir)
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantSession, md.Session)
+		})
+	}
+}
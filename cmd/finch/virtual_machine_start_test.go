@@ -9,20 +9,24 @@ import (
 	"errors"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/runfinch/finch/pkg/dependency"
 	"github.com/runfinch/finch/pkg/flog"
+	"github.com/runfinch/finch/pkg/lima"
 	"github.com/runfinch/finch/pkg/mocks"
 
+	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/mock/gomock"
 )
 
 func TestNewStartVMCommand(t *testing.T) {
 	t.Parallel()
 
-	cmd := newStartVMCommand(nil, nil, nil, nil, nil, nil, "", nil)
+	cmd := newStartVMCommand(nil, nil, nil, nil, nil, nil, nil, "", nil, "", nil)
 	assert.Equal(t, cmd.Name(), "start")
 }
 
@@ -46,9 +50,16 @@ func TestStartVMAction_runAdapter(t *testing.T) {
 		{
 			name:    "should start instance",
 			wantErr: nil,
-			command: &cobra.Command{
-				Use: "start",
-			},
+			command: func() *cobra.Command {
+				c := &cobra.Command{
+					Use: "start",
+				}
+				c.Flags().Bool("skip-preflight", false, "")
+				c.Flags().String("from-snapshot", "", "")
+				c.Flags().Bool("no-time-sync", false, "")
+				addGuestSSHFlags(c)
+				return c
+			}(),
 			groups: func(ctrl *gomock.Controller) []*dependency.Group {
 				dep := mocks.NewDependency(ctrl)
 				deps := dependency.NewGroup([]dependency.Dependency{dep}, "", "")
@@ -73,6 +84,8 @@ func TestStartVMAction_runAdapter(t *testing.T) {
 				getVMStatusC.EXPECT().Output().Return([]byte("Stopped"), nil)
 				logger.EXPECT().Debugf("Status of virtual machine: %s", "Stopped")
 
+				dm.EXPECT().UserDataDiskSpace().Return(uint64(50), uint64(100), nil)
+
 				lca.EXPECT().ConfigureOverrideLimaYaml().Return(nil)
 
 				dm.EXPECT().EnsureUserDataDisk().Return(nil)
@@ -82,6 +95,15 @@ func TestStartVMAction_runAdapter(t *testing.T) {
 				ncc.EXPECT().CreateWithoutStdio("start", limaInstanceName).Return(command)
 
 				logger.EXPECT().Info("Starting existing Finch virtual machine...")
+
+				dateC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "date", "+%s.%N").Return(dateC)
+				dateC.EXPECT().Output().Return([]byte(fmt.Sprintf("%d.000000000\n", time.Now().Unix())), nil)
+				chronyC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "sudo", "chronyc", "makestep").Return(chronyC)
+				chronyC.EXPECT().CombinedOutput()
+				logger.EXPECT().Debugf("Synchronized the guest clock, which was off by %s", gomock.Any())
+
 				logger.EXPECT().Info("Finch virtual machine started successfully")
 			},
 		},
@@ -100,7 +122,8 @@ func TestStartVMAction_runAdapter(t *testing.T) {
 			groups := tc.groups(ctrl)
 			tc.mockSvc(ncc, logger, lca, dm, ctrl)
 
-			err := newStartVMAction(ncc, logger, groups, lca, dm).runAdapter(tc.command, tc.args)
+			err := newStartVMAction(ncc, logger, groups, lca, dm, afero.NewMemMapFs(), mockFinchPath.LimaInstancePath(), nil).runAdapter(
+				tc.command, tc.args)
 			assert.Equal(t, tc.wantErr, err)
 		})
 	}
@@ -359,8 +382,398 @@ func TestStartVMAction_run(t *testing.T) {
 			groups := tc.groups(ctrl)
 			tc.mockSvc(ncc, logger, lca, dm, ctrl)
 
-			err := newStartVMAction(ncc, logger, groups, lca, dm).run()
+			action := newStartVMAction(ncc, logger, groups, lca, dm, afero.NewMemMapFs(), mockFinchPath.LimaInstancePath(), nil)
+			err := action.run(startVMOptions{skipPreflight: true, noTimeSync: true})
 			assert.Equal(t, err, tc.wantErr)
 		})
 	}
 }
+
+func TestStartVMAction_runHibernated(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	logger := mocks.NewLogger(ctrl)
+	ncc := mocks.NewNerdctlCmdCreator(ctrl)
+	lca := mocks.NewLimaConfigApplier(ctrl)
+	dm := mocks.NewUserDataDiskManager(ctrl)
+	fs := afero.NewMemMapFs()
+	instanceDir := mockFinchPath.LimaInstancePath()
+
+	require.NoError(t, lima.SaveInstanceMetadata(fs, instanceDir, &lima.InstanceMetadata{Hibernated: true}))
+
+	getVMStatusC := mocks.NewCommand(ctrl)
+	ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+	getVMStatusC.EXPECT().Output().Return([]byte("Stopped"), nil)
+	logger.EXPECT().Debugf("Status of virtual machine: %s", "Stopped")
+	lca.EXPECT().ConfigureOverrideLimaYaml().Return(nil)
+	dm.EXPECT().EnsureUserDataDisk().Return(nil)
+
+	command := mocks.NewCommand(ctrl)
+	command.EXPECT().CombinedOutput()
+	ncc.EXPECT().CreateWithoutStdio("start", limaInstanceName).Return(command)
+	logger.EXPECT().Info("Resuming hibernated Finch virtual machine...")
+	logger.EXPECT().Info("Finch virtual machine resumed successfully")
+
+	err := newStartVMAction(ncc, logger, nil, lca, dm, fs, instanceDir, nil).run(startVMOptions{skipPreflight: true, noTimeSync: true})
+	require.NoError(t, err)
+
+	md, err := lima.LoadInstanceMetadata(fs, instanceDir)
+	require.NoError(t, err)
+	assert.False(t, md.Hibernated)
+}
+
+func TestStartVMAction_runRestoresCheckpointedContainers(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	logger := mocks.NewLogger(ctrl)
+	ncc := mocks.NewNerdctlCmdCreator(ctrl)
+	lca := mocks.NewLimaConfigApplier(ctrl)
+	dm := mocks.NewUserDataDiskManager(ctrl)
+	fs := afero.NewMemMapFs()
+	instanceDir := mockFinchPath.LimaInstancePath()
+
+	require.NoError(t, lima.SaveInstanceMetadata(fs, instanceDir, &lima.InstanceMetadata{CheckpointedContainers: []string{"c1", "c2"}}))
+
+	getVMStatusC := mocks.NewCommand(ctrl)
+	ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+	getVMStatusC.EXPECT().Output().Return([]byte("Stopped"), nil)
+	logger.EXPECT().Debugf("Status of virtual machine: %s", "Stopped")
+	lca.EXPECT().ConfigureOverrideLimaYaml().Return(nil)
+	dm.EXPECT().EnsureUserDataDisk().Return(nil)
+
+	command := mocks.NewCommand(ctrl)
+	command.EXPECT().CombinedOutput()
+	ncc.EXPECT().CreateWithoutStdio("start", limaInstanceName).Return(command)
+	logger.EXPECT().Info("Starting existing Finch virtual machine...")
+	logger.EXPECT().Infof("Restoring %d checkpointed containers...", 2)
+	restoreC1 := mocks.NewCommand(ctrl)
+	ncc.EXPECT().CreateWithoutStdio(
+		"shell", limaInstanceName, "sudo", "-E", "nerdctl", "start", "--checkpoint", "finch-vm-stop", "c1",
+	).Return(restoreC1)
+	restoreC1.EXPECT().CombinedOutput().Return([]byte("c1\n"), nil)
+	restoreC2 := mocks.NewCommand(ctrl)
+	ncc.EXPECT().CreateWithoutStdio(
+		"shell", limaInstanceName, "sudo", "-E", "nerdctl", "start", "--checkpoint", "finch-vm-stop", "c2",
+	).Return(restoreC2)
+	restoreC2.EXPECT().CombinedOutput().Return([]byte("no such checkpoint"), errors.New("exit status 1"))
+	logger.EXPECT().Warnf("Could not restore the container %s from its checkpoint %q: %v, command output: %s",
+		"c2", "finch-vm-stop", errors.New("exit status 1"), []byte("no such checkpoint"))
+	logger.EXPECT().Info("Finch virtual machine started successfully")
+
+	err := newStartVMAction(ncc, logger, nil, lca, dm, fs, instanceDir, nil).run(startVMOptions{skipPreflight: true, noTimeSync: true})
+	require.NoError(t, err)
+
+	md, err := lima.LoadInstanceMetadata(fs, instanceDir)
+	require.NoError(t, err)
+	assert.Empty(t, md.CheckpointedContainers)
+}
+
+func TestStartVMAction_runSafeMode(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name         string
+		stopFailures int
+		noSafeMode   bool
+		mockSvc      func(*mocks.Logger, *mocks.NerdctlCmdCreator, *mocks.UserDataDiskManager, *gomock.Controller)
+		wantFailures int
+	}{
+		{
+			name:         "should clean up before starting after repeated stop failures",
+			stopFailures: 3,
+			mockSvc: func(logger *mocks.Logger, ncc *mocks.NerdctlCmdCreator, dm *mocks.UserDataDiskManager, ctrl *gomock.Controller) {
+				logger.EXPECT().Warnf("The last %d stops of the instance failed, starting in safe mode to recover from them...", 3)
+				forceStopC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("stop", "--force", limaInstanceName).Return(forceStopC)
+				forceStopC.EXPECT().CombinedOutput()
+				dm.EXPECT().DetachUserDataDisk().Return(nil)
+			},
+			wantFailures: 0,
+		},
+		{
+			name:         "should not clean up with --no-safe-mode",
+			stopFailures: 3,
+			noSafeMode:   true,
+			mockSvc: func(logger *mocks.Logger, _ *mocks.NerdctlCmdCreator, _ *mocks.UserDataDiskManager, _ *gomock.Controller) {
+				logger.EXPECT().Warnf("The last %d stops of the instance failed, starting without recovering from them", 3)
+			},
+			wantFailures: 3,
+		},
+		{
+			name:         "should not clean up below the threshold",
+			stopFailures: 2,
+			mockSvc:      func(*mocks.Logger, *mocks.NerdctlCmdCreator, *mocks.UserDataDiskManager, *gomock.Controller) {},
+			wantFailures: 2,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+			lca := mocks.NewLimaConfigApplier(ctrl)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			fs := afero.NewMemMapFs()
+			instanceDir := mockFinchPath.LimaInstancePath()
+			require.NoError(t, lima.SaveInstanceMetadata(fs, instanceDir, &lima.InstanceMetadata{StopFailures: tc.stopFailures}))
+
+			getVMStatusC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+			getVMStatusC.EXPECT().Output().Return([]byte("Stopped"), nil)
+			logger.EXPECT().Debugf("Status of virtual machine: %s", "Stopped")
+			lca.EXPECT().ConfigureOverrideLimaYaml().Return(nil)
+			tc.mockSvc(logger, ncc, dm, ctrl)
+			dm.EXPECT().EnsureUserDataDisk().Return(nil)
+			startC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("start", limaInstanceName).Return(startC)
+			startC.EXPECT().CombinedOutput()
+			logger.EXPECT().Info("Starting existing Finch virtual machine...")
+			logger.EXPECT().Info("Finch virtual machine started successfully")
+
+			err := newStartVMAction(ncc, logger, nil, lca, dm, fs, instanceDir, nil).run(
+				startVMOptions{skipPreflight: true, noTimeSync: true, noSafeMode: tc.noSafeMode})
+			require.NoError(t, err)
+
+			md, err := lima.LoadInstanceMetadata(fs, instanceDir)
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantFailures, md.StopFailures)
+		})
+	}
+}
+
+func TestStartVMAction_runPreflight(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name      string
+		wantErr   error
+		required  uint64
+		available uint64
+		spaceErr  error
+	}{
+		{
+			name:      "should refuse to start if the host doesn't have enough disk space",
+			wantErr:   errors.New("insufficient host disk space: need 50GiB, have 10GiB"),
+			required:  50 * 1024 * 1024 * 1024,
+			available: 10 * 1024 * 1024 * 1024,
+			spaceErr:  nil,
+		},
+		{
+			name:      "should return an error if the host disk space can't be checked",
+			wantErr:   errors.New("statfs error"),
+			required:  0,
+			available: 0,
+			spaceErr:  errors.New("statfs error"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+			lca := mocks.NewLimaConfigApplier(ctrl)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+
+			getVMStatusC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+			getVMStatusC.EXPECT().Output().Return([]byte("Stopped"), nil)
+			logger.EXPECT().Debugf("Status of virtual machine: %s", "Stopped")
+			dm.EXPECT().UserDataDiskSpace().Return(tc.required, tc.available, tc.spaceErr)
+
+			action := newStartVMAction(ncc, logger, nil, lca, dm, afero.NewMemMapFs(), mockFinchPath.LimaInstancePath(), nil)
+			err := action.run(startVMOptions{})
+			assert.Equal(t, tc.wantErr, err)
+		})
+	}
+}
+
+func TestStartVMAction_runFromSnapshot(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name      string
+		wantErr   error
+		snapshots []string
+		mockSvc   func(ncc *mocks.NerdctlCmdCreator, logger *mocks.Logger, ctrl *gomock.Controller)
+	}{
+		{
+			name:      "should restore the snapshot before starting the instance",
+			wantErr:   nil,
+			snapshots: []string{"clean"},
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, logger *mocks.Logger, ctrl *gomock.Controller) {
+				logger.EXPECT().Infof("Restoring snapshot %q of the Finch virtual machine...", "clean")
+				applyC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("snapshot", "apply", limaInstanceName, "--tag", "clean").Return(applyC)
+				applyC.EXPECT().CombinedOutput()
+
+				command := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("start", limaInstanceName).Return(command)
+				command.EXPECT().CombinedOutput()
+				logger.EXPECT().Info("Starting existing Finch virtual machine...")
+				logger.EXPECT().Info("Finch virtual machine started successfully")
+			},
+		},
+		{
+			name:      "should refuse to restore an unknown snapshot",
+			wantErr:   fmt.Errorf("the snapshot %q does not exist", "clean"),
+			snapshots: []string{"other"},
+			mockSvc:   func(_ *mocks.NerdctlCmdCreator, _ *mocks.Logger, _ *gomock.Controller) {},
+		},
+		{
+			name:      "should not start the instance if the snapshot can't be restored",
+			wantErr:   errors.New("error"),
+			snapshots: []string{"clean"},
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, logger *mocks.Logger, ctrl *gomock.Controller) {
+				logger.EXPECT().Infof("Restoring snapshot %q of the Finch virtual machine...", "clean")
+				applyC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("snapshot", "apply", limaInstanceName, "--tag", "clean").Return(applyC)
+				logs := []byte("stdout + stderr")
+				applyC.EXPECT().CombinedOutput().Return(logs, errors.New("error"))
+				logger.EXPECT().Errorf("Finch virtual machine failed to restore the snapshot, debug logs:\n%s", logs)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+			lca := mocks.NewLimaConfigApplier(ctrl)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			fs := afero.NewMemMapFs()
+			instanceDir := mockFinchPath.LimaInstancePath()
+
+			require.NoError(t, lima.SaveInstanceMetadata(fs, instanceDir, &lima.InstanceMetadata{Snapshots: tc.snapshots}))
+
+			getVMStatusC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+			getVMStatusC.EXPECT().Output().Return([]byte("Stopped"), nil)
+			logger.EXPECT().Debugf("Status of virtual machine: %s", "Stopped")
+			lca.EXPECT().ConfigureOverrideLimaYaml().Return(nil)
+			dm.EXPECT().EnsureUserDataDisk().Return(nil)
+			tc.mockSvc(ncc, logger, ctrl)
+
+			action := newStartVMAction(ncc, logger, nil, lca, dm, fs, instanceDir, nil)
+			err := action.run(startVMOptions{skipPreflight: true, fromSnapshot: "clean", noTimeSync: true})
+			assert.Equal(t, tc.wantErr, err)
+		})
+	}
+}
+
+func TestStartVMAction_runSyncsGuestClock(t *testing.T) {
+	t.Parallel()
+
+	dateArgs := []any{"shell", limaInstanceName, "date", "+%s.%N"}
+	chronyArgs := []any{"shell", limaInstanceName, "sudo", "chronyc", "makestep"}
+	hwclockArgs := []any{"shell", limaInstanceName, "sudo", "hwclock", "--hctosys"}
+	hourAgo := func() []byte {
+		return []byte(fmt.Sprintf("%d.500000000\n", time.Now().Add(-time.Hour).Unix()))
+	}
+
+	testCases := []struct {
+		name    string
+		mockSvc func(ncc *mocks.NerdctlCmdCreator, logger *mocks.Logger, ctrl *gomock.Controller)
+	}{
+		{
+			name: "should step the guest clock with chrony",
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, logger *mocks.Logger, ctrl *gomock.Controller) {
+				dateC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(dateArgs...).Return(dateC)
+				dateC.EXPECT().Output().Return(hourAgo(), nil)
+				chronyC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(chronyArgs...).Return(chronyC)
+				chronyC.EXPECT().CombinedOutput()
+				logger.EXPECT().Infof("Synchronized the guest clock, which was off by %s", gomock.Any()).Do(
+					func(_ string, args ...any) {
+						drift, ok := args[0].(time.Duration)
+						require.True(t, ok)
+						assert.InDelta(t, -time.Hour, drift, float64(2*time.Second))
+					})
+			},
+		},
+		{
+			name: "should fall back to hwclock if chrony fails",
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, logger *mocks.Logger, ctrl *gomock.Controller) {
+				dateC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(dateArgs...).Return(dateC)
+				dateC.EXPECT().Output().Return(hourAgo(), nil)
+				chronyC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(chronyArgs...).Return(chronyC)
+				logs := []byte("sudo: chronyc: command not found")
+				chronyC.EXPECT().CombinedOutput().Return(logs, errors.New("exit status 1"))
+				logger.EXPECT().Debugf("Could not step the guest clock with chrony, falling back to hwclock: %v, command output: %s",
+					errors.New("exit status 1"), logs)
+				hwclockC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(hwclockArgs...).Return(hwclockC)
+				hwclockC.EXPECT().CombinedOutput()
+				logger.EXPECT().Infof("Synchronized the guest clock, which was off by %s", gomock.Any())
+			},
+		},
+		{
+			name: "should only warn if the guest clock can't be synchronized",
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, logger *mocks.Logger, ctrl *gomock.Controller) {
+				dateC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(dateArgs...).Return(dateC)
+				dateC.EXPECT().Output().Return(hourAgo(), nil)
+				chronyC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(chronyArgs...).Return(chronyC)
+				chronyC.EXPECT().CombinedOutput().Return(nil, errors.New("exit status 1"))
+				logger.EXPECT().Debugf("Could not step the guest clock with chrony, falling back to hwclock: %v, command output: %s",
+					gomock.Any(), gomock.Any())
+				hwclockC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(hwclockArgs...).Return(hwclockC)
+				logs := []byte("hwclock: Cannot access the Hardware Clock via any known method.")
+				hwclockC.EXPECT().CombinedOutput().Return(logs, errors.New("exit status 1"))
+				logger.EXPECT().Warnf("Could not synchronize the guest clock: %v, command output: %s", errors.New("exit status 1"), logs)
+			},
+		},
+		{
+			name: "should only warn if the guest clock can't be read",
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, logger *mocks.Logger, ctrl *gomock.Controller) {
+				dateC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(dateArgs...).Return(dateC)
+				dateC.EXPECT().Output().Return(nil, errors.New("ssh error"))
+				logger.EXPECT().Warnf("Could not read the guest clock: %v", errors.New("ssh error"))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+			lca := mocks.NewLimaConfigApplier(ctrl)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+
+			getVMStatusC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+			getVMStatusC.EXPECT().Output().Return([]byte("Stopped"), nil)
+			logger.EXPECT().Debugf("Status of virtual machine: %s", "Stopped")
+			lca.EXPECT().ConfigureOverrideLimaYaml().Return(nil)
+			dm.EXPECT().EnsureUserDataDisk().Return(nil)
+			startC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("start", limaInstanceName).Return(startC)
+			startC.EXPECT().CombinedOutput()
+			logger.EXPECT().Info("Starting existing Finch virtual machine...")
+			logger.EXPECT().Info("Finch virtual machine started successfully")
+			tc.mockSvc(ncc, logger, ctrl)
+
+			action := newStartVMAction(ncc, logger, nil, lca, dm, afero.NewMemMapFs(), mockFinchPath.LimaInstancePath(), nil)
+			assert.NoError(t, action.run(startVMOptions{skipPreflight: true}))
+		})
+	}
+}
@@ -13,33 +13,99 @@ import (
 	"github.com/runfinch/finch/pkg/flog"
 	"github.com/runfinch/finch/pkg/lima"
 
+	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
 )
 
-func newStatusVMCommand(limaCmdCreator command.NerdctlCmdCreator, logger flog.Logger, stdout io.Writer) *cobra.Command {
+func newStatusVMCommand(limaCmdCreator command.NerdctlCmdCreator, logger flog.Logger, fs afero.Fs, stdout io.Writer) *cobra.Command {
 	statusVMCommand := &cobra.Command{
 		Use:   "status",
 		Short: "Status of the virtual machine",
-		RunE:  newStatusVMAction(limaCmdCreator, logger, stdout).runAdapter,
+		RunE:  newStatusVMAction(limaCmdCreator, logger, fs, stdout).runAdapter,
 	}
 
+	statusVMCommand.Flags().String("lima-home", "", "path to the Lima home the instance is in, if not the one of Finch")
+	statusVMCommand.Flags().Bool("probe-only", false,
+		"print nothing and exit with 0 if running, 2 if stopped, 3 if nonexistent, 4 if broken, 1 otherwise")
+
 	return statusVMCommand
 }
 
 type statusVMAction struct {
 	creator command.NerdctlCmdCreator
 	logger  flog.Logger
+	fs      afero.Fs
 	stdout  io.Writer
 }
 
-func newStatusVMAction(creator command.NerdctlCmdCreator, logger flog.Logger, stdout io.Writer) *statusVMAction {
-	return &statusVMAction{creator: creator, logger: logger, stdout: stdout}
+func newStatusVMAction(creator command.NerdctlCmdCreator, logger flog.Logger, fs afero.Fs, stdout io.Writer) *statusVMAction {
+	return &statusVMAction{creator: creator, logger: logger, fs: fs, stdout: stdout}
 }
 
-func (sva *statusVMAction) runAdapter(_ *cobra.Command, _ []string) error {
+func (sva *statusVMAction) runAdapter(cmd *cobra.Command, _ []string) error {
+	limaHome, err := cmd.Flags().GetString("lima-home")
+	if err != nil {
+		return err
+	}
+	if limaHome != "" {
+		creator, err := targetLimaHome(sva.creator, sva.fs, sva.logger, limaHome)
+		if err != nil {
+			return err
+		}
+		sva.creator = creator
+	}
+	probeOnly, err := cmd.Flags().GetBool("probe-only")
+	if err != nil {
+		return err
+	}
+	if probeOnly {
+		return sva.probe()
+	}
 	return sva.run()
 }
 
+// Exit codes of finch vm status --probe-only, a running VM exits with 0.
+const (
+	probeExitCodeUnknown     = 1
+	probeExitCodeStopped     = 2
+	probeExitCodeNonexistent = 3
+	probeExitCodeBroken      = 4
+)
+
+// probeExitError makes finch exit with code, without printing anything.
+type probeExitError struct {
+	code int
+}
+
+func (e *probeExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.code)
+}
+
+// ExitCode implements errutil.ExitCoder, which main exits with.
+func (e *probeExitError) ExitCode() int {
+	return e.code
+}
+
+// probe encodes the status of the VM in the exit code, for scripts to check it with e.g. `if finch vm status --probe-only`.
+func (sva *statusVMAction) probe() error {
+	status, err := lima.Status(sva.creator, limaInstanceName)
+	if err != nil {
+		return &probeExitError{code: probeExitCodeUnknown}
+	}
+	switch status {
+	case lima.Running:
+		return nil
+	case lima.Stopped:
+		return &probeExitError{code: probeExitCodeStopped}
+	case lima.Nonexistent:
+		return &probeExitError{code: probeExitCodeNonexistent}
+	case lima.Broken:
+		return &probeExitError{code: probeExitCodeBroken}
+	default:
+		return &probeExitError{code: probeExitCodeUnknown}
+	}
+}
+
 func (sva *statusVMAction) run() error {
 	status, err := lima.GetVMStatus(sva.creator, sva.logger, limaInstanceName)
 	if err != nil {
@@ -20,7 +20,7 @@ import (
 func TestNewStatusVMCommand(t *testing.T) {
 	t.Parallel()
 
-	cmd := newStatusVMCommand(nil, nil, nil)
+	cmd := newStatusVMCommand(nil, nil, nil, nil)
 	assert.Equal(t, cmd.Name(), "status")
 }
 
@@ -69,8 +69,9 @@ func TestStatusVMAction_runAdapter(t *testing.T) {
 			lca := mocks.NewLimaConfigApplier(ctrl)
 
 			tc.mockSvc(ncc, logger, lca, ctrl)
+			tc.command.Flags().String("lima-home", "", "")
 
-			assert.NoError(t, newStatusVMAction(ncc, logger, &stdout).runAdapter(tc.command, tc.args))
+			assert.NoError(t, newStatusVMAction(ncc, logger, nil, &stdout).runAdapter(tc.command, tc.args))
 		})
 	}
 }
@@ -182,9 +183,74 @@ func TestStatusVMAction_run(t *testing.T) {
 
 			tc.mockSvc(ncc, logger, lca, ctrl)
 
-			err := newStatusVMAction(ncc, logger, &stdout).run()
+			err := newStatusVMAction(ncc, logger, nil, &stdout).run()
 			assert.Equal(t, err, tc.wantErr)
 			assert.Equal(t, tc.wantStatusOutput, stdout.String())
 		})
 	}
 }
+
+func TestStatusVMAction_probe(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name    string
+		status  []byte
+		err     error
+		wantErr error
+	}{
+		{
+			name:    "running VM",
+			status:  []byte("Running"),
+			wantErr: nil,
+		},
+		{
+			name:    "stopped VM",
+			status:  []byte("Stopped"),
+			wantErr: &probeExitError{code: 2},
+		},
+		{
+			name:    "nonexistent VM",
+			status:  []byte(""),
+			wantErr: &probeExitError{code: 3},
+		},
+		{
+			name:    "broken VM",
+			status:  []byte("Broken"),
+			wantErr: &probeExitError{code: 4},
+		},
+		{
+			name:    "unknown VM status",
+			status:  []byte("Starting"),
+			wantErr: &probeExitError{code: 1},
+		},
+		{
+			name:    "status command returns an error",
+			status:  []byte("fatal error"),
+			err:     errors.New("get status error"),
+			wantErr: &probeExitError{code: 1},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			logger := mocks.NewLogger(ctrl)
+			stdout := bytes.Buffer{}
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+			getVMStatusC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+			getVMStatusC.EXPECT().Output().Return(tc.status, tc.err)
+
+			cmd := newStatusVMCommand(ncc, logger, nil, &stdout)
+			cmd.SetArgs([]string{"--probe-only"})
+			cmd.SilenceErrors = true
+			cmd.SilenceUsage = true
+			err := cmd.Execute()
+			assert.Equal(t, tc.wantErr, err)
+			assert.Empty(t, stdout.String())
+		})
+	}
+}
@@ -6,97 +6,2014 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/template"
+	"time"
 
+	"github.com/runfinch/finch/pkg/audit"
 	"github.com/runfinch/finch/pkg/command"
+	"github.com/runfinch/finch/pkg/config"
 	"github.com/runfinch/finch/pkg/disk"
 	"github.com/runfinch/finch/pkg/flog"
+	"github.com/runfinch/finch/pkg/fmemory"
 	"github.com/runfinch/finch/pkg/lima"
+	"github.com/runfinch/finch/pkg/path"
 
+	"github.com/docker/go-units"
+	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
 )
 
-func newStopVMCommand(limaCmdCreator command.NerdctlCmdCreator, diskManager disk.UserDataDiskManager, logger flog.Logger) *cobra.Command {
+func newStopVMCommand(
+	limaCmdCreator command.NerdctlCmdCreator,
+	ecc command.Creator,
+	diskManager disk.UserDataDiskManager,
+	logger flog.Logger,
+	fs afero.Fs,
+	fp path.Finch,
+	fc *config.Finch,
+	finchRootPath string,
+	stdin io.Reader,
+	stdout io.Writer,
+	output io.Writer,
+) *cobra.Command {
 	stopVMCommand := &cobra.Command{
 		Use:   "stop",
 		Short: "Stop the virtual machine",
-		RunE:  newStopVMAction(limaCmdCreator, diskManager, logger).runAdapter,
+		RunE:  newStopVMAction(limaCmdCreator, ecc, diskManager, logger, fs, fp, fc, finchRootPath, stdin, stdout, output).runAdapter,
 	}
 
 	stopVMCommand.Flags().BoolP("force", "f", false, "forcibly stop finch VM")
+	stopVMCommand.Flags().String("instance-file", "",
+		"path to a file listing the instances to stop, one per line (blank lines and # comments are ignored)")
+	stopVMCommand.Flags().Bool("include-foreign", false, "also stop the instances listed in --instance-file that weren't created by Finch")
+	stopVMCommand.Flags().Bool("confirm-running-containers", true,
+		"ask for confirmation before stopping a VM with running containers when run interactively")
+	stopVMCommand.Flags().BoolP("yes", "y", false, "do not ask for confirmation")
+	stopVMCommand.Flags().Bool("rotate-disk", false,
+		"archive the user data disk of the Finch VM once stopped and start the next time with an empty one, requires --yes")
+	stopVMCommand.Flags().String("pre-snapshot", "",
+		"snapshot the user data disk of the Finch VM under the given name before stopping it, list them with finch vm disk snapshots "+
+			"(macOS only)")
+	stopVMCommand.Flags().Bool("ignore-snapshot-errors", false, "stop the VM even if the snapshot of --pre-snapshot fails")
+	stopVMCommand.Flags().Bool("report-containers", false, "print the running containers the stop affects before stopping the VM")
+	stopVMCommand.Flags().Bool("flush-writes", true,
+		"flush the pending writes of the guest, e.g. to the bind mounts, before stopping it and report how much was flushed")
+	stopVMCommand.Flags().Bool("hibernate", false, "save the VM state to disk and restore it on the next start (vz only)")
+	stopVMCommand.Flags().String("tag", "",
+		"take a snapshot with the given tag once the VM is stopped, restore it with finch vm start --from-snapshot (qemu only)")
+	stopVMCommand.Flags().String("post-stop-command", "", "command to run with the host shell once the VM is stopped")
+	stopVMCommand.Flags().Bool("ignore-hook-errors", false, "do not fail if the post-stop command fails")
+	stopVMCommand.Flags().String("report-to", "", "URL of a webhook to post the result of the stop to, overrides stop.reportTo")
+	stopVMCommand.Flags().String("collect-metrics-to", "",
+		"path to a JSON lines file to append the timings of the stop to, relative to --output-dir or ~/.finch")
+	stopVMCommand.Flags().Int("max-attempts", 1, "number of times to try stopping a VM that is still running after a failed attempt")
+	stopVMCommand.Flags().Bool("summary", false, "print a JSON summary of each stop, the one posted with --report-to")
+	stopVMCommand.Flags().String("output-format", summaryFormatJSON,
+		`format of the summary printed with --summary, either "json" or "yaml"`)
+	stopVMCommand.Flags().Bool("json", false,
+		"print whether each VM was running and what was done as JSON, and succeed if it was already stopped")
+	stopVMCommand.Flags().Bool("since-boot-only", false, "only stop the instances Finch started since the host booted")
+	stopVMCommand.Flags().String("wait-for-container", "", "wait for the container with this name or ID to exit before stopping the VM")
+	stopVMCommand.Flags().Duration("wait-for-container-timeout", 0,
+		"how long to wait for the container of --wait-for-container to exit before failing the stop, 0 for no limit")
+	stopVMCommand.Flags().Duration("pull-timeout", time.Minute,
+		"how long to wait for the image pulls in progress to finish before stopping the VM, 0 to not wait")
+	stopVMCommand.Flags().Duration("drain-timeout", 0,
+		"how long the running containers get to exit before the VM is stopped, after which they are killed, 0 to not drain them")
+	stopVMCommand.Flags().Bool("drain-webhook", false,
+		"POST to the URL in the finch.drain-url label of each running container for it to prepare before the VM is stopped")
+	stopVMCommand.Flags().Bool("preserve-containers-state", false,
+		"checkpoint the running containers instead of stopping them, and restore them on the next start (needs CRIU in the VM)")
+	stopVMCommand.Flags().Bool("record-session", false,
+		"record the running containers, for finch vm start --restore-session to restart them")
+	stopVMCommand.Flags().String("namespace", "",
+		"containerd namespace of the containers to drain, overrides stop.drainNamespace (default the namespace of Finch)")
+	stopVMCommand.Flags().Duration("timeout", 0, "how long limactl gets to stop the VM, 0 for no limit")
+	stopVMCommand.Flags().Duration("grace-period", defaultGracePeriod,
+		"how long the VM gets to stop cleanly before it's forcibly stopped, 0 to stop it forcibly right away like --force "+
+			"(ignored with --timeout unless set)")
+	stopVMCommand.Flags().Duration("if-idle-for", 0, "only stop the VM if none of its containers has run within the given duration")
+	stopVMCommand.Flags().String("disk-detach-order", diskDetachSerial,
+		`how the disks are detached, "serial" one after the other in reverse order, or "parallel" to detach the disks `+
+			"not listed in disk.detachOrder concurrently")
+	stopVMCommand.Flags().Int("disk-detach-workers", defaultDiskDetachWorkers,
+		"maximum number of disks detached concurrently with --disk-detach-order=parallel")
+	stopVMCommand.Flags().Bool("probe-only-if-exists", false,
+		"succeed without doing anything if the instance doesn't exist, and stop it as usual otherwise")
+	stopVMCommand.Flags().Bool("uninstall", false,
+		"stop the VM, then remove it along with its host socket, for the uninstallers (needs --yes)")
+	stopVMCommand.Flags().Bool("remove-user-data", false,
+		"with --uninstall, also remove the user data disk, which holds the images, containers and volumes")
+	stopVMCommand.Flags().Bool("keep-socket", false,
+		"keep the host socket of the Docker-compatible API once the VM is stopped instead of removing it")
+	stopVMCommand.Flags().Bool("keep-alive-socket", false,
+		"keep accepting connections on the host socket of the Docker-compatible API once the VM is stopped, they're served "+
+			"as soon as the VM is started again (macOS only)")
+	stopVMCommand.Flags().Bool("verify-disk-detached", false, "fail if the user data disk is still attached once the VM is stopped")
+	stopVMCommand.Flags().Bool("wait", true,
+		"wait for the VM to stop, with --wait=false the stop goes on in the background once the user data disk is detached")
+	stopVMCommand.Flags().String("output-dir", "", "directory to write the files generated by the stop to, e.g. the guest kernel log, "+
+		"the metrics and the summaries (default ~/.finch)")
+	stopVMCommand.Flags().Bool("compress-logs", false, "gzip the logs saved when a stop fails, e.g. the guest kernel log")
+	stopVMCommand.Flags().String("recover", "",
+		`how to recover a VM whose last stop was interrupted: "stop" to complete the stop, "restart" to start it again`)
+	stopVMCommand.Flags().StringArray("annotate", nil,
+		"attach a key=value annotation to the stop, recorded in the audit log and shown by finch vm info, can be repeated")
+	stopVMCommand.Flags().String("lima-home", "", "path to the Lima home the instances are in, if not the one of Finch")
+	stopVMCommand.Flags().Bool("allow-sleep", false, "let the host sleep while the VM is being stopped (macOS only)")
+	stopVMCommand.Flags().Bool("lima-debug", false,
+		"pass --debug to limactl when stopping the VM and checking its status, for its verbose logs to be in the debug logs")
+	stopVMCommand.Flags().String("after-command", "",
+		"run a command with the host shell, streaming its output, then stop the VM and exit with the code of the command")
+	stopVMCommand.Flags().Bool("only-on-success", false, "only stop the VM if the command of --after-command succeeds")
+	stopVMCommand.Flags().Bool("validate-config-first", false,
+		"validate the config before touching the VM, and fail while it's still running if the next start would fail")
+	addGuestSSHFlags(stopVMCommand)
 
 	return stopVMCommand
 }
 
+// stopVMOptions holds the options of a single `finch vm stop` invocation.
+type stopVMOptions struct {
+	force                    bool
+	instanceFile             string
+	includeForeign           bool
+	confirmRunningContainers bool
+	yes                      bool
+	rotateDisk               bool
+	preSnapshot              string
+	ignoreSnapshotErrors     bool
+	reportContainers         bool
+	flushWrites              bool
+	preventSleep             bool
+	hibernate                bool
+	tag                      string
+	postStopCommand          string
+	ignoreHookErrors         bool
+	reportTo                 string
+	collectMetricsTo         string
+	maxAttempts              int
+	sinceBootOnly            bool
+	ifIdleFor                time.Duration
+	waitForContainer         string
+	waitForContainerTimeout  time.Duration
+	pullTimeout              time.Duration
+	drainTimeout             time.Duration
+	drainNamespace           string
+	drainWebhook             bool
+	preserveContainersState  bool
+	recordSession            bool
+	timeout                  time.Duration
+	gracePeriod              time.Duration
+	recoverWith              string
+	compressLogs             bool
+	annotations              map[string]string
+	outputDir                string
+	diskDetachOrder          string
+	diskDetachWorkers        int
+	verifyDiskDetached       bool
+	keepSocket               bool
+	keepAliveSocket          bool
+	uninstall                bool
+	removeUserData           bool
+	probeOnlyIfExists        bool
+	noWait                   bool
+	summary                  bool
+	json                     bool
+	outputFormat             string
+	afterCommand             string
+	onlyOnSuccess            bool
+	validateConfigFirst      bool
+	// interactive is true if the user can be prompted for confirmation.
+	interactive bool
+}
+
 type stopVMAction struct {
-	creator     command.NerdctlCmdCreator
-	diskManager disk.UserDataDiskManager
-	logger      flog.Logger
+	creator       command.NerdctlCmdCreator
+	ecc           command.Creator
+	diskManager   disk.UserDataDiskManager
+	logger        flog.Logger
+	fs            afero.Fs
+	fp            path.Finch
+	fc            *config.Finch
+	finchRootPath string
+	stdin         io.Reader
+	// stdout is where the user is prompted for confirmation.
+	stdout io.Writer
+	// output is where the structured output of the stop goes, separately from the logs,
+	// so that a program embedding the stop can capture it.
+	output io.Writer
+	// limaHome is the Lima home set with --lima-home, empty to use the one of Finch.
+	limaHome string
+	// ctx carries the deadline set by a supervisor with FINCH_DEADLINE, if any, nil when run outside of the CLI.
+	ctx context.Context
+	// phases holds how long each phase of the ongoing stop took, for --collect-metrics-to.
+	phases map[string]time.Duration
+	// observedStatus is the status the ongoing stop found the instance in, lima.Unknown until it's checked, for --json.
+	observedStatus lima.VMStatus
+	// traceEndpoint is the OTLP endpoint the trace of each stop is exported to, empty if tracing is disabled.
+	traceEndpoint string
+	// spans holds the phases of the ongoing stop when tracing is enabled, see exportTrace.
+	spans []phaseSpan
+	// compressLogs is set with --compress-logs to gzip the logs saved by the stop.
+	compressLogs bool
+	// annotations are set with --annotate, they're attached to the records of the stop.
+	annotations map[string]string
+	// drainNamespace is the containerd namespace of the containers to drain, empty for the one nerdctl is configured with.
+	drainNamespace string
+	// outputDir is where the files generated by the stop are written to, see artifactPath.
+	outputDir string
+	// outputFormat is the format of the summary printed with --summary, set with --output-format.
+	outputFormat string
+	// stopTimeout is set with --timeout to bound how long the VM takes to stop, 0 for no limit.
+	stopTimeout time.Duration
+	// gracePeriod is set with --grace-period, the instance is forcibly stopped if it doesn't stop cleanly within it.
+	gracePeriod time.Duration
+	// diskDetachOrder is set with --disk-detach-order, diskDetachParallel to detach the independent disks concurrently.
+	diskDetachOrder string
+	// diskDetachWorkers is set with --disk-detach-workers to bound how many disks are detached concurrently.
+	diskDetachWorkers int
+	// listeners are notified of the beginning and the end of each stop.
+	listeners []lima.LifecycleListener
+	// executable returns the path of finch, to start the proxy of the host socket with, it's overridden in tests.
+	executable func() (string, error)
+	// lookupEnv reads the FINCH_STOP_* environment variables setting the flags and the OTEL_* ones configuring
+	// the tracing, it's overridden in tests.
+	lookupEnv func(string) (string, bool)
+	// mem reads the memory of the host for stop.autoForceOnPressure, it's overridden in tests.
+	mem fmemory.Memory
+	// settleTimeout is how long a stop waits for an instance that is starting to settle, it's overridden in tests.
+	settleTimeout time.Duration
+}
+
+func newStopVMAction(
+	creator command.NerdctlCmdCreator,
+	ecc command.Creator,
+	diskManager disk.UserDataDiskManager,
+	logger flog.Logger,
+	fs afero.Fs,
+	fp path.Finch,
+	fc *config.Finch,
+	finchRootPath string,
+	stdin io.Reader,
+	stdout io.Writer,
+	output io.Writer,
+) *stopVMAction {
+	return &stopVMAction{
+		creator:       creator,
+		ecc:           ecc,
+		diskManager:   diskManager,
+		logger:        logger,
+		fs:            fs,
+		fp:            fp,
+		fc:            fc,
+		finchRootPath: finchRootPath,
+		stdin:         stdin,
+		stdout:        stdout,
+		output:        output,
+		listeners:     lima.LifecycleListeners(),
+		executable:    os.Executable,
+		lookupEnv:     os.LookupEnv,
+		mem:           fmemory.NewMemory(),
+		settleTimeout: startingSettleTimeout,
+	}
+}
+
+func (sva *stopVMAction) runAdapter(cmd *cobra.Command, _ []string) error {
+	sva.ctx = cmd.Context()
+	if err := applyEnvFlags(cmd.Flags(), stopEnvPrefix, sva.lookupEnv); err != nil {
+		return err
+	}
+	force, err := cmd.Flags().GetBool("force")
+	if err != nil {
+		return err
+	}
+	instanceFile, err := cmd.Flags().GetString("instance-file")
+	if err != nil {
+		return err
+	}
+	includeForeign, err := cmd.Flags().GetBool("include-foreign")
+	if err != nil {
+		return err
+	}
+	confirmRunningContainers, err := cmd.Flags().GetBool("confirm-running-containers")
+	if err != nil {
+		return err
+	}
+	yes, err := cmd.Flags().GetBool("yes")
+	if err != nil {
+		return err
+	}
+	flushWrites, err := cmd.Flags().GetBool("flush-writes")
+	if err != nil {
+		return err
+	}
+	allowSleep, err := cmd.Flags().GetBool("allow-sleep")
+	if err != nil {
+		return err
+	}
+	rotateDisk, err := cmd.Flags().GetBool("rotate-disk")
+	if err != nil {
+		return err
+	}
+	preSnapshot, err := cmd.Flags().GetString("pre-snapshot")
+	if err != nil {
+		return err
+	}
+	ignoreSnapshotErrors, err := cmd.Flags().GetBool("ignore-snapshot-errors")
+	if err != nil {
+		return err
+	}
+	reportContainers, err := cmd.Flags().GetBool("report-containers")
+	if err != nil {
+		return err
+	}
+	hibernate, err := cmd.Flags().GetBool("hibernate")
+	if err != nil {
+		return err
+	}
+	tag, err := cmd.Flags().GetString("tag")
+	if err != nil {
+		return err
+	}
+	postStopCommand, err := cmd.Flags().GetString("post-stop-command")
+	if err != nil {
+		return err
+	}
+	ignoreHookErrors, err := cmd.Flags().GetBool("ignore-hook-errors")
+	if err != nil {
+		return err
+	}
+	reportTo, err := cmd.Flags().GetString("report-to")
+	if err != nil {
+		return err
+	}
+	if reportTo == "" {
+		reportTo = sva.fc.Stop.ReportTo
+	}
+	collectMetricsTo, err := cmd.Flags().GetString("collect-metrics-to")
+	if err != nil {
+		return err
+	}
+	maxAttempts, err := cmd.Flags().GetInt("max-attempts")
+	if err != nil {
+		return err
+	}
+	if maxAttempts < 1 {
+		return errors.New("--max-attempts must be at least 1")
+	}
+	summary, err := cmd.Flags().GetBool("summary")
+	if err != nil {
+		return err
+	}
+	jsonOutput, err := cmd.Flags().GetBool("json")
+	if err != nil {
+		return err
+	}
+	outputFormat, err := cmd.Flags().GetString("output-format")
+	if err != nil {
+		return err
+	}
+	// Only an explicit --output-format is checked against --summary, whichever format it asks for.
+	if !cmd.Flags().Changed("output-format") {
+		outputFormat = ""
+	}
+	sinceBootOnly, err := cmd.Flags().GetBool("since-boot-only")
+	if err != nil {
+		return err
+	}
+	ifIdleFor, err := cmd.Flags().GetDuration("if-idle-for")
+	if err != nil {
+		return err
+	}
+	if ifIdleFor < 0 {
+		return errors.New("--if-idle-for must not be negative")
+	}
+	waitForContainer, err := cmd.Flags().GetString("wait-for-container")
+	if err != nil {
+		return err
+	}
+	waitForContainerTimeout, err := cmd.Flags().GetDuration("wait-for-container-timeout")
+	if err != nil {
+		return err
+	}
+	if waitForContainerTimeout < 0 {
+		return errors.New("--wait-for-container-timeout must not be negative")
+	}
+	pullTimeout, err := cmd.Flags().GetDuration("pull-timeout")
+	if err != nil {
+		return err
+	}
+	drainTimeout, err := cmd.Flags().GetDuration("drain-timeout")
+	if err != nil {
+		return err
+	}
+	if drainTimeout < 0 {
+		return errors.New("--drain-timeout must not be negative")
+	}
+	drainNamespace, err := cmd.Flags().GetString("namespace")
+	if err != nil {
+		return err
+	}
+	if drainNamespace == "" {
+		drainNamespace = sva.fc.Stop.DrainNamespace
+	}
+	drainWebhook, err := cmd.Flags().GetBool("drain-webhook")
+	if err != nil {
+		return err
+	}
+	preserveContainersState, err := cmd.Flags().GetBool("preserve-containers-state")
+	if err != nil {
+		return err
+	}
+	recordSession, err := cmd.Flags().GetBool("record-session")
+	if err != nil {
+		return err
+	}
+	timeout, err := cmd.Flags().GetDuration("timeout")
+	if err != nil {
+		return err
+	}
+	if timeout < 0 {
+		return errors.New("--timeout must not be negative")
+	}
+	gracePeriod, err := cmd.Flags().GetDuration("grace-period")
+	if err != nil {
+		return err
+	}
+	// Like `kubectl delete --grace-period=0`, there's no graceful stop to wait for.
+	forceRightAway := cmd.Flags().Changed("grace-period") && gracePeriod == 0
+	if !cmd.Flags().Changed("grace-period") && timeout > 0 {
+		// --timeout alone keeps failing the stop rather than forcing it, as it always did.
+		gracePeriod = 0
+	}
+	recoverWith, err := cmd.Flags().GetString("recover")
+	if err != nil {
+		return err
+	}
+	compressLogs, err := cmd.Flags().GetBool("compress-logs")
+	if err != nil {
+		return err
+	}
+	annotate, err := cmd.Flags().GetStringArray("annotate")
+	if err != nil {
+		return err
+	}
+	afterCommand, err := cmd.Flags().GetString("after-command")
+	if err != nil {
+		return err
+	}
+	onlyOnSuccess, err := cmd.Flags().GetBool("only-on-success")
+	if err != nil {
+		return err
+	}
+	validateConfigFirst, err := cmd.Flags().GetBool("validate-config-first")
+	if err != nil {
+		return err
+	}
+	annotations, err := parseAnnotations(annotate)
+	if err != nil {
+		return err
+	}
+	outputDir, err := cmd.Flags().GetString("output-dir")
+	if err != nil {
+		return err
+	}
+	diskDetachOrder, err := cmd.Flags().GetString("disk-detach-order")
+	if err != nil {
+		return err
+	}
+	diskDetachWorkers, err := cmd.Flags().GetInt("disk-detach-workers")
+	if err != nil {
+		return err
+	}
+	verifyDiskDetached, err := cmd.Flags().GetBool("verify-disk-detached")
+	if err != nil {
+		return err
+	}
+	keepSocket, err := cmd.Flags().GetBool("keep-socket")
+	if err != nil {
+		return err
+	}
+	keepAliveSocket, err := cmd.Flags().GetBool("keep-alive-socket")
+	if err != nil {
+		return err
+	}
+	uninstall, err := cmd.Flags().GetBool("uninstall")
+	if err != nil {
+		return err
+	}
+	removeUserData, err := cmd.Flags().GetBool("remove-user-data")
+	if err != nil {
+		return err
+	}
+	probeOnlyIfExists, err := cmd.Flags().GetBool("probe-only-if-exists")
+	if err != nil {
+		return err
+	}
+	wait, err := cmd.Flags().GetBool("wait")
+	if err != nil {
+		return err
+	}
+	limaHome, err := cmd.Flags().GetString("lima-home")
+	if err != nil {
+		return err
+	}
+	if limaHome != "" {
+		creator, err := targetLimaHome(sva.creator, sva.fs, sva.logger, limaHome)
+		if err != nil {
+			return err
+		}
+		sva.creator = creator
+		sva.limaHome = limaHome
+	}
+	limaDebug, err := cmd.Flags().GetBool("lima-debug")
+	if err != nil {
+		return err
+	}
+	if limaDebug {
+		sva.creator = limaDebugCmdCreator{sva.creator}
+	}
+	sva.creator, err = targetGuestSSH(cmd, sva.creator, sva.ecc, sva.fs, sva.logger)
+	if err != nil {
+		return err
+	}
+	opts := stopVMOptions{
+		force:                    force,
+		instanceFile:             instanceFile,
+		includeForeign:           includeForeign,
+		confirmRunningContainers: confirmRunningContainers && !yes,
+		yes:                      yes,
+		rotateDisk:               rotateDisk,
+		preSnapshot:              preSnapshot,
+		ignoreSnapshotErrors:     ignoreSnapshotErrors,
+		reportContainers:         reportContainers,
+		flushWrites:              flushWrites,
+		preventSleep:             !allowSleep,
+		hibernate:                hibernate,
+		tag:                      tag,
+		postStopCommand:          postStopCommand,
+		ignoreHookErrors:         ignoreHookErrors,
+		reportTo:                 reportTo,
+		collectMetricsTo:         collectMetricsTo,
+		maxAttempts:              maxAttempts,
+		sinceBootOnly:            sinceBootOnly,
+		ifIdleFor:                ifIdleFor,
+		waitForContainer:         waitForContainer,
+		waitForContainerTimeout:  waitForContainerTimeout,
+		pullTimeout:              pullTimeout,
+		drainTimeout:             drainTimeout,
+		drainNamespace:           drainNamespace,
+		drainWebhook:             drainWebhook,
+		preserveContainersState:  preserveContainersState,
+		recordSession:            recordSession,
+		timeout:                  timeout,
+		gracePeriod:              gracePeriod,
+		recoverWith:              recoverWith,
+		compressLogs:             compressLogs,
+		annotations:              annotations,
+		outputDir:                outputDir,
+		diskDetachOrder:          diskDetachOrder,
+		diskDetachWorkers:        diskDetachWorkers,
+		verifyDiskDetached:       verifyDiskDetached,
+		keepSocket:               keepSocket,
+		keepAliveSocket:          keepAliveSocket,
+		uninstall:                uninstall,
+		removeUserData:           removeUserData,
+		probeOnlyIfExists:        probeOnlyIfExists,
+		noWait:                   !wait,
+		summary:                  summary,
+		json:                     jsonOutput,
+		outputFormat:             outputFormat,
+		afterCommand:             afterCommand,
+		onlyOnSuccess:            onlyOnSuccess,
+		validateConfigFirst:      validateConfigFirst,
+		interactive:              isTerminal(sva.stdin),
+	}
+	// What can't be forced is reported against --grace-period=0 rather than against a --force that wasn't passed.
+	if forceRightAway {
+		if conflict := forceConflict(opts); conflict != "" {
+			return fmt.Errorf("--grace-period=0 stops the VM forcibly, it cannot be used together with %s", conflict)
+		}
+		opts.force = true
+	}
+	// An explicit --force=false, or FINCH_STOP_FORCE=0, overrides the configured default, so only an unset flag
+	// falls back to it. The default doesn't apply to a stop that asks for something a forced stop can't do either.
+	if !cmd.Flags().Changed("force") && sva.fc.Stop.DefaultForce && !conflictsWithForce(opts) {
+		opts.force = true
+	}
+	return sva.run(opts)
+}
+
+// conflictsWithForce reports whether the options can't be used with --force, see run.
+func conflictsWithForce(opts stopVMOptions) bool {
+	return forceConflict(opts) != ""
+}
+
+// forceConflict returns the first of the options that can't be used with --force, see run, or an empty string
+// if there's none.
+func forceConflict(opts stopVMOptions) string {
+	switch {
+	case opts.hibernate:
+		return "--hibernate"
+	case opts.tag != "":
+		return "--tag"
+	case opts.preserveContainersState:
+		return "--preserve-containers-state"
+	case opts.recordSession:
+		return "--record-session"
+	case opts.preSnapshot != "":
+		return "--pre-snapshot"
+	case opts.rotateDisk:
+		return "--rotate-disk"
+	case opts.noWait:
+		return "--wait=false"
+	case opts.ifIdleFor > 0:
+		return "--if-idle-for"
+	case opts.reportContainers:
+		return "--report-containers"
+	case opts.drainTimeout > 0:
+		return "--drain-timeout"
+	case opts.drainWebhook:
+		return "--drain-webhook"
+	case opts.waitForContainer != "":
+		return "--wait-for-container"
+	}
+	return ""
+}
+
+func (sva *stopVMAction) run(opts stopVMOptions) error {
+	if opts.force && opts.hibernate {
+		return errors.New("--force and --hibernate cannot be used together")
+	}
+	// Snapshots are taken of the disks of a cleanly stopped VM, they can't capture a saved VM state.
+	if opts.tag != "" && (opts.force || opts.hibernate) {
+		return errors.New("--tag cannot be used together with --force or --hibernate")
+	}
+	// Checking whether the containers are idle needs the guest to respond, which a forced stop doesn't rely on.
+	if opts.force && opts.ifIdleFor > 0 {
+		return errors.New("--force and --if-idle-for cannot be used together")
+	}
+	// Listing the containers needs the guest to respond too.
+	if opts.force && opts.reportContainers {
+		return errors.New("--force and --report-containers cannot be used together")
+	}
+	// Draining the containers needs the guest to respond too.
+	if opts.force && opts.drainTimeout > 0 {
+		return errors.New("--force and --drain-timeout cannot be used together")
+	}
+	if opts.force && opts.drainWebhook {
+		return errors.New("--force and --drain-webhook cannot be used together")
+	}
+	if opts.force && opts.waitForContainer != "" {
+		return errors.New("--force and --wait-for-container cannot be used together")
+	}
+	// Checkpointing the containers needs the guest to respond, and a hibernated VM keeps them running anyway.
+	if opts.preserveContainersState && (opts.force || opts.hibernate) {
+		return errors.New("--preserve-containers-state cannot be used together with --force or --hibernate")
+	}
+	if opts.recordSession && (opts.force || opts.hibernate) {
+		return errors.New("--record-session cannot be used together with --force or --hibernate")
+	}
+	// Both write a JSON object per instance to the structured output, which would then be ambiguous to parse.
+	if opts.json && opts.summary {
+		return errors.New("--json and --summary cannot be used together")
+	}
+	switch opts.outputFormat {
+	case "", summaryFormatJSON, summaryFormatYAML:
+	default:
+		return fmt.Errorf("unsupported output format %q, it must be either %q or %q", opts.outputFormat, summaryFormatJSON, summaryFormatYAML)
+	}
+	if opts.outputFormat != "" && !opts.summary {
+		return errors.New("--output-format only applies to --summary")
+	}
+	// These need the VM to be stopped by the time finch returns.
+	if opts.noWait && (opts.force || opts.hibernate || opts.tag != "" || opts.verifyDiskDetached || opts.postStopCommand != "") {
+		return errors.New("--wait=false cannot be used together with --force, --hibernate, --tag, --verify-disk-detached " +
+			"or --post-stop-command")
+	}
+	// The disk must be detached from a cleanly stopped VM to be moved, and the snapshot would be of the empty disk.
+	if opts.rotateDisk && (opts.force || opts.hibernate || opts.tag != "" || opts.noWait) {
+		return errors.New("--rotate-disk cannot be used together with --force, --hibernate, --tag or --wait=false")
+	}
+	// A forced stop is meant to be fast, and a hibernated VM keeps writing to the disk once restored.
+	if opts.preSnapshot != "" && (opts.force || opts.hibernate) {
+		return errors.New("--pre-snapshot cannot be used together with --force or --hibernate")
+	}
+	if opts.gracePeriod < 0 {
+		return errors.New("--grace-period must not be negative")
+	}
+	if opts.gracePeriod > 0 && opts.timeout > 0 {
+		return errors.New("--grace-period cannot be used together with --timeout")
+	}
+	if opts.rotateDisk && !opts.yes {
+		return errors.New("--rotate-disk archives all the images and containers of the VM, pass --yes to confirm")
+	}
+	if opts.removeUserData && !opts.uninstall {
+		return errors.New("--remove-user-data only applies to --uninstall")
+	}
+	if opts.removeUserData && !opts.yes {
+		return errors.New("--remove-user-data removes all the images, containers and volumes of the VM, pass --yes to confirm")
+	}
+	if opts.uninstall && !opts.yes {
+		return errors.New("--uninstall removes the VM, pass --yes to confirm")
+	}
+	// Whatever they keep of the VM or its data would be removed right after.
+	if opts.uninstall && (needsGracefulStop(opts) || opts.instanceFile != "" || opts.afterCommand != "") {
+		return errors.New("--uninstall cannot be used together with --instance-file, --after-command or the options " +
+			"that keep the VM or its data")
+	}
+	// The disk is only snapshotted by cloning it, which the file systems of Windows don't do.
+	if opts.preSnapshot != "" && runtime.GOOS == "windows" {
+		return errors.New("--pre-snapshot only applies to macOS")
+	}
+	if opts.keepAliveSocket && runtime.GOOS == "windows" {
+		return errors.New("--keep-alive-socket only applies to macOS")
+	}
+	// The socket is kept alive once the VM is stopped, which --wait=false doesn't wait for, and --uninstall removes it.
+	if opts.keepAliveSocket && (opts.keepSocket || opts.noWait || opts.uninstall) {
+		return errors.New("--keep-alive-socket cannot be used together with --keep-socket, --wait=false or --uninstall")
+	}
+	if opts.recoverWith != "" && opts.recoverWith != recoverStop && opts.recoverWith != recoverRestart {
+		return fmt.Errorf("unsupported recovery %q, it must be either %q or %q", opts.recoverWith, recoverStop, recoverRestart)
+	}
+	if opts.diskDetachOrder != "" && opts.diskDetachOrder != diskDetachSerial && opts.diskDetachOrder != diskDetachParallel {
+		return fmt.Errorf("unsupported disk detach order %q, it must be either %q or %q",
+			opts.diskDetachOrder, diskDetachSerial, diskDetachParallel)
+	}
+	if opts.diskDetachOrder == diskDetachParallel && opts.diskDetachWorkers < 1 {
+		return errors.New("--disk-detach-workers must be at least 1")
+	}
+	if opts.onlyOnSuccess && opts.afterCommand == "" {
+		return errors.New("--only-on-success only applies to --after-command")
+	}
+	if opts.validateConfigFirst {
+		if err := config.ValidateForStart(sva.fc); err != nil {
+			return fmt.Errorf("the config is invalid, fix it before stopping the VM as the next start would fail: %w", err)
+		}
+		sva.logger.Debugln("The config is valid")
+	}
+	if opts.afterCommand != "" {
+		return sva.stopAfterCommand(opts)
+	}
+	if opts.uninstall {
+		return sva.uninstall(opts.force, opts.removeUserData)
+	}
+	sva.compressLogs = opts.compressLogs
+	sva.stopTimeout = opts.timeout
+	sva.gracePeriod = opts.gracePeriod
+	sva.diskDetachOrder = opts.diskDetachOrder
+	sva.diskDetachWorkers = opts.diskDetachWorkers
+	sva.outputDir = opts.outputDir
+	sva.outputFormat = opts.outputFormat
+	sva.annotations = opts.annotations
+	sva.drainNamespace = opts.drainNamespace
+	sva.traceEndpoint = otlpTracesEndpoint(sva.lookupEnv)
+	if sva.fc.Stop.SelfDumpAfter > 0 {
+		defer sva.startSelfDumpWatchdog(sva.fc.Stop.SelfDumpAfter)()
+	}
+	if opts.preventSleep {
+		defer sva.preventSleep()()
+	}
+
+	if opts.instanceFile == "" {
+		return sva.stopInstanceWithRetries(limaInstanceName, opts)
+	}
+
+	instances, err := readInstanceFile(sva.fs, opts.instanceFile)
+	if err != nil {
+		return err
+	}
+	if len(instances) == 0 {
+		sva.logger.Warnf("No instance names found in %q, nothing to stop", opts.instanceFile)
+		return nil
+	}
+	instances, err = orderByDependencies(instances, sva.fc.Stop.DependsOn)
+	if err != nil {
+		return err
+	}
+	return sva.stopInstances(instances, opts)
+}
+
+// stopInstances stops each of the instances in order. A failure to stop one instance doesn't prevent
+// the remaining ones from being stopped; all the errors are aggregated into the returned error.
+// Instances that weren't created by Finch are skipped, unless opts.includeForeign is set.
+func (sva *stopVMAction) stopInstances(instances []string, opts stopVMOptions) error {
+	var errs []error
+	skipped := 0
+	for _, instance := range instances {
+		if !opts.includeForeign && !sva.isFinchInstance(instance) {
+			sva.logger.Warnf("Not stopping the instance %q as it wasn't created by Finch, use --include-foreign to stop it anyway", instance)
+			skipped++
+			continue
+		}
+		if err := sva.stopInstanceWithRetries(instance, opts); err != nil {
+			errs = append(errs, fmt.Errorf("failed to stop instance %q: %w", instance, err))
+		}
+	}
+
+	sva.logger.Infof("Stopped %d of %d instances", len(instances)-len(errs)-skipped, len(instances))
+	return errors.Join(errs...)
+}
+
+// isFinchInstance reports whether the instance of the Lima home was created by Finch, see lima.IsFinchInstance.
+func (sva *stopVMAction) isFinchInstance(instance string) bool {
+	return lima.IsFinchInstance(sva.fs, instance, filepath.Join(sva.limaHomePath(), instance), sva.fc.InstancePrefix)
+}
+
+// stopInstanceWithRetries makes up to opts.maxAttempts attempts at stopping the instance.
+// Whether an attempt is worth retrying is told by the status of the instance after it failed: only an instance
+// that is still running, or whose status can't be read, is retried. An instance that is found stopped after a retry
+// means that a previous attempt went through after all, and the stop is successful.
+// The delay between the attempts follows the retry policy of the config.
+func (sva *stopVMAction) stopInstanceWithRetries(instance string, opts stopVMOptions) error {
+	for attempt := 1; ; attempt++ {
+		err := sva.stopInstance(instance, opts)
+		if err == nil || attempt >= opts.maxAttempts {
+			return err
+		}
+
+		status, statusErr := lima.Status(sva.creator, instance)
+		if statusErr == nil && status != lima.Running {
+			if status == lima.Stopped && attempt > 1 {
+				sva.logger.Infof("The instance %q is stopped", instance)
+				return nil
+			}
+			return err
+		}
+
+		delay := sva.fc.Retry.Delay(attempt)
+		sva.logger.Warnf("Attempt %d of %d at stopping the instance %q failed: %v, retrying in %s...",
+			attempt, opts.maxAttempts, instance, err, delay)
+		time.Sleep(delay)
+	}
+}
+
+// stopGuard serializes the stops of the same instance issued from within this process.
+var stopGuard = lima.NewStopGuard()
+
+func (sva *stopVMAction) stopInstance(instance string, opts stopVMOptions) error {
+	// A stop that waited for another one to finish finds the instance stopped and reports it as such.
+	release, err := stopGuard.Acquire(instance, lima.WaitForStop)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if err := sva.checkDeadline("status"); err != nil {
+		return err
+	}
+	if sva.stopWasInterrupted(instance) {
+		if opts.recoverWith != "" {
+			err := sva.recoverInterruptedStop(instance, opts.recoverWith)
+			// The recoveries are named after the commands they complete.
+			sva.recordAudit(opts.recoverWith, instance, err)
+			return err
+		}
+		sva.logger.Warnf("The last stop of the instance %q was interrupted, use --recover=%s or --recover=%s to recover from it",
+			instance, recoverStop, recoverRestart)
+	}
+	if opts.probeOnlyIfExists {
+		// Cleanup scripts looping over instances that may be gone expect it, unlike an already stopped instance.
+		if status, err := lima.Status(sva.creator, instance); err == nil && status == lima.Nonexistent {
+			sva.logger.Debugf("The instance %q does not exist, there's nothing to stop", instance)
+			if opts.json {
+				sva.printStopResult(stopResult{Status: stopStatusNonexistent, Result: stopResultNoop})
+			}
+			return nil
+		}
+	}
+	if opts.json {
+		// Stopping an already stopped instance is what the caller asked for, there's nothing to fail.
+		if status, err := lima.Status(sva.creator, instance); err == nil && status == lima.Stopped {
+			sva.printStopResult(stopResult{Status: stopStatusAlreadyStopped, Result: stopResultNoop})
+			return nil
+		}
+	}
+
+	start := time.Now()
+	sva.phases = nil
+	sva.spans = nil
+	sva.observedStatus = lima.Unknown
+	for _, l := range sva.listeners {
+		l.OnStopBegin(instance)
+	}
+	stopped, err := sva.stopInstanceVM(instance, opts)
+	if err == nil && stopped && opts.verifyDiskDetached {
+		done := sva.timePhase("verify")
+		err = sva.verifyDiskDetached(instance)
+		done()
+	}
+	sva.notifyStopEnd(lima.StopResult{Instance: instance, Stopped: stopped, Forced: opts.force, Duration: time.Since(start)}, err)
+	// With --wait=false, the instance is still stopping, finch vm finish-stop records how the stop went.
+	pending := stopped && err == nil && opts.noWait
+	if (stopped || err != nil) && !pending {
+		sva.recordAudit("stop", instance, err)
+	}
+	if stopped && len(opts.annotations) > 0 {
+		sva.recordStopAnnotations(instance, opts.annotations)
+	}
+	if stopped && !pending && instance == limaInstanceName {
+		sva.recordLastRunConfig(instance)
+	}
+	if opts.json {
+		sva.printStopResult(newStopResult(sva.observedStatus, stopped, pending, err))
+	}
+	if err == nil && stopped && instance == limaInstanceName && !opts.noWait {
+		sva.logFreedMemory()
+	}
+	// With --wait=false, the instance is still stopping and serving the socket.
+	if err == nil && stopped && !opts.noWait && !opts.keepSocket {
+		if opts.keepAliveSocket {
+			sva.keepHostSocketAlive(instance)
+		} else {
+			sva.removeHostSocket(instance)
+		}
+	}
+	if (opts.reportTo != "" || opts.summary) && (stopped || err != nil) {
+		report := newStopReport(instance, opts.force, time.Since(start), pending, err)
+		if opts.reportTo != "" {
+			sva.reportStop(opts.reportTo, report)
+		}
+		if opts.summary {
+			sva.printSummary(report)
+			if opts.outputDir != "" {
+				sva.saveSummary(report)
+			}
+		}
+	}
+	if err == nil && stopped && opts.postStopCommand != "" {
+		done := sva.timePhase("postStop")
+		err = sva.runPostStopCommand(opts.postStopCommand, opts.ignoreHookErrors)
+		done()
+	}
+	if opts.collectMetricsTo != "" && (stopped || err != nil) {
+		sva.collectMetrics(sva.artifactPath(opts.collectMetricsTo), newStopMetrics(instance, opts.force, time.Since(start), sva.phases, err))
+	}
+	if sva.traceEndpoint != "" {
+		sva.exportTrace(instance, opts.force, start, newStopResult(sva.observedStatus, stopped, pending, err))
+	}
+	return err
+}
+
+// artifactPath resolves the path of a file generated by the stop: a relative path is relative to --output-dir if set,
+// and to ~/.finch otherwise, so that where it ends up doesn't depend on the directory finch is run from.
+func (sva *stopVMAction) artifactPath(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	dir := sva.outputDir
+	if dir == "" {
+		dir = sva.fp.FinchDir(sva.finchRootPath)
+	}
+	return filepath.Join(dir, path)
+}
+
+// recordAudit appends the record of the command run on the instance to the audit log, with the annotations
+// of the stop, see recordAudit.
+func (sva *stopVMAction) recordAudit(command, instance string, err error) {
+	r := audit.NewRecord(command, instance, err)
+	r.Annotations = sva.annotations
+	appendAudit(sva.fs, sva.logger, sva.fp.AuditLogPath(sva.finchRootPath), r)
+}
+
+// recordStopAnnotations records the annotations of the stop in the metadata of the instance for finch vm info,
+// in place of those of the previous annotated stop. The metadata is only kept for the instances created by Finch.
+func (sva *stopVMAction) recordStopAnnotations(instance string, annotations map[string]string) {
+	if !sva.isFinchInstance(instance) {
+		return
+	}
+	if err := lima.UpdateInstanceMetadata(sva.fs, filepath.Join(sva.limaHomePath(), instance), func(md *lima.InstanceMetadata) {
+		md.StopAnnotations = annotations
+	}); err != nil {
+		sva.logger.Warnf("Could not record the annotations of the stop of the instance %q: %v", instance, err)
+	}
+}
+
+// recordLastRunConfig records the configuration the Finch VM ran with in its metadata, for finch vm info --last-run-config
+// to tell what it was even after finch.yaml changed. The configuration is the one recorded when the VM was started,
+// not the one loaded by this invocation, as finch.yaml can change while the VM runs. A VM started by a version of
+// Finch that didn't record it has no last run configuration rather than a wrong one.
+func (sva *stopVMAction) recordLastRunConfig(instance string) {
+	if err := lima.UpdateInstanceMetadata(sva.fs, filepath.Join(sva.limaHomePath(), instance), func(md *lima.InstanceMetadata) {
+		md.LastRunConfig = md.RunConfig
+		md.RunConfig = nil
+	}); err != nil {
+		sva.logger.Warnf("Could not record the configuration the instance %q ran with: %v", instance, err)
+	}
+}
+
+// parseAnnotations parses the key=value annotations passed with --annotate, a later value of a key overrides the earlier.
+func parseAnnotations(annotate []string) (map[string]string, error) {
+	annotations := make(map[string]string, len(annotate))
+	for _, a := range annotate {
+		key, value, ok := strings.Cut(a, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("invalid annotation %q, it must be in the key=value format", a)
+		}
+		annotations[key] = value
+	}
+	return annotations, nil
+}
+
+// notifyStopEnd tells the listeners how the stop of result.Instance ended.
+func (sva *stopVMAction) notifyStopEnd(result lima.StopResult, err error) {
+	for _, l := range sva.listeners {
+		if err != nil {
+			l.OnStopError(result.Instance, err)
+		} else {
+			l.OnStopComplete(result)
+		}
+	}
+}
+
+// checkDeadline fails once the deadline set by a supervisor has passed, it's called before each phase of the stop.
+// The waits of a phase that is already underway are bounded by the deadline too, see untilDeadline.
+func (sva *stopVMAction) checkDeadline(phase string) error {
+	if sva.ctx == nil {
+		return nil
+	}
+	if err := sva.ctx.Err(); err != nil {
+		return fmt.Errorf("the deadline passed before the %s phase of the stop: %w", phase, err)
+	}
+	return nil
+}
+
+// untilDeadline caps timeout, 0 for no limit, at the time left before the deadline set by a supervisor,
+// and reports whether the deadline is what bounds the wait.
+func (sva *stopVMAction) untilDeadline(timeout time.Duration) (time.Duration, bool) {
+	if sva.ctx == nil {
+		return timeout, false
+	}
+	deadline, ok := sva.ctx.Deadline()
+	if !ok {
+		return timeout, false
+	}
+	// A timeout of 0 means no limit, so at least a nanosecond is left for a deadline that has just passed.
+	left := max(time.Until(deadline), time.Nanosecond)
+	if timeout > 0 && timeout <= left {
+		return timeout, false
+	}
+	return left, true
 }
 
-func newStopVMAction(creator command.NerdctlCmdCreator, diskManager disk.UserDataDiskManager, logger flog.Logger) *stopVMAction {
-	return &stopVMAction{creator: creator, diskManager: diskManager, logger: logger}
+// deadlinePassedDuring is the error of the phase of the stop that the deadline set by a supervisor cut short.
+func deadlinePassedDuring(phase string) error {
+	return fmt.Errorf("the deadline passed during the %s phase of the stop: %w", phase, context.DeadlineExceeded)
 }
 
-func (sva *stopVMAction) runAdapter(cmd *cobra.Command, _ []string) error {
-	force, err := cmd.Flags().GetBool("force")
+// startedSinceHostBoot reports whether Finch last started the instance during the current boot session of the host.
+// Instances Finch has never recorded a start of are considered to be from a previous boot session.
+func (sva *stopVMAction) startedSinceHostBoot(instance string) (bool, error) {
+	md, err := lima.LoadInstanceMetadata(sva.fs, filepath.Join(sva.limaHomePath(), instance))
 	if err != nil {
-		return err
+		return false, err
+	}
+	if md.HostBootTime.IsZero() {
+		return false, nil
+	}
+	bootTime, err := hostBootTime()
+	if err != nil {
+		return false, err
 	}
-	return sva.run(force)
+	return bootTime.Sub(md.HostBootTime).Abs() <= hostBootTimeTolerance, nil
 }
 
-func (sva *stopVMAction) run(force bool) error {
-	if force {
-		return sva.stopVM(force)
+// logFreedMemory logs how much host memory the Finch VM was given, which is released now that it's stopped.
+func (sva *stopVMAction) logFreedMemory() {
+	memory := configuredMemory(sva.fc)
+	if memory == "" {
+		return
+	}
+	size, err := units.RAMInBytes(memory)
+	if err != nil {
+		sva.logger.Debugf("Could not parse the memory of the virtual machine %q: %v", memory, err)
+		return
+	}
+	sva.logger.Infof("Freed ~%s of host memory", units.BytesSize(float64(size)))
+}
+
+// stopInstanceVM stops the instance the way opts asks for, and reports whether it got stopped.
+func (sva *stopVMAction) stopInstanceVM(instance string, opts stopVMOptions) (bool, error) {
+	if opts.sinceBootOnly {
+		started, err := sva.startedSinceHostBoot(instance)
+		if err != nil {
+			return false, err
+		}
+		if !started {
+			sva.logger.Warnf("Not stopping the instance %q, it wasn't started by Finch since the host booted", instance)
+			return false, nil
+		}
+	}
+
+	force, err := sva.forceOnPressure(instance, opts)
+	if err != nil {
+		return false, err
+	}
+	opts.force = opts.force || force
+
+	// A forced stop is meant to be fast and to work on an unresponsive guest, so it must skip everything
+	// that runs commands in the guest before stopping it, e.g. counting or stopping its containers.
+	if opts.force {
+		err := sva.trackStop(instance, func() error { return sva.stopVM(instance, true) })
+		if err == nil && instance == limaInstanceName && sva.fc.Network.CleanupOnForceStop {
+			// Failing to clean up doesn't undo the stop, the next start reports if the network is still in the way.
+			if err := sva.cleanUpNetwork(); err != nil {
+				sva.logger.Warnf("Could not clean up the network of the virtual machine: %v", err)
+			}
+		}
+		return true, err
+	}
+
+	done := sva.timePhase("status")
+	err = sva.assertVMIsRunning(instance)
+	done()
+	if isSuspended(err) && !opts.hibernate {
+		// A suspended instance isn't running, but it isn't stopped either, see stop.fromSuspend.
+		var stopped bool
+		if stopped, err = sva.leaveSuspend(instance); stopped {
+			return true, err
+		}
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if opts.waitForContainer != "" {
+		done := sva.timePhase("waitForContainer")
+		err := sva.waitForContainer(instance, opts.waitForContainer, opts.waitForContainerTimeout)
+		done()
+		if err != nil {
+			return false, err
+		}
+	}
+	if opts.ifIdleFor > 0 {
+		idle, err := sva.isIdleFor(instance, opts.ifIdleFor)
+		if err != nil || !idle {
+			return false, err
+		}
+	}
+
+	// The containers keep running after the VM state is restored, so there's nothing to confirm.
+	if opts.hibernate {
+		return true, sva.hibernateVM(instance)
+	}
+
+	if opts.reportContainers {
+		sva.reportContainers(instance)
+	}
+	if opts.confirmRunningContainers && opts.interactive {
+		confirmed, err := sva.confirmRunningContainers(instance)
+		if err != nil {
+			return false, err
+		}
+		if !confirmed {
+			sva.logger.Infof("Not stopping the instance %q", instance)
+			return false, nil
+		}
+	}
+	if opts.preSnapshot != "" && instance == limaInstanceName {
+		done := sva.timePhase("preSnapshot")
+		err := sva.preSnapshotUserDataDisk(instance, opts.preSnapshot, opts.ignoreSnapshotErrors)
+		done()
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if sva.fc.Nested.GracefulStop {
+		sva.stopNestedVM(instance)
+	}
+	if opts.pullTimeout > 0 {
+		sva.waitForPulls(instance, opts.pullTimeout)
+	}
+	// The apps get to flush their state before their containers are checkpointed or stopped.
+	if opts.drainWebhook {
+		done := sva.timePhase("drainWebhook")
+		sva.notifyDrainWebhooks(instance)
+		done()
+	}
+	// The containers are recorded while they're still running, before they're checkpointed or drained.
+	if opts.recordSession {
+		done := sva.timePhase("recordSession")
+		sva.recordSession(instance)
+		done()
+	}
+	// Checkpointing stops the containers, so it must happen before they are drained.
+	if opts.preserveContainersState {
+		done := sva.timePhase("checkpoint")
+		sva.checkpointContainers(instance)
+		done()
+	}
+	if opts.drainTimeout > 0 {
+		if err := sva.checkDeadline("drain"); err != nil {
+			return false, err
+		}
+		done := sva.timePhase("drain")
+		err := sva.drainContainers(instance, opts.drainTimeout)
+		done()
+		if err != nil {
+			return false, err
+		}
+	}
+	if sva.fc.BuildKit.PersistOnStop {
+		sva.flushBuildKitCache(instance)
+	}
+	if sva.fc.Containerd.FlushOnStop {
+		done := sva.timePhase("flushContainerd")
+		sva.flushContainerdContentStore(instance)
+		done()
+	}
+
+	if opts.flushWrites {
+		done := sva.timePhase("flushWrites")
+		sva.flushGuestWrites(instance)
+		done()
+	}
+
+	if opts.noWait {
+		return true, sva.startDetachedStop(instance)
+	}
+	if err := sva.trackStop(instance, func() error { return sva.stopVMWithConfiguredMethod(instance) }); err != nil {
+		return false, err
+	}
+	if opts.rotateDisk && instance == limaInstanceName {
+		return true, sva.rotateUserDataDisk()
+	}
+	if opts.tag != "" {
+		return true, sva.snapshotVM(instance, opts.tag)
+	}
+	return true, nil
+}
+
+// runPostStopCommand runs the user provided command with the host shell once the instance is stopped.
+// Its output goes through the logger, and it failing fails the stop unless ignoreErrors is set.
+func (sva *stopVMAction) runPostStopCommand(postStopCommand string, ignoreErrors bool) error {
+	sva.logger.Infof("Running the post-stop command %q...", postStopCommand)
+	name, args := hostShellCommand(postStopCommand)
+	out, err := sva.ecc.Create(name, args...).CombinedOutput()
+	for _, line := range strings.Split(string(out), "\n") {
+		if line = strings.TrimRight(line, "\r"); line != "" {
+			sva.logger.Infoln(line)
+		}
+	}
+	if err != nil {
+		if ignoreErrors {
+			sva.logger.Warnf("The post-stop command failed: %v", err)
+			return nil
+		}
+		return fmt.Errorf("the post-stop command failed: %w", err)
+	}
+	return nil
+}
+
+// hostShellCommand returns the name and the arguments of the command running shellCommand with the host shell.
+func hostShellCommand(shellCommand string) (string, []string) {
+	if runtime.GOOS == "windows" {
+		return "cmd.exe", []string{"/C", shellCommand}
+	}
+	return "/bin/sh", []string{"-c", shellCommand}
+}
+
+// stopNestedVM gracefully stops the Finch VM running inside the instance, if any,
+// so that it isn't killed along with the instance.
+// Failing to do so doesn't prevent the instance from being stopped.
+func (sva *stopVMAction) stopNestedVM(instance string) {
+	out, err := sva.creator.CreateWithoutStdio("shell", instance, "finch", "vm", "status").Output()
+	if err != nil || strings.TrimSpace(string(out)) != "Running" {
+		sva.logger.Debugf("No nested Finch virtual machine running in the instance %q", instance)
+		return
+	}
+
+	sva.logger.Infof("Stopping the nested Finch virtual machine in the instance %q...", instance)
+	logs, err := sva.creator.CreateWithoutStdio("shell", instance, "finch", "vm", "stop").CombinedOutput()
+	if err != nil {
+		sva.logger.Warnf("Could not stop the nested Finch virtual machine: %v, debug logs:\n%s", err, logs)
+	}
+}
+
+// buildKitCacheDir is where BuildKit keeps its cache in the guest, it's bind mounted from the user data disk.
+const buildKitCacheDir = "/var/lib/buildkit"
+
+// flushBuildKitCache stops BuildKit, which makes it write out its cache metadata, then flushes the cache
+// to the user data disk, so that detaching the disk doesn't lose what's still only in memory.
+// Failing to do so doesn't prevent the instance from being stopped.
+func (sva *stopVMAction) flushBuildKitCache(instance string) {
+	// The user data disk only ever belongs to the Finch instance, and an ephemeral one doesn't outlive the VM anyway.
+	if instance != limaInstanceName || sva.fc.Disk.Ephemeral {
+		return
 	}
 
-	err := sva.assertVMIsRunning(sva.creator, sva.logger)
+	sva.logger.Info("Flushing the BuildKit cache to the user data disk...")
+	logs, err := sva.creator.CreateWithoutStdio(
+		"shell", instance, "sudo", "sh", "-c", fmt.Sprintf("systemctl stop buildkit.service && sync -f %s", buildKitCacheDir),
+	).CombinedOutput()
 	if err != nil {
+		sva.logger.Warnf("Could not flush the BuildKit cache: %v, debug logs:\n%s", err, logs)
+	}
+}
+
+// stopVMWithConfiguredMethod gracefully stops the instance with the method set in the configuration.
+func (sva *stopVMAction) stopVMWithConfiguredMethod(instance string) error {
+	switch sva.fc.Stop.Method {
+	case "", config.StopMethodLimactl:
+		return sva.stopVM(instance, false)
+	case config.StopMethodSystemdPoweroff:
+		return sva.poweroffVM(instance)
+	default:
+		return fmt.Errorf("unsupported stop method %q, it must be either %q or %q",
+			sva.fc.Stop.Method, config.StopMethodLimactl, config.StopMethodSystemdPoweroff)
+	}
+}
+
+// poweroffVM shuts the guest down from the inside with systemd, which gives its services the chance
+// to stop cleanly, then waits for Lima to report the instance as stopped.
+func (sva *stopVMAction) poweroffVM(instance string) error {
+	if logs, err := sva.creator.CreateWithoutStdio("shell", instance, "true").CombinedOutput(); err != nil {
+		sva.logger.Warnf("Could not reach the instance %q over SSH, stopping it with limactl instead: %v, debug logs:\n%s",
+			instance, err, logs)
+		return sva.stopVM(instance, false)
+	}
+
+	if err := sva.checkDeadline("detach"); err != nil {
 		return err
 	}
+	doneDetaching := sva.timePhase("detach")
+	sva.unmountGuestDataVolume(instance)
+	detached := sva.detachUserDataDisk(instance)
+	doneDetaching()
+
+	if err := sva.checkDeadline("stop"); err != nil {
+		return sva.reattachUserDataDiskIf(instance, detached, err)
+	}
+	doneStopping := sva.timePhase("stop")
+	defer doneStopping()
+	done := sva.logger.StartProgress("Powering off Finch virtual machine...")
+	// The poweroff usually drops the SSH connection before the command returns, so its result is meaningless;
+	// whether the instance stops is what tells if it worked.
+	_, _ = sva.creator.CreateWithoutStdio("shell", instance, "sudo", "systemctl", "poweroff").CombinedOutput()
+	var err error
+	if sva.fc.Stop.GuestTimeout > 0 {
+		err = sva.escalateGuestPoweroff(instance, &done)
+	} else {
+		err = sva.waitForStop(instance)
+	}
+	done()
+	if sva.gracePeriodExpired(err) {
+		return sva.forceStopAfterGracePeriod(instance)
+	}
+	if errors.Is(err, errGuestUnresponsive) {
+		sva.logger.Warnf("The instance %q is still running after being powered off from its kernel, forcibly stopping it from the host...",
+			instance)
+		return sva.stopVM(instance, true)
+	}
+	if err != nil {
+		return sva.reattachUserDataDiskIf(instance, detached, err)
+	}
+	sva.logger.Info("Finch virtual machine stopped successfully")
+	return nil
+}
+
+const (
+	defaultVerifyPollInterval = 500 * time.Millisecond
+	defaultVerifyTimeout      = 30 * time.Second
+	// defaultPoweroffTimeout is how long a guest powered off from the inside gets to stop by default. It's longer
+	// than defaultVerifyTimeout, as the services of the guest are stopped before it powers off.
+	defaultPoweroffTimeout = 2 * time.Minute
+)
+
+// waitForStop waits for Lima to report the instance as stopped, see waitForStatus.
+func (sva *stopVMAction) waitForStop(instance string) error {
+	err := sva.waitForStatus(instance, lima.Stopped, sva.verifyTimeout())
+	if errors.Is(err, errTimedOut) {
+		return withCategory(stopErrorTimeout, fmt.Errorf(
+			"the stop phase timed out after %s, the instance %q is still running after being powered off", sva.verifyTimeout(), instance))
+	}
+	return err
+}
+
+// waitForStatus polls the status of the instance every stop.verifyPollInterval until Lima reports it as want,
+// and gives up with errTimedOut after timeout, or once the deadline set by a supervisor has passed.
+func (sva *stopVMAction) waitForStatus(instance string, want lima.VMStatus, timeout time.Duration) error {
+	interval := sva.verifyPollInterval()
+	timeout, byDeadline := sva.untilDeadline(timeout)
+	deadline := time.Now().Add(timeout)
+	for {
+		// The status can be off while the guest is changing state, only the final one matters.
+		if status, err := lima.Status(sva.creator, instance); err == nil && status == want {
+			return nil
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			if byDeadline {
+				return deadlinePassedDuring("stop")
+			}
+			return errTimedOut
+		}
+		time.Sleep(min(interval, remaining))
+	}
+}
+
+// verifyPollInterval is how often the status of the instance is polled while waiting for it to change.
+func (sva *stopVMAction) verifyPollInterval() time.Duration {
+	if sva.fc.Stop.VerifyPollInterval > 0 {
+		return sva.fc.Stop.VerifyPollInterval
+	}
+	return defaultVerifyPollInterval
+}
+
+// verifyTimeout is how long waitForStop waits for: stop.verifyTimeout, or --timeout or --grace-period if set,
+// and defaultPoweroffTimeout otherwise.
+func (sva *stopVMAction) verifyTimeout() time.Duration {
+	if timeout := sva.stopPhaseTimeout(false); timeout > 0 {
+		return timeout
+	}
+	if sva.fc.Stop.VerifyTimeout > 0 {
+		return sva.fc.Stop.VerifyTimeout
+	}
+	return defaultPoweroffTimeout
+}
+
+// confirmRunningContainers prompts the user for confirmation if there are containers running in the instance.
+// If the running containers can't be counted, the stop proceeds as it would without this check.
+func (sva *stopVMAction) confirmRunningContainers(instance string) (bool, error) {
+	out, err := sva.creator.CreateWithoutStdio(sva.guestNerdctlArgs(instance, "ps", "-q")...).Output()
+	if err != nil {
+		sva.logger.Warnf("Could not count the running containers: %v", err)
+		return true, nil
+	}
+	running := len(strings.Fields(string(out)))
+	if running == 0 {
+		return true, nil
+	}
 
-	return sva.stopVM(false)
+	if _, err := fmt.Fprintf(sva.stdout, "%d containers running. Stop anyway? [y/N] ", running); err != nil {
+		return false, err
+	}
+	answer, err := bufio.NewReader(sva.stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, fmt.Errorf("failed to read the confirmation: %w", err)
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	default:
+		return false, nil
+	}
 }
 
-func (sva *stopVMAction) assertVMIsRunning(creator command.NerdctlCmdCreator, logger flog.Logger) error {
-	status, err := lima.GetVMStatus(creator, logger, limaInstanceName)
+func (sva *stopVMAction) assertVMIsRunning(instance string) error {
+	status, err := sva.settledStatus(instance)
 	if err != nil {
 		return err
 	}
+	sva.observedStatus = status
 	switch status {
 	case lima.Nonexistent:
-		return fmt.Errorf("the instance %q does not exist", limaInstanceName)
+		return withStatus(status, fmt.Errorf("the instance %q does not exist", instance))
 	case lima.Stopped:
-		return fmt.Errorf("the instance %q is already stopped", limaInstanceName)
+		if sva.isHibernated(instance) {
+			sva.observedStatus = lima.Suspended
+			return withStatus(lima.Suspended, fmt.Errorf("the instance %q is hibernated", instance))
+		}
+		return withStatus(status, fmt.Errorf("the instance %q is already stopped", instance))
+	case lima.Suspended:
+		return withStatus(status, fmt.Errorf("the instance %q is suspended", instance))
+	case lima.Broken:
+		return withStatus(status, fmt.Errorf("the instance %q is broken, use --force to stop it", instance))
+	case lima.Starting:
+		return fmt.Errorf("the instance %q is still starting; retry shortly", instance)
 	default:
 		return nil
 	}
 }
 
-func (sva *stopVMAction) stopVM(force bool) error {
-	limaCmd := sva.createLimaStopCommand(force)
+// startingSettleTimeout is how long a stop waits for an instance that is booting to be running, or to fail to.
+const startingSettleTimeout = 10 * time.Second
+
+// settledStatus returns the status of the instance, waiting up to settleTimeout for it to settle
+// if it's starting, as stopping an instance that is still booting would race with its start.
+func (sva *stopVMAction) settledStatus(instance string) (lima.VMStatus, error) {
+	status, err := lima.Status(sva.creator, instance)
+	if err != nil || status != lima.Starting {
+		return status, err
+	}
+	sva.logger.Infof("The instance %q is starting, waiting for it to settle before stopping it...", instance)
+	deadline := time.Now().Add(sva.settleTimeout)
+	for err == nil && status == lima.Starting {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			break
+		}
+		time.Sleep(min(sva.verifyPollInterval(), remaining))
+		status, err = lima.Status(sva.creator, instance)
+	}
+	return status, err
+}
+
+func (sva *stopVMAction) stopVM(instance string, force bool) error {
+	if err := sva.checkDeadline("detach"); err != nil {
+		return err
+	}
+	stopArgs, err := sva.limaStopArgs(instance, force)
+	if err != nil {
+		return err
+	}
+	limaCmd := sva.creator.CreateWithoutStdio(stopArgs...)
+	label := "Stopping existing Finch virtual machine..."
 	if force {
-		sva.logger.Info("Forcibly stopping Finch virtual machine...")
-	} else {
-		sva.logger.Info("Stopping existing Finch virtual machine...")
+		// A forced stop usually means something went wrong in the guest,
+		// so keep its kernel log around before it goes away.
+		sva.captureGuestKernelLog(instance)
+		label = "Forcibly stopping Finch virtual machine..."
 	}
 
-	// ignore error, this is to ensure that the disk mount doesn't linger after the VM stops
-	_ = sva.diskManager.DetachUserDataDisk()
+	doneDetaching := sva.timePhase("detach")
+	// A forced stop accepts the risk of detaching a disk the guest is still using, it may not respond anyway.
+	if !force {
+		sva.unmountGuestDataVolume(instance)
+	}
+	detached := sva.detachUserDataDisk(instance)
+	doneDetaching()
+
+	err = sva.runLimactlStop(instance, limaCmd, stopArgs, label, force)
+	if !force && sva.gracePeriodExpired(err) {
+		return sva.forceStopAfterGracePeriod(instance)
+	}
+	// A forced stop accepts leaving the disk detached, an unresponsive guest couldn't use it anyway.
+	return sva.reattachUserDataDiskIf(instance, err != nil && detached && !force, err)
+}
 
-	logs, err := limaCmd.CombinedOutput()
+// runLimactlStop runs limaCmd, the limactl stop of the instance built from stopArgs, once its disk has been detached.
+func (sva *stopVMAction) runLimactlStop(instance string, limaCmd command.Command, stopArgs []string, label string, force bool) error {
+	if err := sva.checkDeadline("stop"); err != nil {
+		return err
+	}
+	// Stopping can take a while, show that it's still going on.
+	doneStopping := sva.timePhase("stop")
+	done := sva.logger.StartProgress(label)
+	timeout := sva.stopPhaseTimeout(force)
+	limit, byDeadline := sva.untilDeadline(timeout)
+	logs, err := combinedOutputWithin(limaCmd, limit)
+	done()
+	if errors.Is(err, errTimedOut) {
+		doneStopping()
+		if byDeadline {
+			return deadlinePassedDuring("stop")
+		}
+		return stopPhaseTimedOut(timeout, force)
+	}
+	if err != nil && sva.ownsUserDataDisk(instance) && !sva.fc.Disk.Ephemeral && isDiskInUse(logs) {
+		// The guest can grab the user data disk again after it has been detached,
+		// detaching it once more is usually enough for the stop to go through.
+		sva.logger.Warnln("The user data disk is still in use, detaching it again and retrying the stop...")
+		_ = sva.diskManager.DetachUserDataDisk()
+		limit, byDeadline = sva.untilDeadline(timeout)
+		logs, err = combinedOutputWithin(sva.creator.CreateWithoutStdio(stopArgs...), limit)
+		if errors.Is(err, errTimedOut) {
+			doneStopping()
+			if byDeadline {
+				return deadlinePassedDuring("stop")
+			}
+			return stopPhaseTimedOut(timeout, force)
+		}
+	}
+	if err != nil && force && isForceUnsupported(logs) {
+		sva.logger.Warnln("The installed limactl doesn't support forcibly stopping an instance, killing its processes instead...")
+		err = sva.killInstanceProcesses(instance)
+	}
+	doneStopping()
 	if err != nil {
+		category := stopErrorLimactl
+		if isDiskInUse(logs) {
+			category = stopErrorDisk
+		}
+		// The exit code tells a crash of limactl apart from a stop it refused, e.g. the one of a missing instance.
+		// A limactl killed by a signal has no exit code of its own, e.g. one killed by the OOM killer.
+		if sig, ok := exitSignal(err); ok {
+			sva.logger.Errorf("Finch virtual machine failed to stop, limactl stop was terminated by signal %d (%s), debug logs:\n%s",
+				int(sig), sig, logs)
+			return withCategory(category, fmt.Errorf("limactl stop was terminated by signal %d (%s): %w", int(sig), sig, err))
+		}
+		if code, ok := exitCode(err); ok {
+			sva.logger.Errorf("Finch virtual machine failed to stop, limactl stop exited with code %d, debug logs:\n%s", code, logs)
+			return withCategory(category, fmt.Errorf("limactl stop exited with code %d: %w", code, err))
+		}
 		sva.logger.Errorf("Finch virtual machine failed to stop, debug logs:\n%s", logs)
-		return err
+		return withCategory(category, err)
 	}
 	sva.logger.Info("Finch virtual machine stopped successfully")
 	return nil
 }
 
-func (sva *stopVMAction) createLimaStopCommand(force bool) command.Command {
-	if force {
-		return sva.creator.CreateWithoutStdio("stop", "--force", limaInstanceName)
+// exitCode returns the exit code of the command that failed with err, if it ran to completion.
+func exitCode(err error) (int, bool) {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return 0, false
+	}
+	return exitErr.ExitCode(), true
+}
+
+// exitSignal returns the signal that terminated the command that failed with err, if it was terminated by one.
+// The exit code of such a command is -1, which doesn't tell what happened to it.
+func exitSignal(err error) (syscall.Signal, bool) {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return 0, false
+	}
+	status, ok := exitErr.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return 0, false
+	}
+	return status.Signal(), true
+}
+
+// isForceUnsupported reports whether limactl failed to stop the VM because it's too old to know about `stop --force`.
+func isForceUnsupported(logs []byte) bool {
+	return strings.Contains(string(logs), "unknown flag: --force")
+}
+
+// instancePIDFiles are the files Lima records the PIDs of the processes running an instance in,
+// the host agent first, then the QEMU driver, if any.
+var instancePIDFiles = []string{"ha.pid", "qemu.pid"}
+
+// killInstanceProcesses kills the processes running the instance, which is what `limactl stop --force` does.
+func (sva *stopVMAction) killInstanceProcesses(instance string) error {
+	instanceDir := filepath.Join(sva.limaHomePath(), instance)
+	killed := 0
+	for _, pidFile := range instancePIDFiles {
+		b, err := afero.ReadFile(sva.fs, filepath.Join(instanceDir, pidFile))
+		if err != nil {
+			if errors.Is(err, afero.ErrFileNotFound) {
+				continue
+			}
+			return fmt.Errorf("failed to read %s: %w", pidFile, err)
+		}
+		pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+		if err != nil {
+			return fmt.Errorf("failed to parse %s: %w", pidFile, err)
+		}
+
+		name, args := killProcessCommand(pid)
+		if out, err := sva.ecc.Create(name, args...).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to kill process %d: %w, command output: %s", pid, err, out)
+		}
+		killed++
+	}
+	if killed == 0 {
+		return fmt.Errorf("no running process found for the instance %q", instance)
+	}
+	return nil
+}
+
+// killProcessCommand returns the name and the arguments of the command forcibly killing the process.
+func killProcessCommand(pid int) (string, []string) {
+	if runtime.GOOS == "windows" {
+		return "taskkill.exe", []string{"/F", "/PID", strconv.Itoa(pid)}
+	}
+	return "kill", []string{"-9", strconv.Itoa(pid)}
+}
+
+// detachUserDataDisk detaches the user data disk from the instance before it stops.
+// The user data disk only ever belongs to the Finch instance, and there's no point in detaching an ephemeral one.
+func (sva *stopVMAction) detachUserDataDisk(instance string) bool {
+	if !sva.ownsUserDataDisk(instance) {
+		return false
+	}
+	if sva.fc.Disk.Ephemeral {
+		sva.logger.Infoln("The user data disk is ephemeral, not detaching it")
+		return false
+	}
+	// ignore error, this is to ensure that the disk mount doesn't linger after the VM stops
+	var err error
+	if sva.diskDetachOrder == diskDetachParallel {
+		err = sva.detachDisksInParallel()
+	} else {
+		err = sva.diskManager.DetachUserDataDisk()
+	}
+	// Lima detaches the disk on macOS as the instance stops, so there's nothing to attach back if the stop fails.
+	return err == nil && runtime.GOOS == "windows"
+}
+
+// reattachUserDataDiskIf attaches the user data disk back if reattach is set, i.e. the stop failed with err once
+// the disk was detached, so that the instance, if it's still running, isn't left without it. The disk of an instance
+// that isn't running is attached by its next start.
+// It returns err, joined with the error of attaching the disk back if that failed too.
+func (sva *stopVMAction) reattachUserDataDiskIf(instance string, reattach bool, err error) error {
+	if !reattach {
+		return err
+	}
+	status, statusErr := lima.Status(sva.creator, instance)
+	if statusErr != nil {
+		sva.logger.Warnf("The stop failed after the user data disk was detached, and the status of the instance %q is unknown: %v, "+
+			"not attaching the disk back", instance, statusErr)
+		return err
+	}
+	if status != lima.Running {
+		sva.logger.Infof("The stop failed after the user data disk was detached, the instance %q is %s so its next start "+
+			"attaches the disk back", instance, status)
+		return err
+	}
+	sva.logger.Warnln("The stop failed after the user data disk was detached, attaching it back...")
+	if rollbackErr := sva.diskManager.AttachUserDataDisk(); rollbackErr != nil {
+		return errors.Join(err, withCategory(stopErrorDisk, fmt.Errorf("failed to attach the user data disk back: %w", rollbackErr)))
+	}
+	sva.logger.Infoln("Attached the user data disk back")
+	return err
+}
+
+// verifyDiskDetached fails if the user data disk is still attached once the instance is stopped,
+// which would make a detach that silently did nothing go unnoticed.
+func (sva *stopVMAction) verifyDiskDetached(instance string) error {
+	if !sva.ownsUserDataDisk(instance) || sva.fc.Disk.Ephemeral {
+		return nil
+	}
+	attached, err := sva.diskManager.UserDataDiskAttached()
+	if err != nil {
+		return withCategory(stopErrorDisk, fmt.Errorf("failed to verify that the user data disk is detached: %w", err))
+	}
+	if attached {
+		return withCategory(stopErrorDisk, errors.New("the user data disk is still attached after the stop"))
+	}
+	sva.logger.Debugln("Verified that the user data disk is detached")
+	return nil
+}
+
+// guestDataVolume is where the user data disk is mounted in the guest, the data directories of the guest
+// (e.g. /var/lib/containerd) are bind mounted from it.
+const guestDataVolume = "/mnt/lima-finch"
+
+// guestUnmountAttempts is how many times the unmount of the guest data volume is tried, following the retry policy
+// of the config, as it's busy until the services using it have let go of their files.
+const guestUnmountAttempts = 3
+
+// unmountGuestDataVolume stops the services writing to the user data disk in the guest, then unmounts it
+// with all of its bind mounts, so that it's clean when it gets detached.
+// Failing to do so doesn't prevent the instance from being stopped.
+func (sva *stopVMAction) unmountGuestDataVolume(instance string) {
+	if instance != limaInstanceName || sva.fc.Disk.Ephemeral {
+		return
+	}
+
+	script := fmt.Sprintf("systemctl stop buildkit.service; systemctl stop containerd.service; sync; "+
+		"if mountpoint -q %[1]s; then umount --all-targets --recursive %[1]s; fi", guestDataVolume)
+	for attempt := 1; ; attempt++ {
+		logs, err := sva.creator.CreateWithoutStdio("shell", instance, "sudo", "sh", "-c", script).CombinedOutput()
+		if err == nil {
+			return
+		}
+		if attempt >= guestUnmountAttempts {
+			sva.logger.Warnf("Could not unmount the data volume of the guest, detaching the user data disk anyway: %v, debug logs:\n%s",
+				err, logs)
+			return
+		}
+		sva.logger.Debugf("The data volume of the guest is busy, retrying the unmount: %v", err)
+		time.Sleep(sva.fc.Retry.Delay(attempt))
+	}
+}
+
+// snapshotVM takes a snapshot of the stopped instance, and records its tag in the instance metadata
+// so that `finch vm start --from-snapshot` can restore it.
+func (sva *stopVMAction) snapshotVM(instance, tag string) error {
+	sva.logger.Infof("Taking snapshot %q of the Finch virtual machine...", tag)
+	logs, err := sva.creator.CreateWithoutStdio("snapshot", "create", instance, "--tag", tag).CombinedOutput()
+	if err != nil {
+		sva.logger.Errorf("Finch virtual machine failed to take the snapshot, debug logs:\n%s", logs)
+		return withCategory(stopErrorLimactl, err)
+	}
+
+	instanceDir := filepath.Join(sva.limaHomePath(), instance)
+	if err := lima.UpdateInstanceMetadata(sva.fs, instanceDir, func(md *lima.InstanceMetadata) {
+		if !slices.Contains(md.Snapshots, tag) {
+			md.Snapshots = append(md.Snapshots, tag)
+		}
+	}); err != nil {
+		return fmt.Errorf("failed to record the snapshot %q of the instance %q: %w", tag, instance, err)
+	}
+	sva.logger.Infof("Snapshot %q taken successfully", tag)
+	return nil
+}
+
+// isDiskInUse reports whether limactl failed to stop the VM because the user data disk was still in use.
+func isDiskInUse(logs []byte) bool {
+	return strings.Contains(strings.ToLower(string(logs)), "disk in use")
+}
+
+// hibernateVM stops the instance after saving its state to disk, so that the next start resumes it.
+// Saving the VM state is only possible with vz, and requires a limactl that supports `stop --save-state`.
+func (sva *stopVMAction) hibernateVM(instance string) error {
+	vmType, err := lima.GetVMType(sva.creator, sva.logger, instance)
+	if err != nil {
+		return err
+	}
+	if vmType != lima.VZ {
+		return fmt.Errorf("hibernation is only supported for %q VMs, the instance %q is a %q VM", lima.VZ, instance, vmType)
+	}
+	help, err := sva.creator.CreateWithoutStdio("stop", "--help").Output()
+	if err != nil {
+		return fmt.Errorf("failed to check if limactl can save the VM state: %w", err)
+	}
+	if !strings.Contains(string(help), "--save-state") {
+		return errors.New("the installed limactl does not support saving the VM state")
+	}
+
+	sva.logger.Info("Hibernating Finch virtual machine...")
+	detached := sva.detachUserDataDisk(instance)
+	logs, err := sva.creator.CreateWithoutStdio("stop", "--save-state", instance).CombinedOutput()
+	if err != nil {
+		sva.logger.Errorf("Finch virtual machine failed to hibernate, debug logs:\n%s", logs)
+		return sva.reattachUserDataDiskIf(instance, detached, withCategory(stopErrorLimactl, err))
+	}
+
+	instanceDir := filepath.Join(sva.limaHomePath(), instance)
+	if err := lima.UpdateInstanceMetadata(sva.fs, instanceDir, func(md *lima.InstanceMetadata) {
+		md.Hibernated = true
+	}); err != nil {
+		return fmt.Errorf("failed to record that the instance %q is hibernated: %w", instance, err)
+	}
+	sva.logger.Info("Finch virtual machine hibernated successfully")
+	return nil
+}
+
+// stopCommandData is what stop.commandTemplate is rendered with.
+type stopCommandData struct {
+	Instance string
+	Force    bool
+}
+
+// limaStopArgs returns the arguments limactl is run with to stop the instance: `stop [--force] <instance>`,
+// or what stop.commandTemplate renders to if set, split on whitespace.
+func (sva *stopVMAction) limaStopArgs(instance string, force bool) ([]string, error) {
+	if sva.fc.Stop.CommandTemplate == "" {
+		if force {
+			return []string{"stop", "--force", instance}, nil
+		}
+		return []string{"stop", instance}, nil
+	}
+
+	tmpl, err := template.New("commandTemplate").Parse(sva.fc.Stop.CommandTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse stop.commandTemplate: %w", err)
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, stopCommandData{Instance: instance, Force: force}); err != nil {
+		return nil, fmt.Errorf("failed to render stop.commandTemplate: %w", err)
+	}
+	args := strings.Fields(b.String())
+	if len(args) == 0 {
+		return nil, fmt.Errorf("stop.commandTemplate %q renders to an empty command", sva.fc.Stop.CommandTemplate)
+	}
+	return args, nil
+}
+
+// serialLogFiles are the names of the serial console logs Lima keeps in the instance directory,
+// in order of preference. Which of them exist depends on the VM type.
+var serialLogFiles = []string{"serial.log", "serialv.log", "serialp.log"}
+
+// captureGuestKernelLog saves the guest kernel log of the instance to the diagnostics directory.
+// The serial console log kept by Lima is preferred since it's readable even if the guest hangs,
+// otherwise dmesg is read over SSH if the instance is running.
+// This is best-effort and never prevents the instance from being stopped.
+func (sva *stopVMAction) captureGuestKernelLog(instance string) {
+	kernelLog, err := sva.readSerialLog(instance)
+	if err != nil {
+		sva.logger.Debugf("Could not read the serial log of the instance: %v", err)
+		kernelLog, err = sva.readGuestDmesg(instance)
+		if err != nil {
+			sva.logger.Warnf("Could not capture the guest kernel log: %v", err)
+			return
+		}
+	}
+
+	diagnosticsDir := sva.diagnosticsDir()
+	if err := sva.fs.MkdirAll(diagnosticsDir, 0o700); err != nil {
+		sva.logger.Warnf("Could not create the diagnostics directory: %v", err)
+		return
+	}
+	logPath := filepath.Join(diagnosticsDir, fmt.Sprintf("%s-kernel-%s.log", instance, time.Now().Format("20060102-150405")))
+	if sva.compressLogs {
+		if kernelLog, err = gzipLog(kernelLog); err != nil {
+			sva.logger.Warnf("Could not compress the guest kernel log: %v", err)
+			return
+		}
+		logPath += ".gz"
+	}
+	if err := afero.WriteFile(sva.fs, logPath, kernelLog, 0o600); err != nil {
+		sva.logger.Warnf("Could not save the guest kernel log: %v", err)
+		return
+	}
+	sva.logger.Infof("Guest kernel log saved to %q", logPath)
+}
+
+// diagnosticsDir is where the diagnostics collected by the stop are saved, under --output-dir if set.
+func (sva *stopVMAction) diagnosticsDir() string {
+	if sva.outputDir != "" {
+		return filepath.Join(sva.outputDir, "diagnostics")
+	}
+	return sva.fp.DiagnosticsDir(sva.finchRootPath)
+}
+
+// gzipLog compresses a log to be saved, they're mostly text and shrink a lot.
+func gzipLog(log []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(log); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (sva *stopVMAction) readSerialLog(instance string) ([]byte, error) {
+	instanceDir := filepath.Join(sva.limaHomePath(), instance)
+	for _, name := range serialLogFiles {
+		serialLog, err := afero.ReadFile(sva.fs, filepath.Join(instanceDir, name))
+		if err == nil && len(serialLog) > 0 {
+			return serialLog, nil
+		}
+	}
+	return nil, fmt.Errorf("no serial log found in %q", instanceDir)
+}
+
+func (sva *stopVMAction) readGuestDmesg(instance string) ([]byte, error) {
+	status, err := lima.GetVMStatus(sva.creator, sva.logger, instance)
+	if err != nil {
+		return nil, err
+	}
+	if status != lima.Running {
+		return nil, fmt.Errorf("the instance %q is not running", instance)
+	}
+	return sva.creator.CreateWithoutStdio("shell", instance, "sudo", "dmesg").Output()
+}
+
+// ownsUserDataDisk reports whether the user data disk of Finch belongs to the instance, which is only the case
+// of the Finch instance in the Lima home of Finch, an instance of the same name in another one, see --lima-home,
+// doesn't use it.
+func (sva *stopVMAction) ownsUserDataDisk(instance string) bool {
+	if instance != limaInstanceName {
+		return false
+	}
+	return sva.limaHome == "" || filepath.Clean(sva.limaHome) == filepath.Clean(sva.fp.LimaHomePath())
+}
+
+// limaHomePath returns the path to the Lima home the instances are in.
+func (sva *stopVMAction) limaHomePath() string {
+	if sva.limaHome != "" {
+		return sva.limaHome
+	}
+	return sva.fp.LimaHomePath()
+}
+
+// isTerminal returns true if r is a character device, i.e., the user can interact with it.
+func isTerminal(r io.Reader) bool {
+	f, ok := r.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// readInstanceFile returns the instance names listed in the file at path, one per line.
+// Surrounding whitespace is trimmed, and blank lines and lines starting with # are skipped.
+func readInstanceFile(fs afero.Fs, path string) ([]string, error) {
+	data, err := afero.ReadFile(fs, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read instance file: %w", err)
+	}
+
+	var instances []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		instances = append(instances, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to parse instance file: %w", err)
 	}
-	return sva.creator.CreateWithoutStdio("stop", limaInstanceName)
+	return instances, nil
 }
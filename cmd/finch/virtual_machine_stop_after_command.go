@@ -0,0 +1,47 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// stopAfterCommand runs the command of --after-command, then stops the VM, unless the command failed with
+// --only-on-success. The failure of the stop takes precedence over the one of the command, it's what needs fixing.
+func (sva *stopVMAction) stopAfterCommand(opts stopVMOptions) error {
+	afterErr := sva.runAfterCommand(opts.afterCommand)
+	if afterErr != nil && opts.onlyOnSuccess {
+		sva.logger.Warnln("The after-command failed, the virtual machine isn't stopped because of --only-on-success")
+		return afterErr
+	}
+	opts.afterCommand = ""
+	opts.onlyOnSuccess = false
+	opts.validateConfigFirst = false
+	if err := sva.run(opts); err != nil {
+		return err
+	}
+	return afterErr
+}
+
+// runAfterCommand runs the command with the host shell and the standard streams of finch, so that it can be
+// interacted with and its output streams through. It failing is an afterCommandError with its exit code.
+func (sva *stopVMAction) runAfterCommand(afterCommand string) error {
+	sva.logger.Infof("Running %q before stopping the virtual machine...", afterCommand)
+	name, args := hostShellCommand(afterCommand)
+	cmd := sva.ecc.Create(name, args...)
+	cmd.SetStdin(sva.stdin)
+	cmd.SetStdout(sva.stdout)
+	cmd.SetStderr(os.Stderr)
+	err := cmd.Run()
+	if err == nil {
+		return nil
+	}
+	if code, ok := exitCode(err); ok {
+		return &afterCommandError{Code: code}
+	}
+	return fmt.Errorf("failed to run the after-command: %w", err)
+}
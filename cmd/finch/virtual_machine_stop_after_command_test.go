@@ -0,0 +1,114 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/mocks"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestStopVMAction_runWithAfterCommand(t *testing.T) {
+	t.Parallel()
+
+	const afterCommand = "make test"
+
+	// The exit error of a command exiting with code 2, as the test binary does on an unknown flag.
+	var exitErr *exec.ExitError
+	require.ErrorAs(t, exec.Command(os.Args[0], "-test.unknown-flag").Run(), &exitErr)
+
+	testCases := []struct {
+		name          string
+		onlyOnSuccess bool
+		runErr        error
+		wantStop      bool
+		wantErr       error
+		wantExitCode  int
+	}{
+		{
+			name:     "should stop the instance once the command succeeds",
+			wantStop: true,
+		},
+		{
+			name:         "should stop the instance and exit with the code of the command if it fails",
+			runErr:       exitErr,
+			wantStop:     true,
+			wantErr:      &afterCommandError{Code: 2},
+			wantExitCode: 2,
+		},
+		{
+			name:          "should not stop the instance if the command fails with --only-on-success",
+			onlyOnSuccess: true,
+			runErr:        exitErr,
+			wantErr:       &afterCommandError{Code: 2},
+			wantExitCode:  2,
+		},
+		{
+			name:         "should stop the instance if the command can't be run",
+			runErr:       errors.New("no such file or directory"),
+			wantStop:     true,
+			wantErr:      errors.New("failed to run the after-command: no such file or directory"),
+			wantExitCode: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+			ecc := mocks.NewCommandCreator(ctrl)
+
+			logger.EXPECT().Infof("Running %q before stopping the virtual machine...", afterCommand)
+			name, args := hostShellCommand(afterCommand)
+			afterC := mocks.NewCommand(ctrl)
+			ecc.EXPECT().Create(name, args).Return(afterC)
+			afterC.EXPECT().SetStdin(gomock.Any())
+			afterC.EXPECT().SetStdout(os.Stdout)
+			afterC.EXPECT().SetStderr(os.Stderr)
+			afterC.EXPECT().Run().Return(tc.runErr)
+			if tc.wantStop {
+				getVMStatusC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+				getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+				expectGracefulStop(ncc, dm, logger, ctrl)
+			} else {
+				logger.EXPECT().Warnln("The after-command failed, the virtual machine isn't stopped because of --only-on-success")
+			}
+
+			action := newStopVMAction(ncc, ecc, dm, logger, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath,
+				nil, os.Stdout, nil)
+			err := action.run(stopVMOptions{afterCommand: afterCommand, onlyOnSuccess: tc.onlyOnSuccess})
+			if tc.wantErr == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tc.wantErr.Error())
+			assert.Equal(t, tc.wantExitCode, exitCodeOf(err))
+		})
+	}
+}
+
+func TestStopVMAction_runRejectsOnlyOnSuccessWithoutAfterCommand(t *testing.T) {
+	t.Parallel()
+
+	action := newStopVMAction(nil, nil, nil, nil, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath,
+		nil, nil, nil)
+	err := action.run(stopVMOptions{onlyOnSuccess: true})
+	assert.EqualError(t, err, "--only-on-success only applies to --after-command")
+}
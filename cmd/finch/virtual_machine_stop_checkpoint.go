@@ -0,0 +1,61 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/runfinch/finch/pkg/lima"
+)
+
+// containersCheckpointName is the name of the checkpoints taken of the running containers when the VM is stopped.
+// nerdctl keeps them in the containerd content store, which lives on the user data disk, so they outlive the VM.
+const containersCheckpointName = "finch-vm-stop"
+
+// checkpointContainers checkpoints the containers running in the guest, which stops them, and records them in the
+// instance metadata for the next start to restore them. Checkpointing is best-effort: once a container can't be
+// checkpointed, e.g. because CRIU isn't available in the guest, it and the remaining containers are left running
+// for the rest of the stop to stop them like any other.
+func (sva *stopVMAction) checkpointContainers(instance string) {
+	if !sva.isFinchInstance(instance) {
+		sva.logger.Warnf("Not checkpointing the containers of the instance %q, which wasn't created by Finch", instance)
+		return
+	}
+	out, err := sva.creator.CreateWithoutStdio("shell", instance, "sudo", "-E", "nerdctl", "ps", "-q").Output()
+	if err != nil {
+		sva.logger.Warnf("Could not list the running containers to checkpoint: %v", err)
+		return
+	}
+	containers := strings.Fields(string(out))
+	if len(containers) == 0 {
+		return
+	}
+
+	sva.logger.Infof("Checkpointing %d running containers...", len(containers))
+	var checkpointed []string
+	for _, container := range containers {
+		out, err := sva.creator.CreateWithoutStdio("shell", instance, "sudo", "-E", "nerdctl", "checkpoint", "create",
+			container, containersCheckpointName).CombinedOutput()
+		if err != nil {
+			sva.logger.Warnf("Could not checkpoint the container %s, the remaining containers will be stopped instead: %v, "+
+				"command output: %s", container, err, out)
+			break
+		}
+		checkpointed = append(checkpointed, container)
+	}
+	if len(checkpointed) == 0 {
+		return
+	}
+
+	if err := lima.UpdateInstanceMetadata(sva.fs, filepath.Join(sva.limaHomePath(), instance), func(md *lima.InstanceMetadata) {
+		md.CheckpointedContainers = checkpointed
+	}); err != nil {
+		sva.logger.Warnf("Could not record the checkpointed containers, they won't be restored on the next start: %v", err)
+		return
+	}
+	sva.logger.Infof("Checkpointed %d containers, they will be restored on the next start", len(checkpointed))
+}
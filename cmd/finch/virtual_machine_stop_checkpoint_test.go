@@ -0,0 +1,115 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/lima"
+	"github.com/runfinch/finch/pkg/mocks"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestStopVMAction_runWithPreserveContainersState(t *testing.T) {
+	t.Parallel()
+
+	psArgs := []any{"shell", limaInstanceName, "sudo", "-E", "nerdctl", "ps", "-q"}
+	checkpointArgs := func(container string) []any {
+		return []any{"shell", limaInstanceName, "sudo", "-E", "nerdctl", "checkpoint", "create", container, "finch-vm-stop"}
+	}
+
+	testCases := []struct {
+		name             string
+		mockSvc          func(*mocks.NerdctlCmdCreator, *mocks.Logger, *gomock.Controller)
+		wantCheckpointed []string
+	}{
+		{
+			name: "should checkpoint the running containers before the VM is stopped",
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, logger *mocks.Logger, ctrl *gomock.Controller) {
+				psC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(psArgs...).Return(psC)
+				psC.EXPECT().Output().Return([]byte("c1\nc2\n"), nil)
+				logger.EXPECT().Infof("Checkpointing %d running containers...", 2)
+				for _, container := range []string{"c1", "c2"} {
+					checkpointC := mocks.NewCommand(ctrl)
+					ncc.EXPECT().CreateWithoutStdio(checkpointArgs(container)...).Return(checkpointC)
+					checkpointC.EXPECT().CombinedOutput().Return([]byte("finch-vm-stop\n"), nil)
+				}
+				logger.EXPECT().Infof("Checkpointed %d containers, they will be restored on the next start", 2)
+			},
+			wantCheckpointed: []string{"c1", "c2"},
+		},
+		{
+			name: "should fall back to stopping the containers when the runtime can't checkpoint them",
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, logger *mocks.Logger, ctrl *gomock.Controller) {
+				psC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(psArgs...).Return(psC)
+				psC.EXPECT().Output().Return([]byte("c1\nc2\n"), nil)
+				logger.EXPECT().Infof("Checkpointing %d running containers...", 2)
+				checkpointC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(checkpointArgs("c1")...).Return(checkpointC)
+				checkpointC.EXPECT().CombinedOutput().Return([]byte("criu not found"), errors.New("exit status 1"))
+				logger.EXPECT().Warnf("Could not checkpoint the container %s, the remaining containers will be stopped instead: %v, "+
+					"command output: %s", "c1", errors.New("exit status 1"), []byte("criu not found"))
+			},
+		},
+		{
+			name: "should do nothing without running containers",
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, _ *mocks.Logger, ctrl *gomock.Controller) {
+				psC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(psArgs...).Return(psC)
+				psC.EXPECT().Output().Return([]byte(""), nil)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+
+			getVMStatusC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+			getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+			tc.mockSvc(ncc, logger, ctrl)
+			expectUnmountGuestDataVolume(ncc, ctrl)
+			dm.EXPECT().DetachUserDataDisk().Return(nil)
+			stopC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(stopC)
+			stopC.EXPECT().CombinedOutput()
+			logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+			logger.EXPECT().Info("Finch virtual machine stopped successfully")
+
+			fs := afero.NewMemMapFs()
+			action := newStopVMAction(ncc, nil, dm, logger, fs, mockFinchPath, &config.Finch{}, mockFinchRootPath, nil, nil, nil)
+			require.NoError(t, action.run(stopVMOptions{preserveContainersState: true}))
+
+			md, err := lima.LoadInstanceMetadata(fs, filepath.Join(mockFinchPath.LimaHomePath(), limaInstanceName))
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantCheckpointed, md.CheckpointedContainers)
+		})
+	}
+}
+
+func TestStopVMAction_runRejectsPreserveContainersStateWithForce(t *testing.T) {
+	t.Parallel()
+
+	action := newStopVMAction(nil, nil, nil, nil, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath,
+		nil, nil, nil)
+	err := action.run(stopVMOptions{force: true, preserveContainersState: true})
+	assert.EqualError(t, err, "--preserve-containers-state cannot be used together with --force or --hibernate")
+}
@@ -0,0 +1,33 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import "time"
+
+// containerdRootDir is where containerd keeps its content store and metadata in the guest,
+// it's bind mounted from the user data disk.
+const containerdRootDir = "/var/lib/containerd"
+
+// flushContainerdContentStore flushes the content store of containerd to the user data disk, and reports how long it
+// took, as the blobs it has yet to write back could be left corrupted once the disk is detached. containerd keeps
+// running, so that it still serves if the stop fails before the disk is detached; it commits its metadata database
+// on every transaction, and it's stopped along with the unmount of the data volume right before the detach.
+// Failing to flush it doesn't prevent the VM from being stopped.
+func (sva *stopVMAction) flushContainerdContentStore(instance string) {
+	// The user data disk only ever belongs to the Finch instance, and an ephemeral one doesn't outlive the VM anyway.
+	if instance != limaInstanceName || sva.fc.Disk.Ephemeral {
+		return
+	}
+
+	sva.logger.Info("Flushing the containerd content store to the user data disk...")
+	start := time.Now()
+	logs, err := sva.creator.CreateWithoutStdio("shell", instance, "sudo", "sync", "-f", containerdRootDir).CombinedOutput()
+	if err != nil {
+		sva.logger.Warnf("Could not flush the containerd content store: %v, debug logs:\n%s", err, logs)
+		return
+	}
+	sva.logger.Infof("Flushed the containerd content store in %s", time.Since(start).Round(time.Millisecond))
+}
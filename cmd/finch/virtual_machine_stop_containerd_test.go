@@ -0,0 +1,116 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/mocks"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestStopVMAction_runWithContainerdFlushOnStop(t *testing.T) {
+	t.Parallel()
+
+	flushArgs := []any{"shell", limaInstanceName, "sudo", "sync", "-f", "/var/lib/containerd"}
+
+	testCases := []struct {
+		name    string
+		force   bool
+		mockSvc func(t *testing.T, logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, dm *mocks.UserDataDiskManager,
+			fs afero.Fs, ctrl *gomock.Controller)
+	}{
+		{
+			name: "should flush the containerd content store before detaching the disk",
+			mockSvc: func(
+				_ *testing.T,
+				logger *mocks.Logger,
+				creator *mocks.NerdctlCmdCreator,
+				dm *mocks.UserDataDiskManager,
+				_ afero.Fs,
+				ctrl *gomock.Controller,
+			) {
+				getVMStatusC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+				getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+				logger.EXPECT().Info("Flushing the containerd content store to the user data disk...")
+				flushC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio(flushArgs...).Return(flushC)
+				flushC.EXPECT().CombinedOutput()
+				logger.EXPECT().Infof("Flushed the containerd content store in %s", gomock.Any())
+				expectGracefulStop(creator, dm, logger, ctrl)
+			},
+		},
+		{
+			name: "should still stop the instance if the containerd content store fails to be flushed",
+			mockSvc: func(
+				_ *testing.T,
+				logger *mocks.Logger,
+				creator *mocks.NerdctlCmdCreator,
+				dm *mocks.UserDataDiskManager,
+				_ afero.Fs,
+				ctrl *gomock.Controller,
+			) {
+				getVMStatusC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+				getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+				logger.EXPECT().Info("Flushing the containerd content store to the user data disk...")
+				flushC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio(flushArgs...).Return(flushC)
+				logs := []byte("sync: error syncing '/var/lib/containerd': Input/output error")
+				flushC.EXPECT().CombinedOutput().Return(logs, errors.New("exit status 1"))
+				logger.EXPECT().Warnf("Could not flush the containerd content store: %v, debug logs:\n%s", errors.New("exit status 1"), logs)
+				expectGracefulStop(creator, dm, logger, ctrl)
+			},
+		},
+		{
+			name:  "should not flush the containerd content store on a forced stop",
+			force: true,
+			mockSvc: func(
+				t *testing.T,
+				logger *mocks.Logger,
+				creator *mocks.NerdctlCmdCreator,
+				dm *mocks.UserDataDiskManager,
+				fs afero.Fs,
+				ctrl *gomock.Controller,
+			) {
+				serialLogPath := filepath.Join(mockFinchPath.LimaInstancePath(), "serial.log")
+				require.NoError(t, afero.WriteFile(fs, serialLogPath, []byte("kernel log"), 0o600))
+				logger.EXPECT().Infof("Guest kernel log saved to %q", gomock.Any())
+				dm.EXPECT().DetachUserDataDisk().Return(nil)
+				logger.EXPECT().StartProgress("Forcibly stopping Finch virtual machine...").Return(func() {})
+				forceStopC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("stop", "--force", limaInstanceName).Return(forceStopC)
+				forceStopC.EXPECT().CombinedOutput()
+				logger.EXPECT().Info("Finch virtual machine stopped successfully")
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+			fs := afero.NewMemMapFs()
+			fc := &config.Finch{}
+			fc.Containerd.FlushOnStop = true
+			tc.mockSvc(t, logger, ncc, dm, fs, ctrl)
+
+			action := newStopVMAction(ncc, nil, dm, logger, fs, mockFinchPath, fc, mockFinchRootPath, nil, nil, nil)
+			require.NoError(t, action.run(stopVMOptions{force: tc.force}))
+		})
+	}
+}
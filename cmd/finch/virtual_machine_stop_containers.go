@@ -0,0 +1,92 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/docker/go-units"
+)
+
+// runningContainer is the part of the output of nerdctl inspect that describes a container for --report-containers.
+type runningContainer struct {
+	ID    string         `json:"Id"`
+	Name  string         `json:"Name"`
+	Image string         `json:"Image"`
+	State containerState `json:"State"`
+}
+
+// reportContainers prints a table of the containers running in the guest, which the stop is about to stop.
+// Like the confirmation, it's informational: if the containers can't be listed, the stop proceeds without it.
+func (sva *stopVMAction) reportContainers(instance string) {
+	containers, err := sva.runningContainers(instance)
+	if err != nil {
+		sva.logger.Warnf("Could not list the running containers: %v", err)
+		return
+	}
+	if len(containers) == 0 {
+		sva.logger.Info("No running containers will be affected by the stop")
+		return
+	}
+	if err := printRunningContainers(sva.stdout, containers, time.Now()); err != nil {
+		sva.logger.Warnf("Could not print the running containers: %v", err)
+	}
+}
+
+// runningContainers returns the containers running in the guest.
+func (sva *stopVMAction) runningContainers(instance string) ([]runningContainer, error) {
+	out, err := sva.creator.CreateWithoutStdio(sva.guestNerdctlArgs(instance, "ps", "-q")...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the containers: %w", err)
+	}
+	ids := strings.Fields(string(out))
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	args := append(sva.guestNerdctlArgs(instance, "inspect", "--format", "{{json .}}"), ids...)
+	out, err = sva.creator.CreateWithoutStdio(args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect the containers: %w", err)
+	}
+	var containers []runningContainer
+	for _, line := range bytes.Split(bytes.TrimSpace(out), []byte("\n")) {
+		var c runningContainer
+		if err := json.Unmarshal(line, &c); err != nil {
+			return nil, fmt.Errorf("failed to parse the description of a container: %w", err)
+		}
+		containers = append(containers, c)
+	}
+	return containers, nil
+}
+
+// printRunningContainers writes the table of the containers, with how long they have been running as of now.
+func printRunningContainers(w io.Writer, containers []runningContainer, now time.Time) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	if _, err := fmt.Fprintln(tw, "CONTAINER ID\tNAME\tIMAGE\tUPTIME"); err != nil {
+		return err
+	}
+	for _, c := range containers {
+		uptime := "unknown"
+		if startedAt, err := time.Parse(time.RFC3339Nano, c.State.StartedAt); err == nil {
+			uptime = units.HumanDuration(now.Sub(startedAt))
+		}
+		id := c.ID
+		if len(id) > 12 {
+			id = id[:12]
+		}
+		if _, err := fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", id, strings.TrimPrefix(c.Name, "/"), c.Image, uptime); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,125 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/mocks"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestPrintRunningContainers(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	containers := []runningContainer{
+		{
+			ID:    "0123456789abcdef0123",
+			Name:  "/web",
+			Image: "public.ecr.aws/docker/library/nginx:latest",
+			State: containerState{StartedAt: now.Add(-2 * time.Hour).Format(time.RFC3339Nano)},
+		},
+		{ID: "fedcba", Name: "db", Image: "postgres"},
+	}
+
+	var out bytes.Buffer
+	require.NoError(t, printRunningContainers(&out, containers, now))
+	assert.Equal(t, "CONTAINER ID   NAME   IMAGE                                        UPTIME\n"+
+		"0123456789ab   web    public.ecr.aws/docker/library/nginx:latest   2 hours\n"+
+		"fedcba         db     postgres                                     unknown\n", out.String())
+}
+
+func TestStopVMAction_runWithReportContainers(t *testing.T) {
+	t.Parallel()
+
+	psArgs := []any{"shell", limaInstanceName, "sudo", "-E", "nerdctl", "ps", "-q"}
+	inspectArgs := []any{"shell", limaInstanceName, "sudo", "-E", "nerdctl", "inspect", "--format", "{{json .}}", "c1"}
+
+	testCases := []struct {
+		name       string
+		mockSvc    func(*mocks.NerdctlCmdCreator, *mocks.Logger, *gomock.Controller)
+		wantStdout string
+	}{
+		{
+			name: "should print the running containers before the VM is stopped",
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, _ *mocks.Logger, ctrl *gomock.Controller) {
+				psC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(psArgs...).Return(psC)
+				psC.EXPECT().Output().Return([]byte("c1\n"), nil)
+				inspectC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(inspectArgs...).Return(inspectC)
+				inspectC.EXPECT().Output().Return([]byte(`{"Id":"c1","Name":"web","Image":"nginx","State":{"StartedAt":""}}`+"\n"), nil)
+			},
+			wantStdout: "CONTAINER ID   NAME   IMAGE   UPTIME\nc1             web    nginx   unknown\n",
+		},
+		{
+			name: "should report that no containers are affected",
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, logger *mocks.Logger, ctrl *gomock.Controller) {
+				psC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(psArgs...).Return(psC)
+				psC.EXPECT().Output().Return([]byte(""), nil)
+				logger.EXPECT().Info("No running containers will be affected by the stop")
+			},
+		},
+		{
+			name: "should stop the VM when the containers can't be listed",
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, logger *mocks.Logger, ctrl *gomock.Controller) {
+				psC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(psArgs...).Return(psC)
+				psC.EXPECT().Output().Return(nil, errors.New("exit status 1"))
+				logger.EXPECT().Warnf("Could not list the running containers: %v",
+					errors.New("failed to list the containers: exit status 1"))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+
+			getVMStatusC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+			getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+			tc.mockSvc(ncc, logger, ctrl)
+			expectUnmountGuestDataVolume(ncc, ctrl)
+			dm.EXPECT().DetachUserDataDisk().Return(nil)
+			stopC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(stopC)
+			stopC.EXPECT().CombinedOutput()
+			logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+			logger.EXPECT().Info("Finch virtual machine stopped successfully")
+
+			var stdout bytes.Buffer
+			action := newStopVMAction(ncc, nil, dm, logger, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath,
+				nil, &stdout, nil)
+			require.NoError(t, action.run(stopVMOptions{reportContainers: true}))
+			assert.Equal(t, tc.wantStdout, stdout.String())
+		})
+	}
+}
+
+func TestStopVMAction_runRejectsReportContainersWithForce(t *testing.T) {
+	t.Parallel()
+
+	action := newStopVMAction(nil, nil, nil, nil, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath,
+		nil, nil, nil)
+	err := action.run(stopVMOptions{force: true, reportContainers: true})
+	assert.EqualError(t, err, "--force and --report-containers cannot be used together")
+}
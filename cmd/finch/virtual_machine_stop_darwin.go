@@ -0,0 +1,87 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+
+	"github.com/runfinch/finch/pkg/config"
+)
+
+// sharedNetworkPIDFile is where Lima records the PID of the socket_vmnet daemon serving the finch-shared network,
+// i.e., <varRun>/<network>_socket_vmnet.pid, as configured in networks.yaml.
+const sharedNetworkPIDFile = "/private/var/run/finch-lima/finch-shared_socket_vmnet.pid"
+
+// configuredMemory returns the memory allocated to the Finch VM in finch.yaml, or an empty string if it isn't set.
+func configuredMemory(fc *config.Finch) string {
+	if fc.Memory == nil {
+		return ""
+	}
+	return *fc.Memory
+}
+
+// cleanUpNetwork terminates the socket_vmnet daemon of the finch-shared network if it outlived a forced stop,
+// as its NAT and packet filter rules would prevent the VM from starting again.
+// The rules belong to the vmnet interface of the daemon, so they are removed along with it.
+func (sva *stopVMAction) cleanUpNetwork() error {
+	if _, err := sva.fs.Stat(sharedNetworkPIDFile); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			sva.logger.Debugln("No socket_vmnet daemon left to clean up")
+			return nil
+		}
+		return err
+	}
+	// This is the command Lima stops the daemon with, which the sudoers file installed along with it allows.
+	out, err := sva.ecc.Create("sudo", "--user", "root", "--group", "wheel", "--non-interactive",
+		"/usr/bin/pkill", "-F", sharedNetworkPIDFile).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to terminate the socket_vmnet daemon: %w, command output: %s", err, out)
+	}
+	sva.logger.Infoln("Cleaned up the network of the virtual machine")
+	return nil
+}
+
+// preventSleep holds a power assertion with caffeinate for as long as the stop runs, so that the host doesn't sleep
+// in the middle of it and leave the VM half stopped. caffeinate holds it while cat runs, and cat exits once its stdin
+// is closed, either by the returned function or by finch exiting whichever way, so the assertion never outlives finch.
+// Failing to hold it only risks the host sleeping, which doesn't prevent the stop.
+func (sva *stopVMAction) preventSleep() (release func()) {
+	cmd := sva.ecc.Create("caffeinate", "-i", "-s", "cat")
+	stdin, err := cmd.StdinPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		sva.logger.Warnf("Could not prevent the host from sleeping during the stop: %v", err)
+		return func() {}
+	}
+	sva.logger.Debugln("Preventing the host from sleeping until the stop is over")
+	return func() {
+		_ = stdin.Close()
+		_ = cmd.Wait()
+	}
+}
+
+// hostServices returns the labels of the launchd jobs named after Finch, among the ones of the user finch runs as.
+func (sva *stopVMAction) hostServices() ([]string, error) {
+	out, err := sva.ecc.Create("launchctl", "list").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the launchd jobs: %w", err)
+	}
+	// Each line is "<PID>\t<status>\t<label>", after a header.
+	var labels []string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		labels = append(labels, fields[len(fields)-1])
+	}
+	return finchServices(labels), nil
+}
@@ -0,0 +1,220 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/mocks"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+	"github.com/xorcare/pointer"
+	"go.uber.org/mock/gomock"
+)
+
+func TestStopVMAction_runLogsFreedMemory(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name    string
+		memory  *string
+		mockSvc func(logger *mocks.Logger)
+	}{
+		{
+			name:   "should log the memory of the VM",
+			memory: pointer.String("4GiB"),
+			mockSvc: func(logger *mocks.Logger) {
+				logger.EXPECT().Infof("Freed ~%s of host memory", "4GiB")
+			},
+		},
+		{
+			name:    "should not log anything if the memory isn't set",
+			memory:  nil,
+			mockSvc: func(_ *mocks.Logger) {},
+		},
+		{
+			name:   "should only debug log an invalid memory",
+			memory: pointer.String("lots"),
+			mockSvc: func(logger *mocks.Logger) {
+				logger.EXPECT().Debugf("Could not parse the memory of the virtual machine %q: %v", "lots", gomock.Any())
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+			fc := &config.Finch{}
+			fc.Memory = tc.memory
+
+			getVMStatusC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+			getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+			expectUnmountGuestDataVolume(ncc, ctrl)
+			dm.EXPECT().DetachUserDataDisk().Return(nil)
+			stopC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(stopC)
+			stopC.EXPECT().CombinedOutput()
+			logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+			logger.EXPECT().Info("Finch virtual machine stopped successfully")
+			tc.mockSvc(logger)
+
+			action := newStopVMAction(ncc, nil, dm, logger, afero.NewMemMapFs(), mockFinchPath, fc, mockFinchRootPath, nil, nil, nil)
+			require.NoError(t, action.run(stopVMOptions{}))
+		})
+	}
+}
+
+func TestStopVMAction_runCleansUpNetworkOnForceStop(t *testing.T) {
+	t.Parallel()
+
+	pkillArgs := []any{"--user", "root", "--group", "wheel", "--non-interactive", "/usr/bin/pkill", "-F", sharedNetworkPIDFile}
+
+	testCases := []struct {
+		name    string
+		cleanup bool
+		mockSvc func(*mocks.Logger, *mocks.CommandCreator, *gomock.Controller, afero.Fs)
+	}{
+		{
+			name:    "should terminate the socket_vmnet daemon",
+			cleanup: true,
+			mockSvc: func(logger *mocks.Logger, ecc *mocks.CommandCreator, ctrl *gomock.Controller, fs afero.Fs) {
+				require.NoError(t, afero.WriteFile(fs, sharedNetworkPIDFile, []byte("42"), 0o644))
+				pkillC := mocks.NewCommand(ctrl)
+				ecc.EXPECT().Create("sudo", pkillArgs...).Return(pkillC)
+				pkillC.EXPECT().CombinedOutput()
+				logger.EXPECT().Infoln("Cleaned up the network of the virtual machine")
+			},
+		},
+		{
+			name:    "should do nothing if the socket_vmnet daemon isn't running",
+			cleanup: true,
+			mockSvc: func(logger *mocks.Logger, _ *mocks.CommandCreator, _ *gomock.Controller, _ afero.Fs) {
+				logger.EXPECT().Debugln("No socket_vmnet daemon left to clean up")
+			},
+		},
+		{
+			name:    "should only warn if the socket_vmnet daemon can't be terminated",
+			cleanup: true,
+			mockSvc: func(logger *mocks.Logger, ecc *mocks.CommandCreator, ctrl *gomock.Controller, fs afero.Fs) {
+				require.NoError(t, afero.WriteFile(fs, sharedNetworkPIDFile, []byte("42"), 0o644))
+				pkillC := mocks.NewCommand(ctrl)
+				ecc.EXPECT().Create("sudo", pkillArgs...).Return(pkillC)
+				pkillC.EXPECT().CombinedOutput().Return([]byte("sudo: a password is required"), errors.New("exit status 1"))
+				logger.EXPECT().Warnf("Could not clean up the network of the virtual machine: %v", gomock.Any())
+			},
+		},
+		{
+			name:    "should not clean up if it isn't enabled",
+			cleanup: false,
+			mockSvc: func(_ *mocks.Logger, _ *mocks.CommandCreator, _ *gomock.Controller, fs afero.Fs) {
+				require.NoError(t, afero.WriteFile(fs, sharedNetworkPIDFile, []byte("42"), 0o644))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+			ecc := mocks.NewCommandCreator(ctrl)
+			fs := afero.NewMemMapFs()
+			fc := &config.Finch{}
+			fc.Network.CleanupOnForceStop = tc.cleanup
+
+			serialLogPath := filepath.Join(mockFinchPath.LimaInstancePath(), "serial.log")
+			require.NoError(t, afero.WriteFile(fs, serialLogPath, []byte("kernel log"), 0o600))
+			logger.EXPECT().Infof("Guest kernel log saved to %q", gomock.Any())
+			dm.EXPECT().DetachUserDataDisk().Return(nil)
+			stopC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("stop", "--force", limaInstanceName).Return(stopC)
+			stopC.EXPECT().CombinedOutput()
+			logger.EXPECT().StartProgress("Forcibly stopping Finch virtual machine...").Return(func() {})
+			logger.EXPECT().Info("Finch virtual machine stopped successfully")
+			tc.mockSvc(logger, ecc, ctrl, fs)
+
+			action := newStopVMAction(ncc, ecc, dm, logger, fs, mockFinchPath, fc, mockFinchRootPath, nil, nil, nil)
+			require.NoError(t, action.run(stopVMOptions{force: true}))
+		})
+	}
+}
+
+// closeRecorder is the stdin of caffeinate, it records being closed.
+type closeRecorder struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestStopVMAction_runPreventsSleep(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name    string
+		mockSvc func(logger *mocks.Logger, caffeinateC *mocks.Command, stdin *closeRecorder)
+	}{
+		{
+			name: "should hold a power assertion until the stop is over",
+			mockSvc: func(logger *mocks.Logger, caffeinateC *mocks.Command, stdin *closeRecorder) {
+				caffeinateC.EXPECT().StdinPipe().Return(stdin, nil)
+				caffeinateC.EXPECT().Start().Return(nil)
+				logger.EXPECT().Debugln("Preventing the host from sleeping until the stop is over")
+				caffeinateC.EXPECT().Wait().Return(nil)
+			},
+		},
+		{
+			name: "should still stop if caffeinate can't be started",
+			mockSvc: func(logger *mocks.Logger, caffeinateC *mocks.Command, stdin *closeRecorder) {
+				caffeinateC.EXPECT().StdinPipe().Return(stdin, nil)
+				caffeinateC.EXPECT().Start().Return(errors.New("executable file not found"))
+				logger.EXPECT().Warnf("Could not prevent the host from sleeping during the stop: %v", errors.New("executable file not found"))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+			ecc := mocks.NewCommandCreator(ctrl)
+
+			caffeinateC := mocks.NewCommand(ctrl)
+			ecc.EXPECT().Create("caffeinate", "-i", "-s", "cat").Return(caffeinateC)
+			stdin := &closeRecorder{}
+			tc.mockSvc(logger, caffeinateC, stdin)
+			getVMStatusC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+			getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+			expectGracefulStop(ncc, dm, logger, ctrl)
+
+			action := newStopVMAction(ncc, ecc, dm, logger, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath,
+				nil, nil, nil)
+			require.NoError(t, action.run(stopVMOptions{preventSleep: true}))
+		})
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/runfinch/finch/pkg/command"
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/disk"
+	"github.com/runfinch/finch/pkg/flog"
+	"github.com/runfinch/finch/pkg/path"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+)
+
+// detachedStopLog is the file in the instance directory the output of the stop started with --wait=false goes to,
+// it's where the outcome of the stop is found once it's over.
+const detachedStopLog = "stop.log"
+
+// finishStopCmd is the hidden subcommand of finch vm running the stop started with --wait=false to completion.
+const finishStopCmd = "finish-stop"
+
+// startDetachedStop unmounts and detaches the user data disk like a graceful stop does, then starts finch vm finish-stop
+// without waiting for it to finish, for --wait=false. The stop goes on after finch exits, in its own session or
+// process group so that it isn't terminated along with the terminal finch was run from, writing to detachedStopLog.
+func (sva *stopVMAction) startDetachedStop(instance string) error {
+	// A broken stop.commandTemplate fails the stop before anything is detached rather than in the background.
+	if _, err := sva.limaStopArgs(instance, false); err != nil {
+		return err
+	}
+	if err := sva.checkDeadline("detach"); err != nil {
+		return err
+	}
+	doneDetaching := sva.timePhase("detach")
+	sva.unmountGuestDataVolume(instance)
+	detached := sva.detachUserDataDisk(instance)
+	doneDetaching()
+
+	logPath := filepath.Join(sva.limaHomePath(), instance, detachedStopLog)
+	if err := sva.startFinishStop(instance, detached, logPath); err != nil {
+		return sva.reattachUserDataDiskIf(instance, detached,
+			withCategory(stopErrorLimactl, fmt.Errorf("failed to start the stop: %w", err)))
+	}
+	sva.logger.Infof("Stopping the instance %q in the background, see %q for how it went", instance, logPath)
+	return nil
+}
+
+func (sva *stopVMAction) startFinishStop(instance string, detached bool, logPath string) error {
+	finch, err := sva.executable()
+	if err != nil {
+		return err
+	}
+	f, err := sva.fs.OpenFile(logPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create the log of the stop: %w", err)
+	}
+	// The stop gets its own handle on the file, which outlives this one.
+	defer f.Close() //nolint:errcheck // nothing was written through this handle
+	cmd := sva.ecc.Create(finch, sva.finishStopArgs(instance, detached)...)
+	cmd.Detach()
+	cmd.SetStdout(f)
+	cmd.SetStderr(f)
+	return cmd.Start()
+}
+
+// finishStopArgs returns the arguments of finch to run finch vm finish-stop with, passing on what the stop
+// started with.
+func (sva *stopVMAction) finishStopArgs(instance string, detached bool) []string {
+	args := []string{virtualMachineRootCmd, finishStopCmd}
+	if sva.limaHome != "" {
+		args = append(args, "--lima-home", sva.limaHome)
+	}
+	if detached {
+		args = append(args, "--disk-detached")
+	}
+	keys := make([]string, 0, len(sva.annotations))
+	for k := range sva.annotations {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		args = append(args, "--annotate", k+"="+sva.annotations[k])
+	}
+	return append(args, instance)
+}
+
+func newFinishStopVMCommand(
+	limaCmdCreator command.NerdctlCmdCreator,
+	ecc command.Creator,
+	diskManager disk.UserDataDiskManager,
+	logger flog.Logger,
+	fs afero.Fs,
+	fp path.Finch,
+	fc *config.Finch,
+	finchRootPath string,
+	output io.Writer,
+) *cobra.Command {
+	finishStopCommand := &cobra.Command{
+		Use:    finishStopCmd + " <instance>",
+		Args:   cobra.ExactArgs(1),
+		Hidden: true,
+		Short:  "Run the stop started with finch vm stop --wait=false to completion",
+		RunE:   newStopVMAction(limaCmdCreator, ecc, diskManager, logger, fs, fp, fc, finchRootPath, nil, nil, output).finishStopAdapter,
+	}
+	finishStopCommand.Flags().String("lima-home", "", "Lima home directory of the instance")
+	finishStopCommand.Flags().Bool("disk-detached", false, "whether the user data disk was detached before the stop started")
+	finishStopCommand.Flags().StringArray("annotate", nil, "key=value annotation of the stop, recorded in the audit log")
+	return finishStopCommand
+}
+
+func (sva *stopVMAction) finishStopAdapter(cmd *cobra.Command, args []string) error {
+	limaHome, err := cmd.Flags().GetString("lima-home")
+	if err != nil {
+		return err
+	}
+	if limaHome != "" {
+		creator, err := targetLimaHome(sva.creator, sva.fs, sva.logger, limaHome)
+		if err != nil {
+			return err
+		}
+		sva.creator = creator
+		sva.limaHome = limaHome
+	}
+	detached, err := cmd.Flags().GetBool("disk-detached")
+	if err != nil {
+		return err
+	}
+	annotate, err := cmd.Flags().GetStringArray("annotate")
+	if err != nil {
+		return err
+	}
+	if sva.annotations, err = parseAnnotations(annotate); err != nil {
+		return err
+	}
+	return sva.finishDetachedStop(args[0], detached)
+}
+
+// finishDetachedStop stops the instance with limactl, whatever stop.method is, as only limactl reports how the stop
+// went, and records the outcome in the metadata of the instance and in the audit log, which the stop started with
+// --wait=false left to it. If the stop fails, the user data disk is attached back if it was detached.
+func (sva *stopVMAction) finishDetachedStop(instance string, detached bool) error {
+	stopArgs, err := sva.limaStopArgs(instance, false)
+	if err == nil {
+		err = sva.trackStop(instance, func() error {
+			logs, err := sva.creator.CreateWithoutStdio(stopArgs...).CombinedOutput()
+			if err != nil {
+				return withCategory(stopErrorLimactl, fmt.Errorf("limactl stop failed: %w, debug logs:\n%s", err, logs))
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		err = sva.reattachUserDataDiskIf(instance, detached, err)
+	}
+	sva.recordAudit("stop", instance, err)
+	if err != nil {
+		return err
+	}
+	if instance == limaInstanceName {
+		sva.recordLastRunConfig(instance)
+	}
+	sva.logger.Infof("The instance %q is stopped", instance)
+	return nil
+}
@@ -0,0 +1,209 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/runfinch/finch/pkg/audit"
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/lima"
+	"github.com/runfinch/finch/pkg/mocks"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestStopVMAction_runWithoutWaiting(t *testing.T) {
+	t.Parallel()
+
+	const finchPath = "/usr/local/bin/finch"
+	logPath := filepath.Join(mockFinchPath.LimaInstancePath(), "stop.log")
+
+	testCases := []struct {
+		name       string
+		wantErr    error
+		wantOutput string
+		mockSvc    func(*mocks.Logger, *mocks.Command)
+	}{
+		{
+			name:       "should start the stop in the background once the disk is detached",
+			wantOutput: `{"status":"was_running","result":"stopping"}` + "\n",
+			mockSvc: func(logger *mocks.Logger, finishC *mocks.Command) {
+				finishC.EXPECT().Start().Return(nil)
+				logger.EXPECT().Infof("Stopping the instance %q in the background, see %q for how it went", limaInstanceName, logPath)
+			},
+		},
+		{
+			name:    "should fail if the stop can't be started",
+			wantErr: withCategory(stopErrorLimactl, errors.New("failed to start the stop: executable file not found")),
+			wantOutput: `{"status":"was_running","result":"failed","error":"failed to start the stop: executable file not found",` +
+				`"errorCategory":"limactl"}` + "\n",
+			mockSvc: func(_ *mocks.Logger, finishC *mocks.Command) {
+				finishC.EXPECT().Start().Return(errors.New("executable file not found"))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+			ecc := mocks.NewCommandCreator(ctrl)
+			fs := afero.NewMemMapFs()
+
+			getVMStatusC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC).Times(2)
+			getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil).Times(2)
+			expectUnmountGuestDataVolume(ncc, ctrl)
+			finishC := mocks.NewCommand(ctrl)
+			finishArgs := []any{"vm", "finish-stop", "--annotate", "reason=patch", limaInstanceName}
+			if runtime.GOOS == "windows" {
+				// Lima detaches the disk on macOS, so it's only on Windows that the disk is left detached by the stop.
+				finishArgs = []any{"vm", "finish-stop", "--disk-detached", "--annotate", "reason=patch", limaInstanceName}
+			}
+			gomock.InOrder(
+				dm.EXPECT().DetachUserDataDisk().Return(nil),
+				ecc.EXPECT().Create(finchPath, finishArgs...).Return(finishC),
+				finishC.EXPECT().Detach(),
+				finishC.EXPECT().SetStdout(gomock.Any()),
+				finishC.EXPECT().SetStderr(gomock.Any()),
+			)
+			tc.mockSvc(logger, finishC)
+			if tc.wantErr != nil {
+				expectReattachUserDataDisk(ncc, dm, logger, ctrl)
+			}
+
+			output := &bytes.Buffer{}
+			action := newStopVMAction(ncc, ecc, dm, logger, fs, mockFinchPath, &config.Finch{}, mockFinchRootPath, nil, nil, output)
+			action.executable = func() (string, error) { return finchPath, nil }
+			err := action.run(stopVMOptions{noWait: true, json: true, annotations: map[string]string{"reason": "patch"}})
+			assert.Equal(t, tc.wantOutput, output.String())
+
+			// The stop that goes on in the background records how it went.
+			records, readErr := audit.Read(fs, mockFinchPath.AuditLogPath(mockFinchRootPath))
+			require.NoError(t, readErr)
+			if tc.wantErr != nil {
+				assert.EqualError(t, err, tc.wantErr.Error())
+				assert.Equal(t, stopErrorLimactl, classifyStopError(err))
+				require.Len(t, records, 1)
+				assert.Equal(t, audit.ResultFailure, records[0].Result)
+				return
+			}
+			require.NoError(t, err)
+			assert.Empty(t, records)
+			exists, err := afero.Exists(fs, logPath)
+			require.NoError(t, err)
+			assert.True(t, exists)
+		})
+	}
+}
+
+func TestStopVMAction_runRejectsNoWaitWithForce(t *testing.T) {
+	t.Parallel()
+
+	action := newStopVMAction(nil, nil, nil, nil, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath,
+		nil, nil, nil)
+	err := action.run(stopVMOptions{force: true, noWait: true})
+	assert.EqualError(t, err, "--wait=false cannot be used together with --force, --hibernate, --tag, --verify-disk-detached "+
+		"or --post-stop-command")
+}
+
+func TestStopVMAction_finishDetachedStop(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name             string
+		detached         bool
+		stopErr          error
+		wantErr          string
+		wantStopFailures int
+		wantResult       string
+		mockSvc          func(*mocks.NerdctlCmdCreator, *mocks.UserDataDiskManager, *mocks.Logger, *gomock.Controller)
+	}{
+		{
+			name:       "should record that the instance is stopped",
+			detached:   true,
+			wantResult: audit.ResultSuccess,
+			mockSvc: func(_ *mocks.NerdctlCmdCreator, _ *mocks.UserDataDiskManager, logger *mocks.Logger, _ *gomock.Controller) {
+				logger.EXPECT().Infof("The instance %q is stopped", limaInstanceName)
+			},
+		},
+		{
+			name:             "should record the failure and attach the disk back",
+			detached:         true,
+			stopErr:          errors.New("exit status 1"),
+			wantErr:          "limactl stop failed: exit status 1, debug logs:\nfailed",
+			wantStopFailures: 1,
+			wantResult:       audit.ResultFailure,
+			mockSvc:          expectAttachUserDataDiskBack,
+		},
+		{
+			name:             "should not attach back a disk that wasn't detached",
+			stopErr:          errors.New("exit status 1"),
+			wantErr:          "limactl stop failed: exit status 1, debug logs:\nfailed",
+			wantStopFailures: 1,
+			wantResult:       audit.ResultFailure,
+			mockSvc:          func(*mocks.NerdctlCmdCreator, *mocks.UserDataDiskManager, *mocks.Logger, *gomock.Controller) {},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+			fs := afero.NewMemMapFs()
+
+			stopC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(stopC)
+			if tc.stopErr != nil {
+				stopC.EXPECT().CombinedOutput().Return([]byte("failed"), tc.stopErr)
+			} else {
+				stopC.EXPECT().CombinedOutput()
+			}
+			tc.mockSvc(ncc, dm, logger, ctrl)
+
+			cmd := newFinishStopVMCommand(ncc, nil, dm, logger, fs, mockFinchPath, &config.Finch{}, mockFinchRootPath, nil)
+			args := []string{limaInstanceName, "--annotate", "reason=patch"}
+			if tc.detached {
+				args = append(args, "--disk-detached")
+			}
+			cmd.SetArgs(args)
+			err := cmd.Execute()
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				assert.Equal(t, stopErrorLimactl, classifyStopError(err))
+			} else {
+				require.NoError(t, err)
+			}
+
+			md, err := lima.LoadInstanceMetadata(fs, mockFinchPath.LimaInstancePath())
+			require.NoError(t, err)
+			assert.Empty(t, md.PendingOperation)
+			assert.Equal(t, tc.wantStopFailures, md.StopFailures)
+			records, err := audit.Read(fs, mockFinchPath.AuditLogPath(mockFinchRootPath))
+			require.NoError(t, err)
+			require.Len(t, records, 1)
+			assert.Equal(t, "stop", records[0].Command)
+			assert.Equal(t, tc.wantResult, records[0].Result)
+			assert.Equal(t, map[string]string{"reason": "patch"}, records[0].Annotations)
+		})
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"errors"
+	"slices"
+	"sync"
+)
+
+const (
+	// diskDetachSerial detaches the disks one after the other, in the detach order of the disk manager.
+	diskDetachSerial = "serial"
+	// diskDetachParallel detaches the independent disks concurrently.
+	diskDetachParallel = "parallel"
+
+	defaultDiskDetachWorkers = 4
+)
+
+// detachDisksInParallel detaches the disks that depend on each other one after the other, in their detach order,
+// while the independent disks are detached concurrently, at most sva.diskDetachWorkers at a time.
+// Failing to detach a disk doesn't prevent the others from being detached.
+func (sva *stopVMAction) detachDisksInParallel() error {
+	independent := sva.diskManager.IndependentDisks()
+	var dependent []string
+	for _, diskPath := range sva.diskManager.DetachOrder() {
+		if !slices.Contains(independent, diskPath) {
+			dependent = append(dependent, diskPath)
+		}
+	}
+	// Each batch of disks is detached by a single worker, in order.
+	batches := make([][]string, 0, len(independent)+1)
+	if len(dependent) > 0 {
+		batches = append(batches, dependent)
+	}
+	for _, diskPath := range independent {
+		batches = append(batches, []string{diskPath})
+	}
+
+	errs := make([]error, len(batches))
+	workers := make(chan struct{}, sva.diskDetachWorkers)
+	var wg sync.WaitGroup
+	for i, batch := range batches {
+		wg.Add(1)
+		workers <- struct{}{}
+		go func() {
+			defer func() {
+				<-workers
+				wg.Done()
+			}()
+			var batchErrs []error
+			for _, diskPath := range batch {
+				if err := sva.diskManager.DetachDisk(diskPath); err != nil {
+					batchErrs = append(batchErrs, err)
+				}
+			}
+			errs[i] = errors.Join(batchErrs...)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
@@ -0,0 +1,155 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/mocks"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestStopVMAction_runWithParallelDiskDetach(t *testing.T) {
+	t.Parallel()
+
+	const (
+		dataDisk    = "data.vhdx"
+		overlayDisk = "overlay.vhdx"
+		cacheDisk   = "cache.vhdx"
+		logsDisk    = "logs.vhdx"
+	)
+
+	testCases := []struct {
+		name        string
+		independent []string
+		detachErr   error
+	}{
+		{
+			name:        "should detach the dependent disks in order and the independent ones concurrently",
+			independent: []string{cacheDisk, logsDisk},
+		},
+		{
+			name:        "should stop the instance if a disk fails to be detached",
+			independent: []string{cacheDisk, logsDisk},
+			detachErr:   errors.New("failed to detach disk: exit status 1"),
+		},
+		{
+			name: "should detach the disks in order if none of them is independent",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+
+			getVMStatusC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+			getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+			expectUnmountGuestDataVolume(ncc, ctrl)
+
+			dm.EXPECT().IndependentDisks().Return(tc.independent)
+			dm.EXPECT().DetachOrder().Return(append([]string{overlayDisk, dataDisk}, tc.independent...))
+			gomock.InOrder(
+				dm.EXPECT().DetachDisk(overlayDisk).Return(nil),
+				dm.EXPECT().DetachDisk(dataDisk).Return(tc.detachErr),
+			)
+			for _, diskPath := range tc.independent {
+				dm.EXPECT().DetachDisk(diskPath).Return(nil)
+			}
+
+			stopC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(stopC)
+			stopC.EXPECT().CombinedOutput()
+			logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+			logger.EXPECT().Info("Finch virtual machine stopped successfully")
+
+			action := newStopVMAction(ncc, nil, dm, logger, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath,
+				nil, nil, nil)
+			err := action.run(stopVMOptions{diskDetachOrder: diskDetachParallel, diskDetachWorkers: defaultDiskDetachWorkers})
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestStopVMAction_detachDisksInParallelBoundsTheWorkers(t *testing.T) {
+	t.Parallel()
+
+	const workers = 2
+
+	ctrl := gomock.NewController(t)
+	dm := mocks.NewUserDataDiskManager(ctrl)
+
+	var independent []string
+	for i := range 6 {
+		independent = append(independent, fmt.Sprintf("disk%d.vhdx", i))
+	}
+	dm.EXPECT().IndependentDisks().Return(independent)
+	dm.EXPECT().DetachOrder().Return(independent)
+
+	var inFlight, maxInFlight atomic.Int32
+	dm.EXPECT().DetachDisk(gomock.Any()).DoAndReturn(func(string) error {
+		n := inFlight.Add(1)
+		for {
+			prev := maxInFlight.Load()
+			if n <= prev || maxInFlight.CompareAndSwap(prev, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		inFlight.Add(-1)
+		return nil
+	}).Times(len(independent))
+
+	action := newStopVMAction(nil, nil, dm, nil, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath,
+		nil, nil, nil)
+	action.diskDetachWorkers = workers
+	assert.NoError(t, action.detachDisksInParallel())
+	assert.LessOrEqual(t, maxInFlight.Load(), int32(workers))
+}
+
+func TestStopVMAction_runRejectsInvalidDiskDetachOrder(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name    string
+		opts    stopVMOptions
+		wantErr error
+	}{
+		{
+			name:    "should reject an unsupported order",
+			opts:    stopVMOptions{diskDetachOrder: "random"},
+			wantErr: fmt.Errorf("unsupported disk detach order %q, it must be either %q or %q", "random", "serial", "parallel"),
+		},
+		{
+			name:    "should reject no workers",
+			opts:    stopVMOptions{diskDetachOrder: diskDetachParallel},
+			wantErr: errors.New("--disk-detach-workers must be at least 1"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			action := newStopVMAction(nil, nil, nil, nil, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath,
+				nil, nil, nil)
+			assert.Equal(t, tc.wantErr, action.run(tc.opts))
+		})
+	}
+}
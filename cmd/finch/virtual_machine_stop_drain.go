@@ -0,0 +1,149 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/runfinch/finch/pkg/command"
+)
+
+// drainKillGracePeriod is how long nerdctl gets to kill the containers that didn't exit within the drain timeout.
+const drainKillGracePeriod = 10 * time.Second
+
+// errTimedOut is returned by combinedOutputWithin when the command didn't finish in time.
+var errTimedOut = errors.New("timed out")
+
+// drainContainers stops the containers running in the guest, giving them up to timeout to exit on their own
+// before they are killed, so that they get the chance to shut down cleanly before the VM goes away.
+// They are stopped in the order of their shutdown priority, see shutdownGroups, all within timeout. Each group gets
+// an equal share of the time left when it's stopped, so that the groups stopped first can't use it all up and leave
+// the last ones to be killed right away, and the time a group doesn't use goes to the groups stopped after it.
+func (sva *stopVMAction) drainContainers(instance string, timeout time.Duration) error {
+	out, err := sva.creator.CreateWithoutStdio(sva.guestNerdctlArgs(instance, "ps", "-q")...).Output()
+	if err != nil {
+		sva.logger.Warnf("Could not list the running containers to drain: %v", err)
+		return nil
+	}
+	containers := strings.Fields(string(out))
+	if len(containers) == 0 {
+		return nil
+	}
+
+	sva.logger.Infof("Giving %d running containers up to %s to exit...", len(containers), timeout)
+	deadline := time.Now().Add(timeout)
+	groups := sva.shutdownGroups(instance, containers)
+	for i, group := range groups {
+		share := max(time.Until(deadline), 0) / time.Duration(len(groups)-i)
+		err := sva.stopContainers(instance, group, share)
+		if errors.Is(err, errTimedOut) {
+			return withCategory(stopErrorTimeout, fmt.Errorf("the drain phase timed out after %s, containers may still be running", timeout))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shutdownPriorityLabel is the label of a container that tells when it's stopped while draining:
+// the higher its value, the later the container is stopped.
+const shutdownPriorityLabel = "finch.shutdown-priority"
+
+// defaultShutdownPriority is the shutdown priority of the containers without a valid shutdownPriorityLabel.
+const defaultShutdownPriority = 0
+
+// shutdownGroups groups the containers by shutdown priority, in the order they must be stopped, lowest priority first.
+// The containers of the same priority are stopped together, in the order nerdctl listed them.
+// If the priorities can't be read, all the containers are stopped together.
+func (sva *stopVMAction) shutdownGroups(instance string, containers []string) [][]string {
+	args := append(sva.guestNerdctlArgs(instance, "inspect", "--format",
+		fmt.Sprintf(`{{.ID}} {{index .Config.Labels %q}}`, shutdownPriorityLabel)), containers...)
+	out, err := sva.creator.CreateWithoutStdio(args...).Output()
+	if err != nil {
+		sva.logger.Warnf("Could not read the shutdown priorities of the containers, stopping them all at once: %v", err)
+		return [][]string{containers}
+	}
+
+	byPriority := map[int][]string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		priority := defaultShutdownPriority
+		if len(fields) > 1 {
+			if p, err := strconv.Atoi(fields[1]); err == nil {
+				priority = p
+			} else {
+				sva.logger.Warnf("Ignoring the invalid %s label %q of the container %s", shutdownPriorityLabel, fields[1], fields[0])
+			}
+		}
+		byPriority[priority] = append(byPriority[priority], fields[0])
+	}
+	groups := make([][]string, 0, len(byPriority))
+	for _, priority := range slices.Sorted(maps.Keys(byPriority)) {
+		groups = append(groups, byPriority[priority])
+	}
+	return groups
+}
+
+// stopContainers stops the containers, giving them up to timeout to exit before they are killed.
+func (sva *stopVMAction) stopContainers(instance string, containers []string, timeout time.Duration) error {
+	args := sva.guestNerdctlArgs(instance, "stop", "--time", strconv.Itoa(int(math.Ceil(timeout.Seconds()))))
+	logs, err := combinedOutputWithin(sva.creator.CreateWithoutStdio(append(args, containers...)...), timeout+drainKillGracePeriod)
+	if errors.Is(err, errTimedOut) {
+		return err
+	}
+	if err != nil {
+		sva.logger.Errorf("Failed to drain the running containers, debug logs:\n%s", logs)
+		return withCategory(stopErrorGuest, fmt.Errorf("failed to drain the running containers: %w", err))
+	}
+	return nil
+}
+
+// guestNerdctlArgs returns the arguments to run nerdctl with args in the guest, in the namespace of the containers
+// the stop affects, see --namespace.
+func (sva *stopVMAction) guestNerdctlArgs(instance string, args ...string) []string {
+	nerdctlArgs := []string{"shell", instance, "sudo", "-E", "nerdctl"}
+	if sva.drainNamespace != "" {
+		nerdctlArgs = append(nerdctlArgs, "--namespace", sva.drainNamespace)
+	}
+	return append(nerdctlArgs, args...)
+}
+
+// combinedOutputWithin runs the command like CombinedOutput, but kills it and gives up on it with errTimedOut
+// if it doesn't finish within timeout. A timeout of 0 means no limit.
+func combinedOutputWithin(cmd command.Command, timeout time.Duration) ([]byte, error) {
+	if timeout <= 0 {
+		return cmd.CombinedOutput()
+	}
+	type result struct {
+		out []byte
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		out, err := cmd.CombinedOutput()
+		done <- result{out: out, err: err}
+	}()
+	select {
+	case r := <-done:
+		return r.out, r.err
+	case <-time.After(timeout):
+		// Don't leave it running in the background, e.g. a limactl stop still going on while the instance is forcibly stopped.
+		cmd.Kill()
+		<-done
+		return nil, errTimedOut
+	}
+}
@@ -0,0 +1,292 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/mocks"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestStopVMAction_runWithDrainTimeout(t *testing.T) {
+	t.Parallel()
+
+	psArgs := []any{"shell", limaInstanceName, "sudo", "-E", "nerdctl", "ps", "-q"}
+	inspectArgs := []any{
+		"shell", limaInstanceName, "sudo", "-E", "nerdctl", "inspect", "--format",
+		`{{.ID}} {{index .Config.Labels "finch.shutdown-priority"}}`, "c1", "c2",
+	}
+	drainArgs := []any{"shell", limaInstanceName, "sudo", "-E", "nerdctl", "stop", "--time", "60", "c1", "c2"}
+
+	testCases := []struct {
+		name     string
+		mockSvc  func(*mocks.NerdctlCmdCreator, *mocks.Logger, *gomock.Controller)
+		wantStop bool
+		wantErr  error
+	}{
+		{
+			name: "should stop the running containers before the VM",
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, logger *mocks.Logger, ctrl *gomock.Controller) {
+				psC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(psArgs...).Return(psC)
+				psC.EXPECT().Output().Return([]byte("c1\nc2\n"), nil)
+				logger.EXPECT().Infof("Giving %d running containers up to %s to exit...", 2, time.Minute)
+				inspectC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(inspectArgs...).Return(inspectC)
+				inspectC.EXPECT().Output().Return([]byte("c1 \nc2 \n"), nil)
+				drainC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(drainArgs...).Return(drainC)
+				drainC.EXPECT().CombinedOutput().Return([]byte("c1\nc2\n"), nil)
+			},
+			wantStop: true,
+		},
+		{
+			// The first group gets half of the drain timeout, and the second one what the first one left of it.
+			name: "should stop the containers with a higher shutdown priority later",
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, logger *mocks.Logger, ctrl *gomock.Controller) {
+				psC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(psArgs...).Return(psC)
+				psC.EXPECT().Output().Return([]byte("c1\nc2\n"), nil)
+				logger.EXPECT().Infof("Giving %d running containers up to %s to exit...", 2, time.Minute)
+				inspectC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(inspectArgs...).Return(inspectC)
+				inspectC.EXPECT().Output().Return([]byte("c1 10\nc2 \n"), nil)
+				drainC2 := mocks.NewCommand(ctrl)
+				drainC1 := mocks.NewCommand(ctrl)
+				gomock.InOrder(
+					ncc.EXPECT().CreateWithoutStdio(
+						"shell", limaInstanceName, "sudo", "-E", "nerdctl", "stop", "--time", "30", "c2",
+					).Return(drainC2),
+					drainC2.EXPECT().CombinedOutput().Return([]byte("c2\n"), nil),
+					ncc.EXPECT().CreateWithoutStdio(
+						"shell", limaInstanceName, "sudo", "-E", "nerdctl", "stop", "--time", "60", "c1",
+					).Return(drainC1),
+					drainC1.EXPECT().CombinedOutput().Return([]byte("c1\n"), nil),
+				)
+			},
+			wantStop: true,
+		},
+		{
+			name: "should stop the containers with an invalid shutdown priority with the default priority",
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, logger *mocks.Logger, ctrl *gomock.Controller) {
+				psC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(psArgs...).Return(psC)
+				psC.EXPECT().Output().Return([]byte("c1\nc2\n"), nil)
+				logger.EXPECT().Infof("Giving %d running containers up to %s to exit...", 2, time.Minute)
+				inspectC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(inspectArgs...).Return(inspectC)
+				inspectC.EXPECT().Output().Return([]byte("c1 high\nc2 0\n"), nil)
+				logger.EXPECT().Warnf("Ignoring the invalid %s label %q of the container %s", "finch.shutdown-priority", "high", "c1")
+				drainC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(drainArgs...).Return(drainC)
+				drainC.EXPECT().CombinedOutput().Return([]byte("c1\nc2\n"), nil)
+			},
+			wantStop: true,
+		},
+		{
+			name: "should stop all the containers at once if their shutdown priorities can't be read",
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, logger *mocks.Logger, ctrl *gomock.Controller) {
+				psC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(psArgs...).Return(psC)
+				psC.EXPECT().Output().Return([]byte("c1\nc2\n"), nil)
+				logger.EXPECT().Infof("Giving %d running containers up to %s to exit...", 2, time.Minute)
+				inspectC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(inspectArgs...).Return(inspectC)
+				inspectC.EXPECT().Output().Return(nil, errors.New("exit status 1"))
+				logger.EXPECT().Warnf("Could not read the shutdown priorities of the containers, stopping them all at once: %v",
+					errors.New("exit status 1"))
+				drainC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(drainArgs...).Return(drainC)
+				drainC.EXPECT().CombinedOutput().Return([]byte("c1\nc2\n"), nil)
+			},
+			wantStop: true,
+		},
+		{
+			name: "should stop the VM right away if no container is running",
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, _ *mocks.Logger, ctrl *gomock.Controller) {
+				psC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(psArgs...).Return(psC)
+				psC.EXPECT().Output().Return([]byte("\n"), nil)
+			},
+			wantStop: true,
+		},
+		{
+			name: "should still stop the VM if the running containers can't be listed",
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, logger *mocks.Logger, ctrl *gomock.Controller) {
+				psC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(psArgs...).Return(psC)
+				psC.EXPECT().Output().Return(nil, errors.New("exit status 1"))
+				logger.EXPECT().Warnf("Could not list the running containers to drain: %v", errors.New("exit status 1"))
+			},
+			wantStop: true,
+		},
+		{
+			name: "should not stop the VM if the containers fail to be drained",
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, logger *mocks.Logger, ctrl *gomock.Controller) {
+				psC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(psArgs...).Return(psC)
+				psC.EXPECT().Output().Return([]byte("c1\nc2\n"), nil)
+				logger.EXPECT().Infof("Giving %d running containers up to %s to exit...", 2, time.Minute)
+				inspectC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(inspectArgs...).Return(inspectC)
+				inspectC.EXPECT().Output().Return([]byte("c1 \nc2 \n"), nil)
+				drainC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(drainArgs...).Return(drainC)
+				drainC.EXPECT().CombinedOutput().Return([]byte("no such container"), errors.New("exit status 1"))
+				logger.EXPECT().Errorf("Failed to drain the running containers, debug logs:\n%s", []byte("no such container"))
+			},
+			wantErr: withCategory(stopErrorGuest, fmt.Errorf("failed to drain the running containers: %w", errors.New("exit status 1"))),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+
+			getVMStatusC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+			getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+			tc.mockSvc(ncc, logger, ctrl)
+			if tc.wantStop {
+				expectUnmountGuestDataVolume(ncc, ctrl)
+				dm.EXPECT().DetachUserDataDisk().Return(nil)
+				stopC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(stopC)
+				stopC.EXPECT().CombinedOutput()
+				logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+				logger.EXPECT().Info("Finch virtual machine stopped successfully")
+			}
+
+			action := newStopVMAction(ncc, nil, dm, logger, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath,
+				nil, nil, nil)
+			err := action.run(stopVMOptions{drainTimeout: time.Minute})
+			if tc.wantErr != nil {
+				assert.Equal(t, tc.wantErr, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestStopVMAction_runWithTimeout(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	dm := mocks.NewUserDataDiskManager(ctrl)
+	logger := mocks.NewLogger(ctrl)
+	ncc := mocks.NewNerdctlCmdCreator(ctrl)
+
+	getVMStatusC := mocks.NewCommand(ctrl)
+	ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+	getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+	expectUnmountGuestDataVolume(ncc, ctrl)
+	dm.EXPECT().DetachUserDataDisk().Return(nil)
+	stopC := mocks.NewCommand(ctrl)
+	ncc.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(stopC)
+	killed := make(chan struct{})
+	stopC.EXPECT().CombinedOutput().DoAndReturn(func() ([]byte, error) {
+		<-killed
+		return nil, errors.New("signal: killed")
+	})
+	stopC.EXPECT().Kill().Do(func() { close(killed) })
+	logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+	expectReattachUserDataDisk(ncc, dm, logger, ctrl)
+
+	action := newStopVMAction(ncc, nil, dm, logger, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath,
+		nil, nil, nil)
+	err := action.run(stopVMOptions{timeout: 10 * time.Millisecond})
+	assert.EqualError(t, err, "the stop phase timed out after 10ms, use --force to stop the instance forcibly")
+}
+
+func TestStopVMAction_runRejectsDrainTimeoutWithForce(t *testing.T) {
+	t.Parallel()
+
+	action := newStopVMAction(nil, nil, nil, nil, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath,
+		nil, nil, nil)
+	err := action.run(stopVMOptions{force: true, drainTimeout: time.Minute})
+	assert.EqualError(t, err, "--force and --drain-timeout cannot be used together")
+}
+
+func TestStopVMAction_runAdapterWithNamespace(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name           string
+		flag           string
+		drainNamespace string
+		wantPSArgs     []any
+	}{
+		{
+			name:       "should drain the containers of the namespace of Finch by default",
+			wantPSArgs: []any{"shell", limaInstanceName, "sudo", "-E", "nerdctl", "ps", "-q"},
+		},
+		{
+			name:           "should drain the containers of the configured namespace",
+			drainNamespace: "tenant-a",
+			wantPSArgs:     []any{"shell", limaInstanceName, "sudo", "-E", "nerdctl", "--namespace", "tenant-a", "ps", "-q"},
+		},
+		{
+			name:           "should drain the containers of the namespace passed with --namespace over the configured one",
+			flag:           "tenant-b",
+			drainNamespace: "tenant-a",
+			wantPSArgs:     []any{"shell", limaInstanceName, "sudo", "-E", "nerdctl", "--namespace", "tenant-b", "ps", "-q"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+			fc := &config.Finch{}
+			fc.Stop.DrainNamespace = tc.drainNamespace
+
+			getVMStatusC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+			getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+			psC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio(tc.wantPSArgs...).Return(psC)
+			psC.EXPECT().Output().Return([]byte(""), nil)
+			expectUnmountGuestDataVolume(ncc, ctrl)
+			dm.EXPECT().DetachUserDataDisk().Return(nil)
+			stopC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(stopC)
+			stopC.EXPECT().CombinedOutput()
+			logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+			logger.EXPECT().Info("Finch virtual machine stopped successfully")
+
+			action := newStopVMAction(ncc, nil, dm, logger, afero.NewMemMapFs(), mockFinchPath, fc, mockFinchRootPath, nil, nil, nil)
+			action.lookupEnv = func(string) (string, bool) { return "", false }
+			cmd := newStopVMCommand(nil, nil, nil, nil, nil, mockFinchPath, fc, mockFinchRootPath, nil, nil, nil)
+			require.NoError(t, cmd.Flags().Set("drain-timeout", "1m"))
+			require.NoError(t, cmd.Flags().Set("pull-timeout", "0"))
+			require.NoError(t, cmd.Flags().Set("flush-writes", "false"))
+			require.NoError(t, cmd.Flags().Set("allow-sleep", "true"))
+			if tc.flag != "" {
+				require.NoError(t, cmd.Flags().Set("namespace", tc.flag))
+			}
+			assert.NoError(t, action.runAdapter(cmd, nil))
+		})
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// drainURLLabel is the label of a container that holds the URL to POST to for it to prepare for the stop,
+	// with --drain-webhook. The URL must be reachable from the host, e.g. through a published port.
+	drainURLLabel = "finch.drain-url"
+	// drainWebhookTimeout bounds how long the stop waits for all the containers to answer their drain webhooks.
+	drainWebhookTimeout = 30 * time.Second
+)
+
+// notifyDrainWebhooks POSTs to the drain URL of each running container that declares one, so that the apps get
+// the chance to flush their state before they are stopped. It's best-effort: the containers that don't answer
+// with a success within drainWebhookTimeout are logged, and the stop goes on.
+func (sva *stopVMAction) notifyDrainWebhooks(instance string) {
+	urls, err := sva.drainURLs(instance)
+	if err != nil {
+		sva.logger.Warnf("Could not read the drain URLs of the running containers: %v", err)
+		return
+	}
+	if len(urls) == 0 {
+		return
+	}
+
+	sva.logger.Infof("Notifying %d containers to prepare for the stop...", len(urls))
+	ctx, cancel := context.WithTimeout(context.Background(), drainWebhookTimeout)
+	defer cancel()
+	for _, u := range urls {
+		if err := postDrainWebhook(ctx, u.url); err != nil {
+			sva.logger.Warnf("The container %s didn't acknowledge its drain webhook %q: %v", u.container, u.url, err)
+		}
+	}
+}
+
+// containerDrainURL is the drain URL of a container, see drainURLLabel.
+type containerDrainURL struct {
+	container string
+	url       string
+}
+
+// drainURLs returns the drain URLs of the running containers, the containers without one are left out.
+func (sva *stopVMAction) drainURLs(instance string) ([]containerDrainURL, error) {
+	out, err := sva.creator.CreateWithoutStdio(sva.guestNerdctlArgs(instance, "ps", "-q")...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the containers: %w", err)
+	}
+	containers := strings.Fields(string(out))
+	if len(containers) == 0 {
+		return nil, nil
+	}
+
+	args := append(sva.guestNerdctlArgs(instance, "inspect", "--format",
+		fmt.Sprintf(`{{.ID}} {{index .Config.Labels %q}}`, drainURLLabel)), containers...)
+	out, err = sva.creator.CreateWithoutStdio(args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect the containers: %w", err)
+	}
+	var urls []containerDrainURL
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		urls = append(urls, containerDrainURL{container: fields[0], url: fields[1]})
+	}
+	return urls, nil
+}
+
+func postDrainWebhook(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, http.NoBody)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() //nolint:errcheck // nothing is read from the body
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+	return nil
+}
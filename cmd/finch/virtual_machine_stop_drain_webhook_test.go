@@ -0,0 +1,127 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/mocks"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestStopVMAction_runWithDrainWebhook(t *testing.T) {
+	t.Parallel()
+
+	psArgs := []any{"shell", limaInstanceName, "sudo", "-E", "nerdctl", "ps", "-q"}
+	inspectArgs := []any{
+		"shell", limaInstanceName, "sudo", "-E", "nerdctl", "inspect", "--format",
+		`{{.ID}} {{index .Config.Labels "finch.drain-url"}}`, "c1", "c2",
+	}
+
+	testCases := []struct {
+		name        string
+		status      int
+		mockSvc     func(*mocks.NerdctlCmdCreator, *mocks.Logger, *gomock.Controller, string)
+		wantNotices int
+	}{
+		{
+			name:   "should POST to the drain URL of the containers that have one",
+			status: http.StatusOK,
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, logger *mocks.Logger, ctrl *gomock.Controller, url string) {
+				psC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(psArgs...).Return(psC)
+				psC.EXPECT().Output().Return([]byte("c1\nc2\n"), nil)
+				inspectC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(inspectArgs...).Return(inspectC)
+				inspectC.EXPECT().Output().Return([]byte(fmt.Sprintf("c1 %s\nc2 \n", url)), nil)
+				logger.EXPECT().Infof("Notifying %d containers to prepare for the stop...", 1)
+			},
+			wantNotices: 1,
+		},
+		{
+			name:   "should warn and go on with the stop when a container doesn't acknowledge its drain webhook",
+			status: http.StatusServiceUnavailable,
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, logger *mocks.Logger, ctrl *gomock.Controller, url string) {
+				psC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(psArgs...).Return(psC)
+				psC.EXPECT().Output().Return([]byte("c1\nc2\n"), nil)
+				inspectC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(inspectArgs...).Return(inspectC)
+				inspectC.EXPECT().Output().Return([]byte(fmt.Sprintf("c1 %s\nc2 \n", url)), nil)
+				logger.EXPECT().Infof("Notifying %d containers to prepare for the stop...", 1)
+				logger.EXPECT().Warnf("The container %s didn't acknowledge its drain webhook %q: %v", "c1", url,
+					fmt.Errorf("unexpected response status: %s", "503 Service Unavailable"))
+			},
+			wantNotices: 1,
+		},
+		{
+			name:   "should do nothing without running containers",
+			status: http.StatusOK,
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, _ *mocks.Logger, ctrl *gomock.Controller, _ string) {
+				psC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(psArgs...).Return(psC)
+				psC.EXPECT().Output().Return([]byte(""), nil)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var mu sync.Mutex
+			notices := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, http.MethodPost, r.Method)
+				mu.Lock()
+				notices++
+				mu.Unlock()
+				w.WriteHeader(tc.status)
+			}))
+			defer srv.Close()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+
+			getVMStatusC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+			getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+			tc.mockSvc(ncc, logger, ctrl, srv.URL)
+			expectUnmountGuestDataVolume(ncc, ctrl)
+			dm.EXPECT().DetachUserDataDisk().Return(nil)
+			stopC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(stopC)
+			stopC.EXPECT().CombinedOutput()
+			logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+			logger.EXPECT().Info("Finch virtual machine stopped successfully")
+
+			action := newStopVMAction(ncc, nil, dm, logger, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath,
+				nil, nil, nil)
+			require.NoError(t, action.run(stopVMOptions{drainWebhook: true}))
+			assert.Equal(t, tc.wantNotices, notices)
+		})
+	}
+}
+
+func TestStopVMAction_runRejectsDrainWebhookWithForce(t *testing.T) {
+	t.Parallel()
+
+	action := newStopVMAction(nil, nil, nil, nil, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath,
+		nil, nil, nil)
+	err := action.run(stopVMOptions{force: true, drainWebhook: true})
+	assert.EqualError(t, err, "--force and --drain-webhook cannot be used together")
+}
@@ -0,0 +1,39 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+// stopEnvPrefix prefixes the environment variables setting the flags of finch vm stop, e.g. FINCH_STOP_FORCE for --force.
+const stopEnvPrefix = "FINCH_STOP_"
+
+// applyEnvFlags sets the flags that weren't passed on the command line from the environment variables named after them,
+// e.g. PREFIX_PULL_TIMEOUT for --pull-timeout. A flag set this way counts as passed, so it takes precedence over
+// the config file, which only provides the values of the flags that weren't passed. The precedence is therefore:
+// command line, then environment, then config file, then the default of the flag.
+func applyEnvFlags(flags *pflag.FlagSet, prefix string, lookupEnv func(string) (string, bool)) error {
+	var errs []error
+	flags.VisitAll(func(f *pflag.Flag) {
+		if f.Changed || f.Name == "help" {
+			return
+		}
+		key := prefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		value, ok := lookupEnv(key)
+		if !ok {
+			return
+		}
+		if err := flags.Set(f.Name, value); err != nil {
+			errs = append(errs, fmt.Errorf("invalid value %q of %s: %w", value, key, err))
+		}
+	})
+	return errors.Join(errs...)
+}
@@ -0,0 +1,134 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/mocks"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestApplyEnvFlags(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name        string
+		args        []string
+		env         map[string]string
+		wantForce   bool
+		wantChanged bool
+		wantTimeout time.Duration
+		wantErr     string
+	}{
+		{
+			name:        "should leave the flags to their default without a flag or an environment variable",
+			wantTimeout: 0,
+		},
+		{
+			name:        "should set the flags from the environment",
+			env:         map[string]string{"FINCH_STOP_FORCE": "1", "FINCH_STOP_TIMEOUT": "30s"},
+			wantForce:   true,
+			wantChanged: true,
+			wantTimeout: 30 * time.Second,
+		},
+		{
+			name:        "should prefer the flags passed on the command line to the environment",
+			args:        []string{"--force=false", "--timeout", "10s"},
+			env:         map[string]string{"FINCH_STOP_FORCE": "1", "FINCH_STOP_TIMEOUT": "30s"},
+			wantChanged: true,
+			wantTimeout: 10 * time.Second,
+		},
+		{
+			name:    "should fail on an invalid value in the environment",
+			env:     map[string]string{"FINCH_STOP_TIMEOUT": "soon"},
+			wantErr: `invalid value "soon" of FINCH_STOP_TIMEOUT: time: invalid duration "soon"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			cmd := newStopVMCommand(nil, nil, nil, nil, nil, mockFinchPath, &config.Finch{}, mockFinchRootPath, nil, nil, nil)
+			require.NoError(t, cmd.ParseFlags(tc.args))
+			lookupEnv := func(key string) (string, bool) {
+				value, ok := tc.env[key]
+				return value, ok
+			}
+
+			err := applyEnvFlags(cmd.Flags(), stopEnvPrefix, lookupEnv)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			force, err := cmd.Flags().GetBool("force")
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantForce, force)
+			// A flag set from the environment must take precedence over the config file, like a passed flag.
+			assert.Equal(t, tc.wantChanged, cmd.Flags().Changed("force"))
+			timeout, err := cmd.Flags().GetDuration("timeout")
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantTimeout, timeout)
+		})
+	}
+}
+
+func TestStopVMAction_runAdapterEnvOverridesConfig(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	logger := mocks.NewLogger(ctrl)
+	// The config asks for a forced stop, the environment for a graceful one, which fails without a running instance.
+	fc := &config.Finch{}
+	fc.Stop.DefaultForce = true
+	ncc := mocks.NewNerdctlCmdCreator(ctrl)
+	getVMStatusC := mocks.NewCommand(ctrl)
+	ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+	getVMStatusC.EXPECT().Output().Return([]byte("Stopped"), nil)
+
+	action := newStopVMAction(ncc, nil, nil, logger, afero.NewMemMapFs(), mockFinchPath, fc, mockFinchRootPath, nil, nil, nil)
+	action.lookupEnv = func(key string) (string, bool) {
+		if key == "FINCH_STOP_FORCE" {
+			return "0", true
+		}
+		return "", false
+	}
+	cmd := newStopVMCommand(nil, nil, nil, nil, nil, mockFinchPath, fc, mockFinchRootPath, nil, nil, nil)
+	require.NoError(t, cmd.Flags().Set("allow-sleep", "true"))
+	err := action.runAdapter(cmd, nil)
+	assert.EqualError(t, err, `the instance "finch" is already stopped`)
+}
+
+func TestStopVMAction_runAdapterDefaultForceWithGracefulOption(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	logger := mocks.NewLogger(ctrl)
+	// The config asks for a forced stop, which --hibernate can't be used with, so the stop is graceful instead of failing
+	// on the conflict, and fails without a running instance.
+	fc := &config.Finch{}
+	fc.Stop.DefaultForce = true
+	ncc := mocks.NewNerdctlCmdCreator(ctrl)
+	getVMStatusC := mocks.NewCommand(ctrl)
+	ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+	getVMStatusC.EXPECT().Output().Return([]byte("Stopped"), nil)
+
+	action := newStopVMAction(ncc, nil, nil, logger, afero.NewMemMapFs(), mockFinchPath, fc, mockFinchRootPath, nil, nil, nil)
+	action.lookupEnv = func(string) (string, bool) { return "", false }
+	cmd := newStopVMCommand(nil, nil, nil, nil, nil, mockFinchPath, fc, mockFinchRootPath, nil, nil, nil)
+	require.NoError(t, cmd.Flags().Set("allow-sleep", "true"))
+	require.NoError(t, cmd.Flags().Set("hibernate", "true"))
+	err := action.runAdapter(cmd, nil)
+	assert.EqualError(t, err, `the instance "finch" is already stopped`)
+}
@@ -0,0 +1,130 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/runfinch/finch/pkg/lima"
+)
+
+// stopErrorCategory buckets the failures of stops by what caused them, it's reported with --json, --summary
+// and in the metrics for dashboards to aggregate them.
+type stopErrorCategory string
+
+const (
+	// stopErrorTimeout is a phase of the stop, or the whole of it, that didn't finish in time.
+	stopErrorTimeout stopErrorCategory = "timeout"
+	// stopErrorDisk is the user data disk failing to be detached.
+	stopErrorDisk stopErrorCategory = "disk"
+	// stopErrorLimactl is limactl failing to stop the instance, or missing.
+	stopErrorLimactl stopErrorCategory = "limactl"
+	// stopErrorGuest is a command run in the guest before the instance is stopped failing.
+	stopErrorGuest stopErrorCategory = "guest"
+	// stopErrorUnknown is any other failure.
+	stopErrorUnknown stopErrorCategory = "unknown"
+)
+
+// stopError is a failure of a stop, tagged with its category where it's produced.
+type stopError struct {
+	Category stopErrorCategory
+	err      error
+}
+
+func (e *stopError) Error() string {
+	return e.err.Error()
+}
+
+func (e *stopError) Unwrap() error {
+	return e.err
+}
+
+// withCategory tags err with category, it returns nil if err is nil.
+func withCategory(category stopErrorCategory, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &stopError{Category: category, err: err}
+}
+
+// classifyStopError returns the category of the failure of a stop: the one it was tagged with, or for the errors
+// that come from outside of the stop, the one their cause falls into.
+func classifyStopError(err error) stopErrorCategory {
+	var se *stopError
+	switch {
+	case errors.As(err, &se):
+		return se.Category
+	case errors.Is(err, errTimedOut), errors.Is(err, context.DeadlineExceeded):
+		return stopErrorTimeout
+	case errors.Is(err, lima.ErrLimactlNotFound):
+		return stopErrorLimactl
+	default:
+		return stopErrorUnknown
+	}
+}
+
+// statusError is a stop refused because of the status of the instance, e.g. it being already stopped.
+type statusError struct {
+	Status lima.VMStatus
+	err    error
+}
+
+func (e *statusError) Error() string {
+	return e.err.Error()
+}
+
+func (e *statusError) Unwrap() error {
+	return e.err
+}
+
+// withStatus tags err with the status of the instance that caused it.
+func withStatus(status lima.VMStatus, err error) error {
+	return &statusError{Status: status, err: err}
+}
+
+// afterCommandError is the failure of the command run with --after-command, finch exits with its exit code.
+type afterCommandError struct {
+	Code int
+}
+
+func (e *afterCommandError) Error() string {
+	return fmt.Sprintf("the after-command exited with code %d", e.Code)
+}
+
+// Exit codes of finch vm stop. They are a contract with the scripts checking them, so they must never change,
+// and they match the ones of finch vm status --probe-only for the same statuses.
+const (
+	stopExitCodeError          = 1
+	stopExitCodeAlreadyStopped = 2
+	stopExitCodeNonexistent    = 3
+	stopExitCodeBroken         = 4
+	stopExitCodeTimeout        = 5
+)
+
+// exitCodeOf returns the code finch exits with when it fails with err, see the stopExitCode constants,
+// or the one of the command run with --after-command if it's what failed.
+func exitCodeOf(err error) int {
+	var se *statusError
+	var ace *afterCommandError
+	switch {
+	case errors.As(err, &ace):
+		return ace.Code
+	case errors.As(err, &se):
+		switch se.Status {
+		case lima.Stopped:
+			return stopExitCodeAlreadyStopped
+		case lima.Nonexistent:
+			return stopExitCodeNonexistent
+		case lima.Broken:
+			return stopExitCodeBroken
+		}
+	case classifyStopError(err) == stopErrorTimeout:
+		return stopExitCodeTimeout
+	}
+	return stopExitCodeError
+}
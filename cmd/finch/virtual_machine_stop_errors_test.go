@@ -0,0 +1,157 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/runfinch/finch/pkg/lima"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyStopError(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name string
+		err  error
+		want stopErrorCategory
+	}{
+		{
+			name: "stop phase timeout",
+			err:  withCategory(stopErrorTimeout, errors.New("the stop phase timed out after 1m0s, use --force to stop the instance forcibly")),
+			want: stopErrorTimeout,
+		},
+		{
+			name: "passed deadline",
+			err:  fmt.Errorf("the deadline passed before the status phase of the stop: %w", context.DeadlineExceeded),
+			want: stopErrorTimeout,
+		},
+		{
+			name: "command that didn't finish in time",
+			err:  errTimedOut,
+			want: stopErrorTimeout,
+		},
+		{
+			name: "user data disk still attached",
+			err:  withCategory(stopErrorDisk, errors.New("the user data disk is still attached after the stop")),
+			want: stopErrorDisk,
+		},
+		{
+			name: "failed limactl stop",
+			err:  withCategory(stopErrorLimactl, fmt.Errorf("limactl stop exited with code %d: %w", 1, errors.New("exit status 1"))),
+			want: stopErrorLimactl,
+		},
+		{
+			name: "missing limactl",
+			err:  lima.ErrLimactlNotFound,
+			want: stopErrorLimactl,
+		},
+		{
+			name: "failed drain",
+			err:  withCategory(stopErrorGuest, fmt.Errorf("failed to drain the running containers: %w", errors.New("exit status 1"))),
+			want: stopErrorGuest,
+		},
+		{
+			name: "category wrapped by the caller",
+			err:  fmt.Errorf("failed to stop instance %q: %w", limaInstanceName, withCategory(stopErrorDisk, errors.New("disk in use"))),
+			want: stopErrorDisk,
+		},
+		{
+			name: "anything else",
+			err:  fmt.Errorf("the post-stop command failed: %w", errors.New("exit status 1")),
+			want: stopErrorUnknown,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.want, classifyStopError(tc.err))
+		})
+	}
+}
+
+func TestWithCategory(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, withCategory(stopErrorGuest, nil))
+
+	cause := errors.New("exit status 1")
+	err := withCategory(stopErrorGuest, cause)
+	assert.EqualError(t, err, "exit status 1")
+	assert.ErrorIs(t, err, cause)
+}
+
+func TestExitCodeOf(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{
+			name: "generic error",
+			err:  fmt.Errorf("the post-stop command failed: %w", errors.New("exit status 1")),
+			want: 1,
+		},
+		{
+			name: "already stopped",
+			err:  withStatus(lima.Stopped, fmt.Errorf("the instance %q is already stopped", limaInstanceName)),
+			want: 2,
+		},
+		{
+			name: "not found",
+			err:  withStatus(lima.Nonexistent, fmt.Errorf("the instance %q does not exist", limaInstanceName)),
+			want: 3,
+		},
+		{
+			name: "broken",
+			err:  withStatus(lima.Broken, fmt.Errorf("the instance %q is broken, use --force to stop it", limaInstanceName)),
+			want: 4,
+		},
+		{
+			name: "still starting",
+			err:  withStatus(lima.Starting, fmt.Errorf("the instance %q is still starting; retry shortly", limaInstanceName)),
+			want: 1,
+		},
+		{
+			name: "timeout",
+			err:  withCategory(stopErrorTimeout, errors.New("the stop phase timed out after 1m0s, use --force to stop the instance forcibly")),
+			want: 5,
+		},
+		{
+			name: "passed deadline",
+			err:  fmt.Errorf("the deadline passed before the status phase of the stop: %w", context.DeadlineExceeded),
+			want: 5,
+		},
+		{
+			name: "status wrapped by the caller",
+			err: errors.Join(fmt.Errorf("failed to stop instance %q: %w", "first",
+				withStatus(lima.Stopped, fmt.Errorf("the instance %q is already stopped", "first")))),
+			want: 2,
+		},
+		{
+			name: "failed disk detach",
+			err:  withCategory(stopErrorDisk, errors.New("the user data disk is still attached after the stop")),
+			want: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.want, exitCodeOf(tc.err))
+		})
+	}
+}
@@ -0,0 +1,45 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/runfinch/finch/pkg/lima"
+)
+
+// guestKernelPoweroffScript powers the guest off from its kernel with the magic SysRq key, without going through init.
+// The kernel doesn't deliver SIGKILL to init, and Lima doesn't let the host signal the guest through the hypervisor,
+// so it's the last thing that can be done from the guest side to a guest whose init ignores the poweroff.
+const guestKernelPoweroffScript = "echo 1 > /proc/sys/kernel/sysrq; echo o > /proc/sysrq-trigger"
+
+// errGuestUnresponsive is returned by escalateGuestPoweroff when the guest is still running after both guest tiers.
+var errGuestUnresponsive = errors.New("the guest didn't power off")
+
+// escalateGuestPoweroff waits stop.guestTimeout for the guest powered off by systemd to stop, then powers it off
+// from its kernel and waits stop.guestTimeout again. It returns errGuestUnresponsive if it's still running after that,
+// for the stop to fall back to forcibly stopping it from the host.
+// done stops the progress shown for the poweroff, it's replaced with the one of the progress shown from then on.
+func (sva *stopVMAction) escalateGuestPoweroff(instance string, done *func()) error {
+	guestTimeout := sva.fc.Stop.GuestTimeout
+	// There's no time left to escalate once the deadline set by a supervisor has passed.
+	if err := sva.waitForStatus(instance, lima.Stopped, guestTimeout); err == nil || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+
+	// The warning would be drawn over by the progress, so it's stopped first and shown again after.
+	(*done)()
+	sva.logger.Warnf("The instance %q is still running %s after being powered off, powering it off from its kernel...",
+		instance, guestTimeout)
+	*done = sva.logger.StartProgress("Powering off Finch virtual machine from its kernel...")
+	// Like the poweroff, this drops the SSH connection if it works, so only the status of the instance tells.
+	_, _ = sva.creator.CreateWithoutStdio("shell", instance, "sudo", "sh", "-c", guestKernelPoweroffScript).CombinedOutput()
+	if err := sva.waitForStatus(instance, lima.Stopped, guestTimeout); err == nil || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return errGuestUnresponsive
+}
@@ -0,0 +1,174 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/mocks"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+// trackProgress returns a fake of Logger.StartProgress that keeps track of whether the progress is shown in spinning.
+func trackProgress(spinning *bool) func(string) func() {
+	return func(string) func() {
+		*spinning = true
+		return func() { *spinning = false }
+	}
+}
+
+// expectKernelPoweroffProgress expects the progress of the poweroff to be replaced with the one of the poweroff
+// from the kernel of the guest, and the warning in between to be logged while no progress is shown.
+func expectKernelPoweroffProgress(t *testing.T, logger *mocks.Logger, guestTimeout time.Duration, spinning *bool) {
+	logger.EXPECT().Warnf("The instance %q is still running %s after being powered off, powering it off from its kernel...",
+		limaInstanceName, guestTimeout).Do(func(string, ...any) {
+		assert.False(t, *spinning, "the warning was logged while the progress was shown")
+	})
+	logger.EXPECT().StartProgress("Powering off Finch virtual machine from its kernel...").DoAndReturn(trackProgress(spinning))
+}
+
+func TestStopVMAction_runWithGuestTimeout(t *testing.T) {
+	t.Parallel()
+
+	const guestTimeout = time.Millisecond
+	kernelPoweroffArgs := []any{"shell", limaInstanceName, "sudo", "sh", "-c", guestKernelPoweroffScript}
+
+	testCases := []struct {
+		name    string
+		mockSvc func(
+			t *testing.T,
+			logger *mocks.Logger,
+			creator *mocks.NerdctlCmdCreator,
+			dm *mocks.UserDataDiskManager,
+			fs afero.Fs,
+			ctrl *gomock.Controller,
+			spinning *bool,
+		)
+	}{
+		{
+			name: "should not escalate if the guest powers off in time",
+			mockSvc: func(
+				_ *testing.T,
+				logger *mocks.Logger,
+				creator *mocks.NerdctlCmdCreator,
+				_ *mocks.UserDataDiskManager,
+				_ afero.Fs,
+				ctrl *gomock.Controller,
+				_ *bool,
+			) {
+				stoppedC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(stoppedC)
+				stoppedC.EXPECT().Output().Return([]byte("Stopped"), nil)
+				logger.EXPECT().Info("Finch virtual machine stopped successfully")
+			},
+		},
+		{
+			name: "should power the guest off from its kernel if it ignores the poweroff",
+			mockSvc: func(
+				t *testing.T,
+				logger *mocks.Logger,
+				creator *mocks.NerdctlCmdCreator,
+				_ *mocks.UserDataDiskManager,
+				_ afero.Fs,
+				ctrl *gomock.Controller,
+				spinning *bool,
+			) {
+				// The guest stops once it's powered off from its kernel.
+				poweredOff := false
+				statusC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(statusC).MinTimes(2)
+				statusC.EXPECT().Output().DoAndReturn(func() ([]byte, error) {
+					if poweredOff {
+						return []byte("Stopped"), nil
+					}
+					return []byte("Running"), nil
+				}).MinTimes(2)
+				expectKernelPoweroffProgress(t, logger, guestTimeout, spinning)
+				kernelPoweroffC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio(kernelPoweroffArgs...).Return(kernelPoweroffC)
+				kernelPoweroffC.EXPECT().CombinedOutput().DoAndReturn(func() ([]byte, error) {
+					poweredOff = true
+					return nil, errors.New("connection closed")
+				})
+				logger.EXPECT().Info("Finch virtual machine stopped successfully")
+			},
+		},
+		{
+			name: "should forcibly stop the instance from the host if the guest is unresponsive",
+			mockSvc: func(
+				t *testing.T,
+				logger *mocks.Logger,
+				creator *mocks.NerdctlCmdCreator,
+				dm *mocks.UserDataDiskManager,
+				fs afero.Fs,
+				ctrl *gomock.Controller,
+				spinning *bool,
+			) {
+				runningC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(runningC).MinTimes(2)
+				runningC.EXPECT().Output().Return([]byte("Running"), nil).MinTimes(2)
+				expectKernelPoweroffProgress(t, logger, guestTimeout, spinning)
+				kernelPoweroffC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio(kernelPoweroffArgs...).Return(kernelPoweroffC)
+				kernelPoweroffC.EXPECT().CombinedOutput().Return(nil, errors.New("exit status 1"))
+				logger.EXPECT().Warnf("The instance %q is still running after being powered off from its kernel, "+
+					"forcibly stopping it from the host...", limaInstanceName).Do(func(string, ...any) {
+					assert.False(t, *spinning, "the warning was logged while the progress was shown")
+				})
+				serialLogPath := filepath.Join(mockFinchPath.LimaInstancePath(), "serial.log")
+				require.NoError(t, afero.WriteFile(fs, serialLogPath, []byte("kernel log"), 0o600))
+				logger.EXPECT().Infof("Guest kernel log saved to %q", gomock.Any())
+				dm.EXPECT().DetachUserDataDisk().Return(nil)
+				logger.EXPECT().StartProgress("Forcibly stopping Finch virtual machine...").Return(func() {})
+				stopC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("stop", "--force", limaInstanceName).Return(stopC)
+				stopC.EXPECT().CombinedOutput()
+				logger.EXPECT().Info("Finch virtual machine stopped successfully")
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+			fc := &config.Finch{}
+			fc.Stop.Method = config.StopMethodSystemdPoweroff
+			fc.Stop.GuestTimeout = guestTimeout
+
+			getVMStatusC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+			getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+			sshC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "true").Return(sshC)
+			sshC.EXPECT().CombinedOutput()
+			expectUnmountGuestDataVolume(ncc, ctrl)
+			dm.EXPECT().DetachUserDataDisk().Return(nil)
+			spinning := false
+			logger.EXPECT().StartProgress("Powering off Finch virtual machine...").DoAndReturn(trackProgress(&spinning))
+			poweroffC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "sudo", "systemctl", "poweroff").Return(poweroffC)
+			poweroffC.EXPECT().CombinedOutput().Return(nil, errors.New("connection closed"))
+			fs := afero.NewMemMapFs()
+			tc.mockSvc(t, logger, ncc, dm, fs, ctrl, &spinning)
+
+			action := newStopVMAction(ncc, nil, dm, logger, fs, mockFinchPath, fc, mockFinchRootPath, nil, nil, nil)
+			assert.NoError(t, action.run(stopVMOptions{}))
+		})
+	}
+}
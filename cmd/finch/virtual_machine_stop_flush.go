@@ -0,0 +1,62 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/docker/go-units"
+)
+
+// largePendingWrites is the amount of pending writes of the guest above which flushing them is worth a warning,
+// as that much work would have been lost if the VM had gone down without flushing them.
+const largePendingWrites = 64 * units.MiB
+
+// flushGuestWrites flushes the writes pending in the page cache of the guest before it's stopped, e.g. to the bind
+// mounts of the host, and reports how much was flushed. Failing to flush them doesn't prevent the VM from being stopped.
+func (sva *stopVMAction) flushGuestWrites(instance string) {
+	pending, pendingErr := sva.pendingGuestWrites(instance)
+	if pendingErr != nil {
+		sva.logger.Warnf("Could not check for the pending writes of the guest: %v", pendingErr)
+	}
+	if logs, err := sva.creator.CreateWithoutStdio("shell", instance, "sudo", "sync").CombinedOutput(); err != nil {
+		sva.logger.Warnf("Could not flush the pending writes of the guest: %v, debug logs:\n%s", err, logs)
+		return
+	}
+	if pendingErr != nil || pending == 0 {
+		return
+	}
+	if pending >= largePendingWrites {
+		sva.logger.Warnf("Flushed %s of pending writes of the guest, they would have been lost if it had gone down without "+
+			"flushing them", units.BytesSize(float64(pending)))
+		return
+	}
+	sva.logger.Infof("Flushed %s of pending writes of the guest", units.BytesSize(float64(pending)))
+}
+
+// pendingGuestWrites returns the bytes waiting in the page cache of the guest to be written back, from /proc/meminfo.
+func (sva *stopVMAction) pendingGuestWrites(instance string) (int64, error) {
+	out, err := sva.creator.CreateWithoutStdio("shell", instance, "cat", "/proc/meminfo").Output()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read the memory usage of the guest: %w", err)
+	}
+	var pending int64
+	for _, line := range strings.Split(string(out), "\n") {
+		// e.g. "Dirty:              1024 kB"
+		fields := strings.Fields(line)
+		if len(fields) < 2 || (fields[0] != "Dirty:" && fields[0] != "Writeback:") {
+			continue
+		}
+		kb, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("failed to parse %q of the memory usage of the guest: %w", line, err)
+		}
+		pending += kb * units.KiB
+	}
+	return pending, nil
+}
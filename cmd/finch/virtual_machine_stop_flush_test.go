@@ -0,0 +1,130 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/mocks"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestStopVMAction_runWithFlushWrites(t *testing.T) {
+	t.Parallel()
+
+	const meminfo = "MemTotal:        4005504 kB\nDirty:              %d kB\nWriteback:          %d kB\n"
+
+	testCases := []struct {
+		name    string
+		opts    stopVMOptions
+		mockSvc func(*mocks.NerdctlCmdCreator, *mocks.Logger, *gomock.Controller)
+	}{
+		{
+			name: "should report the pending writes flushed",
+			opts: stopVMOptions{flushWrites: true},
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, logger *mocks.Logger, ctrl *gomock.Controller) {
+				meminfoC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "cat", "/proc/meminfo").Return(meminfoC)
+				meminfoC.EXPECT().Output().Return([]byte(fmt.Sprintf(meminfo, 1536, 512)), nil)
+				syncC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "sudo", "sync").Return(syncC)
+				syncC.EXPECT().CombinedOutput()
+				logger.EXPECT().Infof("Flushed %s of pending writes of the guest", "2MiB")
+			},
+		},
+		{
+			name: "should warn if a lot of pending writes were flushed",
+			opts: stopVMOptions{flushWrites: true},
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, logger *mocks.Logger, ctrl *gomock.Controller) {
+				meminfoC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "cat", "/proc/meminfo").Return(meminfoC)
+				meminfoC.EXPECT().Output().Return([]byte(fmt.Sprintf(meminfo, 131072, 0)), nil)
+				syncC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "sudo", "sync").Return(syncC)
+				syncC.EXPECT().CombinedOutput()
+				logger.EXPECT().Warnf("Flushed %s of pending writes of the guest, they would have been lost if it had gone down "+
+					"without flushing them", "128MiB")
+			},
+		},
+		{
+			name: "should not report anything if there were no pending writes",
+			opts: stopVMOptions{flushWrites: true},
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, _ *mocks.Logger, ctrl *gomock.Controller) {
+				meminfoC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "cat", "/proc/meminfo").Return(meminfoC)
+				meminfoC.EXPECT().Output().Return([]byte(fmt.Sprintf(meminfo, 0, 0)), nil)
+				syncC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "sudo", "sync").Return(syncC)
+				syncC.EXPECT().CombinedOutput()
+			},
+		},
+		{
+			name: "should still flush if the pending writes can't be checked",
+			opts: stopVMOptions{flushWrites: true},
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, logger *mocks.Logger, ctrl *gomock.Controller) {
+				meminfoC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "cat", "/proc/meminfo").Return(meminfoC)
+				meminfoC.EXPECT().Output().Return(nil, errors.New("exit status 1"))
+				logger.EXPECT().Warnf("Could not check for the pending writes of the guest: %v",
+					fmt.Errorf("failed to read the memory usage of the guest: %w", errors.New("exit status 1")))
+				syncC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "sudo", "sync").Return(syncC)
+				syncC.EXPECT().CombinedOutput()
+			},
+		},
+		{
+			name: "should still stop if the pending writes can't be flushed",
+			opts: stopVMOptions{flushWrites: true},
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, logger *mocks.Logger, ctrl *gomock.Controller) {
+				meminfoC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "cat", "/proc/meminfo").Return(meminfoC)
+				meminfoC.EXPECT().Output().Return([]byte(fmt.Sprintf(meminfo, 4, 0)), nil)
+				syncC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "sudo", "sync").Return(syncC)
+				syncC.EXPECT().CombinedOutput().Return([]byte("sync: I/O error"), errors.New("exit status 1"))
+				logger.EXPECT().Warnf("Could not flush the pending writes of the guest: %v, debug logs:\n%s",
+					errors.New("exit status 1"), []byte("sync: I/O error"))
+			},
+		},
+		{
+			name:    "should not flush the pending writes with --flush-writes=false",
+			opts:    stopVMOptions{},
+			mockSvc: func(*mocks.NerdctlCmdCreator, *mocks.Logger, *gomock.Controller) {},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+
+			getVMStatusC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+			getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+			tc.mockSvc(ncc, logger, ctrl)
+			expectGracefulStop(ncc, dm, logger, ctrl)
+
+			recorder := mocks.NewNerdctlCmdCreatorRecorder(ncc)
+			action := newStopVMAction(recorder, nil, dm, logger, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath,
+				nil, nil, nil)
+			assert.NoError(t, action.run(tc.opts))
+			if tc.opts.flushWrites {
+				// The writes must be flushed while the guest can still write them back, i.e. before it's stopped.
+				assert.NoError(t, recorder.InOrder([]string{"shell", limaInstanceName, "sudo", "sync"}, []string{"stop", limaInstanceName}))
+			}
+		})
+	}
+}
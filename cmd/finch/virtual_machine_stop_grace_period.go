@@ -0,0 +1,47 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// defaultGracePeriod is how long the guest gets to stop cleanly before it's forcibly stopped, the same as kubectl's.
+const defaultGracePeriod = 30 * time.Second
+
+// gracePeriodExpired reports whether the graceful stop failed with err because the guest didn't stop within --grace-period.
+// A deadline set by a supervisor isn't one, there's no time left to forcibly stop the instance then.
+func (sva *stopVMAction) gracePeriodExpired(err error) bool {
+	return sva.gracePeriod > 0 && classifyStopError(err) == stopErrorTimeout && !errors.Is(err, context.DeadlineExceeded)
+}
+
+// forceStopAfterGracePeriod forcibly stops the instance once its grace period has expired,
+// like Kubernetes kills the containers of a pod that didn't terminate within its own.
+func (sva *stopVMAction) forceStopAfterGracePeriod(instance string) error {
+	sva.logger.Warnf("The instance %q didn't stop within its grace period of %s, forcibly stopping it...", instance, sva.gracePeriod)
+	return sva.stopVM(instance, true)
+}
+
+// stopPhaseTimeout is how long the stop phase may take, 0 for no limit.
+// The grace period only bounds a graceful stop, a forced one is only bounded by --timeout.
+func (sva *stopVMAction) stopPhaseTimeout(force bool) time.Duration {
+	if !force && sva.gracePeriod > 0 {
+		return sva.gracePeriod
+	}
+	return sva.stopTimeout
+}
+
+// stopPhaseTimedOut is the error of a stop phase that didn't complete within timeout.
+func stopPhaseTimedOut(timeout time.Duration, force bool) error {
+	if force {
+		return withCategory(stopErrorTimeout, fmt.Errorf("the stop phase timed out after %s", timeout))
+	}
+	return withCategory(stopErrorTimeout,
+		fmt.Errorf("the stop phase timed out after %s, use --force to stop the instance forcibly", timeout))
+}
@@ -0,0 +1,276 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/mocks"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+// expectForcedStopAfterGracePeriod expects the instance to be forcibly stopped once its grace period has expired.
+func expectForcedStopAfterGracePeriod(
+	t *testing.T,
+	creator *mocks.NerdctlCmdCreator,
+	dm *mocks.UserDataDiskManager,
+	logger *mocks.Logger,
+	fs afero.Fs,
+	ctrl *gomock.Controller,
+	gracePeriod time.Duration,
+) {
+	logger.EXPECT().Warnf("The instance %q didn't stop within its grace period of %s, forcibly stopping it...",
+		limaInstanceName, gracePeriod)
+	serialLogPath := filepath.Join(mockFinchPath.LimaInstancePath(), "serial.log")
+	require.NoError(t, afero.WriteFile(fs, serialLogPath, []byte("kernel log"), 0o600))
+	logger.EXPECT().Infof("Guest kernel log saved to %q", gomock.Any())
+	dm.EXPECT().DetachUserDataDisk().Return(nil)
+	logger.EXPECT().StartProgress("Forcibly stopping Finch virtual machine...").Return(func() {})
+	forceStopC := mocks.NewCommand(ctrl)
+	creator.EXPECT().CreateWithoutStdio("stop", "--force", limaInstanceName).Return(forceStopC)
+	forceStopC.EXPECT().CombinedOutput()
+	logger.EXPECT().Info("Finch virtual machine stopped successfully")
+}
+
+func TestStopVMAction_runWithGracePeriod(t *testing.T) {
+	t.Parallel()
+
+	const gracePeriod = 10 * time.Millisecond
+
+	testCases := []struct {
+		name       string
+		stopMethod config.StopMethod
+		mockSvc    func(
+			t *testing.T,
+			logger *mocks.Logger,
+			creator *mocks.NerdctlCmdCreator,
+			dm *mocks.UserDataDiskManager,
+			fs afero.Fs,
+			ctrl *gomock.Controller,
+		)
+	}{
+		{
+			name: "should not forcibly stop the instance if it stops within its grace period",
+			mockSvc: func(
+				_ *testing.T,
+				logger *mocks.Logger,
+				creator *mocks.NerdctlCmdCreator,
+				dm *mocks.UserDataDiskManager,
+				_ afero.Fs,
+				ctrl *gomock.Controller,
+			) {
+				expectGracefulStop(creator, dm, logger, ctrl)
+			},
+		},
+		{
+			name: "should forcibly stop the instance if limactl doesn't stop it within its grace period",
+			mockSvc: func(
+				t *testing.T,
+				logger *mocks.Logger,
+				creator *mocks.NerdctlCmdCreator,
+				dm *mocks.UserDataDiskManager,
+				fs afero.Fs,
+				ctrl *gomock.Controller,
+			) {
+				expectUnmountGuestDataVolume(creator, ctrl)
+				dm.EXPECT().DetachUserDataDisk().Return(nil)
+				logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+				stopC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(stopC)
+				killed := make(chan struct{})
+				stopC.EXPECT().CombinedOutput().DoAndReturn(func() ([]byte, error) {
+					<-killed
+					return nil, errors.New("signal: killed")
+				})
+				stopC.EXPECT().Kill().Do(func() { close(killed) })
+				expectForcedStopAfterGracePeriod(t, creator, dm, logger, fs, ctrl, gracePeriod)
+			},
+		},
+		{
+			name:       "should forcibly stop the instance if the guest doesn't power off within its grace period",
+			stopMethod: config.StopMethodSystemdPoweroff,
+			mockSvc: func(
+				t *testing.T,
+				logger *mocks.Logger,
+				creator *mocks.NerdctlCmdCreator,
+				dm *mocks.UserDataDiskManager,
+				fs afero.Fs,
+				ctrl *gomock.Controller,
+			) {
+				sshC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "true").Return(sshC)
+				sshC.EXPECT().CombinedOutput()
+				expectUnmountGuestDataVolume(creator, ctrl)
+				dm.EXPECT().DetachUserDataDisk().Return(nil)
+				logger.EXPECT().StartProgress("Powering off Finch virtual machine...").Return(func() {})
+				poweroffC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "sudo", "systemctl", "poweroff").Return(poweroffC)
+				poweroffC.EXPECT().CombinedOutput().Return(nil, errors.New("connection closed"))
+				runningC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(runningC).MinTimes(1)
+				runningC.EXPECT().Output().Return([]byte("Running"), nil).MinTimes(1)
+				expectForcedStopAfterGracePeriod(t, creator, dm, logger, fs, ctrl, gracePeriod)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+			fs := afero.NewMemMapFs()
+			fc := &config.Finch{}
+			fc.Stop.Method = tc.stopMethod
+			fc.Stop.VerifyPollInterval = time.Millisecond
+
+			getVMStatusC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+			getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+			tc.mockSvc(t, logger, ncc, dm, fs, ctrl)
+
+			action := newStopVMAction(ncc, nil, dm, logger, fs, mockFinchPath, fc, mockFinchRootPath, nil, nil, nil)
+			assert.NoError(t, action.run(stopVMOptions{gracePeriod: gracePeriod}))
+		})
+	}
+}
+
+func TestStopVMAction_runForcedWithGracePeriod(t *testing.T) {
+	t.Parallel()
+
+	const gracePeriod = 10 * time.Millisecond
+
+	ctrl := gomock.NewController(t)
+	dm := mocks.NewUserDataDiskManager(ctrl)
+	logger := mocks.NewLogger(ctrl)
+	ncc := mocks.NewNerdctlCmdCreator(ctrl)
+	fs := afero.NewMemMapFs()
+
+	// The grace period only bounds a graceful stop, a forced one slower than it still goes through.
+	serialLogPath := filepath.Join(mockFinchPath.LimaInstancePath(), "serial.log")
+	require.NoError(t, afero.WriteFile(fs, serialLogPath, []byte("kernel log"), 0o600))
+	logger.EXPECT().Infof("Guest kernel log saved to %q", gomock.Any())
+	dm.EXPECT().DetachUserDataDisk().Return(nil)
+	logger.EXPECT().StartProgress("Forcibly stopping Finch virtual machine...").Return(func() {})
+	forceStopC := mocks.NewCommand(ctrl)
+	ncc.EXPECT().CreateWithoutStdio("stop", "--force", limaInstanceName).Return(forceStopC)
+	forceStopC.EXPECT().CombinedOutput().DoAndReturn(func() ([]byte, error) {
+		time.Sleep(5 * gracePeriod)
+		return nil, nil
+	})
+	logger.EXPECT().Info("Finch virtual machine stopped successfully")
+
+	action := newStopVMAction(ncc, nil, dm, logger, fs, mockFinchPath, &config.Finch{}, mockFinchRootPath, nil, nil, nil)
+	assert.NoError(t, action.run(stopVMOptions{force: true, gracePeriod: gracePeriod}))
+}
+
+func TestStopVMAction_runAdapterWithZeroGracePeriod(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	dm := mocks.NewUserDataDiskManager(ctrl)
+	logger := mocks.NewLogger(ctrl)
+	ncc := mocks.NewNerdctlCmdCreator(ctrl)
+	fs := afero.NewMemMapFs()
+
+	// Like `kubectl delete --grace-period=0`, the instance is forcibly stopped right away.
+	serialLogPath := filepath.Join(mockFinchPath.LimaInstancePath(), "serial.log")
+	require.NoError(t, afero.WriteFile(fs, serialLogPath, []byte("kernel log"), 0o600))
+	logger.EXPECT().Infof("Guest kernel log saved to %q", gomock.Any())
+	dm.EXPECT().DetachUserDataDisk().Return(nil)
+	logger.EXPECT().StartProgress("Forcibly stopping Finch virtual machine...").Return(func() {})
+	forceStopC := mocks.NewCommand(ctrl)
+	ncc.EXPECT().CreateWithoutStdio("stop", "--force", limaInstanceName).Return(forceStopC)
+	forceStopC.EXPECT().CombinedOutput()
+	logger.EXPECT().Info("Finch virtual machine stopped successfully")
+
+	action := newStopVMAction(ncc, nil, dm, logger, fs, mockFinchPath, &config.Finch{}, mockFinchRootPath, nil, nil, nil)
+	action.lookupEnv = func(string) (string, bool) { return "", false }
+	cmd := newStopVMCommand(nil, nil, nil, nil, nil, mockFinchPath, &config.Finch{}, mockFinchRootPath, nil, nil, nil)
+	require.NoError(t, cmd.Flags().Set("grace-period", "0"))
+	require.NoError(t, cmd.Flags().Set("allow-sleep", "true"))
+	assert.NoError(t, action.runAdapter(cmd, nil))
+}
+
+func TestStopVMAction_runRejectsInvalidGracePeriod(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name    string
+		opts    stopVMOptions
+		wantErr string
+	}{
+		{
+			name:    "should reject a negative grace period",
+			opts:    stopVMOptions{gracePeriod: -time.Second},
+			wantErr: "--grace-period must not be negative",
+		},
+		{
+			name:    "should reject a grace period together with a timeout",
+			opts:    stopVMOptions{gracePeriod: time.Second, timeout: time.Minute},
+			wantErr: "--grace-period cannot be used together with --timeout",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			action := newStopVMAction(nil, nil, nil, nil, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath,
+				nil, nil, nil)
+			assert.EqualError(t, action.run(tc.opts), tc.wantErr)
+		})
+	}
+}
+
+func TestStopVMAction_runAdapterRejectsZeroGracePeriodWithGracefulOptions(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name    string
+		flag    string
+		value   string
+		wantErr string
+	}{
+		{
+			name:    "should reject --grace-period=0 together with --hibernate",
+			flag:    "hibernate",
+			value:   "true",
+			wantErr: "--grace-period=0 stops the VM forcibly, it cannot be used together with --hibernate",
+		},
+		{
+			name:    "should reject --grace-period=0 together with --wait=false",
+			flag:    "wait",
+			value:   "false",
+			wantErr: "--grace-period=0 stops the VM forcibly, it cannot be used together with --wait=false",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			action := newStopVMAction(nil, nil, nil, nil, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath,
+				nil, nil, nil)
+			action.lookupEnv = func(string) (string, bool) { return "", false }
+			cmd := newStopVMCommand(nil, nil, nil, nil, nil, mockFinchPath, &config.Finch{}, mockFinchRootPath, nil, nil, nil)
+			require.NoError(t, cmd.Flags().Set("grace-period", "0"))
+			require.NoError(t, cmd.Flags().Set(tc.flag, tc.value))
+			assert.EqualError(t, action.runAdapter(cmd, nil), tc.wantErr)
+		})
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// containerState is the part of the state reported by nerdctl inspect that tells when the container was last active.
+// The times are left as strings since nerdctl leaves them empty for a container that never started or finished.
+type containerState struct {
+	Running    bool   `json:"Running"`
+	StartedAt  string `json:"StartedAt"`
+	FinishedAt string `json:"FinishedAt"`
+}
+
+// isIdleFor reports whether none of the containers of the instance has been running for at least idleFor,
+// and logs why the instance isn't idle otherwise. An instance without containers is idle.
+func (sva *stopVMAction) isIdleFor(instance string, idleFor time.Duration) (bool, error) {
+	states, err := sva.containerStates(instance)
+	if err != nil {
+		return false, fmt.Errorf("failed to check whether the instance %q is idle: %w", instance, err)
+	}
+
+	var lastActivity time.Time
+	for _, state := range states {
+		if state.Running {
+			sva.logger.Infof("Not stopping the instance %q, it has running containers", instance)
+			return false, nil
+		}
+		for _, t := range []string{state.StartedAt, state.FinishedAt} {
+			if parsed, err := time.Parse(time.RFC3339Nano, t); err == nil && parsed.After(lastActivity) {
+				lastActivity = parsed
+			}
+		}
+	}
+
+	if idle := time.Since(lastActivity); !lastActivity.IsZero() && idle < idleFor {
+		sva.logger.Infof("Not stopping the instance %q, a container was last active %s ago, less than %s",
+			instance, idle.Round(time.Second), idleFor)
+		return false, nil
+	}
+	return true, nil
+}
+
+// containerStates returns the states of all the containers of the instance, running or not.
+func (sva *stopVMAction) containerStates(instance string) ([]containerState, error) {
+	out, err := sva.creator.CreateWithoutStdio("shell", instance, "sudo", "-E", "nerdctl", "ps", "-a", "-q").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the containers: %w", err)
+	}
+	ids := strings.Fields(string(out))
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	args := append([]string{"shell", instance, "sudo", "-E", "nerdctl", "inspect", "--format", "{{json .State}}"}, ids...)
+	out, err = sva.creator.CreateWithoutStdio(args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect the containers: %w", err)
+	}
+	var states []containerState
+	for _, line := range bytes.Split(bytes.TrimSpace(out), []byte("\n")) {
+		var state containerState
+		if err := json.Unmarshal(line, &state); err != nil {
+			return nil, fmt.Errorf("failed to parse the state of a container: %w", err)
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
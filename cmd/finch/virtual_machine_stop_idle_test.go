@@ -0,0 +1,135 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/mocks"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestStopVMAction_runIfIdleFor(t *testing.T) {
+	t.Parallel()
+
+	psArgs := []any{"shell", limaInstanceName, "sudo", "-E", "nerdctl", "ps", "-a", "-q"}
+	inspectArgs := []any{"shell", limaInstanceName, "sudo", "-E", "nerdctl", "inspect", "--format", "{{json .State}}", "web", "db"}
+	state := func(running bool, startedAgo, finishedAgo time.Duration) string {
+		return fmt.Sprintf(`{"Running":%t,"StartedAt":%q,"FinishedAt":%q}`, running,
+			time.Now().Add(-startedAgo).Format(time.RFC3339Nano), time.Now().Add(-finishedAgo).Format(time.RFC3339Nano))
+	}
+	expectStop := func(ncc *mocks.NerdctlCmdCreator, dm *mocks.UserDataDiskManager, logger *mocks.Logger, ctrl *gomock.Controller) {
+		expectUnmountGuestDataVolume(ncc, ctrl)
+		dm.EXPECT().DetachUserDataDisk().Return(nil)
+		stopC := mocks.NewCommand(ctrl)
+		ncc.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(stopC)
+		stopC.EXPECT().CombinedOutput()
+		logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+		logger.EXPECT().Info("Finch virtual machine stopped successfully")
+	}
+
+	testCases := []struct {
+		name    string
+		wantErr error
+		mockSvc func(*mocks.NerdctlCmdCreator, *mocks.UserDataDiskManager, *mocks.Logger, *gomock.Controller)
+	}{
+		{
+			name: "should stop the VM if its containers have been idle long enough",
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, dm *mocks.UserDataDiskManager, logger *mocks.Logger, ctrl *gomock.Controller) {
+				psC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(psArgs...).Return(psC)
+				psC.EXPECT().Output().Return([]byte("web\ndb\n"), nil)
+				inspectC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(inspectArgs...).Return(inspectC)
+				inspectC.EXPECT().Output().Return([]byte(
+					state(false, 2*time.Hour, time.Hour)+"\n"+state(false, 3*time.Hour, 20*time.Minute)+"\n"), nil)
+				expectStop(ncc, dm, logger, ctrl)
+			},
+		},
+		{
+			name: "should stop the VM if it has no containers",
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, dm *mocks.UserDataDiskManager, logger *mocks.Logger, ctrl *gomock.Controller) {
+				psC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(psArgs...).Return(psC)
+				psC.EXPECT().Output().Return([]byte(""), nil)
+				expectStop(ncc, dm, logger, ctrl)
+			},
+		},
+		{
+			name: "should not stop the VM if a container was active recently",
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, _ *mocks.UserDataDiskManager, logger *mocks.Logger, ctrl *gomock.Controller) {
+				psC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(psArgs...).Return(psC)
+				psC.EXPECT().Output().Return([]byte("web\ndb\n"), nil)
+				inspectC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(inspectArgs...).Return(inspectC)
+				inspectC.EXPECT().Output().Return([]byte(
+					state(false, 2*time.Hour, time.Hour)+"\n"+state(false, time.Hour, 5*time.Minute)+"\n"), nil)
+				logger.EXPECT().Infof("Not stopping the instance %q, a container was last active %s ago, less than %s",
+					limaInstanceName, gomock.Any(), 10*time.Minute)
+			},
+		},
+		{
+			name: "should not stop the VM if a container is running",
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, _ *mocks.UserDataDiskManager, logger *mocks.Logger, ctrl *gomock.Controller) {
+				psC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(psArgs...).Return(psC)
+				psC.EXPECT().Output().Return([]byte("web\ndb\n"), nil)
+				inspectC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(inspectArgs...).Return(inspectC)
+				inspectC.EXPECT().Output().Return([]byte(
+					state(true, 2*time.Hour, 3*time.Hour)+"\n"+state(false, 3*time.Hour, time.Hour)+"\n"), nil)
+				logger.EXPECT().Infof("Not stopping the instance %q, it has running containers", limaInstanceName)
+			},
+		},
+		{
+			name: "should not stop the VM if the containers can't be listed",
+			wantErr: fmt.Errorf("failed to check whether the instance %q is idle: %w", limaInstanceName,
+				fmt.Errorf("failed to list the containers: %w", errors.New("exit status 255"))),
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, _ *mocks.UserDataDiskManager, _ *mocks.Logger, ctrl *gomock.Controller) {
+				psC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(psArgs...).Return(psC)
+				psC.EXPECT().Output().Return(nil, errors.New("exit status 255"))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+
+			getVMStatusC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+			getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+			tc.mockSvc(ncc, dm, logger, ctrl)
+
+			action := newStopVMAction(ncc, nil, dm, logger, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath,
+				nil, nil, nil)
+			err := action.run(stopVMOptions{ifIdleFor: 10 * time.Minute})
+			assert.Equal(t, tc.wantErr, err)
+		})
+	}
+}
+
+func TestStopVMAction_runIfIdleForWithForce(t *testing.T) {
+	t.Parallel()
+
+	action := newStopVMAction(nil, nil, nil, nil, nil, mockFinchPath, &config.Finch{}, mockFinchRootPath, nil, nil, nil)
+	err := action.run(stopVMOptions{force: true, ifIdleFor: time.Minute})
+	assert.EqualError(t, err, "--force and --if-idle-for cannot be used together")
+}
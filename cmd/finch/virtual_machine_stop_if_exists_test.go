@@ -0,0 +1,92 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/lima"
+	"github.com/runfinch/finch/pkg/mocks"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestStopVMAction_runProbeOnlyIfExists(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name       string
+		opts       stopVMOptions
+		mockSvc    func(*mocks.NerdctlCmdCreator, *mocks.UserDataDiskManager, *mocks.Logger, *gomock.Controller)
+		wantOutput string
+		wantErr    error
+	}{
+		{
+			name: "should succeed without stopping a nonexistent instance",
+			opts: stopVMOptions{probeOnlyIfExists: true},
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, _ *mocks.UserDataDiskManager, logger *mocks.Logger, ctrl *gomock.Controller) {
+				getVMStatusC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+				getVMStatusC.EXPECT().Output().Return([]byte(""), nil)
+				logger.EXPECT().Debugf("The instance %q does not exist, there's nothing to stop", limaInstanceName)
+			},
+		},
+		{
+			name: "should print that the instance doesn't exist with --json",
+			opts: stopVMOptions{probeOnlyIfExists: true, json: true},
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, _ *mocks.UserDataDiskManager, logger *mocks.Logger, ctrl *gomock.Controller) {
+				getVMStatusC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+				getVMStatusC.EXPECT().Output().Return([]byte(""), nil)
+				logger.EXPECT().Debugf("The instance %q does not exist, there's nothing to stop", limaInstanceName)
+			},
+			wantOutput: `{"status":"nonexistent","result":"noop"}` + "\n",
+		},
+		{
+			name: "should stop an existing instance as usual",
+			opts: stopVMOptions{probeOnlyIfExists: true},
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, dm *mocks.UserDataDiskManager, logger *mocks.Logger, ctrl *gomock.Controller) {
+				getVMStatusC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC).Times(2)
+				getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil).Times(2)
+				expectGracefulStop(ncc, dm, logger, ctrl)
+			},
+		},
+		{
+			name: "should still fail on a nonexistent instance without it",
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, _ *mocks.UserDataDiskManager, _ *mocks.Logger, ctrl *gomock.Controller) {
+				getVMStatusC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+				getVMStatusC.EXPECT().Output().Return([]byte(""), nil)
+			},
+			wantErr: withStatus(lima.Nonexistent, fmt.Errorf("the instance %q does not exist", limaInstanceName)),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+			tc.mockSvc(ncc, dm, logger, ctrl)
+
+			output := &bytes.Buffer{}
+			action := newStopVMAction(ncc, nil, dm, logger, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath,
+				nil, nil, output)
+			err := action.run(tc.opts)
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.wantOutput, output.String())
+		})
+	}
+}
@@ -0,0 +1,35 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import "github.com/runfinch/finch/pkg/command"
+
+// limaDebugCommands are the limactl commands --lima-debug makes verbose, the ones stopping the instance and
+// checking its status. The commands run in the guest are left alone, as their output is the one of the guest.
+var limaDebugCommands = map[string]bool{"stop": true, "ls": true}
+
+// limaDebugCmdCreator passes limactl its --debug flag for the limactl commands of the stop, for --lima-debug,
+// so that the logs captured from them include the verbose ones of limactl. Its debug logs go to stderr,
+// which leaves the output parsed from stdout, e.g. the status of the instance, as is.
+type limaDebugCmdCreator struct {
+	command.NerdctlCmdCreator
+}
+
+func (c limaDebugCmdCreator) Create(args ...string) command.Command {
+	return c.NerdctlCmdCreator.Create(withLimaDebug(args)...)
+}
+
+func (c limaDebugCmdCreator) CreateWithoutStdio(args ...string) command.Command {
+	return c.NerdctlCmdCreator.CreateWithoutStdio(withLimaDebug(args)...)
+}
+
+// withLimaDebug prepends the --debug flag of limactl to args if they run one of limaDebugCommands.
+func withLimaDebug(args []string) []string {
+	if len(args) == 0 || !limaDebugCommands[args[0]] {
+		return args
+	}
+	return append([]string{"--debug"}, args...)
+}
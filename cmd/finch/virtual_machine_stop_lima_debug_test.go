@@ -0,0 +1,76 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"testing"
+
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/mocks"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestWithLimaDebug(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{
+			name: "should make limactl stop verbose",
+			args: []string{"stop", "--force", "finch"},
+			want: []string{"--debug", "stop", "--force", "finch"},
+		},
+		{
+			name: "should make limactl ls verbose",
+			args: []string{"ls", "-f", "{{.Status}}", "finch"},
+			want: []string{"--debug", "ls", "-f", "{{.Status}}", "finch"},
+		},
+		{
+			name: "should leave the commands run in the guest alone",
+			args: []string{"shell", "finch", "sudo", "sync"},
+			want: []string{"shell", "finch", "sudo", "sync"},
+		},
+		{name: "should leave no arguments alone", args: nil, want: nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.want, withLimaDebug(tc.args))
+		})
+	}
+}
+
+func TestStopVMAction_runWithLimaDebug(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	dm := mocks.NewUserDataDiskManager(ctrl)
+	logger := mocks.NewLogger(ctrl)
+	ncc := mocks.NewNerdctlCmdCreator(ctrl)
+
+	getVMStatusC := mocks.NewCommand(ctrl)
+	ncc.EXPECT().CreateWithoutStdio("--debug", "ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+	getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+	expectUnmountGuestDataVolume(ncc, ctrl)
+	dm.EXPECT().DetachUserDataDisk().Return(nil)
+	stopC := mocks.NewCommand(ctrl)
+	ncc.EXPECT().CreateWithoutStdio("--debug", "stop", limaInstanceName).Return(stopC)
+	stopC.EXPECT().CombinedOutput()
+	logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+	logger.EXPECT().Info("Finch virtual machine stopped successfully")
+
+	action := newStopVMAction(limaDebugCmdCreator{ncc}, nil, dm, logger, afero.NewMemMapFs(), mockFinchPath, &config.Finch{},
+		mockFinchRootPath, nil, nil, nil)
+	assert.NoError(t, action.run(stopVMOptions{}))
+}
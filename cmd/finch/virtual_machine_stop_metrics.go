@@ -0,0 +1,91 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/runfinch/finch/pkg/version"
+)
+
+// stopMetrics is the record appended to the file set with --collect-metrics-to for each stop.
+// The file accumulates one record per line across runs, so that the stop performance can be tracked over Finch versions.
+type stopMetrics struct {
+	Time            time.Time          `json:"time"`
+	FinchVersion    string             `json:"finchVersion"`
+	Instance        string             `json:"instance"`
+	Forced          bool               `json:"forced"`
+	Success         bool               `json:"success"`
+	ErrorCategory   string             `json:"errorCategory,omitempty"`
+	DurationSeconds float64            `json:"durationSeconds"`
+	PhasesSeconds   map[string]float64 `json:"phasesSeconds"`
+}
+
+func newStopMetrics(instance string, forced bool, duration time.Duration, phases map[string]time.Duration, err error) stopMetrics {
+	phasesSeconds := make(map[string]float64, len(phases))
+	for phase, d := range phases {
+		phasesSeconds[phase] = d.Seconds()
+	}
+	metrics := stopMetrics{
+		Time:            time.Now().UTC(),
+		FinchVersion:    version.Version,
+		Instance:        instance,
+		Forced:          forced,
+		Success:         err == nil,
+		DurationSeconds: duration.Seconds(),
+		PhasesSeconds:   phasesSeconds,
+	}
+	if err != nil {
+		metrics.ErrorCategory = string(classifyStopError(err))
+	}
+	return metrics
+}
+
+// timePhase starts timing a phase of the stop, and records how long it took once the returned function is called.
+// The phase is also recorded as a span if tracing is enabled.
+func (sva *stopVMAction) timePhase(phase string) func() {
+	start := time.Now()
+	return func() {
+		if sva.phases == nil {
+			sva.phases = map[string]time.Duration{}
+		}
+		end := time.Now()
+		sva.phases[phase] += end.Sub(start)
+		if sva.traceEndpoint != "" {
+			sva.spans = append(sva.spans, phaseSpan{name: phase, start: start, end: end})
+		}
+	}
+}
+
+// collectMetrics appends the metrics to the file at path. Failing to do so doesn't fail the stop.
+func (sva *stopVMAction) collectMetrics(path string, metrics stopMetrics) {
+	if err := sva.appendStopMetrics(path, metrics); err != nil {
+		sva.logger.Warnf("Could not append the metrics of the stop to %q: %v", path, err)
+	}
+}
+
+func (sva *stopVMAction) appendStopMetrics(path string, metrics stopMetrics) error {
+	b, err := json.Marshal(metrics)
+	if err != nil {
+		return fmt.Errorf("failed to marshal the metrics: %w", err)
+	}
+	if err := sva.fs.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	f, err := sva.fs.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
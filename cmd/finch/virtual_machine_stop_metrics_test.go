@@ -0,0 +1,73 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/mocks"
+	"github.com/runfinch/finch/pkg/version"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestStopVMAction_runWithCollectMetricsTo(t *testing.T) {
+	t.Parallel()
+
+	const metricsPath = "/metrics/stop.jsonl"
+
+	ctrl := gomock.NewController(t)
+	dm := mocks.NewUserDataDiskManager(ctrl)
+	logger := mocks.NewLogger(ctrl)
+	ncc := mocks.NewNerdctlCmdCreator(ctrl)
+	fs := afero.NewMemMapFs()
+	action := newStopVMAction(ncc, nil, dm, logger, fs, mockFinchPath, &config.Finch{}, mockFinchRootPath, nil, nil, nil)
+
+	for _, stopErr := range []error{nil, errors.New("exit status 1")} {
+		getVMStatusC := mocks.NewCommand(ctrl)
+		ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+		getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+		expectUnmountGuestDataVolume(ncc, ctrl)
+		dm.EXPECT().DetachUserDataDisk().Return(nil)
+		stopC := mocks.NewCommand(ctrl)
+		ncc.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(stopC)
+		stopC.EXPECT().CombinedOutput().Return(nil, stopErr)
+		logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+		if stopErr == nil {
+			logger.EXPECT().Info("Finch virtual machine stopped successfully")
+		} else {
+			logger.EXPECT().Errorf("Finch virtual machine failed to stop, debug logs:\n%s", []byte(nil))
+			expectReattachUserDataDisk(ncc, dm, logger, ctrl)
+		}
+
+		err := action.run(stopVMOptions{collectMetricsTo: metricsPath})
+		assert.Equal(t, withCategory(stopErrorLimactl, stopErr), err)
+	}
+
+	b, err := afero.ReadFile(fs, metricsPath)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	require.Len(t, lines, 2)
+	for i, wantCategory := range []string{"", "limactl"} {
+		var metrics stopMetrics
+		require.NoError(t, json.Unmarshal([]byte(lines[i]), &metrics))
+		assert.Equal(t, limaInstanceName, metrics.Instance)
+		assert.Equal(t, version.Version, metrics.FinchVersion)
+		assert.False(t, metrics.Forced)
+		assert.Equal(t, wantCategory == "", metrics.Success)
+		assert.Equal(t, wantCategory, metrics.ErrorCategory)
+		assert.Equal(t, []string{"detach", "status", "stop"}, slices.Sorted(maps.Keys(metrics.PhasesSeconds)))
+	}
+}
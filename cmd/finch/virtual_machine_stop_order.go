@@ -0,0 +1,61 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// orderByDependencies orders the instances for them to be stopped, so that an instance is stopped before the ones
+// it depends on, as declared in stop.dependsOn. The instances that don't depend on each other keep their order,
+// and dependencies on instances that aren't listed are ignored. It fails if the dependencies form a cycle.
+func orderByDependencies(instances []string, dependsOn map[string][]string) ([]string, error) {
+	if len(dependsOn) == 0 {
+		return instances, nil
+	}
+
+	remaining := slices.Clone(instances)
+	dependsOnRemaining := func(instance, dependency string) bool {
+		return slices.Contains(dependsOn[instance], dependency) && slices.Contains(remaining, instance)
+	}
+	// An instance can be stopped once none of the remaining instances depends on it.
+	canStop := func(dependency string) bool {
+		return !slices.ContainsFunc(remaining, func(instance string) bool { return dependsOnRemaining(instance, dependency) })
+	}
+
+	ordered := make([]string, 0, len(instances))
+	for len(remaining) > 0 {
+		i := slices.IndexFunc(remaining, canStop)
+		if i < 0 {
+			return nil, fmt.Errorf("the instances to stop depend on each other in a cycle: %s", dependencyCycle(remaining, dependsOnRemaining))
+		}
+		ordered = append(ordered, remaining[i])
+		remaining = slices.Delete(remaining, i, i+1)
+	}
+	return ordered, nil
+}
+
+// dependencyCycle describes a cycle of dependencies among the remaining instances, each of which has an instance
+// depending on it, as "a -> b -> a" where a depends on b.
+func dependencyCycle(remaining []string, dependsOnRemaining func(instance, dependency string) bool) string {
+	var path []string
+	seen := map[string]int{}
+	for current := remaining[0]; ; {
+		if i, ok := seen[current]; ok {
+			path = path[i:]
+			break
+		}
+		seen[current] = len(path)
+		path = append(path, current)
+		j := slices.IndexFunc(remaining, func(instance string) bool { return dependsOnRemaining(instance, current) })
+		current = remaining[j]
+	}
+	// The path goes from each instance to one that depends on it, the cycle is told the other way around.
+	slices.Reverse(path)
+	return strings.Join(append(path, path[0]), " -> ")
+}
@@ -0,0 +1,84 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"testing"
+
+	"github.com/runfinch/finch/pkg/config"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrderByDependencies(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name      string
+		instances []string
+		dependsOn map[string][]string
+		want      []string
+		wantErr   string
+	}{
+		{
+			name:      "should keep the order without dependencies",
+			instances: []string{"db", "app", "cache"},
+			want:      []string{"db", "app", "cache"},
+		},
+		{
+			name:      "should stop the dependents before their dependencies",
+			instances: []string{"db", "cache", "app", "worker"},
+			dependsOn: map[string][]string{"app": {"db", "cache"}, "worker": {"app"}},
+			want:      []string{"worker", "app", "db", "cache"},
+		},
+		{
+			name:      "should ignore the dependencies that aren't stopped",
+			instances: []string{"db", "app"},
+			dependsOn: map[string][]string{"app": {"db", "queue"}, "queue": {"db"}},
+			want:      []string{"app", "db"},
+		},
+		{
+			name:      "should fail on a cycle",
+			instances: []string{"db", "app", "cache"},
+			dependsOn: map[string][]string{"app": {"db"}, "db": {"cache"}, "cache": {"app"}},
+			wantErr:   "the instances to stop depend on each other in a cycle: cache -> app -> db -> cache",
+		},
+		{
+			name:      "should fail on an instance that depends on itself",
+			instances: []string{"app"},
+			dependsOn: map[string][]string{"app": {"app"}},
+			wantErr:   "the instances to stop depend on each other in a cycle: app -> app",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := orderByDependencies(tc.instances, tc.dependsOn)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestStopVMAction_runFailsOnDependencyCycle(t *testing.T) {
+	t.Parallel()
+
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, "/instances", []byte("db\napp\n"), 0o600))
+	fc := &config.Finch{}
+	fc.Stop.DependsOn = map[string][]string{"app": {"db"}, "db": {"app"}}
+	action := newStopVMAction(nil, nil, nil, nil, fs, mockFinchPath, fc, mockFinchRootPath, nil, nil, nil)
+	err := action.run(stopVMOptions{instanceFile: "/instances"})
+	assert.EqualError(t, err, "the instances to stop depend on each other in a cycle: app -> db -> app")
+}
@@ -0,0 +1,29 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import "fmt"
+
+// preSnapshotUserDataDisk snapshots the user data disk of the running Finch VM before it's stopped, for --pre-snapshot,
+// as a safety net for a risky stop. The guest flushes its writes first, so that the clone is as consistent as it gets
+// while the VM runs. Failing to take the snapshot fails the stop before anything is stopped, unless ignoreErrors is set.
+func (sva *stopVMAction) preSnapshotUserDataDisk(instance, name string, ignoreErrors bool) error {
+	if logs, err := sva.creator.CreateWithoutStdio("shell", instance, "sudo", "sync").CombinedOutput(); err != nil {
+		sva.logger.Warnf("Could not flush the writes of the guest before the snapshot: %v, debug logs:\n%s", err, logs)
+	}
+	done := sva.logger.StartProgress(fmt.Sprintf("Taking snapshot %q of the user data disk...", name))
+	snapshotPath, err := sva.diskManager.SnapshotUserDataDisk(name)
+	done()
+	if err != nil {
+		if ignoreErrors {
+			sva.logger.Warnf("Could not snapshot the user data disk, stopping the VM anyway: %v", err)
+			return nil
+		}
+		return withCategory(stopErrorDisk, fmt.Errorf("failed to snapshot the user data disk, the VM wasn't stopped: %w", err))
+	}
+	sva.logger.Infof("Saved snapshot %q of the user data disk to %q", name, snapshotPath)
+	return nil
+}
@@ -0,0 +1,112 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"testing"
+
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/mocks"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestStopVMAction_runWithPreSnapshot(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.Skip("--pre-snapshot only applies to macOS")
+	}
+
+	const snapshotName = "before-upgrade"
+	snapshotPath := mockFinchPath.UserDataDiskSnapshotDir(mockFinchRootPath) + "/before-upgrade.img"
+	snapshotErr := errors.New("no space left on device")
+
+	testCases := []struct {
+		name         string
+		ignoreErrors bool
+		wantErr      error
+		mockSvc      func(*mocks.NerdctlCmdCreator, *mocks.UserDataDiskManager, *mocks.Logger, *gomock.Controller)
+	}{
+		{
+			name:    "should snapshot the user data disk, then stop the VM",
+			wantErr: nil,
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, dm *mocks.UserDataDiskManager, logger *mocks.Logger, ctrl *gomock.Controller) {
+				dm.EXPECT().SnapshotUserDataDisk(snapshotName).Return(snapshotPath, nil)
+				logger.EXPECT().Infof("Saved snapshot %q of the user data disk to %q", snapshotName, snapshotPath)
+				expectGracefulStop(ncc, dm, logger, ctrl)
+			},
+		},
+		{
+			name: "should not stop the VM if the snapshot fails",
+			wantErr: withCategory(stopErrorDisk, fmt.Errorf("failed to snapshot the user data disk, the VM wasn't stopped: %w",
+				snapshotErr)),
+			mockSvc: func(_ *mocks.NerdctlCmdCreator, dm *mocks.UserDataDiskManager, _ *mocks.Logger, _ *gomock.Controller) {
+				dm.EXPECT().SnapshotUserDataDisk(snapshotName).Return("", snapshotErr)
+			},
+		},
+		{
+			name:         "should stop the VM if the snapshot fails with --ignore-snapshot-errors",
+			ignoreErrors: true,
+			wantErr:      nil,
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, dm *mocks.UserDataDiskManager, logger *mocks.Logger, ctrl *gomock.Controller) {
+				dm.EXPECT().SnapshotUserDataDisk(snapshotName).Return("", snapshotErr)
+				logger.EXPECT().Warnf("Could not snapshot the user data disk, stopping the VM anyway: %v", snapshotErr)
+				expectGracefulStop(ncc, dm, logger, ctrl)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+
+			getVMStatusC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+			getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+			syncC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "sudo", "sync").Return(syncC)
+			syncC.EXPECT().CombinedOutput()
+			logger.EXPECT().StartProgress(fmt.Sprintf("Taking snapshot %q of the user data disk...", snapshotName)).Return(func() {})
+			tc.mockSvc(ncc, dm, logger, ctrl)
+
+			action := newStopVMAction(ncc, nil, dm, logger, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath,
+				nil, nil, nil)
+			err := action.run(stopVMOptions{preSnapshot: snapshotName, ignoreSnapshotErrors: tc.ignoreErrors})
+			assert.Equal(t, tc.wantErr, err)
+		})
+	}
+}
+
+func TestStopVMAction_runRejectsPreSnapshotWithForce(t *testing.T) {
+	t.Parallel()
+
+	action := newStopVMAction(nil, nil, nil, nil, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath,
+		nil, nil, nil)
+	err := action.run(stopVMOptions{force: true, preSnapshot: "before-upgrade"})
+	assert.EqualError(t, err, "--pre-snapshot cannot be used together with --force or --hibernate")
+}
+
+func TestStopVMAction_runRejectsPreSnapshotOnWindows(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS != "windows" {
+		t.Skip("the user data disk is snapshotted on macOS")
+	}
+
+	action := newStopVMAction(nil, nil, nil, nil, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath,
+		nil, nil, nil)
+	err := action.run(stopVMOptions{preSnapshot: "before-upgrade"})
+	assert.EqualError(t, err, "--pre-snapshot only applies to macOS")
+}
@@ -0,0 +1,85 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/runfinch/finch/pkg/fmemory"
+
+	"github.com/docker/go-units"
+)
+
+const defaultPressureMinAvailableMemory = 256 * units.MiB
+
+// needsGracefulStop reports whether the options need the VM to be stopped gracefully, i.e. can't be used with --force.
+func needsGracefulStop(opts stopVMOptions) bool {
+	return opts.hibernate || opts.tag != "" || opts.preserveContainersState || opts.recordSession ||
+		opts.preSnapshot != "" || opts.rotateDisk || opts.noWait
+}
+
+// hostPressure returns why the host is under memory pressure according to stop.autoForceOnPressure,
+// or an empty string if it isn't. The memory that can't be read isn't considered under pressure,
+// forcing the stop on a guess would risk losing the writes of the guest for nothing.
+//
+// The swap is only considered if stop.autoForceOnPressure.maxSwapUsage is set: macOS grows its swap as needed,
+// so that it's routinely almost full on a healthy host, and only the critical pressure the kernel reports tells.
+func (sva *stopVMAction) hostPressure() (string, error) {
+	settings := sva.fc.Stop.AutoForceOnPressure
+	minAvailable := int64(defaultPressureMinAvailableMemory)
+	if settings.MinAvailableMemory != "" {
+		size, err := units.RAMInBytes(settings.MinAvailableMemory)
+		if err != nil {
+			return "", fmt.Errorf("failed to parse stop.autoForceOnPressure.minAvailableMemory: %w", err)
+		}
+		minAvailable = size
+	}
+	maxSwapUsage := settings.MaxSwapUsage
+	if maxSwapUsage < 0 || maxSwapUsage > 100 {
+		return "", fmt.Errorf("stop.autoForceOnPressure.maxSwapUsage must be between 1 and 100, got %d", maxSwapUsage)
+	}
+
+	level, err := sva.mem.PressureLevel()
+	switch {
+	case err != nil:
+		sva.logger.Debugf("Could not read the memory pressure level of the host: %v", err)
+	case level == fmemory.PressureCritical:
+		return "the kernel reports a critical memory pressure", nil
+	}
+	available, err := sva.mem.AvailableMemory()
+	switch {
+	case err != nil:
+		sva.logger.Debugf("Could not read the available memory of the host: %v", err)
+	case available < uint64(minAvailable):
+		return fmt.Sprintf("%s of memory available, below %s", units.BytesSize(float64(available)),
+			units.BytesSize(float64(minAvailable))), nil
+	}
+	if maxSwapUsage == 0 {
+		return "", nil
+	}
+	used, total, err := sva.mem.SwapUsage()
+	switch {
+	case err != nil:
+		sva.logger.Debugf("Could not read the swap usage of the host: %v", err)
+	case total > 0 && used*100 > total*uint64(maxSwapUsage):
+		return fmt.Sprintf("%d%% of the swap used, above %d%%", used*100/total, maxSwapUsage), nil
+	}
+	return "", nil
+}
+
+// forceOnPressure reports whether the instance must be forcibly stopped because the host is under memory pressure.
+func (sva *stopVMAction) forceOnPressure(instance string, opts stopVMOptions) (bool, error) {
+	if opts.force || !sva.fc.Stop.AutoForceOnPressure.Enabled || needsGracefulStop(opts) {
+		return false, nil
+	}
+	pressure, err := sva.hostPressure()
+	if err != nil || pressure == "" {
+		return false, err
+	}
+	sva.logger.Warnf("The host is under memory pressure (%s), forcibly stopping the instance %q as it may not stop cleanly",
+		pressure, instance)
+	return true, nil
+}
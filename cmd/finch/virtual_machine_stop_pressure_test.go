@@ -0,0 +1,142 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/fmemory"
+	"github.com/runfinch/finch/pkg/mocks"
+
+	"github.com/docker/go-units"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestStopVMAction_runWithAutoForceOnPressure(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		settings config.AutoForceOnPressureSettings
+		mockMem  func(mem *mocks.Memory, logger *mocks.Logger)
+		wantWarn string
+		wantErr  error
+	}{
+		{
+			name:     "should force the stop when the kernel reports a critical memory pressure",
+			settings: config.AutoForceOnPressureSettings{Enabled: true},
+			mockMem: func(mem *mocks.Memory, _ *mocks.Logger) {
+				mem.EXPECT().PressureLevel().Return(fmemory.PressureCritical, nil)
+			},
+			wantWarn: "the kernel reports a critical memory pressure",
+		},
+		{
+			name:     "should force the stop when the host is short on memory",
+			settings: config.AutoForceOnPressureSettings{Enabled: true},
+			mockMem: func(mem *mocks.Memory, _ *mocks.Logger) {
+				mem.EXPECT().PressureLevel().Return(fmemory.PressureNormal, nil)
+				mem.EXPECT().AvailableMemory().Return(uint64(100*units.MiB), nil)
+			},
+			wantWarn: "100MiB of memory available, below 256MiB",
+		},
+		{
+			name:     "should force the stop when the host swaps above the configured usage",
+			settings: config.AutoForceOnPressureSettings{Enabled: true, MinAvailableMemory: "1GiB", MaxSwapUsage: 50},
+			mockMem: func(mem *mocks.Memory, _ *mocks.Logger) {
+				mem.EXPECT().PressureLevel().Return(fmemory.PressureNormal, nil)
+				mem.EXPECT().AvailableMemory().Return(uint64(2*units.GiB), nil)
+				mem.EXPECT().SwapUsage().Return(uint64(3*units.GiB), uint64(4*units.GiB), nil)
+			},
+			wantWarn: "75% of the swap used, above 50%",
+		},
+		{
+			name:     "should stop gracefully when the host isn't under pressure",
+			settings: config.AutoForceOnPressureSettings{Enabled: true},
+			mockMem: func(mem *mocks.Memory, _ *mocks.Logger) {
+				mem.EXPECT().PressureLevel().Return(fmemory.PressureNormal, nil)
+				mem.EXPECT().AvailableMemory().Return(uint64(8*units.GiB), nil)
+			},
+		},
+		{
+			name:     "should not consider the swap unless maxSwapUsage is set",
+			settings: config.AutoForceOnPressureSettings{Enabled: true},
+			mockMem: func(mem *mocks.Memory, _ *mocks.Logger) {
+				// The swap of macOS grows as needed, it's almost full while the kernel only warns of pressure.
+				mem.EXPECT().PressureLevel().Return(fmemory.PressureWarning, nil)
+				mem.EXPECT().AvailableMemory().Return(uint64(1*units.GiB), nil)
+			},
+		},
+		{
+			name:     "should stop gracefully when the memory of the host can't be read",
+			settings: config.AutoForceOnPressureSettings{Enabled: true},
+			mockMem: func(mem *mocks.Memory, logger *mocks.Logger) {
+				mem.EXPECT().PressureLevel().Return(fmemory.PressureNormal, errors.New("unknown oid"))
+				logger.EXPECT().Debugf("Could not read the memory pressure level of the host: %v", errors.New("unknown oid"))
+				mem.EXPECT().AvailableMemory().Return(uint64(0), errors.New("vm_stat: not found"))
+				logger.EXPECT().Debugf("Could not read the available memory of the host: %v", errors.New("vm_stat: not found"))
+			},
+		},
+		{
+			name:     "should not read the memory of the host if disabled",
+			settings: config.AutoForceOnPressureSettings{MinAvailableMemory: "1GiB"},
+			mockMem:  func(_ *mocks.Memory, _ *mocks.Logger) {},
+		},
+		{
+			name:     "should reject an invalid swap usage",
+			settings: config.AutoForceOnPressureSettings{Enabled: true, MaxSwapUsage: 150},
+			mockMem:  func(_ *mocks.Memory, _ *mocks.Logger) {},
+			wantErr:  errors.New("stop.autoForceOnPressure.maxSwapUsage must be between 1 and 100, got 150"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+			mem := mocks.NewMemory(ctrl)
+			fs := afero.NewMemMapFs()
+
+			tc.mockMem(mem, logger)
+			switch {
+			case tc.wantErr != nil:
+			case tc.wantWarn != "":
+				logger.EXPECT().Warnf("The host is under memory pressure (%s), forcibly stopping the instance %q as it may not "+
+					"stop cleanly", tc.wantWarn, limaInstanceName)
+				serialLogPath := filepath.Join(mockFinchPath.LimaInstancePath(), "serial.log")
+				require.NoError(t, afero.WriteFile(fs, serialLogPath, []byte("kernel log"), 0o600))
+				logger.EXPECT().Infof("Guest kernel log saved to %q", gomock.Any())
+				dm.EXPECT().DetachUserDataDisk().Return(nil)
+				logger.EXPECT().StartProgress("Forcibly stopping Finch virtual machine...").Return(func() {})
+				forceStopC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("stop", "--force", limaInstanceName).Return(forceStopC)
+				forceStopC.EXPECT().CombinedOutput()
+				logger.EXPECT().Info("Finch virtual machine stopped successfully")
+			default:
+				getVMStatusC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+				getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+				expectGracefulStop(ncc, dm, logger, ctrl)
+			}
+
+			fc := &config.Finch{}
+			fc.Stop.AutoForceOnPressure = tc.settings
+			action := newStopVMAction(ncc, nil, dm, logger, fs, mockFinchPath, fc, mockFinchRootPath, nil, nil, nil)
+			action.mem = mem
+			err := action.run(stopVMOptions{})
+			assert.Equal(t, tc.wantErr, err)
+		})
+	}
+}
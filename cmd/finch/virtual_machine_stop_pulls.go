@@ -0,0 +1,64 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// pullPollInterval is how often the pulls in progress are checked while waiting for them to finish.
+const pullPollInterval = 2 * time.Second
+
+// waitForPulls waits up to timeout for the image pulls in progress in the guest to finish, as stopping in the middle
+// of a pull leaves partially downloaded layers behind. Once it times out, the downloads still in progress are reported,
+// and the stop goes on anyway.
+func (sva *stopVMAction) waitForPulls(instance string, timeout time.Duration) {
+	pulls, err := sva.activePulls(instance)
+	if err != nil {
+		sva.logger.Warnf("Could not check for image pulls in progress: %v", err)
+		return
+	}
+	if len(pulls) == 0 {
+		return
+	}
+
+	sva.logger.Infof("Waiting up to %s for %d image downloads in progress to finish...", timeout, len(pulls))
+	deadline := time.Now().Add(timeout)
+	for len(pulls) > 0 {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			sva.logger.Warnf("Stopping with image downloads still in progress: %s", strings.Join(pulls, ", "))
+			return
+		}
+		time.Sleep(min(pullPollInterval, remaining))
+		if pulls, err = sva.activePulls(instance); err != nil {
+			sva.logger.Warnf("Could not check for image pulls in progress: %v", err)
+			return
+		}
+	}
+	sva.logger.Info("The image downloads in progress finished")
+}
+
+// activePulls returns the references of the content containerd is downloading in the guest,
+// e.g. layer-sha256:<digest> for a layer of an image being pulled.
+func (sva *stopVMAction) activePulls(instance string) ([]string, error) {
+	out, err := sva.creator.CreateWithoutStdio(
+		"shell", instance, "sudo", "ctr", "--namespace", "finch", "content", "active",
+	).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the active downloads: %w", err)
+	}
+	var refs []string
+	// The first line is the header of the table.
+	for i, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if fields := strings.Fields(line); i > 0 && len(fields) > 0 {
+			refs = append(refs, fields[0])
+		}
+	}
+	return refs, nil
+}
@@ -0,0 +1,116 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/mocks"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestStopVMAction_runWithPullTimeout(t *testing.T) {
+	t.Parallel()
+
+	activeArgs := []any{"shell", limaInstanceName, "sudo", "ctr", "--namespace", "finch", "content", "active"}
+	const (
+		header    = "REF                       SIZE    AGE\n"
+		layer     = "layer-sha256:4f4fb700ef54 1.2 MiB 3 seconds\n"
+		configRef = "config-sha256:8a3b6b5f1c2 3.1 KiB 1 second\n"
+	)
+
+	testCases := []struct {
+		name        string
+		pullTimeout time.Duration
+		mockSvc     func(*mocks.NerdctlCmdCreator, *mocks.Logger, *gomock.Controller)
+	}{
+		{
+			name:        "should stop right away if no image is being pulled",
+			pullTimeout: time.Minute,
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, _ *mocks.Logger, ctrl *gomock.Controller) {
+				activeC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(activeArgs...).Return(activeC)
+				activeC.EXPECT().Output().Return([]byte(header), nil)
+			},
+		},
+		{
+			name:        "should wait for the image pulls in progress to finish",
+			pullTimeout: 50 * time.Millisecond,
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, logger *mocks.Logger, ctrl *gomock.Controller) {
+				activeC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(activeArgs...).Return(activeC).Times(2)
+				gomock.InOrder(
+					activeC.EXPECT().Output().Return([]byte(header+layer), nil),
+					activeC.EXPECT().Output().Return([]byte(header), nil),
+				)
+				logger.EXPECT().Infof("Waiting up to %s for %d image downloads in progress to finish...", 50*time.Millisecond, 1)
+				logger.EXPECT().Info("The image downloads in progress finished")
+			},
+		},
+		{
+			name:        "should report the images still being pulled and stop anyway on timeout",
+			pullTimeout: 10 * time.Millisecond,
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, logger *mocks.Logger, ctrl *gomock.Controller) {
+				activeC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(activeArgs...).Return(activeC).Times(2)
+				activeC.EXPECT().Output().Return([]byte(header+layer+configRef), nil).Times(2)
+				logger.EXPECT().Infof("Waiting up to %s for %d image downloads in progress to finish...", 10*time.Millisecond, 2)
+				logger.EXPECT().Warnf("Stopping with image downloads still in progress: %s",
+					"layer-sha256:4f4fb700ef54, config-sha256:8a3b6b5f1c2")
+			},
+		},
+		{
+			name:        "should still stop if the image pulls in progress can't be checked",
+			pullTimeout: time.Minute,
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, logger *mocks.Logger, ctrl *gomock.Controller) {
+				activeC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(activeArgs...).Return(activeC)
+				activeC.EXPECT().Output().Return(nil, errors.New("exit status 1"))
+				logger.EXPECT().Warnf("Could not check for image pulls in progress: %v",
+					fmt.Errorf("failed to list the active downloads: %w", errors.New("exit status 1")))
+			},
+		},
+		{
+			name:        "should not check for image pulls without a timeout",
+			pullTimeout: 0,
+			mockSvc:     func(*mocks.NerdctlCmdCreator, *mocks.Logger, *gomock.Controller) {},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+
+			getVMStatusC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+			getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+			tc.mockSvc(ncc, logger, ctrl)
+			expectUnmountGuestDataVolume(ncc, ctrl)
+			dm.EXPECT().DetachUserDataDisk().Return(nil)
+			stopC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(stopC)
+			stopC.EXPECT().CombinedOutput()
+			logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+			logger.EXPECT().Info("Finch virtual machine stopped successfully")
+
+			action := newStopVMAction(ncc, nil, dm, logger, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath,
+				nil, nil, nil)
+			assert.NoError(t, action.run(stopVMOptions{pullTimeout: tc.pullTimeout}))
+		})
+	}
+}
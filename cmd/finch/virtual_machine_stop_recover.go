@@ -0,0 +1,96 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/runfinch/finch/pkg/lima"
+)
+
+// How finch vm stop --recover recovers an instance whose last stop was interrupted.
+const (
+	// recoverStop completes the stop.
+	recoverStop = "stop"
+	// recoverRestart stops the instance, then starts it again.
+	recoverRestart = "restart"
+)
+
+// trackStop records in the metadata of the instance that it's being stopped for as long as stop runs,
+// so that a stop interrupted half-way, which can leave the instance neither running nor stopped, is detected
+// by the next finch vm stop. It also counts the stops that failed in a row, for the next finch vm start to
+// recover from them in safe mode. The metadata is only kept for the instances created by Finch, as it marks them as such.
+func (sva *stopVMAction) trackStop(instance string, stop func() error) error {
+	if !sva.isFinchInstance(instance) {
+		return stop()
+	}
+	sva.setPendingOperation(instance, lima.OperationStop)
+	// A stop that failed, unlike an interrupted one, leaves the instance in the state limactl reported.
+	err := stop()
+	if updateErr := lima.UpdateInstanceMetadata(sva.fs, filepath.Join(sva.limaHomePath(), instance), func(md *lima.InstanceMetadata) {
+		md.PendingOperation = ""
+		if err != nil {
+			md.StopFailures++
+		} else {
+			md.StopFailures = 0
+		}
+	}); updateErr != nil {
+		sva.logger.Debugf("Could not record the outcome of the stop of the instance %q: %v", instance, updateErr)
+	}
+	return err
+}
+
+// setPendingOperation records the operation in progress on the instance, failing to do so only loses the chance
+// to recover from it being interrupted.
+func (sva *stopVMAction) setPendingOperation(instance, operation string) {
+	if err := lima.UpdateInstanceMetadata(sva.fs, filepath.Join(sva.limaHomePath(), instance), func(md *lima.InstanceMetadata) {
+		md.PendingOperation = operation
+	}); err != nil {
+		sva.logger.Debugf("Could not record the pending operation of the instance %q: %v", instance, err)
+	}
+}
+
+// stopWasInterrupted reports whether the last stop of the instance was interrupted before it completed.
+func (sva *stopVMAction) stopWasInterrupted(instance string) bool {
+	md, err := lima.LoadInstanceMetadata(sva.fs, filepath.Join(sva.limaHomePath(), instance))
+	return err == nil && md.PendingOperation == lima.OperationStop
+}
+
+// recoverInterruptedStop brings the instance whose last stop was interrupted back to a known state: it's forcibly
+// stopped, as whatever is left of it may not respond anymore, and with recoverRestart started again.
+func (sva *stopVMAction) recoverInterruptedStop(instance, recoverWith string) error {
+	sva.logger.Warnf("The last stop of the instance %q was interrupted, recovering from it...", instance)
+	status, err := lima.Status(sva.creator, instance)
+	if err != nil {
+		return fmt.Errorf("failed to get the status of the instance %q: %w", instance, err)
+	}
+	if status != lima.Stopped {
+		if err := sva.stopVM(instance, true); err != nil {
+			return fmt.Errorf("failed to complete the interrupted stop: %w", err)
+		}
+	}
+	sva.setPendingOperation(instance, "")
+	if recoverWith != recoverRestart {
+		sva.logger.Infof("The interrupted stop of the instance %q is complete", instance)
+		return nil
+	}
+
+	if instance == limaInstanceName {
+		if err := sva.diskManager.EnsureUserDataDisk(); err != nil {
+			return fmt.Errorf("failed to attach the user data disk: %w", err)
+		}
+	}
+	done := sva.logger.StartProgress("Restarting Finch virtual machine...")
+	logs, err := sva.creator.CreateWithoutStdio("start", instance).CombinedOutput()
+	done()
+	if err != nil {
+		sva.logger.Errorf("Finch virtual machine failed to restart, debug logs:\n%s", logs)
+		return fmt.Errorf("failed to restart the instance %q: %w", instance, err)
+	}
+	sva.logger.Infof("The instance %q was restarted", instance)
+	return nil
+}
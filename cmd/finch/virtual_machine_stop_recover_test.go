@@ -0,0 +1,201 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/lima"
+	"github.com/runfinch/finch/pkg/mocks"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestStopVMAction_runWithRecover(t *testing.T) {
+	t.Parallel()
+
+	expectStatus := func(ncc *mocks.NerdctlCmdCreator, ctrl *gomock.Controller, status string) {
+		statusC := mocks.NewCommand(ctrl)
+		ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(statusC)
+		statusC.EXPECT().Output().Return([]byte(status), nil)
+	}
+	expectForceStop := func(ncc *mocks.NerdctlCmdCreator, dm *mocks.UserDataDiskManager, logger *mocks.Logger, ctrl *gomock.Controller) {
+		dm.EXPECT().DetachUserDataDisk().Return(nil)
+		stopC := mocks.NewCommand(ctrl)
+		ncc.EXPECT().CreateWithoutStdio("stop", "--force", limaInstanceName).Return(stopC)
+		stopC.EXPECT().CombinedOutput()
+		logger.EXPECT().Infof("Guest kernel log saved to %q", gomock.Any())
+		logger.EXPECT().StartProgress("Forcibly stopping Finch virtual machine...").Return(func() {})
+		logger.EXPECT().Info("Finch virtual machine stopped successfully")
+	}
+
+	testCases := []struct {
+		name        string
+		recoverWith string
+		interrupted bool
+		wantErr     error
+		mockSvc     func(*mocks.NerdctlCmdCreator, *mocks.UserDataDiskManager, *mocks.Logger, *gomock.Controller)
+	}{
+		{
+			name:        "should complete an interrupted stop",
+			recoverWith: "stop",
+			interrupted: true,
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, dm *mocks.UserDataDiskManager, logger *mocks.Logger, ctrl *gomock.Controller) {
+				logger.EXPECT().Warnf("The last stop of the instance %q was interrupted, recovering from it...", limaInstanceName)
+				expectStatus(ncc, ctrl, "Broken")
+				expectForceStop(ncc, dm, logger, ctrl)
+				logger.EXPECT().Infof("The interrupted stop of the instance %q is complete", limaInstanceName)
+			},
+		},
+		{
+			name:        "should restart the instance after an interrupted stop",
+			recoverWith: "restart",
+			interrupted: true,
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, dm *mocks.UserDataDiskManager, logger *mocks.Logger, ctrl *gomock.Controller) {
+				logger.EXPECT().Warnf("The last stop of the instance %q was interrupted, recovering from it...", limaInstanceName)
+				expectStatus(ncc, ctrl, "Stopped")
+				dm.EXPECT().EnsureUserDataDisk().Return(nil)
+				startC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("start", limaInstanceName).Return(startC)
+				startC.EXPECT().CombinedOutput()
+				logger.EXPECT().StartProgress("Restarting Finch virtual machine...").Return(func() {})
+				logger.EXPECT().Infof("The instance %q was restarted", limaInstanceName)
+			},
+		},
+		{
+			name:        "should report a failed restart",
+			recoverWith: "restart",
+			interrupted: true,
+			wantErr:     fmt.Errorf("failed to restart the instance %q: %w", limaInstanceName, errors.New("exit status 1")),
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, dm *mocks.UserDataDiskManager, logger *mocks.Logger, ctrl *gomock.Controller) {
+				logger.EXPECT().Warnf("The last stop of the instance %q was interrupted, recovering from it...", limaInstanceName)
+				expectStatus(ncc, ctrl, "Stopped")
+				dm.EXPECT().EnsureUserDataDisk().Return(nil)
+				startC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("start", limaInstanceName).Return(startC)
+				startC.EXPECT().CombinedOutput().Return([]byte("failed to start"), errors.New("exit status 1"))
+				logger.EXPECT().StartProgress("Restarting Finch virtual machine...").Return(func() {})
+				logger.EXPECT().Errorf("Finch virtual machine failed to restart, debug logs:\n%s", []byte("failed to start"))
+			},
+		},
+		{
+			name:        "should stop as usual if the last stop wasn't interrupted",
+			recoverWith: "stop",
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, dm *mocks.UserDataDiskManager, logger *mocks.Logger, ctrl *gomock.Controller) {
+				expectStatus(ncc, ctrl, "Running")
+				expectUnmountGuestDataVolume(ncc, ctrl)
+				dm.EXPECT().DetachUserDataDisk().Return(nil)
+				stopC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(stopC)
+				stopC.EXPECT().CombinedOutput()
+				logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+				logger.EXPECT().Info("Finch virtual machine stopped successfully")
+			},
+		},
+		{
+			name:        "should point to --recover if the last stop was interrupted",
+			interrupted: true,
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, dm *mocks.UserDataDiskManager, logger *mocks.Logger, ctrl *gomock.Controller) {
+				logger.EXPECT().Warnf(
+					"The last stop of the instance %q was interrupted, use --recover=%s or --recover=%s to recover from it",
+					limaInstanceName, "stop", "restart")
+				expectStatus(ncc, ctrl, "Running")
+				expectUnmountGuestDataVolume(ncc, ctrl)
+				dm.EXPECT().DetachUserDataDisk().Return(nil)
+				stopC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(stopC)
+				stopC.EXPECT().CombinedOutput()
+				logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+				logger.EXPECT().Info("Finch virtual machine stopped successfully")
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+			fs := afero.NewMemMapFs()
+			serialLogPath := filepath.Join(mockFinchPath.LimaInstancePath(), "serial.log")
+			require.NoError(t, afero.WriteFile(fs, serialLogPath, []byte("kernel log"), 0o600))
+			if tc.interrupted {
+				require.NoError(t, lima.SaveInstanceMetadata(fs, mockFinchPath.LimaInstancePath(),
+					&lima.InstanceMetadata{PendingOperation: lima.OperationStop}))
+			}
+			tc.mockSvc(ncc, dm, logger, ctrl)
+
+			action := newStopVMAction(ncc, nil, dm, logger, fs, mockFinchPath, &config.Finch{}, mockFinchRootPath, nil, nil, nil)
+			err := action.run(stopVMOptions{recoverWith: tc.recoverWith})
+			assert.Equal(t, tc.wantErr, err)
+
+			// However the stop went, it's no longer pending.
+			md, err := lima.LoadInstanceMetadata(fs, mockFinchPath.LimaInstancePath())
+			require.NoError(t, err)
+			assert.Empty(t, md.PendingOperation)
+		})
+	}
+}
+
+func TestStopVMAction_runWithUnsupportedRecover(t *testing.T) {
+	t.Parallel()
+
+	action := newStopVMAction(nil, nil, nil, nil, nil, mockFinchPath, &config.Finch{}, mockFinchRootPath, nil, nil, nil)
+	err := action.run(stopVMOptions{recoverWith: "reset"})
+	assert.EqualError(t, err, `unsupported recovery "reset", it must be either "stop" or "restart"`)
+}
+
+func TestStopVMAction_runCountsStopFailures(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	dm := mocks.NewUserDataDiskManager(ctrl)
+	logger := mocks.NewLogger(ctrl)
+	ncc := mocks.NewNerdctlCmdCreator(ctrl)
+	fs := afero.NewMemMapFs()
+	action := newStopVMAction(ncc, nil, dm, logger, fs, mockFinchPath, &config.Finch{}, mockFinchRootPath, nil, nil, nil)
+
+	stopErr := errors.New("exit status 1")
+	for i, tc := range []struct {
+		err          error
+		wantFailures int
+	}{
+		{err: stopErr, wantFailures: 1},
+		{err: stopErr, wantFailures: 2},
+		{err: nil, wantFailures: 0},
+	} {
+		getVMStatusC := mocks.NewCommand(ctrl)
+		ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+		getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+		expectUnmountGuestDataVolume(ncc, ctrl)
+		dm.EXPECT().DetachUserDataDisk().Return(nil)
+		stopC := mocks.NewCommand(ctrl)
+		ncc.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(stopC)
+		stopC.EXPECT().CombinedOutput().Return(nil, tc.err)
+		logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+		if tc.err == nil {
+			logger.EXPECT().Info("Finch virtual machine stopped successfully")
+		} else {
+			logger.EXPECT().Errorf("Finch virtual machine failed to stop, debug logs:\n%s", []byte(nil))
+			expectReattachUserDataDisk(ncc, dm, logger, ctrl)
+		}
+
+		assert.Equal(t, withCategory(stopErrorLimactl, tc.err), action.run(stopVMOptions{}), "stop %d", i)
+		md, err := lima.LoadInstanceMetadata(fs, mockFinchPath.LimaInstancePath())
+		require.NoError(t, err)
+		assert.Equal(t, tc.wantFailures, md.StopFailures, "stop %d", i)
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/runfinch/finch/pkg/lima"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// stopReportTimeout bounds how long a stop can be held up by a slow webhook.
+	stopReportTimeout = 5 * time.Second
+	// stopReportSignatureHeader holds the hex encoded HMAC-SHA256 of the report body, prefixed with "sha256=".
+	stopReportSignatureHeader = "X-Finch-Signature"
+)
+
+// stopReport is the result of a stop that's posted to the webhook set with --report-to.
+// It's also the summary printed with --summary.
+type stopReport struct {
+	Instance        string  `json:"instance" yaml:"instance"`
+	Result          string  `json:"result" yaml:"result"`
+	DurationSeconds float64 `json:"durationSeconds" yaml:"durationSeconds"`
+	Forced          bool    `json:"forced" yaml:"forced"`
+	Error           string  `json:"error,omitempty" yaml:"error,omitempty"`
+	ErrorCategory   string  `json:"errorCategory,omitempty" yaml:"errorCategory,omitempty"`
+}
+
+// newStopReport makes the report of a stop, pending being set for a stop that goes on in the background.
+func newStopReport(instance string, forced bool, duration time.Duration, pending bool, err error) stopReport {
+	report := stopReport{
+		Instance:        instance,
+		Result:          "success",
+		DurationSeconds: duration.Seconds(),
+		Forced:          forced,
+	}
+	if pending {
+		report.Result = "pending"
+	}
+	if err != nil {
+		report.Result = "failure"
+		report.Error = err.Error()
+		report.ErrorCategory = string(classifyStopError(err))
+	}
+	return report
+}
+
+// reportStop posts the report to url. Reporting is best-effort: failures are logged and never fail the stop.
+func (sva *stopVMAction) reportStop(url string, report stopReport) {
+	if err := postStopReport(url, sva.fc.Stop.ReportSecret, report); err != nil {
+		sva.logger.Warnf("Could not report the result of the stop to %q: %v", url, err)
+		return
+	}
+	sva.logger.Debugf("Reported the result of the stop to %q", url)
+}
+
+// The formats of the summary printed with --summary.
+const (
+	summaryFormatJSON = "json"
+	summaryFormatYAML = "yaml"
+)
+
+// printSummary writes the report to the structured output as a JSON line, or as a YAML document with
+// --output-format yaml, so that the summaries of several instances make a YAML stream.
+// Like the webhook, it's informational and failing to write it doesn't fail the stop.
+func (sva *stopVMAction) printSummary(report stopReport) {
+	if err := writeSummary(sva.output, sva.outputFormat, report); err != nil {
+		sva.logger.Warnf("Could not print the summary of the stop: %v", err)
+	}
+}
+
+func writeSummary(w io.Writer, format string, report stopReport) error {
+	if format != summaryFormatYAML {
+		return json.NewEncoder(w).Encode(report)
+	}
+	b, err := yaml.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("failed to marshal the summary: %w", err)
+	}
+	_, err = w.Write(append([]byte("---\n"), b...))
+	return err
+}
+
+// stopSummaryFile is the file in --output-dir the summaries are appended to, as JSON lines whatever --output-format is.
+const stopSummaryFile = "stop-summary.jsonl"
+
+// saveSummary appends the report to the summaries in --output-dir, failing to do so doesn't fail the stop.
+func (sva *stopVMAction) saveSummary(report stopReport) {
+	if err := sva.appendSummary(report); err != nil {
+		sva.logger.Warnf("Could not save the summary of the stop to %q: %v", sva.outputDir, err)
+	}
+}
+
+func (sva *stopVMAction) appendSummary(report stopReport) error {
+	b, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("failed to marshal the summary: %w", err)
+	}
+	if err := sva.fs.MkdirAll(sva.outputDir, 0o700); err != nil {
+		return err
+	}
+	f, err := sva.fs.OpenFile(sva.artifactPath(stopSummaryFile), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// The values of stopResult, for --json.
+const (
+	stopStatusWasRunning     = "was_running"
+	stopStatusAlreadyStopped = "already_stopped"
+	stopStatusNonexistent    = "nonexistent"
+	stopStatusBroken         = "broken"
+	stopStatusStarting       = "starting"
+	stopStatusSuspended      = "suspended"
+	stopStatusUnknown        = "unknown"
+	stopResultStopped        = "stopped"
+	stopResultStopping       = "stopping"
+	stopResultNoop           = "noop"
+	stopResultFailed         = "failed"
+)
+
+// stopResult tells whether the instance needed stopping and what came of it, it's printed with --json.
+type stopResult struct {
+	Status        string `json:"status"`
+	Result        string `json:"result"`
+	Error         string `json:"error,omitempty"`
+	ErrorCategory string `json:"errorCategory,omitempty"`
+}
+
+// newStopResult makes the result of a stop of an instance that wasn't found stopped beforehand,
+// status being the one the stop found the instance in, and pending being set if the stop goes on in the background.
+func newStopResult(status lima.VMStatus, stopped, pending bool, err error) stopResult {
+	switch {
+	case err != nil:
+		return stopResult{
+			Status:        stopStatusOf(status),
+			Result:        stopResultFailed,
+			Error:         err.Error(),
+			ErrorCategory: string(classifyStopError(err)),
+		}
+	case pending:
+		return stopResult{Status: stopStatusOf(status), Result: stopResultStopping}
+	case stopped:
+		return stopResult{Status: stopStatusOf(status), Result: stopResultStopped}
+	default:
+		// The stop was declined, e.g. when asked for confirmation.
+		return stopResult{Status: stopStatusOf(status), Result: stopResultNoop}
+	}
+}
+
+// stopStatusOf is the value of stopResult.Status for the status of the instance.
+// A forced stop doesn't check the status of the instance beforehand, so it's unknown.
+func stopStatusOf(status lima.VMStatus) string {
+	switch status {
+	case lima.Running:
+		return stopStatusWasRunning
+	case lima.Stopped:
+		return stopStatusAlreadyStopped
+	case lima.Nonexistent:
+		return stopStatusNonexistent
+	case lima.Broken:
+		return stopStatusBroken
+	case lima.Starting:
+		return stopStatusStarting
+	case lima.Suspended:
+		return stopStatusSuspended
+	default:
+		return stopStatusUnknown
+	}
+}
+
+// printStopResult writes the result to the structured output as a JSON line.
+func (sva *stopVMAction) printStopResult(result stopResult) {
+	if err := json.NewEncoder(sva.output).Encode(result); err != nil {
+		sva.logger.Warnf("Could not print the result of the stop: %v", err)
+	}
+}
+
+func postStopReport(url, secret string, report stopReport) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("failed to marshal the report: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), stopReportTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		req.Header.Set(stopReportSignatureHeader, signStopReport(secret, body))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() //nolint:errcheck // nothing is read from the body
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+	return nil
+}
+
+func signStopReport(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
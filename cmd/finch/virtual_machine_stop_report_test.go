@@ -0,0 +1,390 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/lima"
+	"github.com/runfinch/finch/pkg/mocks"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+	"gopkg.in/yaml.v3"
+)
+
+func TestStopVMAction_runWithReportTo(t *testing.T) {
+	t.Parallel()
+
+	const secret = "s3cr3t"
+
+	testCases := []struct {
+		name       string
+		wantErr    error
+		wantReport stopReport
+		status     int
+		mockSvc    func(logger *mocks.Logger, stopC *mocks.Command)
+	}{
+		{
+			name:       "should report a successful stop",
+			wantErr:    nil,
+			wantReport: stopReport{Instance: limaInstanceName, Result: "success"},
+			status:     http.StatusNoContent,
+			mockSvc: func(logger *mocks.Logger, stopC *mocks.Command) {
+				stopC.EXPECT().CombinedOutput()
+				logger.EXPECT().Info("Finch virtual machine stopped successfully")
+				logger.EXPECT().Debugf("Reported the result of the stop to %q", gomock.Any())
+			},
+		},
+		{
+			name:       "should report a failed stop",
+			wantErr:    withCategory(stopErrorLimactl, errors.New("exit status 1")),
+			wantReport: stopReport{Instance: limaInstanceName, Result: "failure", Error: "exit status 1", ErrorCategory: "limactl"},
+			status:     http.StatusOK,
+			mockSvc: func(logger *mocks.Logger, stopC *mocks.Command) {
+				stopC.EXPECT().CombinedOutput().Return([]byte("error"), errors.New("exit status 1"))
+				logger.EXPECT().Errorf("Finch virtual machine failed to stop, debug logs:\n%s", []byte("error"))
+				logger.EXPECT().Debugf("Reported the result of the stop to %q", gomock.Any())
+			},
+		},
+		{
+			name:       "should only warn if the webhook fails",
+			wantErr:    nil,
+			wantReport: stopReport{Instance: limaInstanceName, Result: "success"},
+			status:     http.StatusInternalServerError,
+			mockSvc: func(logger *mocks.Logger, stopC *mocks.Command) {
+				stopC.EXPECT().CombinedOutput()
+				logger.EXPECT().Info("Finch virtual machine stopped successfully")
+				logger.EXPECT().Warnf("Could not report the result of the stop to %q: %v", gomock.Any(),
+					errors.New("unexpected response status: 500 Internal Server Error"))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			reports := make(chan stopReport, 1)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, err := io.ReadAll(r.Body)
+				assert.NoError(t, err)
+				mac := hmac.New(sha256.New, []byte(secret))
+				mac.Write(body)
+				assert.Equal(t, "sha256="+hex.EncodeToString(mac.Sum(nil)), r.Header.Get("X-Finch-Signature"))
+
+				var report stopReport
+				assert.NoError(t, json.Unmarshal(body, &report))
+				reports <- report
+				w.WriteHeader(tc.status)
+			}))
+			defer server.Close()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+
+			getVMStatusC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+			getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+			expectUnmountGuestDataVolume(ncc, ctrl)
+			dm.EXPECT().DetachUserDataDisk().Return(nil)
+			stopC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(stopC)
+			logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+			tc.mockSvc(logger, stopC)
+			if tc.wantErr != nil {
+				expectReattachUserDataDisk(ncc, dm, logger, ctrl)
+			}
+
+			fc := &config.Finch{}
+			fc.Stop.ReportSecret = secret
+			action := newStopVMAction(ncc, nil, dm, logger, afero.NewMemMapFs(), mockFinchPath, fc, mockFinchRootPath, nil, nil, nil)
+			err := action.run(stopVMOptions{reportTo: server.URL})
+			assert.Equal(t, tc.wantErr, err)
+
+			report := <-reports
+			report.DurationSeconds = 0
+			require.Equal(t, tc.wantReport, report)
+		})
+	}
+}
+
+func TestStopVMAction_runWithSummary(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	dm := mocks.NewUserDataDiskManager(ctrl)
+	logger := mocks.NewLogger(ctrl)
+	ncc := mocks.NewNerdctlCmdCreator(ctrl)
+
+	getVMStatusC := mocks.NewCommand(ctrl)
+	ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+	getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+	expectUnmountGuestDataVolume(ncc, ctrl)
+	dm.EXPECT().DetachUserDataDisk().Return(nil)
+	stopC := mocks.NewCommand(ctrl)
+	ncc.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(stopC)
+	stopC.EXPECT().CombinedOutput()
+	logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+	logger.EXPECT().Info("Finch virtual machine stopped successfully")
+
+	stdout := &bytes.Buffer{}
+	output := &bytes.Buffer{}
+	action := newStopVMAction(ncc, nil, dm, logger, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath,
+		nil, stdout, output)
+	require.NoError(t, action.run(stopVMOptions{summary: true}))
+
+	var report stopReport
+	require.NoError(t, json.Unmarshal(output.Bytes(), &report))
+	report.DurationSeconds = 0
+	assert.Equal(t, stopReport{Instance: limaInstanceName, Result: "success"}, report)
+	assert.Empty(t, stdout.String())
+}
+
+func TestStopVMAction_runWithSummaryAsYAML(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	dm := mocks.NewUserDataDiskManager(ctrl)
+	logger := mocks.NewLogger(ctrl)
+	ncc := mocks.NewNerdctlCmdCreator(ctrl)
+
+	getVMStatusC := mocks.NewCommand(ctrl)
+	ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+	getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+	expectUnmountGuestDataVolume(ncc, ctrl)
+	dm.EXPECT().DetachUserDataDisk().Return(nil)
+	stopC := mocks.NewCommand(ctrl)
+	ncc.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(stopC)
+	stopC.EXPECT().CombinedOutput()
+	logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+	logger.EXPECT().Info("Finch virtual machine stopped successfully")
+
+	output := &bytes.Buffer{}
+	action := newStopVMAction(ncc, nil, dm, logger, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath,
+		nil, nil, output)
+	require.NoError(t, action.run(stopVMOptions{summary: true, outputFormat: summaryFormatYAML}))
+
+	require.True(t, strings.HasPrefix(output.String(), "---\n"))
+	var report stopReport
+	require.NoError(t, yaml.Unmarshal(output.Bytes(), &report))
+	report.DurationSeconds = 0
+	assert.Equal(t, stopReport{Instance: limaInstanceName, Result: "success"}, report)
+}
+
+func TestStopVMAction_runRejectsOutputFormat(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name    string
+		opts    stopVMOptions
+		wantErr string
+	}{
+		{
+			name:    "should reject an unsupported format",
+			opts:    stopVMOptions{summary: true, outputFormat: "xml"},
+			wantErr: `unsupported output format "xml", it must be either "json" or "yaml"`,
+		},
+		{
+			name:    "should reject yaml without --summary",
+			opts:    stopVMOptions{outputFormat: summaryFormatYAML},
+			wantErr: "--output-format only applies to --summary",
+		},
+		{
+			name:    "should reject json without --summary",
+			opts:    stopVMOptions{outputFormat: summaryFormatJSON},
+			wantErr: "--output-format only applies to --summary",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			action := newStopVMAction(nil, nil, nil, nil, nil, mockFinchPath, &config.Finch{}, mockFinchRootPath, nil, nil, nil)
+			assert.EqualError(t, action.run(tc.opts), tc.wantErr)
+		})
+	}
+}
+
+func TestStopVMAction_runAdapterRejectsExplicitOutputFormat(t *testing.T) {
+	t.Parallel()
+
+	action := newStopVMAction(nil, nil, nil, nil, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath,
+		nil, nil, nil)
+	action.lookupEnv = func(string) (string, bool) { return "", false }
+	cmd := newStopVMCommand(nil, nil, nil, nil, nil, mockFinchPath, &config.Finch{}, mockFinchRootPath, nil, nil, nil)
+	// The default format is json, setting it explicitly without --summary is still a mistake.
+	require.NoError(t, cmd.Flags().Set("output-format", summaryFormatJSON))
+	assert.EqualError(t, action.runAdapter(cmd, nil), "--output-format only applies to --summary")
+}
+
+func TestStopVMAction_runWithJSON(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name       string
+		wantErr    error
+		wantResult stopResult
+		mockSvc    func(*mocks.NerdctlCmdCreator, *mocks.UserDataDiskManager, *mocks.Logger, *gomock.Controller)
+	}{
+		{
+			name:       "should report that the running VM was stopped",
+			wantResult: stopResult{Status: "was_running", Result: "stopped"},
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, dm *mocks.UserDataDiskManager, logger *mocks.Logger, ctrl *gomock.Controller) {
+				getVMStatusC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC).Times(2)
+				getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil).Times(2)
+				expectUnmountGuestDataVolume(ncc, ctrl)
+				dm.EXPECT().DetachUserDataDisk().Return(nil)
+				stopC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(stopC)
+				stopC.EXPECT().CombinedOutput()
+				logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+				logger.EXPECT().Info("Finch virtual machine stopped successfully")
+			},
+		},
+		{
+			name:       "should succeed without doing anything if the VM is already stopped",
+			wantResult: stopResult{Status: "already_stopped", Result: "noop"},
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, _ *mocks.UserDataDiskManager, _ *mocks.Logger, ctrl *gomock.Controller) {
+				getVMStatusC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+				getVMStatusC.EXPECT().Output().Return([]byte("Stopped"), nil)
+			},
+		},
+		{
+			name:       "should report that the VM failed to stop",
+			wantErr:    withCategory(stopErrorLimactl, errors.New("exit status 1")),
+			wantResult: stopResult{Status: "was_running", Result: "failed", Error: "exit status 1", ErrorCategory: "limactl"},
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, dm *mocks.UserDataDiskManager, logger *mocks.Logger, ctrl *gomock.Controller) {
+				getVMStatusC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC).Times(2)
+				getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil).Times(2)
+				expectUnmountGuestDataVolume(ncc, ctrl)
+				dm.EXPECT().DetachUserDataDisk().Return(nil)
+				stopC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(stopC)
+				stopC.EXPECT().CombinedOutput().Return(nil, errors.New("exit status 1"))
+				logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+				logger.EXPECT().Errorf("Finch virtual machine failed to stop, debug logs:\n%s", []byte(nil))
+				expectReattachUserDataDisk(ncc, dm, logger, ctrl)
+			},
+		},
+		{
+			name:    "should report the status the VM was found in when it can't be stopped",
+			wantErr: withStatus(lima.Broken, fmt.Errorf("the instance %q is broken, use --force to stop it", limaInstanceName)),
+			wantResult: stopResult{
+				Status:        "broken",
+				Result:        "failed",
+				Error:         fmt.Sprintf("the instance %q is broken, use --force to stop it", limaInstanceName),
+				ErrorCategory: "unknown",
+			},
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, _ *mocks.UserDataDiskManager, _ *mocks.Logger, ctrl *gomock.Controller) {
+				getVMStatusC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC).Times(2)
+				getVMStatusC.EXPECT().Output().Return([]byte("Broken"), nil).Times(2)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+			tc.mockSvc(ncc, dm, logger, ctrl)
+
+			output := &bytes.Buffer{}
+			action := newStopVMAction(ncc, nil, dm, logger, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath,
+				nil, nil, output)
+			err := action.run(stopVMOptions{json: true})
+			assert.Equal(t, tc.wantErr, err)
+
+			var result stopResult
+			require.NoError(t, json.Unmarshal(output.Bytes(), &result))
+			assert.Equal(t, tc.wantResult, result)
+		})
+	}
+}
+
+func TestStopVMAction_runWithJSONAndSummary(t *testing.T) {
+	t.Parallel()
+
+	action := newStopVMAction(nil, nil, nil, nil, nil, mockFinchPath, &config.Finch{}, mockFinchRootPath, nil, nil, nil)
+	err := action.run(stopVMOptions{json: true, summary: true})
+	assert.EqualError(t, err, "--json and --summary cannot be used together")
+}
+
+func TestStopVMAction_runWithOutputDir(t *testing.T) {
+	t.Parallel()
+
+	const outputDir = "/artifacts"
+
+	ctrl := gomock.NewController(t)
+	dm := mocks.NewUserDataDiskManager(ctrl)
+	logger := mocks.NewLogger(ctrl)
+	ncc := mocks.NewNerdctlCmdCreator(ctrl)
+	fs := afero.NewMemMapFs()
+
+	getVMStatusC := mocks.NewCommand(ctrl)
+	ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+	getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+	expectUnmountGuestDataVolume(ncc, ctrl)
+	dm.EXPECT().DetachUserDataDisk().Return(nil)
+	stopC := mocks.NewCommand(ctrl)
+	ncc.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(stopC)
+	stopC.EXPECT().CombinedOutput()
+	logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+	logger.EXPECT().Info("Finch virtual machine stopped successfully")
+
+	output := &bytes.Buffer{}
+	action := newStopVMAction(ncc, nil, dm, logger, fs, mockFinchPath, &config.Finch{}, mockFinchRootPath, nil, nil, output)
+	err := action.run(stopVMOptions{outputDir: outputDir, collectMetricsTo: "metrics.jsonl", summary: true})
+	require.NoError(t, err)
+
+	// The summary is still printed, and saved alongside the other artifacts.
+	summary, err := afero.ReadFile(fs, filepath.Join(outputDir, "stop-summary.jsonl"))
+	require.NoError(t, err)
+	assert.Equal(t, output.String(), string(summary))
+	exists, err := afero.Exists(fs, filepath.Join(outputDir, "metrics.jsonl"))
+	require.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestStopVMAction_artifactPath(t *testing.T) {
+	t.Parallel()
+
+	action := newStopVMAction(nil, nil, nil, nil, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath,
+		nil, nil, nil)
+	// A relative path doesn't depend on the directory finch is run from.
+	assert.Equal(t, filepath.Join(mockFinchPath.FinchDir(mockFinchRootPath), "metrics.jsonl"), action.artifactPath("metrics.jsonl"))
+
+	action.outputDir = "/artifacts"
+	assert.Equal(t, filepath.Join("/artifacts", "metrics.jsonl"), action.artifactPath("metrics.jsonl"))
+	abs := filepath.Join(t.TempDir(), "stop.jsonl")
+	assert.Equal(t, abs, action.artifactPath(abs))
+}
@@ -0,0 +1,19 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import "fmt"
+
+// rotateUserDataDisk archives the user data disk of the stopped Finch VM, for --rotate-disk, so that the next start
+// begins from an empty disk while the old one can still be inspected or restored by hand.
+func (sva *stopVMAction) rotateUserDataDisk() error {
+	archivePath, err := sva.diskManager.RotateUserDataDisk(sva.fp.UserDataDiskArchiveDir(sva.finchRootPath))
+	if err != nil {
+		return withCategory(stopErrorDisk, fmt.Errorf("failed to rotate the user data disk: %w", err))
+	}
+	sva.logger.Infof("Archived the user data disk to %q, the next start begins with an empty one", archivePath)
+	return nil
+}
@@ -0,0 +1,107 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/mocks"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestStopVMAction_runWithRotateDisk(t *testing.T) {
+	t.Parallel()
+
+	archiveDir := mockFinchPath.UserDataDiskArchiveDir(mockFinchRootPath)
+	archivePath := archiveDir + "/disk-20260102T030405Z"
+
+	testCases := []struct {
+		name    string
+		wantErr error
+		mockSvc func(*mocks.UserDataDiskManager, *mocks.Logger)
+	}{
+		{
+			name:    "should archive the user data disk once the VM is stopped",
+			wantErr: nil,
+			mockSvc: func(dm *mocks.UserDataDiskManager, logger *mocks.Logger) {
+				dm.EXPECT().RotateUserDataDisk(archiveDir).Return(archivePath, nil)
+				logger.EXPECT().Infof("Archived the user data disk to %q, the next start begins with an empty one", archivePath)
+			},
+		},
+		{
+			name: "should fail if the user data disk can't be rotated",
+			wantErr: withCategory(stopErrorDisk, fmt.Errorf("failed to rotate the user data disk: %w",
+				errors.New("permission denied"))),
+			mockSvc: func(dm *mocks.UserDataDiskManager, _ *mocks.Logger) {
+				dm.EXPECT().RotateUserDataDisk(archiveDir).Return("", errors.New("permission denied"))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+
+			getVMStatusC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+			getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+			expectUnmountGuestDataVolume(ncc, ctrl)
+			dm.EXPECT().DetachUserDataDisk().Return(nil)
+			stopC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(stopC)
+			stopC.EXPECT().CombinedOutput()
+			logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+			logger.EXPECT().Info("Finch virtual machine stopped successfully")
+			tc.mockSvc(dm, logger)
+
+			action := newStopVMAction(ncc, nil, dm, logger, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath,
+				nil, nil, nil)
+			assert.Equal(t, tc.wantErr, action.run(stopVMOptions{rotateDisk: true, yes: true}))
+		})
+	}
+}
+
+func TestStopVMAction_runRejectsRotateDisk(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name    string
+		opts    stopVMOptions
+		wantErr string
+	}{
+		{
+			name:    "without --yes",
+			opts:    stopVMOptions{rotateDisk: true},
+			wantErr: "--rotate-disk archives all the images and containers of the VM, pass --yes to confirm",
+		},
+		{
+			name:    "with --force",
+			opts:    stopVMOptions{rotateDisk: true, yes: true, force: true},
+			wantErr: "--rotate-disk cannot be used together with --force, --hibernate, --tag or --wait=false",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			action := newStopVMAction(nil, nil, nil, nil, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath,
+				nil, nil, nil)
+			assert.EqualError(t, action.run(tc.opts), tc.wantErr)
+		})
+	}
+}
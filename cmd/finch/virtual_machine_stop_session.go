@@ -0,0 +1,113 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/runfinch/finch/pkg/lima"
+)
+
+// containerSpec is the part of the output of nerdctl inspect that --record-session records of a container.
+type containerSpec struct {
+	Name   string `json:"Name"`
+	Image  string `json:"Image"`
+	Config struct {
+		Image      string   `json:"Image"`
+		Entrypoint []string `json:"Entrypoint"`
+		Cmd        []string `json:"Cmd"`
+	} `json:"Config"`
+	NetworkSettings struct {
+		Ports map[string][]struct {
+			HostIP   string `json:"HostIp"`
+			HostPort string `json:"HostPort"`
+		} `json:"Ports"`
+	} `json:"NetworkSettings"`
+}
+
+// sessionContainer returns what is needed to recreate the container, with its published ports
+// in the format of nerdctl run --publish, e.g. "127.0.0.1:8080:80/tcp".
+func (spec containerSpec) sessionContainer() lima.SessionContainer {
+	c := lima.SessionContainer{
+		Name:       strings.TrimPrefix(spec.Name, "/"),
+		Image:      spec.Config.Image,
+		Entrypoint: spec.Config.Entrypoint,
+		Cmd:        spec.Config.Cmd,
+	}
+	if c.Image == "" {
+		c.Image = spec.Image
+	}
+	for port, bindings := range spec.NetworkSettings.Ports {
+		for _, b := range bindings {
+			if b.HostPort == "" {
+				continue
+			}
+			if b.HostIP == "" {
+				c.Ports = append(c.Ports, fmt.Sprintf("%s:%s", b.HostPort, port))
+			} else {
+				c.Ports = append(c.Ports, fmt.Sprintf("%s:%s:%s", b.HostIP, b.HostPort, port))
+			}
+		}
+	}
+	slices.Sort(c.Ports)
+	return c
+}
+
+// recordSession records the specs of the containers running in the guest in the instance metadata, for
+// finch vm start --restore-session to restart them. Unlike a checkpoint, it doesn't stop the containers,
+// nor does it save their state. Failing to record them doesn't prevent the stop.
+func (sva *stopVMAction) recordSession(instance string) {
+	if !sva.isFinchInstance(instance) {
+		sva.logger.Warnf("Not recording the containers of the instance %q, which wasn't created by Finch", instance)
+		return
+	}
+	session, err := sva.sessionContainers(instance)
+	if err != nil {
+		sva.logger.Warnf("Could not record the running containers, they won't be recreated on the next start: %v", err)
+		return
+	}
+	if err := lima.UpdateInstanceMetadata(sva.fs, filepath.Join(sva.limaHomePath(), instance), func(md *lima.InstanceMetadata) {
+		md.Session = session
+	}); err != nil {
+		sva.logger.Warnf("Could not record the running containers, they won't be recreated on the next start: %v", err)
+		return
+	}
+	sva.logger.Infof("Recorded %d running containers, finch vm start --restore-session restarts them", len(session))
+}
+
+// sessionContainers returns the specs of the containers running in the guest.
+func (sva *stopVMAction) sessionContainers(instance string) ([]lima.SessionContainer, error) {
+	out, err := sva.creator.CreateWithoutStdio(sva.guestNerdctlArgs(instance, "ps", "-q")...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the containers: %w", err)
+	}
+	ids := strings.Fields(string(out))
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	args := append(sva.guestNerdctlArgs(instance, "inspect", "--format", "{{json .}}"), ids...)
+	out, err = sva.creator.CreateWithoutStdio(args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect the containers: %w", err)
+	}
+	var session []lima.SessionContainer
+	for _, line := range bytes.Split(bytes.TrimSpace(out), []byte("\n")) {
+		var spec containerSpec
+		if err := json.Unmarshal(line, &spec); err != nil {
+			return nil, fmt.Errorf("failed to parse the description of a container: %w", err)
+		}
+		c := spec.sessionContainer()
+		c.Namespace = sva.drainNamespace
+		session = append(session, c)
+	}
+	return session, nil
+}
@@ -0,0 +1,140 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/lima"
+	"github.com/runfinch/finch/pkg/mocks"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestStopVMAction_runWithRecordSession(t *testing.T) {
+	t.Parallel()
+
+	psArgs := []any{"shell", limaInstanceName, "sudo", "-E", "nerdctl", "ps", "-q"}
+	inspectArgs := []any{"shell", limaInstanceName, "sudo", "-E", "nerdctl", "inspect", "--format", "{{json .}}", "c1", "c2"}
+	const inspectOutput = `{"Name":"web","Image":"sha256:abc","Config":{"Image":"nginx:latest","Entrypoint":["/docker-entrypoint.sh"],` +
+		`"Cmd":["nginx","-g","daemon off;"]},"NetworkSettings":{"Ports":{"80/tcp":[{"HostIp":"127.0.0.1","HostPort":"8080"}],` +
+		`"443/tcp":[{"HostIp":"","HostPort":"8443"}],"9000/tcp":null}}}
+{"Name":"/worker","Image":"busybox:latest","Config":{"Cmd":["sleep","infinity"]},"NetworkSettings":{}}
+`
+
+	testCases := []struct {
+		name        string
+		namespace   string
+		mockSvc     func(*mocks.NerdctlCmdCreator, *mocks.Logger, *gomock.Controller)
+		wantSession []lima.SessionContainer
+	}{
+		{
+			name: "should record the running containers before the VM is stopped",
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, logger *mocks.Logger, ctrl *gomock.Controller) {
+				psC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(psArgs...).Return(psC)
+				psC.EXPECT().Output().Return([]byte("c1\nc2\n"), nil)
+				inspectC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(inspectArgs...).Return(inspectC)
+				inspectC.EXPECT().Output().Return([]byte(inspectOutput), nil)
+				logger.EXPECT().Infof("Recorded %d running containers, finch vm start --restore-session restarts them", 2)
+			},
+			wantSession: []lima.SessionContainer{
+				{
+					Name:       "web",
+					Image:      "nginx:latest",
+					Entrypoint: []string{"/docker-entrypoint.sh"},
+					Cmd:        []string{"nginx", "-g", "daemon off;"},
+					Ports:      []string{"127.0.0.1:8080:80/tcp", "8443:443/tcp"},
+				},
+				{
+					Name:  "worker",
+					Image: "busybox:latest",
+					Cmd:   []string{"sleep", "infinity"},
+				},
+			},
+		},
+		{
+			name:      "should record the namespace the containers were listed in",
+			namespace: "jobs",
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, logger *mocks.Logger, ctrl *gomock.Controller) {
+				psC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "sudo", "-E", "nerdctl", "--namespace", "jobs", "ps", "-q").
+					Return(psC)
+				psC.EXPECT().Output().Return([]byte("c2\n"), nil)
+				inspectC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "sudo", "-E", "nerdctl", "--namespace", "jobs", "inspect",
+					"--format", "{{json .}}", "c2").Return(inspectC)
+				inspectC.EXPECT().Output().Return([]byte(`{"Name":"worker","Image":"busybox:latest","Config":{}}`), nil)
+				logger.EXPECT().Infof("Recorded %d running containers, finch vm start --restore-session restarts them", 1)
+			},
+			wantSession: []lima.SessionContainer{{Namespace: "jobs", Name: "worker", Image: "busybox:latest"}},
+		},
+		{
+			name: "should stop the VM even if the containers can't be inspected",
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, logger *mocks.Logger, ctrl *gomock.Controller) {
+				psC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(psArgs...).Return(psC)
+				psC.EXPECT().Output().Return([]byte("c1\nc2\n"), nil)
+				inspectC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(inspectArgs...).Return(inspectC)
+				inspectC.EXPECT().Output().Return(nil, errors.New("exit status 1"))
+				logger.EXPECT().Warnf("Could not record the running containers, they won't be recreated on the next start: %v",
+					fmt.Errorf("failed to inspect the containers: %w", errors.New("exit status 1")))
+			},
+		},
+		{
+			name: "should record that no container was running",
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, logger *mocks.Logger, ctrl *gomock.Controller) {
+				psC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(psArgs...).Return(psC)
+				psC.EXPECT().Output().Return([]byte(""), nil)
+				logger.EXPECT().Infof("Recorded %d running containers, finch vm start --restore-session restarts them", 0)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+
+			getVMStatusC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+			getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+			tc.mockSvc(ncc, logger, ctrl)
+			expectGracefulStop(ncc, dm, logger, ctrl)
+
+			fs := afero.NewMemMapFs()
+			action := newStopVMAction(ncc, nil, dm, logger, fs, mockFinchPath, &config.Finch{}, mockFinchRootPath, nil, nil, nil)
+			require.NoError(t, action.run(stopVMOptions{recordSession: true, drainNamespace: tc.namespace}))
+
+			md, err := lima.LoadInstanceMetadata(fs, filepath.Join(mockFinchPath.LimaHomePath(), limaInstanceName))
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantSession, md.Session)
+		})
+	}
+}
+
+func TestStopVMAction_runRejectsRecordSessionWithForce(t *testing.T) {
+	t.Parallel()
+
+	action := newStopVMAction(nil, nil, nil, nil, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath,
+		nil, nil, nil)
+	err := action.run(stopVMOptions{force: true, recordSession: true})
+	assert.EqualError(t, err, "--record-session cannot be used together with --force or --hibernate")
+}
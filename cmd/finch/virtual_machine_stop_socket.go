@@ -0,0 +1,87 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/afero"
+)
+
+// hostSocketProxyLog is the file in the instance directory the output of the proxy started with --keep-alive-socket
+// goes to.
+const hostSocketProxyLog = "socket-proxy.log"
+
+// serveSocketCmd is the hidden subcommand of finch vm running the proxy of the host socket.
+const serveSocketCmd = "serve-socket"
+
+// hostSocketPath returns the path of the host socket Lima forwards the Docker-compatible API of the instance to,
+// as set with the hostSocket of finch.yaml.d/mac.yaml.
+func (sva *stopVMAction) hostSocketPath(instance string) string {
+	return filepath.Join(sva.limaHomePath(), instance, "sock", "finch.sock")
+}
+
+// removeHostSocket removes the host socket of the stopped instance, which Lima can leave behind, e.g. after a forced
+// stop, and which Docker-compatible clients would hang on until the next start replaces it. If the socket path is
+// a symlink, the socket it points to is removed along with it. The symlinks pointing to the socket are kept,
+// the next start makes them work again. Failing to remove the socket doesn't undo the stop.
+func (sva *stopVMAction) removeHostSocket(instance string) {
+	socketPath := sva.hostSocketPath(instance)
+	paths := []string{socketPath}
+	if lr, ok := sva.fs.(afero.LinkReader); ok {
+		if target, err := lr.ReadlinkIfPossible(socketPath); err == nil {
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(filepath.Dir(socketPath), target)
+			}
+			paths = append(paths, target)
+		}
+	}
+	for _, path := range paths {
+		err := sva.fs.Remove(path)
+		switch {
+		case err == nil:
+			sva.logger.Debugf("Removed the host socket %q", path)
+		case !errors.Is(err, fs.ErrNotExist):
+			sva.logger.Warnf("Could not remove the host socket %q: %v", path, err)
+		}
+	}
+}
+
+// keepHostSocketAlive starts finch vm serve-socket in the background on the host socket of the stopped instance,
+// for --keep-alive-socket. It holds the connections made while the VM is stopped, hands them over to the socket
+// forwarded by the next start and exits, so it goes on after finch exits, independently of the VM.
+// Failing to start it doesn't undo the stop, the host socket is then removed as it would be without the flag.
+func (sva *stopVMAction) keepHostSocketAlive(instance string) {
+	socketPath := sva.hostSocketPath(instance)
+	if err := sva.startHostSocketProxy(instance, socketPath); err != nil {
+		sva.logger.Warnf("Could not keep the host socket %q alive: %v", socketPath, err)
+		sva.removeHostSocket(instance)
+		return
+	}
+	sva.logger.Infof("Keeping the host socket %q alive until the instance is started again", socketPath)
+}
+
+func (sva *stopVMAction) startHostSocketProxy(instance, socketPath string) error {
+	finch, err := sva.executable()
+	if err != nil {
+		return err
+	}
+	logPath := filepath.Join(sva.limaHomePath(), instance, hostSocketProxyLog)
+	f, err := sva.fs.OpenFile(logPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	// The proxy gets its own handle on the file, which outlives this one.
+	defer f.Close() //nolint:errcheck // nothing was written through this handle
+	cmd := sva.ecc.Create(finch, virtualMachineRootCmd, serveSocketCmd, socketPath)
+	cmd.Detach()
+	cmd.SetStdout(f)
+	cmd.SetStderr(f)
+	return cmd.Start()
+}
@@ -0,0 +1,198 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/mocks"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestStopVMAction_runRemovesHostSocket(t *testing.T) {
+	t.Parallel()
+
+	socketPath := filepath.Join(mockFinchPath.LimaInstancePath(), "sock", "finch.sock")
+
+	testCases := []struct {
+		name       string
+		keepSocket bool
+		wantSocket bool
+	}{
+		{
+			name: "should remove the host socket once the instance is stopped",
+		},
+		{
+			name:       "should keep the host socket with --keep-socket",
+			keepSocket: true,
+			wantSocket: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+			fs := afero.NewMemMapFs()
+			require.NoError(t, afero.WriteFile(fs, socketPath, nil, 0o600))
+
+			getVMStatusC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+			getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+			expectGracefulStop(ncc, dm, logger, ctrl)
+			if !tc.wantSocket {
+				logger.EXPECT().Debugf("Removed the host socket %q", socketPath)
+			}
+
+			action := newStopVMAction(ncc, nil, dm, logger, fs, mockFinchPath, &config.Finch{}, mockFinchRootPath, nil, nil, nil)
+			err := action.run(stopVMOptions{keepSocket: tc.keepSocket})
+			require.NoError(t, err)
+
+			exists, err := afero.Exists(fs, socketPath)
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantSocket, exists)
+		})
+	}
+}
+
+func TestStopVMAction_runKeepsHostSocketIfTheStopFails(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	logger := mocks.NewLogger(ctrl)
+	ncc := mocks.NewNerdctlCmdCreator(ctrl)
+	fs := afero.NewMemMapFs()
+	socketPath := filepath.Join(mockFinchPath.LimaInstancePath(), "sock", "finch.sock")
+	require.NoError(t, afero.WriteFile(fs, socketPath, nil, 0o600))
+
+	getVMStatusC := mocks.NewCommand(ctrl)
+	ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+	getVMStatusC.EXPECT().Output().Return([]byte("Stopped"), nil)
+
+	action := newStopVMAction(ncc, nil, nil, logger, fs, mockFinchPath, &config.Finch{}, mockFinchRootPath, nil, nil, nil)
+	assert.Error(t, action.run(stopVMOptions{}))
+
+	exists, err := afero.Exists(fs, socketPath)
+	require.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestStopVMAction_runKeepsHostSocketAlive(t *testing.T) {
+	t.Parallel()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("--keep-alive-socket only applies to macOS")
+	}
+	socketPath := filepath.Join(mockFinchPath.LimaInstancePath(), "sock", "finch.sock")
+	logPath := filepath.Join(mockFinchPath.LimaInstancePath(), hostSocketProxyLog)
+	const finchPath = "/opt/finch/bin/finch"
+
+	testCases := []struct {
+		name       string
+		startErr   error
+		wantSocket bool
+	}{
+		{
+			name:       "should start the proxy of the host socket once the instance is stopped",
+			wantSocket: true,
+		},
+		{
+			name:     "should remove the host socket if the proxy fails to start",
+			startErr: errors.New("start error"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+			ecc := mocks.NewCommandCreator(ctrl)
+			fs := afero.NewMemMapFs()
+			require.NoError(t, afero.WriteFile(fs, socketPath, nil, 0o600))
+
+			getVMStatusC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+			getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+			expectGracefulStop(ncc, dm, logger, ctrl)
+			proxyC := mocks.NewCommand(ctrl)
+			ecc.EXPECT().Create(finchPath, "vm", "serve-socket", socketPath).Return(proxyC)
+			proxyC.EXPECT().Detach()
+			proxyC.EXPECT().SetStdout(gomock.Any())
+			proxyC.EXPECT().SetStderr(gomock.Any())
+			proxyC.EXPECT().Start().Return(tc.startErr)
+			if tc.startErr == nil {
+				logger.EXPECT().Infof("Keeping the host socket %q alive until the instance is started again", socketPath)
+			} else {
+				logger.EXPECT().Warnf("Could not keep the host socket %q alive: %v", socketPath, tc.startErr)
+				logger.EXPECT().Debugf("Removed the host socket %q", socketPath)
+			}
+
+			action := newStopVMAction(ncc, ecc, dm, logger, fs, mockFinchPath, &config.Finch{}, mockFinchRootPath, nil, nil, nil)
+			action.executable = func() (string, error) { return finchPath, nil }
+			err := action.run(stopVMOptions{keepAliveSocket: true})
+			require.NoError(t, err)
+
+			exists, err := afero.Exists(fs, socketPath)
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantSocket, exists)
+			exists, err = afero.Exists(fs, logPath)
+			require.NoError(t, err)
+			assert.True(t, exists, "the output of the proxy should go to its log")
+		})
+	}
+}
+
+func TestStopVMAction_runRejectsKeepAliveSocket(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name string
+		opts stopVMOptions
+	}{
+		{
+			name: "with --keep-socket",
+			opts: stopVMOptions{keepAliveSocket: true, keepSocket: true},
+		},
+		{
+			name: "with --wait=false",
+			opts: stopVMOptions{keepAliveSocket: true, noWait: true},
+		},
+		{
+			name: "with --uninstall",
+			opts: stopVMOptions{keepAliveSocket: true, uninstall: true, yes: true},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			action := newStopVMAction(nil, nil, nil, nil, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath,
+				nil, nil, nil)
+			wantErr := "--keep-alive-socket cannot be used together with --keep-socket, --wait=false or --uninstall"
+			if runtime.GOOS == "windows" {
+				wantErr = "--keep-alive-socket only applies to macOS"
+			}
+			assert.EqualError(t, action.run(tc.opts), wantErr)
+		})
+	}
+}
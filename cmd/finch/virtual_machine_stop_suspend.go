@@ -0,0 +1,82 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/lima"
+)
+
+// isSuspended reports whether the stop was refused with err because the VM state of the instance is saved.
+func isSuspended(err error) bool {
+	var se *statusError
+	return errors.As(err, &se) && se.Status == lima.Suspended
+}
+
+// isHibernated reports whether the instance was hibernated by Finch, which Lima reports as stopped
+// even though its VM state is saved to be restored on the next start.
+func (sva *stopVMAction) isHibernated(instance string) bool {
+	if !sva.isFinchInstance(instance) {
+		return false
+	}
+	md, err := lima.LoadInstanceMetadata(sva.fs, filepath.Join(sva.limaHomePath(), instance))
+	return err == nil && md.Hibernated
+}
+
+// leaveSuspend gets the suspended instance out of its saved VM state the way stop.fromSuspend asks for: either it's
+// resumed, to be stopped cleanly like a running instance, or it's forcibly stopped, which discards its saved VM state.
+// It reports whether the instance got stopped already.
+func (sva *stopVMAction) leaveSuspend(instance string) (bool, error) {
+	switch sva.fc.Stop.FromSuspend {
+	case "", config.StopFromSuspendResume:
+		return false, sva.resumeSuspendedVM(instance)
+	case config.StopFromSuspendDiscard:
+		sva.logger.Warnf("Discarding the saved state of the suspended instance %q, what was running in it is lost", instance)
+		err := sva.trackStop(instance, func() error { return sva.stopVM(instance, true) })
+		if err == nil {
+			sva.clearHibernated(instance)
+		}
+		return true, err
+	default:
+		return false, fmt.Errorf("unsupported stop.fromSuspend %q, it must be either %q or %q",
+			sva.fc.Stop.FromSuspend, config.StopFromSuspendResume, config.StopFromSuspendDiscard)
+	}
+}
+
+// resumeSuspendedVM starts the suspended instance, which restores its saved VM state, with its user data disk
+// attached back like finch vm start does, since it was detached when the instance was suspended.
+func (sva *stopVMAction) resumeSuspendedVM(instance string) error {
+	sva.logger.Infof("Resuming the suspended instance %q to stop it cleanly...", instance)
+	if instance == limaInstanceName && !sva.fc.Disk.Ephemeral {
+		if err := sva.diskManager.EnsureUserDataDisk(); err != nil {
+			return withCategory(stopErrorDisk, fmt.Errorf("failed to attach the user data disk to resume the instance: %w", err))
+		}
+	}
+	if logs, err := sva.creator.CreateWithoutStdio("start", instance).CombinedOutput(); err != nil {
+		sva.logger.Errorf("The suspended instance %q failed to resume, debug logs:\n%s", instance, logs)
+		return withCategory(stopErrorLimactl, fmt.Errorf("failed to resume the suspended instance %q, set stop.fromSuspend "+
+			"to %q to stop it without resuming it: %w", instance, config.StopFromSuspendDiscard, err))
+	}
+	sva.clearHibernated(instance)
+	return nil
+}
+
+// clearHibernated records that the VM state of the instance is no longer saved, for the next start not to
+// report it as resumed. Failing to do so only affects what that start logs.
+func (sva *stopVMAction) clearHibernated(instance string) {
+	if !sva.isHibernated(instance) {
+		return
+	}
+	if err := lima.UpdateInstanceMetadata(sva.fs, filepath.Join(sva.limaHomePath(), instance), func(md *lima.InstanceMetadata) {
+		md.Hibernated = false
+	}); err != nil {
+		sva.logger.Warnf("Could not clear the hibernation marker of the instance %q: %v", instance, err)
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/lima"
+	"github.com/runfinch/finch/pkg/mocks"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestStopVMAction_runSuspended(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name        string
+		status      string
+		hibernated  bool
+		fromSuspend config.StopFromSuspend
+		mockSvc     func(
+			t *testing.T,
+			logger *mocks.Logger,
+			creator *mocks.NerdctlCmdCreator,
+			dm *mocks.UserDataDiskManager,
+			fs afero.Fs,
+			ctrl *gomock.Controller,
+		)
+		wantErr        error
+		wantHibernated bool
+	}{
+		{
+			name:   "should resume a suspended instance to stop it cleanly",
+			status: "Suspended",
+			mockSvc: func(
+				_ *testing.T,
+				logger *mocks.Logger,
+				creator *mocks.NerdctlCmdCreator,
+				dm *mocks.UserDataDiskManager,
+				_ afero.Fs,
+				ctrl *gomock.Controller,
+			) {
+				logger.EXPECT().Infof("Resuming the suspended instance %q to stop it cleanly...", limaInstanceName)
+				dm.EXPECT().EnsureUserDataDisk().Return(nil)
+				startC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("start", limaInstanceName).Return(startC)
+				startC.EXPECT().CombinedOutput()
+				expectGracefulStop(creator, dm, logger, ctrl)
+			},
+		},
+		{
+			name:       "should resume a hibernated instance and clear its hibernation marker",
+			status:     "Stopped",
+			hibernated: true,
+			mockSvc: func(
+				_ *testing.T,
+				logger *mocks.Logger,
+				creator *mocks.NerdctlCmdCreator,
+				dm *mocks.UserDataDiskManager,
+				_ afero.Fs,
+				ctrl *gomock.Controller,
+			) {
+				logger.EXPECT().Infof("Resuming the suspended instance %q to stop it cleanly...", limaInstanceName)
+				dm.EXPECT().EnsureUserDataDisk().Return(nil)
+				startC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("start", limaInstanceName).Return(startC)
+				startC.EXPECT().CombinedOutput()
+				expectGracefulStop(creator, dm, logger, ctrl)
+			},
+		},
+		{
+			name:        "should forcibly stop a hibernated instance if its saved state is to be discarded",
+			status:      "Stopped",
+			hibernated:  true,
+			fromSuspend: config.StopFromSuspendDiscard,
+			mockSvc: func(
+				t *testing.T,
+				logger *mocks.Logger,
+				creator *mocks.NerdctlCmdCreator,
+				dm *mocks.UserDataDiskManager,
+				fs afero.Fs,
+				ctrl *gomock.Controller,
+			) {
+				logger.EXPECT().Warnf("Discarding the saved state of the suspended instance %q, what was running in it is lost",
+					limaInstanceName)
+				serialLogPath := filepath.Join(mockFinchPath.LimaInstancePath(), "serial.log")
+				require.NoError(t, afero.WriteFile(fs, serialLogPath, []byte("kernel log"), 0o600))
+				logger.EXPECT().Infof("Guest kernel log saved to %q", gomock.Any())
+				dm.EXPECT().DetachUserDataDisk().Return(nil)
+				logger.EXPECT().StartProgress("Forcibly stopping Finch virtual machine...").Return(func() {})
+				forceStopC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("stop", "--force", limaInstanceName).Return(forceStopC)
+				forceStopC.EXPECT().CombinedOutput()
+				logger.EXPECT().Info("Finch virtual machine stopped successfully")
+			},
+		},
+		{
+			name:       "should not stop the instance if it fails to resume",
+			status:     "Stopped",
+			hibernated: true,
+			mockSvc: func(
+				_ *testing.T,
+				logger *mocks.Logger,
+				creator *mocks.NerdctlCmdCreator,
+				dm *mocks.UserDataDiskManager,
+				_ afero.Fs,
+				ctrl *gomock.Controller,
+			) {
+				logger.EXPECT().Infof("Resuming the suspended instance %q to stop it cleanly...", limaInstanceName)
+				dm.EXPECT().EnsureUserDataDisk().Return(nil)
+				startC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("start", limaInstanceName).Return(startC)
+				startC.EXPECT().CombinedOutput().Return([]byte("restore failed"), errors.New("exit status 1"))
+				logger.EXPECT().Errorf("The suspended instance %q failed to resume, debug logs:\n%s", limaInstanceName,
+					[]byte("restore failed"))
+			},
+			wantErr: withCategory(stopErrorLimactl, fmt.Errorf("failed to resume the suspended instance %q, set "+
+				"stop.fromSuspend to %q to stop it without resuming it: %w", limaInstanceName, "discard",
+				errors.New("exit status 1"))),
+			wantHibernated: true,
+		},
+		{
+			name:        "should reject an unsupported stop.fromSuspend",
+			status:      "Suspended",
+			fromSuspend: "ignore",
+			mockSvc: func(
+				_ *testing.T,
+				_ *mocks.Logger,
+				_ *mocks.NerdctlCmdCreator,
+				_ *mocks.UserDataDiskManager,
+				_ afero.Fs,
+				_ *gomock.Controller,
+			) {
+			},
+			wantErr: fmt.Errorf("unsupported stop.fromSuspend %q, it must be either %q or %q", "ignore", "resume", "discard"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+			fs := afero.NewMemMapFs()
+
+			if tc.hibernated {
+				require.NoError(t, lima.SaveInstanceMetadata(fs, mockFinchPath.LimaInstancePath(),
+					&lima.InstanceMetadata{Hibernated: true}))
+			}
+			getVMStatusC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+			getVMStatusC.EXPECT().Output().Return([]byte(tc.status), nil)
+			tc.mockSvc(t, logger, ncc, dm, fs, ctrl)
+
+			fc := &config.Finch{}
+			fc.Stop.FromSuspend = tc.fromSuspend
+			action := newStopVMAction(ncc, nil, dm, logger, fs, mockFinchPath, fc, mockFinchRootPath, nil, nil, nil)
+			err := action.run(stopVMOptions{})
+			assert.Equal(t, tc.wantErr, err)
+
+			if tc.hibernated {
+				md, err := lima.LoadInstanceMetadata(fs, mockFinchPath.LimaInstancePath())
+				require.NoError(t, err)
+				assert.Equal(t, tc.wantHibernated, md.Hibernated)
+			}
+		})
+	}
+}
@@ -6,20 +6,92 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/runfinch/finch/pkg/command"
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/lima"
 	"github.com/runfinch/finch/pkg/mocks"
+	"github.com/runfinch/finch/pkg/path"
 
+	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/mock/gomock"
 )
 
+const (
+	mockFinchPath     = path.Finch("/finch")
+	mockFinchRootPath = "/home"
+)
+
+// expectUnmountGuestDataVolume expects the data volume of the guest to be unmounted once,
+// as a graceful stop does before detaching the user data disk.
+func expectUnmountGuestDataVolume(creator *mocks.NerdctlCmdCreator, ctrl *gomock.Controller) {
+	unmountC := mocks.NewCommand(ctrl)
+	creator.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "sudo", "sh", "-c",
+		"systemctl stop buildkit.service; systemctl stop containerd.service; sync; "+
+			"if mountpoint -q /mnt/lima-finch; then umount --all-targets --recursive /mnt/lima-finch; fi").Return(unmountC)
+	unmountC.EXPECT().CombinedOutput()
+}
+
+// expectGracefulStop expects the steps of a graceful stop of the running Finch VM.
+func expectGracefulStop(creator *mocks.NerdctlCmdCreator, dm *mocks.UserDataDiskManager, logger *mocks.Logger, ctrl *gomock.Controller) {
+	expectUnmountGuestDataVolume(creator, ctrl)
+	dm.EXPECT().DetachUserDataDisk().Return(nil)
+	stopC := mocks.NewCommand(ctrl)
+	creator.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(stopC)
+	stopC.EXPECT().CombinedOutput()
+	logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+	logger.EXPECT().Info("Finch virtual machine stopped successfully")
+}
+
+// expectReattachUserDataDisk expects the user data disk to be attached back after a stop that failed once it was detached,
+// which only happens on Windows as Lima detaches the disk on macOS.
+func expectReattachUserDataDisk(
+	ncc *mocks.NerdctlCmdCreator,
+	dm *mocks.UserDataDiskManager,
+	logger *mocks.Logger,
+	ctrl *gomock.Controller,
+) {
+	if runtime.GOOS != "windows" {
+		return
+	}
+	expectAttachUserDataDiskBack(ncc, dm, logger, ctrl)
+}
+
+// expectAttachUserDataDiskBack expects the user data disk to be attached back to the instance, which is still running.
+func expectAttachUserDataDiskBack(
+	ncc *mocks.NerdctlCmdCreator,
+	dm *mocks.UserDataDiskManager,
+	logger *mocks.Logger,
+	ctrl *gomock.Controller,
+) {
+	statusC := mocks.NewCommand(ctrl)
+	ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(statusC)
+	statusC.EXPECT().Output().Return([]byte("Running"), nil)
+	logger.EXPECT().Warnln("The stop failed after the user data disk was detached, attaching it back...")
+	dm.EXPECT().AttachUserDataDisk().Return(nil)
+	logger.EXPECT().Infoln("Attached the user data disk back")
+}
+
 func TestNewStopVMCommand(t *testing.T) {
 	t.Parallel()
 
-	cmd := newStopVMCommand(nil, nil, nil)
+	cmd := newStopVMCommand(nil, nil, nil, nil, nil, "", nil, "", nil, nil, nil)
 	assert.Equal(t, cmd.Name(), "stop")
 }
 
@@ -28,25 +100,51 @@ func TestStopVMAction_runAdapter(t *testing.T) {
 
 	testCases := []struct {
 		name    string
-		mockSvc func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, ctrl *gomock.Controller, dm *mocks.UserDataDiskManager)
-		args    []string
-		wantErr error
+		mockSvc func(
+			logger *mocks.Logger,
+			creator *mocks.NerdctlCmdCreator,
+			ctrl *gomock.Controller,
+			dm *mocks.UserDataDiskManager,
+			fs afero.Fs,
+		)
+		args         []string
+		defaultForce bool
+		wantErr      error
 	}{
 		{
 			name: "should stop the instance",
 			args: []string{},
-			mockSvc: func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, ctrl *gomock.Controller, dm *mocks.UserDataDiskManager) {
+			mockSvc: func(
+				logger *mocks.Logger,
+				creator *mocks.NerdctlCmdCreator,
+				ctrl *gomock.Controller,
+				dm *mocks.UserDataDiskManager,
+				_ afero.Fs,
+			) {
 				getVMStatusC := mocks.NewCommand(ctrl)
 				creator.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
 				getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
-				logger.EXPECT().Debugf("Status of virtual machine: %s", "Running")
 
+				pullsC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "sudo", "ctr", "--namespace", "finch", "content", "active").
+					Return(pullsC)
+				pullsC.EXPECT().Output().Return([]byte("REF SIZE AGE\n"), nil)
+
+				meminfoC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "cat", "/proc/meminfo").Return(meminfoC)
+				meminfoC.EXPECT().Output().Return([]byte("Dirty: 0 kB\nWriteback: 0 kB\n"), nil)
+				syncC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "sudo", "sync").Return(syncC)
+				syncC.EXPECT().CombinedOutput()
+
+				expectUnmountGuestDataVolume(creator, ctrl)
 				dm.EXPECT().DetachUserDataDisk().Return(nil)
 
 				command := mocks.NewCommand(ctrl)
 				creator.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(command)
 				command.EXPECT().CombinedOutput()
 				logger.EXPECT().Info(gomock.Any()).AnyTimes()
+				logger.EXPECT().StartProgress(gomock.Any()).Return(func() {}).AnyTimes()
 			},
 			wantErr: nil,
 		},
@@ -55,12 +153,85 @@ func TestStopVMAction_runAdapter(t *testing.T) {
 			args: []string{
 				"--force",
 			},
-			mockSvc: func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, ctrl *gomock.Controller, dm *mocks.UserDataDiskManager) {
+			mockSvc: func(
+				logger *mocks.Logger,
+				creator *mocks.NerdctlCmdCreator,
+				ctrl *gomock.Controller,
+				dm *mocks.UserDataDiskManager,
+				fs afero.Fs,
+			) {
+				serialLogPath := filepath.Join(mockFinchPath.LimaInstancePath(), "serial.log")
+				require.NoError(t, afero.WriteFile(fs, serialLogPath, []byte("kernel log"), 0o600))
+				logger.EXPECT().Infof("Guest kernel log saved to %q", gomock.Any())
+
+				command := mocks.NewCommand(ctrl)
+				dm.EXPECT().DetachUserDataDisk().Return(nil)
+				creator.EXPECT().CreateWithoutStdio("stop", "--force", limaInstanceName).Return(command)
+				command.EXPECT().CombinedOutput()
+				logger.EXPECT().Info(gomock.Any()).AnyTimes()
+				logger.EXPECT().StartProgress(gomock.Any()).Return(func() {}).AnyTimes()
+			},
+			wantErr: nil,
+		},
+		{
+			name:         "should force stop the instance if it's the configured default",
+			args:         []string{},
+			defaultForce: true,
+			mockSvc: func(
+				logger *mocks.Logger,
+				creator *mocks.NerdctlCmdCreator,
+				ctrl *gomock.Controller,
+				dm *mocks.UserDataDiskManager,
+				fs afero.Fs,
+			) {
+				serialLogPath := filepath.Join(mockFinchPath.LimaInstancePath(), "serial.log")
+				require.NoError(t, afero.WriteFile(fs, serialLogPath, []byte("kernel log"), 0o600))
+				logger.EXPECT().Infof("Guest kernel log saved to %q", gomock.Any())
+
 				command := mocks.NewCommand(ctrl)
 				dm.EXPECT().DetachUserDataDisk().Return(nil)
 				creator.EXPECT().CreateWithoutStdio("stop", "--force", limaInstanceName).Return(command)
 				command.EXPECT().CombinedOutput()
 				logger.EXPECT().Info(gomock.Any()).AnyTimes()
+				logger.EXPECT().StartProgress(gomock.Any()).Return(func() {}).AnyTimes()
+			},
+			wantErr: nil,
+		},
+		{
+			name:         "should not force stop the instance with --force=false even if it's the configured default",
+			args:         []string{"--force=false"},
+			defaultForce: true,
+			mockSvc: func(
+				logger *mocks.Logger,
+				creator *mocks.NerdctlCmdCreator,
+				ctrl *gomock.Controller,
+				dm *mocks.UserDataDiskManager,
+				_ afero.Fs,
+			) {
+				getVMStatusC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+				getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+
+				pullsC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "sudo", "ctr", "--namespace", "finch", "content", "active").
+					Return(pullsC)
+				pullsC.EXPECT().Output().Return([]byte("REF SIZE AGE\n"), nil)
+
+				meminfoC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "cat", "/proc/meminfo").Return(meminfoC)
+				meminfoC.EXPECT().Output().Return([]byte("Dirty: 0 kB\nWriteback: 0 kB\n"), nil)
+				syncC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "sudo", "sync").Return(syncC)
+				syncC.EXPECT().CombinedOutput()
+
+				expectUnmountGuestDataVolume(creator, ctrl)
+				dm.EXPECT().DetachUserDataDisk().Return(nil)
+
+				command := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(command)
+				command.EXPECT().CombinedOutput()
+				logger.EXPECT().Info(gomock.Any()).AnyTimes()
+				logger.EXPECT().StartProgress(gomock.Any()).Return(func() {}).AnyTimes()
 			},
 			wantErr: nil,
 		},
@@ -74,10 +245,14 @@ func TestStopVMAction_runAdapter(t *testing.T) {
 			dm := mocks.NewUserDataDiskManager(ctrl)
 			logger := mocks.NewLogger(ctrl)
 			ncc := mocks.NewNerdctlCmdCreator(ctrl)
-			tc.mockSvc(logger, ncc, ctrl, dm)
+			fs := afero.NewMemMapFs()
+			tc.mockSvc(logger, ncc, ctrl, dm, fs)
+			fc := &config.Finch{}
+			fc.Stop.DefaultForce = tc.defaultForce
 
-			cmd := newStopVMCommand(ncc, dm, logger)
-			cmd.SetArgs(tc.args)
+			cmd := newStopVMCommand(ncc, nil, dm, logger, fs, mockFinchPath, fc, mockFinchRootPath, nil, nil, nil)
+			// Preventing the host from sleeping runs caffeinate on macOS, which isn't what's tested here.
+			cmd.SetArgs(append(tc.args, "--allow-sleep"))
 			err := cmd.Execute()
 			assert.Equal(t, tc.wantErr, err)
 		})
@@ -90,64 +265,107 @@ func TestStopVMAction_run(t *testing.T) {
 	testCases := []struct {
 		name    string
 		wantErr error
-		mockSvc func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, ctrl *gomock.Controller, dm *mocks.UserDataDiskManager)
-		force   bool
+		mockSvc func(
+			logger *mocks.Logger,
+			creator *mocks.NerdctlCmdCreator,
+			ctrl *gomock.Controller,
+			dm *mocks.UserDataDiskManager,
+			fs afero.Fs,
+		)
+		force bool
 	}{
 		{
 			name:    "should stop the instance",
 			wantErr: nil,
-			mockSvc: func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, ctrl *gomock.Controller, dm *mocks.UserDataDiskManager) {
+			mockSvc: func(
+				logger *mocks.Logger,
+				creator *mocks.NerdctlCmdCreator,
+				ctrl *gomock.Controller,
+				dm *mocks.UserDataDiskManager,
+				_ afero.Fs,
+			) {
 				getVMStatusC := mocks.NewCommand(ctrl)
 				creator.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
 				getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
-				logger.EXPECT().Debugf("Status of virtual machine: %s", "Running")
+				expectUnmountGuestDataVolume(creator, ctrl)
 				dm.EXPECT().DetachUserDataDisk().Return(nil)
 
 				command := mocks.NewCommand(ctrl)
 				creator.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(command)
 				command.EXPECT().CombinedOutput()
-				logger.EXPECT().Info("Stopping existing Finch virtual machine...")
+				logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
 				logger.EXPECT().Info("Finch virtual machine stopped successfully")
 			},
 			force: false,
 		},
 		{
 			name:    "stopped VM",
-			wantErr: fmt.Errorf("the instance %q is already stopped", limaInstanceName),
-			mockSvc: func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, ctrl *gomock.Controller, _ *mocks.UserDataDiskManager) {
+			wantErr: withStatus(lima.Stopped, fmt.Errorf("the instance %q is already stopped", limaInstanceName)),
+			mockSvc: func(
+				_ *mocks.Logger,
+				creator *mocks.NerdctlCmdCreator,
+				ctrl *gomock.Controller,
+				_ *mocks.UserDataDiskManager,
+				_ afero.Fs,
+			) {
 				getVMStatusC := mocks.NewCommand(ctrl)
 				creator.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
 				getVMStatusC.EXPECT().Output().Return([]byte("Stopped"), nil)
-				logger.EXPECT().Debugf("Status of virtual machine: %s", "Stopped")
 			},
 			force: false,
 		},
 		{
 			name:    "nonexistent VM",
-			wantErr: fmt.Errorf("the instance %q does not exist", limaInstanceName),
-			mockSvc: func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, ctrl *gomock.Controller, _ *mocks.UserDataDiskManager) {
+			wantErr: withStatus(lima.Nonexistent, fmt.Errorf("the instance %q does not exist", limaInstanceName)),
+			mockSvc: func(
+				_ *mocks.Logger,
+				creator *mocks.NerdctlCmdCreator,
+				ctrl *gomock.Controller,
+				_ *mocks.UserDataDiskManager,
+				_ afero.Fs,
+			) {
 				getVMStatusC := mocks.NewCommand(ctrl)
 				creator.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
 				getVMStatusC.EXPECT().Output().Return([]byte(""), nil)
-				logger.EXPECT().Debugf("Status of virtual machine: %s", "")
 			},
 			force: false,
 		},
 		{
-			name:    "unknown VM status",
-			wantErr: errors.New("unrecognized system status"),
-			mockSvc: func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, ctrl *gomock.Controller, _ *mocks.UserDataDiskManager) {
+			name:    "broken VM",
+			wantErr: withStatus(lima.Broken, fmt.Errorf("the instance %q is broken, use --force to stop it", limaInstanceName)),
+			mockSvc: func(
+				_ *mocks.Logger,
+				creator *mocks.NerdctlCmdCreator,
+				ctrl *gomock.Controller,
+				_ *mocks.UserDataDiskManager,
+				_ afero.Fs,
+			) {
 				getVMStatusC := mocks.NewCommand(ctrl)
 				creator.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
 				getVMStatusC.EXPECT().Output().Return([]byte("Broken"), nil)
-				logger.EXPECT().Debugf("Status of virtual machine: %s", "Broken")
+			},
+			force: false,
+		},
+		{
+			name:    "unknown VM status",
+			wantErr: &lima.UnknownStatusError{Status: "Paused"},
+			mockSvc: func(
+				_ *mocks.Logger,
+				creator *mocks.NerdctlCmdCreator,
+				ctrl *gomock.Controller,
+				_ *mocks.UserDataDiskManager,
+				_ afero.Fs,
+			) {
+				getVMStatusC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+				getVMStatusC.EXPECT().Output().Return([]byte("Paused"), nil)
 			},
 			force: false,
 		},
 		{
 			name:    "status command returns an error",
 			wantErr: errors.New("get status error"),
-			mockSvc: func(_ *mocks.Logger, creator *mocks.NerdctlCmdCreator, ctrl *gomock.Controller, _ *mocks.UserDataDiskManager) {
+			mockSvc: func(_ *mocks.Logger, creator *mocks.NerdctlCmdCreator, ctrl *gomock.Controller, _ *mocks.UserDataDiskManager, _ afero.Fs) {
 				getVMStatusC := mocks.NewCommand(ctrl)
 				creator.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
 				getVMStatusC.EXPECT().Output().Return([]byte("Broken"), errors.New("get status error"))
@@ -156,32 +374,184 @@ func TestStopVMAction_run(t *testing.T) {
 		},
 		{
 			name:    "should print error if virtual machine failed to stop",
-			wantErr: errors.New("error"),
-			mockSvc: func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, ctrl *gomock.Controller, dm *mocks.UserDataDiskManager) {
+			wantErr: withCategory(stopErrorLimactl, errors.New("error")),
+			mockSvc: func(
+				logger *mocks.Logger,
+				creator *mocks.NerdctlCmdCreator,
+				ctrl *gomock.Controller,
+				dm *mocks.UserDataDiskManager,
+				_ afero.Fs,
+			) {
 				getVMStatusC := mocks.NewCommand(ctrl)
 				creator.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
 				getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
-				logger.EXPECT().Debugf("Status of virtual machine: %s", "Running")
+				expectUnmountGuestDataVolume(creator, ctrl)
 				dm.EXPECT().DetachUserDataDisk().Return(nil)
 
 				logs := []byte("stdout + stderr")
 				command := mocks.NewCommand(ctrl)
 				command.EXPECT().CombinedOutput().Return(logs, errors.New("error"))
 				creator.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(command)
-				logger.EXPECT().Info("Stopping existing Finch virtual machine...")
+				logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+				logger.EXPECT().Errorf("Finch virtual machine failed to stop, debug logs:\n%s", logs)
+				expectReattachUserDataDisk(creator, dm, logger, ctrl)
+			},
+			force: false,
+		},
+		{
+			name:    "should not attach the disk back if it failed to be detached",
+			wantErr: withCategory(stopErrorLimactl, errors.New("error")),
+			mockSvc: func(
+				logger *mocks.Logger,
+				creator *mocks.NerdctlCmdCreator,
+				ctrl *gomock.Controller,
+				dm *mocks.UserDataDiskManager,
+				_ afero.Fs,
+			) {
+				getVMStatusC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+				getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+				expectUnmountGuestDataVolume(creator, ctrl)
+				dm.EXPECT().DetachUserDataDisk().Return(errors.New("detach failed"))
+
+				logs := []byte("stdout + stderr")
+				command := mocks.NewCommand(ctrl)
+				command.EXPECT().CombinedOutput().Return(logs, errors.New("error"))
+				creator.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(command)
+				logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+				logger.EXPECT().Errorf("Finch virtual machine failed to stop, debug logs:\n%s", logs)
+			},
+			force: false,
+		},
+		{
+			name:    "should detach the disk again and retry once if the disk is in use",
+			wantErr: nil,
+			mockSvc: func(
+				logger *mocks.Logger,
+				creator *mocks.NerdctlCmdCreator,
+				ctrl *gomock.Controller,
+				dm *mocks.UserDataDiskManager,
+				_ afero.Fs,
+			) {
+				getVMStatusC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+				getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+				expectUnmountGuestDataVolume(creator, ctrl)
+				dm.EXPECT().DetachUserDataDisk().Return(nil).Times(2)
+
+				command := mocks.NewCommand(ctrl)
+				command.EXPECT().CombinedOutput().Return([]byte("failed to stop: disk in use"), errors.New("error"))
+				retryCommand := mocks.NewCommand(ctrl)
+				retryCommand.EXPECT().CombinedOutput()
+				creator.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(command)
+				creator.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(retryCommand)
+				logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+				logger.EXPECT().Warnln("The user data disk is still in use, detaching it again and retrying the stop...")
+				logger.EXPECT().Info("Finch virtual machine stopped successfully")
+			},
+			force: false,
+		},
+		{
+			name:    "should give up after one retry if the disk is still in use",
+			wantErr: withCategory(stopErrorDisk, errors.New("error")),
+			mockSvc: func(
+				logger *mocks.Logger,
+				creator *mocks.NerdctlCmdCreator,
+				ctrl *gomock.Controller,
+				dm *mocks.UserDataDiskManager,
+				_ afero.Fs,
+			) {
+				getVMStatusC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+				getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+				expectUnmountGuestDataVolume(creator, ctrl)
+				dm.EXPECT().DetachUserDataDisk().Return(nil).Times(2)
+
+				logs := []byte("failed to stop: disk in use")
+				command := mocks.NewCommand(ctrl)
+				command.EXPECT().CombinedOutput().Return(logs, errors.New("error")).Times(2)
+				creator.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(command).Times(2)
+				logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+				logger.EXPECT().Warnln("The user data disk is still in use, detaching it again and retrying the stop...")
 				logger.EXPECT().Errorf("Finch virtual machine failed to stop, debug logs:\n%s", logs)
+				expectReattachUserDataDisk(creator, dm, logger, ctrl)
 			},
 			force: false,
 		},
 		{
 			name:    "should force stop virtual machine",
 			wantErr: nil,
-			mockSvc: func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, ctrl *gomock.Controller, dm *mocks.UserDataDiskManager) {
+			mockSvc: func(
+				logger *mocks.Logger,
+				creator *mocks.NerdctlCmdCreator,
+				ctrl *gomock.Controller,
+				dm *mocks.UserDataDiskManager,
+				fs afero.Fs,
+			) {
+				serialLogPath := filepath.Join(mockFinchPath.LimaInstancePath(), "serial.log")
+				require.NoError(t, afero.WriteFile(fs, serialLogPath, []byte("kernel log"), 0o600))
+				logger.EXPECT().Infof("Guest kernel log saved to %q", gomock.Any())
+
+				command := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("stop", "--force", limaInstanceName).Return(command)
+				command.EXPECT().CombinedOutput()
+				dm.EXPECT().DetachUserDataDisk().Return(nil)
+				logger.EXPECT().StartProgress("Forcibly stopping Finch virtual machine...").Return(func() {})
+				logger.EXPECT().Info("Finch virtual machine stopped successfully")
+			},
+			force: true,
+		},
+		{
+			name:    "should capture the guest dmesg when force stopping a VM without a serial log",
+			wantErr: nil,
+			mockSvc: func(
+				logger *mocks.Logger,
+				creator *mocks.NerdctlCmdCreator,
+				ctrl *gomock.Controller,
+				dm *mocks.UserDataDiskManager,
+				_ afero.Fs,
+			) {
+				logger.EXPECT().Debugf("Could not read the serial log of the instance: %v", gomock.Any())
+				getVMStatusC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+				getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+				logger.EXPECT().Debugf("Status of virtual machine: %s", "Running")
+				dmesgC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "sudo", "dmesg").Return(dmesgC)
+				dmesgC.EXPECT().Output().Return([]byte("kernel log"), nil)
+				logger.EXPECT().Infof("Guest kernel log saved to %q", gomock.Any())
+
+				command := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("stop", "--force", limaInstanceName).Return(command)
+				command.EXPECT().CombinedOutput()
+				dm.EXPECT().DetachUserDataDisk().Return(nil)
+				logger.EXPECT().StartProgress("Forcibly stopping Finch virtual machine...").Return(func() {})
+				logger.EXPECT().Info("Finch virtual machine stopped successfully")
+			},
+			force: true,
+		},
+		{
+			name:    "should still force stop the VM if the guest kernel log can't be captured",
+			wantErr: nil,
+			mockSvc: func(
+				logger *mocks.Logger,
+				creator *mocks.NerdctlCmdCreator,
+				ctrl *gomock.Controller,
+				dm *mocks.UserDataDiskManager,
+				_ afero.Fs,
+			) {
+				logger.EXPECT().Debugf("Could not read the serial log of the instance: %v", gomock.Any())
+				getVMStatusC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+				getVMStatusC.EXPECT().Output().Return([]byte("Stopped"), nil)
+				logger.EXPECT().Debugf("Status of virtual machine: %s", "Stopped")
+				logger.EXPECT().Warnf("Could not capture the guest kernel log: %v", gomock.Any())
+
 				command := mocks.NewCommand(ctrl)
 				creator.EXPECT().CreateWithoutStdio("stop", "--force", limaInstanceName).Return(command)
 				command.EXPECT().CombinedOutput()
 				dm.EXPECT().DetachUserDataDisk().Return(nil)
-				logger.EXPECT().Info("Forcibly stopping Finch virtual machine...")
+				logger.EXPECT().StartProgress("Forcibly stopping Finch virtual machine...").Return(func() {})
 				logger.EXPECT().Info("Finch virtual machine stopped successfully")
 			},
 			force: true,
@@ -197,9 +567,1829 @@ func TestStopVMAction_run(t *testing.T) {
 			logger := mocks.NewLogger(ctrl)
 			ncc := mocks.NewNerdctlCmdCreator(ctrl)
 
-			tc.mockSvc(logger, ncc, ctrl, dm)
-			err := newStopVMAction(ncc, dm, logger).run(tc.force)
+			fs := afero.NewMemMapFs()
+
+			tc.mockSvc(logger, ncc, ctrl, dm, fs)
+			action := newStopVMAction(ncc, nil, dm, logger, fs, mockFinchPath, &config.Finch{}, mockFinchRootPath, nil, nil, nil)
+			err := action.run(stopVMOptions{force: tc.force})
+			assert.Equal(t, tc.wantErr, err)
+		})
+	}
+}
+
+func TestStopVMAction_reattachUserDataDiskIf(t *testing.T) {
+	t.Parallel()
+
+	stopErr := withCategory(stopErrorLimactl, errors.New("exit status 1"))
+
+	testCases := []struct {
+		name    string
+		wantErr error
+		mockSvc func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, dm *mocks.UserDataDiskManager, ctrl *gomock.Controller)
+	}{
+		{
+			name:    "should attach the disk back to the running instance",
+			wantErr: stopErr,
+			mockSvc: func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, dm *mocks.UserDataDiskManager, ctrl *gomock.Controller) {
+				expectAttachUserDataDiskBack(creator, dm, logger, ctrl)
+			},
+		},
+		{
+			name: "should report the failure to attach the disk back",
+			wantErr: errors.Join(stopErr,
+				withCategory(stopErrorDisk, fmt.Errorf("failed to attach the user data disk back: %w", errors.New("attach failed")))),
+			mockSvc: func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, dm *mocks.UserDataDiskManager, ctrl *gomock.Controller) {
+				statusC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(statusC)
+				statusC.EXPECT().Output().Return([]byte("Running"), nil)
+				logger.EXPECT().Warnln("The stop failed after the user data disk was detached, attaching it back...")
+				dm.EXPECT().AttachUserDataDisk().Return(errors.New("attach failed"))
+			},
+		},
+		{
+			name:    "should leave the disk to the next start of an instance that isn't running",
+			wantErr: stopErr,
+			mockSvc: func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, _ *mocks.UserDataDiskManager, ctrl *gomock.Controller) {
+				statusC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(statusC)
+				statusC.EXPECT().Output().Return([]byte("Stopped"), nil)
+				logger.EXPECT().Infof("The stop failed after the user data disk was detached, the instance %q is %s so its next start "+
+					"attaches the disk back", limaInstanceName, lima.Stopped)
+			},
+		},
+		{
+			name:    "should not attach the disk back if the status of the instance is unknown",
+			wantErr: stopErr,
+			mockSvc: func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, _ *mocks.UserDataDiskManager, ctrl *gomock.Controller) {
+				statusC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(statusC)
+				statusC.EXPECT().Output().Return([]byte("Frozen"), nil)
+				logger.EXPECT().Warnf("The stop failed after the user data disk was detached, and the status of the instance %q "+
+					"is unknown: %v, not attaching the disk back", limaInstanceName, &lima.UnknownStatusError{Status: "Frozen"})
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+			tc.mockSvc(logger, ncc, dm, ctrl)
+
+			action := newStopVMAction(ncc, nil, dm, logger, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath,
+				nil, nil, nil)
+			assert.Equal(t, tc.wantErr, action.reattachUserDataDiskIf(limaInstanceName, true, stopErr))
+		})
+	}
+}
+
+func TestStopVMAction_runWithInstanceFile(t *testing.T) {
+	t.Parallel()
+
+	const instanceFile = "instances.txt"
+
+	testCases := []struct {
+		name    string
+		wantErr error
+		mockSvc func(
+			logger *mocks.Logger,
+			creator *mocks.NerdctlCmdCreator,
+			ctrl *gomock.Controller,
+			dm *mocks.UserDataDiskManager,
+			fs afero.Fs,
+		)
+		force bool
+	}{
+		{
+			name:    "should stop every instance listed in the file",
+			wantErr: nil,
+			mockSvc: func(
+				logger *mocks.Logger,
+				creator *mocks.NerdctlCmdCreator,
+				ctrl *gomock.Controller,
+				dm *mocks.UserDataDiskManager,
+				fs afero.Fs,
+			) {
+				data := "# fleet instances\nfinch\n\n  other  \n"
+				require.NoError(t, afero.WriteFile(fs, instanceFile, []byte(data), 0o600))
+
+				for _, instance := range []string{limaInstanceName, "other"} {
+					getVMStatusC := mocks.NewCommand(ctrl)
+					creator.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", instance).Return(getVMStatusC)
+					getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+
+					command := mocks.NewCommand(ctrl)
+					creator.EXPECT().CreateWithoutStdio("stop", instance).Return(command)
+					command.EXPECT().CombinedOutput()
+				}
+				expectUnmountGuestDataVolume(creator, ctrl)
+				dm.EXPECT().DetachUserDataDisk().Return(nil)
+				logger.EXPECT().Info(gomock.Any()).AnyTimes()
+				logger.EXPECT().StartProgress(gomock.Any()).Return(func() {}).AnyTimes()
+				logger.EXPECT().Infof("Stopped %d of %d instances", 2, 2)
+			},
+			force: false,
+		},
+		{
+			name: "should keep stopping the remaining instances and aggregate the errors",
+			wantErr: errors.Join(
+				fmt.Errorf("failed to stop instance %q: %w", "first",
+					withStatus(lima.Stopped, fmt.Errorf("the instance %q is already stopped", "first"))),
+			),
+			mockSvc: func(
+				logger *mocks.Logger,
+				creator *mocks.NerdctlCmdCreator,
+				ctrl *gomock.Controller,
+				_ *mocks.UserDataDiskManager,
+				fs afero.Fs,
+			) {
+				require.NoError(t, afero.WriteFile(fs, instanceFile, []byte("first\nsecond\n"), 0o600))
+
+				getFirstStatusC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", "first").Return(getFirstStatusC)
+				getFirstStatusC.EXPECT().Output().Return([]byte("Stopped"), nil)
+
+				getSecondStatusC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", "second").Return(getSecondStatusC)
+				getSecondStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+
+				command := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("stop", "second").Return(command)
+				command.EXPECT().CombinedOutput()
+				logger.EXPECT().Info(gomock.Any()).AnyTimes()
+				logger.EXPECT().StartProgress(gomock.Any()).Return(func() {}).AnyTimes()
+				logger.EXPECT().Infof("Stopped %d of %d instances", 1, 2)
+			},
+			force: false,
+		},
+		{
+			name:    "should force stop every instance listed in the file",
+			wantErr: nil,
+			mockSvc: func(
+				logger *mocks.Logger,
+				creator *mocks.NerdctlCmdCreator,
+				ctrl *gomock.Controller,
+				_ *mocks.UserDataDiskManager,
+				fs afero.Fs,
+			) {
+				require.NoError(t, afero.WriteFile(fs, instanceFile, []byte("first\nsecond"), 0o600))
+
+				for _, instance := range []string{"first", "second"} {
+					serialLogPath := filepath.Join(mockFinchPath.LimaHomePath(), instance, "serial.log")
+					require.NoError(t, afero.WriteFile(fs, serialLogPath, []byte("kernel log"), 0o600))
+					logger.EXPECT().Infof("Guest kernel log saved to %q", gomock.Any())
+
+					command := mocks.NewCommand(ctrl)
+					creator.EXPECT().CreateWithoutStdio("stop", "--force", instance).Return(command)
+					command.EXPECT().CombinedOutput()
+				}
+				logger.EXPECT().Info(gomock.Any()).AnyTimes()
+				logger.EXPECT().StartProgress(gomock.Any()).Return(func() {}).AnyTimes()
+				logger.EXPECT().Infof("Stopped %d of %d instances", 2, 2)
+			},
+			force: true,
+		},
+		{
+			name:    "should warn and do nothing if the file lists no instances",
+			wantErr: nil,
+			mockSvc: func(logger *mocks.Logger, _ *mocks.NerdctlCmdCreator, _ *gomock.Controller, _ *mocks.UserDataDiskManager, fs afero.Fs) {
+				require.NoError(t, afero.WriteFile(fs, instanceFile, []byte("# nothing here\n\n"), 0o600))
+				logger.EXPECT().Warnf("No instance names found in %q, nothing to stop", instanceFile)
+			},
+			force: false,
+		},
+		{
+			name: "should return an error if the file does not exist",
+			wantErr: fmt.Errorf("failed to read instance file: %w",
+				&os.PathError{Op: "open", Path: instanceFile, Err: afero.ErrFileNotFound}),
+			mockSvc: func(_ *mocks.Logger, _ *mocks.NerdctlCmdCreator, _ *gomock.Controller, _ *mocks.UserDataDiskManager, _ afero.Fs) {
+			},
+			force: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+			fs := afero.NewMemMapFs()
+
+			tc.mockSvc(logger, ncc, ctrl, dm, fs)
+			err := newStopVMAction(ncc, nil, dm, logger, fs, mockFinchPath, &config.Finch{}, mockFinchRootPath, nil, nil, nil).run(
+				stopVMOptions{force: tc.force, instanceFile: instanceFile, includeForeign: true},
+			)
 			assert.Equal(t, tc.wantErr, err)
 		})
 	}
 }
+
+func TestStopVMAction_runWithForeignInstances(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	dm := mocks.NewUserDataDiskManager(ctrl)
+	logger := mocks.NewLogger(ctrl)
+	ncc := mocks.NewNerdctlCmdCreator(ctrl)
+	fs := afero.NewMemMapFs()
+
+	require.NoError(t, afero.WriteFile(fs, "instances.txt", []byte("finch\nfinch-test\nhibernated\nforeign\n"), 0o600))
+	hibernatedDir := filepath.Join(mockFinchPath.LimaHomePath(), "hibernated")
+	require.NoError(t, lima.SaveInstanceMetadata(fs, hibernatedDir, &lima.InstanceMetadata{Hibernated: true}))
+
+	for _, instance := range []string{limaInstanceName, "finch-test", "hibernated"} {
+		getVMStatusC := mocks.NewCommand(ctrl)
+		ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", instance).Return(getVMStatusC)
+		getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+
+		command := mocks.NewCommand(ctrl)
+		ncc.EXPECT().CreateWithoutStdio("stop", instance).Return(command)
+		command.EXPECT().CombinedOutput()
+	}
+	expectUnmountGuestDataVolume(ncc, ctrl)
+	dm.EXPECT().DetachUserDataDisk().Return(nil)
+	logger.EXPECT().Info(gomock.Any()).AnyTimes()
+	logger.EXPECT().StartProgress(gomock.Any()).Return(func() {}).AnyTimes()
+	logger.EXPECT().Warnf("Not stopping the instance %q as it wasn't created by Finch, use --include-foreign to stop it anyway", "foreign")
+	logger.EXPECT().Infof("Stopped %d of %d instances", 3, 4)
+
+	action := newStopVMAction(ncc, nil, dm, logger, fs, mockFinchPath, &config.Finch{}, mockFinchRootPath, nil, nil, nil)
+	err := action.run(stopVMOptions{instanceFile: "instances.txt"})
+	require.NoError(t, err)
+}
+
+func TestStopVMAction_runWithRunningContainers(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name        string
+		stdin       string
+		interactive bool
+		namespace   string
+		wantStdout  string
+		mockSvc     func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, ctrl *gomock.Controller, dm *mocks.UserDataDiskManager)
+	}{
+		{
+			name:        "should stop the instance if the user confirms",
+			stdin:       "y\n",
+			interactive: true,
+			wantStdout:  "2 containers running. Stop anyway? [y/N] ",
+			mockSvc: func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, ctrl *gomock.Controller, dm *mocks.UserDataDiskManager) {
+				psC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "sudo", "-E", "nerdctl", "ps", "-q").Return(psC)
+				psC.EXPECT().Output().Return([]byte("abc\ndef\n"), nil)
+
+				expectUnmountGuestDataVolume(creator, ctrl)
+				dm.EXPECT().DetachUserDataDisk().Return(nil)
+				command := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(command)
+				command.EXPECT().CombinedOutput()
+				logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+				logger.EXPECT().Info("Finch virtual machine stopped successfully")
+			},
+		},
+		{
+			name:        "should not stop the instance if the user declines",
+			stdin:       "\n",
+			interactive: true,
+			wantStdout:  "1 containers running. Stop anyway? [y/N] ",
+			mockSvc: func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, ctrl *gomock.Controller, _ *mocks.UserDataDiskManager) {
+				psC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "sudo", "-E", "nerdctl", "ps", "-q").Return(psC)
+				psC.EXPECT().Output().Return([]byte("abc\n"), nil)
+				logger.EXPECT().Infof("Not stopping the instance %q", limaInstanceName)
+			},
+		},
+		{
+			name:        "should count the running containers of --namespace",
+			stdin:       "n\n",
+			interactive: true,
+			namespace:   "tenant-a",
+			wantStdout:  "1 containers running. Stop anyway? [y/N] ",
+			mockSvc: func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, ctrl *gomock.Controller, _ *mocks.UserDataDiskManager) {
+				psC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "sudo", "-E", "nerdctl", "--namespace", "tenant-a",
+					"ps", "-q").Return(psC)
+				psC.EXPECT().Output().Return([]byte("abc\n"), nil)
+				logger.EXPECT().Infof("Not stopping the instance %q", limaInstanceName)
+			},
+		},
+		{
+			name:        "should not prompt if no containers are running",
+			stdin:       "",
+			interactive: true,
+			wantStdout:  "",
+			mockSvc: func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, ctrl *gomock.Controller, dm *mocks.UserDataDiskManager) {
+				psC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "sudo", "-E", "nerdctl", "ps", "-q").Return(psC)
+				psC.EXPECT().Output().Return([]byte(""), nil)
+
+				expectUnmountGuestDataVolume(creator, ctrl)
+				dm.EXPECT().DetachUserDataDisk().Return(nil)
+				command := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(command)
+				command.EXPECT().CombinedOutput()
+				logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+				logger.EXPECT().Info("Finch virtual machine stopped successfully")
+			},
+		},
+		{
+			name:        "should not prompt in a non-interactive session",
+			stdin:       "",
+			interactive: false,
+			wantStdout:  "",
+			mockSvc: func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, ctrl *gomock.Controller, dm *mocks.UserDataDiskManager) {
+				expectUnmountGuestDataVolume(creator, ctrl)
+				dm.EXPECT().DetachUserDataDisk().Return(nil)
+				command := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(command)
+				command.EXPECT().CombinedOutput()
+				logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+				logger.EXPECT().Info("Finch virtual machine stopped successfully")
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+			stdout := &bytes.Buffer{}
+
+			getVMStatusC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+			getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+			tc.mockSvc(logger, ncc, ctrl, dm)
+
+			stdin := strings.NewReader(tc.stdin)
+			fs := afero.NewMemMapFs()
+			action := newStopVMAction(ncc, nil, dm, logger, fs, mockFinchPath, &config.Finch{}, mockFinchRootPath, stdin, stdout, nil)
+			err := action.run(stopVMOptions{confirmRunningContainers: true, interactive: tc.interactive, drainNamespace: tc.namespace})
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantStdout, stdout.String())
+		})
+	}
+}
+
+func TestStopVMAction_runWithStartingVM(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		statuses []string
+		wantErr  error
+	}{
+		{
+			name:     "should stop the instance once it has started",
+			statuses: []string{"Starting", "Running"},
+			wantErr:  nil,
+		},
+		{
+			name:     "should fail if the instance is still starting",
+			statuses: []string{"Starting", "Starting", "Starting"},
+			wantErr:  fmt.Errorf("the instance %q is still starting; retry shortly", limaInstanceName),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+
+			statusC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(statusC).AnyTimes()
+			calls := 0
+			statusC.EXPECT().Output().DoAndReturn(func() ([]byte, error) {
+				status := tc.statuses[min(calls, len(tc.statuses)-1)]
+				calls++
+				return []byte(status), nil
+			}).MinTimes(2)
+			logger.EXPECT().Infof("The instance %q is starting, waiting for it to settle before stopping it...", limaInstanceName)
+			if tc.wantErr == nil {
+				expectGracefulStop(ncc, dm, logger, ctrl)
+			}
+
+			fc := &config.Finch{}
+			fc.Stop.VerifyPollInterval = time.Millisecond
+			action := newStopVMAction(ncc, nil, dm, logger, afero.NewMemMapFs(), mockFinchPath, fc, mockFinchRootPath, nil, nil, nil)
+			action.settleTimeout = 20 * time.Millisecond
+			assert.Equal(t, tc.wantErr, action.run(stopVMOptions{}))
+		})
+	}
+}
+
+func TestStopVMAction_runWithSystemdPoweroff(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name    string
+		method  config.StopMethod
+		wantErr error
+		mockSvc func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, dm *mocks.UserDataDiskManager, ctrl *gomock.Controller)
+	}{
+		{
+			name:    "should power the guest off and wait for the instance to stop",
+			method:  config.StopMethodSystemdPoweroff,
+			wantErr: nil,
+			mockSvc: func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, dm *mocks.UserDataDiskManager, ctrl *gomock.Controller) {
+				sshC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "true").Return(sshC)
+				sshC.EXPECT().CombinedOutput()
+				expectUnmountGuestDataVolume(creator, ctrl)
+				dm.EXPECT().DetachUserDataDisk().Return(nil)
+				logger.EXPECT().StartProgress("Powering off Finch virtual machine...").Return(func() {})
+				poweroffC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "sudo", "systemctl", "poweroff").Return(poweroffC)
+				poweroffC.EXPECT().CombinedOutput().Return(nil, errors.New("connection closed"))
+				stoppedC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(stoppedC)
+				stoppedC.EXPECT().Output().Return([]byte("Stopped"), nil)
+				logger.EXPECT().Info("Finch virtual machine stopped successfully")
+			},
+		},
+		{
+			name:    "should fall back to limactl if the guest can't be reached over SSH",
+			method:  config.StopMethodSystemdPoweroff,
+			wantErr: nil,
+			mockSvc: func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, dm *mocks.UserDataDiskManager, ctrl *gomock.Controller) {
+				sshC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "true").Return(sshC)
+				sshC.EXPECT().CombinedOutput().Return([]byte("ssh: connect to host"), errors.New("exit status 255"))
+				logger.EXPECT().Warnf("Could not reach the instance %q over SSH, stopping it with limactl instead: %v, debug logs:\n%s",
+					limaInstanceName, errors.New("exit status 255"), []byte("ssh: connect to host"))
+				expectUnmountGuestDataVolume(creator, ctrl)
+				dm.EXPECT().DetachUserDataDisk().Return(nil)
+				stopC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(stopC)
+				stopC.EXPECT().CombinedOutput()
+				logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+				logger.EXPECT().Info("Finch virtual machine stopped successfully")
+			},
+		},
+		{
+			name:    "should fail with an unsupported method",
+			method:  "acpi",
+			wantErr: fmt.Errorf("unsupported stop method %q, it must be either %q or %q", "acpi", "limactl", "systemd-poweroff"),
+			mockSvc: func(_ *mocks.Logger, _ *mocks.NerdctlCmdCreator, _ *mocks.UserDataDiskManager, _ *gomock.Controller) {
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+			fc := &config.Finch{}
+			fc.Stop.Method = tc.method
+
+			getVMStatusC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+			getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+			tc.mockSvc(logger, ncc, dm, ctrl)
+
+			action := newStopVMAction(ncc, nil, dm, logger, afero.NewMemMapFs(), mockFinchPath, fc, mockFinchRootPath, nil, nil, nil)
+			err := action.run(stopVMOptions{})
+			assert.Equal(t, tc.wantErr, err)
+		})
+	}
+}
+
+func TestStopVMAction_runWithMaxAttempts(t *testing.T) {
+	t.Parallel()
+
+	stopErr := errors.New("exit status 1")
+
+	testCases := []struct {
+		name    string
+		wantErr error
+		mockSvc func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, dm *mocks.UserDataDiskManager, ctrl *gomock.Controller)
+	}{
+		{
+			name:    "should retry a failed stop of a running VM",
+			wantErr: nil,
+			mockSvc: func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, dm *mocks.UserDataDiskManager, ctrl *gomock.Controller) {
+				statusC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(statusC).Times(3)
+				statusC.EXPECT().Output().Return([]byte("Running"), nil).Times(3)
+				expectUnmountGuestDataVolume(creator, ctrl)
+				expectUnmountGuestDataVolume(creator, ctrl)
+				dm.EXPECT().DetachUserDataDisk().Return(nil).Times(2)
+				logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {}).Times(2)
+
+				failedStopC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(failedStopC)
+				failedStopC.EXPECT().CombinedOutput().Return([]byte("error"), stopErr)
+				logger.EXPECT().Errorf("Finch virtual machine failed to stop, debug logs:\n%s", []byte("error"))
+				expectReattachUserDataDisk(creator, dm, logger, ctrl)
+				logger.EXPECT().Warnf("Attempt %d of %d at stopping the instance %q failed: %v, retrying in %s...",
+					1, 3, limaInstanceName, withCategory(stopErrorLimactl, stopErr), time.Second)
+
+				stopC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(stopC)
+				stopC.EXPECT().CombinedOutput()
+				logger.EXPECT().Info("Finch virtual machine stopped successfully")
+			},
+		},
+		{
+			name:    "should succeed if the VM is found stopped after a retry",
+			wantErr: nil,
+			mockSvc: func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, dm *mocks.UserDataDiskManager, ctrl *gomock.Controller) {
+				runningC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(runningC).Times(2)
+				runningC.EXPECT().Output().Return([]byte("Running"), nil).Times(2)
+				// The instance is still running when the disk is attached back after the failed attempt.
+				expectReattachUserDataDisk(creator, dm, logger, ctrl)
+				stoppedC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(stoppedC).Times(2)
+				stoppedC.EXPECT().Output().Return([]byte("Stopped"), nil).Times(2)
+
+				expectUnmountGuestDataVolume(creator, ctrl)
+				dm.EXPECT().DetachUserDataDisk().Return(nil)
+				logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+				failedStopC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(failedStopC)
+				failedStopC.EXPECT().CombinedOutput().Return([]byte("error"), stopErr)
+				logger.EXPECT().Errorf("Finch virtual machine failed to stop, debug logs:\n%s", []byte("error"))
+				logger.EXPECT().Warnf("Attempt %d of %d at stopping the instance %q failed: %v, retrying in %s...",
+					1, 3, limaInstanceName, withCategory(stopErrorLimactl, stopErr), time.Second)
+				logger.EXPECT().Infof("The instance %q is stopped", limaInstanceName)
+			},
+		},
+		{
+			name:    "should not retry if the VM isn't running",
+			wantErr: withStatus(lima.Nonexistent, fmt.Errorf("the instance %q does not exist", limaInstanceName)),
+			mockSvc: func(_ *mocks.Logger, creator *mocks.NerdctlCmdCreator, _ *mocks.UserDataDiskManager, ctrl *gomock.Controller) {
+				statusC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(statusC).Times(2)
+				statusC.EXPECT().Output().Return([]byte(""), nil).Times(2)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+			tc.mockSvc(logger, ncc, dm, ctrl)
+
+			fc := &config.Finch{}
+			fc.Retry = config.RetryPolicy{Strategy: config.RetryFixed, Base: time.Second}
+			action := newStopVMAction(ncc, nil, dm, logger, afero.NewMemMapFs(), mockFinchPath, fc, mockFinchRootPath,
+				nil, nil, nil)
+			err := action.run(stopVMOptions{maxAttempts: 3})
+			assert.Equal(t, tc.wantErr, err)
+		})
+	}
+}
+
+func TestStopVMAction_runWithNestedGracefulStop(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name    string
+		mockSvc func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, ctrl *gomock.Controller)
+	}{
+		{
+			name: "should stop the nested VM before the instance",
+			mockSvc: func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, ctrl *gomock.Controller) {
+				statusC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "finch", "vm", "status").Return(statusC)
+				statusC.EXPECT().Output().Return([]byte("Running\n"), nil)
+				logger.EXPECT().Infof("Stopping the nested Finch virtual machine in the instance %q...", limaInstanceName)
+				stopC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "finch", "vm", "stop").Return(stopC)
+				stopC.EXPECT().CombinedOutput()
+			},
+		},
+		{
+			name: "should not stop the nested VM if it isn't running",
+			mockSvc: func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, ctrl *gomock.Controller) {
+				statusC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "finch", "vm", "status").Return(statusC)
+				statusC.EXPECT().Output().Return([]byte("Stopped\n"), nil)
+				logger.EXPECT().Debugf("No nested Finch virtual machine running in the instance %q", limaInstanceName)
+			},
+		},
+		{
+			name: "should not stop the nested VM if finch isn't installed in the instance",
+			mockSvc: func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, ctrl *gomock.Controller) {
+				statusC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "finch", "vm", "status").Return(statusC)
+				statusC.EXPECT().Output().Return(nil, errors.New("finch: command not found"))
+				logger.EXPECT().Debugf("No nested Finch virtual machine running in the instance %q", limaInstanceName)
+			},
+		},
+		{
+			name: "should still stop the instance if the nested VM fails to stop",
+			mockSvc: func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, ctrl *gomock.Controller) {
+				statusC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "finch", "vm", "status").Return(statusC)
+				statusC.EXPECT().Output().Return([]byte("Running\n"), nil)
+				logger.EXPECT().Infof("Stopping the nested Finch virtual machine in the instance %q...", limaInstanceName)
+				stopC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "finch", "vm", "stop").Return(stopC)
+				logs := []byte("stdout + stderr")
+				stopC.EXPECT().CombinedOutput().Return(logs, errors.New("error"))
+				logger.EXPECT().Warnf("Could not stop the nested Finch virtual machine: %v, debug logs:\n%s", errors.New("error"), logs)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+			fc := &config.Finch{}
+			fc.Nested.GracefulStop = true
+
+			getVMStatusC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+			getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+			tc.mockSvc(logger, ncc, ctrl)
+
+			expectUnmountGuestDataVolume(ncc, ctrl)
+			dm.EXPECT().DetachUserDataDisk().Return(nil)
+			command := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(command)
+			command.EXPECT().CombinedOutput()
+			logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+			logger.EXPECT().Info("Finch virtual machine stopped successfully")
+
+			action := newStopVMAction(ncc, nil, dm, logger, afero.NewMemMapFs(), mockFinchPath, fc, mockFinchRootPath, nil, nil, nil)
+			err := action.run(stopVMOptions{})
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestStopVMAction_runWithBuildKitPersistOnStop(t *testing.T) {
+	t.Parallel()
+
+	flushArgs := []any{"shell", limaInstanceName, "sudo", "sh", "-c", "systemctl stop buildkit.service && sync -f /var/lib/buildkit"}
+
+	testCases := []struct {
+		name      string
+		ephemeral bool
+		mockSvc   func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, ctrl *gomock.Controller)
+	}{
+		{
+			name: "should flush the BuildKit cache before detaching the disk",
+			mockSvc: func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, ctrl *gomock.Controller) {
+				logger.EXPECT().Info("Flushing the BuildKit cache to the user data disk...")
+				flushC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio(flushArgs...).Return(flushC)
+				flushC.EXPECT().CombinedOutput()
+			},
+		},
+		{
+			name: "should still stop the instance if the BuildKit cache fails to be flushed",
+			mockSvc: func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, ctrl *gomock.Controller) {
+				logger.EXPECT().Info("Flushing the BuildKit cache to the user data disk...")
+				flushC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio(flushArgs...).Return(flushC)
+				logs := []byte("Failed to stop buildkit.service: Unit buildkit.service not loaded.")
+				flushC.EXPECT().CombinedOutput().Return(logs, errors.New("exit status 5"))
+				logger.EXPECT().Warnf("Could not flush the BuildKit cache: %v, debug logs:\n%s", errors.New("exit status 5"), logs)
+			},
+		},
+		{
+			name:      "should not flush the BuildKit cache to an ephemeral disk",
+			ephemeral: true,
+			mockSvc:   func(_ *mocks.Logger, _ *mocks.NerdctlCmdCreator, _ *gomock.Controller) {},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+			fc := &config.Finch{}
+			fc.BuildKit.PersistOnStop = true
+			fc.Disk.Ephemeral = tc.ephemeral
+
+			getVMStatusC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+			getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+			tc.mockSvc(logger, ncc, ctrl)
+
+			if tc.ephemeral {
+				logger.EXPECT().Infoln("The user data disk is ephemeral, not detaching it")
+			} else {
+				expectUnmountGuestDataVolume(ncc, ctrl)
+				dm.EXPECT().DetachUserDataDisk().Return(nil)
+			}
+			command := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(command)
+			command.EXPECT().CombinedOutput()
+			logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+			logger.EXPECT().Info("Finch virtual machine stopped successfully")
+
+			action := newStopVMAction(ncc, nil, dm, logger, afero.NewMemMapFs(), mockFinchPath, fc, mockFinchRootPath, nil, nil, nil)
+			err := action.run(stopVMOptions{})
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestStopVMAction_runWithEphemeralDisk(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name    string
+		wantErr error
+		mockSvc func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, ctrl *gomock.Controller)
+	}{
+		{
+			name:    "should stop the instance without detaching the disk",
+			wantErr: nil,
+			mockSvc: func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, ctrl *gomock.Controller) {
+				command := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(command)
+				command.EXPECT().CombinedOutput()
+				logger.EXPECT().Info("Finch virtual machine stopped successfully")
+			},
+		},
+		{
+			name:    "should not retry if the disk is in use",
+			wantErr: withCategory(stopErrorDisk, errors.New("error")),
+			mockSvc: func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, ctrl *gomock.Controller) {
+				logs := []byte("failed to stop: disk in use")
+				command := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(command)
+				command.EXPECT().CombinedOutput().Return(logs, errors.New("error"))
+				logger.EXPECT().Errorf("Finch virtual machine failed to stop, debug logs:\n%s", logs)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+			fc := &config.Finch{}
+			fc.Disk.Ephemeral = true
+
+			getVMStatusC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+			getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+			logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+			logger.EXPECT().Infoln("The user data disk is ephemeral, not detaching it")
+			tc.mockSvc(logger, ncc, ctrl)
+
+			action := newStopVMAction(ncc, nil, dm, logger, afero.NewMemMapFs(), mockFinchPath, fc, mockFinchRootPath, nil, nil, nil)
+			err := action.run(stopVMOptions{})
+			assert.Equal(t, tc.wantErr, err)
+		})
+	}
+}
+
+func TestStopVMAction_runWithHibernate(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name          string
+		wantErr       error
+		wantHibernate bool
+		mockSvc       func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, ctrl *gomock.Controller, dm *mocks.UserDataDiskManager)
+	}{
+		{
+			name:          "should hibernate the instance and record it",
+			wantErr:       nil,
+			wantHibernate: true,
+			mockSvc: func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, ctrl *gomock.Controller, dm *mocks.UserDataDiskManager) {
+				getVMTypeC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("ls", "-f", "{{.VMType}}", limaInstanceName).Return(getVMTypeC)
+				getVMTypeC.EXPECT().Output().Return([]byte("vz"), nil)
+				logger.EXPECT().Debugf("VMType of virtual machine: %s", "vz")
+
+				helpC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("stop", "--help").Return(helpC)
+				help := "  -f, --force        force stop the instance\n      --save-state   save the VM state\n"
+				helpC.EXPECT().Output().Return([]byte(help), nil)
+
+				dm.EXPECT().DetachUserDataDisk().Return(nil)
+				command := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("stop", "--save-state", limaInstanceName).Return(command)
+				command.EXPECT().CombinedOutput()
+				logger.EXPECT().Info("Hibernating Finch virtual machine...")
+				logger.EXPECT().Info("Finch virtual machine hibernated successfully")
+			},
+		},
+		{
+			name: "should refuse to hibernate a non-vz instance",
+			wantErr: fmt.Errorf("hibernation is only supported for %q VMs, the instance %q is a %q VM",
+				lima.VZ, limaInstanceName, lima.QEMU),
+			wantHibernate: false,
+			mockSvc: func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, ctrl *gomock.Controller, _ *mocks.UserDataDiskManager) {
+				getVMTypeC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("ls", "-f", "{{.VMType}}", limaInstanceName).Return(getVMTypeC)
+				getVMTypeC.EXPECT().Output().Return([]byte("qemu"), nil)
+				logger.EXPECT().Debugf("VMType of virtual machine: %s", "qemu")
+			},
+		},
+		{
+			name:          "should refuse to hibernate if limactl cannot save the VM state",
+			wantErr:       errors.New("the installed limactl does not support saving the VM state"),
+			wantHibernate: false,
+			mockSvc: func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, ctrl *gomock.Controller, _ *mocks.UserDataDiskManager) {
+				getVMTypeC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("ls", "-f", "{{.VMType}}", limaInstanceName).Return(getVMTypeC)
+				getVMTypeC.EXPECT().Output().Return([]byte("vz"), nil)
+				logger.EXPECT().Debugf("VMType of virtual machine: %s", "vz")
+
+				helpC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("stop", "--help").Return(helpC)
+				helpC.EXPECT().Output().Return([]byte("  -f, --force   force stop the instance\n"), nil)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+			fs := afero.NewMemMapFs()
+
+			getVMStatusC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+			getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+			tc.mockSvc(logger, ncc, ctrl, dm)
+
+			action := newStopVMAction(ncc, nil, dm, logger, fs, mockFinchPath, &config.Finch{}, mockFinchRootPath, nil, nil, nil)
+			err := action.run(stopVMOptions{hibernate: true})
+			assert.Equal(t, tc.wantErr, err)
+
+			md, err := lima.LoadInstanceMetadata(fs, mockFinchPath.LimaInstancePath())
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantHibernate, md.Hibernated)
+		})
+	}
+}
+
+func TestStopVMAction_runWithForceAndHibernate(t *testing.T) {
+	t.Parallel()
+
+	action := newStopVMAction(nil, nil, nil, nil, nil, mockFinchPath, &config.Finch{}, mockFinchRootPath, nil, nil, nil)
+	err := action.run(stopVMOptions{force: true, hibernate: true})
+	assert.Equal(t, errors.New("--force and --hibernate cannot be used together"), err)
+}
+
+func TestStopVMAction_runWithTag(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	dm := mocks.NewUserDataDiskManager(ctrl)
+	logger := mocks.NewLogger(ctrl)
+	ncc := mocks.NewNerdctlCmdCreator(ctrl)
+	fs := afero.NewMemMapFs()
+
+	getVMStatusC := mocks.NewCommand(ctrl)
+	ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+	getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+
+	expectUnmountGuestDataVolume(ncc, ctrl)
+	dm.EXPECT().DetachUserDataDisk().Return(nil)
+	stopC := mocks.NewCommand(ctrl)
+	ncc.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(stopC)
+	stopC.EXPECT().CombinedOutput()
+	logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+	logger.EXPECT().Info("Finch virtual machine stopped successfully")
+
+	snapshotC := mocks.NewCommand(ctrl)
+	ncc.EXPECT().CreateWithoutStdio("snapshot", "create", limaInstanceName, "--tag", "clean").Return(snapshotC)
+	snapshotC.EXPECT().CombinedOutput()
+	logger.EXPECT().Infof("Taking snapshot %q of the Finch virtual machine...", "clean")
+	logger.EXPECT().Infof("Snapshot %q taken successfully", "clean")
+
+	action := newStopVMAction(ncc, nil, dm, logger, fs, mockFinchPath, &config.Finch{}, mockFinchRootPath, nil, nil, nil)
+	err := action.run(stopVMOptions{tag: "clean"})
+	require.NoError(t, err)
+
+	md, err := lima.LoadInstanceMetadata(fs, mockFinchPath.LimaInstancePath())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"clean"}, md.Snapshots)
+}
+
+func TestStopVMAction_runWithTagAndHibernate(t *testing.T) {
+	t.Parallel()
+
+	action := newStopVMAction(nil, nil, nil, nil, nil, mockFinchPath, &config.Finch{}, mockFinchRootPath, nil, nil, nil)
+	err := action.run(stopVMOptions{hibernate: true, tag: "clean"})
+	assert.Equal(t, errors.New("--tag cannot be used together with --force or --hibernate"), err)
+}
+
+func TestStopVMAction_runWithPostStopCommand(t *testing.T) {
+	t.Parallel()
+
+	const postStopCommand = "umount /mnt/loop && notify"
+
+	testCases := []struct {
+		name             string
+		wantErr          error
+		ignoreHookErrors bool
+		mockSvc          func(logger *mocks.Logger, command *mocks.Command)
+	}{
+		{
+			name:             "should run the command and log its output",
+			wantErr:          nil,
+			ignoreHookErrors: false,
+			mockSvc: func(logger *mocks.Logger, command *mocks.Command) {
+				command.EXPECT().CombinedOutput().Return([]byte("unmounted\r\nnotified\n"), nil)
+				logger.EXPECT().Infoln("unmounted")
+				logger.EXPECT().Infoln("notified")
+			},
+		},
+		{
+			name:             "should fail if the command fails",
+			wantErr:          fmt.Errorf("the post-stop command failed: %w", errors.New("exit status 1")),
+			ignoreHookErrors: false,
+			mockSvc: func(logger *mocks.Logger, command *mocks.Command) {
+				command.EXPECT().CombinedOutput().Return([]byte("not mounted\n"), errors.New("exit status 1"))
+				logger.EXPECT().Infoln("not mounted")
+			},
+		},
+		{
+			name:             "should only warn if the command fails and hook errors are ignored",
+			wantErr:          nil,
+			ignoreHookErrors: true,
+			mockSvc: func(logger *mocks.Logger, command *mocks.Command) {
+				command.EXPECT().CombinedOutput().Return(nil, errors.New("exit status 1"))
+				logger.EXPECT().Warnf("The post-stop command failed: %v", errors.New("exit status 1"))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+			ecc := mocks.NewCommandCreator(ctrl)
+
+			getVMStatusC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+			getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+			expectUnmountGuestDataVolume(ncc, ctrl)
+			dm.EXPECT().DetachUserDataDisk().Return(nil)
+			stopC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(stopC)
+			stopC.EXPECT().CombinedOutput()
+			logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+			logger.EXPECT().Info("Finch virtual machine stopped successfully")
+
+			name, args := hostShellCommand(postStopCommand)
+			command := mocks.NewCommand(ctrl)
+			ecc.EXPECT().Create(name, args).Return(command)
+			logger.EXPECT().Infof("Running the post-stop command %q...", postStopCommand)
+			tc.mockSvc(logger, command)
+
+			action := newStopVMAction(ncc, ecc, dm, logger, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath,
+				nil, nil, nil)
+			err := action.run(stopVMOptions{postStopCommand: postStopCommand, ignoreHookErrors: tc.ignoreHookErrors})
+			assert.Equal(t, tc.wantErr, err)
+		})
+	}
+}
+
+func TestStopVMAction_runWithPostStopCommandNotStopped(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	logger := mocks.NewLogger(ctrl)
+	ncc := mocks.NewNerdctlCmdCreator(ctrl)
+	ecc := mocks.NewCommandCreator(ctrl)
+
+	getVMStatusC := mocks.NewCommand(ctrl)
+	ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+	getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+	psC := mocks.NewCommand(ctrl)
+	ncc.EXPECT().CreateWithoutStdio("shell", limaInstanceName, "sudo", "-E", "nerdctl", "ps", "-q").Return(psC)
+	psC.EXPECT().Output().Return([]byte("abc\n"), nil)
+	logger.EXPECT().Infof("Not stopping the instance %q", limaInstanceName)
+
+	action := newStopVMAction(ncc, ecc, nil, logger, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath,
+		strings.NewReader("n\n"), &bytes.Buffer{}, nil)
+	err := action.run(stopVMOptions{postStopCommand: "notify", confirmRunningContainers: true, interactive: true})
+	require.NoError(t, err)
+}
+
+func TestStopVMAction_runWithForceSkipsGuestShutdown(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	dm := mocks.NewUserDataDiskManager(ctrl)
+	logger := mocks.NewLogger(ctrl)
+	ncc := mocks.NewNerdctlCmdCreator(ctrl)
+	fs := afero.NewMemMapFs()
+	// Everything that runs in the guest before a graceful stop is enabled.
+	fc := &config.Finch{}
+	fc.Nested.GracefulStop = true
+	fc.Stop.Method = config.StopMethodSystemdPoweroff
+
+	serialLogPath := filepath.Join(mockFinchPath.LimaInstancePath(), "serial.log")
+	require.NoError(t, afero.WriteFile(fs, serialLogPath, []byte("kernel log"), 0o600))
+	logger.EXPECT().Infof("Guest kernel log saved to %q", gomock.Any())
+	logger.EXPECT().StartProgress("Forcibly stopping Finch virtual machine...").Return(func() {})
+	logger.EXPECT().Info("Finch virtual machine stopped successfully")
+	dm.EXPECT().DetachUserDataDisk().Return(nil)
+
+	stopC := mocks.NewCommand(ctrl)
+	ncc.EXPECT().CreateWithoutStdio("stop", "--force", limaInstanceName).Return(stopC)
+	stopC.EXPECT().CombinedOutput()
+	// Any other command, e.g. `nerdctl ps` or `nerdctl stop` in the guest, would slow the forced stop down.
+	ncc.EXPECT().CreateWithoutStdio(gomock.Any()).DoAndReturn(func(args ...string) command.Command {
+		t.Errorf("unexpected command run by a forced stop: %v", args)
+		return mocks.NewCommand(ctrl)
+	}).AnyTimes()
+
+	action := newStopVMAction(ncc, nil, dm, logger, fs, mockFinchPath, fc, mockFinchRootPath,
+		strings.NewReader("n\n"), &bytes.Buffer{}, nil)
+	err := action.run(stopVMOptions{force: true, confirmRunningContainers: true, interactive: true})
+	require.NoError(t, err)
+}
+
+func TestStopVMAction_runWithForeignLimaHome(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	dm := mocks.NewUserDataDiskManager(ctrl)
+	logger := mocks.NewLogger(ctrl)
+	ncc := mocks.NewNerdctlCmdCreator(ctrl)
+	fs := afero.NewMemMapFs()
+	const limaHome = "/sandbox/lima"
+
+	serialLogPath := filepath.Join(limaHome, limaInstanceName, "serial.log")
+	require.NoError(t, afero.WriteFile(fs, serialLogPath, []byte("kernel log"), 0o600))
+	logger.EXPECT().Infof("Guest kernel log saved to %q", gomock.Any())
+	logger.EXPECT().StartProgress("Forcibly stopping Finch virtual machine...").Return(func() {})
+	logger.EXPECT().Info("Finch virtual machine stopped successfully")
+	// The user data disk of Finch isn't the one of an instance of the same name in another Lima home,
+	// so it's left alone.
+	dm.EXPECT().DetachUserDataDisk().Times(0)
+
+	stopC := mocks.NewCommand(ctrl)
+	ncc.EXPECT().CreateWithoutStdio("stop", "--force", limaInstanceName).Return(stopC)
+	stopC.EXPECT().CombinedOutput()
+
+	action := newStopVMAction(ncc, nil, dm, logger, fs, mockFinchPath, &config.Finch{}, mockFinchRootPath, nil, nil, nil)
+	action.limaHome = limaHome
+	require.NoError(t, action.run(stopVMOptions{force: true}))
+}
+
+func TestStopVMAction_runWithoutForceSupport(t *testing.T) {
+	t.Parallel()
+
+	unknownFlagLogs := []byte("Error: unknown flag: --force\n")
+
+	testCases := []struct {
+		name    string
+		wantErr error
+		mockSvc func(logger *mocks.Logger, ecc *mocks.CommandCreator, ctrl *gomock.Controller, fs afero.Fs)
+	}{
+		{
+			name:    "should kill the instance processes",
+			wantErr: nil,
+			mockSvc: func(logger *mocks.Logger, ecc *mocks.CommandCreator, ctrl *gomock.Controller, fs afero.Fs) {
+				require.NoError(t, afero.WriteFile(fs, filepath.Join(mockFinchPath.LimaInstancePath(), "ha.pid"), []byte("42\n"), 0o600))
+				require.NoError(t, afero.WriteFile(fs, filepath.Join(mockFinchPath.LimaInstancePath(), "qemu.pid"), []byte("43\n"), 0o600))
+				for _, pid := range []int{42, 43} {
+					name, args := killProcessCommand(pid)
+					killC := mocks.NewCommand(ctrl)
+					ecc.EXPECT().Create(name, args).Return(killC)
+					killC.EXPECT().CombinedOutput()
+				}
+				logger.EXPECT().Info("Finch virtual machine stopped successfully")
+			},
+		},
+		{
+			name:    "should fail if there are no processes to kill",
+			wantErr: withCategory(stopErrorLimactl, fmt.Errorf("no running process found for the instance %q", limaInstanceName)),
+			mockSvc: func(logger *mocks.Logger, _ *mocks.CommandCreator, _ *gomock.Controller, _ afero.Fs) {
+				logger.EXPECT().Errorf("Finch virtual machine failed to stop, debug logs:\n%s", unknownFlagLogs)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+			ecc := mocks.NewCommandCreator(ctrl)
+			fs := afero.NewMemMapFs()
+
+			serialLogPath := filepath.Join(mockFinchPath.LimaInstancePath(), "serial.log")
+			require.NoError(t, afero.WriteFile(fs, serialLogPath, []byte("kernel log"), 0o600))
+			logger.EXPECT().Infof("Guest kernel log saved to %q", gomock.Any())
+			logger.EXPECT().StartProgress("Forcibly stopping Finch virtual machine...").Return(func() {})
+			dm.EXPECT().DetachUserDataDisk().Return(nil)
+
+			stopC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("stop", "--force", limaInstanceName).Return(stopC)
+			stopC.EXPECT().CombinedOutput().Return(unknownFlagLogs, errors.New("exit status 1"))
+			logger.EXPECT().Warnln("The installed limactl doesn't support forcibly stopping an instance, killing its processes instead...")
+			tc.mockSvc(logger, ecc, ctrl, fs)
+
+			action := newStopVMAction(ncc, ecc, dm, logger, fs, mockFinchPath, &config.Finch{}, mockFinchRootPath, nil, nil, nil)
+			err := action.run(stopVMOptions{force: true})
+			assert.Equal(t, tc.wantErr, err)
+		})
+	}
+}
+
+func TestStopVMAction_captureGuestKernelLog(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	logger := mocks.NewLogger(ctrl)
+	fs := afero.NewMemMapFs()
+
+	serialLogPath := filepath.Join(mockFinchPath.LimaInstancePath(), "serialv.log")
+	require.NoError(t, afero.WriteFile(fs, serialLogPath, []byte("kernel log"), 0o600))
+	logger.EXPECT().Infof("Guest kernel log saved to %q", gomock.Any())
+
+	action := newStopVMAction(nil, nil, nil, logger, fs, mockFinchPath, &config.Finch{}, mockFinchRootPath, nil, nil, nil)
+	action.captureGuestKernelLog(limaInstanceName)
+
+	saved, err := afero.Glob(fs, filepath.Join(mockFinchPath.DiagnosticsDir(mockFinchRootPath), "finch-kernel-*.log"))
+	require.NoError(t, err)
+	require.Len(t, saved, 1)
+	content, err := afero.ReadFile(fs, saved[0])
+	require.NoError(t, err)
+	assert.Equal(t, "kernel log", string(content))
+}
+
+func TestStopVMAction_captureGuestKernelLogCompressed(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	logger := mocks.NewLogger(ctrl)
+	fs := afero.NewMemMapFs()
+
+	serialLogPath := filepath.Join(mockFinchPath.LimaInstancePath(), "serial.log")
+	require.NoError(t, afero.WriteFile(fs, serialLogPath, []byte("kernel log"), 0o600))
+	var savedPath string
+	logger.EXPECT().Infof("Guest kernel log saved to %q", gomock.Any()).Do(func(_ string, args ...any) {
+		savedPath = args[0].(string)
+	})
+
+	action := newStopVMAction(nil, nil, nil, logger, fs, mockFinchPath, &config.Finch{}, mockFinchRootPath, nil, nil, nil)
+	action.compressLogs = true
+	action.captureGuestKernelLog(limaInstanceName)
+
+	saved, err := afero.Glob(fs, filepath.Join(mockFinchPath.DiagnosticsDir(mockFinchRootPath), "finch-kernel-*.log.gz"))
+	require.NoError(t, err)
+	require.Len(t, saved, 1)
+	assert.Equal(t, saved[0], savedPath)
+	compressed, err := afero.ReadFile(fs, saved[0])
+	require.NoError(t, err)
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	require.NoError(t, err)
+	content, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, "kernel log", string(content))
+}
+
+func TestStopVMAction_runSinceBootOnly(t *testing.T) {
+	t.Parallel()
+
+	bootTime, err := hostBootTime()
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name     string
+		metadata *lima.InstanceMetadata
+		mockSvc  func(*mocks.Logger, *mocks.NerdctlCmdCreator, *gomock.Controller, *mocks.UserDataDiskManager)
+	}{
+		{
+			name:     "should stop an instance started since the host booted",
+			metadata: &lima.InstanceMetadata{HostBootTime: bootTime},
+			mockSvc: func(logger *mocks.Logger, ncc *mocks.NerdctlCmdCreator, ctrl *gomock.Controller, dm *mocks.UserDataDiskManager) {
+				getVMStatusC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+				getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+				expectUnmountGuestDataVolume(ncc, ctrl)
+				dm.EXPECT().DetachUserDataDisk().Return(nil)
+				stopC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(stopC)
+				stopC.EXPECT().CombinedOutput()
+				logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+				logger.EXPECT().Info("Finch virtual machine stopped successfully")
+			},
+		},
+		{
+			name:     "should not stop an instance started during a previous boot of the host",
+			metadata: &lima.InstanceMetadata{HostBootTime: bootTime.Add(-24 * time.Hour)},
+			mockSvc: func(logger *mocks.Logger, _ *mocks.NerdctlCmdCreator, _ *gomock.Controller, _ *mocks.UserDataDiskManager) {
+				logger.EXPECT().Warnf("Not stopping the instance %q, it wasn't started by Finch since the host booted", limaInstanceName)
+			},
+		},
+		{
+			name:     "should not stop an instance Finch never recorded a start of",
+			metadata: nil,
+			mockSvc: func(logger *mocks.Logger, _ *mocks.NerdctlCmdCreator, _ *gomock.Controller, _ *mocks.UserDataDiskManager) {
+				logger.EXPECT().Warnf("Not stopping the instance %q, it wasn't started by Finch since the host booted", limaInstanceName)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+			fs := afero.NewMemMapFs()
+			if tc.metadata != nil {
+				require.NoError(t, lima.SaveInstanceMetadata(fs, mockFinchPath.LimaInstancePath(), tc.metadata))
+			}
+			tc.mockSvc(logger, ncc, ctrl, dm)
+
+			action := newStopVMAction(ncc, nil, dm, logger, fs, mockFinchPath, &config.Finch{}, mockFinchRootPath, nil, nil, nil)
+			assert.NoError(t, action.run(stopVMOptions{sinceBootOnly: true}))
+		})
+	}
+}
+
+func TestStopVMAction_runPastDeadline(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	dm := mocks.NewUserDataDiskManager(ctrl)
+	logger := mocks.NewLogger(ctrl)
+	ncc := mocks.NewNerdctlCmdCreator(ctrl)
+
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	action := newStopVMAction(ncc, nil, dm, logger, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath, nil, nil, nil)
+	action.ctx = ctx
+
+	err := action.run(stopVMOptions{})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.EqualError(t, err, "the deadline passed before the status phase of the stop: context deadline exceeded")
+}
+
+func TestStopVMAction_runPastDeadlineDuringStop(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	dm := mocks.NewUserDataDiskManager(ctrl)
+	logger := mocks.NewLogger(ctrl)
+	ncc := mocks.NewNerdctlCmdCreator(ctrl)
+
+	getVMStatusC := mocks.NewCommand(ctrl)
+	ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+	getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+	expectUnmountGuestDataVolume(ncc, ctrl)
+	dm.EXPECT().DetachUserDataDisk().Return(nil)
+	logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+	stopC := mocks.NewCommand(ctrl)
+	ncc.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(stopC)
+	killed := make(chan struct{})
+	stopC.EXPECT().CombinedOutput().DoAndReturn(func() ([]byte, error) {
+		<-killed
+		return nil, errors.New("signal: killed")
+	})
+	stopC.EXPECT().Kill().Do(func() { close(killed) })
+	expectReattachUserDataDisk(ncc, dm, logger, ctrl)
+
+	// limactl stop is given up on once the deadline passes, even though the stop phase has no timeout of its own.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	action := newStopVMAction(ncc, nil, dm, logger, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath, nil, nil, nil)
+	action.ctx = ctx
+
+	err := action.run(stopVMOptions{})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.EqualError(t, err, "the deadline passed during the stop phase of the stop: context deadline exceeded")
+}
+
+func TestStopVMAction_runCanceledDuringStatus(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	dm := mocks.NewUserDataDiskManager(ctrl)
+	logger := mocks.NewLogger(ctrl)
+	ncc := mocks.NewNerdctlCmdCreator(ctrl)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	getVMStatusC := mocks.NewCommand(ctrl)
+	ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+	getVMStatusC.EXPECT().Output().DoAndReturn(func() ([]byte, error) {
+		cancel()
+		return []byte("Running"), nil
+	})
+	action := newStopVMAction(ncc, nil, dm, logger, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath, nil, nil, nil)
+	action.ctx = ctx
+
+	err := action.run(stopVMOptions{})
+	assert.EqualError(t, err, "the deadline passed before the detach phase of the stop: context canceled")
+}
+
+func TestStopVMAction_runUnmountsGuestDataVolumeBeforeDetach(t *testing.T) {
+	t.Parallel()
+
+	unmountArgs := []any{
+		"shell", limaInstanceName, "sudo", "sh", "-c",
+		"systemctl stop buildkit.service; systemctl stop containerd.service; sync; " +
+			"if mountpoint -q /mnt/lima-finch; then umount --all-targets --recursive /mnt/lima-finch; fi",
+	}
+	busyErr := errors.New("exit status 32")
+	busyLogs := []byte("umount: /mnt/lima-finch: target is busy.")
+
+	testCases := []struct {
+		name string
+		// unmountErrs are the results of the successive unmount attempts.
+		unmountErrs []error
+		mockSvc     func(logger *mocks.Logger)
+	}{
+		{
+			name:        "should unmount the data volume of the guest before detaching the disk",
+			unmountErrs: []error{nil},
+			mockSvc:     func(*mocks.Logger) {},
+		},
+		{
+			name:        "should retry the unmount while the data volume of the guest is busy",
+			unmountErrs: []error{busyErr, nil},
+			mockSvc: func(logger *mocks.Logger) {
+				logger.EXPECT().Debugf("The data volume of the guest is busy, retrying the unmount: %v", busyErr)
+			},
+		},
+		{
+			name:        "should still detach the disk if the data volume of the guest stays busy",
+			unmountErrs: []error{busyErr, busyErr, busyErr},
+			mockSvc: func(logger *mocks.Logger) {
+				logger.EXPECT().Debugf("The data volume of the guest is busy, retrying the unmount: %v", busyErr).Times(2)
+				logger.EXPECT().Warnf(
+					"Could not unmount the data volume of the guest, detaching the user data disk anyway: %v, debug logs:\n%s",
+					busyErr, busyLogs)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+
+			getVMStatusC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+			getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+			tc.mockSvc(logger)
+
+			var calls []any
+			for _, err := range tc.unmountErrs {
+				unmountC := mocks.NewCommand(ctrl)
+				var logs []byte
+				if err != nil {
+					logs = busyLogs
+				}
+				calls = append(calls,
+					ncc.EXPECT().CreateWithoutStdio(unmountArgs...).Return(unmountC),
+					unmountC.EXPECT().CombinedOutput().Return(logs, err))
+			}
+			// The stop command is created upfront, it only runs once the disk is detached.
+			stopC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(stopC)
+			calls = append(calls, dm.EXPECT().DetachUserDataDisk().Return(nil), stopC.EXPECT().CombinedOutput())
+			gomock.InOrder(calls...)
+			logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+			logger.EXPECT().Info("Finch virtual machine stopped successfully")
+
+			action := newStopVMAction(ncc, nil, dm, logger, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath,
+				nil, nil, nil)
+			require.NoError(t, action.run(stopVMOptions{}))
+		})
+	}
+}
+
+// recordingListener records the lifecycle events it's notified of.
+type recordingListener struct {
+	events []string
+	result lima.StopResult
+	err    error
+}
+
+func (l *recordingListener) OnStopBegin(instance string) {
+	l.events = append(l.events, "begin "+instance)
+}
+
+func (l *recordingListener) OnStopComplete(result lima.StopResult) {
+	l.events = append(l.events, "complete "+result.Instance)
+	l.result = result
+}
+
+func (l *recordingListener) OnStopError(instance string, err error) {
+	l.events = append(l.events, "error "+instance)
+	l.err = err
+}
+
+func TestStopVMAction_runNotifiesLifecycleListeners(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name       string
+		stopErr    error
+		wantEvents []string
+	}{
+		{
+			name:       "should notify the listeners of a stop that went through",
+			wantEvents: []string{"begin " + limaInstanceName, "complete " + limaInstanceName},
+		},
+		{
+			name:       "should notify the listeners of a stop that failed",
+			stopErr:    errors.New("exit status 1"),
+			wantEvents: []string{"begin " + limaInstanceName, "error " + limaInstanceName},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+
+			getVMStatusC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+			getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+			expectUnmountGuestDataVolume(ncc, ctrl)
+			dm.EXPECT().DetachUserDataDisk().Return(nil)
+			stopC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(stopC)
+			stopC.EXPECT().CombinedOutput().Return(nil, tc.stopErr)
+			logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+			if tc.stopErr != nil {
+				logger.EXPECT().Errorf("Finch virtual machine failed to stop, debug logs:\n%s", []byte(nil))
+				expectReattachUserDataDisk(ncc, dm, logger, ctrl)
+			} else {
+				logger.EXPECT().Info("Finch virtual machine stopped successfully")
+			}
+
+			listener := &recordingListener{}
+			action := newStopVMAction(ncc, nil, dm, logger, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath,
+				nil, nil, nil)
+			action.listeners = []lima.LifecycleListener{listener}
+			err := action.run(stopVMOptions{})
+			wantErr := withCategory(stopErrorLimactl, tc.stopErr)
+			assert.Equal(t, wantErr, err)
+			assert.Equal(t, tc.wantEvents, listener.events)
+			assert.Equal(t, wantErr, listener.err)
+			if tc.stopErr == nil {
+				assert.Equal(t, limaInstanceName, listener.result.Instance)
+				assert.True(t, listener.result.Stopped)
+			}
+		})
+	}
+}
+
+func TestStopVMAction_runReportsLimactlExitCode(t *testing.T) {
+	t.Parallel()
+
+	// The test binary exits with code 2 on an unknown flag, which makes for a real exit error on every platform.
+	var exitErr *exec.ExitError
+	require.ErrorAs(t, exec.Command(os.Args[0], "-test.unknown-flag").Run(), &exitErr)
+
+	ctrl := gomock.NewController(t)
+	dm := mocks.NewUserDataDiskManager(ctrl)
+	logger := mocks.NewLogger(ctrl)
+	ncc := mocks.NewNerdctlCmdCreator(ctrl)
+
+	getVMStatusC := mocks.NewCommand(ctrl)
+	ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+	getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+	expectUnmountGuestDataVolume(ncc, ctrl)
+	dm.EXPECT().DetachUserDataDisk().Return(nil)
+	stopC := mocks.NewCommand(ctrl)
+	ncc.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(stopC)
+	logs := []byte("level=fatal msg=\"failed to stop\"")
+	stopC.EXPECT().CombinedOutput().Return(logs, exitErr)
+	logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+	logger.EXPECT().Errorf("Finch virtual machine failed to stop, limactl stop exited with code %d, debug logs:\n%s", 2, logs)
+	expectReattachUserDataDisk(ncc, dm, logger, ctrl)
+
+	action := newStopVMAction(ncc, nil, dm, logger, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath,
+		nil, nil, nil)
+	err := action.run(stopVMOptions{})
+	assert.EqualError(t, err, "limactl stop exited with code 2: exit status 2")
+	assert.ErrorIs(t, err, exitErr)
+}
+
+func TestStopVMAction_runWithLimactlTerminatedBySignal(t *testing.T) {
+	t.Parallel()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("processes aren't terminated by signals on Windows")
+	}
+	var exitErr *exec.ExitError
+	require.ErrorAs(t, exec.Command("sh", "-c", "kill -KILL $$").Run(), &exitErr)
+
+	ctrl := gomock.NewController(t)
+	dm := mocks.NewUserDataDiskManager(ctrl)
+	logger := mocks.NewLogger(ctrl)
+	ncc := mocks.NewNerdctlCmdCreator(ctrl)
+
+	getVMStatusC := mocks.NewCommand(ctrl)
+	ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+	getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+	expectUnmountGuestDataVolume(ncc, ctrl)
+	dm.EXPECT().DetachUserDataDisk().Return(nil)
+	stopC := mocks.NewCommand(ctrl)
+	ncc.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(stopC)
+	stopC.EXPECT().CombinedOutput().Return(nil, exitErr)
+	logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+	logger.EXPECT().Errorf("Finch virtual machine failed to stop, limactl stop was terminated by signal %d (%s), debug logs:\n%s",
+		9, syscall.SIGKILL, []byte(nil))
+	expectReattachUserDataDisk(ncc, dm, logger, ctrl)
+
+	action := newStopVMAction(ncc, nil, dm, logger, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath,
+		nil, nil, nil)
+	err := action.run(stopVMOptions{})
+	assert.EqualError(t, err, "limactl stop was terminated by signal 9 (killed): signal: killed")
+	assert.ErrorIs(t, err, exitErr)
+}
+
+func TestStopVMAction_runVerifyDiskDetached(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		attached bool
+		checkErr error
+		mockSvc  func(*mocks.Logger)
+		wantErr  error
+	}{
+		{
+			name:    "should succeed if the user data disk is detached",
+			mockSvc: func(logger *mocks.Logger) { logger.EXPECT().Debugln("Verified that the user data disk is detached") },
+		},
+		{
+			name:     "should fail if the user data disk is still attached",
+			attached: true,
+			mockSvc:  func(*mocks.Logger) {},
+			wantErr:  withCategory(stopErrorDisk, errors.New("the user data disk is still attached after the stop")),
+		},
+		{
+			name:     "should fail if the user data disk can't be checked",
+			checkErr: errors.New("access denied"),
+			mockSvc:  func(*mocks.Logger) {},
+			wantErr: withCategory(stopErrorDisk,
+				fmt.Errorf("failed to verify that the user data disk is detached: %w", errors.New("access denied"))),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+
+			getVMStatusC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+			getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+			expectUnmountGuestDataVolume(ncc, ctrl)
+			stopC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(stopC)
+			logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+			gomock.InOrder(
+				dm.EXPECT().DetachUserDataDisk().Return(nil),
+				stopC.EXPECT().CombinedOutput(),
+				logger.EXPECT().Info("Finch virtual machine stopped successfully"),
+				dm.EXPECT().UserDataDiskAttached().Return(tc.attached, tc.checkErr),
+			)
+			tc.mockSvc(logger)
+
+			action := newStopVMAction(ncc, nil, dm, logger, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath,
+				nil, nil, nil)
+			err := action.run(stopVMOptions{verifyDiskDetached: true})
+			assert.Equal(t, tc.wantErr, err)
+		})
+	}
+}
+
+func TestStopVMAction_waitForStatus(t *testing.T) {
+	t.Parallel()
+
+	t.Run("should poll the status at the configured interval", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		ncc := mocks.NewNerdctlCmdCreator(ctrl)
+		runningC := mocks.NewCommand(ctrl)
+		stoppedC := mocks.NewCommand(ctrl)
+		gomock.InOrder(
+			ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(runningC),
+			runningC.EXPECT().Output().Return([]byte("Running"), nil),
+			ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(stoppedC),
+			stoppedC.EXPECT().Output().Return([]byte("Stopped"), nil),
+		)
+		fc := &config.Finch{}
+		fc.Stop.VerifyPollInterval = 50 * time.Millisecond
+
+		action := newStopVMAction(ncc, nil, nil, nil, afero.NewMemMapFs(), mockFinchPath, fc, mockFinchRootPath, nil, nil, nil)
+		start := time.Now()
+		require.NoError(t, action.waitForStatus(limaInstanceName, lima.Stopped, action.verifyTimeout()))
+		assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+	})
+
+	t.Run("should give the guest powering off 2 minutes to stop by default", func(t *testing.T) {
+		t.Parallel()
+
+		action := newStopVMAction(nil, nil, nil, nil, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath,
+			nil, nil, nil)
+		assert.Equal(t, 2*time.Minute, action.verifyTimeout())
+	})
+
+	t.Run("should give up after the configured timeout", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		ncc := mocks.NewNerdctlCmdCreator(ctrl)
+		runningC := mocks.NewCommand(ctrl)
+		ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(runningC).MinTimes(2)
+		runningC.EXPECT().Output().Return([]byte("Running"), nil).MinTimes(2)
+		fc := &config.Finch{}
+		fc.Stop.VerifyPollInterval = 10 * time.Millisecond
+		fc.Stop.VerifyTimeout = 30 * time.Millisecond
+
+		action := newStopVMAction(ncc, nil, nil, nil, afero.NewMemMapFs(), mockFinchPath, fc, mockFinchRootPath, nil, nil, nil)
+		assert.ErrorIs(t, action.waitForStatus(limaInstanceName, lima.Stopped, action.verifyTimeout()), errTimedOut)
+	})
+
+	t.Run("should give up once the deadline has passed", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		ncc := mocks.NewNerdctlCmdCreator(ctrl)
+		runningC := mocks.NewCommand(ctrl)
+		ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(runningC).MinTimes(2)
+		runningC.EXPECT().Output().Return([]byte("Running"), nil).MinTimes(2)
+		fc := &config.Finch{}
+		fc.Stop.VerifyPollInterval = 10 * time.Millisecond
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+		defer cancel()
+		action := newStopVMAction(ncc, nil, nil, nil, afero.NewMemMapFs(), mockFinchPath, fc, mockFinchRootPath, nil, nil, nil)
+		action.ctx = ctx
+		err := action.waitForStatus(limaInstanceName, lima.Stopped, time.Minute)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.EqualError(t, err, "the deadline passed during the stop phase of the stop: context deadline exceeded")
+	})
+}
+
+func TestStopVMAction_runWithoutLimactl(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	ncc := mocks.NewNerdctlCmdCreator(ctrl)
+	getVMStatusC := mocks.NewCommand(ctrl)
+	ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+	getVMStatusC.EXPECT().Output().Return(nil, &exec.Error{Name: "limactl", Err: exec.ErrNotFound})
+
+	action := newStopVMAction(ncc, nil, nil, nil, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath,
+		nil, nil, nil)
+	err := action.run(stopVMOptions{})
+	assert.EqualError(t, err, "limactl not found on PATH; reinstall finch or run 'finch vm init'")
+}
+
+func TestStopVMAction_limaStopArgs(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		template string
+		force    bool
+		want     []string
+		wantErr  string
+	}{
+		{
+			name: "should stop the instance by default",
+			want: []string{"stop", limaInstanceName},
+		},
+		{
+			name:  "should forcibly stop the instance by default",
+			force: true,
+			want:  []string{"stop", "--force", limaInstanceName},
+		},
+		{
+			name:     "should render the template",
+			template: "stop {{if .Force}}--force {{end}}--log-level=debug {{.Instance}}",
+			force:    true,
+			want:     []string{"stop", "--force", "--log-level=debug", limaInstanceName},
+		},
+		{
+			name:     "should fail if the template renders to an empty command",
+			template: "{{if .Force}}stop --force {{.Instance}}{{end}}",
+			wantErr:  `stop.commandTemplate "{{if .Force}}stop --force {{.Instance}}{{end}}" renders to an empty command`,
+		},
+		{
+			name:     "should fail if the template can't be parsed",
+			template: "stop {{.Instance",
+			wantErr:  "failed to parse stop.commandTemplate: ",
+		},
+		{
+			name:     "should fail if the template can't be rendered",
+			template: "stop {{.Name}}",
+			wantErr:  "failed to render stop.commandTemplate: ",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			fc := &config.Finch{}
+			fc.Stop.CommandTemplate = tc.template
+			action := newStopVMAction(nil, nil, nil, nil, afero.NewMemMapFs(), mockFinchPath, fc, mockFinchRootPath, nil, nil, nil)
+			args, err := action.limaStopArgs(limaInstanceName, tc.force)
+			if tc.wantErr != "" {
+				assert.ErrorContains(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, args)
+		})
+	}
+}
+
+func TestStopVMAction_runWithCommandTemplate(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	dm := mocks.NewUserDataDiskManager(ctrl)
+	logger := mocks.NewLogger(ctrl)
+	ncc := mocks.NewNerdctlCmdCreator(ctrl)
+
+	getVMStatusC := mocks.NewCommand(ctrl)
+	ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+	getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+	expectUnmountGuestDataVolume(ncc, ctrl)
+	dm.EXPECT().DetachUserDataDisk().Return(nil)
+	stopC := mocks.NewCommand(ctrl)
+	ncc.EXPECT().CreateWithoutStdio("--tty=false", "stop", limaInstanceName).Return(stopC)
+	stopC.EXPECT().CombinedOutput()
+	logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+	logger.EXPECT().Info("Finch virtual machine stopped successfully")
+
+	fc := &config.Finch{}
+	fc.Stop.CommandTemplate = "--tty=false stop {{if .Force}}--force {{end}}{{.Instance}}"
+	action := newStopVMAction(ncc, nil, dm, logger, afero.NewMemMapFs(), mockFinchPath, fc, mockFinchRootPath, nil, nil, nil)
+	require.NoError(t, action.run(stopVMOptions{}))
+}
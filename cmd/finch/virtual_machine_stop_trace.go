@@ -0,0 +1,143 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/runfinch/finch/pkg/version"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The environment variables of the OpenTelemetry SDKs configuring the OTLP exporter, which the stop follows too
+// for its trace to go wherever the rest of the tooling of the user sends theirs.
+const (
+	otlpEndpointEnv       = "OTEL_EXPORTER_OTLP_ENDPOINT"
+	otlpTracesEndpointEnv = "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"
+	otlpHeadersEnv        = "OTEL_EXPORTER_OTLP_HEADERS"
+)
+
+// stopTraceTimeout bounds how long a stop can be held up by a slow collector.
+const stopTraceTimeout = 5 * time.Second
+
+// otlpTracesEndpoint returns the URL the trace of the stop is exported to, empty if tracing is disabled.
+// Like with the OpenTelemetry SDKs, the traces endpoint is used as is and the general one gets the path of the traces.
+func otlpTracesEndpoint(lookupEnv func(string) (string, bool)) string {
+	if endpoint, ok := lookupEnv(otlpTracesEndpointEnv); ok && endpoint != "" {
+		return endpoint
+	}
+	if endpoint, ok := lookupEnv(otlpEndpointEnv); ok && endpoint != "" {
+		return strings.TrimSuffix(endpoint, "/") + "/v1/traces"
+	}
+	return ""
+}
+
+// otlpHeaders parses OTEL_EXPORTER_OTLP_HEADERS, e.g. "api-key=secret,tenant=dev", whose values are URL encoded.
+func otlpHeaders(lookupEnv func(string) (string, bool)) (map[string]string, error) {
+	headers := map[string]string{}
+	value, ok := lookupEnv(otlpHeadersEnv)
+	if !ok || value == "" {
+		return headers, nil
+	}
+	for _, pair := range strings.Split(value, ",") {
+		key, val, found := strings.Cut(pair, "=")
+		if !found || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("invalid header %q in %s, it must be key=value", pair, otlpHeadersEnv)
+		}
+		val, err := url.QueryUnescape(strings.TrimSpace(val))
+		if err != nil {
+			return nil, fmt.Errorf("invalid header %q in %s: %w", pair, otlpHeadersEnv, err)
+		}
+		headers[strings.TrimSpace(key)] = val
+	}
+	return headers, nil
+}
+
+// phaseSpan is a phase of the stop, which becomes a child span of the span of the stop.
+type phaseSpan struct {
+	name  string
+	start time.Time
+	end   time.Time
+}
+
+// exportTrace exports the trace of the stop to the OTLP endpoint. Tracing is best-effort: failures are logged
+// and never fail the stop.
+func (sva *stopVMAction) exportTrace(instance string, forced bool, start time.Time, result stopResult) {
+	if err := sva.exportStopTrace(instance, forced, start, result); err != nil {
+		sva.logger.Warnf("Could not export the trace of the stop to %q: %v", sva.traceEndpoint, err)
+		return
+	}
+	sva.logger.Debugf("Exported the trace of the stop to %q", sva.traceEndpoint)
+}
+
+func (sva *stopVMAction) exportStopTrace(instance string, forced bool, start time.Time, result stopResult) error {
+	headers, err := otlpHeaders(sva.lookupEnv)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), stopTraceTimeout)
+	defer cancel()
+	// A stop isn't held up retrying the export, the timeout bounds the one attempt.
+	exporter, err := otlptracehttp.New(ctx,
+		otlptracehttp.WithEndpointURL(sva.traceEndpoint),
+		otlptracehttp.WithHeaders(headers),
+		otlptracehttp.WithTimeout(stopTraceTimeout),
+		otlptracehttp.WithRetry(otlptracehttp.RetryConfig{Enabled: false}),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create the exporter: %w", err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceName("finch"),
+			semconv.ServiceVersion(version.Version),
+		)),
+	)
+	recordStopTrace(provider.Tracer("github.com/runfinch/finch", trace.WithInstrumentationVersion(version.Version)),
+		instance, forced, start, time.Now(), sva.spans, result)
+	// The batch is exported when it's flushed, which is what reports whether the export succeeded.
+	if err := provider.ForceFlush(ctx); err != nil {
+		_ = provider.Shutdown(ctx)
+		return err
+	}
+	return provider.Shutdown(ctx)
+}
+
+// recordStopTrace records the trace of a stop: a span of the stop, with the instance, whether it was forced
+// and its result, and a child span per phase it went through.
+func recordStopTrace(tracer trace.Tracer, instance string, forced bool, start, end time.Time, phases []phaseSpan, result stopResult) {
+	ctx, root := tracer.Start(context.Background(), "finch vm stop",
+		trace.WithTimestamp(start),
+		trace.WithAttributes(
+			attribute.String("finch.instance", instance),
+			attribute.Bool("finch.forced", forced),
+			attribute.String("finch.result", result.Result),
+		),
+	)
+	if result.Result == stopResultFailed {
+		root.SetAttributes(attribute.String("finch.error_category", result.ErrorCategory))
+		root.SetStatus(codes.Error, result.Error)
+	} else {
+		root.SetStatus(codes.Ok, "")
+	}
+	for _, phase := range phases {
+		_, span := tracer.Start(ctx, phase.name, trace.WithTimestamp(phase.start))
+		span.End(trace.WithTimestamp(phase.end))
+	}
+	root.End(trace.WithTimestamp(end))
+}
@@ -0,0 +1,218 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/mocks"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"go.uber.org/mock/gomock"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestOTLPTracesEndpoint(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{
+			name: "should disable tracing without an endpoint",
+			env:  map[string]string{},
+			want: "",
+		},
+		{
+			name: "should export to the path of the traces under the endpoint",
+			env:  map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": "http://localhost:4318/"},
+			want: "http://localhost:4318/v1/traces",
+		},
+		{
+			name: "should export to the traces endpoint as is over the endpoint",
+			env: map[string]string{
+				"OTEL_EXPORTER_OTLP_ENDPOINT":        "http://localhost:4318",
+				"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT": "http://collector:4318/traces",
+			},
+			want: "http://collector:4318/traces",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			lookupEnv := func(key string) (string, bool) {
+				v, ok := tc.env[key]
+				return v, ok
+			}
+			assert.Equal(t, tc.want, otlpTracesEndpoint(lookupEnv))
+		})
+	}
+}
+
+func TestOTLPHeaders(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name    string
+		headers string
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name:    "should parse the URL encoded headers",
+			headers: "api-key=s3cr3t, x-tenant=dev%20team",
+			want:    map[string]string{"api-key": "s3cr3t", "x-tenant": "dev team"},
+		},
+		{
+			name:    "should reject a header without a value",
+			headers: "api-key",
+			wantErr: `invalid header "api-key" in OTEL_EXPORTER_OTLP_HEADERS, it must be key=value`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			headers, err := otlpHeaders(func(string) (string, bool) { return tc.headers, true })
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, headers)
+		})
+	}
+}
+
+func TestStopVMAction_runWithTracing(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name           string
+		wantErr        error
+		wantResult     string
+		wantStatusCode tracepb.Status_StatusCode
+		mockSvc        func(logger *mocks.Logger, dm *mocks.UserDataDiskManager, stopC *mocks.Command)
+	}{
+		{
+			name:           "should export the trace of a successful stop",
+			wantResult:     stopResultStopped,
+			wantStatusCode: tracepb.Status_STATUS_CODE_OK,
+			mockSvc: func(logger *mocks.Logger, _ *mocks.UserDataDiskManager, stopC *mocks.Command) {
+				stopC.EXPECT().CombinedOutput()
+				logger.EXPECT().Info("Finch virtual machine stopped successfully")
+			},
+		},
+		{
+			name:           "should export the trace of a failed stop",
+			wantErr:        withCategory(stopErrorLimactl, errors.New("exit status 1")),
+			wantResult:     stopResultFailed,
+			wantStatusCode: tracepb.Status_STATUS_CODE_ERROR,
+			mockSvc: func(logger *mocks.Logger, _ *mocks.UserDataDiskManager, stopC *mocks.Command) {
+				stopC.EXPECT().CombinedOutput().Return([]byte("error"), errors.New("exit status 1"))
+				logger.EXPECT().Errorf("Finch virtual machine failed to stop, debug logs:\n%s", []byte("error"))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			traces := make(chan *collectortrace.ExportTraceServiceRequest, 1)
+			server := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, "/v1/traces", r.URL.Path)
+				assert.Equal(t, "s3cr3t", r.Header.Get("api-key"))
+				body, err := io.ReadAll(r.Body)
+				assert.NoError(t, err)
+				trace := &collectortrace.ExportTraceServiceRequest{}
+				assert.NoError(t, proto.Unmarshal(body, trace))
+				traces <- trace
+			}))
+			defer server.Close()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+
+			getVMStatusC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+			getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+			expectUnmountGuestDataVolume(ncc, ctrl)
+			dm.EXPECT().DetachUserDataDisk().Return(nil)
+			stopC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(stopC)
+			logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+			tc.mockSvc(logger, dm, stopC)
+			if tc.wantErr != nil {
+				expectReattachUserDataDisk(ncc, dm, logger, ctrl)
+			}
+			logger.EXPECT().Debugf("Exported the trace of the stop to %q", server.URL+"/v1/traces")
+
+			action := newStopVMAction(ncc, nil, dm, logger, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath,
+				nil, nil, nil)
+			env := map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": server.URL, "OTEL_EXPORTER_OTLP_HEADERS": "api-key=s3cr3t"}
+			action.lookupEnv = func(key string) (string, bool) {
+				v, ok := env[key]
+				return v, ok
+			}
+			err := action.run(stopVMOptions{})
+			assert.Equal(t, tc.wantErr, err)
+
+			trace := <-traces
+			require.Len(t, trace.GetResourceSpans(), 1)
+			require.Len(t, trace.GetResourceSpans()[0].GetScopeSpans(), 1)
+			spans := trace.GetResourceSpans()[0].GetScopeSpans()[0].GetSpans()
+			var root *tracepb.Span
+			var phases []*tracepb.Span
+			for _, span := range spans {
+				if span.GetName() == "finch vm stop" {
+					root = span
+				} else {
+					phases = append(phases, span)
+				}
+			}
+			require.NotNil(t, root)
+			assert.Equal(t, tc.wantStatusCode, root.GetStatus().GetCode())
+			attributes := map[string]any{}
+			for _, a := range root.GetAttributes() {
+				switch v := a.GetValue().GetValue().(type) {
+				case *commonpb.AnyValue_StringValue:
+					attributes[a.GetKey()] = v.StringValue
+				case *commonpb.AnyValue_BoolValue:
+					attributes[a.GetKey()] = v.BoolValue
+				}
+			}
+			assert.Equal(t, limaInstanceName, attributes["finch.instance"])
+			assert.Equal(t, false, attributes["finch.forced"])
+			assert.Equal(t, tc.wantResult, attributes["finch.result"])
+
+			var names []string
+			for _, span := range phases {
+				assert.Equal(t, root.GetTraceId(), span.GetTraceId())
+				assert.Equal(t, root.GetSpanId(), span.GetParentSpanId())
+				names = append(names, span.GetName())
+			}
+			assert.Equal(t, []string{"status", "detach", "stop"}, names)
+		})
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/runfinch/finch/pkg/lima"
+)
+
+// uninstall stops the Finch VM and removes it along with its host socket, and its user data disk if removeUserData
+// is set, for the uninstallers to leave a clean slate behind. Every step runs even if the previous ones failed,
+// so that as little as possible is left behind, and the errors are returned together.
+//
+// Finch doesn't register any launchd job or scheduled task, but the ones named after it are reported, as they
+// would outlive the uninstall.
+func (sva *stopVMAction) uninstall(force, removeUserData bool) error {
+	sva.logger.Info("Uninstalling Finch virtual machine...")
+	var errs []error
+
+	status, err := lima.Status(sva.creator, limaInstanceName)
+	var unknownStatusErr *lima.UnknownStatusError
+	if err != nil && !errors.As(err, &unknownStatusErr) {
+		errs = append(errs, err)
+	}
+	if status == lima.Running {
+		if err := sva.stopForUninstall(force); err != nil {
+			errs = append(errs, fmt.Errorf("failed to stop the instance: %w", err))
+		}
+	}
+	if status != lima.Nonexistent {
+		// The instance is removed even if it couldn't be stopped, which kills what's left of it.
+		if logs, err := sva.creator.CreateWithoutStdio("remove", "--force", limaInstanceName).CombinedOutput(); err != nil {
+			sva.logger.Errorf("Finch virtual machine failed to remove, debug logs:\n%s", logs)
+			errs = append(errs, fmt.Errorf("failed to remove the instance: %w", err))
+		}
+	}
+	if removeUserData {
+		if err := sva.diskManager.RemoveUserDataDisk(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove the user data disk: %w", err))
+		}
+	} else {
+		sva.logger.Infoln("Kept the user data disk, which the next installation of Finch uses again")
+	}
+	sva.removeHostSocket(limaInstanceName)
+	services, err := sva.hostServices()
+	switch {
+	case err != nil:
+		sva.logger.Warnf("Could not check for host services named after Finch: %v", err)
+	case len(services) > 0:
+		sva.logger.Warnf("Finch doesn't register any host service, but these are named after it, remove them if they "+
+			"belong to it: %s", strings.Join(services, ", "))
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	sva.logger.Info("Finch virtual machine uninstalled successfully")
+	return nil
+}
+
+// stopForUninstall stops the Finch VM gracefully unless force is set, and forcibly if that fails.
+func (sva *stopVMAction) stopForUninstall(force bool) error {
+	if !force {
+		err := sva.stopVM(limaInstanceName, false)
+		if err == nil {
+			return nil
+		}
+		sva.logger.Warnf("Finch virtual machine failed to stop, forcibly stopping it: %v", err)
+	}
+	return sva.stopVM(limaInstanceName, true)
+}
+
+// finchServices returns the names that look like they belong to Finch, sorted and without duplicates.
+func finchServices(names []string) []string {
+	var services []string
+	for _, name := range names {
+		if strings.Contains(strings.ToLower(name), "finch") {
+			services = append(services, name)
+		}
+	}
+	slices.Sort(services)
+	return slices.Compact(services)
+}
@@ -0,0 +1,268 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/mocks"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+// expectHostServices expects the launchd jobs or the scheduled tasks to be listed, with the given names among them.
+func expectHostServices(ecc *mocks.CommandCreator, ctrl *gomock.Controller, names ...string) {
+	listC := mocks.NewCommand(ctrl)
+	if runtime.GOOS == "windows" {
+		var out strings.Builder
+		for _, name := range append([]string{`\Microsoft\Windows\Defrag\ScheduledDefrag`}, names...) {
+			fmt.Fprintf(&out, "%q,\"N/A\",\"Ready\"\n", name)
+		}
+		ecc.EXPECT().Create("schtasks", "/query", "/fo", "csv", "/nh").Return(listC)
+		listC.EXPECT().Output().Return([]byte(out.String()), nil)
+		return
+	}
+	out := "PID\tStatus\tLabel\n-\t0\tcom.apple.Safari.History\n"
+	for _, name := range names {
+		out += "123\t0\t" + name + "\n"
+	}
+	ecc.EXPECT().Create("launchctl", "list").Return(listC)
+	listC.EXPECT().Output().Return([]byte(out), nil)
+}
+
+func TestStopVMAction_runUninstall(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name           string
+		status         string
+		force          bool
+		removeUserData bool
+		services       []string
+		mockSvc        func(t *testing.T, ncc *mocks.NerdctlCmdCreator, dm *mocks.UserDataDiskManager, logger *mocks.Logger, fs afero.Fs,
+			ctrl *gomock.Controller)
+		wantErr error
+	}{
+		{
+			name:           "should stop the VM, then remove it along with its user data disk",
+			status:         "Running",
+			removeUserData: true,
+			mockSvc: func(
+				_ *testing.T,
+				ncc *mocks.NerdctlCmdCreator,
+				dm *mocks.UserDataDiskManager,
+				logger *mocks.Logger,
+				_ afero.Fs,
+				ctrl *gomock.Controller,
+			) {
+				expectGracefulStop(ncc, dm, logger, ctrl)
+				removeC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("remove", "--force", limaInstanceName).Return(removeC)
+				removeC.EXPECT().CombinedOutput()
+				dm.EXPECT().RemoveUserDataDisk().Return(nil)
+				logger.EXPECT().Info("Finch virtual machine uninstalled successfully")
+			},
+		},
+		{
+			name:           "should forcibly stop the VM if it fails to stop",
+			status:         "Running",
+			removeUserData: true,
+			mockSvc: func(
+				t *testing.T,
+				ncc *mocks.NerdctlCmdCreator,
+				dm *mocks.UserDataDiskManager,
+				logger *mocks.Logger,
+				fs afero.Fs,
+				ctrl *gomock.Controller,
+			) {
+				expectUnmountGuestDataVolume(ncc, ctrl)
+				dm.EXPECT().DetachUserDataDisk().Return(nil)
+				stopC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(stopC)
+				stopC.EXPECT().CombinedOutput().Return([]byte("guest agent unresponsive"), errors.New("exit status 1"))
+				logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+				logger.EXPECT().Errorf("Finch virtual machine failed to stop, debug logs:\n%s", []byte("guest agent unresponsive"))
+				expectReattachUserDataDisk(ncc, dm, logger, ctrl)
+				logger.EXPECT().Warnf("Finch virtual machine failed to stop, forcibly stopping it: %v", gomock.Any())
+				serialLogPath := filepath.Join(mockFinchPath.LimaInstancePath(), "serial.log")
+				require.NoError(t, afero.WriteFile(fs, serialLogPath, []byte("kernel log"), 0o600))
+				logger.EXPECT().Infof("Guest kernel log saved to %q", gomock.Any())
+				dm.EXPECT().DetachUserDataDisk().Return(nil)
+				forceStopC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("stop", "--force", limaInstanceName).Return(forceStopC)
+				forceStopC.EXPECT().CombinedOutput()
+				logger.EXPECT().StartProgress("Forcibly stopping Finch virtual machine...").Return(func() {})
+				logger.EXPECT().Info("Finch virtual machine stopped successfully")
+				removeC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("remove", "--force", limaInstanceName).Return(removeC)
+				removeC.EXPECT().CombinedOutput()
+				dm.EXPECT().RemoveUserDataDisk().Return(nil)
+				logger.EXPECT().Info("Finch virtual machine uninstalled successfully")
+			},
+		},
+		{
+			name:   "should forcibly stop the VM right away with --force",
+			status: "Running",
+			force:  true,
+			mockSvc: func(
+				t *testing.T,
+				ncc *mocks.NerdctlCmdCreator,
+				dm *mocks.UserDataDiskManager,
+				logger *mocks.Logger,
+				fs afero.Fs,
+				ctrl *gomock.Controller,
+			) {
+				serialLogPath := filepath.Join(mockFinchPath.LimaInstancePath(), "serial.log")
+				require.NoError(t, afero.WriteFile(fs, serialLogPath, []byte("kernel log"), 0o600))
+				logger.EXPECT().Infof("Guest kernel log saved to %q", gomock.Any())
+				dm.EXPECT().DetachUserDataDisk().Return(nil)
+				forceStopC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("stop", "--force", limaInstanceName).Return(forceStopC)
+				forceStopC.EXPECT().CombinedOutput()
+				logger.EXPECT().StartProgress("Forcibly stopping Finch virtual machine...").Return(func() {})
+				logger.EXPECT().Info("Finch virtual machine stopped successfully")
+				removeC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("remove", "--force", limaInstanceName).Return(removeC)
+				removeC.EXPECT().CombinedOutput()
+				logger.EXPECT().Infoln("Kept the user data disk, which the next installation of Finch uses again")
+				logger.EXPECT().Info("Finch virtual machine uninstalled successfully")
+			},
+		},
+		{
+			name:           "should remove the user data disk even if the instance fails to be removed",
+			status:         "Stopped",
+			removeUserData: true,
+			mockSvc: func(
+				_ *testing.T,
+				ncc *mocks.NerdctlCmdCreator,
+				dm *mocks.UserDataDiskManager,
+				logger *mocks.Logger,
+				_ afero.Fs,
+				ctrl *gomock.Controller,
+			) {
+				removeC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("remove", "--force", limaInstanceName).Return(removeC)
+				removeC.EXPECT().CombinedOutput().Return([]byte("permission denied"), errors.New("exit status 1"))
+				logger.EXPECT().Errorf("Finch virtual machine failed to remove, debug logs:\n%s", []byte("permission denied"))
+				dm.EXPECT().RemoveUserDataDisk().Return(nil)
+			},
+			wantErr: errors.Join(fmt.Errorf("failed to remove the instance: %w", errors.New("exit status 1"))),
+		},
+		{
+			name:     "should report the host services named after Finch",
+			status:   "Stopped",
+			services: []string{"com.example.finch-prune", "com.example.finch-prune"},
+			mockSvc: func(
+				_ *testing.T,
+				ncc *mocks.NerdctlCmdCreator,
+				_ *mocks.UserDataDiskManager,
+				logger *mocks.Logger,
+				_ afero.Fs,
+				ctrl *gomock.Controller,
+			) {
+				removeC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("remove", "--force", limaInstanceName).Return(removeC)
+				removeC.EXPECT().CombinedOutput()
+				logger.EXPECT().Infoln("Kept the user data disk, which the next installation of Finch uses again")
+				logger.EXPECT().Warnf("Finch doesn't register any host service, but these are named after it, remove them if they "+
+					"belong to it: %s", "com.example.finch-prune")
+				logger.EXPECT().Info("Finch virtual machine uninstalled successfully")
+			},
+		},
+		{
+			name:           "should only remove the user data disk if the instance doesn't exist",
+			status:         "",
+			removeUserData: true,
+			mockSvc: func(
+				_ *testing.T,
+				_ *mocks.NerdctlCmdCreator,
+				dm *mocks.UserDataDiskManager,
+				logger *mocks.Logger,
+				_ afero.Fs,
+				_ *gomock.Controller,
+			) {
+				dm.EXPECT().RemoveUserDataDisk().Return(nil)
+				logger.EXPECT().Info("Finch virtual machine uninstalled successfully")
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+			ecc := mocks.NewCommandCreator(ctrl)
+
+			logger.EXPECT().Info("Uninstalling Finch virtual machine...")
+			getVMStatusC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+			getVMStatusC.EXPECT().Output().Return([]byte(tc.status), nil)
+			fs := afero.NewMemMapFs()
+			tc.mockSvc(t, ncc, dm, logger, fs, ctrl)
+			expectHostServices(ecc, ctrl, tc.services...)
+
+			action := newStopVMAction(ncc, ecc, dm, logger, fs, mockFinchPath, &config.Finch{}, mockFinchRootPath,
+				nil, nil, nil)
+			err := action.run(stopVMOptions{uninstall: true, yes: true, force: tc.force, removeUserData: tc.removeUserData})
+			assert.Equal(t, tc.wantErr, err)
+		})
+	}
+}
+
+func TestStopVMAction_runRejectsInvalidUninstall(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name    string
+		opts    stopVMOptions
+		wantErr string
+	}{
+		{
+			name:    "should need a confirmation",
+			opts:    stopVMOptions{uninstall: true},
+			wantErr: "--uninstall removes the VM, pass --yes to confirm",
+		},
+		{
+			name:    "should need a confirmation to remove the user data",
+			opts:    stopVMOptions{uninstall: true, removeUserData: true},
+			wantErr: "--remove-user-data removes all the images, containers and volumes of the VM, pass --yes to confirm",
+		},
+		{
+			name:    "should only remove the user data along with the VM",
+			opts:    stopVMOptions{removeUserData: true, yes: true},
+			wantErr: "--remove-user-data only applies to --uninstall",
+		},
+		{
+			name: "should reject an option that keeps the VM",
+			opts: stopVMOptions{uninstall: true, yes: true, hibernate: true},
+			wantErr: "--uninstall cannot be used together with --instance-file, --after-command or the options " +
+				"that keep the VM or its data",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			action := newStopVMAction(nil, nil, nil, nil, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath,
+				nil, nil, nil)
+			assert.EqualError(t, action.run(tc.opts), tc.wantErr)
+		})
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"testing"
+
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/mocks"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestStopVMAction_runWithValidateConfigFirst(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name         string
+		snapshotters []string
+		mockSvc      func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, dm *mocks.UserDataDiskManager, ctrl *gomock.Controller)
+		wantErr      string
+	}{
+		{
+			name:         "should stop the instance if the config is valid",
+			snapshotters: []string{"soci"},
+			mockSvc: func(logger *mocks.Logger, creator *mocks.NerdctlCmdCreator, dm *mocks.UserDataDiskManager, ctrl *gomock.Controller) {
+				logger.EXPECT().Debugln("The config is valid")
+				getVMStatusC := mocks.NewCommand(ctrl)
+				creator.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+				getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+				expectGracefulStop(creator, dm, logger, ctrl)
+			},
+		},
+		{
+			name:         "should fail before checking the status of the instance if the config is invalid",
+			snapshotters: []string{"zfs"},
+			mockSvc: func(_ *mocks.Logger, _ *mocks.NerdctlCmdCreator, _ *mocks.UserDataDiskManager, _ *gomock.Controller) {
+			},
+			wantErr: "the config is invalid, fix it before stopping the VM as the next start would fail: " +
+				"snapshotter zfs is not supported",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+			tc.mockSvc(logger, ncc, dm, ctrl)
+
+			fc := &config.Finch{}
+			fc.Snapshotters = tc.snapshotters
+			action := newStopVMAction(ncc, nil, dm, logger, afero.NewMemMapFs(), mockFinchPath, fc, mockFinchRootPath, nil, nil, nil)
+			err := action.run(stopVMOptions{validateConfigFirst: true})
+			if tc.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tc.wantErr)
+		})
+	}
+}
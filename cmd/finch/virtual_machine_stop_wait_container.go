@@ -0,0 +1,94 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// containerPollInterval is how often the container is checked while waiting for it to exit.
+	containerPollInterval = time.Second
+	// containerInspectAttempts is how many times in a row inspecting the container can fail while waiting for it
+	// to exit before the wait fails, e.g. while the guest is too loaded to respond.
+	containerInspectAttempts = 3
+)
+
+// errNoSuchContainer is the container to wait for not existing.
+var errNoSuchContainer = errors.New("no such container")
+
+// waitForContainer waits for the container to exit before the VM is stopped, for --wait-for-container, e.g. for the VM
+// of a CI runner to stop once its job is done. It returns right away if the container isn't running, or doesn't exist,
+// and fails once timeout has passed with the container still running, unless timeout is 0. Failing to inspect
+// the container for another reason than it not existing fails the wait, after a few attempts once it's waiting,
+// rather than stopping the VM under a container that may still be running.
+func (sva *stopVMAction) waitForContainer(instance, container string, timeout time.Duration) error {
+	running, err := sva.containerRunning(instance, container)
+	if errors.Is(err, errNoSuchContainer) {
+		sva.logger.Infof("Not waiting for the container %q, it doesn't exist", container)
+		return nil
+	}
+	if err != nil {
+		return withCategory(stopErrorGuest, err)
+	}
+	if !running {
+		return nil
+	}
+
+	if timeout > 0 {
+		sva.logger.Infof("Waiting up to %s for the container %q to exit...", timeout, container)
+	} else {
+		sva.logger.Infof("Waiting for the container %q to exit...", container)
+	}
+	deadline := time.Now().Add(timeout)
+	for failures := 0; running; {
+		sleep := containerPollInterval
+		if timeout > 0 {
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				return withCategory(stopErrorTimeout, fmt.Errorf("the container %q is still running after %s", container, timeout))
+			}
+			sleep = min(sleep, remaining)
+		}
+		time.Sleep(sleep)
+		running, err = sva.containerRunning(instance, container)
+		switch {
+		case errors.Is(err, errNoSuchContainer):
+			// The container was removed once it exited, e.g. it was run with --rm.
+			running = false
+		case err != nil:
+			if failures++; failures >= containerInspectAttempts {
+				return withCategory(stopErrorGuest, err)
+			}
+			sva.logger.Debugf("Could not inspect the container %q, retrying: %v", container, err)
+			running = true
+		default:
+			failures = 0
+		}
+	}
+	sva.logger.Infof("The container %q exited", container)
+	return nil
+}
+
+// containerRunning reports whether the container is running, it fails with errNoSuchContainer if the container
+// doesn't exist.
+func (sva *stopVMAction) containerRunning(instance, container string) (bool, error) {
+	out, err := sva.creator.CreateWithoutStdio(
+		sva.guestNerdctlArgs(instance, "container", "inspect", "--format", "{{.State.Running}}", container)...,
+	).CombinedOutput()
+	if err != nil {
+		if strings.Contains(strings.ToLower(string(out)), "no such container") {
+			return false, fmt.Errorf("%w: %s", errNoSuchContainer, container)
+		}
+		return false, fmt.Errorf("failed to inspect the container %q: %w, debug logs:\n%s", container, err, out)
+	}
+	// The state is the last line, after the warnings nerdctl may print.
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	return strings.TrimSpace(lines[len(lines)-1]) == "true", nil
+}
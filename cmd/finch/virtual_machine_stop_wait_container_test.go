@@ -0,0 +1,149 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/mocks"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestStopVMAction_runWithWaitForContainer(t *testing.T) {
+	t.Parallel()
+
+	inspectArgs := []any{"shell", limaInstanceName, "sudo", "-E", "nerdctl", "container", "inspect", "--format", "{{.State.Running}}", "job"}
+
+	testCases := []struct {
+		name    string
+		timeout time.Duration
+		stopped bool
+		wantErr error
+		mockSvc func(*mocks.NerdctlCmdCreator, *mocks.Logger, *gomock.Controller)
+	}{
+		{
+			name:    "should stop the VM once the container exited",
+			timeout: time.Minute,
+			stopped: true,
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, logger *mocks.Logger, ctrl *gomock.Controller) {
+				runningC := mocks.NewCommand(ctrl)
+				exitedC := mocks.NewCommand(ctrl)
+				gomock.InOrder(
+					ncc.EXPECT().CreateWithoutStdio(inspectArgs...).Return(runningC),
+					ncc.EXPECT().CreateWithoutStdio(inspectArgs...).Return(exitedC),
+				)
+				runningC.EXPECT().CombinedOutput().Return([]byte("true\n"), nil)
+				exitedC.EXPECT().CombinedOutput().Return([]byte("false\n"), nil)
+				logger.EXPECT().Infof("Waiting up to %s for the container %q to exit...", time.Minute, "job")
+				logger.EXPECT().Infof("The container %q exited", "job")
+			},
+		},
+		{
+			name:    "should stop the VM right away if the container doesn't exist",
+			timeout: time.Minute,
+			stopped: true,
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, logger *mocks.Logger, ctrl *gomock.Controller) {
+				inspectC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(inspectArgs...).Return(inspectC)
+				inspectC.EXPECT().CombinedOutput().Return([]byte("time=\"...\" level=fatal msg=\"1 errors:\\nno such container: job\""),
+					errors.New("exit status 1"))
+				logger.EXPECT().Infof("Not waiting for the container %q, it doesn't exist", "job")
+			},
+		},
+		{
+			name:    "should fail if the container can't be inspected",
+			timeout: time.Minute,
+			stopped: false,
+			wantErr: withCategory(stopErrorGuest, fmt.Errorf("failed to inspect the container %q: %w, debug logs:\n%s", "job",
+				errors.New("exit status 255"), "ssh: connect to host 127.0.0.1 port 60022: Connection refused")),
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, _ *mocks.Logger, ctrl *gomock.Controller) {
+				inspectC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(inspectArgs...).Return(inspectC)
+				inspectC.EXPECT().CombinedOutput().Return([]byte("ssh: connect to host 127.0.0.1 port 60022: Connection refused"),
+					errors.New("exit status 255"))
+			},
+		},
+		{
+			name:    "should keep waiting if inspecting the running container fails once",
+			timeout: time.Minute,
+			stopped: true,
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, logger *mocks.Logger, ctrl *gomock.Controller) {
+				runningC := mocks.NewCommand(ctrl)
+				failingC := mocks.NewCommand(ctrl)
+				removedC := mocks.NewCommand(ctrl)
+				gomock.InOrder(
+					ncc.EXPECT().CreateWithoutStdio(inspectArgs...).Return(runningC),
+					ncc.EXPECT().CreateWithoutStdio(inspectArgs...).Return(failingC),
+					ncc.EXPECT().CreateWithoutStdio(inspectArgs...).Return(removedC),
+				)
+				runningC.EXPECT().CombinedOutput().Return([]byte("true\n"), nil)
+				failingC.EXPECT().CombinedOutput().Return([]byte("signal: killed"), errors.New("exit status 137"))
+				removedC.EXPECT().CombinedOutput().Return([]byte("no such container: job"), errors.New("exit status 1"))
+				logger.EXPECT().Infof("Waiting up to %s for the container %q to exit...", time.Minute, "job")
+				logger.EXPECT().Debugf("Could not inspect the container %q, retrying: %v", "job", gomock.Any())
+				logger.EXPECT().Infof("The container %q exited", "job")
+			},
+		},
+		{
+			name:    "should fail if the container is still running after the timeout",
+			timeout: time.Nanosecond,
+			stopped: false,
+			wantErr: withCategory(stopErrorTimeout, fmt.Errorf("the container %q is still running after %s", "job", time.Nanosecond)),
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, logger *mocks.Logger, ctrl *gomock.Controller) {
+				inspectC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(inspectArgs...).Return(inspectC)
+				inspectC.EXPECT().CombinedOutput().Return([]byte("true\n"), nil)
+				logger.EXPECT().Infof("Waiting up to %s for the container %q to exit...", time.Nanosecond, "job")
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dm := mocks.NewUserDataDiskManager(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+
+			getVMStatusC := mocks.NewCommand(ctrl)
+			ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+			getVMStatusC.EXPECT().CombinedOutput().Return([]byte("Running"), nil)
+			tc.mockSvc(ncc, logger, ctrl)
+			if tc.stopped {
+				expectUnmountGuestDataVolume(ncc, ctrl)
+				dm.EXPECT().DetachUserDataDisk().Return(nil)
+				stopC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(stopC)
+				stopC.EXPECT().CombinedOutput()
+				logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+				logger.EXPECT().Info("Finch virtual machine stopped successfully")
+			}
+
+			action := newStopVMAction(ncc, nil, dm, logger, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath,
+				nil, nil, nil)
+			err := action.run(stopVMOptions{waitForContainer: "job", waitForContainerTimeout: tc.timeout})
+			assert.Equal(t, tc.wantErr, err)
+		})
+	}
+}
+
+func TestStopVMAction_runRejectsWaitForContainerWithForce(t *testing.T) {
+	t.Parallel()
+
+	action := newStopVMAction(nil, nil, nil, nil, afero.NewMemMapFs(), mockFinchPath, &config.Finch{}, mockFinchRootPath,
+		nil, nil, nil)
+	err := action.run(stopVMOptions{force: true, waitForContainer: "job"})
+	assert.EqualError(t, err, "--force and --wait-for-container cannot be used together")
+}
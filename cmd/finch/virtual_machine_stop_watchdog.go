@@ -0,0 +1,45 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"runtime/pprof"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// startSelfDumpWatchdog saves a dump of the goroutines of finch to the diagnostics directory if the stop is still
+// going on after stop.selfDumpAfter, to debug finch itself hanging, e.g. on a deadlock in its own code rather than
+// in limactl. The stop goes on regardless. The returned function stops the watchdog once the stop is over.
+func (sva *stopVMAction) startSelfDumpWatchdog(after time.Duration) func() {
+	timer := time.AfterFunc(after, func() { sva.dumpGoroutines(after) })
+	return func() { timer.Stop() }
+}
+
+// dumpGoroutines saves the stacks of all the goroutines of finch, as a panic would print them.
+// Like the other diagnostics, it's best-effort.
+func (sva *stopVMAction) dumpGoroutines(after time.Duration) {
+	var dump bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&dump, 2); err != nil {
+		sva.logger.Warnf("Could not dump the goroutines of finch: %v", err)
+		return
+	}
+	diagnosticsDir := sva.diagnosticsDir()
+	if err := sva.fs.MkdirAll(diagnosticsDir, 0o700); err != nil {
+		sva.logger.Warnf("Could not create the diagnostics directory: %v", err)
+		return
+	}
+	dumpPath := filepath.Join(diagnosticsDir, fmt.Sprintf("finch-stop-goroutines-%s.txt", time.Now().Format("20060102-150405")))
+	if err := afero.WriteFile(sva.fs, dumpPath, dump.Bytes(), 0o600); err != nil {
+		sva.logger.Warnf("Could not save the dump of the goroutines of finch: %v", err)
+		return
+	}
+	sva.logger.Warnf("The stop is still going on after %s, saved a dump of the goroutines of finch to %q", after, dumpPath)
+}
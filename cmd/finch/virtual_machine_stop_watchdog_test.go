@@ -0,0 +1,62 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/mocks"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestStopVMAction_runWithSelfDumpAfter(t *testing.T) {
+	t.Parallel()
+
+	const selfDumpAfter = time.Millisecond
+
+	ctrl := gomock.NewController(t)
+	dm := mocks.NewUserDataDiskManager(ctrl)
+	logger := mocks.NewLogger(ctrl)
+	ncc := mocks.NewNerdctlCmdCreator(ctrl)
+	fs := afero.NewMemMapFs()
+	fc := &config.Finch{}
+	fc.Stop.SelfDumpAfter = selfDumpAfter
+
+	dumped := make(chan struct{})
+	getVMStatusC := mocks.NewCommand(ctrl)
+	ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(getVMStatusC)
+	getVMStatusC.EXPECT().Output().Return([]byte("Running"), nil)
+	expectUnmountGuestDataVolume(ncc, ctrl)
+	dm.EXPECT().DetachUserDataDisk().Return(nil)
+	logger.EXPECT().StartProgress("Stopping existing Finch virtual machine...").Return(func() {})
+	// The stop hangs until the watchdog has dumped the goroutines.
+	stopC := mocks.NewCommand(ctrl)
+	ncc.EXPECT().CreateWithoutStdio("stop", limaInstanceName).Return(stopC)
+	stopC.EXPECT().CombinedOutput().DoAndReturn(func() ([]byte, error) {
+		<-dumped
+		return nil, nil
+	})
+	logger.EXPECT().Warnf("The stop is still going on after %s, saved a dump of the goroutines of finch to %q",
+		selfDumpAfter, gomock.Any()).Do(func(string, ...any) { close(dumped) })
+	logger.EXPECT().Info("Finch virtual machine stopped successfully")
+
+	action := newStopVMAction(ncc, nil, dm, logger, fs, mockFinchPath, fc, mockFinchRootPath, nil, nil, nil)
+	require.NoError(t, action.run(stopVMOptions{}))
+
+	dumps, err := afero.Glob(fs, filepath.Join(mockFinchPath.DiagnosticsDir(mockFinchRootPath), "finch-stop-goroutines-*.txt"))
+	require.NoError(t, err)
+	require.Len(t, dumps, 1)
+	dump, err := afero.ReadFile(fs, dumps[0])
+	require.NoError(t, err)
+	assert.Contains(t, string(dump), "goroutine ")
+}
@@ -0,0 +1,50 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build windows
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+
+	"github.com/runfinch/finch/pkg/config"
+)
+
+// configuredMemory returns an empty string, as the memory of the WSL VM isn't set in finch.yaml.
+func configuredMemory(_ *config.Finch) string {
+	return ""
+}
+
+// cleanUpNetwork is a no-op, WSL2 owns the network of the VM and Lima doesn't add any firewall rule for it.
+func (sva *stopVMAction) cleanUpNetwork() error {
+	sva.logger.Debugln("No network to clean up on Windows")
+	return nil
+}
+
+// preventSleep is a no-op, --allow-sleep only applies to macOS.
+func (sva *stopVMAction) preventSleep() (release func()) {
+	return func() {}
+}
+
+// hostServices returns the names of the scheduled tasks named after Finch.
+func (sva *stopVMAction) hostServices() ([]string, error) {
+	out, err := sva.ecc.Create("schtasks", "/query", "/fo", "csv", "/nh").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the scheduled tasks: %w", err)
+	}
+	// Each record is "<task name>","<next run time>","<status>".
+	reader := csv.NewReader(bytes.NewReader(out))
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the scheduled tasks: %w", err)
+	}
+	var names []string
+	for _, record := range records {
+		names = append(names, record[0])
+	}
+	return finchServices(names), nil
+}
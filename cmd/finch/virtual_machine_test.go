@@ -7,28 +7,31 @@ package main
 
 import (
 	"errors"
-	"runtime"
+	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/runfinch/finch/pkg/command"
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/lima"
 	"github.com/runfinch/finch/pkg/mocks"
 
+	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/mock/gomock"
 )
 
 func TestVirtualMachineCommand(t *testing.T) {
 	t.Parallel()
 
-	cmd := newVirtualMachineCommand(nil, nil, nil, nil, nil, "", nil, nil)
+	cmd := newVirtualMachineCommand(nil, nil, nil, nil, nil, nil, "", nil, nil, nil, "")
 	assert.Equal(t, cmd.Use, virtualMachineRootCmd)
 
 	// check the number of subcommand for vm
-	expectedCmds := 6
-	if runtime.GOOS == "darwin" {
-		expectedCmds = 7 // Darwin includes disk commands
-	}
-	assert.Equal(t, len(cmd.Commands()), expectedCmds)
+	assert.Equal(t, len(cmd.Commands()), 12)
 }
 
 func TestPostVMStartInitAction_runAdapter(t *testing.T) {
@@ -68,7 +71,9 @@ func TestPostVMStartInitAction_runAdapter(t *testing.T) {
 			nca := mocks.NewNerdctlConfigApplier(ctrl)
 			tc.mockSvc(logger, ncc, command, nca)
 
-			err := newPostVMStartInitAction(logger, ncc, nil, "", nca).runAdapter(tc.cmd, tc.args)
+			action := newPostVMStartInitAction(logger, ncc, afero.NewMemMapFs(), "", mockFinchPath.LimaInstancePath(), nca,
+				&config.Finch{})
+			err := action.runAdapter(tc.cmd, tc.args)
 			assert.Equal(t, err, tc.wantErr)
 		})
 	}
@@ -134,8 +139,136 @@ func TestPostVMStartInitAction_run(t *testing.T) {
 			nca := mocks.NewNerdctlConfigApplier(ctrl)
 			tc.mockSvc(logger, ncc, command, nca)
 
-			err := newPostVMStartInitAction(logger, ncc, nil, "", nca).run()
+			fs := afero.NewMemMapFs()
+			fc := &config.Finch{}
+			fc.DockerCompat = true
+			err := newPostVMStartInitAction(logger, ncc, fs, "", mockFinchPath.LimaInstancePath(), nca, fc).run()
 			assert.Equal(t, err, tc.wantErr)
+
+			md, err := lima.LoadInstanceMetadata(fs, mockFinchPath.LimaInstancePath())
+			require.NoError(t, err)
+			assert.False(t, md.HostBootTime.IsZero())
+			assert.Equal(t, map[string]any{"dockercompat": true}, md.RunConfig)
+		})
+	}
+}
+
+func TestTargetLimaHome(t *testing.T) {
+	t.Parallel()
+
+	const limaHome = "/sandbox/lima"
+
+	testCases := []struct {
+		name    string
+		setup   func(fs afero.Fs)
+		creator func(*gomock.Controller, *mocks.Logger) command.NerdctlCmdCreator
+		wantErr error
+	}{
+		{
+			name: "should target the Lima home",
+			setup: func(fs afero.Fs) {
+				require.NoError(t, fs.MkdirAll(limaHome, 0o700))
+			},
+			creator: func(ctrl *gomock.Controller, logger *mocks.Logger) command.NerdctlCmdCreator {
+				logger.EXPECT().Debugf("Using the Lima home %q", limaHome)
+				return command.NewNerdctlCmdCreator(mocks.NewCommandCreator(ctrl), logger, "/lima", "/lima/bin/limactl", "/lima/bin",
+					mocks.NewNerdctlCmdCreatorSystemDeps(ctrl))
+			},
+			wantErr: nil,
+		},
+		{
+			name:  "should return an error if the Lima home doesn't exist",
+			setup: func(_ afero.Fs) {},
+			creator: func(ctrl *gomock.Controller, _ *mocks.Logger) command.NerdctlCmdCreator {
+				return mocks.NewNerdctlCmdCreator(ctrl)
+			},
+			wantErr: fmt.Errorf("failed to access the Lima home: %w",
+				&os.PathError{Op: "open", Path: filepath.Clean(limaHome), Err: os.ErrNotExist}),
+		},
+		{
+			name: "should return an error if the Lima home is a file",
+			setup: func(fs afero.Fs) {
+				require.NoError(t, afero.WriteFile(fs, limaHome, []byte{}, 0o600))
+			},
+			creator: func(ctrl *gomock.Controller, _ *mocks.Logger) command.NerdctlCmdCreator {
+				return mocks.NewNerdctlCmdCreator(ctrl)
+			},
+			wantErr: fmt.Errorf("the Lima home %q is not a directory", limaHome),
+		},
+		{
+			name: "should return an error if the creator can't target another Lima home",
+			setup: func(fs afero.Fs) {
+				require.NoError(t, fs.MkdirAll(limaHome, 0o700))
+			},
+			creator: func(ctrl *gomock.Controller, _ *mocks.Logger) command.NerdctlCmdCreator {
+				return mocks.NewNerdctlCmdCreator(ctrl)
+			},
+			wantErr: fmt.Errorf("the Lima home can't be changed to %q", limaHome),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			logger := mocks.NewLogger(ctrl)
+			fs := afero.NewMemMapFs()
+			tc.setup(fs)
+
+			creator, err := targetLimaHome(tc.creator(ctrl, logger), fs, logger, limaHome)
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.wantErr == nil, creator != nil)
+		})
+	}
+}
+
+func TestTargetGuestSSH(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name        string
+		args        []string
+		wantWrapped bool
+		wantErr     error
+	}{
+		{
+			name:        "should keep reaching the guest through Lima without overrides",
+			args:        []string{},
+			wantWrapped: false,
+		},
+		{
+			name:        "should reach the guest over SSH with a port override",
+			args:        []string{"--ssh-port", "2222"},
+			wantWrapped: true,
+		},
+		{
+			name:        "should reach the guest over SSH with an identity override",
+			args:        []string{"--ssh-identity", "/keys/finch"},
+			wantWrapped: true,
+		},
+		{
+			name:    "should return an error if the port is out of range",
+			args:    []string{"--ssh-port", "70000"},
+			wantErr: errors.New("--ssh-port must be between 1 and 65535, got 70000"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+			cmd := &cobra.Command{}
+			addGuestSSHFlags(cmd)
+			require.NoError(t, cmd.ParseFlags(tc.args))
+
+			creator, err := targetGuestSSH(cmd, ncc, mocks.NewCommandCreator(ctrl), afero.NewMemMapFs(), mocks.NewLogger(ctrl))
+			assert.Equal(t, tc.wantErr, err)
+			if tc.wantErr == nil {
+				assert.Equal(t, tc.wantWrapped, creator != command.NerdctlCmdCreator(ncc))
+			}
 		})
 	}
 }
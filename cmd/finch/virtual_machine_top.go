@@ -0,0 +1,316 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/runfinch/finch/pkg/command"
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/dependency"
+	"github.com/runfinch/finch/pkg/disk"
+	"github.com/runfinch/finch/pkg/flog"
+	"github.com/runfinch/finch/pkg/lima"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+const (
+	// clearScreen moves the cursor to the top left corner and erases the screen.
+	clearScreen = "\033[H\033[2J"
+	// keyCtrlC is what Ctrl+C reads as once the terminal is in raw mode, in which it no longer raises SIGINT.
+	keyCtrlC = 3
+)
+
+func newTopVMCommand(
+	ncc command.NerdctlCmdCreator,
+	logger flog.Logger,
+	optionalDepGroups []*dependency.Group,
+	lca config.LimaConfigApplier,
+	nca config.NerdctlConfigApplier,
+	fc *config.Finch,
+	fs afero.Fs,
+	privateKeyPath string,
+	dm disk.UserDataDiskManager,
+	instanceDir string,
+	auditLogPath string,
+	stdin io.Reader,
+	stdout io.Writer,
+) *cobra.Command {
+	topVMCommand := &cobra.Command{
+		Use:   "top",
+		Short: "Display the status of the virtual machine and the resource usage of its containers, refreshed live",
+		RunE: newTopVMAction(
+			ncc,
+			logger,
+			// The VM is started with the default options, which reach the guest through Lima, hence no host command creator.
+			newStartVMAction(ncc, logger, optionalDepGroups, lca, dm, fs, instanceDir, nil),
+			newPostVMStartInitAction(logger, ncc, fs, privateKeyPath, instanceDir, nca, fc),
+			fs,
+			auditLogPath,
+			stdin,
+			stdout,
+		).runAdapter,
+	}
+
+	topVMCommand.Flags().Duration("interval", 2*time.Second, "time between two refreshes")
+	topVMCommand.Flags().IntP("iterations", "n", 0, "number of refreshes before exiting, 0 to refresh until quit")
+
+	return topVMCommand
+}
+
+// topVMOptions holds the options of a single `finch vm top` invocation.
+type topVMOptions struct {
+	interval   time.Duration
+	iterations int
+}
+
+// containerStats is a line of `nerdctl stats --format '{{json .}}'`.
+type containerStats struct {
+	ID       string `json:"ID"`
+	Name     string `json:"Name"`
+	CPUPerc  string `json:"CPUPerc"`
+	MemUsage string `json:"MemUsage"`
+	MemPerc  string `json:"MemPerc"`
+}
+
+// topSnapshot is what a single refresh of finch vm top displays.
+type topSnapshot struct {
+	time       time.Time
+	status     lima.VMStatus
+	containers []containerStats
+	err        error
+}
+
+type topVMAction struct {
+	creator         command.NerdctlCmdCreator
+	logger          flog.Logger
+	startAction     *startVMAction
+	postStartAction *postVMStartInitAction
+	fs              afero.Fs
+	// auditLogPath is where the starts triggered from finch vm top are recorded, like those of finch vm start.
+	auditLogPath string
+	stdin        io.Reader
+	stdout       io.Writer
+}
+
+func newTopVMAction(
+	creator command.NerdctlCmdCreator,
+	logger flog.Logger,
+	startAction *startVMAction,
+	postStartAction *postVMStartInitAction,
+	fs afero.Fs,
+	auditLogPath string,
+	stdin io.Reader,
+	stdout io.Writer,
+) *topVMAction {
+	return &topVMAction{
+		creator:         creator,
+		logger:          logger,
+		startAction:     startAction,
+		postStartAction: postStartAction,
+		fs:              fs,
+		auditLogPath:    auditLogPath,
+		stdin:           stdin,
+		stdout:          stdout,
+	}
+}
+
+func (tva *topVMAction) runAdapter(cmd *cobra.Command, _ []string) error {
+	interval, err := cmd.Flags().GetDuration("interval")
+	if err != nil {
+		return err
+	}
+	if interval <= 0 {
+		return errors.New("--interval must be positive")
+	}
+	iterations, err := cmd.Flags().GetInt("iterations")
+	if err != nil {
+		return err
+	}
+	if iterations < 0 {
+		return errors.New("--iterations must not be negative")
+	}
+	return tva.run(topVMOptions{interval: interval, iterations: iterations})
+}
+
+func (tva *topVMAction) run(opts topVMOptions) error {
+	in, inOK := tva.stdin.(*os.File)
+	out, outOK := tva.stdout.(*os.File)
+	if !inOK || !outOK || !term.IsTerminal(int(in.Fd())) || !term.IsTerminal(int(out.Fd())) {
+		return tva.runPlain(opts)
+	}
+	return tva.runInteractive(in, opts)
+}
+
+// runPlain prints a snapshot per refresh one after the other, so that the output can be piped or logged.
+func (tva *topVMAction) runPlain(opts topVMOptions) error {
+	for i := 1; ; i++ {
+		if _, err := fmt.Fprint(tva.stdout, renderTopSnapshot(tva.snapshot(), false)); err != nil {
+			return err
+		}
+		if i == opts.iterations {
+			return nil
+		}
+		time.Sleep(opts.interval)
+		if _, err := fmt.Fprintln(tva.stdout); err != nil {
+			return err
+		}
+	}
+}
+
+// runInteractive redraws the snapshot in place on each refresh, and reacts to single key presses until q is pressed.
+func (tva *topVMAction) runInteractive(in *os.File, opts topVMOptions) error {
+	state, err := term.MakeRaw(int(in.Fd()))
+	if err != nil {
+		return fmt.Errorf("failed to put the terminal in raw mode: %w", err)
+	}
+	defer func() {
+		_ = term.Restore(int(in.Fd()), state)
+	}()
+
+	keys := make(chan byte)
+	go readKeys(in, keys)
+
+	ticker := time.NewTicker(opts.interval)
+	defer ticker.Stop()
+	for i := 1; ; i++ {
+		snapshot := tva.snapshot()
+		if err := tva.draw(snapshot); err != nil {
+			return err
+		}
+		if i == opts.iterations {
+			return nil
+		}
+
+	wait:
+		for {
+			select {
+			case <-ticker.C:
+				break wait
+			case key, ok := <-keys:
+				switch {
+				case !ok || key == 'q' || key == keyCtrlC:
+					return nil
+				case key == 's' && snapshot.status == lima.Stopped:
+					// Starting logs its progress, the terminal is restored meanwhile so that the logs render as usual.
+					_ = term.Restore(int(in.Fd()), state)
+					_, _ = fmt.Fprint(tva.stdout, clearScreen)
+					if err := tva.startVM(); err != nil {
+						tva.logger.Errorf("Failed to start the virtual machine: %v", err)
+					}
+					if _, err := term.MakeRaw(int(in.Fd())); err != nil {
+						return fmt.Errorf("failed to put the terminal in raw mode: %w", err)
+					}
+					break wait
+				}
+			}
+		}
+	}
+}
+
+func (tva *topVMAction) draw(snapshot topSnapshot) error {
+	// The terminal is in raw mode, where a line feed doesn't move the cursor back to the start of the line.
+	screen := strings.ReplaceAll(renderTopSnapshot(snapshot, true), "\n", "\r\n")
+	_, err := fmt.Fprint(tva.stdout, clearScreen+screen)
+	return err
+}
+
+func (tva *topVMAction) startVM() error {
+	err := tva.startAction.run(startVMOptions{})
+	if err == nil {
+		err = tva.postStartAction.run()
+	}
+	recordAudit(tva.fs, tva.logger, tva.auditLogPath, "start", limaInstanceName, err)
+	return err
+}
+
+// readKeys sends the bytes read from r to keys, one at a time, and closes keys once r can't be read anymore.
+func readKeys(r io.Reader, keys chan<- byte) {
+	defer close(keys)
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return
+		}
+		keys <- b
+	}
+}
+
+func (tva *topVMAction) snapshot() topSnapshot {
+	snapshot := topSnapshot{time: time.Now()}
+	snapshot.status, snapshot.err = lima.Status(tva.creator, limaInstanceName)
+	if snapshot.err != nil || snapshot.status != lima.Running {
+		return snapshot
+	}
+	snapshot.containers, snapshot.err = tva.containerStats()
+	return snapshot
+}
+
+func (tva *topVMAction) containerStats() ([]containerStats, error) {
+	out, err := tva.creator.CreateWithoutStdio(
+		"shell", limaInstanceName, "sudo", "-E", "nerdctl", "stats", "--no-stream", "--format", "{{json .}}",
+	).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the stats of the containers: %w", err)
+	}
+	var stats []containerStats
+	for _, line := range bytes.Split(bytes.TrimSpace(out), []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		var s containerStats
+		if err := json.Unmarshal(line, &s); err != nil {
+			return nil, fmt.Errorf("failed to parse the stats of the containers: %w", err)
+		}
+		stats = append(stats, s)
+	}
+	return stats, nil
+}
+
+func renderTopSnapshot(snapshot topSnapshot, interactive bool) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Finch virtual machine: %s (%s)\n\n", snapshot.status, snapshot.time.Format(time.TimeOnly))
+
+	switch {
+	case snapshot.err != nil:
+		fmt.Fprintf(&sb, "Error: %v\n", snapshot.err)
+	case snapshot.status == lima.Nonexistent:
+		fmt.Fprintf(&sb, "VM does not exist, run `finch %s init` to create it\n", virtualMachineRootCmd)
+	case snapshot.status == lima.Stopped && interactive:
+		sb.WriteString("VM stopped, press s to start\n")
+	case snapshot.status == lima.Stopped:
+		sb.WriteString("VM stopped\n")
+	case snapshot.status != lima.Running:
+		sb.WriteString("VM not running\n")
+	case len(snapshot.containers) == 0:
+		sb.WriteString("No running containers\n")
+	default:
+		tw := tabwriter.NewWriter(&sb, 0, 0, 3, ' ', 0)
+		fmt.Fprintln(tw, "CONTAINER ID\tNAME\tCPU %\tMEM USAGE / LIMIT\tMEM %")
+		for _, c := range snapshot.containers {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", c.ID, c.Name, c.CPUPerc, c.MemUsage, c.MemPerc)
+		}
+		_ = tw.Flush()
+	}
+
+	if interactive {
+		sb.WriteString("\nPress q to quit\n")
+	}
+	return sb.String()
+}
@@ -0,0 +1,123 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/runfinch/finch/pkg/lima"
+	"github.com/runfinch/finch/pkg/mocks"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+)
+
+func TestNewTopVMCommand(t *testing.T) {
+	t.Parallel()
+
+	cmd := newTopVMCommand(nil, nil, nil, nil, nil, nil, nil, "", nil, "", "", nil, nil)
+	assert.Equal(t, cmd.Name(), "top")
+}
+
+func TestTopVMAction_run(t *testing.T) {
+	t.Parallel()
+
+	statsArgs := []any{"shell", limaInstanceName, "sudo", "-E", "nerdctl", "stats", "--no-stream", "--format", "{{json .}}"}
+
+	testCases := []struct {
+		name       string
+		mockSvc    func(*mocks.NerdctlCmdCreator, *gomock.Controller)
+		wantStdout string
+	}{
+		{
+			name: "should display the stats of the running containers",
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, ctrl *gomock.Controller) {
+				statusC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(statusC)
+				statusC.EXPECT().Output().Return([]byte("Running"), nil)
+				statsC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(statsArgs...).Return(statsC)
+				statsC.EXPECT().Output().Return([]byte(
+					`{"ID":"0123456789ab","Name":"web","CPUPerc":"1.50%","MemUsage":"10MiB / 1GiB","MemPerc":"0.98%"}`+"\n"+
+						`{"ID":"ba9876543210","Name":"db","CPUPerc":"12.00%","MemUsage":"200MiB / 1GiB","MemPerc":"19.53%"}`+"\n",
+				), nil)
+			},
+			wantStdout: "CONTAINER ID   NAME   CPU %    MEM USAGE / LIMIT   MEM %\n" +
+				"0123456789ab   web    1.50%    10MiB / 1GiB        0.98%\n" +
+				"ba9876543210   db     12.00%   200MiB / 1GiB       19.53%\n",
+		},
+		{
+			name: "should report that no container is running",
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, ctrl *gomock.Controller) {
+				statusC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(statusC)
+				statusC.EXPECT().Output().Return([]byte("Running"), nil)
+				statsC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(statsArgs...).Return(statsC)
+				statsC.EXPECT().Output().Return([]byte(""), nil)
+			},
+			wantStdout: "No running containers\n",
+		},
+		{
+			name: "should report that the VM is stopped",
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, ctrl *gomock.Controller) {
+				statusC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(statusC)
+				statusC.EXPECT().Output().Return([]byte("Stopped"), nil)
+			},
+			wantStdout: "VM stopped\n",
+		},
+		{
+			name: "should report that the VM doesn't exist",
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, ctrl *gomock.Controller) {
+				statusC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(statusC)
+				statusC.EXPECT().Output().Return([]byte(""), nil)
+			},
+			wantStdout: "VM does not exist, run `finch vm init` to create it\n",
+		},
+		{
+			name: "should display the error if the stats can't be read",
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, ctrl *gomock.Controller) {
+				statusC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio("ls", "-f", "{{.Status}}", limaInstanceName).Return(statusC)
+				statusC.EXPECT().Output().Return([]byte("Running"), nil)
+				statsC := mocks.NewCommand(ctrl)
+				ncc.EXPECT().CreateWithoutStdio(statsArgs...).Return(statsC)
+				statsC.EXPECT().Output().Return(nil, errors.New("exit status 1"))
+			},
+			wantStdout: "Error: failed to get the stats of the containers: exit status 1\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			stdout := &bytes.Buffer{}
+			tc.mockSvc(ncc, ctrl)
+
+			err := newTopVMAction(ncc, logger, nil, nil, nil, "", nil, stdout).run(topVMOptions{interval: time.Second, iterations: 1})
+			assert.NoError(t, err)
+			assert.Contains(t, stdout.String(), "Finch virtual machine: ")
+			assert.Contains(t, stdout.String(), "\n\n"+tc.wantStdout)
+		})
+	}
+}
+
+func TestRenderTopSnapshot(t *testing.T) {
+	t.Parallel()
+
+	snapshot := topSnapshot{time: time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC), status: lima.Stopped}
+	assert.Equal(t, "Finch virtual machine: Stopped (15:04:05)\n\nVM stopped, press s to start\n\nPress q to quit\n",
+		renderTopSnapshot(snapshot, true))
+}
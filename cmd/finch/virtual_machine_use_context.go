@@ -0,0 +1,111 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/flog"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+)
+
+// instanceFlagName is the persistent flag of the VM commands naming the instance of Finch to operate on.
+const instanceFlagName = "instance"
+
+// useContextCmd is the subcommand of finch vm switching the current context.
+const useContextCmd = "use-context"
+
+// instanceFlag returns the value of the persistent --instance flag as cobra parses it for the command of app args run,
+// and whether that command operates on an instance at all: use-context only switches the current context, which has to
+// work even if the current one no longer exists.
+func instanceFlag(app *cobra.Command, args []string) (string, bool) {
+	cmd, flagArgs, err := app.Find(args)
+	if err != nil {
+		// cobra reports the unknown command when it's executed.
+		return "", true
+	}
+	if cmd.Name() == useContextCmd && cmd.HasParent() && cmd.Parent().Name() == virtualMachineRootCmd {
+		return "", false
+	}
+	if err := cmd.ParseFlags(flagArgs); err != nil {
+		// cobra reports the invalid flags when the command is executed.
+		return "", true
+	}
+	flag := cmd.Flags().Lookup(instanceFlagName)
+	if flag == nil {
+		return "", true
+	}
+	return flag.Value.String(), true
+}
+
+// selectInstance points fc at the instance of Finch to operate on: the one named by instance if set,
+// else the one of the current context, else the one of finch.yaml. A current context that no longer exists
+// falls back to the instance of finch.yaml, so that the commands still work until another context is used.
+func selectInstance(fs afero.Fs, logger flog.Logger, contextsPath string, fc *config.Finch, instance string) error {
+	if instance != "" {
+		prefix, err := config.InstancePrefixOf(instance)
+		if err != nil {
+			return fmt.Errorf("invalid --%s: %w", instanceFlagName, err)
+		}
+		fc.InstancePrefix = prefix
+		return nil
+	}
+	contexts, err := config.LoadContexts(fs, contextsPath)
+	if err != nil {
+		return err
+	}
+	current, err := contexts.Current()
+	if err != nil {
+		logger.Warnf("Using the instance %q of finch.yaml, %v, run `finch %s %s` to switch to another one",
+			fc.InstanceName(), err, virtualMachineRootCmd, useContextCmd)
+		return nil
+	}
+	if current != nil {
+		fc.InstancePrefix = current.InstancePrefix
+	}
+	return nil
+}
+
+func newUseContextVMCommand(logger flog.Logger, fs afero.Fs, contextsPath string) *cobra.Command {
+	return &cobra.Command{
+		Use:   useContextCmd + " <name>",
+		Short: "Set the context selecting the instance the virtual machine commands operate on",
+		Args:  cobra.ExactArgs(1),
+		RunE:  newUseContextVMAction(logger, fs, contextsPath).runAdapter,
+	}
+}
+
+type useContextVMAction struct {
+	logger       flog.Logger
+	fs           afero.Fs
+	contextsPath string
+}
+
+func newUseContextVMAction(logger flog.Logger, fs afero.Fs, contextsPath string) *useContextVMAction {
+	return &useContextVMAction{logger: logger, fs: fs, contextsPath: contextsPath}
+}
+
+func (uca *useContextVMAction) runAdapter(_ *cobra.Command, args []string) error {
+	return uca.run(args[0])
+}
+
+func (uca *useContextVMAction) run(name string) error {
+	contexts, err := config.LoadContexts(uca.fs, uca.contextsPath)
+	if err != nil {
+		return err
+	}
+	if err := contexts.Use(name); err != nil {
+		return fmt.Errorf("%w, the contexts are defined in %q", err, uca.contextsPath)
+	}
+	if err := contexts.Save(uca.fs, uca.contextsPath); err != nil {
+		return err
+	}
+	uca.logger.Infof("Switched to context %q", name)
+	return nil
+}
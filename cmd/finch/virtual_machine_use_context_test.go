@@ -0,0 +1,228 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/mocks"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+const (
+	mockContextsPath = "/home/.finch/contexts.yaml"
+	mockContexts     = `current-context: alice
+contexts:
+- name: default
+- name: alice
+  instancePrefix: alice-
+`
+)
+
+func TestNewUseContextVMCommand(t *testing.T) {
+	t.Parallel()
+
+	cmd := newUseContextVMCommand(nil, nil, mockContextsPath)
+	assert.Equal(t, cmd.Name(), "use-context")
+}
+
+// newInstanceFlagApp returns a command tree shaped like the one of finch for instanceFlag to find the commands in.
+func newInstanceFlagApp() *cobra.Command {
+	app := &cobra.Command{Use: finchRootCmd}
+	app.PersistentFlags().Bool("debug", false, "")
+	vm := &cobra.Command{Use: virtualMachineRootCmd}
+	vm.PersistentFlags().String(instanceFlagName, "", "")
+	stop := &cobra.Command{Use: "stop", RunE: func(*cobra.Command, []string) error { return nil }}
+	stop.Flags().Bool("force", false, "")
+	stop.Flags().String("tag", "", "")
+	status := &cobra.Command{Use: "status", RunE: func(*cobra.Command, []string) error { return nil }}
+	useContext := &cobra.Command{Use: useContextCmd, RunE: func(*cobra.Command, []string) error { return nil }}
+	vm.AddCommand(stop, status, useContext)
+	run := &cobra.Command{Use: "run", DisableFlagParsing: true, RunE: func(*cobra.Command, []string) error { return nil }}
+	app.AddCommand(vm, run)
+	return app
+}
+
+func TestInstanceFlag(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name        string
+		args        []string
+		want        string
+		wantSelects bool
+	}{
+		{
+			name:        "should return the instance of a VM command",
+			args:        []string{"vm", "stop", "--instance", "bob-finch"},
+			want:        "bob-finch",
+			wantSelects: true,
+		},
+		{
+			name:        "should support the = form",
+			args:        []string{"vm", "--instance=bob-finch", "status"},
+			want:        "bob-finch",
+			wantSelects: true,
+		},
+		{
+			name:        "should parse the flags of the command",
+			args:        []string{"--debug", "vm", "stop", "--tag", "--instance", "--force", "--instance=bob-finch"},
+			want:        "bob-finch",
+			wantSelects: true,
+		},
+		{
+			name:        "should ignore the other commands",
+			args:        []string{"run", "--instance", "bob-finch", "alpine"},
+			wantSelects: true,
+		},
+		{
+			name:        "should ignore the arguments after --",
+			args:        []string{"vm", "stop", "--", "--instance=bob-finch"},
+			wantSelects: true,
+		},
+		{name: "should return nothing without the flag", args: []string{"vm", "stop"}, wantSelects: true},
+		{name: "should return nothing for invalid flags", args: []string{"vm", "stop", "--bogus"}, wantSelects: true},
+		{name: "should not select an instance for use-context", args: []string{"vm", "use-context", "alice"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			instance, selects := instanceFlag(newInstanceFlagApp(), tc.args)
+			assert.Equal(t, tc.want, instance)
+			assert.Equal(t, tc.wantSelects, selects)
+		})
+	}
+}
+
+func TestSelectInstance(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name       string
+		contexts   string
+		instance   string
+		mockSvc    func(logger *mocks.Logger)
+		wantPrefix string
+		wantErr    string
+	}{
+		{
+			name:       "should keep the instance of finch.yaml without contexts",
+			mockSvc:    func(*mocks.Logger) {},
+			wantPrefix: "carol-",
+		},
+		{
+			name:       "should select the instance of the current context",
+			contexts:   mockContexts,
+			mockSvc:    func(*mocks.Logger) {},
+			wantPrefix: "alice-",
+		},
+		{
+			name:       "should let --instance override the current context",
+			contexts:   mockContexts,
+			instance:   "bob-finch",
+			mockSvc:    func(*mocks.Logger) {},
+			wantPrefix: "bob-",
+		},
+		{
+			name:     "should fall back to the instance of finch.yaml if the current context doesn't exist",
+			contexts: "current-context: dave\ncontexts:\n- name: default\n",
+			mockSvc: func(logger *mocks.Logger) {
+				logger.EXPECT().Warnf("Using the instance %q of finch.yaml, %v, run `finch %s %s` to switch to another one",
+					"carol-finch", errors.New(`the current context "dave" doesn't exist`), "vm", "use-context")
+			},
+			wantPrefix: "carol-",
+		},
+		{
+			name:       "should reject an invalid --instance",
+			instance:   "bob",
+			mockSvc:    func(*mocks.Logger) {},
+			wantPrefix: "carol-",
+			wantErr:    `invalid --instance: "bob" isn't the name of an instance of Finch, which all end with "finch"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			logger := mocks.NewLogger(ctrl)
+			fs := afero.NewMemMapFs()
+			if tc.contexts != "" {
+				require.NoError(t, afero.WriteFile(fs, mockContextsPath, []byte(tc.contexts), 0o600))
+			}
+			tc.mockSvc(logger)
+			fc := &config.Finch{}
+			fc.InstancePrefix = "carol-"
+			err := selectInstance(fs, logger, mockContextsPath, fc, tc.instance)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.wantPrefix, fc.InstancePrefix)
+		})
+	}
+}
+
+func TestUseContextVMAction_run(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name        string
+		context     string
+		mockSvc     func(logger *mocks.Logger)
+		wantCurrent string
+		wantErr     string
+	}{
+		{
+			name:    "should switch to the context",
+			context: "default",
+			mockSvc: func(logger *mocks.Logger) {
+				logger.EXPECT().Infof("Switched to context %q", "default")
+			},
+			wantCurrent: "default",
+		},
+		{
+			name:        "should fail for an unknown context",
+			context:     "bob",
+			mockSvc:     func(*mocks.Logger) {},
+			wantCurrent: "alice",
+			wantErr:     `no context named "bob", the contexts are defined in "/home/.finch/contexts.yaml"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			logger := mocks.NewLogger(ctrl)
+			fs := afero.NewMemMapFs()
+			require.NoError(t, afero.WriteFile(fs, mockContextsPath, []byte(mockContexts), 0o600))
+			tc.mockSvc(logger)
+
+			err := newUseContextVMAction(logger, fs, mockContextsPath).run(tc.context)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			contexts, err := config.LoadContexts(fs, mockContextsPath)
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantCurrent, contexts.CurrentContext)
+		})
+	}
+}
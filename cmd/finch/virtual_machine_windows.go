@@ -19,8 +19,30 @@ import (
 	"github.com/runfinch/finch/pkg/path"
 )
 
+func newDiskVMCommand(
+	creator command.NerdctlCmdCreator,
+	diskManager disk.UserDataDiskManager,
+	logger flog.Logger,
+	fs afero.Fs,
+	auditLogPath string,
+) *cobra.Command {
+	diskCmd := &cobra.Command{
+		Use:   "disk",
+		Short: "Manage virtual machine disk operations",
+	}
+
+	diskCmd.AddCommand(
+		withAudit(newVMDiskDetachCommand(creator, diskManager, logger), fs, logger, auditLogPath),
+		withAudit(newVMDiskAttachCommand(diskManager, logger), fs, logger, auditLogPath),
+		newVMDiskSnapshotsCommand(diskManager, logger, os.Stdout),
+	)
+
+	return diskCmd
+}
+
 func newVirtualMachineCommand(
 	limaCmdCreator command.NerdctlCmdCreator,
+	ecc command.Creator,
 	logger flog.Logger,
 	optionalDepGroups []*dependency.Group,
 	lca config.LimaConfigApplier,
@@ -28,20 +50,41 @@ func newVirtualMachineCommand(
 	fp path.Finch,
 	fs afero.Fs,
 	diskManager disk.UserDataDiskManager,
+	fc *config.Finch,
+	finchRootPath string,
 ) *cobra.Command {
 	virtualMachineCommand := &cobra.Command{
 		Use:   virtualMachineRootCmd,
 		Short: "Manage the virtual machine lifecycle",
 	}
 
+	virtualMachineCommand.PersistentFlags().String(instanceFlagName, "",
+		"name of the Finch instance to operate on, overrides the current context")
+
+	auditLogPath := fp.AuditLogPath(finchRootPath)
+	lock := diskLock(fs, fc)
 	virtualMachineCommand.AddCommand(
-		newStartVMCommand(limaCmdCreator, logger, optionalDepGroups, lca, nca, fs, fp.LimaSSHPrivateKeyPath(), diskManager),
-		newStopVMCommand(limaCmdCreator, diskManager, logger),
-		newRemoveVMCommand(limaCmdCreator, diskManager, logger),
-		newStatusVMCommand(limaCmdCreator, logger, os.Stdout),
-		newInitVMCommand(limaCmdCreator, logger, optionalDepGroups, lca, nca, fp.BaseYamlFilePath(), fs,
-			fp.LimaSSHPrivateKeyPath(), diskManager),
+		withDiskLock(withAudit(newStartVMCommand(limaCmdCreator, logger, optionalDepGroups, lca, nca, fc, fs,
+			fp.LimaSSHPrivateKeyPath(), diskManager, fp.LimaInstancePathOf(limaInstanceName), ecc), fs, logger, auditLogPath), lock, logger),
+		withDiskLock(newStopVMCommand(limaCmdCreator, ecc, diskManager, logger, fs, fp, fc, finchRootPath, os.Stdin, os.Stdout,
+			os.Stdout), lock, logger),
+		newFinishStopVMCommand(limaCmdCreator, ecc, diskManager, logger, fs, fp, fc, finchRootPath, os.Stdout),
+		withDiskLock(withAudit(newRemoveVMCommand(limaCmdCreator, diskManager, logger), fs, logger, auditLogPath), lock, logger),
+		newStatusVMCommand(limaCmdCreator, logger, fs, os.Stdout),
+		newRestartRuntimeVMCommand(limaCmdCreator, logger),
+		newProbeVMCommand(limaCmdCreator, logger, os.Stdout),
+		newInfoVMCommand(limaCmdCreator, logger, fc, fs, fp.LimaInstancePathOf(limaInstanceName), os.Stdout),
+		newListVMCommand(limaCmdCreator, logger, fc, fs, os.Stdout),
+		newAuditVMCommand(logger, fs, auditLogPath, os.Stdout),
+		withAudit(newInitVMCommand(limaCmdCreator, logger, optionalDepGroups, lca, nca, fc, fp.BaseYamlFilePath(), fs,
+			fp.LimaSSHPrivateKeyPath(), diskManager, fp.LimaInstancePathOf(limaInstanceName)), fs, logger, auditLogPath),
+		withAudit(newResetVMCommand(limaCmdCreator, logger, optionalDepGroups, lca, nca, fc, fp.BaseYamlFilePath(), fs,
+			fp.LimaSSHPrivateKeyPath(), diskManager, fp.LimaInstancePathOf(limaInstanceName)), fs, logger, auditLogPath),
+		newTopVMCommand(limaCmdCreator, logger, optionalDepGroups, lca, nca, fc, fs, fp.LimaSSHPrivateKeyPath(), diskManager,
+			fp.LimaInstancePathOf(limaInstanceName), auditLogPath, os.Stdin, os.Stdout),
 		newSettingsVMCommand(logger, lca, fs, os.Stdout),
+		newDiskVMCommand(limaCmdCreator, diskManager, logger, fs, auditLogPath),
+		newUseContextVMCommand(logger, fs, fp.ContextsFilePath(finchRootPath)),
 	)
 
 	return virtualMachineCommand
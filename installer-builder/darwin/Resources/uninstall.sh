@@ -14,14 +14,41 @@ while true; do
     echo "Please answer with 'y' or 'n'"
 done
 
+#ask before anything is removed, the user data disk of the VM is only removed along with ~/.finch
+delete_user_data=false
+while true; do
+  read -r -p "Delete ~/.finch containing persistent user data [Y/n]? " answer
+  if [[ $answer == "y" || $answer == "Y" ]]
+  then
+    delete_user_data=true
+    break
+  elif [[ $answer == "n" || $answer == "N" || $answer == "" ]]
+  then
+    break
+  else
+    echo "Please answer with 'y' or 'n'"
+  fi
+done
+
 echo "Application uninstalling process started"
+
+#stop and remove the VM as the user it belongs to, the processes left behind are killed below
+uninstall_args=(vm stop --uninstall --yes)
+[ "$delete_user_data" = true ] && uninstall_args+=(--remove-user-data)
+if [ -x /Applications/Finch/bin/finch ] && sudo -u "${SUDO_USER:-root}" /Applications/Finch/bin/finch "${uninstall_args[@]}"
+then
+  echo "[1/5] [DONE] Successfully removed the virtual machine"
+else
+  echo "[1/5] [ERROR] Could not remove the virtual machine" >&2
+fi
+
 sudo pkill '^socket_vmnet'
 sudo pkill '^qemu-system-'
 sudo pkill '^limactl'
 
 if [ "$$(readlink "/usr/local/bin/finch")" = "/Applications/Finch/bin/finch" ]; then sudo rm /usr/local/bin/finch; fi
 
-echo "[1/4] [DONE] Successfully deleted shortcut links"
+echo "[2/5] [DONE] Successfully deleted shortcut links"
 
 #forget from pkgutil
 echo "Remove historical pkgutil packages..."
@@ -32,41 +59,33 @@ done
 
 if [ $? -eq 0 ]
 then
-  echo "[2/4] [DONE] Successfully deleted application informations"
+  echo "[3/5] [DONE] Successfully deleted application informations"
 else
-  echo "[2/4] [ERROR] Could not delete application informations" >&2
+  echo "[3/5] [ERROR] Could not delete application informations" >&2
 fi
 
 #remove application source distribution
 [ -e "/Applications/Finch" ] && rm -rf /Applications/Finch && rm -rf /opt/finch && rm -rf /private/var/run/finch-lima && rm -rf /private/etc/sudoers.d/finch-lima
 if [ $? -eq 0 ]
 then
-  echo "[3/4] [DONE] Successfully deleted application"
+  echo "[4/5] [DONE] Successfully deleted application"
 else
-  echo "[3/4] [ERROR] Could not delete application" >&2
+  echo "[4/5] [ERROR] Could not delete application" >&2
 fi
 
 #clean up ~/.finch directory
-while true; do
-  read -r -p "Delete ~/.finch containing persistent user data [Y/n]? " answer
-  if [[ $answer == "y" || $answer == "Y" ]]
-  then
-    [ -d ~/.finch ] && rm -rf ~/.finch
-    if [ $? -eq 0 ]
-    then
-        echo "[4/4] [DONE] Successfully deleted ~/.finch"
-    else
-        echo "[4/4] [ERROR] Could not delete ~/.finch" >&2
-    fi
-    break
-  elif [[ $answer == "n" || $answer == "N" || $answer == "" ]]
+if [ "$delete_user_data" = true ]
+then
+  [ -d ~/.finch ] && rm -rf ~/.finch
+  if [ $? -eq 0 ]
   then
-    echo "[4/4] Deletion of ~/.finch was aborted."
-    break
+      echo "[5/5] [DONE] Successfully deleted ~/.finch"
   else
-    echo "Please answer with 'y' or 'n'"
+      echo "[5/5] [ERROR] Could not delete ~/.finch" >&2
   fi
-done
+else
+  echo "[5/5] Deletion of ~/.finch was aborted."
+fi
 
 echo "Application uninstall process finished"
 exit 0
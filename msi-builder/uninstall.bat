@@ -1,9 +1,9 @@
 @echo off
 SET InstallDir=%~1
 
-:: Stop and remove any running instance
-finch.exe vm stop -f ^ & 
-finch.exe vm remove -f ^ & 
+:: Stop and remove any running instance. The user data disk is kept, the uninstaller doesn't ask about
+:: the user data, and it runs on upgrades too.
+finch.exe vm stop --uninstall --yes ^ & 
 
 :: Just in case
 wsl --terminate lima-finch ^ & 
@@ -0,0 +1,201 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package audit keeps a tamper-evident log of the changes of state of the virtual machine,
+// e.g. who stopped it and when.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// keySize is the size in bytes of the key the records are signed with.
+const keySize = 32
+
+// The results of the recorded commands.
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// Record is an entry of the audit log, which holds a JSON record per line.
+type Record struct {
+	Time     time.Time `json:"time"`
+	User     string    `json:"user"`
+	Command  string    `json:"command"`
+	Instance string    `json:"instance"`
+	Result   string    `json:"result"`
+	Error    string    `json:"error,omitempty"`
+	// Annotations are the key=value pairs the user attached to the command, e.g. with finch vm stop --annotate.
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// PrevHash is the hash of the previous record, empty for the first record of the log.
+	PrevHash string `json:"prevHash,omitempty"`
+	// Hash is the hex encoded HMAC-SHA256 of the record without it, keyed with the key of the log, see KeyPath.
+	// It chains the record to the previous ones: altering or removing a record breaks the chain from there on,
+	// and the chain can't be rebuilt without the key.
+	Hash string `json:"hash"`
+}
+
+// NewRecord makes the record of the command run by the current user on the instance, which failed if err isn't nil.
+func NewRecord(command, instance string, err error) Record {
+	r := Record{
+		Time:     time.Now().UTC(),
+		User:     currentUser(),
+		Command:  command,
+		Instance: instance,
+		Result:   ResultSuccess,
+	}
+	if err != nil {
+		r.Result = ResultFailure
+		r.Error = err.Error()
+	}
+	return r
+}
+
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return os.Getenv("USERNAME")
+}
+
+// KeyPath returns the path to the key the records of the audit log at path are signed with. It's kept out of the log,
+// readable by its owner only, so that rewriting the log isn't enough to forge a chain of records.
+func KeyPath(path string) string {
+	return filepath.Join(filepath.Dir(path), "audit.key")
+}
+
+// ReadKey returns the key the records of the audit log at path are signed with, see KeyPath.
+func ReadKey(afs afero.Fs, path string) ([]byte, error) {
+	key, err := afero.ReadFile(afs, KeyPath(path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the key of the audit log: %w", err)
+	}
+	return key, nil
+}
+
+// readOrCreateKey returns the key of the audit log at path, generating it on the first record.
+func readOrCreateKey(afs afero.Fs, path string) ([]byte, error) {
+	key, err := afero.ReadFile(afs, KeyPath(path))
+	if err == nil {
+		return key, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read the key of the audit log: %w", err)
+	}
+	key = make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate the key of the audit log: %w", err)
+	}
+	if err := afs.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create the directory of the audit log: %w", err)
+	}
+	if err := afero.WriteFile(afs, KeyPath(path), key, 0o600); err != nil {
+		return nil, fmt.Errorf("failed to write the key of the audit log: %w", err)
+	}
+	return key, nil
+}
+
+// Append chains the record to the last one of the audit log at path and appends it to the log.
+// The log is only ever appended to, the records already in it are left untouched.
+func Append(afs afero.Fs, path string, r Record) error {
+	records, err := Read(afs, path)
+	if err != nil {
+		return err
+	}
+	key, err := readOrCreateKey(afs, path)
+	if err != nil {
+		return err
+	}
+	r.PrevHash = ""
+	if len(records) > 0 {
+		r.PrevHash = records[len(records)-1].Hash
+	}
+	r.Hash = sign(r, key)
+	line, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to marshal the audit record: %w", err)
+	}
+
+	if err := afs.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create the directory of the audit log: %w", err)
+	}
+	f, err := afs.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open the audit log: %w", err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to write the audit record: %w", err)
+	}
+	return f.Close()
+}
+
+// Read returns the records of the audit log at path, in the order they were appended.
+// A log that doesn't exist yet has no records.
+func Read(afs afero.Fs, path string) ([]Record, error) {
+	b, err := afero.ReadFile(afs, path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read the audit log: %w", err)
+	}
+	var records []Record
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("failed to parse the audit record on line %d: %w", line, err)
+		}
+		records = append(records, r)
+	}
+	return records, scanner.Err()
+}
+
+// Verify checks that the records form an unbroken chain signed with key, i.e. that none of them was altered
+// or removed, and reports the first record that doesn't.
+func Verify(records []Record, key []byte) error {
+	prev := ""
+	for i, r := range records {
+		if r.PrevHash != prev {
+			return fmt.Errorf("the audit record %d doesn't follow the previous one, a record was removed or altered", i+1)
+		}
+		if !hmac.Equal([]byte(sign(r, key)), []byte(r.Hash)) {
+			return fmt.Errorf("the audit record %d was altered", i+1)
+		}
+		prev = r.Hash
+	}
+	return nil
+}
+
+// sign returns the HMAC of the record keyed with key, which covers all of its fields but the hash itself.
+func sign(r Record, key []byte) string {
+	r.Hash = ""
+	// Marshaling a Record can't fail, its fields are all plain values.
+	b, _ := json.Marshal(r)
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write(b)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,168 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package audit_test
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/runfinch/finch/pkg/audit"
+)
+
+const mockAuditLogPath = "/home/.finch/audit.log"
+
+func readKey(t *testing.T, fs afero.Fs) []byte {
+	key, err := audit.ReadKey(fs, mockAuditLogPath)
+	require.NoError(t, err)
+	return key
+}
+
+func TestNewRecord(t *testing.T) {
+	t.Parallel()
+
+	r := audit.NewRecord("stop", "finch", nil)
+	assert.Equal(t, "stop", r.Command)
+	assert.Equal(t, "finch", r.Instance)
+	assert.Equal(t, audit.ResultSuccess, r.Result)
+	assert.Empty(t, r.Error)
+	assert.False(t, r.Time.IsZero())
+
+	r = audit.NewRecord("start", "finch", errors.New("exit status 1"))
+	assert.Equal(t, audit.ResultFailure, r.Result)
+	assert.Equal(t, "exit status 1", r.Error)
+}
+
+func TestAppend(t *testing.T) {
+	t.Parallel()
+
+	fs := afero.NewMemMapFs()
+	require.NoError(t, audit.Append(fs, mockAuditLogPath, audit.NewRecord("start", "finch", nil)))
+	require.NoError(t, audit.Append(fs, mockAuditLogPath, audit.NewRecord("stop", "finch", nil)))
+
+	records, err := audit.Read(fs, mockAuditLogPath)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, "start", records[0].Command)
+	assert.Empty(t, records[0].PrevHash)
+	assert.Equal(t, "stop", records[1].Command)
+	assert.Equal(t, records[0].Hash, records[1].PrevHash)
+	assert.NoError(t, audit.Verify(records, readKey(t, fs)))
+
+	// The key is kept out of the log, readable by its owner only.
+	assert.Equal(t, "/home/.finch/audit.key", audit.KeyPath(mockAuditLogPath))
+	info, err := fs.Stat(audit.KeyPath(mockAuditLogPath))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	assert.Len(t, readKey(t, fs), 32)
+}
+
+func TestAppend_annotations(t *testing.T) {
+	t.Parallel()
+
+	fs := afero.NewMemMapFs()
+	r := audit.NewRecord("stop", "finch", nil)
+	r.Annotations = map[string]string{"ticket": "JIRA-123"}
+	require.NoError(t, audit.Append(fs, mockAuditLogPath, r))
+
+	records, err := audit.Read(fs, mockAuditLogPath)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, map[string]string{"ticket": "JIRA-123"}, records[0].Annotations)
+	require.NoError(t, audit.Verify(records, readKey(t, fs)))
+
+	// The annotations are covered by the hash like the rest of the record.
+	records[0].Annotations["ticket"] = "JIRA-456"
+	assert.EqualError(t, audit.Verify(records, readKey(t, fs)), "the audit record 1 was altered")
+}
+
+func TestRead(t *testing.T) {
+	t.Parallel()
+
+	t.Run("should return no records if the audit log doesn't exist", func(t *testing.T) {
+		t.Parallel()
+
+		records, err := audit.Read(afero.NewMemMapFs(), mockAuditLogPath)
+		require.NoError(t, err)
+		assert.Empty(t, records)
+	})
+
+	t.Run("should fail on a record that isn't JSON", func(t *testing.T) {
+		t.Parallel()
+
+		fs := afero.NewMemMapFs()
+		require.NoError(t, afero.WriteFile(fs, mockAuditLogPath, []byte("{}\nnot json\n"), 0o600))
+		_, err := audit.Read(fs, mockAuditLogPath)
+		assert.ErrorContains(t, err, "failed to parse the audit record on line 2")
+	})
+}
+
+func TestVerify(t *testing.T) {
+	t.Parallel()
+
+	newLog := func(t *testing.T) ([]audit.Record, []byte) {
+		fs := afero.NewMemMapFs()
+		for _, command := range []string{"start", "stop", "remove"} {
+			require.NoError(t, audit.Append(fs, mockAuditLogPath, audit.NewRecord(command, "finch", nil)))
+		}
+		records, err := audit.Read(fs, mockAuditLogPath)
+		require.NoError(t, err)
+		return records, readKey(t, fs)
+	}
+
+	testCases := []struct {
+		name    string
+		alter   func([]audit.Record) []audit.Record
+		key     []byte
+		wantErr string
+	}{
+		{
+			name:  "should succeed on an unaltered log",
+			alter: func(records []audit.Record) []audit.Record { return records },
+		},
+		{
+			name: "should report an altered record",
+			alter: func(records []audit.Record) []audit.Record {
+				records[1].User = strings.ToUpper(records[1].User) + "-mallory"
+				return records
+			},
+			wantErr: "the audit record 2 was altered",
+		},
+		{
+			name: "should report a removed record",
+			alter: func(records []audit.Record) []audit.Record {
+				return append(records[:1], records[2:]...)
+			},
+			wantErr: "the audit record 2 doesn't follow the previous one, a record was removed or altered",
+		},
+		{
+			name:    "should report a log signed with another key",
+			alter:   func(records []audit.Record) []audit.Record { return records },
+			key:     []byte("another key"),
+			wantErr: "the audit record 1 was altered",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			records, key := newLog(t)
+			if tc.key != nil {
+				key = tc.key
+			}
+			err := audit.Verify(tc.alter(records), key)
+			if tc.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tc.wantErr)
+		})
+	}
+}
@@ -31,4 +31,9 @@ type Command interface {
 	Wait() error
 	Output() ([]byte, error)
 	CombinedOutput() ([]byte, error)
+	// Kill kills the process of the command if it has been started, and keeps it from starting otherwise.
+	Kill()
+	// Detach makes the command run in a session of its own, or a process group of its own on Windows, so that it goes
+	// on after finch exits and doesn't get the signals sent to the terminal finch runs in. It's called before Start.
+	Detach()
 }
@@ -4,10 +4,16 @@
 package command
 
 import (
+	"context"
 	"io"
 	"os/exec"
+	"time"
 )
 
+// killWaitDelay is how long the output of a killed command is still read for. A process it started can keep
+// its output open after it's gone, which would otherwise keep Wait from returning until that process exits too.
+const killWaitDelay = 5 * time.Second
+
 // ExecCmdCreator implements CommandCreator by invoking functions offered by os/exec.
 type ExecCmdCreator struct{}
 
@@ -24,13 +30,18 @@ func (ecc *ExecCmdCreator) Create(name string, args ...string) Command {
 }
 
 func newExecCmd(name string, args ...string) *execCmd {
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.WaitDelay = killWaitDelay
 	return &execCmd{
-		exec.Command(name, args...),
+		Cmd:    cmd,
+		cancel: cancel,
 	}
 }
 
 type execCmd struct {
 	*exec.Cmd
+	cancel context.CancelFunc
 }
 
 var _ Command = (*execCmd)(nil)
@@ -63,3 +74,7 @@ func (c *execCmd) SetStderr(stderr io.Writer) {
 func (c *execCmd) StdinPipe() (io.WriteCloser, error) {
 	return c.Cmd.StdinPipe()
 }
+
+func (c *execCmd) Kill() {
+	c.cancel()
+}
@@ -5,7 +5,11 @@ package command
 
 import (
 	"bytes"
+	"context"
+	"os"
+	"runtime"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -53,3 +57,36 @@ func TestExecCommand_SetStderr(t *testing.T) {
 	cmd.SetStderr(buf)
 	assert.Equal(t, cmd.Stderr, buf)
 }
+
+func TestExecCommand_Kill(t *testing.T) {
+	t.Parallel()
+
+	cmd := newExecCmd(os.Args[0])
+	cmd.Kill()
+	assert.ErrorIs(t, cmd.Run(), context.Canceled)
+}
+
+func TestExecCommand_KillWithOutputHeldOpen(t *testing.T) {
+	t.Parallel()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("the command is run with sh")
+	}
+	cmd := newExecCmd("sh", "-c", "sleep 30 & sleep 30")
+	assert.Equal(t, killWaitDelay, cmd.WaitDelay)
+	cmd.WaitDelay = 100 * time.Millisecond
+	done := make(chan error, 1)
+	go func() {
+		_, err := cmd.CombinedOutput()
+		done <- err
+	}()
+	// Wait for it to start, the sleep in the background keeps the output open once sh is killed.
+	time.Sleep(100 * time.Millisecond)
+	cmd.Kill()
+	select {
+	case err := <-done:
+		assert.Error(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("the killed command didn't return while a process it started kept its output open")
+	}
+}
@@ -0,0 +1,12 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !windows
+
+package command
+
+import "syscall"
+
+func (c *execCmd) Detach() {
+	c.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+}
@@ -0,0 +1,30 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !windows
+
+package command
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecCommand_Detach(t *testing.T) {
+	t.Parallel()
+
+	cmd := newExecCmd("sleep", "30")
+	cmd.Detach()
+	require.NoError(t, cmd.Start())
+	defer func() {
+		cmd.Kill()
+		_ = cmd.Wait()
+	}()
+	// The leader of a new session leads a new process group too.
+	pgid, err := syscall.Getpgid(cmd.Process.Pid)
+	require.NoError(t, err)
+	assert.Equal(t, cmd.Process.Pid, pgid)
+}
@@ -16,6 +16,15 @@ import (
 // This is exported to facilitate unit testing, since it uses a different package (command_test).
 const EnvKeyLimaHome = "LIMA_HOME"
 
+// LimaHomeTargeter is implemented by the NerdctlCmdCreator that runs limactl,
+// so that the commands can target a Lima home other than the one of Finch.
+type LimaHomeTargeter interface {
+	// WithLimaHome returns a NerdctlCmdCreator that creates the same commands, but with limaHomePath as the Lima home.
+	WithLimaHome(limaHomePath string) NerdctlCmdCreator
+}
+
+var _ LimaHomeTargeter = (*nerdctlCmdCreator)(nil)
+
 type nerdctlCmdCreator struct {
 	cmdCreator   Creator
 	logger       flog.Logger
@@ -46,6 +55,12 @@ func NewNerdctlCmdCreator(
 	}
 }
 
+func (ncc *nerdctlCmdCreator) WithLimaHome(limaHomePath string) NerdctlCmdCreator {
+	retargeted := *ncc
+	retargeted.limaHomePath = limaHomePath
+	return &retargeted
+}
+
 func (ncc *nerdctlCmdCreator) create(stdin io.Reader, stdout, stderr io.Writer, args ...string) Command {
 	ncc.logger.Debugf("Creating limactl command: ARGUMENTS: %v, %s: %s", args, EnvKeyLimaHome, ncc.limaHomePath)
 	cmd := ncc.cmdCreator.Create(ncc.limactlPath, args...)
@@ -121,6 +121,35 @@ func TestNerdctlCmdCreator_CreateWithoutStdio(t *testing.T) {
 	}
 }
 
+func TestNerdctlCmdCreator_WithLimaHome(t *testing.T) {
+	t.Parallel()
+
+	const otherLimaHomePath = "/other/lima/home"
+
+	ctrl := gomock.NewController(t)
+	cmdCreator := mocks.NewCommandCreator(ctrl)
+	cmd := mocks.NewCommand(ctrl)
+	logger := mocks.NewLogger(ctrl)
+	lcd := mocks.NewNerdctlCmdCreatorSystemDeps(ctrl)
+
+	logger.EXPECT().Debugf("Creating limactl command: ARGUMENTS: %v, %s: %s", mockArgs, command.EnvKeyLimaHome, otherLimaHomePath)
+	cmdCreator.EXPECT().Create(mockLimactlPath, mockArgs).Return(cmd)
+	lcd.EXPECT().Environ().Return([]string{})
+	lcd.EXPECT().Env(command.EnvKeyPath).Return(mockSystemPath)
+	cmd.EXPECT().SetEnv([]string{
+		fmt.Sprintf("%s=%s", command.EnvKeyLimaHome, otherLimaHomePath),
+		fmt.Sprintf("%s=%s", command.EnvKeyPath, finalPath),
+	})
+	cmd.EXPECT().SetStdin(nil)
+	cmd.EXPECT().SetStdout(nil)
+	cmd.EXPECT().SetStderr(nil)
+
+	ncc := command.NewNerdctlCmdCreator(cmdCreator, logger, mockLimaHomePath, mockLimactlPath, mockQemuBinPath, lcd)
+	targeter, ok := ncc.(command.LimaHomeTargeter)
+	require.True(t, ok)
+	targeter.WithLimaHome(otherLimaHomePath).CreateWithoutStdio(mockArgs...)
+}
+
 func TestNerdctlCmdCreator_RunWithReplacingStdout(t *testing.T) {
 	t.Parallel()
 
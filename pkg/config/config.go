@@ -13,6 +13,7 @@ import (
 	"errors"
 	"fmt"
 	"path/filepath"
+	"time"
 
 	"github.com/lima-vm/lima/pkg/limayaml"
 	"github.com/spf13/afero"
@@ -31,7 +32,158 @@ type AdditionalDirectory struct {
 
 // SharedSystemSettings represents all settings shared by virtualized Finch configurations.
 type SharedSystemSettings struct {
-	VMType *limayaml.VMType `yaml:"vmType,omitempty"`
+	VMType     *limayaml.VMType   `yaml:"vmType,omitempty"`
+	Nested     NestedSettings     `yaml:"nested,omitempty"`
+	Disk       DiskSettings       `yaml:"disk,omitempty"`
+	Stop       StopSettings       `yaml:"stop,omitempty"`
+	Network    NetworkSettings    `yaml:"network,omitempty"`
+	BuildKit   BuildKitSettings   `yaml:"buildkit,omitempty"`
+	Containerd ContainerdSettings `yaml:"containerd,omitempty"`
+	Telemetry  TelemetrySettings  `yaml:"telemetry,omitempty"`
+	Retry      RetryPolicy        `yaml:"retry,omitempty"`
+	Logging    LoggingSettings    `yaml:"logging,omitempty"`
+	// InstancePrefix is prepended to the name of the Lima instance of Finch and of its user data disk,
+	// so that the users of a shared machine each get their own instance, e.g. "alice-" for "alice-finch".
+	InstancePrefix string `yaml:"instancePrefix,omitempty"`
+}
+
+// defaultInstanceName is the name of the Lima instance of Finch without an InstancePrefix.
+const defaultInstanceName = "finch"
+
+// InstanceName returns the name of the Lima instance of Finch, which is also the name of its user data disk.
+func (s SharedSystemSettings) InstanceName() string {
+	return s.InstancePrefix + defaultInstanceName
+}
+
+// BuildKitSettings represents the settings of the BuildKit daemon running in the VM.
+type BuildKitSettings struct {
+	// PersistOnStop makes finch vm stop shut BuildKit down and flush its cache to the user data disk
+	// before detaching it, so that the cache isn't lost or left inconsistent by the stop.
+	PersistOnStop bool `yaml:"persistOnStop,omitempty"`
+}
+
+// ContainerdSettings represents the settings of the containerd daemon running in the VM.
+type ContainerdSettings struct {
+	// FlushOnStop makes finch vm stop flush the content store of containerd to the user data disk
+	// before detaching it, so that the images aren't left corrupted by the stop.
+	FlushOnStop bool `yaml:"flushOnStop,omitempty"`
+}
+
+// TelemetrySettings represents the settings of the collection of anonymized lifecycle events of the VM,
+// see the telemetry package.
+type TelemetrySettings struct {
+	// Enabled opts in to the collection, the events can be inspected with finch telemetry preview.
+	Enabled bool `yaml:"enabled,omitempty"`
+	// Endpoint is the URL the batches of events are posted to. The events are kept locally until it's set.
+	Endpoint string `yaml:"endpoint,omitempty"`
+}
+
+// LoggingSettings represents where the logs of Finch go, in addition to the console.
+type LoggingSettings struct {
+	// Syslog is the address of a syslog server the logs are forwarded to in RFC 5424 format,
+	// e.g. "udp://logs.example.com:514". The scheme is the transport, either udp, tcp or tls.
+	// Failing to reach the server doesn't fail the commands.
+	Syslog string `yaml:"syslog,omitempty"`
+}
+
+// NetworkSettings represents the settings of the network of the VM.
+type NetworkSettings struct {
+	// CleanupOnForceStop makes finch vm stop --force remove the network state of the VM that a forced stop leaves behind,
+	// which would otherwise prevent the VM from starting again.
+	CleanupOnForceStop bool `yaml:"cleanupOnForceStop,omitempty"`
+}
+
+// StopMethod is the way finch vm stop shuts the VM down.
+type StopMethod string
+
+const (
+	// StopMethodLimactl stops the VM with limactl stop, it's the default.
+	StopMethodLimactl StopMethod = "limactl"
+	// StopMethodSystemdPoweroff powers the guest off with systemctl poweroff, then waits for the VM to be stopped.
+	StopMethodSystemdPoweroff StopMethod = "systemd-poweroff"
+)
+
+// StopFromSuspend is what finch vm stop does with an instance whose VM state is saved, e.g. a hibernated one.
+type StopFromSuspend string
+
+const (
+	// StopFromSuspendResume resumes the instance to stop it cleanly, it's the default.
+	StopFromSuspendResume StopFromSuspend = "resume"
+	// StopFromSuspendDiscard forcibly stops the instance without resuming it, which discards its saved VM state.
+	StopFromSuspendDiscard StopFromSuspend = "discard"
+)
+
+// StopSettings represents the settings of finch vm stop.
+type StopSettings struct {
+	// Method is the way the VM is shut down, StopMethodLimactl if unset.
+	Method StopMethod `yaml:"method,omitempty"`
+	// DefaultForce makes finch vm stop force the stop unless --force=false, or an option that needs a graceful stop,
+	// is passed.
+	DefaultForce bool `yaml:"defaultForce,omitempty"`
+	// ReportTo is the URL of a webhook the result of each stop is posted to, it can be overridden with --report-to.
+	ReportTo string `yaml:"reportTo,omitempty"`
+	// ReportSecret is the key the reports are signed with, so that the webhook can verify where they come from.
+	ReportSecret string `yaml:"reportSecret,omitempty"`
+	// VerifyPollInterval is how often the status of the VM is polled while waiting for it to stop, 500ms if unset.
+	VerifyPollInterval time.Duration `yaml:"verifyPollInterval,omitempty"`
+	// VerifyTimeout is how long the VM gets to be reported as stopped, 2m if unset. It can be overridden with --timeout.
+	VerifyTimeout time.Duration `yaml:"verifyTimeout,omitempty"`
+	// GuestTimeout is how long a guest powered off with StopMethodSystemdPoweroff gets to stop before the stop escalates,
+	// first to powering it off from the guest kernel, then to forcibly stopping it from the host. No escalation if unset.
+	GuestTimeout time.Duration `yaml:"guestTimeout,omitempty"`
+	// SelfDumpAfter is how long a stop can go on before a dump of the goroutines of finch is saved to the diagnostics
+	// directory, to debug finch itself hanging. No dump is taken if unset.
+	SelfDumpAfter time.Duration `yaml:"selfDumpAfter,omitempty"`
+	// DrainNamespace is the containerd namespace of the containers drained before the VM is stopped,
+	// the one nerdctl is configured with by Finch if unset. It can be overridden with --namespace.
+	DrainNamespace string `yaml:"drainNamespace,omitempty"`
+	// CommandTemplate is a Go template of the arguments limactl is run with to gracefully or forcibly stop the VM,
+	// rendered with .Instance and .Force and split on whitespace. `stop [--force] <instance>` is run if unset.
+	CommandTemplate string `yaml:"commandTemplate,omitempty"`
+	// DependsOn lists the instances each instance depends on, by name. The instances stopped together with
+	// --instance-file are stopped in an order such that each one is stopped before those it depends on.
+	DependsOn map[string][]string `yaml:"dependsOn,omitempty"`
+	// FromSuspend is what is done with an instance whose VM state is saved, StopFromSuspendResume if unset.
+	FromSuspend StopFromSuspend `yaml:"fromSuspend,omitempty"`
+	// AutoForceOnPressure makes finch vm stop forcibly stop the VM when the host is under memory pressure.
+	AutoForceOnPressure AutoForceOnPressureSettings `yaml:"autoForceOnPressure,omitempty"`
+}
+
+// AutoForceOnPressureSettings represents when the host is too short on memory for the guest to stop cleanly,
+// e.g. to flush its writes, in which case the VM is forcibly stopped instead.
+type AutoForceOnPressureSettings struct {
+	// Enabled makes finch vm stop forcibly stop the VM when the host is under memory pressure.
+	Enabled bool `yaml:"enabled,omitempty"`
+	// MinAvailableMemory is the memory the host must have available, e.g. "512MiB", below which it's under pressure.
+	// 256MiB if unset.
+	MinAvailableMemory string `yaml:"minAvailableMemory,omitempty"`
+	// MaxSwapUsage is the percentage of the swap in use above which the host is under pressure, the swap isn't
+	// considered if unset.
+	MaxSwapUsage int `yaml:"maxSwapUsage,omitempty"`
+}
+
+// DiskSettings represents the settings of the user data disk.
+type DiskSettings struct {
+	// Ephemeral indicates that the user data disk doesn't outlive the VM (e.g. it is backed by tmpfs),
+	// so it isn't detached when the VM stops.
+	Ephemeral bool `yaml:"ephemeral,omitempty"`
+	// Additional are the paths of the disks attached to the VM after the user data disk, in order (Windows only).
+	Additional []string `yaml:"additional,omitempty"`
+	// DetachOrder are the paths of the attached disks in the order they must be detached in, e.g. when one is mounted
+	// under another. The disks that aren't listed are detached afterwards, in the reverse of the order they were attached in.
+	DetachOrder []string `yaml:"detachOrder,omitempty"`
+	// LockFile is the path of a lock file on the storage shared by the hosts that use the same user data disk,
+	// e.g. on a network share, so that only one of them starts, stops or removes the VM at a time.
+	LockFile string `yaml:"lockFile,omitempty"`
+	// Backend is the name of the disk backend managing the user data disk, one of those registered with
+	// disk.RegisterBackend. The user data disk managed by Finch itself is used by default.
+	Backend string `yaml:"backend,omitempty"`
+}
+
+// NestedSettings represents the settings for running Finch inside the Finch VM.
+type NestedSettings struct {
+	// GracefulStop stops the Finch VM running inside the guest, if any, before stopping the guest itself.
+	GracefulStop bool `yaml:"gracefulStop,omitempty"`
 }
 
 // SharedSettings represents settings shared by all Finch configurations.
@@ -176,3 +176,10 @@ func Test_ensureConfigDir(t *testing.T) {
 		})
 	}
 }
+
+func TestSharedSystemSettings_InstanceName(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "finch", SharedSystemSettings{}.InstanceName())
+	assert.Equal(t, "alice-finch", SharedSystemSettings{InstancePrefix: "alice-"}.InstanceName())
+}
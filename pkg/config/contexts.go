@@ -0,0 +1,105 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+
+	"github.com/spf13/afero"
+	"gopkg.in/yaml.v3"
+)
+
+// Contexts selects the instance of Finch the VM commands operate on, like a kubeconfig selects a cluster,
+// for the users juggling several instances. It's kept apart from finch.yaml, which all the instances share.
+type Contexts struct {
+	// CurrentContext is the name of the context in use, the instance of finch.yaml is used if unset.
+	CurrentContext string    `yaml:"current-context,omitempty"`
+	Contexts       []Context `yaml:"contexts,omitempty"`
+}
+
+// Context names an instance of Finch by the prefix of its name, see SharedSystemSettings.InstancePrefix.
+type Context struct {
+	Name           string `yaml:"name"`
+	InstancePrefix string `yaml:"instancePrefix,omitempty"`
+}
+
+// LoadContexts reads the contexts file at path, there are no contexts if it doesn't exist.
+func LoadContexts(afs afero.Fs, path string) (*Contexts, error) {
+	b, err := afero.ReadFile(afs, path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &Contexts{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the contexts file: %w", err)
+	}
+	var c Contexts
+	if err := yaml.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("failed to parse the contexts file %q: %w", path, err)
+	}
+	for _, ctx := range c.Contexts {
+		if err := validateInstancePrefix(SharedSystemSettings{InstancePrefix: ctx.InstancePrefix}); err != nil {
+			return nil, fmt.Errorf("invalid context %q: %w", ctx.Name, err)
+		}
+	}
+	return &c, nil
+}
+
+// Save writes the contexts to the contexts file at path.
+func (c *Contexts) Save(afs afero.Fs, path string) error {
+	b, err := yaml.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to marshal the contexts: %w", err)
+	}
+	if err := afero.WriteFile(afs, path, b, 0o600); err != nil {
+		return fmt.Errorf("failed to write the contexts file: %w", err)
+	}
+	return nil
+}
+
+// Current returns the context in use, or nil if there's none.
+func (c *Contexts) Current() (*Context, error) {
+	if c.CurrentContext == "" {
+		return nil, nil //nolint:nilnil // no context is in use
+	}
+	ctx := c.find(c.CurrentContext)
+	if ctx == nil {
+		return nil, fmt.Errorf("the current context %q doesn't exist", c.CurrentContext)
+	}
+	return ctx, nil
+}
+
+// Use makes the context called name the one in use.
+func (c *Contexts) Use(name string) error {
+	if c.find(name) == nil {
+		return fmt.Errorf("no context named %q", name)
+	}
+	c.CurrentContext = name
+	return nil
+}
+
+func (c *Contexts) find(name string) *Context {
+	for i := range c.Contexts {
+		if c.Contexts[i].Name == name {
+			return &c.Contexts[i]
+		}
+	}
+	return nil
+}
+
+// InstancePrefixOf returns the instance prefix that makes instance the name of the Lima instance of Finch.
+func InstancePrefixOf(instance string) (string, error) {
+	prefix, ok := strings.CutSuffix(instance, defaultInstanceName)
+	if !ok {
+		return "", fmt.Errorf("%q isn't the name of an instance of Finch, which all end with %q", instance, defaultInstanceName)
+	}
+	if err := validateInstancePrefix(SharedSystemSettings{InstancePrefix: prefix}); err != nil {
+		return "", err
+	}
+	return prefix, nil
+}
@@ -0,0 +1,128 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package config
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const mockContextsPath = "/home/.finch/contexts.yaml"
+
+func TestLoadContexts(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name        string
+		content     string
+		wantCurrent *Context
+		wantErr     string
+	}{
+		{
+			name: "should have no current context if the file doesn't exist",
+		},
+		{
+			name: "should return the current context",
+			content: `current-context: alice
+contexts:
+- name: default
+- name: alice
+  instancePrefix: alice-
+`,
+			wantCurrent: &Context{Name: "alice", InstancePrefix: "alice-"},
+		},
+		{
+			name: "should fail if the current context doesn't exist",
+			content: `current-context: bob
+contexts:
+- name: alice
+  instancePrefix: alice-
+`,
+			wantErr: `the current context "bob" doesn't exist`,
+		},
+		{
+			name: "should reject a context with an invalid instance prefix",
+			content: `contexts:
+- name: alice
+  instancePrefix: alice/
+`,
+			wantErr: `invalid context "alice": the instance prefix "alice/" doesn't make a valid instance name, ` +
+				"it must only contain letters, digits and single '-', '_' or '.' separators",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			fs := afero.NewMemMapFs()
+			if tc.content != "" {
+				require.NoError(t, afero.WriteFile(fs, mockContextsPath, []byte(tc.content), 0o600))
+			}
+			contexts, err := LoadContexts(fs, mockContextsPath)
+			if err == nil {
+				var current *Context
+				current, err = contexts.Current()
+				assert.Equal(t, tc.wantCurrent, current)
+			}
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestContexts_Use(t *testing.T) {
+	t.Parallel()
+
+	fs := afero.NewMemMapFs()
+	contexts := &Contexts{Contexts: []Context{{Name: "default"}, {Name: "alice", InstancePrefix: "alice-"}}}
+	assert.EqualError(t, contexts.Use("bob"), `no context named "bob"`)
+	require.NoError(t, contexts.Use("alice"))
+	require.NoError(t, contexts.Save(fs, mockContextsPath))
+
+	loaded, err := LoadContexts(fs, mockContextsPath)
+	require.NoError(t, err)
+	assert.Equal(t, contexts, loaded)
+}
+
+func TestInstancePrefixOf(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		instance string
+		want     string
+		wantErr  string
+	}{
+		{instance: "finch", want: ""},
+		{instance: "alice-finch", want: "alice-"},
+		{instance: "alice", wantErr: `"alice" isn't the name of an instance of Finch, which all end with "finch"`},
+		{
+			instance: "alice--finch",
+			wantErr: `the instance prefix "alice--" doesn't make a valid instance name, ` +
+				"it must only contain letters, digits and single '-', '_' or '.' separators",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.instance, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := InstancePrefixOf(tc.instance)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
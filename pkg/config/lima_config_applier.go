@@ -174,7 +174,7 @@ func (lca *limaConfigApplier) ConfigureOverrideLimaYaml() error {
 	lca.configureMounts(&limaCfg)
 	if *lca.cfg.VMType != "wsl2" && len(limaCfg.AdditionalDisks) == 0 {
 		limaCfg.AdditionalDisks = append(limaCfg.AdditionalDisks, limayaml.Disk{
-			Name: "finch",
+			Name: lca.cfg.InstanceName(),
 		})
 	}
 
@@ -0,0 +1,79 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+// RetryStrategy is how the delay between the retries of an operation grows.
+type RetryStrategy string
+
+const (
+	// RetryFixed waits the base delay before each retry.
+	RetryFixed RetryStrategy = "fixed"
+	// RetryExponential doubles the delay for each retry, starting from the base delay.
+	RetryExponential RetryStrategy = "exponential"
+	// RetryExponentialJitter waits a random delay between half and all of the exponential delay, so that the hosts
+	// retrying against the same resource spread out. It's the default.
+	RetryExponentialJitter RetryStrategy = "exponential-jitter"
+)
+
+// Defaults of RetryPolicy.
+const (
+	DefaultRetryBase = 500 * time.Millisecond
+	DefaultRetryMax  = 30 * time.Second
+)
+
+// RetryPolicy represents how the operations on the VM that can fail transiently are retried,
+// e.g. finch vm stop --max-attempts or the unmount of the user data disk in the guest.
+type RetryPolicy struct {
+	// Strategy is RetryExponentialJitter if unset.
+	Strategy RetryStrategy `yaml:"strategy,omitempty"`
+	// Base is the delay before the first retry, DefaultRetryBase if unset.
+	Base time.Duration `yaml:"base,omitempty"`
+	// Max caps the delay between two retries, DefaultRetryMax if unset.
+	Max time.Duration `yaml:"max,omitempty"`
+}
+
+// Delay returns how long to wait before the retry-th retry, starting from 1.
+func (p RetryPolicy) Delay(retry int) time.Duration {
+	base, maxDelay := p.Base, p.Max
+	if base <= 0 {
+		base = DefaultRetryBase
+	}
+	if maxDelay <= 0 {
+		maxDelay = DefaultRetryMax
+	}
+	if p.Strategy == RetryFixed {
+		return min(base, maxDelay)
+	}
+
+	delay := base
+	for i := 1; i < retry && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxDelay)
+	if p.Strategy == RetryExponential {
+		return delay
+	}
+	return delay/2 + rand.N(delay/2+1) //nolint:gosec // the jitter doesn't need to be cryptographically secure
+}
+
+// validateRetryPolicy checks that the retry policy can be followed.
+func validateRetryPolicy(p RetryPolicy) error {
+	switch p.Strategy {
+	case "", RetryFixed, RetryExponential, RetryExponentialJitter:
+	default:
+		return fmt.Errorf("unsupported retry.strategy %q, it must be one of %q, %q or %q",
+			p.Strategy, RetryFixed, RetryExponential, RetryExponentialJitter)
+	}
+	if p.Base < 0 || p.Max < 0 {
+		return errors.New("retry.base and retry.max must not be negative")
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name   string
+		policy RetryPolicy
+		want   []time.Duration
+	}{
+		{
+			name:   "fixed",
+			policy: RetryPolicy{Strategy: RetryFixed, Base: time.Second},
+			want:   []time.Duration{time.Second, time.Second, time.Second},
+		},
+		{
+			name:   "exponential",
+			policy: RetryPolicy{Strategy: RetryExponential, Base: time.Second, Max: 3 * time.Second},
+			want:   []time.Duration{time.Second, 2 * time.Second, 3 * time.Second},
+		},
+		{
+			name:   "exponential with the defaults",
+			policy: RetryPolicy{Strategy: RetryExponential},
+			want:   []time.Duration{DefaultRetryBase, 2 * DefaultRetryBase, 4 * DefaultRetryBase},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			for i, want := range tc.want {
+				assert.Equal(t, want, tc.policy.Delay(i+1), "retry %d", i+1)
+			}
+		})
+	}
+}
+
+func TestRetryPolicy_DelayWithJitter(t *testing.T) {
+	t.Parallel()
+
+	// exponential-jitter is the default strategy.
+	policy := RetryPolicy{Base: time.Second}
+	for retry, exponential := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second} {
+		delay := policy.Delay(retry)
+		assert.GreaterOrEqual(t, delay, exponential/2, "retry %d", retry)
+		assert.LessOrEqual(t, delay, exponential, "retry %d", retry)
+	}
+}
+
+func TestValidateRetryPolicy(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, validateRetryPolicy(RetryPolicy{}))
+	assert.NoError(t, validateRetryPolicy(RetryPolicy{Strategy: RetryFixed, Base: time.Second, Max: time.Minute}))
+	assert.Equal(t, fmt.Errorf("unsupported retry.strategy %q, it must be one of %q, %q or %q",
+		"linear", RetryFixed, RetryExponential, RetryExponentialJitter), validateRetryPolicy(RetryPolicy{Strategy: "linear"}))
+	assert.EqualError(t, validateRetryPolicy(RetryPolicy{Base: -time.Second}), "retry.base and retry.max must not be negative")
+}
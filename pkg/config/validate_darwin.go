@@ -7,6 +7,7 @@ package config
 
 import (
 	"fmt"
+	"runtime"
 
 	"github.com/docker/go-units"
 
@@ -15,6 +16,13 @@ import (
 )
 
 func validate(cfg *Finch, log flog.Logger, systemDeps LoadSystemDeps, mem fmemory.Memory) error {
+	if err := validateInstancePrefix(cfg.SharedSystemSettings); err != nil {
+		return err
+	}
+	if err := validateRetryPolicy(cfg.Retry); err != nil {
+		return err
+	}
+
 	if *cfg.CPUs <= 0 {
 		return fmt.Errorf(
 			"specified number of CPUs (%d) must be greater than 0",
@@ -56,3 +64,17 @@ func validate(cfg *Finch, log flog.Logger, systemDeps LoadSystemDeps, mem fmemor
 
 	return nil
 }
+
+// validateVMType checks the VM type the way configureVirtualizationFramework does, which ignores it
+// when Rosetta is enabled on arm64.
+func validateVMType(cfg *Finch) error {
+	if cfg.VMType == nil || (cfg.Rosetta != nil && *cfg.Rosetta && runtime.GOARCH == "arm64") {
+		return nil
+	}
+	switch *cfg.VMType {
+	case "vz", "qemu":
+		return nil
+	default:
+		return fmt.Errorf("unsupported vm type \"%s\" for macOS", *cfg.VMType)
+	}
+}
@@ -103,6 +103,19 @@ func TestValidate(t *testing.T) {
 			},
 			err: nil,
 		},
+		{
+			name: "config specifies an instance prefix that doesn't make a valid instance name",
+			cfg: &Finch{
+				SystemSettings: SystemSettings{
+					SharedSystemSettings: SharedSystemSettings{InstancePrefix: "alice/"},
+					CPUs:                 pointer.Int(4),
+					Memory:               pointer.String("4GiB"),
+				},
+			},
+			mockSvc: func(_ *mocks.Logger, _ *mocks.LoadSystemDeps, _ *mocks.Memory) {},
+			err: errors.New(`the instance prefix "alice/" doesn't make a valid instance name, ` +
+				"it must only contain letters, digits and single '-', '_' or '.' separators"),
+		},
 	}
 
 	for _, tc := range testCases {
@@ -121,3 +134,14 @@ func TestValidate(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateVMType(t *testing.T) {
+	t.Parallel()
+
+	require.NoError(t, validateVMType(&Finch{SystemSettings: SystemSettings{SharedSystemSettings: SharedSystemSettings{
+		VMType: pointer.String("qemu"),
+	}}}))
+	require.EqualError(t, validateVMType(&Finch{SystemSettings: SystemSettings{SharedSystemSettings: SharedSystemSettings{
+		VMType: pointer.String("hyperkit"),
+	}}}), `unsupported vm type "hyperkit" for macOS`)
+}
@@ -0,0 +1,23 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package config
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// instanceNameRegex is what Lima accepts as the name of an instance.
+var instanceNameRegex = regexp.MustCompile(`^[A-Za-z0-9]+(?:[._-][A-Za-z0-9]+)*$`)
+
+// validateInstancePrefix checks that the instance prefix makes for a valid Lima instance name.
+func validateInstancePrefix(s SharedSystemSettings) error {
+	if !instanceNameRegex.MatchString(s.InstanceName()) {
+		return fmt.Errorf("the instance prefix %q doesn't make a valid instance name, "+
+			"it must only contain letters, digits and single '-', '_' or '.' separators", s.InstancePrefix)
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package config
+
+import "errors"
+
+// ValidateForStart checks the settings that are only validated when the VM starts, as the Lima config is generated
+// from them, on top of those validated by Load, so that the errors that would make the next start fail can be found
+// while the VM is still running. All the errors found are returned.
+func ValidateForStart(cfg *Finch) error {
+	var errs []error
+	if err := validateVMType(cfg); err != nil {
+		errs = append(errs, err)
+	}
+	for _, snapshotter := range cfg.Snapshotters {
+		if err := validateSnapshotter(snapshotter); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,51 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package config
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateForStart(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name         string
+		snapshotters []string
+		wantErr      error
+	}{
+		{
+			name:         "should accept the supported snapshotters",
+			snapshotters: []string{"soci", "overlayfs"},
+		},
+		{
+			name:         "should report each unsupported snapshotter",
+			snapshotters: []string{"zfs", "overlayfs", "btrfs"},
+			wantErr: errors.Join(
+				errors.New("snapshotter zfs is not supported"),
+				errors.New("snapshotter btrfs is not supported"),
+			),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := &Finch{}
+			cfg.Snapshotters = tc.snapshotters
+			err := ValidateForStart(cfg)
+			if tc.wantErr == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tc.wantErr.Error())
+		})
+	}
+}
@@ -6,10 +6,23 @@
 package config
 
 import (
+	"fmt"
+
 	"github.com/runfinch/finch/pkg/flog"
 	"github.com/runfinch/finch/pkg/fmemory"
 )
 
-func validate(_ *Finch, _ flog.Logger, _ LoadSystemDeps, _ fmemory.Memory) error {
-	return nil
+func validate(cfg *Finch, _ flog.Logger, _ LoadSystemDeps, _ fmemory.Memory) error {
+	if err := validateInstancePrefix(cfg.SharedSystemSettings); err != nil {
+		return err
+	}
+	return validateRetryPolicy(cfg.Retry)
+}
+
+// validateVMType checks the VM type the way configureVirtualizationFramework does.
+func validateVMType(cfg *Finch) error {
+	if cfg.VMType == nil || *cfg.VMType == "wsl2" {
+		return nil
+	}
+	return fmt.Errorf("unsupported vm type \"%s\" for windows", *cfg.VMType)
 }
@@ -9,6 +9,15 @@
 package disk
 
 import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/docker/go-units"
 	"github.com/spf13/afero"
 
 	"github.com/runfinch/finch/pkg/command"
@@ -21,6 +30,24 @@ import (
 type UserDataDiskManager interface {
 	EnsureUserDataDisk() error
 	DetachUserDataDisk() error
+	// AttachUserDataDisk attaches the disks DetachUserDataDisk detached back, without creating them.
+	AttachUserDataDisk() error
+	// UserDataDiskAttached reports whether the user data disk is still attached to an instance.
+	UserDataDiskAttached() (bool, error)
+	DetachOrder() []string
+	// IndependentDisks returns the paths of the attached disks that can be detached concurrently, see DetachOrder.
+	IndependentDisks() []string
+	// DetachDisk detaches the attached disk at diskPath, one of those of DetachOrder.
+	DetachDisk(diskPath string) error
+	UserDataDiskSpace() (required, available uint64, err error)
+	RemoveUserDataDisk() error
+	// RotateUserDataDisk moves the user data disk, which must have been detached first, to archiveDir
+	// and replaces it with an empty one. It returns the path the disk was archived to.
+	RotateUserDataDisk(archiveDir string) (string, error)
+	// SnapshotUserDataDisk copies the user data disk to a snapshot called name, and returns the path of the copy.
+	SnapshotUserDataDisk(name string) (string, error)
+	// UserDataDiskSnapshots returns the files of the snapshots of the user data disk, from the oldest.
+	UserDataDiskSnapshots() ([]fs.FileInfo, error)
 }
 
 // fs functions required for setting up the user data disk.
@@ -38,6 +65,13 @@ type userDataDiskManager struct {
 	rootDir string
 	config  *config.Finch
 	logger  flog.Logger
+	// freeSpace returns the free space of the file system containing the path, it's overridden in tests.
+	freeSpace func(path string) (uint64, error)
+	// allocatedSpace returns the host disk space the file at the path takes up, 0 if it doesn't exist.
+	// It's overridden in tests.
+	allocatedSpace func(path string) (uint64, error)
+	// cloneFile makes dst a copy-on-write clone of src, it's overridden in tests.
+	cloneFile func(src, dst string) error
 }
 
 // NewUserDataDiskManager is a constructor for UserDataDiskManager.
@@ -51,12 +85,132 @@ func NewUserDataDiskManager(
 	logger flog.Logger,
 ) UserDataDiskManager {
 	return &userDataDiskManager{
-		ncc:     ncc,
-		ecc:     ecc,
-		fs:      fs,
-		finch:   finch,
-		rootDir: rootDir,
-		config:  config,
-		logger:  logger,
+		ncc:            ncc,
+		ecc:            ecc,
+		fs:             fs,
+		finch:          finch,
+		rootDir:        rootDir,
+		config:         config,
+		logger:         logger,
+		freeSpace:      freeSpace,
+		allocatedSpace: allocatedSpace,
+		cloneFile:      cloneFile,
+	}
+}
+
+// UserDataDiskSpace reports the host disk space the user data disk still needs to grow to its full size,
+// and the host disk space available for it, in bytes.
+// An existing disk already takes up some of that space, only the rest is required.
+func (m *userDataDiskManager) UserDataDiskSpace() (uint64, uint64, error) {
+	size, err := units.RAMInBytes(diskSizeStr)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to parse the user data disk size: %w", err)
+	}
+	diskPath := m.finch.UserDataDiskPath(m.rootDir)
+	allocated, err := m.allocatedSpace(diskPath)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get the allocated space of %q: %w", diskPath, err)
+	}
+	// The disk lives under the root directory, which, unlike the disk directory, always exists.
+	available, err := m.freeSpace(m.rootDir)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get the free space of %q: %w", m.rootDir, err)
+	}
+	return uint64(size) - min(allocated, uint64(size)), available, nil
+}
+
+// DetachOrder returns the paths of the disks attached to the VM in the order they must be detached in:
+// the ones listed in the configuration first, then the others in the reverse of the order they were attached in,
+// so that a disk mounted under another one is detached before it by default.
+func (m *userDataDiskManager) DetachOrder() []string {
+	attached := append([]string{m.finch.UserDataDiskPath(m.rootDir)}, m.config.Disk.Additional...)
+	order := make([]string, 0, len(attached))
+	for _, diskPath := range m.config.Disk.DetachOrder {
+		if slices.Contains(attached, diskPath) && !slices.Contains(order, diskPath) {
+			order = append(order, diskPath)
+		}
+	}
+	for _, diskPath := range slices.Backward(attached) {
+		if !slices.Contains(order, diskPath) {
+			order = append(order, diskPath)
+		}
+	}
+	return order
+}
+
+// IndependentDisks returns the paths of the attached disks that aren't listed in the detach order of the configuration,
+// in DetachOrder: the listed ones depend on each other to be detached in that order, the others on none of them.
+func (m *userDataDiskManager) IndependentDisks() []string {
+	var independent []string
+	for _, diskPath := range m.DetachOrder() {
+		if !slices.Contains(m.config.Disk.DetachOrder, diskPath) {
+			independent = append(independent, diskPath)
+		}
+	}
+	return independent
+}
+
+// removePersistentDisk deletes the persistent disk file, if any.
+func (m *userDataDiskManager) removePersistentDisk() error {
+	err := m.fs.Remove(m.finch.UserDataDiskPath(m.rootDir))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to remove the user data disk: %w", err)
+	}
+	return nil
+}
+
+// RotateUserDataDisk moves the user data disk to archiveDir, under its name suffixed with the time it's archived at,
+// and replaces it with an empty disk. archiveDir must be on the same file system as the disk, which isn't copied.
+func (m *userDataDiskManager) RotateUserDataDisk(archiveDir string) (string, error) {
+	diskPath := m.finch.UserDataDiskPath(m.rootDir)
+	if err := m.fs.MkdirAll(archiveDir, 0o700); err != nil {
+		return "", fmt.Errorf("failed to create the disk archive directory: %w", err)
+	}
+	ext := filepath.Ext(diskPath)
+	name := strings.TrimSuffix(filepath.Base(diskPath), ext)
+	archivePath := filepath.Join(archiveDir, fmt.Sprintf("%s-%s%s", name, time.Now().UTC().Format("20060102T150405Z"), ext))
+	if err := m.fs.Rename(diskPath, archivePath); err != nil {
+		return "", fmt.Errorf("failed to archive the user data disk: %w", err)
+	}
+	if err := m.createEmptyUserDataDisk(); err != nil {
+		return archivePath, fmt.Errorf("failed to create an empty user data disk: %w", err)
+	}
+	return archivePath, nil
+}
+
+// SnapshotUserDataDisk clones the user data disk to the snapshot directory, under name with the extension of the disk.
+// The clone is made at once, rather than read through while the guest writes to it, so the snapshot is the disk as it
+// was at that point, like after a power loss, and shares the blocks of the disk until either of them changes.
+func (m *userDataDiskManager) SnapshotUserDataDisk(name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("invalid snapshot name %q, it must be a file name", name)
+	}
+	diskPath := m.finch.UserDataDiskPath(m.rootDir)
+	snapshotDir := m.finch.UserDataDiskSnapshotDir(m.rootDir)
+	snapshotPath := filepath.Join(snapshotDir, name+filepath.Ext(diskPath))
+	if _, err := m.fs.Stat(snapshotPath); err == nil {
+		return "", fmt.Errorf("the snapshot %q already exists", name)
+	}
+	if err := m.fs.MkdirAll(snapshotDir, 0o700); err != nil {
+		return "", fmt.Errorf("failed to create the disk snapshot directory: %w", err)
+	}
+	if err := m.cloneFile(diskPath, snapshotPath); err != nil {
+		return "", fmt.Errorf("failed to snapshot the user data disk: %w", err)
+	}
+	return snapshotPath, nil
+}
+
+// UserDataDiskSnapshots returns the files in the snapshot directory, from the oldest. There are none
+// if the directory doesn't exist, as it's only created by the first snapshot.
+func (m *userDataDiskManager) UserDataDiskSnapshots() ([]fs.FileInfo, error) {
+	entries, err := afero.ReadDir(m.fs, m.finch.UserDataDiskSnapshotDir(m.rootDir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the disk snapshot directory: %w", err)
 	}
+	snapshots := slices.DeleteFunc(entries, fs.FileInfo.IsDir)
+	slices.SortStableFunc(snapshots, func(a, b fs.FileInfo) int { return a.ModTime().Compare(b.ModTime()) })
+	return snapshots, nil
 }
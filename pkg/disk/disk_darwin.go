@@ -14,13 +14,44 @@ import (
 
 	"github.com/docker/go-units"
 	limaStore "github.com/lima-vm/lima/pkg/store"
+	"golang.org/x/sys/unix"
 )
 
-const (
-	// diskName must always be consistent with the value set for AdditionalDisks in lima_config_applier.go.
-	diskName    = "finch"
-	diskSizeStr = "50GB"
-)
+const diskSizeStr = "50GB"
+
+// diskName returns the name of the user data disk in Lima, which is the name of the instance using it,
+// as set for AdditionalDisks in lima_config_applier.go.
+func (m *userDataDiskManager) diskName() string {
+	return m.config.InstanceName()
+}
+
+func freeSpace(path string) (uint64, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
+
+func allocatedSpace(path string) (uint64, error) {
+	var stat unix.Stat_t
+	if err := unix.Stat(path, &stat); err != nil {
+		if errors.Is(err, unix.ENOENT) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	// The disk is a sparse file, its size is the one it can grow to rather than the space it takes up.
+	return uint64(stat.Blocks) * 512, nil
+}
+
+// cloneFile clones src to dst with clonefile(2), which APFS makes copy-on-write. It fails if dst exists.
+func cloneFile(src, dst string) error {
+	if err := unix.Clonefile(src, dst, unix.CLONE_NOFOLLOW); err != nil {
+		return &fs.PathError{Op: "clonefile", Path: src, Err: err}
+	}
+	return nil
+}
 
 type qemuDiskInfo struct {
 	VirtualSize int    `json:"virtual-size"`
@@ -58,7 +89,7 @@ func (m *userDataDiskManager) EnsureUserDataDisk() error {
 
 		// if the file is not a symlink, loc will be an empty string
 		// both os.Readlink() and UserDataDiskPath return absolute paths, so they will be equal if equivalent
-		limaPath := fmt.Sprintf("%s/_disks/%s/datadisk", m.finch.LimaHomePath(), diskName)
+		limaPath := fmt.Sprintf("%s/_disks/%s/datadisk", m.finch.LimaHomePath(), m.diskName())
 		loc, err := m.fs.ReadlinkIfPossible(limaPath)
 		if err != nil {
 			return err
@@ -93,13 +124,56 @@ func (m *userDataDiskManager) DetachUserDataDisk() error {
 	return nil
 }
 
+// AttachUserDataDisk is a no-op on Unix because Lima does the attaching.
+func (m *userDataDiskManager) AttachUserDataDisk() error {
+	return nil
+}
+
+// DetachDisk is a no-op on Unix because Lima does the detaching.
+func (m *userDataDiskManager) DetachDisk(string) error {
+	return nil
+}
+
+// UserDataDiskAttached reports whether Lima still has the user data disk locked for an instance,
+// which it does for as long as the instance using it is running.
+func (m *userDataDiskManager) UserDataDiskAttached() (bool, error) {
+	return m.limaDiskIsLocked(), nil
+}
+
+// RemoveUserDataDisk deletes the user data disk, along with the Lima disk linking to it.
+// All the data stored on it, e.g. images and containers, is lost.
+func (m *userDataDiskManager) RemoveUserDataDisk() error {
+	if m.limaDiskExists() {
+		out, err := m.ncc.CreateWithoutStdio("disk", "delete", m.diskName()).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("failed to delete the Lima disk: %w, command output: %s", err, out)
+		}
+	}
+	return m.removePersistentDisk()
+}
+
+// createEmptyUserDataDisk replaces the Lima disk, which links to the user data disk that was moved away,
+// with a new one, and moves it to the persistent path of the user data disk.
+func (m *userDataDiskManager) createEmptyUserDataDisk() error {
+	if m.limaDiskExists() {
+		out, err := m.ncc.CreateWithoutStdio("disk", "delete", m.diskName()).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("failed to delete the Lima disk: %w, command output: %s", err, out)
+		}
+	}
+	if err := m.createLimaDisk(); err != nil {
+		return err
+	}
+	return m.attachPersistentDiskToLimaDisk()
+}
+
 func (m *userDataDiskManager) persistentDiskExists() bool {
 	_, err := m.fs.Stat(m.finch.UserDataDiskPath(m.rootDir))
 	return err == nil
 }
 
 func (m *userDataDiskManager) limaDiskExists() bool {
-	cmd := m.ncc.CreateWithoutStdio("disk", "ls", diskName, "--json")
+	cmd := m.ncc.CreateWithoutStdio("disk", "ls", m.diskName(), "--json")
 	out, err := cmd.Output()
 	if err != nil {
 		return false
@@ -109,7 +183,7 @@ func (m *userDataDiskManager) limaDiskExists() bool {
 	if err != nil {
 		return false
 	}
-	return diskListOutput.Name == diskName
+	return diskListOutput.Name == m.diskName()
 }
 
 func (m *userDataDiskManager) getDiskInfo(diskPath string) (*qemuDiskInfo, error) {
@@ -157,7 +231,7 @@ func (m *userDataDiskManager) createLimaDisk() error {
 	if err != nil {
 		return fmt.Errorf("failed to get disk size: %w", err)
 	}
-	cmd := m.ncc.CreateWithoutStdio("disk", "create", diskName, "--size", size, "--format", "raw")
+	cmd := m.ncc.CreateWithoutStdio("disk", "create", m.diskName(), "--size", size, "--format", "raw")
 	if logs, err := cmd.CombinedOutput(); err != nil {
 		return fmt.Errorf("failed to create disk, debug logs:\n%s", logs)
 	}
@@ -165,7 +239,7 @@ func (m *userDataDiskManager) createLimaDisk() error {
 }
 
 func (m *userDataDiskManager) attachPersistentDiskToLimaDisk() error {
-	limaPath := fmt.Sprintf("%s/_disks/%s/datadisk", m.finch.LimaHomePath(), diskName)
+	limaPath := fmt.Sprintf("%s/_disks/%s/datadisk", m.finch.LimaHomePath(), m.diskName())
 	if !m.persistentDiskExists() {
 		disksDir := path.Dir(m.finch.UserDataDiskPath(m.rootDir))
 		_, err := m.fs.Stat(disksDir)
@@ -201,13 +275,13 @@ func (m *userDataDiskManager) attachPersistentDiskToLimaDisk() error {
 }
 
 func (m *userDataDiskManager) limaDiskIsLocked() bool {
-	lockPath := path.Join(m.finch.LimaHomePath(), "_disks", diskName, "in_use_by")
+	lockPath := path.Join(m.finch.LimaHomePath(), "_disks", m.diskName(), "in_use_by")
 	_, err := m.fs.Stat(lockPath)
 	return err == nil
 }
 
 func (m *userDataDiskManager) unlockLimaDisk() error {
-	cmd := m.ncc.CreateWithoutStdio("disk", "unlock", diskName)
+	cmd := m.ncc.CreateWithoutStdio("disk", "unlock", m.diskName())
 	if logs, err := cmd.CombinedOutput(); err != nil {
 		return fmt.Errorf("failed to unlock disk, debug logs:\n%s", logs)
 	}
@@ -6,20 +6,28 @@
 package disk
 
 import (
+	"errors"
 	"fmt"
 	"io/fs"
 	"path"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/xorcare/pointer"
 	"go.uber.org/mock/gomock"
+	"golang.org/x/sys/unix"
 
 	"github.com/runfinch/finch/pkg/config"
 	"github.com/runfinch/finch/pkg/mocks"
 	fpath "github.com/runfinch/finch/pkg/path"
 )
 
+const diskName = "finch"
+
 func TestDisk_NewUserDataDiskManager(t *testing.T) {
 	t.Parallel()
 
@@ -256,3 +264,323 @@ func TestUserDataDiskManager_InitializeUserDataDisk(t *testing.T) {
 		})
 	}
 }
+
+func TestUserDataDiskManager_UserDataDiskSpace(t *testing.T) {
+	t.Parallel()
+
+	finch := fpath.Finch("mock_finch")
+	homeDir := "mock_home"
+	diskPath := finch.UserDataDiskPath(homeDir)
+	const diskSize = 50 * 1024 * 1024 * 1024
+
+	testCases := []struct {
+		name           string
+		allocatedSpace func(path string) (uint64, error)
+		freeSpace      func(path string) (uint64, error)
+		wantRequired   uint64
+		wantAvailable  uint64
+		wantErr        error
+	}{
+		{
+			name: "should report the disk size and the free space of the root directory",
+			allocatedSpace: func(path string) (uint64, error) {
+				assert.Equal(t, diskPath, path)
+				return 0, nil
+			},
+			freeSpace: func(path string) (uint64, error) {
+				assert.Equal(t, homeDir, path)
+				return 1024, nil
+			},
+			wantRequired:  diskSize,
+			wantAvailable: 1024,
+			wantErr:       nil,
+		},
+		{
+			name: "should only require the space an existing disk hasn't taken up yet",
+			allocatedSpace: func(_ string) (uint64, error) {
+				return diskSize - 1024, nil
+			},
+			freeSpace: func(_ string) (uint64, error) {
+				return 2048, nil
+			},
+			wantRequired:  1024,
+			wantAvailable: 2048,
+			wantErr:       nil,
+		},
+		{
+			name: "should not require any space for an existing disk that has grown to its full size",
+			allocatedSpace: func(_ string) (uint64, error) {
+				return diskSize + 1024, nil
+			},
+			freeSpace: func(_ string) (uint64, error) {
+				return 0, nil
+			},
+			wantRequired:  0,
+			wantAvailable: 0,
+			wantErr:       nil,
+		},
+		{
+			name: "should return an error if the allocated space of the disk can't be read",
+			allocatedSpace: func(_ string) (uint64, error) {
+				return 0, errors.New("stat error")
+			},
+			freeSpace:     nil,
+			wantRequired:  0,
+			wantAvailable: 0,
+			wantErr:       fmt.Errorf("failed to get the allocated space of %q: %w", diskPath, errors.New("stat error")),
+		},
+		{
+			name: "should return an error if the free space can't be read",
+			allocatedSpace: func(_ string) (uint64, error) {
+				return 0, nil
+			},
+			freeSpace: func(_ string) (uint64, error) {
+				return 0, errors.New("statfs error")
+			},
+			wantRequired:  0,
+			wantAvailable: 0,
+			wantErr:       fmt.Errorf("failed to get the free space of %q: %w", homeDir, errors.New("statfs error")),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			dm := &userDataDiskManager{finch: finch, rootDir: homeDir, freeSpace: tc.freeSpace, allocatedSpace: tc.allocatedSpace}
+			required, available, err := dm.UserDataDiskSpace()
+			assert.Equal(t, tc.wantRequired, required)
+			assert.Equal(t, tc.wantAvailable, available)
+			assert.Equal(t, tc.wantErr, err)
+		})
+	}
+}
+
+func TestUserDataDiskManager_RemoveUserDataDisk(t *testing.T) {
+	t.Parallel()
+
+	finch := fpath.Finch("mock_finch")
+	homeDir := "mock_home"
+	mockListArgs := []string{"disk", "ls", diskName, "--json"}
+	mockDeleteArgs := []string{"disk", "delete", diskName}
+	listSuccessOutput := []byte(`{"name":"finch","size":5,"dir":"mock_dir"}`)
+
+	testCases := []struct {
+		name    string
+		wantErr error
+		mockSvc func(ncc *mocks.NerdctlCmdCreator, dfs *mocks.MockdiskFS, cmd *mocks.Command)
+	}{
+		{
+			name:    "should delete the Lima disk and the persistent disk",
+			wantErr: nil,
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, dfs *mocks.MockdiskFS, cmd *mocks.Command) {
+				ncc.EXPECT().CreateWithoutStdio(mockListArgs).Return(cmd)
+				cmd.EXPECT().Output().Return(listSuccessOutput, nil)
+				ncc.EXPECT().CreateWithoutStdio(mockDeleteArgs).Return(cmd)
+				cmd.EXPECT().CombinedOutput().Return(nil, nil)
+				dfs.EXPECT().Remove(finch.UserDataDiskPath(homeDir)).Return(nil)
+			},
+		},
+		{
+			name:    "should succeed if there is no disk",
+			wantErr: nil,
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, dfs *mocks.MockdiskFS, cmd *mocks.Command) {
+				ncc.EXPECT().CreateWithoutStdio(mockListArgs).Return(cmd)
+				cmd.EXPECT().Output().Return([]byte(""), nil)
+				dfs.EXPECT().Remove(finch.UserDataDiskPath(homeDir)).Return(fs.ErrNotExist)
+			},
+		},
+		{
+			name:    "should return an error if the Lima disk can't be deleted",
+			wantErr: fmt.Errorf("failed to delete the Lima disk: %w, command output: %s", errors.New("exit status 1"), "in use"),
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, _ *mocks.MockdiskFS, cmd *mocks.Command) {
+				ncc.EXPECT().CreateWithoutStdio(mockListArgs).Return(cmd)
+				cmd.EXPECT().Output().Return(listSuccessOutput, nil)
+				ncc.EXPECT().CreateWithoutStdio(mockDeleteArgs).Return(cmd)
+				cmd.EXPECT().CombinedOutput().Return([]byte("in use"), errors.New("exit status 1"))
+			},
+		},
+		{
+			name:    "should return an error if the persistent disk can't be removed",
+			wantErr: fmt.Errorf("failed to remove the user data disk: %w", fs.ErrPermission),
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, dfs *mocks.MockdiskFS, cmd *mocks.Command) {
+				ncc.EXPECT().CreateWithoutStdio(mockListArgs).Return(cmd)
+				cmd.EXPECT().Output().Return([]byte(""), nil)
+				dfs.EXPECT().Remove(finch.UserDataDiskPath(homeDir)).Return(fs.ErrPermission)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+			dfs := mocks.NewMockdiskFS(ctrl)
+			cmd := mocks.NewCommand(ctrl)
+			tc.mockSvc(ncc, dfs, cmd)
+
+			dm := NewUserDataDiskManager(ncc, nil, dfs, finch, homeDir, &config.Finch{}, nil)
+			err := dm.RemoveUserDataDisk()
+			assert.Equal(t, tc.wantErr, err)
+		})
+	}
+}
+
+func TestUserDataDiskManager_RotateUserDataDisk(t *testing.T) {
+	t.Parallel()
+
+	finch := fpath.Finch("mock_finch")
+	homeDir := "mock_home"
+	archiveDir := finch.UserDataDiskArchiveDir(homeDir)
+	diskPath := finch.UserDataDiskPath(homeDir)
+	limaPath := path.Join(finch.LimaHomePath(), "_disks", diskName, "datadisk")
+	size, err := sizeString()
+	assert.NoError(t, err)
+	mockListArgs := []string{"disk", "ls", diskName, "--json"}
+	mockDeleteArgs := []string{"disk", "delete", diskName}
+	mockCreateArgs := []string{"disk", "create", diskName, "--size", size, "--format", "raw"}
+	listSuccessOutput := []byte(`{"name":"finch","size":5,"dir":"mock_dir"}`)
+
+	testCases := []struct {
+		name         string
+		wantArchived bool
+		wantErr      error
+		mockSvc      func(ncc *mocks.NerdctlCmdCreator, dfs *mocks.MockdiskFS, cmd *mocks.Command)
+	}{
+		{
+			name:         "should archive the disk and replace it with a new Lima disk",
+			wantArchived: true,
+			wantErr:      nil,
+			mockSvc: func(ncc *mocks.NerdctlCmdCreator, dfs *mocks.MockdiskFS, cmd *mocks.Command) {
+				dfs.EXPECT().MkdirAll(archiveDir, fs.FileMode(0o700)).Return(nil)
+				dfs.EXPECT().Rename(diskPath, gomock.Any()).Return(nil)
+				ncc.EXPECT().CreateWithoutStdio(mockListArgs).Return(cmd)
+				cmd.EXPECT().Output().Return(listSuccessOutput, nil)
+				ncc.EXPECT().CreateWithoutStdio(mockDeleteArgs).Return(cmd)
+				cmd.EXPECT().CombinedOutput().Return(nil, nil)
+				ncc.EXPECT().CreateWithoutStdio(mockCreateArgs).Return(cmd)
+				cmd.EXPECT().CombinedOutput().Return(nil, nil)
+				dfs.EXPECT().Stat(diskPath).Return(nil, fs.ErrNotExist)
+				dfs.EXPECT().Stat(path.Dir(diskPath)).Return(nil, nil)
+				dfs.EXPECT().Rename(limaPath, diskPath).Return(nil)
+				dfs.EXPECT().Stat(limaPath).Return(nil, fs.ErrNotExist)
+				dfs.EXPECT().SymlinkIfPossible(diskPath, limaPath).Return(nil)
+			},
+		},
+		{
+			name:         "should return an error if the disk can't be moved to the archive",
+			wantArchived: false,
+			wantErr:      fmt.Errorf("failed to archive the user data disk: %w", fs.ErrPermission),
+			mockSvc: func(_ *mocks.NerdctlCmdCreator, dfs *mocks.MockdiskFS, _ *mocks.Command) {
+				dfs.EXPECT().MkdirAll(archiveDir, fs.FileMode(0o700)).Return(nil)
+				dfs.EXPECT().Rename(diskPath, gomock.Any()).Return(fs.ErrPermission)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			ncc := mocks.NewNerdctlCmdCreator(ctrl)
+			dfs := mocks.NewMockdiskFS(ctrl)
+			cmd := mocks.NewCommand(ctrl)
+			tc.mockSvc(ncc, dfs, cmd)
+
+			dm := NewUserDataDiskManager(ncc, nil, dfs, finch, homeDir, &config.Finch{}, nil)
+			archivePath, err := dm.RotateUserDataDisk(archiveDir)
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.wantArchived, strings.HasPrefix(archivePath, filepath.Join(archiveDir, filepath.Base(diskPath)+"-")))
+		})
+	}
+}
+
+func TestUserDataDiskManager_SnapshotUserDataDisk(t *testing.T) {
+	t.Parallel()
+
+	finch := fpath.Finch("mock_finch")
+	homeDir := "mock_home"
+	diskPath := finch.UserDataDiskPath(homeDir)
+	snapshotDir := finch.UserDataDiskSnapshotDir(homeDir)
+	snapshotPath := filepath.Join(snapshotDir, "before-upgrade"+filepath.Ext(diskPath))
+
+	cloneErr := &fs.PathError{Op: "clonefile", Path: diskPath, Err: unix.EXDEV}
+
+	testCases := []struct {
+		name         string
+		snapshotName string
+		cloneErr     error
+		wantErr      error
+		mockSvc      func(dfs *mocks.MockdiskFS, mem afero.Fs)
+	}{
+		{
+			name:         "should clone the disk to the snapshot directory",
+			snapshotName: "before-upgrade",
+			wantErr:      nil,
+			mockSvc: func(dfs *mocks.MockdiskFS, mem afero.Fs) {
+				dfs.EXPECT().Stat(snapshotPath).Return(nil, fs.ErrNotExist)
+				dfs.EXPECT().MkdirAll(snapshotDir, fs.FileMode(0o700)).DoAndReturn(mem.MkdirAll)
+			},
+		},
+		{
+			name:         "should fail if the disk can't be cloned",
+			snapshotName: "before-upgrade",
+			cloneErr:     cloneErr,
+			wantErr:      fmt.Errorf("failed to snapshot the user data disk: %w", cloneErr),
+			mockSvc: func(dfs *mocks.MockdiskFS, mem afero.Fs) {
+				dfs.EXPECT().Stat(snapshotPath).Return(nil, fs.ErrNotExist)
+				dfs.EXPECT().MkdirAll(snapshotDir, fs.FileMode(0o700)).DoAndReturn(mem.MkdirAll)
+			},
+		},
+		{
+			name:         "should refuse to overwrite a snapshot",
+			snapshotName: "before-upgrade",
+			wantErr:      fmt.Errorf("the snapshot %q already exists", "before-upgrade"),
+			mockSvc: func(dfs *mocks.MockdiskFS, _ afero.Fs) {
+				dfs.EXPECT().Stat(snapshotPath).Return(nil, nil)
+			},
+		},
+		{
+			name:         "should reject a name that isn't a file name",
+			snapshotName: "../disk",
+			wantErr:      fmt.Errorf("invalid snapshot name %q, it must be a file name", "../disk"),
+			mockSvc:      func(_ *mocks.MockdiskFS, _ afero.Fs) {},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			dfs := mocks.NewMockdiskFS(ctrl)
+			mem := afero.NewMemMapFs()
+			require.NoError(t, afero.WriteFile(mem, diskPath, []byte("disk"), 0o600))
+			tc.mockSvc(dfs, mem)
+
+			dm := &userDataDiskManager{fs: dfs, finch: finch, rootDir: homeDir, config: &config.Finch{}}
+			dm.cloneFile = func(src, dst string) error {
+				if tc.cloneErr != nil {
+					return tc.cloneErr
+				}
+				b, err := afero.ReadFile(mem, src)
+				if err != nil {
+					return err
+				}
+				return afero.WriteFile(mem, dst, b, 0o600)
+			}
+			gotPath, err := dm.SnapshotUserDataDisk(tc.snapshotName)
+			assert.Equal(t, tc.wantErr, err)
+			if tc.wantErr != nil {
+				return
+			}
+			assert.Equal(t, snapshotPath, gotPath)
+			content, err := afero.ReadFile(mem, snapshotPath)
+			require.NoError(t, err)
+			assert.Equal(t, []byte("disk"), content)
+		})
+	}
+}
@@ -16,9 +16,44 @@ import (
 	"os"
 	"path/filepath"
 
+	"golang.org/x/sys/windows"
+
 	"github.com/runfinch/finch/pkg/winutil"
 )
 
+// diskSizeStr is the maximum size of the disk.vhdx shipped in min_win_disk.zip.
+const diskSizeStr = "50GB"
+
+func freeSpace(path string) (uint64, error) {
+	pathPtr, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	var available uint64
+	if err := windows.GetDiskFreeSpaceEx(pathPtr, &available, nil, nil); err != nil {
+		return 0, err
+	}
+	return available, nil
+}
+
+// cloneFile isn't supported on Windows, whose file systems don't clone the disk in one go like APFS does,
+// and copying it while the VM writes to it doesn't make a consistent snapshot.
+func cloneFile(string, string) error {
+	return errors.ErrUnsupported
+}
+
+func allocatedSpace(path string) (uint64, error) {
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	// The disk is a dynamically expanding VHDX, its file only takes up the space written to it.
+	return uint64(info.Size()), nil
+}
+
 // EnsureUserDataDisk checks the current disk configuration and fixes it if needed.
 func (m *userDataDiskManager) EnsureUserDataDisk() error {
 	diskPath := m.finch.UserDataDiskPath(m.rootDir)
@@ -43,29 +78,68 @@ func (m *userDataDiskManager) EnsureUserDataDisk() error {
 		return fmt.Errorf("error stating disksDir %q: %w", diskPath, err)
 	}
 
-	if err := m.attachDisk(diskPath); err != nil {
-		return fmt.Errorf("could not attach persistent disk: %w", err)
+	return m.AttachUserDataDisk()
+}
+
+// DetachUserDataDisk unmounts the user data disk and the additional disks in wsl, in DetachOrder.
+// Failing to detach a disk doesn't prevent the next ones from being detached.
+func (m *userDataDiskManager) DetachUserDataDisk() error {
+	var errs []error
+	for _, diskPath := range m.DetachOrder() {
+		if err := m.detachDisk(diskPath); err != nil {
+			errs = append(errs, err)
+		}
 	}
+	return errors.Join(errs...)
+}
 
+// AttachUserDataDisk mounts the user data disk and the additional disks back in wsl, the disks must exist.
+func (m *userDataDiskManager) AttachUserDataDisk() error {
+	if err := m.attachDisk(m.finch.UserDataDiskPath(m.rootDir)); err != nil {
+		return fmt.Errorf("could not attach persistent disk: %w", err)
+	}
+	for _, additionalPath := range m.config.Disk.Additional {
+		if err := m.attachDisk(additionalPath); err != nil {
+			return fmt.Errorf("could not attach additional disk %q: %w", additionalPath, err)
+		}
+	}
 	return nil
 }
 
-// DetachUserDataDisk unmounts the disk in wsl.
-func (m *userDataDiskManager) DetachUserDataDisk() error {
-	cmd := m.ecc.Create(
-		"wsl.exe",
-		"--unmount",
-		`\\?\`+m.finch.UserDataDiskPath(m.rootDir),
-	)
-
-	m.logger.Debugf("running detach cmd: %v", cmd)
+// DetachDisk unmounts the disk at diskPath in wsl.
+func (m *userDataDiskManager) DetachDisk(diskPath string) error {
+	return m.detachDisk(diskPath)
+}
 
-	out, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("failed to detach disk: %w, command output: %s", err, out)
+// UserDataDiskAttached reports whether any of the user data disk and the additional disks is still attached to wsl,
+// which holds the disks it has attached open exclusively.
+func (m *userDataDiskManager) UserDataDiskAttached() (bool, error) {
+	for _, diskPath := range m.DetachOrder() {
+		f, err := os.OpenFile(filepath.Clean(diskPath), os.O_RDWR, 0)
+		if err == nil {
+			_ = f.Close()
+			continue
+		}
+		if errors.Is(err, windows.ERROR_SHARING_VIOLATION) {
+			return true, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return false, fmt.Errorf("failed to check whether the disk %s is attached: %w", diskPath, err)
+		}
 	}
+	return false, nil
+}
 
-	return nil
+// RemoveUserDataDisk deletes the user data disk, which must have been detached first.
+// All the data stored on it, e.g. images and containers, is lost.
+func (m *userDataDiskManager) RemoveUserDataDisk() error {
+	return m.removePersistentDisk()
+}
+
+// createEmptyUserDataDisk creates an empty user data disk in place of the one that was moved away,
+// which is attached on the next start.
+func (m *userDataDiskManager) createEmptyUserDataDisk() error {
+	return m.createDisk(m.finch.UserDataDiskPath(m.rootDir))
 }
 
 // min_win_disk.zip is a zip directory with a single file (disk.vhdx).
@@ -115,6 +189,23 @@ func (m *userDataDiskManager) createDisk(diskPath string) error {
 	return nil
 }
 
+func (m *userDataDiskManager) detachDisk(diskPath string) error {
+	cmd := m.ecc.Create(
+		"wsl.exe",
+		"--unmount",
+		`\\?\`+diskPath,
+	)
+
+	m.logger.Debugf("running detach cmd: %v", cmd)
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to detach disk: %w, command output: %s", err, out)
+	}
+
+	return nil
+}
+
 func (m *userDataDiskManager) attachDisk(diskPath string) error {
 	m.logger.Infof("attaching disk at path: %s", diskPath)
 
@@ -0,0 +1,185 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build windows
+
+package disk
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/mock/gomock"
+
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/mocks"
+	fpath "github.com/runfinch/finch/pkg/path"
+)
+
+func TestUserDataDiskManager_DetachUserDataDisk(t *testing.T) {
+	t.Parallel()
+
+	finch := fpath.Finch("mock_finch")
+	rootDir := "mock_root"
+	userDataDiskPath := finch.UserDataDiskPath(rootDir)
+	cachePath := `C:\disks\cache.vhdx`
+	overlayPath := `C:\disks\cache-overlay.vhdx`
+
+	type detach struct {
+		diskPath string
+		err      error
+	}
+
+	testCases := []struct {
+		name        string
+		detachOrder []string
+		wantDetach  []detach
+		wantErr     error
+	}{
+		{
+			name:       "should detach the disks in the reverse of the order they were attached in",
+			wantDetach: []detach{{diskPath: overlayPath}, {diskPath: cachePath}, {diskPath: userDataDiskPath}},
+		},
+		{
+			name:        "should detach the disks in the configured order first",
+			detachOrder: []string{cachePath, `C:\disks\unknown.vhdx`},
+			wantDetach:  []detach{{diskPath: cachePath}, {diskPath: overlayPath}, {diskPath: userDataDiskPath}},
+		},
+		{
+			name: "should detach the remaining disks if one fails to be detached",
+			wantDetach: []detach{
+				{diskPath: overlayPath, err: errors.New("exit status 1")},
+				{diskPath: cachePath},
+				{diskPath: userDataDiskPath},
+			},
+			wantErr: errors.Join(errors.New("failed to detach disk: exit status 1, command output: ")),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			ecc := mocks.NewCommandCreator(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			fc := &config.Finch{}
+			fc.Disk.Additional = []string{cachePath, overlayPath}
+			fc.Disk.DetachOrder = tc.detachOrder
+
+			var calls []any
+			for _, d := range tc.wantDetach {
+				cmd := mocks.NewCommand(ctrl)
+				calls = append(calls, ecc.EXPECT().Create("wsl.exe", "--unmount", `\\?\`+d.diskPath).Return(cmd))
+				cmd.EXPECT().CombinedOutput().Return(nil, d.err)
+				logger.EXPECT().Debugf("running detach cmd: %v", cmd)
+			}
+			gomock.InOrder(calls...)
+
+			dm := NewUserDataDiskManager(nil, ecc, nil, finch, rootDir, fc, logger)
+			err := dm.DetachUserDataDisk()
+			if tc.wantErr == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.wantErr.Error())
+			}
+		})
+	}
+}
+
+func TestUserDataDiskManager_AttachUserDataDisk(t *testing.T) {
+	t.Parallel()
+
+	finch := fpath.Finch("mock_finch")
+	rootDir := "mock_root"
+	userDataDiskPath := finch.UserDataDiskPath(rootDir)
+	cachePath := `C:\disks\cache.vhdx`
+
+	testCases := []struct {
+		name       string
+		wantAttach []string
+		attachErr  error
+		wantErr    string
+	}{
+		{
+			name:       "should attach the user data disk and the additional disks",
+			wantAttach: []string{userDataDiskPath, cachePath},
+		},
+		{
+			name:       "should fail if the user data disk can't be attached",
+			wantAttach: []string{userDataDiskPath},
+			attachErr:  errors.New("exit status 1"),
+			wantErr:    "could not attach persistent disk: failed to attach disk: exit status 1, command output: ",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			ecc := mocks.NewCommandCreator(ctrl)
+			logger := mocks.NewLogger(ctrl)
+			fc := &config.Finch{}
+			fc.Disk.Additional = []string{cachePath}
+
+			var calls []any
+			for _, diskPath := range tc.wantAttach {
+				cmd := mocks.NewCommand(ctrl)
+				logger.EXPECT().Infof("attaching disk at path: %s", diskPath)
+				calls = append(calls, ecc.EXPECT().Create("wsl.exe", "--mount", "--bare", "--vhd", diskPath).Return(cmd))
+				cmd.EXPECT().CombinedOutput().Return(nil, tc.attachErr)
+				logger.EXPECT().Debugf("running attach cmd: %v", cmd)
+			}
+			gomock.InOrder(calls...)
+
+			dm := NewUserDataDiskManager(nil, ecc, nil, finch, rootDir, fc, logger)
+			err := dm.AttachUserDataDisk()
+			if tc.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestUserDataDiskManager_IndependentDisks(t *testing.T) {
+	t.Parallel()
+
+	finch := fpath.Finch("mock_finch")
+	rootDir := "mock_root"
+	userDataDiskPath := finch.UserDataDiskPath(rootDir)
+	cachePath := `C:\disks\cache.vhdx`
+	overlayPath := `C:\disks\cache-overlay.vhdx`
+
+	testCases := []struct {
+		name        string
+		detachOrder []string
+		want        []string
+	}{
+		{
+			name: "should consider all the disks independent without a configured order",
+			want: []string{overlayPath, cachePath, userDataDiskPath},
+		},
+		{
+			name:        "should not consider the disks in the configured order independent",
+			detachOrder: []string{overlayPath, cachePath},
+			want:        []string{userDataDiskPath},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			fc := &config.Finch{}
+			fc.Disk.Additional = []string{cachePath, overlayPath}
+			fc.Disk.DetachOrder = tc.detachOrder
+
+			dm := NewUserDataDiskManager(nil, nil, nil, finch, rootDir, fc, nil)
+			assert.Equal(t, tc.want, dm.IndependentDisks())
+		})
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package disk
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/spf13/afero"
+
+	"github.com/runfinch/finch/pkg/command"
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/flog"
+	fpath "github.com/runfinch/finch/pkg/path"
+)
+
+// DefaultBackend is the disk backend used when disk.backend isn't set, the user data disk managed by Finch itself.
+const DefaultBackend = "default"
+
+// ManagerDeps are what the UserDataDiskManager of a disk backend is built from.
+type ManagerDeps struct {
+	NCC     command.NerdctlCmdCreator
+	ECC     command.Creator
+	FS      afero.Fs
+	Finch   fpath.Finch
+	RootDir string
+	Config  *config.Finch
+	Logger  flog.Logger
+}
+
+// ManagerFactory builds the UserDataDiskManager of a disk backend.
+type ManagerFactory func(deps ManagerDeps) (UserDataDiskManager, error)
+
+var (
+	backendsMu sync.Mutex
+	backends   = map[string]ManagerFactory{DefaultBackend: newDefaultManager}
+)
+
+// RegisterBackend registers the factory of the disk backend called name, for it to be selected with disk.backend.
+// It's meant to be called from the init function of the package implementing the backend,
+// and it panics if a backend is already registered under name.
+func RegisterBackend(name string, factory ManagerFactory) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	if factory == nil {
+		panic(fmt.Sprintf("disk: the factory of the backend %q is nil", name))
+	}
+	if _, ok := backends[name]; ok {
+		panic(fmt.Sprintf("disk: the backend %q is already registered", name))
+	}
+	backends[name] = factory
+}
+
+// unregisterBackend removes the disk backend called name, for the tests to clean up the backends they register.
+func unregisterBackend(name string) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	delete(backends, name)
+}
+
+// Backends returns the names of the registered disk backends, sorted.
+func Backends() []string {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// NewManager returns the UserDataDiskManager of the disk backend selected with disk.backend.
+// If the backend isn't registered or can't be built, the returned manager fails every operation with the reason,
+// so that the commands that don't need the user data disk keep working.
+func NewManager(deps ManagerDeps) UserDataDiskManager {
+	name := deps.Config.Disk.Backend
+	if name == "" {
+		name = DefaultBackend
+	}
+	backendsMu.Lock()
+	factory, ok := backends[name]
+	backendsMu.Unlock()
+	if !ok {
+		return unavailableManager{fmt.Errorf("unknown disk backend %q, the registered ones are: %s",
+			name, strings.Join(Backends(), ", "))}
+	}
+	m, err := factory(deps)
+	if err != nil {
+		return unavailableManager{fmt.Errorf("failed to set up the disk backend %q: %w", name, err)}
+	}
+	if m == nil {
+		return unavailableManager{fmt.Errorf("failed to set up the disk backend %q: its factory returned no manager", name)}
+	}
+	return m
+}
+
+func newDefaultManager(deps ManagerDeps) (UserDataDiskManager, error) {
+	dfs, ok := deps.FS.(diskFS)
+	if !ok {
+		return nil, errors.New("the file system doesn't support symlinks")
+	}
+	return NewUserDataDiskManager(deps.NCC, deps.ECC, dfs, deps.Finch, deps.RootDir, deps.Config, deps.Logger), nil
+}
+
+// unavailableManager is the UserDataDiskManager of a disk backend that couldn't be set up, it fails with err.
+type unavailableManager struct {
+	err error
+}
+
+var _ UserDataDiskManager = unavailableManager{}
+
+func (m unavailableManager) EnsureUserDataDisk() error {
+	return m.err
+}
+
+func (m unavailableManager) DetachUserDataDisk() error {
+	return m.err
+}
+
+func (m unavailableManager) AttachUserDataDisk() error {
+	return m.err
+}
+
+func (m unavailableManager) UserDataDiskAttached() (bool, error) {
+	return false, m.err
+}
+
+func (m unavailableManager) DetachOrder() []string {
+	return nil
+}
+
+func (m unavailableManager) IndependentDisks() []string {
+	return nil
+}
+
+func (m unavailableManager) DetachDisk(string) error {
+	return m.err
+}
+
+func (m unavailableManager) UserDataDiskSpace() (uint64, uint64, error) {
+	return 0, 0, m.err
+}
+
+func (m unavailableManager) RemoveUserDataDisk() error {
+	return m.err
+}
+
+func (m unavailableManager) RotateUserDataDisk(string) (string, error) {
+	return "", m.err
+}
+
+func (m unavailableManager) SnapshotUserDataDisk(string) (string, error) {
+	return "", m.err
+}
+
+func (m unavailableManager) UserDataDiskSnapshots() ([]fs.FileInfo, error) {
+	return nil, m.err
+}
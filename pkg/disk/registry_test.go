@@ -0,0 +1,116 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin || windows
+
+package disk
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/runfinch/finch/pkg/config"
+	"github.com/runfinch/finch/pkg/mocks"
+)
+
+func TestNewManager(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	zvol := mocks.NewUserDataDiskManager(ctrl)
+	RegisterBackend("test-zvol", func(ManagerDeps) (UserDataDiskManager, error) {
+		return zvol, nil
+	})
+	RegisterBackend("test-broken", func(ManagerDeps) (UserDataDiskManager, error) {
+		return nil, errors.New("zpool not found")
+	})
+	RegisterBackend("test-empty", func(ManagerDeps) (UserDataDiskManager, error) {
+		return nil, nil
+	})
+	t.Cleanup(func() {
+		unregisterBackend("test-zvol")
+		unregisterBackend("test-broken")
+		unregisterBackend("test-empty")
+	})
+
+	testCases := []struct {
+		name    string
+		backend string
+		fs      afero.Fs
+		want    UserDataDiskManager
+		wantErr string
+	}{
+		{
+			name: "should use the default backend if none is set",
+			fs:   afero.NewOsFs(),
+		},
+		{
+			name:    "should use the registered backend",
+			backend: "test-zvol",
+			want:    zvol,
+		},
+		{
+			name:    "should fail on an unknown backend",
+			backend: "test-lvm",
+			wantErr: `unknown disk backend "test-lvm", the registered ones are: `,
+		},
+		{
+			name:    "should fail on a backend that can't be built",
+			backend: "test-broken",
+			wantErr: `failed to set up the disk backend "test-broken": zpool not found`,
+		},
+		{
+			name:    "should fail on a backend whose factory returns no manager",
+			backend: "test-empty",
+			wantErr: `failed to set up the disk backend "test-empty": its factory returned no manager`,
+		},
+		{
+			name:    "should fail if the file system of the default backend doesn't support symlinks",
+			fs:      afero.NewMemMapFs(),
+			wantErr: `failed to set up the disk backend "default": the file system doesn't support symlinks`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			fc := &config.Finch{}
+			fc.Disk.Backend = tc.backend
+			m := NewManager(ManagerDeps{FS: tc.fs, Config: fc})
+			switch {
+			case tc.wantErr != "":
+				err := m.EnsureUserDataDisk()
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.wantErr)
+			case tc.want != nil:
+				assert.Equal(t, tc.want, m)
+			default:
+				assert.IsType(t, &userDataDiskManager{}, m)
+			}
+		})
+	}
+}
+
+func TestRegisterBackend(t *testing.T) {
+	t.Parallel()
+
+	factory := func(ManagerDeps) (UserDataDiskManager, error) {
+		return nil, nil
+	}
+	RegisterBackend("test-duplicate", factory)
+	t.Cleanup(func() { unregisterBackend("test-duplicate") })
+	assert.Contains(t, Backends(), "test-duplicate")
+	assert.Contains(t, Backends(), DefaultBackend)
+	assert.PanicsWithValue(t, `disk: the backend "test-duplicate" is already registered`, func() {
+		RegisterBackend("test-duplicate", factory)
+	})
+	assert.PanicsWithValue(t, `disk: the factory of the backend "test-nil" is nil`, func() {
+		RegisterBackend("test-nil", nil)
+	})
+}
@@ -20,6 +20,9 @@ type Logger interface {
 	Fatal(args ...interface{})
 	SetLevel(level Level)
 	SetFormatter(formatter Formatter)
+	// StartProgress shows the progress of a long operation labelled with label,
+	// until the returned function is called once the operation is done.
+	StartProgress(label string) (done func())
 }
 
 // Log defines the properties of every log message.
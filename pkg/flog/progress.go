@@ -0,0 +1,83 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package flog
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const spinnerInterval = 100 * time.Millisecond
+
+// spinnerFrames are plain ASCII so that they render in every terminal, including the legacy Windows console.
+var spinnerFrames = []string{"|", "/", "-", "\\"}
+
+// spinner renders an indeterminate progress indicator on a single terminal line until it's stopped.
+type spinner struct {
+	out   io.Writer
+	label string
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+func newSpinner(out io.Writer, label string) *spinner {
+	return &spinner{out: out, label: label, done: make(chan struct{})}
+}
+
+func (s *spinner) start() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(spinnerInterval)
+		defer ticker.Stop()
+		for i := 0; ; i++ {
+			_, _ = fmt.Fprintf(s.out, "\r%s %s", spinnerFrames[i%len(spinnerFrames)], s.label)
+			select {
+			case <-s.done:
+				// Erase the spinner line, so that the next log starts from a clean line.
+				_, _ = fmt.Fprint(s.out, "\r\033[K")
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (s *spinner) stop() {
+	close(s.done)
+	s.wg.Wait()
+}
+
+// StartProgress shows a spinner labelled with label until the returned function is called, then logs label at level Info.
+// If the logs don't go to a terminal, or they are formatted as JSON, label is just logged at level Info right away.
+// Nothing else should be logged until the returned function is called, as it would be mixed up with the spinner.
+func (l *Logrus) StartProgress(label string) func() {
+	logger := logrus.StandardLogger()
+	out, ok := logger.Out.(*os.File)
+	_, isJSON := logger.Formatter.(*logrus.JSONFormatter)
+	if !ok || !isTerminal(out) || isJSON || !logger.IsLevelEnabled(logrus.InfoLevel) {
+		logrus.Info(label)
+		return func() {}
+	}
+
+	s := newSpinner(out, label)
+	s.start()
+	return func() {
+		s.stop()
+		logrus.Info(label)
+	}
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,172 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package flog
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// syslogFacilityUser is the facility of the logs forwarded to syslog, the one of user-level messages.
+	syslogFacilityUser = 1
+	// syslogDialTimeout bounds how long a command can be held up by a syslog server that can't be reached.
+	syslogDialTimeout = 2 * time.Second
+	// syslogWriteTimeout bounds how long a log can be held up by a syslog server that stopped reading.
+	syslogWriteTimeout = 2 * time.Second
+	// syslogTimestampFormat is RFC 3339 with the microseconds, the highest precision RFC 5424 allows.
+	syslogTimestampFormat = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+// syslogDefaultPorts are the ports of the syslog servers by transport, when the address doesn't have one.
+var syslogDefaultPorts = map[string]string{"udp": "514", "tcp": "514", "tls": "6514"}
+
+// SyslogHook forwards the logs to a remote syslog server in RFC 5424 format, over UDP, TCP or TLS.
+// It's best-effort: if the server can't be reached, the logs are no longer forwarded, and the commands go on.
+type SyslogHook struct {
+	transport    string
+	address      string
+	appName      string
+	hostname     string
+	writeTimeout time.Duration
+	dial         func(transport, address string) (net.Conn, error)
+
+	mu     sync.Mutex
+	conn   net.Conn
+	failed bool
+}
+
+var _ logrus.Hook = (*SyslogHook)(nil)
+
+// NewSyslogHook returns a hook forwarding the logs of appName to the syslog server at address,
+// e.g. "udp://logs.example.com:514". The scheme is the transport, either udp, tcp or tls.
+// The server is only connected to once there's something to forward.
+func NewSyslogHook(address, appName string) (*SyslogHook, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the syslog address %q: %w", address, err)
+	}
+	defaultPort, ok := syslogDefaultPorts[u.Scheme]
+	if !ok || u.Hostname() == "" {
+		return nil, fmt.Errorf("unsupported syslog address %q, it must be in the form udp://host[:port], "+
+			"tcp://host[:port] or tls://host[:port]", address)
+	}
+	port := u.Port()
+	if port == "" {
+		port = defaultPort
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "-"
+	}
+	return &SyslogHook{
+		transport:    u.Scheme,
+		address:      net.JoinHostPort(u.Hostname(), port),
+		appName:      appName,
+		hostname:     hostname,
+		writeTimeout: syslogWriteTimeout,
+		dial:         dialSyslog,
+	}, nil
+}
+
+// ForwardToSyslog makes the Logrus loggers forward their logs to the syslog server at address, in addition
+// to the console. See NewSyslogHook for the format of address.
+func ForwardToSyslog(address, appName string) error {
+	hook, err := NewSyslogHook(address, appName)
+	if err != nil {
+		return err
+	}
+	logrus.AddHook(hook)
+	return nil
+}
+
+// Levels returns the levels the hook forwards, all of them.
+func (h *SyslogHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire forwards entry to the syslog server. It never returns an error, which logrus would print for every entry:
+// the first failure is reported once to stderr instead, and the following entries are dropped. The logs go through
+// h.mu, so a write that doesn't complete within the write timeout, e.g. because the server stopped reading and
+// the buffers filled up, is a failure too, rather than holding up every log of the command.
+func (h *SyslogHook) Fire(entry *logrus.Entry) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.failed {
+		return nil
+	}
+	if h.conn == nil {
+		conn, err := h.dial(h.transport, h.address)
+		if err != nil {
+			h.fail(err)
+			return nil
+		}
+		h.conn = conn
+	}
+	if err := h.conn.SetWriteDeadline(time.Now().Add(h.writeTimeout)); err != nil {
+		h.fail(err)
+		return nil
+	}
+	if _, err := h.conn.Write(h.frame(h.format(entry))); err != nil {
+		h.fail(err)
+	}
+	return nil
+}
+
+// fail stops forwarding the logs after err.
+func (h *SyslogHook) fail(err error) {
+	h.failed = true
+	if h.conn != nil {
+		_ = h.conn.Close()
+		h.conn = nil
+	}
+	fmt.Fprintf(os.Stderr, "Could not forward the logs to syslog at %s://%s, they won't be forwarded anymore: %v\n",
+		h.transport, h.address, err)
+}
+
+// format returns entry as an RFC 5424 message, without structured data.
+func (h *SyslogHook) format(entry *logrus.Entry) []byte {
+	priority := syslogFacilityUser*8 + syslogSeverity(entry.Level)
+	return []byte(fmt.Sprintf("<%d>1 %s %s %s %d - - %s", priority, entry.Time.Format(syslogTimestampFormat),
+		h.hostname, h.appName, os.Getpid(), entry.Message))
+}
+
+// frame delimits msg for the transport: a UDP datagram holds a single message, while over a stream
+// each message is prefixed with its length, as RFC 5425 and RFC 6587 define.
+func (h *SyslogHook) frame(msg []byte) []byte {
+	if h.transport == "udp" {
+		return msg
+	}
+	return append([]byte(fmt.Sprintf("%d ", len(msg))), msg...)
+}
+
+// syslogSeverity returns the syslog severity matching the logrus level.
+func syslogSeverity(level logrus.Level) int {
+	switch level {
+	case logrus.PanicLevel, logrus.FatalLevel:
+		return 2 // critical
+	case logrus.ErrorLevel:
+		return 3 // error
+	case logrus.WarnLevel:
+		return 4 // warning
+	case logrus.InfoLevel:
+		return 6 // informational
+	default:
+		return 7 // debug
+	}
+}
+
+func dialSyslog(transport, address string) (net.Conn, error) {
+	if transport == "tls" {
+		return tls.DialWithDialer(&net.Dialer{Timeout: syslogDialTimeout}, "tcp", address, nil)
+	}
+	return net.DialTimeout(transport, address, syslogDialTimeout)
+}
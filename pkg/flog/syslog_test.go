@@ -0,0 +1,180 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package flog
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSyslogHook(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name          string
+		address       string
+		wantTransport string
+		wantAddress   string
+		wantErr       error
+	}{
+		{
+			name:          "should keep the port of the address",
+			address:       "tcp://logs.example.com:601",
+			wantTransport: "tcp",
+			wantAddress:   "logs.example.com:601",
+		},
+		{
+			name:          "should default to the port of the transport",
+			address:       "tls://logs.example.com",
+			wantTransport: "tls",
+			wantAddress:   "logs.example.com:6514",
+		},
+		{
+			name:    "should reject an unsupported transport",
+			address: "http://logs.example.com",
+			wantErr: errors.New(`unsupported syslog address "http://logs.example.com", it must be in the form ` +
+				"udp://host[:port], tcp://host[:port] or tls://host[:port]"),
+		},
+		{
+			name:    "should reject an address without a scheme",
+			address: "logs.example.com:514",
+			wantErr: errors.New(`unsupported syslog address "logs.example.com:514", it must be in the form ` +
+				"udp://host[:port], tcp://host[:port] or tls://host[:port]"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			hook, err := NewSyslogHook(tc.address, "finch")
+			if tc.wantErr != nil {
+				assert.EqualError(t, err, tc.wantErr.Error())
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantTransport, hook.transport)
+			assert.Equal(t, tc.wantAddress, hook.address)
+		})
+	}
+}
+
+func TestSyslogHook_Fire(t *testing.T) {
+	t.Parallel()
+
+	entry := &logrus.Entry{
+		Level:   logrus.WarnLevel,
+		Time:    time.Date(2024, 5, 1, 10, 30, 0, 123456000, time.UTC),
+		Message: "Finch virtual machine failed to stop",
+	}
+	wantMsg := fmt.Sprintf("<12>1 2024-05-01T10:30:00.123456Z host finch %d - - Finch virtual machine failed to stop", os.Getpid())
+
+	testCases := []struct {
+		name      string
+		transport string
+		want      string
+	}{
+		{
+			name:      "should send a message per datagram over UDP",
+			transport: "udp",
+			want:      wantMsg,
+		},
+		{
+			name:      "should prefix the messages with their length over TCP",
+			transport: "tcp",
+			want:      fmt.Sprintf("%d %s", len(wantMsg), wantMsg),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			client, server := net.Pipe()
+			received := make(chan []byte, 1)
+			go func() {
+				b, _ := io.ReadAll(server)
+				received <- b
+			}()
+			hook := &SyslogHook{
+				transport:    tc.transport,
+				address:      "logs.example.com:514",
+				appName:      "finch",
+				hostname:     "host",
+				writeTimeout: time.Second,
+				dial: func(string, string) (net.Conn, error) {
+					return client, nil
+				},
+			}
+			require.NoError(t, hook.Fire(entry))
+			require.NoError(t, client.Close())
+			assert.Equal(t, tc.want, string(<-received))
+		})
+	}
+}
+
+func TestSyslogHook_FireStopsForwardingOnceTheServerIsUnreachable(t *testing.T) {
+	t.Parallel()
+
+	dials := 0
+	hook := &SyslogHook{
+		transport: "tcp",
+		address:   "logs.example.com:514",
+		appName:   "finch",
+		hostname:  "host",
+		dial: func(string, string) (net.Conn, error) {
+			dials++
+			return nil, errors.New("connection refused")
+		},
+	}
+	entry := &logrus.Entry{Level: logrus.InfoLevel, Time: time.Now(), Message: "Stopping existing Finch virtual machine..."}
+	assert.NoError(t, hook.Fire(entry))
+	assert.NoError(t, hook.Fire(entry))
+	assert.Equal(t, 1, dials)
+}
+
+func TestSyslogHook_FireStopsForwardingOnceTheServerStopsReading(t *testing.T) {
+	t.Parallel()
+
+	// Nothing reads from the other end of the pipe, so the writes block like once the buffers of a stalled server
+	// are full.
+	client, server := net.Pipe()
+	defer server.Close() //nolint:errcheck // closing the pipe in a test
+	dials := 0
+	hook := &SyslogHook{
+		transport:    "tcp",
+		address:      "logs.example.com:514",
+		appName:      "finch",
+		hostname:     "host",
+		writeTimeout: 10 * time.Millisecond,
+		dial: func(string, string) (net.Conn, error) {
+			dials++
+			return client, nil
+		},
+	}
+	entry := &logrus.Entry{Level: logrus.InfoLevel, Time: time.Now(), Message: "Stopping existing Finch virtual machine..."}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assert.NoError(t, hook.Fire(entry))
+		assert.NoError(t, hook.Fire(entry))
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the logs were held up by the server that stopped reading")
+	}
+	assert.Equal(t, 1, dials)
+	assert.True(t, hook.failed)
+}
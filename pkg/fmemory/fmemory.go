@@ -13,14 +13,45 @@ import (
 //go:generate mockgen -copyright_file=../../copyright_header -destination=../mocks/pkg_fmemory_memory.go -package=mocks -mock_names Memory=Memory . Memory
 type Memory interface {
 	TotalMemory() uint64
+	// AvailableMemory returns the memory, in bytes, that can be allocated without swapping.
+	AvailableMemory() (uint64, error)
+	// SwapUsage returns how much of the swap is used and its total size, in bytes.
+	SwapUsage() (used, total uint64, err error)
+	// PressureLevel returns the memory pressure the kernel reports, PressureNormal if it doesn't report any.
+	PressureLevel() (PressureLevel, error)
 }
 
+// PressureLevel is how short on memory the kernel considers the system to be.
+type PressureLevel int
+
+const (
+	// PressureNormal is when the system has the memory it needs.
+	PressureNormal PressureLevel = iota
+	// PressureWarning is when the system compresses or swaps out memory to keep up, e.g. with the swap macOS grows
+	// as needed, which it routinely does.
+	PressureWarning
+	// PressureCritical is when the system is about to run out of memory, it terminates processes to free some.
+	PressureCritical
+)
+
 type mem struct{}
 
 func (mem) TotalMemory() uint64 {
 	return memory.TotalMemory()
 }
 
+func (mem) AvailableMemory() (uint64, error) {
+	return availableMemory()
+}
+
+func (mem) SwapUsage() (uint64, uint64, error) {
+	return swapUsage()
+}
+
+func (mem) PressureLevel() (PressureLevel, error) {
+	return pressureLevel()
+}
+
 // NewMemory returns a Memory instance that calls memory.TotalMemory under the hood.
 func NewMemory() Memory {
 	return &mem{}
@@ -0,0 +1,61 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build darwin
+
+package fmemory
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os/exec"
+
+	"golang.org/x/sys/unix"
+)
+
+func availableMemory() (uint64, error) {
+	out, err := exec.Command("vm_stat").Output()
+	if err != nil {
+		return 0, fmt.Errorf("failed to run vm_stat: %w", err)
+	}
+	return parseVMStat(out)
+}
+
+// swapUsage reads the xsw_usage struct of the vm.swapusage sysctl, which starts with
+// the total, available and used swap as 64-bit integers.
+func swapUsage() (uint64, uint64, error) {
+	usage, err := unix.SysctlRaw("vm.swapusage")
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read vm.swapusage: %w", err)
+	}
+	if len(usage) < 24 {
+		return 0, 0, fmt.Errorf("unexpected size of vm.swapusage: %d bytes", len(usage))
+	}
+	return binary.LittleEndian.Uint64(usage[16:24]), binary.LittleEndian.Uint64(usage[0:8]), nil
+}
+
+// The levels of the kern.memorystatus_vm_pressure_level sysctl, see kern_memorystatus.h.
+const (
+	darwinPressureNormal   = 1
+	darwinPressureWarning  = 2
+	darwinPressureCritical = 4
+)
+
+// pressureLevel reads the memory pressure level the kernel reports, the one the memory pressure graph of
+// Activity Monitor shows.
+func pressureLevel() (PressureLevel, error) {
+	level, err := unix.SysctlUint32("kern.memorystatus_vm_pressure_level")
+	if err != nil {
+		return PressureNormal, fmt.Errorf("failed to read kern.memorystatus_vm_pressure_level: %w", err)
+	}
+	switch level {
+	case darwinPressureNormal:
+		return PressureNormal, nil
+	case darwinPressureWarning:
+		return PressureWarning, nil
+	case darwinPressureCritical:
+		return PressureCritical, nil
+	default:
+		return PressureNormal, fmt.Errorf("unexpected kern.memorystatus_vm_pressure_level: %d", level)
+	}
+}
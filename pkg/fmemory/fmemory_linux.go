@@ -0,0 +1,34 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build linux
+
+package fmemory
+
+import (
+	"fmt"
+	"os"
+)
+
+func readMeminfo() (uint64, uint64, uint64, error) {
+	out, err := os.ReadFile("/proc/meminfo")
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to read /proc/meminfo: %w", err)
+	}
+	return parseMeminfo(out)
+}
+
+func availableMemory() (uint64, error) {
+	available, _, _, err := readMeminfo()
+	return available, err
+}
+
+func swapUsage() (uint64, uint64, error) {
+	_, used, total, err := readMeminfo()
+	return used, total, err
+}
+
+// pressureLevel reports no pressure, the kernel has no level of it the way macOS does.
+func pressureLevel() (PressureLevel, error) {
+	return PressureNormal, nil
+}
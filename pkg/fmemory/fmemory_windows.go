@@ -0,0 +1,69 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build windows
+
+package fmemory
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// memoryStatusEx is the MEMORYSTATUSEX struct filled by GlobalMemoryStatusEx.
+type memoryStatusEx struct {
+	length               uint32
+	memoryLoad           uint32
+	totalPhys            uint64
+	availPhys            uint64
+	totalPageFile        uint64
+	availPageFile        uint64
+	totalVirtual         uint64
+	availVirtual         uint64
+	availExtendedVirtual uint64
+}
+
+var procGlobalMemoryStatusEx = windows.NewLazySystemDLL("kernel32.dll").NewProc("GlobalMemoryStatusEx")
+
+func globalMemoryStatus() (*memoryStatusEx, error) {
+	status := &memoryStatusEx{}
+	status.length = uint32(unsafe.Sizeof(*status))
+	if r, _, err := procGlobalMemoryStatusEx.Call(uintptr(unsafe.Pointer(status))); r == 0 {
+		return nil, fmt.Errorf("failed to get the memory status: %w", err)
+	}
+	return status, nil
+}
+
+func availableMemory() (uint64, error) {
+	status, err := globalMemoryStatus()
+	if err != nil {
+		return 0, err
+	}
+	return status.availPhys, nil
+}
+
+// swapUsage derives the usage of the page files from the commit limit and charge,
+// which cover both the physical memory and the page files.
+func swapUsage() (uint64, uint64, error) {
+	status, err := globalMemoryStatus()
+	if err != nil {
+		return 0, 0, err
+	}
+	if status.totalPageFile <= status.totalPhys {
+		return 0, 0, nil
+	}
+	total := status.totalPageFile - status.totalPhys
+	committed := status.totalPageFile - status.availPageFile
+	inMemory := status.totalPhys - status.availPhys
+	if committed <= inMemory {
+		return 0, total, nil
+	}
+	return min(committed-inMemory, total), total, nil
+}
+
+// pressureLevel reports no pressure, the kernel has no level of it the way macOS does.
+func pressureLevel() (PressureLevel, error) {
+	return PressureNormal, nil
+}
@@ -0,0 +1,73 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package fmemory
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var vmStatPageSize = regexp.MustCompile(`page size of (\d+) bytes`)
+
+// parseVMStat returns the available memory reported by the output of the vm_stat command of macOS,
+// i.e. the pages that are free, or that the kernel can reclaim without swapping: the inactive and speculative pages.
+// The purgeable pages aren't added, they are already counted among the active and inactive ones.
+func parseVMStat(out []byte) (uint64, error) {
+	match := vmStatPageSize.FindSubmatch(out)
+	if match == nil {
+		return 0, fmt.Errorf("no page size in the output of vm_stat: %q", out)
+	}
+	pageSize, err := strconv.ParseUint(string(match[1]), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse the page size of vm_stat: %w", err)
+	}
+	names := []string{"Pages free", "Pages inactive", "Pages speculative"}
+	pages, err := parseFields(out, ":", ".", names...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse the output of vm_stat: %w", err)
+	}
+	var available uint64
+	for _, name := range names {
+		available += pages[name] * pageSize
+	}
+	return available, nil
+}
+
+// parseMeminfo returns the available memory, the used swap and the total swap reported by /proc/meminfo, in bytes.
+func parseMeminfo(out []byte) (available, swapUsed, swapTotal uint64, err error) {
+	kib, err := parseFields(out, ":", "kB", "MemAvailable", "SwapTotal", "SwapFree")
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to parse /proc/meminfo: %w", err)
+	}
+	return kib["MemAvailable"] * 1024, (kib["SwapTotal"] - kib["SwapFree"]) * 1024, kib["SwapTotal"] * 1024, nil
+}
+
+// parseFields parses the integer value of each of the names in the lines of out formatted as
+// "<name><sep> <value><suffix>", it fails if one of them is missing.
+func parseFields(out []byte, sep, suffix string, names ...string) (map[string]uint64, error) {
+	values := make(map[string]uint64, len(names))
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(scanner.Text(), sep)
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(value), suffix))
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		values[strings.TrimSpace(name)] = n
+	}
+	for _, name := range names {
+		if _, ok := values[name]; !ok {
+			return nil, fmt.Errorf("no %q", name)
+		}
+	}
+	return values, nil
+}
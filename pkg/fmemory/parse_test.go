@@ -0,0 +1,79 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package fmemory
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseVMStat(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name    string
+		out     string
+		want    uint64
+		wantErr error
+	}{
+		{
+			name: "should count the free and reclaimable pages, but not the purgeable ones again",
+			out: `Mach Virtual Memory Statistics: (page size of 16384 bytes)
+Pages free:                                3000.
+Pages active:                            200000.
+Pages inactive:                          100000.
+Pages speculative:                         2000.
+Pages throttled:                              0.
+Pages wired down:                         90000.
+Pages purgeable:                           1000.
+`,
+			want: (3000 + 100000 + 2000) * 16384,
+		},
+		{
+			name:    "should fail without the page size",
+			out:     "Pages free: 3000.\n",
+			wantErr: errors.New(`no page size in the output of vm_stat: "Pages free: 3000.\n"`),
+		},
+		{
+			name:    "should fail if a count is missing",
+			out:     "Mach Virtual Memory Statistics: (page size of 4096 bytes)\nPages free: 3000.\n",
+			wantErr: errors.New(`failed to parse the output of vm_stat: no "Pages inactive"`),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := parseVMStat([]byte(tc.out))
+			if tc.wantErr == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.wantErr.Error())
+			}
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestParseMeminfo(t *testing.T) {
+	t.Parallel()
+
+	out := `MemTotal:       16303428 kB
+MemFree:          512000 kB
+MemAvailable:    8000000 kB
+SwapTotal:       2097148 kB
+SwapFree:        1048574 kB
+`
+	available, swapUsed, swapTotal, err := parseMeminfo([]byte(out))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(8000000*1024), available)
+	assert.Equal(t, uint64(1048574*1024), swapUsed)
+	assert.Equal(t, uint64(2097148*1024), swapTotal)
+
+	_, _, _, err = parseMeminfo([]byte("MemTotal: 16303428 kB\n"))
+	assert.EqualError(t, err, `failed to parse /proc/meminfo: no "MemAvailable"`)
+}
@@ -0,0 +1,56 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package lima
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrInstanceAlreadyStopped is returned by StopGuard.Acquire when another goroutine is already stopping the instance
+// and the FailIfStopping policy is used.
+var ErrInstanceAlreadyStopped = errors.New("the instance is already being stopped")
+
+// StopPolicy tells StopGuard.Acquire what to do when the instance is already being stopped.
+type StopPolicy int
+
+const (
+	// WaitForStop blocks until the ongoing stop is done.
+	WaitForStop StopPolicy = iota
+	// FailIfStopping returns ErrInstanceAlreadyStopped right away.
+	FailIfStopping
+)
+
+// StopGuard serializes the stops of the same instance within a single process.
+// Stops of different instances don't block each other.
+type StopGuard struct {
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+// NewStopGuard returns a StopGuard that isn't guarding any instance yet.
+func NewStopGuard() *StopGuard {
+	return &StopGuard{locks: map[string]*sync.Mutex{}}
+}
+
+// Acquire marks the instance as being stopped, applying policy if it already is.
+// The returned function must be called once the stop is done.
+func (g *StopGuard) Acquire(instance string, policy StopPolicy) (func(), error) {
+	g.mu.Lock()
+	lock, ok := g.locks[instance]
+	if !ok {
+		lock = &sync.Mutex{}
+		g.locks[instance] = lock
+	}
+	g.mu.Unlock()
+
+	if policy == FailIfStopping {
+		if !lock.TryLock() {
+			return nil, ErrInstanceAlreadyStopped
+		}
+	} else {
+		lock.Lock()
+	}
+	return lock.Unlock, nil
+}
@@ -0,0 +1,104 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package lima_test
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/runfinch/finch/pkg/lima"
+)
+
+func TestStopGuard_Acquire(t *testing.T) {
+	t.Parallel()
+
+	t.Run("overlapping stops of the same instance are serialized", func(t *testing.T) {
+		t.Parallel()
+
+		guard := lima.NewStopGuard()
+		var stopping, overlaps atomic.Int32
+		var wg sync.WaitGroup
+		for range 2 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				release, err := guard.Acquire("finch", lima.WaitForStop)
+				if !assert.NoError(t, err) {
+					return
+				}
+				defer release()
+				if stopping.Add(1) > 1 {
+					overlaps.Add(1)
+				}
+				time.Sleep(10 * time.Millisecond)
+				stopping.Add(-1)
+			}()
+		}
+		wg.Wait()
+		assert.Zero(t, overlaps.Load())
+	})
+
+	t.Run("the second stop fails with the FailIfStopping policy", func(t *testing.T) {
+		t.Parallel()
+
+		guard := lima.NewStopGuard()
+		release, err := guard.Acquire("finch", lima.FailIfStopping)
+		require.NoError(t, err)
+
+		errs := make(chan error, 1)
+		go func() {
+			_, err := guard.Acquire("finch", lima.FailIfStopping)
+			errs <- err
+		}()
+		assert.ErrorIs(t, <-errs, lima.ErrInstanceAlreadyStopped)
+
+		release()
+		release, err = guard.Acquire("finch", lima.FailIfStopping)
+		require.NoError(t, err)
+		release()
+	})
+
+	t.Run("the second stop waits with the WaitForStop policy", func(t *testing.T) {
+		t.Parallel()
+
+		guard := lima.NewStopGuard()
+		release, err := guard.Acquire("finch", lima.WaitForStop)
+		require.NoError(t, err)
+
+		acquired := make(chan struct{})
+		go func() {
+			release, err := guard.Acquire("finch", lima.WaitForStop)
+			if assert.NoError(t, err) {
+				release()
+			}
+			close(acquired)
+		}()
+
+		select {
+		case <-acquired:
+			t.Fatal("the second stop didn't wait for the first one")
+		case <-time.After(50 * time.Millisecond):
+		}
+		release()
+		<-acquired
+	})
+
+	t.Run("stops of different instances don't block each other", func(t *testing.T) {
+		t.Parallel()
+
+		guard := lima.NewStopGuard()
+		release, err := guard.Acquire("finch", lima.FailIfStopping)
+		require.NoError(t, err)
+		defer release()
+
+		releaseOther, err := guard.Acquire("finch-test", lima.FailIfStopping)
+		require.NoError(t, err)
+		releaseOther()
+	})
+}
@@ -0,0 +1,83 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package lima
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/afero"
+
+	"github.com/runfinch/finch/pkg/command"
+)
+
+// finchInstanceName is the name of the instance Finch creates, the names of the other instances it creates start with it.
+const finchInstanceName = "finch"
+
+// Instance is the description of a Lima instance, as reported by `limactl ls --json`.
+type Instance struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Dir    string `json:"dir"`
+	VMType string `json:"vmType"`
+	Arch   string `json:"arch"`
+	CPUs   int    `json:"cpus"`
+	Memory int64  `json:"memory"`
+	Disk   int64  `json:"disk"`
+}
+
+// ListInstances returns the description of every instance of the Lima home the creator targets.
+func ListInstances(creator command.NerdctlCmdCreator) ([]Instance, error) {
+	out, err := creator.CreateWithoutStdio("ls", "--json").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the instances: %w", err)
+	}
+	// Lima prints one JSON object per instance, one per line.
+	var instances []Instance
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var inst Instance
+		if err := json.Unmarshal(line, &inst); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal the description of an instance: %w", err)
+		}
+		instances = append(instances, inst)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read the list of instances: %w", err)
+	}
+	return instances, nil
+}
+
+// ListFinchInstances returns the description of the instances created by Finch, see IsFinchInstance.
+func ListFinchInstances(creator command.NerdctlCmdCreator, fs afero.Fs, instancePrefix string) ([]Instance, error) {
+	instances, err := ListInstances(creator)
+	if err != nil {
+		return nil, err
+	}
+	var finchInstances []Instance
+	for _, inst := range instances {
+		if IsFinchInstance(fs, inst.Name, inst.Dir, instancePrefix) {
+			finchInstances = append(finchInstances, inst)
+		}
+	}
+	return finchInstances, nil
+}
+
+// IsFinchInstance reports whether the instance named name, whose directory is instanceDir, was created by Finch:
+// either it's named after the Finch instance with the configured instancePrefix (e.g. "finch" and "finch-test",
+// or "alice-finch" and "alice-finch-test" with the prefix "alice-"), or Finch has recorded metadata about it.
+func IsFinchInstance(fs afero.Fs, name, instanceDir, instancePrefix string) bool {
+	instanceName := instancePrefix + finchInstanceName
+	if name == instanceName || strings.HasPrefix(name, instanceName+"-") {
+		return true
+	}
+	return HasInstanceMetadata(fs, instanceDir)
+}
@@ -0,0 +1,101 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package lima_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/runfinch/finch/pkg/lima"
+	"github.com/runfinch/finch/pkg/mocks"
+)
+
+func TestListFinchInstances(t *testing.T) {
+	t.Parallel()
+
+	const out = `{"name":"finch","status":"Running","dir":"/lima/finch","vmType":"vz","arch":"aarch64","cpus":2,"disk":53687091200}
+{"name":"docker","status":"Stopped","dir":"/lima/docker","vmType":"qemu","disk":107374182400}
+{"name":"renamed","status":"Stopped","dir":"/lima/renamed","vmType":"vz","disk":53687091200}
+
+`
+
+	testCases := []struct {
+		name    string
+		out     string
+		outErr  error
+		want    []lima.Instance
+		wantErr error
+	}{
+		{
+			name: "should only list the instances created by Finch",
+			out:  out,
+			want: []lima.Instance{
+				{
+					Name: "finch", Status: "Running", Dir: "/lima/finch", VMType: "vz", Arch: "aarch64",
+					CPUs: 2, Disk: 53687091200,
+				},
+				{Name: "renamed", Status: "Stopped", Dir: "/lima/renamed", VMType: "vz", Disk: 53687091200},
+			},
+		},
+		{
+			name: "should list nothing if there is no instance",
+			out:  "",
+			want: nil,
+		},
+		{
+			name:    "should fail if the instances can't be listed",
+			outErr:  errors.New("exit status 1"),
+			wantErr: errors.New("failed to list the instances: exit status 1"),
+		},
+		{
+			name:    "should fail if an instance can't be parsed",
+			out:     "not json\n",
+			wantErr: errors.New("failed to unmarshal the description of an instance: invalid character 'o' in literal null (expecting 'u')"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			creator := mocks.NewNerdctlCmdCreator(ctrl)
+			cmd := mocks.NewCommand(ctrl)
+			creator.EXPECT().CreateWithoutStdio("ls", "--json").Return(cmd)
+			cmd.EXPECT().Output().Return([]byte(tc.out), tc.outErr)
+			fs := afero.NewMemMapFs()
+			require.NoError(t, lima.SaveInstanceMetadata(fs, "/lima/renamed", &lima.InstanceMetadata{}))
+
+			instances, err := lima.ListFinchInstances(creator, fs, "")
+			if tc.wantErr != nil {
+				assert.EqualError(t, err, tc.wantErr.Error())
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, instances)
+		})
+	}
+}
+
+func TestIsFinchInstance(t *testing.T) {
+	t.Parallel()
+
+	fs := afero.NewMemMapFs()
+	require.NoError(t, lima.SaveInstanceMetadata(fs, "/lima/renamed", &lima.InstanceMetadata{}))
+
+	assert.True(t, lima.IsFinchInstance(fs, "finch", "/lima/finch", ""))
+	assert.True(t, lima.IsFinchInstance(fs, "finch-test", "/lima/finch-test", ""))
+	assert.True(t, lima.IsFinchInstance(fs, "alice-finch", "/lima/alice-finch", "alice-"))
+	assert.True(t, lima.IsFinchInstance(fs, "alice-finch-test", "/lima/alice-finch-test", "alice-"))
+	assert.True(t, lima.IsFinchInstance(fs, "renamed", "/lima/renamed", ""))
+	assert.False(t, lima.IsFinchInstance(fs, "finchy", "/lima/finchy", ""))
+	assert.False(t, lima.IsFinchInstance(fs, "notfinch", "/lima/notfinch", ""))
+	assert.False(t, lima.IsFinchInstance(fs, "bob-finch", "/lima/bob-finch", "alice-"))
+	assert.False(t, lima.IsFinchInstance(fs, "docker", "/lima/docker", ""))
+}
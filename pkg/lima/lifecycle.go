@@ -0,0 +1,63 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package lima
+
+import (
+	"sync"
+	"time"
+)
+
+// StopResult is the outcome of a stop of an instance that didn't fail.
+type StopResult struct {
+	Instance string
+	// Stopped is false if the instance was left running, e.g. because the stop wasn't confirmed.
+	Stopped  bool
+	Forced   bool
+	Duration time.Duration
+}
+
+// LifecycleListener is notified of the lifecycle events of the instances, for the programs embedding Finch
+// that need to react to them in-process. The listeners are called synchronously, so they must not block.
+type LifecycleListener interface {
+	// OnStopBegin is called before the instance is stopped.
+	OnStopBegin(instance string)
+	// OnStopComplete is called once the stop of an instance is done.
+	OnStopComplete(result StopResult)
+	// OnStopError is called when the stop of the instance failed.
+	OnStopError(instance string, err error)
+}
+
+// NopLifecycleListener ignores all the lifecycle events,
+// it can be embedded by the listeners that only care about some of them.
+type NopLifecycleListener struct{}
+
+var _ LifecycleListener = NopLifecycleListener{}
+
+// OnStopBegin does nothing.
+func (NopLifecycleListener) OnStopBegin(string) {}
+
+// OnStopComplete does nothing.
+func (NopLifecycleListener) OnStopComplete(StopResult) {}
+
+// OnStopError does nothing.
+func (NopLifecycleListener) OnStopError(string, error) {}
+
+var (
+	lifecycleListenersMu sync.Mutex
+	lifecycleListeners   []LifecycleListener
+)
+
+// RegisterLifecycleListener registers the listener to be notified of the lifecycle events from now on.
+func RegisterLifecycleListener(l LifecycleListener) {
+	lifecycleListenersMu.Lock()
+	defer lifecycleListenersMu.Unlock()
+	lifecycleListeners = append(lifecycleListeners, l)
+}
+
+// LifecycleListeners returns the registered listeners, in the order they were registered.
+func LifecycleListeners() []LifecycleListener {
+	lifecycleListenersMu.Lock()
+	defer lifecycleListenersMu.Unlock()
+	return append([]LifecycleListener(nil), lifecycleListeners...)
+}
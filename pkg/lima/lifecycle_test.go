@@ -0,0 +1,35 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package lima_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/runfinch/finch/pkg/lima"
+)
+
+type instanceListener struct {
+	lima.NopLifecycleListener
+	began []string
+}
+
+func (l *instanceListener) OnStopBegin(instance string) {
+	l.began = append(l.began, instance)
+}
+
+// TestRegisterLifecycleListener isn't parallel as it changes the registered listeners of the package.
+func TestRegisterLifecycleListener(t *testing.T) {
+	l := &instanceListener{}
+	lima.RegisterLifecycleListener(l)
+
+	listeners := lima.LifecycleListeners()
+	assert.Contains(t, listeners, lima.LifecycleListener(l))
+	for _, listener := range listeners {
+		listener.OnStopBegin("finch")
+		listener.OnStopComplete(lima.StopResult{Instance: "finch", Stopped: true})
+	}
+	assert.Equal(t, []string{"finch"}, l.began)
+}
@@ -7,6 +7,8 @@ package lima
 import (
 	"errors"
 	"fmt"
+	"io/fs"
+	"os/exec"
 	"strings"
 
 	"github.com/runfinch/finch/pkg/command"
@@ -26,12 +28,18 @@ type VMType string
 // LimaVersion is injected at build time to be used in the call to osutil.LimaUser.
 var LimaVersion string
 
-// Finch CLI assumes there are only 4 VM status below. Adding more statuses will need to make changes in the caller side.
+// Finch CLI assumes there are only 4 VM status below, Broken, Starting and Suspended are only reported by Status.
+// Adding more statuses will need to make changes in the caller side.
 const (
 	Running VMStatus = iota
 	Stopped
 	Nonexistent
 	Unknown
+	Broken
+	// Starting is any of the transient statuses Lima reports while the instance boots, see startingStatuses.
+	Starting
+	// Suspended is an instance whose VM state is saved to be restored on its next start, see suspendedStatuses.
+	Suspended
 	QEMU              VMType = "qemu"
 	VZ                VMType = "vz"
 	WSL               VMType = "wsl2"
@@ -39,11 +47,23 @@ const (
 	UnknownVMType     VMType = "unknown"
 )
 
+// ErrLimactlNotFound is returned when limactl can't be run because its binary is missing.
+var ErrLimactlNotFound = errors.New("limactl not found on PATH; reinstall finch or run 'finch vm init'")
+
+// limactlNotFound reports whether running limactl failed because its binary is missing,
+// either from the PATH or, as Finch runs the one it bundles, from where it's expected to be.
+func limactlNotFound(err error) bool {
+	return errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist)
+}
+
 // GetVMStatus returns the Lima VM status.
 func GetVMStatus(creator command.NerdctlCmdCreator, logger flog.Logger, instanceName string) (VMStatus, error) {
 	args := []string{"ls", "-f", "{{.Status}}", instanceName}
 	cmd := creator.CreateWithoutStdio(args...)
 	out, err := cmd.Output()
+	if limactlNotFound(err) {
+		return Unknown, ErrLimactlNotFound
+	}
 	if err != nil {
 		if strings.TrimSpace(string(out)) == "" ||
 			strings.Contains(strings.TrimSpace(string(out)), fmt.Sprintf("No instance matching %s found", instanceName)) {
@@ -55,11 +75,123 @@ func GetVMStatus(creator command.NerdctlCmdCreator, logger flog.Logger, instance
 	return toVMStatus(status, logger)
 }
 
+// String returns the name of the status, as Lima reports it.
+func (s VMStatus) String() string {
+	switch s {
+	case Running:
+		return "Running"
+	case Stopped:
+		return "Stopped"
+	case Nonexistent:
+		return "Nonexistent"
+	case Broken:
+		return "Broken"
+	case Starting:
+		return "Starting"
+	case Suspended:
+		return "Suspended"
+	default:
+		return "Unknown"
+	}
+}
+
+// UnknownStatusError is returned along with the Unknown status by Status, and holds the status reported by Lima.
+type UnknownStatusError struct {
+	Status string
+}
+
+func (e *UnknownStatusError) Error() string {
+	return fmt.Sprintf("unrecognized system status %q", e.Status)
+}
+
+// Status returns the status of the Lima instance.
+// Unlike GetVMStatus, it tells broken instances apart, and returns an *UnknownStatusError
+// holding the status reported by Lima when it isn't recognized.
+func Status(creator command.NerdctlCmdCreator, instanceName string) (VMStatus, error) {
+	out, err := creator.CreateWithoutStdio("ls", "-f", "{{.Status}}", instanceName).Output()
+	status := strings.TrimSpace(string(out))
+	if limactlNotFound(err) {
+		return Unknown, ErrLimactlNotFound
+	}
+	if err != nil {
+		if status == "" || strings.Contains(status, fmt.Sprintf("No instance matching %s found", instanceName)) {
+			return Nonexistent, nil
+		}
+		return Unknown, err
+	}
+	if status == "" {
+		return Nonexistent, nil
+	}
+	if vmStatus, ok := parseStatus(status, LimaVersion, statusQuirks); ok {
+		return vmStatus, nil
+	}
+	return Unknown, &UnknownStatusError{Status: status}
+}
+
+// statusQuirk is a status that some limactl versions report differently from the others.
+type statusQuirk struct {
+	// versionPrefix selects the limactl versions the quirk applies to, e.g. "0.19." or "1.0.0-rc".
+	versionPrefix string
+	// reported is the status as these versions report it.
+	reported string
+	// status is what the reported status means.
+	status VMStatus
+}
+
+// statusQuirks are the quirks of the limactl versions Finch is known to be used with, selected by LimaVersion.
+// Statuses are matched regardless of surrounding whitespace and letter case, only quirks beyond those need an entry.
+var statusQuirks = []statusQuirk{
+	// Since WSL2 support was added in 0.18, an instance whose distro hasn't been imported yet is reported as
+	// Uninitialized rather than Stopped. It isn't running, and limactl start imports it.
+	{versionPrefix: "0.18.", reported: "Uninitialized", status: Stopped},
+	{versionPrefix: "0.19.", reported: "Uninitialized", status: Stopped},
+	{versionPrefix: "0.20.", reported: "Uninitialized", status: Stopped},
+	{versionPrefix: "0.21.", reported: "Uninitialized", status: Stopped},
+	{versionPrefix: "0.22.", reported: "Uninitialized", status: Stopped},
+	{versionPrefix: "0.23.", reported: "Uninitialized", status: Stopped},
+	{versionPrefix: "1.", reported: "Uninitialized", status: Stopped},
+}
+
+// parseStatus returns the status that limactl of the given version reports as reported, or false if it's unknown.
+func parseStatus(reported, version string, quirks []statusQuirk) (VMStatus, bool) {
+	reported = strings.TrimSpace(reported)
+	for _, q := range quirks {
+		if version != "" && strings.HasPrefix(version, q.versionPrefix) && strings.EqualFold(reported, q.reported) {
+			return q.status, true
+		}
+	}
+	for _, status := range []VMStatus{Running, Stopped, Broken} {
+		if strings.EqualFold(reported, status.String()) {
+			return status, true
+		}
+	}
+	for _, starting := range startingStatuses {
+		if strings.EqualFold(reported, starting) {
+			return Starting, true
+		}
+	}
+	for _, suspended := range suspendedStatuses {
+		if strings.EqualFold(reported, suspended) {
+			return Suspended, true
+		}
+	}
+	return Unknown, false
+}
+
+// startingStatuses are the statuses Lima reports an instance with while it's booting, before it's running.
+var startingStatuses = []string{"Starting", "Initializing", "Installing"}
+
+// suspendedStatuses are the statuses Lima reports an instance with once its VM state is saved.
+var suspendedStatuses = []string{"Suspended", "Hibernated"}
+
 // GetVMType returns the Lima VMType for a running instance.
 func GetVMType(creator command.NerdctlCmdCreator, logger flog.Logger, instanceName string) (VMType, error) {
 	args := []string{"ls", "-f", "{{.VMType}}", instanceName}
 	cmd := creator.CreateWithoutStdio(args...)
 	out, err := cmd.Output()
+	if limactlNotFound(err) {
+		return UnknownVMType, ErrLimactlNotFound
+	}
 	if err != nil {
 		return UnknownVMType, err
 	}
@@ -69,16 +201,16 @@ func GetVMType(creator command.NerdctlCmdCreator, logger flog.Logger, instanceNa
 
 func toVMStatus(status string, logger flog.Logger) (VMStatus, error) {
 	logger.Debugf("Status of virtual machine: %s", status)
-	switch status {
-	case "":
+	if status == "" {
 		return Nonexistent, nil
-	case "Running":
-		return Running, nil
-	case "Stopped":
-		return Stopped, nil
-	default:
-		return Unknown, errors.New("unrecognized system status")
 	}
+	// GetVMStatus callers only expect the statuses it has always returned, a broken, starting or suspended instance
+	// isn't one of them.
+	if vmStatus, ok := parseStatus(status, LimaVersion, statusQuirks); ok && vmStatus != Broken && vmStatus != Starting &&
+		vmStatus != Suspended {
+		return vmStatus, nil
+	}
+	return Unknown, errors.New("unrecognized system status")
 }
 
 func toVMType(vmType string, logger flog.Logger) (VMType, error) {
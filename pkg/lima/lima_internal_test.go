@@ -0,0 +1,120 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package lima
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseStatus(t *testing.T) {
+	t.Parallel()
+
+	quirks := []statusQuirk{{versionPrefix: "0.19.", reported: "Up", status: Running}}
+	testCases := []struct {
+		name       string
+		reported   string
+		version    string
+		want       VMStatus
+		wantParsed bool
+	}{
+		{
+			name:       "should parse a status regardless of the letter case",
+			reported:   " STOPPED\n",
+			version:    "1.0.0",
+			want:       Stopped,
+			wantParsed: true,
+		},
+		{
+			name:       "should parse the transient statuses of a booting instance as starting",
+			reported:   "installing",
+			version:    "1.0.0",
+			want:       Starting,
+			wantParsed: true,
+		},
+		{
+			name:       "should apply the quirks of the limactl version",
+			reported:   "up",
+			version:    "0.19.1",
+			want:       Running,
+			wantParsed: true,
+		},
+		{
+			name:       "should not apply the quirks of other limactl versions",
+			reported:   "Up",
+			version:    "1.0.0",
+			want:       Unknown,
+			wantParsed: false,
+		},
+		{
+			name:       "should not apply any quirk if the limactl version is unknown",
+			reported:   "Up",
+			version:    "",
+			want:       Unknown,
+			wantParsed: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, parsed := parseStatus(tc.reported, tc.version, quirks)
+			assert.Equal(t, tc.want, got)
+			assert.Equal(t, tc.wantParsed, parsed)
+		})
+	}
+}
+
+func TestStatusQuirks(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name       string
+		reported   string
+		version    string
+		want       VMStatus
+		wantParsed bool
+	}{
+		{
+			name:       "should parse the status of a WSL2 instance that isn't imported yet as stopped on 0.18",
+			reported:   "Uninitialized",
+			version:    "0.18.0",
+			want:       Stopped,
+			wantParsed: true,
+		},
+		{
+			name:       "should parse the status of a WSL2 instance that isn't imported yet as stopped on 0.23",
+			reported:   "Uninitialized",
+			version:    "0.23.2",
+			want:       Stopped,
+			wantParsed: true,
+		},
+		{
+			name:       "should parse the status of a WSL2 instance that isn't imported yet as stopped on 1.x",
+			reported:   "uninitialized\n",
+			version:    "1.1.1",
+			want:       Stopped,
+			wantParsed: true,
+		},
+		{
+			name:       "should not parse the status of an uninitialized instance before WSL2 was supported",
+			reported:   "Uninitialized",
+			version:    "0.17.2",
+			want:       Unknown,
+			wantParsed: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, parsed := parseStatus(tc.reported, tc.version, statusQuirks)
+			assert.Equal(t, tc.want, got)
+			assert.Equal(t, tc.wantParsed, parsed)
+		})
+	}
+}
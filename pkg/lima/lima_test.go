@@ -5,6 +5,9 @@ package lima_test
 
 import (
 	"errors"
+	"io/fs"
+	"os/exec"
+	"syscall"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -74,6 +77,15 @@ func TestGetVMStatus(t *testing.T) {
 				cmd.EXPECT().Output().Return([]byte("Broken "), errors.New("get status error"))
 			},
 		},
+		{
+			name:    "limactl not found",
+			want:    lima.Unknown,
+			wantErr: lima.ErrLimactlNotFound,
+			mockSvc: func(creator *mocks.NerdctlCmdCreator, _ *mocks.Logger, cmd *mocks.Command) {
+				creator.EXPECT().CreateWithoutStdio(mockArgs).Return(cmd)
+				cmd.EXPECT().Output().Return(nil, &exec.Error{Name: "limactl", Err: exec.ErrNotFound})
+			},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -92,6 +104,127 @@ func TestGetVMStatus(t *testing.T) {
 	}
 }
 
+func TestStatus(t *testing.T) {
+	t.Parallel()
+
+	instanceName := "finch"
+	mockArgs := []string{"ls", "-f", "{{.Status}}", instanceName}
+	testCases := []struct {
+		name    string
+		out     string
+		outErr  error
+		want    lima.VMStatus
+		wantErr error
+	}{
+		{
+			name:    "running VM",
+			out:     "Running ",
+			outErr:  nil,
+			want:    lima.Running,
+			wantErr: nil,
+		},
+		{
+			name:    "stopped VM",
+			out:     "Stopped ",
+			outErr:  nil,
+			want:    lima.Stopped,
+			wantErr: nil,
+		},
+		{
+			name:    "broken VM",
+			out:     "Broken ",
+			outErr:  nil,
+			want:    lima.Broken,
+			wantErr: nil,
+		},
+		{
+			name:    "starting VM",
+			out:     "Initializing ",
+			outErr:  nil,
+			want:    lima.Starting,
+			wantErr: nil,
+		},
+		{
+			name:    "suspended VM",
+			out:     "Hibernated ",
+			outErr:  nil,
+			want:    lima.Suspended,
+			wantErr: nil,
+		},
+		{
+			name:    "nonexistent VM",
+			out:     " ",
+			outErr:  nil,
+			want:    lima.Nonexistent,
+			wantErr: nil,
+		},
+		{
+			name:    "nonexistent VM reported as an error",
+			out:     "No instance matching finch found",
+			outErr:  errors.New("exit status 1"),
+			want:    lima.Nonexistent,
+			wantErr: nil,
+		},
+		{
+			name:    "running VM reported in another letter case",
+			out:     "\trunning\n",
+			outErr:  nil,
+			want:    lima.Running,
+			wantErr: nil,
+		},
+		{
+			name:    "unknown VM status",
+			out:     "Paused ",
+			outErr:  nil,
+			want:    lima.Unknown,
+			wantErr: &lima.UnknownStatusError{Status: "Paused"},
+		},
+		{
+			name:    "status command returns an error",
+			out:     "Broken ",
+			outErr:  errors.New("get status error"),
+			want:    lima.Unknown,
+			wantErr: errors.New("get status error"),
+		},
+		{
+			name:    "bundled limactl missing",
+			out:     "",
+			outErr:  &fs.PathError{Op: "fork/exec", Path: "/finch/lima/bin/limactl", Err: syscall.ENOENT},
+			want:    lima.Unknown,
+			wantErr: lima.ErrLimactlNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			creator := mocks.NewNerdctlCmdCreator(ctrl)
+			statusCmd := mocks.NewCommand(ctrl)
+			creator.EXPECT().CreateWithoutStdio(mockArgs).Return(statusCmd)
+			statusCmd.EXPECT().Output().Return([]byte(tc.out), tc.outErr)
+
+			got, err := lima.Status(creator, instanceName)
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestVMStatus_String(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "Running", lima.Running.String())
+	assert.Equal(t, "Stopped", lima.Stopped.String())
+	assert.Equal(t, "Nonexistent", lima.Nonexistent.String())
+	assert.Equal(t, "Broken", lima.Broken.String())
+	assert.Equal(t, "Starting", lima.Starting.String())
+	assert.Equal(t, "Suspended", lima.Suspended.String())
+	assert.Equal(t, "Unknown", lima.Unknown.String())
+	assert.Equal(t, `unrecognized system status "Paused"`, (&lima.UnknownStatusError{Status: "Paused"}).Error())
+}
+
 func TestGetVMType(t *testing.T) {
 	t.Parallel()
 
@@ -0,0 +1,66 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package lima
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// ErrLockHeld is returned by DistributedLock.Acquire when another owner holds the lock.
+var ErrLockHeld = errors.New("the lock is held by another owner")
+
+// DistributedLock is a lock shared by the hosts operating on the same resource, e.g. a user data disk stored
+// on a network share, so that only one of them operates on it at a time.
+type DistributedLock interface {
+	// Acquire takes the lock on behalf of owner, or fails with ErrLockHeld if someone else holds it.
+	// The returned function releases the lock.
+	Acquire(owner string) (release func() error, err error)
+}
+
+// fileLock is a DistributedLock held by whoever managed to create its file, which lives on the shared storage.
+type fileLock struct {
+	fs   afero.Fs
+	path string
+}
+
+var _ DistributedLock = (*fileLock)(nil)
+
+// NewFileLock returns a DistributedLock backed by the file at path, which must be on the storage shared by the hosts.
+// A host that crashes while holding the lock leaves the file behind, it must then be removed by hand.
+func NewFileLock(fs afero.Fs, path string) DistributedLock {
+	return &fileLock{fs: fs, path: path}
+}
+
+func (l *fileLock) Acquire(owner string) (func() error, error) {
+	f, err := l.fs.OpenFile(l.path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			holder, _ := afero.ReadFile(l.fs, l.path)
+			return nil, fmt.Errorf("%w: %s holds %q, remove it if that host is no longer operating on it",
+				ErrLockHeld, strings.TrimSpace(string(holder)), l.path)
+		}
+		return nil, fmt.Errorf("failed to create the lock file %q: %w", l.path, err)
+	}
+	_, err = fmt.Fprintf(f, "%s since %s\n", owner, time.Now().UTC().Format(time.RFC3339))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = l.fs.Remove(l.path)
+		return nil, fmt.Errorf("failed to write the lock file %q: %w", l.path, err)
+	}
+	return func() error {
+		if err := l.fs.Remove(l.path); err != nil {
+			return fmt.Errorf("failed to remove the lock file %q: %w", l.path, err)
+		}
+		return nil
+	}, nil
+}
@@ -0,0 +1,38 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package lima_test
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/runfinch/finch/pkg/lima"
+)
+
+func TestFileLock_Acquire(t *testing.T) {
+	t.Parallel()
+
+	const lockPath = "/shared/finch.lock"
+	fs := afero.NewMemMapFs()
+	lock := lima.NewFileLock(fs, lockPath)
+
+	release, err := lock.Acquire("host-a (pid 1)")
+	require.NoError(t, err)
+	b, err := afero.ReadFile(fs, lockPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(b), "host-a (pid 1) since ")
+
+	// Another host sharing the storage can't take the lock until it's released.
+	_, err = lima.NewFileLock(fs, lockPath).Acquire("host-b (pid 2)")
+	require.ErrorIs(t, err, lima.ErrLockHeld)
+	assert.ErrorContains(t, err, "host-a (pid 1) since ")
+
+	require.NoError(t, release())
+	release, err = lima.NewFileLock(fs, lockPath).Acquire("host-b (pid 2)")
+	require.NoError(t, err)
+	assert.NoError(t, release())
+}
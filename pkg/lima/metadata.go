@@ -0,0 +1,111 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package lima
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// metadataFileName is the name of the file, inside of the Lima instance directory,
+// that Finch persists its own state of the instance to.
+// Keeping it in the instance directory ensures that it's removed together with the instance.
+const metadataFileName = "finch-metadata.json"
+
+// InstanceMetadata is the state Finch keeps about a Lima instance across invocations.
+type InstanceMetadata struct {
+	// Hibernated is true if the VM state was saved to disk when the instance was last stopped.
+	Hibernated bool `json:"hibernated,omitempty"`
+	// Snapshots are the tags of the snapshots taken of the instance with `finch vm stop --tag`.
+	Snapshots []string `json:"snapshots,omitempty"`
+	// HostBootTime is when the host had booted the last time Finch started the instance,
+	// which tells whether the instance was started during the current boot session of the host.
+	HostBootTime time.Time `json:"hostBootTime,omitzero"`
+	// PendingOperation is the operation Finch is in the middle of on the instance, e.g. OperationStop.
+	// It's cleared once the operation completes, so finding it set means the operation was interrupted.
+	PendingOperation string `json:"pendingOperation,omitempty"`
+	// CheckpointedContainers are the containers checkpointed by `finch vm stop --preserve-containers-state`,
+	// which are restored from their checkpoint on the next start.
+	CheckpointedContainers []string `json:"checkpointedContainers,omitempty"`
+	// Session are the containers that were running when the instance was last stopped with
+	// `finch vm stop --record-session`, which `finch vm start --restore-session` restores.
+	Session []SessionContainer `json:"session,omitempty"`
+	// StopAnnotations are the key=value pairs attached to the last stop with `finch vm stop --annotate`.
+	StopAnnotations map[string]string `json:"stopAnnotations,omitempty"`
+	// StopFailures is the number of stops of the instance that failed in a row, reset by a successful stop.
+	StopFailures int `json:"stopFailures,omitempty"`
+	// RunConfig is the effective Finch configuration the instance was last started with, with the same keys
+	// as finch.yaml. It becomes LastRunConfig once the instance is stopped.
+	RunConfig map[string]any `json:"runConfig,omitempty"`
+	// LastRunConfig is the effective Finch configuration the instance ran with until it was last stopped,
+	// with the same keys as finch.yaml.
+	LastRunConfig map[string]any `json:"lastRunConfig,omitempty"`
+}
+
+// SessionContainer is what is needed to recreate a container recorded by `finch vm stop --record-session`.
+// Only its spec is recorded, not its state, e.g. what it keeps in memory or has written to its own file system.
+type SessionContainer struct {
+	// Namespace is the containerd namespace of the container, empty for the one nerdctl is configured with.
+	Namespace  string   `json:"namespace,omitempty"`
+	Name       string   `json:"name"`
+	Image      string   `json:"image"`
+	Entrypoint []string `json:"entrypoint,omitempty"`
+	Cmd        []string `json:"cmd,omitempty"`
+	// Ports are the published ports of the container, in the format of `nerdctl run --publish`.
+	Ports []string `json:"ports,omitempty"`
+}
+
+// OperationStop is the PendingOperation of an instance that is being stopped.
+const OperationStop = "stop"
+
+// LoadInstanceMetadata reads the metadata of the instance in instanceDir.
+// An instance without any metadata yet gets an empty InstanceMetadata.
+func LoadInstanceMetadata(fs afero.Fs, instanceDir string) (*InstanceMetadata, error) {
+	b, err := afero.ReadFile(fs, filepath.Join(instanceDir, metadataFileName))
+	if err != nil {
+		if errors.Is(err, afero.ErrFileNotFound) {
+			return &InstanceMetadata{}, nil
+		}
+		return nil, fmt.Errorf("failed to read instance metadata: %w", err)
+	}
+
+	var md InstanceMetadata
+	if err := json.Unmarshal(b, &md); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal instance metadata: %w", err)
+	}
+	return &md, nil
+}
+
+// HasInstanceMetadata reports whether Finch has ever recorded metadata about the instance in instanceDir.
+func HasInstanceMetadata(fs afero.Fs, instanceDir string) bool {
+	exists, err := afero.Exists(fs, filepath.Join(instanceDir, metadataFileName))
+	return err == nil && exists
+}
+
+// SaveInstanceMetadata writes the metadata of the instance in instanceDir.
+func SaveInstanceMetadata(fs afero.Fs, instanceDir string, md *InstanceMetadata) error {
+	b, err := json.Marshal(md)
+	if err != nil {
+		return fmt.Errorf("failed to marshal instance metadata: %w", err)
+	}
+	if err := afero.WriteFile(fs, filepath.Join(instanceDir, metadataFileName), b, 0o600); err != nil {
+		return fmt.Errorf("failed to write instance metadata: %w", err)
+	}
+	return nil
+}
+
+// UpdateInstanceMetadata loads the metadata of the instance in instanceDir, applies update to it, and saves it back.
+func UpdateInstanceMetadata(fs afero.Fs, instanceDir string, update func(*InstanceMetadata)) error {
+	md, err := LoadInstanceMetadata(fs, instanceDir)
+	if err != nil {
+		return err
+	}
+	update(md)
+	return SaveInstanceMetadata(fs, instanceDir, md)
+}
@@ -0,0 +1,96 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package lima_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/runfinch/finch/pkg/lima"
+)
+
+func TestLoadInstanceMetadata(t *testing.T) {
+	t.Parallel()
+
+	instanceDir := filepath.Join("lima", "data", "finch")
+	testCases := []struct {
+		name    string
+		want    *lima.InstanceMetadata
+		wantErr bool
+		mockSvc func(fs afero.Fs)
+	}{
+		{
+			name:    "instance without metadata",
+			want:    &lima.InstanceMetadata{},
+			wantErr: false,
+			mockSvc: func(_ afero.Fs) {},
+		},
+		{
+			name:    "hibernated instance",
+			want:    &lima.InstanceMetadata{Hibernated: true},
+			wantErr: false,
+			mockSvc: func(fs afero.Fs) {
+				data := []byte(`{"hibernated":true}`)
+				require.NoError(t, afero.WriteFile(fs, filepath.Join(instanceDir, "finch-metadata.json"), data, 0o600))
+			},
+		},
+		{
+			name:    "corrupted metadata",
+			want:    nil,
+			wantErr: true,
+			mockSvc: func(fs afero.Fs) {
+				require.NoError(t, afero.WriteFile(fs, filepath.Join(instanceDir, "finch-metadata.json"), []byte("{"), 0o600))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			fs := afero.NewMemMapFs()
+			tc.mockSvc(fs)
+
+			got, err := lima.LoadInstanceMetadata(fs, instanceDir)
+			assert.Equal(t, tc.want, got)
+			assert.Equal(t, tc.wantErr, err != nil)
+		})
+	}
+}
+
+func TestUpdateInstanceMetadata(t *testing.T) {
+	t.Parallel()
+
+	fs := afero.NewMemMapFs()
+	instanceDir := filepath.Join("lima", "data", "finch")
+
+	require.NoError(t, lima.UpdateInstanceMetadata(fs, instanceDir, func(md *lima.InstanceMetadata) {
+		md.Hibernated = true
+	}))
+	got, err := lima.LoadInstanceMetadata(fs, instanceDir)
+	require.NoError(t, err)
+	assert.Equal(t, &lima.InstanceMetadata{Hibernated: true}, got)
+
+	require.NoError(t, lima.UpdateInstanceMetadata(fs, instanceDir, func(md *lima.InstanceMetadata) {
+		md.Hibernated = false
+	}))
+	got, err = lima.LoadInstanceMetadata(fs, instanceDir)
+	require.NoError(t, err)
+	assert.Equal(t, &lima.InstanceMetadata{}, got)
+}
+
+func TestHasInstanceMetadata(t *testing.T) {
+	t.Parallel()
+
+	fs := afero.NewMemMapFs()
+	instanceDir := filepath.Join("lima", "data", "finch")
+
+	assert.False(t, lima.HasInstanceMetadata(fs, instanceDir))
+	require.NoError(t, lima.SaveInstanceMetadata(fs, instanceDir, &lima.InstanceMetadata{}))
+	assert.True(t, lima.HasInstanceMetadata(fs, instanceDir))
+}
@@ -0,0 +1,173 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package lima
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/afero"
+
+	"github.com/runfinch/finch/pkg/command"
+	"github.com/runfinch/finch/pkg/flog"
+)
+
+// SSHConfig tells how to reach the guest of an instance over SSH.
+type SSHConfig struct {
+	Address      string
+	Port         int
+	User         string
+	IdentityFile string
+}
+
+// ResolveSSHConfig returns how to reach the guest of the running instance over SSH, as Lima configured it:
+// the address and the port come from the JSON description of the instance,
+// the user and the identity file from the SSH config file Lima generates for it, which the description points to.
+func ResolveSSHConfig(creator command.NerdctlCmdCreator, fs afero.Fs, instance string) (SSHConfig, error) {
+	out, err := creator.CreateWithoutStdio("ls", "--json", instance).Output()
+	if err != nil {
+		return SSHConfig{}, fmt.Errorf("failed to describe the instance %q: %w", instance, err)
+	}
+	out = bytes.TrimSpace(out)
+	if len(out) == 0 {
+		return SSHConfig{}, fmt.Errorf("the instance %q does not exist", instance)
+	}
+	var desc struct {
+		SSHAddress    string `json:"sshAddress"`
+		SSHLocalPort  int    `json:"sshLocalPort"`
+		SSHConfigFile string `json:"sshConfigFile"`
+	}
+	if err := json.Unmarshal(out, &desc); err != nil {
+		return SSHConfig{}, fmt.Errorf("failed to parse the description of the instance %q: %w", instance, err)
+	}
+	if desc.SSHLocalPort == 0 {
+		return SSHConfig{}, fmt.Errorf("the instance %q has no SSH port, is it running?", instance)
+	}
+
+	cfg := SSHConfig{Address: desc.SSHAddress, Port: desc.SSHLocalPort}
+	if cfg.Address == "" {
+		cfg.Address = "127.0.0.1"
+	}
+	b, err := afero.ReadFile(fs, desc.SSHConfigFile)
+	if err != nil {
+		return SSHConfig{}, fmt.Errorf("failed to read the SSH config file of the instance %q: %w", instance, err)
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), " ")
+		if !ok {
+			continue
+		}
+		value = strings.Trim(strings.TrimSpace(value), `"`)
+		// Like ssh, the first value of a keyword wins, Lima lists the identity file of the instance first.
+		switch strings.ToLower(key) {
+		case "user":
+			if cfg.User == "" {
+				cfg.User = value
+			}
+		case "identityfile":
+			if cfg.IdentityFile == "" {
+				cfg.IdentityFile = value
+			}
+		}
+	}
+	if cfg.User == "" {
+		return SSHConfig{}, errors.New("no user found in the SSH config file of the instance")
+	}
+	return cfg, nil
+}
+
+// sshCmdCreator runs the commands meant for the guest of an instance with ssh instead of `limactl shell`,
+// the other commands are left to the wrapped creator.
+type sshCmdCreator struct {
+	command.NerdctlCmdCreator
+	ecc       command.Creator
+	fs        afero.Fs
+	logger    flog.Logger
+	instance  string
+	overrides SSHConfig
+	// resolved is the SSH config of the guest, once it has been resolved.
+	resolved *SSHConfig
+}
+
+var _ command.NerdctlCmdCreator = (*sshCmdCreator)(nil)
+
+// NewSSHCmdCreator returns a creator that runs `shell` commands for the instance with ssh, where the non-zero fields
+// of overrides take precedence over the SSH config Lima generated. The SSH config is only resolved once the first
+// of these commands is created, as the instance may not be running before then. If it can't be resolved,
+// the command goes through `limactl shell` as usual.
+func NewSSHCmdCreator(
+	creator command.NerdctlCmdCreator,
+	ecc command.Creator,
+	fs afero.Fs,
+	logger flog.Logger,
+	instance string,
+	overrides SSHConfig,
+) command.NerdctlCmdCreator {
+	return &sshCmdCreator{
+		NerdctlCmdCreator: creator,
+		ecc:               ecc,
+		fs:                fs,
+		logger:            logger,
+		instance:          instance,
+		overrides:         overrides,
+	}
+}
+
+func (s *sshCmdCreator) CreateWithoutStdio(args ...string) command.Command {
+	if len(args) < 2 || args[0] != "shell" || args[1] != s.instance {
+		return s.NerdctlCmdCreator.CreateWithoutStdio(args...)
+	}
+	cfg, err := s.sshConfig()
+	if err != nil {
+		s.logger.Warnf("Could not resolve the SSH config of the instance %q, reaching it through Lima instead: %v", s.instance, err)
+		return s.NerdctlCmdCreator.CreateWithoutStdio(args...)
+	}
+	return s.ecc.Create("ssh", SSHArgs(cfg, args[2:])...)
+}
+
+func (s *sshCmdCreator) sshConfig() (SSHConfig, error) {
+	if s.resolved != nil {
+		return *s.resolved, nil
+	}
+	cfg, err := ResolveSSHConfig(s.NerdctlCmdCreator, s.fs, s.instance)
+	if err != nil {
+		return SSHConfig{}, err
+	}
+	if s.overrides.Port != 0 {
+		cfg.Port = s.overrides.Port
+	}
+	if s.overrides.IdentityFile != "" {
+		cfg.IdentityFile = s.overrides.IdentityFile
+	}
+	s.logger.Debugf("Reaching the instance %q over SSH as %s@%s:%d", s.instance, cfg.User, cfg.Address, cfg.Port)
+	s.resolved = &cfg
+	return cfg, nil
+}
+
+// SSHArgs returns the arguments of ssh to run the command in the guest described by cfg.
+// The guest is only reachable from the host, and its host key changes with each instance, so it isn't checked.
+func SSHArgs(cfg SSHConfig, cmd []string) []string {
+	args := []string{
+		"-o", "BatchMode=yes",
+		"-o", "StrictHostKeyChecking=no",
+		"-o", "UserKnownHostsFile=" + os.DevNull,
+		"-o", "LogLevel=ERROR",
+		"-p", strconv.Itoa(cfg.Port),
+	}
+	if cfg.IdentityFile != "" {
+		args = append(args, "-o", "IdentitiesOnly=yes", "-i", cfg.IdentityFile)
+	}
+	args = append(args, cfg.User+"@"+cfg.Address, "--")
+	// ssh joins the arguments of the command with spaces and has the remote shell split them again.
+	quoted := make([]string, 0, len(cmd))
+	for _, arg := range cmd {
+		quoted = append(quoted, "'"+strings.ReplaceAll(arg, "'", `'\''`)+"'")
+	}
+	return append(args, strings.Join(quoted, " "))
+}
@@ -0,0 +1,178 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package lima_test
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/runfinch/finch/pkg/lima"
+	"github.com/runfinch/finch/pkg/mocks"
+)
+
+const mockSSHConfigFile = `Host lima-finch
+  IdentityFile "/home/user/.finch/lima/data/_config/user"
+  IdentityFile "/home/user/.ssh/id_ed25519"
+  StrictHostKeyChecking no
+  User user
+  Hostname 127.0.0.1
+  Port 60022
+`
+
+func TestResolveSSHConfig(t *testing.T) {
+	t.Parallel()
+
+	const configPath = "/lima/finch/ssh.config"
+	description := `{"name":"finch","status":"Running","sshLocalPort":60022,"sshConfigFile":"/lima/finch/ssh.config"}`
+
+	testCases := []struct {
+		name    string
+		out     string
+		outErr  error
+		config  string
+		want    lima.SSHConfig
+		wantErr error
+	}{
+		{
+			name:   "should resolve the SSH config from the instance description and the SSH config file",
+			out:    description + "\n",
+			config: mockSSHConfigFile,
+			want: lima.SSHConfig{
+				Address:      "127.0.0.1",
+				Port:         60022,
+				User:         "user",
+				IdentityFile: "/home/user/.finch/lima/data/_config/user",
+			},
+		},
+		{
+			name:    "should fail if the instance doesn't exist",
+			out:     "",
+			wantErr: errors.New(`the instance "finch" does not exist`),
+		},
+		{
+			name:    "should fail if the instance isn't running",
+			out:     `{"name":"finch","status":"Stopped","sshLocalPort":0}`,
+			wantErr: errors.New(`the instance "finch" has no SSH port, is it running?`),
+		},
+		{
+			name:    "should fail if the SSH config file has no user",
+			out:     description,
+			config:  "Host lima-finch\n  Port 60022\n",
+			wantErr: errors.New("no user found in the SSH config file of the instance"),
+		},
+		{
+			name:    "should fail if the instance can't be described",
+			outErr:  errors.New("exit status 1"),
+			wantErr: errors.New(`failed to describe the instance "finch": exit status 1`),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			creator := mocks.NewNerdctlCmdCreator(ctrl)
+			cmd := mocks.NewCommand(ctrl)
+			creator.EXPECT().CreateWithoutStdio("ls", "--json", "finch").Return(cmd)
+			cmd.EXPECT().Output().Return([]byte(tc.out), tc.outErr)
+			fs := afero.NewMemMapFs()
+			if tc.config != "" {
+				require.NoError(t, afero.WriteFile(fs, configPath, []byte(tc.config), 0o600))
+			}
+
+			cfg, err := lima.ResolveSSHConfig(creator, fs, "finch")
+			if tc.wantErr != nil {
+				assert.EqualError(t, err, tc.wantErr.Error())
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, cfg)
+		})
+	}
+}
+
+func TestSSHArgs(t *testing.T) {
+	t.Parallel()
+
+	cfg := lima.SSHConfig{Address: "127.0.0.1", Port: 2222, User: "user", IdentityFile: "/keys/finch"}
+	assert.Equal(t, []string{
+		"-o", "BatchMode=yes",
+		"-o", "StrictHostKeyChecking=no",
+		"-o", "UserKnownHostsFile=" + os.DevNull,
+		"-o", "LogLevel=ERROR",
+		"-p", "2222",
+		"-o", "IdentitiesOnly=yes", "-i", "/keys/finch",
+		"user@127.0.0.1", "--",
+		`'sudo' 'sh' '-c' 'echo '\''hello world'\'''`,
+	}, lima.SSHArgs(cfg, []string{"sudo", "sh", "-c", "echo 'hello world'"}))
+}
+
+func TestSSHCmdCreator_CreateWithoutStdio(t *testing.T) {
+	t.Parallel()
+
+	const configPath = "/lima/finch/ssh.config"
+	description := []byte(`{"name":"finch","sshLocalPort":60022,"sshConfigFile":"/lima/finch/ssh.config"}`)
+
+	t.Run("should run the guest commands of the instance with ssh and the overrides", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		ncc := mocks.NewNerdctlCmdCreator(ctrl)
+		ecc := mocks.NewCommandCreator(ctrl)
+		logger := mocks.NewLogger(ctrl)
+		fs := afero.NewMemMapFs()
+		require.NoError(t, afero.WriteFile(fs, configPath, []byte(mockSSHConfigFile), 0o600))
+
+		lsCmd := mocks.NewCommand(ctrl)
+		ncc.EXPECT().CreateWithoutStdio("ls", "--json", "finch").Return(lsCmd)
+		lsCmd.EXPECT().Output().Return(description, nil)
+		logger.EXPECT().Debugf("Reaching the instance %q over SSH as %s@%s:%d", "finch", "user", "127.0.0.1", 2222)
+		wantCfg := lima.SSHConfig{
+			Address:      "127.0.0.1",
+			Port:         2222,
+			User:         "user",
+			IdentityFile: "/home/user/.finch/lima/data/_config/user",
+		}
+		sshCmd := mocks.NewCommand(ctrl)
+		var sshArgs []any
+		for _, arg := range lima.SSHArgs(wantCfg, []string{"sudo", "true"}) {
+			sshArgs = append(sshArgs, arg)
+		}
+		ecc.EXPECT().Create("ssh", sshArgs...).Return(sshCmd).Times(2)
+		otherCmd := mocks.NewCommand(ctrl)
+		ncc.EXPECT().CreateWithoutStdio("shell", "other", "true").Return(otherCmd)
+
+		creator := lima.NewSSHCmdCreator(ncc, ecc, fs, logger, "finch", lima.SSHConfig{Port: 2222})
+		// The SSH config is only resolved once.
+		assert.Equal(t, sshCmd, creator.CreateWithoutStdio("shell", "finch", "sudo", "true"))
+		assert.Equal(t, sshCmd, creator.CreateWithoutStdio("shell", "finch", "sudo", "true"))
+		assert.Equal(t, otherCmd, creator.CreateWithoutStdio("shell", "other", "true"))
+	})
+
+	t.Run("should fall back to limactl shell if the SSH config can't be resolved", func(t *testing.T) {
+		t.Parallel()
+
+		ctrl := gomock.NewController(t)
+		ncc := mocks.NewNerdctlCmdCreator(ctrl)
+		logger := mocks.NewLogger(ctrl)
+
+		lsCmd := mocks.NewCommand(ctrl)
+		ncc.EXPECT().CreateWithoutStdio("ls", "--json", "finch").Return(lsCmd)
+		lsCmd.EXPECT().Output().Return([]byte(`{"name":"finch","sshLocalPort":0}`), nil)
+		logger.EXPECT().Warnf("Could not resolve the SSH config of the instance %q, reaching it through Lima instead: %v",
+			"finch", errors.New(`the instance "finch" has no SSH port, is it running?`))
+		shellCmd := mocks.NewCommand(ctrl)
+		ncc.EXPECT().CreateWithoutStdio("shell", "finch", "true").Return(shellCmd)
+
+		creator := lima.NewSSHCmdCreator(ncc, nil, afero.NewMemMapFs(), logger, "finch", lima.SSHConfig{Port: 2222})
+		assert.Equal(t, shellCmd, creator.CreateWithoutStdio("shell", "finch", "true"))
+	})
+}
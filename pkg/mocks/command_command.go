@@ -58,6 +58,30 @@ func (mr *CommandMockRecorder) CombinedOutput() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CombinedOutput", reflect.TypeOf((*Command)(nil).CombinedOutput))
 }
 
+// Detach mocks base method.
+func (m *Command) Detach() {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Detach")
+}
+
+// Detach indicates an expected call of Detach.
+func (mr *CommandMockRecorder) Detach() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Detach", reflect.TypeOf((*Command)(nil).Detach))
+}
+
+// Kill mocks base method.
+func (m *Command) Kill() {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Kill")
+}
+
+// Kill indicates an expected call of Kill.
+func (mr *CommandMockRecorder) Kill() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Kill", reflect.TypeOf((*Command)(nil).Kill))
+}
+
 // Output mocks base method.
 func (m *Command) Output() ([]byte, error) {
 	m.ctrl.T.Helper()
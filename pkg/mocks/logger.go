@@ -198,6 +198,20 @@ func (mr *LoggerMockRecorder) SetLevel(level any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetLevel", reflect.TypeOf((*Logger)(nil).SetLevel), level)
 }
 
+// StartProgress mocks base method.
+func (m *Logger) StartProgress(label string) func() {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "StartProgress", label)
+	ret0, _ := ret[0].(func())
+	return ret0
+}
+
+// StartProgress indicates an expected call of StartProgress.
+func (mr *LoggerMockRecorder) StartProgress(label any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StartProgress", reflect.TypeOf((*Logger)(nil).StartProgress), label)
+}
+
 // Warnf mocks base method.
 func (m *Logger) Warnf(format string, args ...any) {
 	m.ctrl.T.Helper()
@@ -0,0 +1,71 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package mocks
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/runfinch/finch/pkg/command"
+)
+
+// NerdctlCmdCreatorRecorder is a NerdctlCmdCreator that records the arguments of the commands created
+// with CreateWithoutStdio, in the order they are created, for tests to assert the order concisely
+// instead of with gomock.InOrder. The commands are still created by the wrapped mock, whose expectations apply.
+type NerdctlCmdCreatorRecorder struct {
+	*NerdctlCmdCreator
+
+	mu    sync.Mutex
+	calls [][]string
+}
+
+var _ command.NerdctlCmdCreator = (*NerdctlCmdCreatorRecorder)(nil)
+
+// NewNerdctlCmdCreatorRecorder records the commands created with CreateWithoutStdio by the mock.
+func NewNerdctlCmdCreatorRecorder(mock *NerdctlCmdCreator) *NerdctlCmdCreatorRecorder {
+	return &NerdctlCmdCreatorRecorder{NerdctlCmdCreator: mock}
+}
+
+// CreateWithoutStdio records the arguments of the command before the mock creates it.
+func (r *NerdctlCmdCreatorRecorder) CreateWithoutStdio(args ...string) command.Command {
+	r.mu.Lock()
+	r.calls = append(r.calls, slices.Clone(args))
+	r.mu.Unlock()
+	return r.NerdctlCmdCreator.CreateWithoutStdio(args...)
+}
+
+// Calls returns the arguments of the commands created with CreateWithoutStdio so far, in order.
+func (r *NerdctlCmdCreatorRecorder) Calls() [][]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.calls)
+}
+
+// InOrder checks that the commands with the given arguments were created in this order,
+// other commands may have been created in between. Each one matches its first creation after the previous one.
+func (r *NerdctlCmdCreatorRecorder) InOrder(want ...[]string) error {
+	calls := r.Calls()
+	next := 0
+	for _, args := range want {
+		i := slices.IndexFunc(calls[next:], func(call []string) bool { return slices.Equal(call, args) })
+		if i < 0 {
+			if slices.ContainsFunc(calls, func(call []string) bool { return slices.Equal(call, args) }) {
+				return fmt.Errorf("%q was created out of order, the commands were created in this order:\n%s", args, formatCalls(calls))
+			}
+			return fmt.Errorf("%q was never created, the commands were created in this order:\n%s", args, formatCalls(calls))
+		}
+		next += i + 1
+	}
+	return nil
+}
+
+func formatCalls(calls [][]string) string {
+	lines := make([]string, 0, len(calls))
+	for _, call := range calls {
+		lines = append(lines, fmt.Sprintf("%q", call))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,75 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package mocks
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestNerdctlCmdCreatorRecorder_InOrder(t *testing.T) {
+	t.Parallel()
+
+	sync := []string{"shell", "finch", "sudo", "sync"}
+	unmount := []string{"shell", "finch", "sudo", "umount", "/mnt/lima-finch"}
+	stop := []string{"stop", "finch"}
+
+	testCases := []struct {
+		name    string
+		want    [][]string
+		wantErr string
+	}{
+		{
+			name: "should accept the commands created in order",
+			want: [][]string{sync, stop},
+		},
+		{
+			name: "should accept no commands",
+			want: nil,
+		},
+		{
+			name: "should reject the commands created out of order",
+			want: [][]string{stop, unmount},
+			wantErr: `["shell" "finch" "sudo" "umount" "/mnt/lima-finch"] was created out of order, ` +
+				"the commands were created in this order:\n" +
+				`["shell" "finch" "sudo" "sync"]` + "\n" +
+				`["shell" "finch" "sudo" "umount" "/mnt/lima-finch"]` + "\n" +
+				`["stop" "finch"]`,
+		},
+		{
+			name: "should reject a command that was never created",
+			want: [][]string{{"stop", "--force", "finch"}},
+			wantErr: `["stop" "--force" "finch"] was never created, the commands were created in this order:` + "\n" +
+				`["shell" "finch" "sudo" "sync"]` + "\n" +
+				`["shell" "finch" "sudo" "umount" "/mnt/lima-finch"]` + "\n" +
+				`["stop" "finch"]`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+			ncc := NewNerdctlCmdCreator(ctrl)
+			ncc.EXPECT().CreateWithoutStdio(gomock.Any()).Return(NewCommand(ctrl)).Times(3)
+
+			recorder := NewNerdctlCmdCreatorRecorder(ncc)
+			for _, args := range [][]string{sync, unmount, stop} {
+				require.NotNil(t, recorder.CreateWithoutStdio(args...))
+			}
+			assert.Equal(t, [][]string{sync, unmount, stop}, recorder.Calls())
+
+			err := recorder.InOrder(tc.want...)
+			if tc.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tc.wantErr)
+		})
+	}
+}
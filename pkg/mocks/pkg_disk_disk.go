@@ -13,6 +13,7 @@
 package mocks
 
 import (
+	fs "io/fs"
 	os "os"
 	reflect "reflect"
 	time "time"
@@ -45,6 +46,48 @@ func (m *UserDataDiskManager) EXPECT() *UserDataDiskManagerMockRecorder {
 	return m.recorder
 }
 
+// AttachUserDataDisk mocks base method.
+func (m *UserDataDiskManager) AttachUserDataDisk() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AttachUserDataDisk")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AttachUserDataDisk indicates an expected call of AttachUserDataDisk.
+func (mr *UserDataDiskManagerMockRecorder) AttachUserDataDisk() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AttachUserDataDisk", reflect.TypeOf((*UserDataDiskManager)(nil).AttachUserDataDisk))
+}
+
+// DetachDisk mocks base method.
+func (m *UserDataDiskManager) DetachDisk(diskPath string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DetachDisk", diskPath)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DetachDisk indicates an expected call of DetachDisk.
+func (mr *UserDataDiskManagerMockRecorder) DetachDisk(diskPath any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DetachDisk", reflect.TypeOf((*UserDataDiskManager)(nil).DetachDisk), diskPath)
+}
+
+// DetachOrder mocks base method.
+func (m *UserDataDiskManager) DetachOrder() []string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DetachOrder")
+	ret0, _ := ret[0].([]string)
+	return ret0
+}
+
+// DetachOrder indicates an expected call of DetachOrder.
+func (mr *UserDataDiskManagerMockRecorder) DetachOrder() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DetachOrder", reflect.TypeOf((*UserDataDiskManager)(nil).DetachOrder))
+}
+
 // DetachUserDataDisk mocks base method.
 func (m *UserDataDiskManager) DetachUserDataDisk() error {
 	m.ctrl.T.Helper()
@@ -73,6 +116,110 @@ func (mr *UserDataDiskManagerMockRecorder) EnsureUserDataDisk() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EnsureUserDataDisk", reflect.TypeOf((*UserDataDiskManager)(nil).EnsureUserDataDisk))
 }
 
+// IndependentDisks mocks base method.
+func (m *UserDataDiskManager) IndependentDisks() []string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "IndependentDisks")
+	ret0, _ := ret[0].([]string)
+	return ret0
+}
+
+// IndependentDisks indicates an expected call of IndependentDisks.
+func (mr *UserDataDiskManagerMockRecorder) IndependentDisks() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "IndependentDisks", reflect.TypeOf((*UserDataDiskManager)(nil).IndependentDisks))
+}
+
+// RemoveUserDataDisk mocks base method.
+func (m *UserDataDiskManager) RemoveUserDataDisk() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RemoveUserDataDisk")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RemoveUserDataDisk indicates an expected call of RemoveUserDataDisk.
+func (mr *UserDataDiskManagerMockRecorder) RemoveUserDataDisk() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveUserDataDisk", reflect.TypeOf((*UserDataDiskManager)(nil).RemoveUserDataDisk))
+}
+
+// RotateUserDataDisk mocks base method.
+func (m *UserDataDiskManager) RotateUserDataDisk(archiveDir string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RotateUserDataDisk", archiveDir)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RotateUserDataDisk indicates an expected call of RotateUserDataDisk.
+func (mr *UserDataDiskManagerMockRecorder) RotateUserDataDisk(archiveDir any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RotateUserDataDisk", reflect.TypeOf((*UserDataDiskManager)(nil).RotateUserDataDisk), archiveDir)
+}
+
+// SnapshotUserDataDisk mocks base method.
+func (m *UserDataDiskManager) SnapshotUserDataDisk(name string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SnapshotUserDataDisk", name)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SnapshotUserDataDisk indicates an expected call of SnapshotUserDataDisk.
+func (mr *UserDataDiskManagerMockRecorder) SnapshotUserDataDisk(name any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SnapshotUserDataDisk", reflect.TypeOf((*UserDataDiskManager)(nil).SnapshotUserDataDisk), name)
+}
+
+// UserDataDiskAttached mocks base method.
+func (m *UserDataDiskManager) UserDataDiskAttached() (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UserDataDiskAttached")
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UserDataDiskAttached indicates an expected call of UserDataDiskAttached.
+func (mr *UserDataDiskManagerMockRecorder) UserDataDiskAttached() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UserDataDiskAttached", reflect.TypeOf((*UserDataDiskManager)(nil).UserDataDiskAttached))
+}
+
+// UserDataDiskSnapshots mocks base method.
+func (m *UserDataDiskManager) UserDataDiskSnapshots() ([]fs.FileInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UserDataDiskSnapshots")
+	ret0, _ := ret[0].([]fs.FileInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UserDataDiskSnapshots indicates an expected call of UserDataDiskSnapshots.
+func (mr *UserDataDiskManagerMockRecorder) UserDataDiskSnapshots() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UserDataDiskSnapshots", reflect.TypeOf((*UserDataDiskManager)(nil).UserDataDiskSnapshots))
+}
+
+// UserDataDiskSpace mocks base method.
+func (m *UserDataDiskManager) UserDataDiskSpace() (uint64, uint64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UserDataDiskSpace")
+	ret0, _ := ret[0].(uint64)
+	ret1, _ := ret[1].(uint64)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// UserDataDiskSpace indicates an expected call of UserDataDiskSpace.
+func (mr *UserDataDiskManagerMockRecorder) UserDataDiskSpace() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UserDataDiskSpace", reflect.TypeOf((*UserDataDiskManager)(nil).UserDataDiskSpace))
+}
+
 // MockdiskFS is a mock of diskFS interface.
 type MockdiskFS struct {
 	ctrl     *gomock.Controller
@@ -15,6 +15,7 @@ package mocks
 import (
 	reflect "reflect"
 
+	fmemory "github.com/runfinch/finch/pkg/fmemory"
 	gomock "go.uber.org/mock/gomock"
 )
 
@@ -42,6 +43,52 @@ func (m *Memory) EXPECT() *MemoryMockRecorder {
 	return m.recorder
 }
 
+// AvailableMemory mocks base method.
+func (m *Memory) AvailableMemory() (uint64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AvailableMemory")
+	ret0, _ := ret[0].(uint64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AvailableMemory indicates an expected call of AvailableMemory.
+func (mr *MemoryMockRecorder) AvailableMemory() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AvailableMemory", reflect.TypeOf((*Memory)(nil).AvailableMemory))
+}
+
+// PressureLevel mocks base method.
+func (m *Memory) PressureLevel() (fmemory.PressureLevel, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PressureLevel")
+	ret0, _ := ret[0].(fmemory.PressureLevel)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PressureLevel indicates an expected call of PressureLevel.
+func (mr *MemoryMockRecorder) PressureLevel() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PressureLevel", reflect.TypeOf((*Memory)(nil).PressureLevel))
+}
+
+// SwapUsage mocks base method.
+func (m *Memory) SwapUsage() (uint64, uint64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SwapUsage")
+	ret0, _ := ret[0].(uint64)
+	ret1, _ := ret[1].(uint64)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// SwapUsage indicates an expected call of SwapUsage.
+func (mr *MemoryMockRecorder) SwapUsage() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SwapUsage", reflect.TypeOf((*Memory)(nil).SwapUsage))
+}
+
 // TotalMemory mocks base method.
 func (m *Memory) TotalMemory() uint64 {
 	m.ctrl.T.Helper()
@@ -56,6 +56,20 @@ func (mr *BundleConfigMockRecorder) ConfigFiles() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ConfigFiles", reflect.TypeOf((*BundleConfig)(nil).ConfigFiles))
 }
 
+// InstanceName mocks base method.
+func (m *BundleConfig) InstanceName() string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "InstanceName")
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+// InstanceName indicates an expected call of InstanceName.
+func (mr *BundleConfigMockRecorder) InstanceName() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InstanceName", reflect.TypeOf((*BundleConfig)(nil).InstanceName))
+}
+
 // LogFiles mocks base method.
 func (m *BundleConfig) LogFiles() []string {
 	m.ctrl.T.Helper()
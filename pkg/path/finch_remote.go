@@ -23,6 +23,37 @@ func (Finch) ConfigFilePath(rootDir string) string {
 	return filepath.Join(rootDir, ".finch", "finch.yaml")
 }
 
+// DiagnosticsDir returns the path to the directory where diagnostics collected by Finch are saved.
+func (Finch) DiagnosticsDir(rootDir string) string {
+	return filepath.Join(rootDir, ".finch", "diagnostics")
+}
+
+// AuditLogPath returns the path to the audit log of the changes of state of the VM.
+func (Finch) AuditLogPath(rootDir string) string {
+	return filepath.Join(rootDir, ".finch", "audit.log")
+}
+
+// TelemetryBatchPath returns the path to the batch of telemetry events that weren't sent yet.
+func (Finch) TelemetryBatchPath(rootDir string) string {
+	return filepath.Join(rootDir, ".finch", "telemetry.jsonl")
+}
+
+// ContextsFilePath returns the path to the file of the contexts selecting the instance the VM commands operate on.
+func (Finch) ContextsFilePath(rootDir string) string {
+	return filepath.Join(rootDir, ".finch", "contexts.yaml")
+}
+
+// UserDataDiskArchiveDir returns the path to the directory the user data disks rotated out by
+// `finch vm stop --rotate-disk` are archived in.
+func (Finch) UserDataDiskArchiveDir(rootDir string) string {
+	return filepath.Join(rootDir, ".finch", "disk-archive")
+}
+
+// UserDataDiskSnapshotDir returns the path to the directory the snapshots of the user data disk are kept in.
+func (Finch) UserDataDiskSnapshotDir(rootDir string) string {
+	return filepath.Join(rootDir, ".finch", "disk-snapshots")
+}
+
 // UserDataDiskPath returns the path to the permanent storage location of the Finch
 // user data disk.
 func (w Finch) UserDataDiskPath(rootDir string) string {
@@ -40,7 +71,12 @@ func (w Finch) LimaHomePath() string {
 
 // LimaInstancePath returns the path to the Lima instance of the Finch VM.
 func (w Finch) LimaInstancePath() string {
-	return filepath.Join(string(w), "lima", "data", "finch")
+	return w.LimaInstancePathOf("finch")
+}
+
+// LimaInstancePathOf returns the path to the named Lima instance, e.g. the Finch VM with an instance prefix.
+func (w Finch) LimaInstancePathOf(instance string) string {
+	return filepath.Join(w.LimaHomePath(), instance)
 }
 
 // LimactlPath returns the limactl path.
@@ -27,6 +27,48 @@ func TestFinch_ConfigFilePath(t *testing.T) {
 	assert.Equal(t, res, filepath.Join("homeDir", ".finch", "finch.yaml"))
 }
 
+func TestFinch_DiagnosticsDir(t *testing.T) {
+	t.Parallel()
+
+	res := mockFinch.DiagnosticsDir("homeDir")
+	assert.Equal(t, res, filepath.Join("homeDir", ".finch", "diagnostics"))
+}
+
+func TestFinch_AuditLogPath(t *testing.T) {
+	t.Parallel()
+
+	res := mockFinch.AuditLogPath("homeDir")
+	assert.Equal(t, res, filepath.Join("homeDir", ".finch", "audit.log"))
+}
+
+func TestFinch_TelemetryBatchPath(t *testing.T) {
+	t.Parallel()
+
+	res := mockFinch.TelemetryBatchPath("homeDir")
+	assert.Equal(t, res, filepath.Join("homeDir", ".finch", "telemetry.jsonl"))
+}
+
+func TestFinch_UserDataDiskArchiveDir(t *testing.T) {
+	t.Parallel()
+
+	res := mockFinch.UserDataDiskArchiveDir("homeDir")
+	assert.Equal(t, res, filepath.Join("homeDir", ".finch", "disk-archive"))
+}
+
+func TestFinch_ContextsFilePath(t *testing.T) {
+	t.Parallel()
+
+	res := mockFinch.ContextsFilePath("homeDir")
+	assert.Equal(t, res, filepath.Join("homeDir", ".finch", "contexts.yaml"))
+}
+
+func TestFinch_UserDataDiskSnapshotDir(t *testing.T) {
+	t.Parallel()
+
+	res := mockFinch.UserDataDiskSnapshotDir("homeDir")
+	assert.Equal(t, res, filepath.Join("homeDir", ".finch", "disk-snapshots"))
+}
+
 func TestFinch_UserDataDiskPath(t *testing.T) {
 	t.Parallel()
 
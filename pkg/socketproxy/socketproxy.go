@@ -0,0 +1,218 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package socketproxy keeps the host socket of a stopped instance accepting connections, so that Docker-compatible
+// clients connecting to it while the VM is stopped are held instead of refused, and are served as soon as the next
+// start forwards the socket again.
+package socketproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/runfinch/finch/pkg/flog"
+)
+
+const (
+	// defaultPollInterval is how often the proxy checks whether the socket was replaced.
+	defaultPollInterval = 500 * time.Millisecond
+	// defaultHandOverTimeout bounds how long the held connections wait for the socket that replaced the proxy
+	// to accept them. Lima removes the socket before forwarding it again, so it doesn't exist for a moment.
+	defaultHandOverTimeout = 30 * time.Second
+)
+
+// Proxy listens on a host socket while nothing else serves it.
+type Proxy struct {
+	path            string
+	logger          flog.Logger
+	pollInterval    time.Duration
+	handOverTimeout time.Duration
+
+	mu   sync.Mutex
+	held []*net.UnixConn
+}
+
+// New creates a proxy for the host socket at path.
+func New(path string, logger flog.Logger) *Proxy {
+	return &Proxy{
+		path:            path,
+		logger:          logger,
+		pollInterval:    defaultPollInterval,
+		handOverTimeout: defaultHandOverTimeout,
+	}
+}
+
+// Serve listens on the socket and holds the connections it accepts until the socket is replaced, i.e., until the
+// next start of the instance forwards it again. It then stops listening, hands the held connections over to the new
+// socket and returns once they are all closed. If the socket is removed and nothing replaces it, e.g. because
+// the instance was removed, the held connections are closed. Cancelling ctx closes the held connections and removes
+// the socket.
+//
+// A stale socket left at the path is replaced, a socket something still serves is an error.
+func (p *Proxy) Serve(ctx context.Context) error {
+	l, err := p.listen()
+	if err != nil {
+		return err
+	}
+	// The socket file belongs to whatever replaces the proxy by the time it stops listening.
+	l.SetUnlinkOnClose(false)
+	info, err := os.Stat(p.path)
+	if err != nil {
+		_ = l.Close()
+		return fmt.Errorf("failed to stat the socket %q: %w", p.path, err)
+	}
+	p.logger.Infof("Holding the connections to %q until the instance is started again", p.path)
+
+	var accepting sync.WaitGroup
+	accepting.Add(1)
+	go func() {
+		defer accepting.Done()
+		p.accept(l)
+	}()
+
+	replaced := p.waitForReplacement(ctx, info)
+	_ = l.Close()
+	accepting.Wait()
+	if !replaced {
+		if current, err := os.Stat(p.path); err == nil && os.SameFile(info, current) {
+			if err := os.Remove(p.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				p.logger.Warnf("Could not remove the socket %q: %v", p.path, err)
+			}
+		}
+		p.closeHeld()
+		return ctx.Err()
+	}
+	p.handOver()
+	return nil
+}
+
+// listen listens on the socket path, removing the socket left there if nothing serves it.
+func (p *Proxy) listen() (*net.UnixListener, error) {
+	addr := &net.UnixAddr{Name: p.path, Net: "unix"}
+	if _, err := os.Lstat(p.path); err == nil {
+		if conn, err := net.DialUnix("unix", nil, addr); err == nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("the socket %q is already served", p.path)
+		}
+		if err := os.Remove(p.path); err != nil {
+			return nil, fmt.Errorf("failed to remove the stale socket %q: %w", p.path, err)
+		}
+	}
+	l, err := net.ListenUnix("unix", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on the socket %q: %w", p.path, err)
+	}
+	return l, nil
+}
+
+func (p *Proxy) accept(l *net.UnixListener) {
+	for {
+		conn, err := l.AcceptUnix()
+		if err != nil {
+			return
+		}
+		p.logger.Debugln("Holding a connection until the instance is started again")
+		p.mu.Lock()
+		p.held = append(p.held, conn)
+		p.mu.Unlock()
+	}
+}
+
+// waitForReplacement polls the socket path until it no longer refers to the socket the proxy listens on,
+// and returns true, or until ctx is done, and returns false.
+func (p *Proxy) waitForReplacement(ctx context.Context, info fs.FileInfo) bool {
+	ticker := time.NewTicker(p.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+			current, err := os.Stat(p.path)
+			if err != nil || !os.SameFile(info, current) {
+				p.logger.Debugf("The socket %q was replaced", p.path)
+				return true
+			}
+		}
+	}
+}
+
+// handOver connects each held connection to the socket that replaced the proxy and pipes them together.
+func (p *Proxy) handOver() {
+	p.mu.Lock()
+	held := p.held
+	p.held = nil
+	p.mu.Unlock()
+	if len(held) == 0 {
+		return
+	}
+
+	p.logger.Infof("Handing %d connection(s) over to %q", len(held), p.path)
+	var piping sync.WaitGroup
+	deadline := time.Now().Add(p.handOverTimeout)
+	for _, conn := range held {
+		upstream, err := p.dialUntil(deadline)
+		if err != nil {
+			p.logger.Warnf("Could not hand a connection over to %q: %v", p.path, err)
+			_ = conn.Close()
+			continue
+		}
+		piping.Add(1)
+		go func() {
+			defer piping.Done()
+			pipe(conn, upstream)
+		}()
+	}
+	piping.Wait()
+}
+
+// dialUntil dials the socket until it accepts the connection or the deadline passes.
+func (p *Proxy) dialUntil(deadline time.Time) (*net.UnixConn, error) {
+	addr := &net.UnixAddr{Name: p.path, Net: "unix"}
+	for {
+		conn, err := net.DialUnix("unix", nil, addr)
+		if err == nil {
+			return conn, nil
+		}
+		if time.Now().Add(p.pollInterval).After(deadline) {
+			return nil, err
+		}
+		time.Sleep(p.pollInterval)
+	}
+}
+
+func (p *Proxy) closeHeld() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, conn := range p.held {
+		_ = conn.Close()
+	}
+	p.held = nil
+}
+
+// pipe copies the data between the two connections in both directions until both are done, half closing each side
+// as its peer stops writing, so that requests and responses of any length go through.
+func pipe(a, b *net.UnixConn) {
+	defer a.Close() //nolint:errcheck // the copies report nothing more to be done on the connections
+	defer b.Close() //nolint:errcheck // the copies report nothing more to be done on the connections
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(b, a)
+		_ = b.CloseWrite()
+	}()
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(a, b)
+		_ = a.CloseWrite()
+	}()
+	wg.Wait()
+}
@@ -0,0 +1,185 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package socketproxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/runfinch/finch/pkg/mocks"
+)
+
+// socketPath returns a socket path short enough for the limit of the socket addresses, which t.TempDir can exceed.
+func socketPath(t *testing.T) string {
+	dir, err := os.MkdirTemp("", "sp")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	return filepath.Join(dir, "finch.sock")
+}
+
+func newTestProxy(t *testing.T, path string) *Proxy {
+	ctrl := gomock.NewController(t)
+	logger := mocks.NewLogger(ctrl)
+	logger.EXPECT().Infof(gomock.Any(), gomock.Any()).AnyTimes()
+	logger.EXPECT().Debugf(gomock.Any(), gomock.Any()).AnyTimes()
+	logger.EXPECT().Debugln(gomock.Any()).AnyTimes()
+	logger.EXPECT().Warnf(gomock.Any(), gomock.Any()).AnyTimes()
+	p := New(path, logger)
+	p.pollInterval = 10 * time.Millisecond
+	p.handOverTimeout = 200 * time.Millisecond
+	return p
+}
+
+// serve starts the proxy and waits for it to accept connections.
+func serve(ctx context.Context, t *testing.T, p *Proxy) <-chan error {
+	served := make(chan error, 1)
+	go func() { served <- p.Serve(ctx) }()
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("unix", p.path)
+		if err != nil {
+			return false
+		}
+		// The proxy holds this connection too, closing it makes the hand over skip it.
+		_ = conn.Close()
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+	return served
+}
+
+// waitForHeld waits for the proxy to hold n connections, the connections it didn't accept yet are reset when it stops
+// listening.
+func waitForHeld(t *testing.T, p *Proxy, n int) {
+	require.Eventually(t, func() bool {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return len(p.held) == n
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestProxy_Serve(t *testing.T) {
+	t.Parallel()
+
+	t.Run("hands the held connections over to the socket that replaces it", func(t *testing.T) {
+		t.Parallel()
+
+		path := socketPath(t)
+		p := newTestProxy(t, path)
+		served := serve(context.Background(), t, p)
+
+		client, err := net.Dial("unix", path)
+		require.NoError(t, err)
+		_, err = client.Write([]byte("ping"))
+		require.NoError(t, err)
+		waitForHeld(t, p, 2)
+
+		// This is what the next start does: Lima removes the socket and forwards a new one at the same path.
+		require.NoError(t, os.Remove(path))
+		l, err := net.Listen("unix", path)
+		require.NoError(t, err)
+		defer l.Close() //nolint:errcheck // closing the listener in a test
+		go func() {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					defer conn.Close() //nolint:errcheck // closing the connection in a test
+					buf := make([]byte, 4)
+					if _, err := io.ReadFull(conn, buf); err != nil {
+						return
+					}
+					_, _ = conn.Write(append([]byte("pong:"), buf...))
+				}()
+			}
+		}()
+
+		got, err := io.ReadAll(client)
+		require.NoError(t, err)
+		assert.Equal(t, "pong:ping", string(got))
+		require.NoError(t, client.Close())
+		require.NoError(t, <-served)
+		_, err = os.Stat(path)
+		assert.NoError(t, err, "the socket that replaced the proxy should be kept")
+	})
+
+	t.Run("closes the held connections when nothing replaces the removed socket", func(t *testing.T) {
+		t.Parallel()
+
+		path := socketPath(t)
+		p := newTestProxy(t, path)
+		served := serve(context.Background(), t, p)
+
+		client, err := net.Dial("unix", path)
+		require.NoError(t, err)
+		defer client.Close() //nolint:errcheck // closing the connection in a test
+		waitForHeld(t, p, 2)
+		require.NoError(t, os.Remove(path))
+
+		got, err := io.ReadAll(client)
+		require.NoError(t, err)
+		assert.Empty(t, got)
+		require.NoError(t, <-served)
+	})
+
+	t.Run("removes the socket when it's cancelled", func(t *testing.T) {
+		t.Parallel()
+
+		path := socketPath(t)
+		p := newTestProxy(t, path)
+		ctx, cancel := context.WithCancel(context.Background())
+		served := serve(ctx, t, p)
+
+		client, err := net.Dial("unix", path)
+		require.NoError(t, err)
+		defer client.Close() //nolint:errcheck // closing the connection in a test
+		waitForHeld(t, p, 2)
+		cancel()
+
+		assert.ErrorIs(t, <-served, context.Canceled)
+		got, err := io.ReadAll(client)
+		require.NoError(t, err)
+		assert.Empty(t, got)
+		_, err = os.Stat(path)
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+
+	t.Run("replaces a stale socket", func(t *testing.T) {
+		t.Parallel()
+
+		path := socketPath(t)
+		l, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+		require.NoError(t, err)
+		l.SetUnlinkOnClose(false)
+		require.NoError(t, l.Close())
+
+		p := newTestProxy(t, path)
+		ctx, cancel := context.WithCancel(context.Background())
+		served := serve(ctx, t, p)
+		cancel()
+		assert.ErrorIs(t, <-served, context.Canceled)
+	})
+
+	t.Run("refuses a socket something still serves", func(t *testing.T) {
+		t.Parallel()
+
+		path := socketPath(t)
+		l, err := net.Listen("unix", path)
+		require.NoError(t, err)
+		defer l.Close() //nolint:errcheck // closing the listener in a test
+
+		p := newTestProxy(t, path)
+		err = p.Serve(context.Background())
+		assert.ErrorContains(t, err, "is already served")
+	})
+}
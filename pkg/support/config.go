@@ -6,8 +6,9 @@ package support
 import fpath "github.com/runfinch/finch/pkg/path"
 
 type bundleConfig struct {
-	finch   fpath.Finch
-	rootDir string
+	finch    fpath.Finch
+	rootDir  string
+	instance string
 }
 
 // BundleConfig provides methods that configure what is included in a support bundle.
@@ -16,12 +17,19 @@ type bundleConfig struct {
 type BundleConfig interface {
 	LogFiles() []string
 	ConfigFiles() []string
+	// InstanceName returns the name of the instance whose guest files are included.
+	InstanceName() string
 }
 
 // NewBundleConfig creates a new bundleConfig.
-func NewBundleConfig(finch fpath.Finch, rootDir string) BundleConfig {
+func NewBundleConfig(finch fpath.Finch, rootDir, instance string) BundleConfig {
 	return &bundleConfig{
-		finch:   finch,
-		rootDir: rootDir,
+		finch:    finch,
+		rootDir:  rootDir,
+		instance: instance,
 	}
 }
+
+func (bc *bundleConfig) InstanceName() string {
+	return bc.instance
+}
@@ -13,19 +13,19 @@ import (
 
 func (bc *bundleConfig) LogFiles() []string {
 	files := []string{
-		filepath.Join(bc.finch.LimaInstancePath(), "ha.stderr.log"),
-		filepath.Join(bc.finch.LimaInstancePath(), "ha.stdout.log"),
+		filepath.Join(bc.finch.LimaInstancePathOf(bc.instance), "ha.stderr.log"),
+		filepath.Join(bc.finch.LimaInstancePathOf(bc.instance), "ha.stdout.log"),
 	}
 
 	if runtime.GOOS != "windows" {
-		files = append(files, filepath.Join(bc.finch.LimaInstancePath(), "serial.log"))
+		files = append(files, filepath.Join(bc.finch.LimaInstancePathOf(bc.instance), "serial.log"))
 	}
 	return files
 }
 
 func (bc *bundleConfig) ConfigFiles() []string {
 	return []string{
-		path.Join(bc.finch.LimaInstancePath(), "lima.yaml"),
+		path.Join(bc.finch.LimaInstancePathOf(bc.instance), "lima.yaml"),
 		bc.finch.ConfigFilePath(bc.rootDir),
 	}
 }
@@ -18,7 +18,7 @@ func TestNewBundleConfig(t *testing.T) {
 
 	finch := fpath.Finch("/mockfinch")
 	homeDir := "/mockhome"
-	NewBundleConfig(finch, homeDir)
+	NewBundleConfig(finch, homeDir, "finch")
 }
 
 func TestBundleConfig_LogFiles(t *testing.T) {
@@ -34,7 +34,7 @@ func TestBundleConfig_LogFiles(t *testing.T) {
 		homeDir = "/mockhome"
 	}
 
-	config := NewBundleConfig(finch, homeDir)
+	config := NewBundleConfig(finch, homeDir, "finch")
 
 	for _, fileName := range config.LogFiles() {
 		assert.True(t, filepath.IsAbs(fileName))
@@ -53,7 +53,7 @@ func TestBundleConfig_ConfigFiles(t *testing.T) {
 		finch = fpath.Finch("/mockfinch")
 		homeDir = "/mockhome"
 	}
-	config := NewBundleConfig(finch, homeDir)
+	config := NewBundleConfig(finch, homeDir, "finch")
 
 	for _, fileName := range config.ConfigFiles() {
 		assert.True(t, filepath.IsAbs(fileName))
@@ -244,7 +244,7 @@ func (bb *bundleBuilder) streamFileFromVM(writer *zip.Writer, filename, prefix s
 	errBuf := new(bytes.Buffer)
 
 	_, filePathInVM, _ := strings.Cut(filename, ":")
-	cmd := bb.ncc.CreateWithoutStdio("shell", "finch", "sudo", "cat", filePathInVM)
+	cmd := bb.ncc.CreateWithoutStdio("shell", bb.config.InstanceName(), "sudo", "cat", filePathInVM)
 	cmd.SetStdout(pipeWriter)
 	cmd.SetStderr(errBuf)
 
@@ -32,7 +32,7 @@ func TestSupport_NewBundleBuilder(t *testing.T) {
 	lima := mocks.NewMockLimaWrapper(ctrl)
 	systemDeps := mocks.NewSupportSystemDeps(ctrl)
 
-	config := NewBundleConfig(finch, "mockhome")
+	config := NewBundleConfig(finch, "mockhome", "finch")
 	NewBundleBuilder(logger, fs, config, finch, ecc, ncc, lima, systemDeps)
 }
 
@@ -373,6 +373,7 @@ func TestSupportBundleBuilder_GenerateSupportBundle(t *testing.T) {
 
 				var catWriter io.Writer
 				waitChan := make(chan int)
+				config.EXPECT().InstanceName().Return("finch")
 				ncc.EXPECT().CreateWithoutStdio("shell", "finch", "sudo", "cat", "extra1").Return(cmd)
 				cmd.EXPECT().SetStdout(gomock.Any()).Do(func(writer io.Writer) {
 					catWriter = writer
@@ -0,0 +1,273 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+// Package telemetry collects anonymized lifecycle events of the virtual machine, e.g. whether its stops succeed,
+// for the users who opted in with telemetry.enabled, and sends them in batches.
+// The events carry no personal data: no instance names, paths, user names, error messages or exact durations.
+package telemetry
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/runfinch/finch/pkg/flog"
+	"github.com/runfinch/finch/pkg/lima"
+)
+
+// The types of the events.
+const (
+	EventStop = "stop"
+)
+
+// The results of the events.
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+const (
+	// BatchSize is the number of events that are sent together.
+	BatchSize = 20
+	// MaxPending is the number of events kept at most until they are sent, the oldest ones are dropped beyond it.
+	// It keeps the batch from growing forever while no endpoint is configured, or while it can't be reached.
+	MaxPending = 5 * BatchSize
+	// sendTimeout bounds how long the stop that fills a batch waits for it to be sent.
+	sendTimeout = 5 * time.Second
+)
+
+// Event is an anonymized lifecycle event of the virtual machine.
+type Event struct {
+	Type   string `json:"type"`
+	Result string `json:"result"`
+	// DurationBucket is the range the duration of the operation falls in, see DurationBucket.
+	DurationBucket string `json:"durationBucket"`
+	// Forced is true for the stops forced with --force. It's always false for the failed stops.
+	Forced bool   `json:"forced"`
+	OS     string `json:"os"`
+	// Driver is the type of the virtual machine, e.g. vz or qemu.
+	Driver string `json:"driver,omitempty"`
+}
+
+// Payload is the document a batch of events is sent as.
+type Payload struct {
+	Events []Event `json:"events"`
+}
+
+// DurationBucket returns the range d falls in, which is reported instead of the exact duration.
+func DurationBucket(d time.Duration) string {
+	switch {
+	case d < 10*time.Second:
+		return "<10s"
+	case d < 30*time.Second:
+		return "10s-30s"
+	case d < time.Minute:
+		return "30s-1m"
+	case d < 5*time.Minute:
+		return "1m-5m"
+	default:
+		return ">=5m"
+	}
+}
+
+// Recorder records the lifecycle events of the instances to the pending batch at path, and sends the batch
+// to endpoint once it's full. The last MaxPending events are kept until an endpoint is configured, for them to be inspected.
+// Telemetry never gets in the way of the operations it observes: its failures are only logged at debug level.
+type Recorder struct {
+	lima.NopLifecycleListener
+
+	fs       afero.Fs
+	path     string
+	endpoint string
+	driver   string
+	logger   flog.Logger
+	client   *http.Client
+
+	mu sync.Mutex
+	// begun holds when the ongoing stops began, by instance. The names of the instances are never recorded.
+	begun map[string]time.Time
+}
+
+var _ lima.LifecycleListener = (*Recorder)(nil)
+
+// NewRecorder is a constructor for Recorder.
+func NewRecorder(afs afero.Fs, path, endpoint, driver string, logger flog.Logger) *Recorder {
+	return &Recorder{
+		fs:       afs,
+		path:     path,
+		endpoint: endpoint,
+		driver:   driver,
+		logger:   logger,
+		client:   http.DefaultClient,
+		begun:    map[string]time.Time{},
+	}
+}
+
+// OnStopBegin notes when the stop began, to know how long it took if it fails.
+func (r *Recorder) OnStopBegin(instance string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.begun[instance] = time.Now()
+}
+
+// OnStopComplete records the successful stop, unless the instance was left running.
+func (r *Recorder) OnStopComplete(result lima.StopResult) {
+	r.forget(result.Instance)
+	if !result.Stopped {
+		return
+	}
+	r.record(Event{
+		Type:           EventStop,
+		Result:         ResultSuccess,
+		DurationBucket: DurationBucket(result.Duration),
+		Forced:         result.Forced,
+		OS:             runtime.GOOS,
+		Driver:         r.driver,
+	})
+}
+
+// OnStopError records the failed stop, without the error, which may hold paths or names.
+func (r *Recorder) OnStopError(instance string, _ error) {
+	r.record(Event{
+		Type:           EventStop,
+		Result:         ResultFailure,
+		DurationBucket: DurationBucket(time.Since(r.forget(instance))),
+		OS:             runtime.GOOS,
+		Driver:         r.driver,
+	})
+}
+
+// forget returns when the stop of instance began and forgets about it.
+func (r *Recorder) forget(instance string) time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	begun, ok := r.begun[instance]
+	delete(r.begun, instance)
+	if !ok {
+		return time.Now()
+	}
+	return begun
+}
+
+func (r *Recorder) record(e Event) {
+	if err := appendEvent(r.fs, r.path, e); err != nil {
+		r.logger.Debugf("Could not record the telemetry event: %v", err)
+		return
+	}
+	events, err := Pending(r.fs, r.path)
+	if err != nil {
+		r.logger.Debugf("Could not read the pending telemetry events: %v", err)
+		return
+	}
+	if len(events) > MaxPending {
+		events = events[len(events)-MaxPending:]
+		if err := writeEvents(r.fs, r.path, events); err != nil {
+			r.logger.Debugf("Could not drop the oldest telemetry events: %v", err)
+		}
+	}
+	if r.endpoint == "" || len(events) < BatchSize {
+		return
+	}
+	if err := r.send(events); err != nil {
+		r.logger.Debugf("Could not send the telemetry events, they will be sent with the next batch: %v", err)
+		return
+	}
+	if err := r.fs.Remove(r.path); err != nil {
+		r.logger.Debugf("Could not clear the sent telemetry events: %v", err)
+	}
+}
+
+func (r *Recorder) send(events []Event) error {
+	b, err := json.Marshal(Payload{Events: events})
+	if err != nil {
+		return fmt.Errorf("failed to marshal the telemetry events: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.endpoint, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() //nolint:errcheck // nothing is read from the body
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+	return nil
+}
+
+// appendEvent appends the event to the pending batch at path, which holds a JSON event per line.
+func appendEvent(afs afero.Fs, path string, e Event) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to marshal the telemetry event: %w", err)
+	}
+	if err := afs.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create the directory of the telemetry events: %w", err)
+	}
+	f, err := afs.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open the telemetry events: %w", err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to write the telemetry event: %w", err)
+	}
+	return f.Close()
+}
+
+// writeEvents replaces the pending batch at path with events.
+func writeEvents(afs afero.Fs, path string, events []Event) error {
+	var b bytes.Buffer
+	for _, e := range events {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return fmt.Errorf("failed to marshal the telemetry event: %w", err)
+		}
+		b.Write(append(line, '\n'))
+	}
+	if err := afero.WriteFile(afs, path, b.Bytes(), 0o600); err != nil {
+		return fmt.Errorf("failed to write the telemetry events: %w", err)
+	}
+	return nil
+}
+
+// Pending returns the events of the batch at path that weren't sent yet, in the order they were recorded.
+func Pending(afs afero.Fs, path string) ([]Event, error) {
+	b, err := afero.ReadFile(afs, path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read the telemetry events: %w", err)
+	}
+	var events []Event
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("failed to parse the telemetry event on line %d: %w", line, err)
+		}
+		events = append(events, e)
+	}
+	return events, scanner.Err()
+}
@@ -0,0 +1,132 @@
+// Copyright Amazon.com, Inc. or its affiliates. All Rights Reserved.
+// SPDX-License-Identifier: Apache-2.0
+
+package telemetry_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+
+	"github.com/runfinch/finch/pkg/lima"
+	"github.com/runfinch/finch/pkg/mocks"
+	"github.com/runfinch/finch/pkg/telemetry"
+)
+
+const mockBatchPath = "/home/.finch/telemetry.jsonl"
+
+func TestDurationBucket(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		duration time.Duration
+		want     string
+	}{
+		{duration: 3 * time.Second, want: "<10s"},
+		{duration: 10 * time.Second, want: "10s-30s"},
+		{duration: 45 * time.Second, want: "30s-1m"},
+		{duration: 2 * time.Minute, want: "1m-5m"},
+		{duration: time.Hour, want: ">=5m"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.want, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.want, telemetry.DurationBucket(tc.duration))
+		})
+	}
+}
+
+func TestRecorder_recordsAnonymizedStops(t *testing.T) {
+	t.Parallel()
+
+	fs := afero.NewMemMapFs()
+	r := telemetry.NewRecorder(fs, mockBatchPath, "", "vz", nil)
+	r.OnStopBegin("alice-finch")
+	r.OnStopComplete(lima.StopResult{Instance: "alice-finch", Stopped: true, Forced: true, Duration: 12 * time.Second})
+	r.OnStopBegin("alice-finch")
+	r.OnStopError("alice-finch", errors.New("failed to stop /Users/alice/.finch"))
+	// A stop that wasn't confirmed left the instance running, there's nothing to record.
+	r.OnStopComplete(lima.StopResult{Instance: "alice-finch", Stopped: false})
+
+	events, err := telemetry.Pending(fs, mockBatchPath)
+	require.NoError(t, err)
+	assert.Equal(t, []telemetry.Event{
+		{Type: telemetry.EventStop, Result: telemetry.ResultSuccess, DurationBucket: "10s-30s", Forced: true, OS: runtime.GOOS, Driver: "vz"},
+		{Type: telemetry.EventStop, Result: telemetry.ResultFailure, DurationBucket: "<10s", OS: runtime.GOOS, Driver: "vz"},
+	}, events)
+}
+
+func TestRecorder_sendsFullBatches(t *testing.T) {
+	t.Parallel()
+
+	var received []telemetry.Payload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p telemetry.Payload
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&p))
+		received = append(received, p)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	fs := afero.NewMemMapFs()
+	r := telemetry.NewRecorder(fs, mockBatchPath, srv.URL, "vz", nil)
+	for range telemetry.BatchSize + 1 {
+		r.OnStopComplete(lima.StopResult{Instance: "finch", Stopped: true, Duration: time.Second})
+	}
+
+	require.Len(t, received, 1)
+	assert.Len(t, received[0].Events, telemetry.BatchSize)
+	events, err := telemetry.Pending(fs, mockBatchPath)
+	require.NoError(t, err)
+	assert.Len(t, events, 1)
+}
+
+func TestRecorder_keepsTheBatchIfItCantBeSent(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	ctrl := gomock.NewController(t)
+	logger := mocks.NewLogger(ctrl)
+	logger.EXPECT().Debugf("Could not send the telemetry events, they will be sent with the next batch: %v", gomock.Any())
+
+	fs := afero.NewMemMapFs()
+	r := telemetry.NewRecorder(fs, mockBatchPath, srv.URL, "vz", logger)
+	for range telemetry.BatchSize {
+		r.OnStopComplete(lima.StopResult{Instance: "finch", Stopped: true, Duration: time.Second})
+	}
+
+	events, err := telemetry.Pending(fs, mockBatchPath)
+	require.NoError(t, err)
+	assert.Len(t, events, telemetry.BatchSize)
+}
+
+func TestRecorder_keepsTheLastEventsWithoutAnEndpoint(t *testing.T) {
+	t.Parallel()
+
+	fs := afero.NewMemMapFs()
+	r := telemetry.NewRecorder(fs, mockBatchPath, "", "vz", nil)
+	for range telemetry.MaxPending {
+		r.OnStopComplete(lima.StopResult{Instance: "finch", Stopped: true, Duration: time.Second})
+	}
+	r.OnStopComplete(lima.StopResult{Instance: "finch", Stopped: true, Forced: true, Duration: time.Second})
+
+	events, err := telemetry.Pending(fs, mockBatchPath)
+	require.NoError(t, err)
+	require.Len(t, events, telemetry.MaxPending)
+	assert.True(t, events[len(events)-1].Forced)
+}